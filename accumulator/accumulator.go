@@ -0,0 +1,191 @@
+package accumulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Kinds of accumulators; the kind selects the merge function on workers, which cannot
+// receive Go closures
+const (
+	KindLong       = "long"
+	KindFloat      = "float"
+	KindCollection = "collection"
+	KindCustom     = "custom"
+)
+
+var nextID int64
+
+// accumulable is the type-erased view of an Accumulator used by the registry and Updates
+type accumulable interface {
+	ID() int64
+	Name() string
+	combine(a, b interface{}) (interface{}, bool)
+	mergeValue(v interface{})
+	mergeJSON(raw json.RawMessage) error
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[int64]accumulable)
+)
+
+// Accumulator is a variable tasks can only add to. Additions made inside a task are
+// buffered per attempt and merged into the driver's value once the task succeeds, so
+// retried or speculative attempts never count twice.
+type Accumulator[T any] struct {
+	id    int64
+	name  string
+	kind  string
+	zero  T
+	merge func(a, b T) T
+
+	mu    sync.Mutex
+	value T
+}
+
+// New creates an accumulator with a custom merge function, which must be associative.
+// On remote workers each added value is shipped to the driver and merged there.
+func New[T any](name string, zero T, merge func(a, b T) T) *Accumulator[T] {
+	return register(&Accumulator[T]{name: name, kind: KindCustom, zero: zero, merge: merge, value: zero})
+}
+
+// Long creates a counter
+func Long(name string) *Accumulator[int64] {
+	return register(&Accumulator[int64]{name: name, kind: KindLong, merge: builtinMerge[int64](KindLong)})
+}
+
+// Float creates a floating point sum
+func Float(name string) *Accumulator[float64] {
+	return register(&Accumulator[float64]{name: name, kind: KindFloat, merge: builtinMerge[float64](KindFloat)})
+}
+
+// Collection creates an accumulator gathering elements into a slice. Elements added
+// by different tasks arrive in no particular order.
+func Collection[E any](name string) *Accumulator[[]E] {
+	return register(&Accumulator[[]E]{name: name, kind: KindCollection, merge: builtinMerge[[]E](KindCollection)})
+}
+
+func register[T any](a *Accumulator[T]) *Accumulator[T] {
+	a.id = atomic.AddInt64(&nextID, 1)
+	registryMu.Lock()
+	registry[a.id] = a
+	registryMu.Unlock()
+	return a
+}
+
+// Unregister stops an accumulator from receiving task updates
+func Unregister(id int64) {
+	registryMu.Lock()
+	delete(registry, id)
+	registryMu.Unlock()
+}
+
+// builtinMerge returns the merge function of a built-in kind, or nil for custom ones
+func builtinMerge[T any](kind string) func(a, b T) T {
+	switch kind {
+	case KindLong, KindFloat:
+		return func(a, b T) T {
+			switch x := any(a).(type) {
+			case int64:
+				return any(x + any(b).(int64)).(T)
+			case float64:
+				return any(x + any(b).(float64)).(T)
+			}
+			panic(fmt.Sprintf("accumulator: %s merge on %T", kind, a))
+		}
+	case KindCollection:
+		return func(a, b T) T {
+			return reflect.AppendSlice(reflect.ValueOf(a), reflect.ValueOf(b)).Interface().(T)
+		}
+	}
+	return nil
+}
+
+// ID returns the identifier task updates refer to
+func (a *Accumulator[T]) ID() int64 { return a.id }
+
+// Name returns the name given at creation
+func (a *Accumulator[T]) Name() string { return a.name }
+
+// Add adds v. Inside a task, ctx carries the attempt's Updates and v is buffered there;
+// anywhere else v is merged into the value immediately.
+func (a *Accumulator[T]) Add(ctx context.Context, v T) {
+	if u := updatesFrom(ctx); u != nil {
+		u.add(a, v)
+		return
+	}
+	a.mergeValue(v)
+}
+
+// Value returns the merged value; it is only meaningful on the driver
+func (a *Accumulator[T]) Value() T {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.value
+}
+
+// Reset sets the value back to zero
+func (a *Accumulator[T]) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.value = a.zero
+}
+
+func (a *Accumulator[T]) String() string {
+	return fmt.Sprintf("Accumulator(%s: %v)", a.name, a.Value())
+}
+
+func (a *Accumulator[T]) combine(x, y interface{}) (interface{}, bool) {
+	if a.merge == nil {
+		return nil, false
+	}
+	return a.merge(x.(T), y.(T)), true
+}
+
+func (a *Accumulator[T]) mergeValue(v interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.merge == nil {
+		return
+	}
+	a.value = a.merge(a.value, v.(T))
+}
+
+func (a *Accumulator[T]) mergeJSON(raw json.RawMessage) error {
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return fmt.Errorf("accumulator %s: %w", a.name, err)
+	}
+	a.mergeValue(v)
+	return nil
+}
+
+// reference is the wire form of a handle
+type reference struct {
+	ID   int64  `json:"$accumulator"`
+	Kind string `json:"kind"`
+}
+
+func (a *Accumulator[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(reference{ID: a.id, Kind: a.kind})
+}
+
+// UnmarshalJSON restores a handle on a worker. Built-in kinds get their merge
+// function back; custom ones buffer every added value for the driver to merge.
+func (a *Accumulator[T]) UnmarshalJSON(data []byte) error {
+	var ref reference
+	if err := json.Unmarshal(data, &ref); err != nil {
+		return err
+	}
+	if ref.ID == 0 {
+		return errors.New("accumulator reference without an id")
+	}
+	*a = Accumulator[T]{id: ref.ID, kind: ref.Kind, merge: builtinMerge[T](ref.Kind)}
+	return nil
+}
@@ -0,0 +1,146 @@
+package accumulator
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestAccumulator_AddOutsideTask(t *testing.T) {
+	count := Long("count")
+	sum := Float("sum")
+	for i := 1; i <= 4; i++ {
+		count.Add(context.Background(), 1)
+		sum.Add(context.Background(), float64(i)/2)
+	}
+	if count.Value() != 4 {
+		t.Errorf("Long accumulator: got %d, want 4", count.Value())
+	}
+	if sum.Value() != 5 {
+		t.Errorf("Float accumulator: got %v, want 5", sum.Value())
+	}
+
+	count.Reset()
+	if count.Value() != 0 {
+		t.Errorf("Reset did not clear the value: got %d", count.Value())
+	}
+}
+
+func TestAccumulator_CollectionAndCustomMerge(t *testing.T) {
+	bad := Collection[string]("bad")
+	bad.Add(context.Background(), []string{"x"})
+	bad.Add(context.Background(), []string{"y", "z"})
+	if got := bad.Value(); !reflect.DeepEqual(got, []string{"x", "y", "z"}) {
+		t.Errorf("Collection accumulator: got %v", got)
+	}
+
+	longest := New("longest", "", func(a, b string) string {
+		if len(b) > len(a) {
+			return b
+		}
+		return a
+	})
+	for _, s := range []string{"ab", "abcd", "abc"} {
+		longest.Add(context.Background(), s)
+	}
+	if longest.Value() != "abcd" {
+		t.Errorf("Custom accumulator: got %q, want %q", longest.Value(), "abcd")
+	}
+}
+
+func TestAccumulator_UpdatesBufferUntilMerged(t *testing.T) {
+	count := Long("count")
+	updates := NewUpdates()
+	ctx := WithUpdates(context.Background(), updates)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			count.Add(ctx, 1)
+		}()
+	}
+	wg.Wait()
+	if count.Value() != 0 {
+		t.Fatalf("Task updates reached the value before the merge: got %d", count.Value())
+	}
+
+	updates.Merge()
+	if count.Value() != 100 {
+		t.Errorf("Merged updates: got %d, want 100", count.Value())
+	}
+}
+
+func TestAccumulator_RemoteUpdatesApplyOnDriver(t *testing.T) {
+	count := Long("count")
+	seen := Collection[float64]("seen")
+	maxLen := New("max", 0, func(a, b int) int {
+		if b > a {
+			return b
+		}
+		return a
+	})
+
+	// A worker decodes the handles from the operation arguments
+	args, err := json.Marshal(map[string]interface{}{"count": count, "seen": seen, "max": maxLen})
+	if err != nil {
+		t.Fatalf("Marshal failed with error: %v", err)
+	}
+	var remote struct {
+		Count *Accumulator[int64]
+		Seen  *Accumulator[[]float64]
+		Max   *Accumulator[int]
+	}
+	if err := json.Unmarshal(args, &remote); err != nil {
+		t.Fatalf("Unmarshal failed with error: %v", err)
+	}
+
+	updates := NewUpdates()
+	ctx := WithUpdates(context.Background(), updates)
+	for _, v := range []float64{1, 2, 3} {
+		remote.Count.Add(ctx, 1)
+		remote.Seen.Add(ctx, []float64{v})
+		remote.Max.Add(ctx, int(v))
+	}
+	exported, err := updates.Export()
+	if err != nil {
+		t.Fatalf("Export failed with error: %v", err)
+	}
+	if len(exported[0].Values) != 1 {
+		t.Errorf("Built-in kinds should be combined on the worker, got %d values", len(exported[0].Values))
+	}
+	if len(exported[2].Values) != 3 {
+		t.Errorf("Custom kinds should be shipped value by value, got %d values", len(exported[2].Values))
+	}
+
+	if err := Apply(exported); err != nil {
+		t.Fatalf("Apply failed with error: %v", err)
+	}
+	if count.Value() != 3 {
+		t.Errorf("Remote count: got %d, want 3", count.Value())
+	}
+	got := seen.Value()
+	sort.Float64s(got)
+	if !reflect.DeepEqual(got, []float64{1, 2, 3}) {
+		t.Errorf("Remote collection: got %v", got)
+	}
+	if maxLen.Value() != 3 {
+		t.Errorf("Remote custom merge: got %d, want 3", maxLen.Value())
+	}
+}
+
+func TestAccumulator_ApplySkipsUnregistered(t *testing.T) {
+	count := Long("count")
+	Unregister(count.ID())
+	raw, _ := json.Marshal(int64(5))
+	if err := Apply([]Update{{ID: count.ID(), Values: []json.RawMessage{raw}}}); err != nil {
+		t.Fatalf("Apply failed with error: %v", err)
+	}
+	if count.Value() != 0 {
+		t.Errorf("Unregistered accumulator received updates: got %d", count.Value())
+	}
+}
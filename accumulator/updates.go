@@ -0,0 +1,117 @@
+package accumulator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Updates buffers the additions made by one task attempt
+type Updates struct {
+	mu      sync.Mutex
+	order   []int64
+	entries map[int64]*pending
+}
+
+// pending holds the buffered values of one accumulator; values that can be merged
+// locally are combined into a single element
+type pending struct {
+	acc    accumulable
+	values []interface{}
+}
+
+// Update is the serialized form of an attempt's additions to one accumulator
+type Update struct {
+	ID     int64             `json:"id"`
+	Values []json.RawMessage `json:"values"`
+}
+
+type updatesKey struct{}
+
+// NewUpdates creates an empty buffer for a task attempt
+func NewUpdates() *Updates {
+	return &Updates{entries: make(map[int64]*pending)}
+}
+
+// WithUpdates returns a context whose accumulator additions go to u
+func WithUpdates(ctx context.Context, u *Updates) context.Context {
+	return context.WithValue(ctx, updatesKey{}, u)
+}
+
+func updatesFrom(ctx context.Context) *Updates {
+	if ctx == nil {
+		return nil
+	}
+	u, _ := ctx.Value(updatesKey{}).(*Updates)
+	return u
+}
+
+func (u *Updates) add(acc accumulable, v interface{}) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	p, ok := u.entries[acc.ID()]
+	if !ok {
+		p = &pending{acc: acc}
+		u.entries[acc.ID()] = p
+		u.order = append(u.order, acc.ID())
+	}
+	if len(p.values) == 1 {
+		if merged, ok := acc.combine(p.values[0], v); ok {
+			p.values[0] = merged
+			return
+		}
+	}
+	p.values = append(p.values, v)
+}
+
+// Merge applies the buffered additions to accumulators of this process. The scheduler
+// calls it once for the attempt that won its task.
+func (u *Updates) Merge() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, id := range u.order {
+		p := u.entries[id]
+		for _, v := range p.values {
+			p.acc.mergeValue(v)
+		}
+	}
+}
+
+// Export serializes the buffered additions to send them to the driver
+func (u *Updates) Export() ([]Update, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	out := make([]Update, 0, len(u.order))
+	for _, id := range u.order {
+		update := Update{ID: id}
+		for _, v := range u.entries[id].values {
+			raw, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("accumulator %d: %w", id, err)
+			}
+			update.Values = append(update.Values, raw)
+		}
+		out = append(out, update)
+	}
+	return out, nil
+}
+
+// Apply merges serialized updates of a successful remote task into the registered
+// accumulators. Updates for unknown ids, e.g. of unregistered accumulators, are skipped.
+func Apply(updates []Update) error {
+	for _, update := range updates {
+		registryMu.RLock()
+		acc, ok := registry[update.ID]
+		registryMu.RUnlock()
+		if !ok {
+			continue
+		}
+		for _, raw := range update.Values {
+			if err := acc.mergeJSON(raw); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
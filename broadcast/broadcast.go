@@ -0,0 +1,303 @@
+package broadcast
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bajor/spark-go-core/codec"
+	"github.com/bajor/spark-go-core/storage"
+)
+
+// DefaultChunkSize is the size of the pieces a broadcast value is split into
+const DefaultChunkSize = 4 << 20
+
+// ErrDestroyed is returned when reading a broadcast after Destroy
+var ErrDestroyed = errors.New("broadcast destroyed")
+
+// ErrUnavailable is returned when a broadcast was never delivered to this process
+var ErrUnavailable = errors.New("broadcast not available")
+
+var nextID int64
+
+// values holds the broadcast values readable in this process, on the driver as soon as
+// they are created and on workers once all pieces arrived
+var (
+	valuesMu sync.RWMutex
+	values   = make(map[int64]interface{})
+)
+
+// Install makes a value readable through handles with the given id. An existing value
+// is kept, so the driver's original is never replaced by a decoded copy.
+func Install(id int64, value interface{}) {
+	valuesMu.Lock()
+	defer valuesMu.Unlock()
+	if _, ok := values[id]; !ok {
+		values[id] = value
+	}
+}
+
+// Uninstall forgets a value once its broadcast is destroyed
+func Uninstall(id int64) {
+	valuesMu.Lock()
+	defer valuesMu.Unlock()
+	delete(values, id)
+}
+
+// Installed reports whether a broadcast value is readable in this process
+func Installed(id int64) bool {
+	valuesMu.RLock()
+	defer valuesMu.RUnlock()
+	_, ok := values[id]
+	return ok
+}
+
+// Broadcast is a read-only value shared with every task. Handles serialize to their
+// id only, so they can be captured by closures and passed as arguments of registered
+// operations; the value itself reaches each worker once.
+type Broadcast struct {
+	id      int64
+	manager *Manager
+}
+
+// ID returns the identifier the broadcast is shipped under
+func (b *Broadcast) ID() int64 {
+	return b.id
+}
+
+// Value returns the broadcast value
+func (b *Broadcast) Value() (interface{}, error) {
+	valuesMu.RLock()
+	v, ok := values[b.id]
+	valuesMu.RUnlock()
+	if ok {
+		return v, nil
+	}
+	if b.manager != nil && b.manager.destroyed(b.id) {
+		return nil, fmt.Errorf("broadcast %d: %w", b.id, ErrDestroyed)
+	}
+	return nil, fmt.Errorf("broadcast %d: %w", b.id, ErrUnavailable)
+}
+
+// Destroy releases the value and its pieces everywhere. It can only be called on the
+// handle returned by Manager.New.
+func (b *Broadcast) Destroy() error {
+	if b.manager == nil {
+		return fmt.Errorf("broadcast %d can only be destroyed where it was created", b.id)
+	}
+	return b.manager.destroy(b.id)
+}
+
+func (b *Broadcast) String() string {
+	return fmt.Sprintf("Broadcast(%d)", b.id)
+}
+
+// reference is the wire form of a handle
+type reference struct {
+	ID int64 `json:"$broadcast"`
+}
+
+func (b *Broadcast) MarshalJSON() ([]byte, error) {
+	return json.Marshal(reference{ID: b.id})
+}
+
+func (b *Broadcast) UnmarshalJSON(data []byte) error {
+	var ref reference
+	if err := json.Unmarshal(data, &ref); err != nil {
+		return err
+	}
+	if ref.ID == 0 {
+		return errors.New("broadcast reference without an id")
+	}
+	*b = Broadcast{id: ref.ID}
+	return nil
+}
+
+// References returns the ids of the broadcasts referenced in serialized arguments
+func References(args json.RawMessage) []int64 {
+	if !bytes.Contains(args, []byte(`"$broadcast"`)) {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(args, &v); err != nil {
+		return nil
+	}
+	var ids []int64
+	var walk func(interface{})
+	walk = func(v interface{}) {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			if id, ok := x["$broadcast"].(float64); ok && len(x) == 1 {
+				ids = append(ids, int64(id))
+				return
+			}
+			for _, item := range x {
+				walk(item)
+			}
+		case []interface{}:
+			for _, item := range x {
+				walk(item)
+			}
+		}
+	}
+	walk(v)
+	return ids
+}
+
+// Config configures a Manager
+type Config struct {
+	// Codec names the codec values are serialized with, defaults to codec.Default
+	Codec string
+	// ChunkSize is the maximum size of a piece, defaults to DefaultChunkSize
+	ChunkSize int
+}
+
+// Manager creates broadcasts and keeps their serialized pieces in a block manager
+// until they are destroyed
+type Manager struct {
+	store     *storage.BlockManager
+	codec     string
+	chunkSize int
+
+	mu        sync.Mutex
+	live      map[int64]int
+	dead      map[int64]bool
+	onDestroy []func(id int64)
+}
+
+// NewManager creates a Manager storing pieces in store
+func NewManager(store *storage.BlockManager, config Config) *Manager {
+	if config.Codec == "" {
+		config.Codec = codec.Default
+	}
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = DefaultChunkSize
+	}
+	return &Manager{
+		store:     store,
+		codec:     config.Codec,
+		chunkSize: config.ChunkSize,
+		live:      make(map[int64]int),
+		dead:      make(map[int64]bool),
+	}
+}
+
+// Codec returns the name of the codec pieces are encoded with
+func (m *Manager) Codec() string {
+	return m.codec
+}
+
+// New serializes value into pieces and returns a handle to it
+func (m *Manager) New(value interface{}) (*Broadcast, error) {
+	c, err := codec.Lookup(m.codec)
+	if err != nil {
+		return nil, fmt.Errorf("broadcast: %w", err)
+	}
+	data, err := codec.Marshal(c, []interface{}{value})
+	if err != nil {
+		return nil, fmt.Errorf("broadcast: %w", err)
+	}
+	id := atomic.AddInt64(&nextID, 1)
+	pieces := 0
+	for start := 0; start < len(data) || pieces == 0; start += m.chunkSize {
+		end := start + m.chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := m.store.Put(storage.BroadcastPieceID(id, pieces), data[start:end]); err != nil {
+			m.store.RemovePrefix(storage.BroadcastPrefix(id))
+			return nil, fmt.Errorf("broadcast: %w", err)
+		}
+		pieces++
+	}
+
+	m.mu.Lock()
+	m.live[id] = pieces
+	m.mu.Unlock()
+	Install(id, value)
+	return &Broadcast{id: id, manager: m}, nil
+}
+
+// Pieces returns the serialized pieces of a live broadcast in order
+func (m *Manager) Pieces(id int64) ([][]byte, error) {
+	m.mu.Lock()
+	n, ok := m.live[id]
+	dead := m.dead[id]
+	m.mu.Unlock()
+	if !ok {
+		if dead {
+			return nil, fmt.Errorf("broadcast %d: %w", id, ErrDestroyed)
+		}
+		return nil, fmt.Errorf("broadcast %d is unknown", id)
+	}
+	pieces := make([][]byte, n)
+	for i := range pieces {
+		piece, ok := m.store.Get(storage.BroadcastPieceID(id, i))
+		if !ok {
+			return nil, fmt.Errorf("broadcast %d: piece %d is missing", id, i)
+		}
+		pieces[i] = piece
+	}
+	return pieces, nil
+}
+
+// OnDestroy registers a function called after a broadcast is destroyed, used to
+// release copies held elsewhere
+func (m *Manager) OnDestroy(f func(id int64)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onDestroy = append(m.onDestroy, f)
+}
+
+// Close destroys every live broadcast
+func (m *Manager) Close() {
+	m.mu.Lock()
+	ids := make([]int64, 0, len(m.live))
+	for id := range m.live {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+	for _, id := range ids {
+		m.destroy(id)
+	}
+}
+
+func (m *Manager) destroyed(id int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dead[id]
+}
+
+func (m *Manager) destroy(id int64) error {
+	m.mu.Lock()
+	if _, ok := m.live[id]; !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("broadcast %d: %w", id, ErrDestroyed)
+	}
+	delete(m.live, id)
+	m.dead[id] = true
+	hooks := append([]func(int64){}, m.onDestroy...)
+	m.mu.Unlock()
+
+	m.store.RemovePrefix(storage.BroadcastPrefix(id))
+	Uninstall(id)
+	for _, f := range hooks {
+		f(id)
+	}
+	return nil
+}
+
+// Decode rebuilds a broadcast value from its pieces
+func Decode(c codec.Codec, pieces [][]byte) (interface{}, error) {
+	records, err := codec.Unmarshal(c, bytes.Join(pieces, nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(records) != 1 {
+		return nil, fmt.Errorf("broadcast holds %d values, want 1", len(records))
+	}
+	return records[0], nil
+}
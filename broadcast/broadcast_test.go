@@ -0,0 +1,106 @@
+package broadcast
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bajor/spark-go-core/codec"
+	"github.com/bajor/spark-go-core/storage"
+)
+
+func TestBroadcast_ValueAndDestroy(t *testing.T) {
+	store := storage.NewBlockManager()
+	m := NewManager(store, Config{ChunkSize: 8})
+	table := map[string]interface{}{"pl": "Poland", "de": "Germany"}
+
+	b, err := m.New(table)
+	if err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+	if v, err := b.Value(); err != nil || !reflect.DeepEqual(v, table) {
+		t.Errorf("Value failed: got %v, %v", v, err)
+	}
+
+	pieces, err := m.Pieces(b.ID())
+	if err != nil {
+		t.Fatalf("Pieces failed with error: %v", err)
+	}
+	if len(pieces) < 2 || len(store.IDs(storage.BroadcastPrefix(b.ID()))) != len(pieces) {
+		t.Errorf("Expected the value to be split into several stored pieces, got %d", len(pieces))
+	}
+	c, _ := codec.Lookup(m.Codec())
+	if v, err := Decode(c, pieces); err != nil || !reflect.DeepEqual(v, table) {
+		t.Errorf("Decode failed: got %v, %v", v, err)
+	}
+
+	var destroyed []int64
+	m.OnDestroy(func(id int64) { destroyed = append(destroyed, id) })
+	if err := b.Destroy(); err != nil {
+		t.Fatalf("Destroy failed with error: %v", err)
+	}
+	if _, err := b.Value(); !errors.Is(err, ErrDestroyed) {
+		t.Errorf("Expected ErrDestroyed after Destroy, got %v", err)
+	}
+	if store.Size() != 0 {
+		t.Errorf("Pieces not released: %v", store.IDs(""))
+	}
+	if !reflect.DeepEqual(destroyed, []int64{b.ID()}) {
+		t.Errorf("OnDestroy hooks not called: %v", destroyed)
+	}
+	if err := b.Destroy(); err == nil {
+		t.Errorf("Expected second Destroy to fail")
+	}
+}
+
+func TestBroadcast_HandleSerializesToID(t *testing.T) {
+	m := NewManager(storage.NewBlockManager(), Config{})
+	b, _ := m.New([]interface{}{1.0, 2.0})
+	defer b.Destroy()
+
+	args := struct {
+		Factor float64
+		Table  *Broadcast
+	}{Factor: 2, Table: b}
+	raw, err := json.Marshal(args)
+	if err != nil {
+		t.Fatalf("Marshal failed with error: %v", err)
+	}
+	if !strings.Contains(string(raw), `{"$broadcast":`) {
+		t.Errorf("Handle should serialize to its id, got %s", raw)
+	}
+	if refs := References(raw); !reflect.DeepEqual(refs, []int64{b.ID()}) {
+		t.Errorf("References failed: got %v", refs)
+	}
+
+	var decoded struct {
+		Factor float64
+		Table  *Broadcast
+	}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed with error: %v", err)
+	}
+	if v, err := decoded.Table.Value(); err != nil || !reflect.DeepEqual(v, []interface{}{1.0, 2.0}) {
+		t.Errorf("Decoded handle failed to read the value: %v, %v", v, err)
+	}
+	if err := decoded.Table.Destroy(); err == nil {
+		t.Errorf("Expected a decoded handle to refuse Destroy")
+	}
+}
+
+func TestBroadcast_UnavailableUntilInstalled(t *testing.T) {
+	var b Broadcast
+	if err := json.Unmarshal([]byte(`{"$broadcast": 987654}`), &b); err != nil {
+		t.Fatalf("Unmarshal failed with error: %v", err)
+	}
+	if _, err := b.Value(); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable, got %v", err)
+	}
+	Install(987654, "v")
+	defer Uninstall(987654)
+	if v, err := b.Value(); err != nil || v != "v" {
+		t.Errorf("Value after Install failed: got %v, %v", v, err)
+	}
+}
@@ -0,0 +1,82 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/bajor/spark-go-core/broadcast"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// Broadcast makes value readable by every task through the returned handle. The value
+// is serialized once and shipped in pieces to each worker before the first task that
+// references it; Destroy on the handle releases it on the driver and the workers.
+func (d *Driver) Broadcast(value interface{}) (*broadcast.Broadcast, error) {
+	return d.broadcasts.New(value)
+}
+
+// broadcastRefs collects the broadcasts referenced by the arguments of specs and
+// fails if one of them was destroyed
+func (d *Driver) broadcastRefs(specs ...[]types.OperationSpec) ([]int64, error) {
+	seen := make(map[int64]bool)
+	var refs []int64
+	for _, list := range specs {
+		for _, spec := range list {
+			for _, id := range broadcast.References(spec.Args) {
+				if seen[id] {
+					continue
+				}
+				if _, err := d.broadcasts.Pieces(id); err != nil {
+					return nil, fmt.Errorf("operation %q: %w", spec.Name, err)
+				}
+				seen[id] = true
+				refs = append(refs, id)
+			}
+		}
+	}
+	return refs, nil
+}
+
+// shipBroadcasts sends the pieces of every broadcast the worker has not received yet
+func (d *Driver) shipBroadcasts(w *workerConn, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	w.shipMu.Lock()
+	defer w.shipMu.Unlock()
+	for _, id := range ids {
+		if w.shipped[id] {
+			continue
+		}
+		pieces, err := d.broadcasts.Pieces(id)
+		if err != nil {
+			return err
+		}
+		for i, data := range pieces {
+			piece := &BroadcastPiece{ID: id, Index: i, Total: len(pieces), Codec: d.broadcasts.Codec(), Data: data}
+			if err := w.conn.send(Message{Type: MsgBroadcast, Broadcast: piece}); err != nil {
+				return fmt.Errorf("ship broadcast %d to worker %s: %v: %w", id, w.info.ID, err, ErrWorkerLost)
+			}
+			d.broadcastPieces.Add(1)
+		}
+		w.shipped[id] = true
+	}
+	return nil
+}
+
+// releaseBroadcast tells every worker to drop a destroyed broadcast
+func (d *Driver) releaseBroadcast(id int64) {
+	d.mu.Lock()
+	workers := make([]*workerConn, 0, len(d.workers))
+	for _, w := range d.workers {
+		workers = append(workers, w)
+	}
+	d.mu.Unlock()
+	for _, w := range workers {
+		w.shipMu.Lock()
+		if w.shipped[id] {
+			delete(w.shipped, id)
+			w.conn.send(Message{Type: MsgDestroy, BroadcastID: id})
+		}
+		w.shipMu.Unlock()
+	}
+}
@@ -0,0 +1,662 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bajor/spark-go-core/accumulator"
+	"github.com/bajor/spark-go-core/broadcast"
+	"github.com/bajor/spark-go-core/codec"
+	"github.com/bajor/spark-go-core/compression"
+	"github.com/bajor/spark-go-core/expr"
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/registry"
+	"github.com/bajor/spark-go-core/storage"
+	"github.com/bajor/spark-go-core/types"
+)
+
+var testLogger = log.New(io.Discard, "", 0)
+
+var testOperations = Operations{
+	"double": func(args json.RawMessage, data []interface{}) ([]interface{}, error) {
+		out := make([]interface{}, len(data))
+		for i, v := range data {
+			out[i] = v.(float64) * 2
+		}
+		return out, nil
+	},
+	"fail": func(args json.RawMessage, data []interface{}) ([]interface{}, error) {
+		return nil, fmt.Errorf("failing on purpose")
+	},
+}
+
+type countArgs struct {
+	Seen *accumulator.Accumulator[int64]
+}
+
+func init() {
+	registry.RegisterMapFunc("cluster.test.negate", func(args struct{}, in float64) (float64, error) {
+		return -in, nil
+	})
+	registry.RegisterMapFunc("cluster.test.mod", func(args struct{ N float64 }, in float64) (float64, error) {
+		return float64(int(in) % int(args.N)), nil
+	})
+	registry.RegisterMapFunc("cluster.test.lookup", func(args struct{ Table *broadcast.Broadcast }, in float64) (interface{}, error) {
+		table, err := args.Table.Value()
+		if err != nil {
+			return nil, err
+		}
+		return table.(map[string]interface{})[fmt.Sprint(in)], nil
+	})
+	registry.RegisterMapFuncContext("cluster.test.count", func(ctx context.Context, args countArgs, in float64) (float64, error) {
+		args.Seen.Add(ctx, 1)
+		return in, nil
+	})
+	registry.RegisterPartition("cluster.test.sum", func(args json.RawMessage) (func([]interface{}) ([]interface{}, error), error) {
+		return func(data []interface{}) ([]interface{}, error) {
+			sum := 0.0
+			for _, v := range data {
+				sum += v.(float64)
+			}
+			return []interface{}{sum}, nil
+		}, nil
+	})
+}
+
+// startRegistryWorker starts a worker that executes registered operations
+func startRegistryWorker(t *testing.T, d *Driver, id string, heartbeat time.Duration) context.CancelFunc {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	w := NewWorker(WorkerConfig{ID: id, DriverAddr: d.Addr(), Executor: registry.Executor{}, HeartbeatInterval: heartbeat, Logger: testLogger})
+	go w.Run(ctx)
+	t.Cleanup(cancel)
+	return cancel
+}
+
+func sortedFloats(data []interface{}) []float64 {
+	out := make([]float64, len(data))
+	for i, v := range data {
+		out[i] = v.(float64)
+	}
+	sort.Float64s(out)
+	return out
+}
+
+func startDriver(t *testing.T) *Driver {
+	t.Helper()
+	d := NewDriver(DriverConfig{Logger: testLogger})
+	if err := d.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen failed with error: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func startWorker(t *testing.T, d *Driver, id string, chunkSize int) context.CancelFunc {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	w := NewWorker(WorkerConfig{ID: id, DriverAddr: d.Addr(), Executor: testOperations, ChunkSize: chunkSize, Logger: testLogger})
+	go w.Run(ctx)
+	t.Cleanup(cancel)
+	return cancel
+}
+
+func waitForWorkers(t *testing.T, d *Driver, n int) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.WaitForWorkers(ctx, n); err != nil {
+		t.Fatalf("WaitForWorkers failed with error: %v", err)
+	}
+}
+
+func numbers(n int) []interface{} {
+	data := make([]interface{}, n)
+	for i := range data {
+		data[i] = float64(i + 1)
+	}
+	return data
+}
+
+func TestCluster_RunJobAcrossWorkers(t *testing.T) {
+	d := startDriver(t)
+	startWorker(t, d, "w1", 2)
+	startWorker(t, d, "w2", 2)
+	waitForWorkers(t, d, 2)
+
+	result, err := d.RunJob(context.Background(), numbers(6), 3, []types.OperationSpec{{Name: "double"}})
+	if err != nil {
+		t.Fatalf("RunJob failed with error: %v", err)
+	}
+
+	expected := []interface{}{2.0, 4.0, 6.0, 8.0, 10.0, 12.0}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("RunJob failed: got %v, want %v", result, expected)
+	}
+}
+
+func TestCluster_TaskErrorIsReported(t *testing.T) {
+	d := startDriver(t)
+	startWorker(t, d, "w1", 0)
+	waitForWorkers(t, d, 1)
+
+	if _, err := d.RunJob(context.Background(), numbers(3), 1, []types.OperationSpec{{Name: "fail"}}); err == nil {
+		t.Errorf("Expected failing operation to fail the job")
+	}
+	if _, err := d.RunJob(context.Background(), numbers(3), 1, []types.OperationSpec{{Name: "missing"}}); err == nil {
+		t.Errorf("Expected unknown operation to fail the job")
+	}
+}
+
+func TestCluster_MembershipTracksDisconnects(t *testing.T) {
+	d := startDriver(t)
+	startWorker(t, d, "w1", 0)
+	stop := startWorker(t, d, "w2", 0)
+	waitForWorkers(t, d, 2)
+
+	stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(d.Workers()) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	workers := d.Workers()
+	if len(workers) != 1 || workers[0].ID != "w1" {
+		t.Errorf("Membership not updated after disconnect: %+v", workers)
+	}
+}
+
+func TestCluster_RejectsDuplicateWorkerID(t *testing.T) {
+	d := startDriver(t)
+	startWorker(t, d, "w1", 0)
+	waitForWorkers(t, d, 1)
+
+	w := NewWorker(WorkerConfig{ID: "w1", DriverAddr: d.Addr(), Executor: testOperations, Logger: testLogger})
+	if err := w.Run(context.Background()); err == nil {
+		t.Errorf("Expected duplicate worker id to be rejected")
+	}
+}
+
+func TestCluster_RejectsProtocolVersionMismatch(t *testing.T) {
+	d := startDriver(t)
+
+	raw, err := net.Dial("tcp", d.Addr())
+	if err != nil {
+		t.Fatalf("Dial failed with error: %v", err)
+	}
+	defer raw.Close()
+	fmt.Fprintf(raw, "{\"version\": %d, \"type\": %q, \"workerId\": \"old\"}\n", ProtocolVersion+1, MsgRegister)
+
+	var reply Message
+	if err := json.NewDecoder(raw).Decode(&reply); err != nil {
+		t.Fatalf("Decode failed with error: %v", err)
+	}
+	if reply.Type != MsgRejected {
+		t.Errorf("Expected rejection, got %+v", reply)
+	}
+	if len(d.Workers()) != 0 {
+		t.Errorf("Mismatched worker was registered")
+	}
+}
+
+// TestHelperWorkerProcess is not a real test: it runs a worker when the test binary
+// is re-executed by TestCluster_WorkerProcesses
+func TestHelperWorkerProcess(t *testing.T) {
+	addr := os.Getenv("CLUSTER_TEST_DRIVER")
+	if addr == "" {
+		return
+	}
+	w := NewWorker(WorkerConfig{ID: os.Getenv("CLUSTER_TEST_WORKER_ID"), DriverAddr: addr, Executor: testOperations, Logger: testLogger})
+	w.Run(context.Background())
+	os.Exit(0)
+}
+
+func TestCluster_WorkerProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts worker processes")
+	}
+	d := startDriver(t)
+
+	for i := 0; i < 3; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperWorkerProcess$")
+		cmd.Env = append(os.Environ(), "CLUSTER_TEST_DRIVER="+d.Addr(), fmt.Sprintf("CLUSTER_TEST_WORKER_ID=proc-%d", i))
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("Starting worker process failed with error: %v", err)
+		}
+		t.Cleanup(func() {
+			cmd.Process.Kill()
+			cmd.Wait()
+		})
+	}
+	waitForWorkers(t, d, 3)
+
+	result, err := d.RunJob(context.Background(), numbers(9), 3, []types.OperationSpec{{Name: "double"}})
+	if err != nil {
+		t.Fatalf("RunJob failed with error: %v", err)
+	}
+	if len(result) != 9 || result[8] != 18.0 {
+		t.Errorf("RunJob across processes failed: got %v", result)
+	}
+}
+
+func TestCluster_SubmitRefusesClosures(t *testing.T) {
+	d := startDriver(t)
+	startWorker(t, d, "w1", 0)
+	waitForWorkers(t, d, 1)
+
+	job := rdd.NewKeyedRDD(numbers(3), func(i interface{}) (interface{}, error) { return i, nil }).
+		Map(func(i interface{}) (interface{}, error) { return i, nil })
+
+	_, err := d.Submit(context.Background(), job)
+	if err == nil || !strings.Contains(err.Error(), "closure") {
+		t.Errorf("Expected closure chain to be refused, got %v", err)
+	}
+}
+
+func TestCluster_SubmitRegisteredChain(t *testing.T) {
+	d := startDriver(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := NewWorker(WorkerConfig{ID: "w1", DriverAddr: d.Addr(), Executor: registry.Executor{}, Logger: testLogger})
+	go w.Run(ctx)
+	waitForWorkers(t, d, 1)
+
+	job := rdd.NewKeyedRDD(numbers(4), func(i interface{}) (interface{}, error) { return i, nil }).
+		Repartition(2).
+		MapNamed("cluster.test.negate", nil)
+
+	result, err := d.Submit(context.Background(), job)
+	if err != nil {
+		t.Fatalf("Submit failed with error: %v", err)
+	}
+
+	expected := []interface{}{-1.0, -2.0, -3.0, -4.0}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Submit failed: got %v, want %v", result, expected)
+	}
+}
+
+func TestCluster_SilentWorkerIsMarkedDead(t *testing.T) {
+	d := NewDriver(DriverConfig{HeartbeatTimeout: 300 * time.Millisecond, Logger: testLogger})
+	if err := d.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen failed with error: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	// a worker that registers and then never heartbeats nor answers tasks
+	raw, err := net.Dial("tcp", d.Addr())
+	if err != nil {
+		t.Fatalf("Dial failed with error: %v", err)
+	}
+	defer raw.Close()
+	silent := newConn(raw)
+	if err := silent.send(Message{Type: MsgRegister, WorkerID: "silent"}); err != nil {
+		t.Fatalf("Register failed with error: %v", err)
+	}
+	if reply, err := silent.receive(); err != nil || reply.Type != MsgRegistered {
+		t.Fatalf("Silent worker not registered: %+v, %v", reply, err)
+	}
+	startRegistryWorker(t, d, "healthy", 20*time.Millisecond)
+	waitForWorkers(t, d, 2)
+
+	job := rdd.NewKeyedRDD(numbers(8), func(i interface{}) (interface{}, error) { return i, nil }).
+		Repartition(4).
+		MapNamed("cluster.test.negate", nil)
+	result, err := d.Submit(context.Background(), job)
+	if err != nil {
+		t.Fatalf("Submit failed with error: %v", err)
+	}
+	if len(result) != 8 || result[7] != -8.0 {
+		t.Errorf("Rescheduled job failed: got %v", result)
+	}
+
+	dead := d.DeadWorkers()
+	if len(dead) != 1 || dead[0].ID != "silent" {
+		t.Errorf("Expected silent worker to be marked dead, got %+v", dead)
+	}
+	if workers := d.Workers(); len(workers) != 1 || workers[0].ID != "healthy" {
+		t.Errorf("Healthy worker should stay registered, got %+v", workers)
+	}
+}
+
+func TestCluster_ShuffleAcrossWorkers(t *testing.T) {
+	d := startDriver(t)
+	startRegistryWorker(t, d, "w1", 0)
+	startRegistryWorker(t, d, "w2", 0)
+	waitForWorkers(t, d, 2)
+
+	// sum 1..10 grouped by value mod 3: {3+6+9, 1+4+7+10, 2+5+8}
+	job := rdd.NewKeyedRDD(numbers(10), func(i interface{}) (interface{}, error) { return i, nil }).
+		Repartition(3).
+		ReduceByKeyNamed("cluster.test.mod", map[string]int{"N": 3}, "cluster.test.sum", nil).
+		MapNamed("cluster.test.negate", nil)
+
+	result, err := d.Submit(context.Background(), job)
+	if err != nil {
+		t.Fatalf("Submit failed with error: %v", err)
+	}
+	expected := []float64{-22, -18, -15}
+	if got := sortedFloats(result); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Shuffle job failed: got %v, want %v", got, expected)
+	}
+}
+
+func TestCluster_LostMapOutputIsRecomputed(t *testing.T) {
+	d := startDriver(t)
+	stop := map[string]context.CancelFunc{
+		"w1": startRegistryWorker(t, d, "w1", 0),
+		"w2": startRegistryWorker(t, d, "w2", 0),
+	}
+	waitForWorkers(t, d, 2)
+
+	key := types.OperationSpec{Name: "cluster.test.mod", Args: json.RawMessage(`{"N":2}`)}
+	enc, _ := codec.Lookup(codec.Binary)
+	dep := &shuffleDep{id: 1000, codec: enc, reduce: ShuffleReduce{Key: key, Reduce: types.OperationSpec{Name: "cluster.test.sum"}}}
+	for p, part := range [][]interface{}{{1.0, 2.0}, {3.0, 4.0}, {5.0, 6.0}, {7.0, 8.0}} {
+		dep.mapTasks = append(dep.mapTasks, &TaskRequest{Partition: p, Codec: codec.Binary, Data: part, Write: &ShuffleWrite{ShuffleID: dep.id, MapID: p, NumBuckets: 2, Key: key}})
+	}
+	if _, err := d.runStage(context.Background(), nil, dep.mapTasks); err != nil {
+		t.Fatalf("Map stage failed with error: %v", err)
+	}
+
+	status, ok := d.MapOutputs().Status(dep.id, 0)
+	if !ok {
+		t.Fatalf("Map output 0 was not registered")
+	}
+	stop[status.WorkerID]()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(d.Workers()) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if missing := d.MapOutputs().Missing(dep.id, len(dep.mapTasks)); len(missing) == 0 {
+		t.Fatalf("Expected outputs of the lost worker to be dropped")
+	}
+
+	var reduced []interface{}
+	for bucket := 0; bucket < 2; bucket++ {
+		data, err := d.fetchBucket(context.Background(), dep, bucket)
+		if err != nil {
+			t.Fatalf("Fetching bucket %d failed with error: %v", bucket, err)
+		}
+		sum, err := registry.ReduceByKey(data, dep.reduce.Key, dep.reduce.Reduce)
+		if err != nil {
+			t.Fatalf("ReduceByKey failed with error: %v", err)
+		}
+		reduced = append(reduced, sum...)
+	}
+	if got := sortedFloats(reduced); !reflect.DeepEqual(got, []float64{16, 20}) {
+		t.Errorf("Recomputed shuffle failed: got %v", got)
+	}
+	if missing := d.MapOutputs().Missing(dep.id, len(dep.mapTasks)); len(missing) != 0 {
+		t.Errorf("Map outputs still missing after recomputation: %v", missing)
+	}
+}
+
+func TestCluster_SubmitJobWithCodec(t *testing.T) {
+	d := startDriver(t)
+	startRegistryWorker(t, d, "w1", 0)
+	waitForWorkers(t, d, 1)
+
+	job := rdd.NewKeyedRDD([]interface{}{1, 2, 3}, func(i interface{}) (interface{}, error) { return i, nil }).
+		Repartition(2).
+		MapExpr(expr.Mul(expr.Col("_"), expr.Lit(10)))
+
+	// JSON turns every number into float64, the binary codec keeps ints as ints
+	expected := map[string][]interface{}{
+		codec.JSON:   {10.0, 20.0, 30.0},
+		codec.Binary: {10, 20, 30},
+		codec.Gob:    {10, 20, 30},
+	}
+	for name, want := range expected {
+		result, err := d.SubmitJob(context.Background(), job, JobConfig{Codec: name})
+		if err != nil {
+			t.Fatalf("SubmitJob with %s failed with error: %v", name, err)
+		}
+		if !reflect.DeepEqual(result, want) {
+			t.Errorf("SubmitJob with %s failed: got %#v, want %#v", name, result, want)
+		}
+	}
+
+	if _, err := d.SubmitJob(context.Background(), job, JobConfig{Codec: "xml"}); err == nil {
+		t.Errorf("Expected unknown codec to be rejected")
+	}
+}
+
+func TestCluster_ShuffleMetricsReportCompression(t *testing.T) {
+	d := startDriver(t)
+	startRegistryWorker(t, d, "w1", 0)
+	startRegistryWorker(t, d, "w2", 0)
+	waitForWorkers(t, d, 2)
+
+	job := rdd.NewKeyedRDD(numbers(2000), func(i interface{}) (interface{}, error) { return i, nil }).
+		Repartition(4).
+		ReduceByKeyNamed("cluster.test.mod", map[string]int{"N": 2}, "cluster.test.sum", nil)
+
+	for _, name := range []string{compression.None, compression.LZ} {
+		result, err := d.SubmitJob(context.Background(), job, JobConfig{Codec: codec.JSON, Compression: name})
+		if err != nil {
+			t.Fatalf("SubmitJob with %s failed with error: %v", name, err)
+		}
+		if got := sortedFloats(result); !reflect.DeepEqual(got, []float64{1000000, 1001000}) {
+			t.Errorf("SubmitJob with %s failed: got %v", name, got)
+		}
+	}
+
+	metrics := d.ShuffleMetrics()
+	if len(metrics) != 2 {
+		t.Fatalf("Expected metrics for 2 shuffles, got %+v", metrics)
+	}
+	plain, compressed := metrics[0], metrics[1]
+	if plain.Compression != compression.None || compressed.Compression != compression.LZ {
+		t.Errorf("Unexpected compression names: %+v", metrics)
+	}
+	if plain.Records != 2000 || compressed.Records != 2000 {
+		t.Errorf("Expected 2000 shuffled records, got %d and %d", plain.Records, compressed.Records)
+	}
+	if plain.RawBytes != compressed.RawBytes || compressed.RawBytes == 0 {
+		t.Errorf("Raw bytes should not depend on compression: %d vs %d", plain.RawBytes, compressed.RawBytes)
+	}
+	if compressed.CompressedBytes >= compressed.RawBytes {
+		t.Errorf("Compression did not shrink the shuffle: %+v", compressed)
+	}
+}
+
+func TestCluster_CorruptBlockIsRecomputed(t *testing.T) {
+	d := startDriver(t)
+	workers := make(map[string]*Worker)
+	for _, id := range []string{"w1", "w2"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		workers[id] = NewWorker(WorkerConfig{ID: id, DriverAddr: d.Addr(), Executor: registry.Executor{}, Logger: testLogger})
+		go workers[id].Run(ctx)
+	}
+	waitForWorkers(t, d, 2)
+
+	enc, _ := codec.Lookup(codec.Binary)
+	key := types.OperationSpec{Name: "cluster.test.mod", Args: json.RawMessage(`{"N":2}`)}
+	dep := &shuffleDep{id: 2000, codec: enc, compression: compression.LZ, reduce: ShuffleReduce{Key: key, Reduce: types.OperationSpec{Name: "cluster.test.sum"}}}
+	for p, part := range [][]interface{}{{1.0, 2.0, 3.0}, {4.0, 5.0, 6.0}} {
+		dep.mapTasks = append(dep.mapTasks, &TaskRequest{Partition: p, Codec: codec.Binary, Data: part, Write: &ShuffleWrite{ShuffleID: dep.id, MapID: p, NumBuckets: 1, Key: key, Compression: compression.LZ}})
+	}
+	if _, err := d.runStage(context.Background(), nil, dep.mapTasks); err != nil {
+		t.Fatalf("Map stage failed with error: %v", err)
+	}
+
+	// flip a payload byte of map output 1 on the worker that holds it
+	status, _ := d.MapOutputs().Status(dep.id, 1)
+	holder := workers[status.WorkerID]
+	id := storage.ShuffleBlockID(dep.id, 1, 0)
+	block, _ := holder.blocks.Get(id)
+	corrupt := append([]byte(nil), block...)
+	corrupt[len(corrupt)-1] ^= 0xFF
+	holder.blocks.Put(id, corrupt)
+
+	data, err := d.fetchBucket(context.Background(), dep, 0)
+	if err != nil {
+		t.Fatalf("fetchBucket failed with error: %v", err)
+	}
+	if got := sortedFloats(data); !reflect.DeepEqual(got, []float64{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("Corrupt block not recomputed: got %v", got)
+	}
+
+	d.finishShuffle(dep)
+	metrics := d.ShuffleMetrics()
+	if len(metrics) != 1 || metrics[0].CorruptBlocks != 1 {
+		t.Errorf("Expected one corrupt block in metrics, got %+v", metrics)
+	}
+}
+
+func TestCluster_BroadcastShippedOncePerWorker(t *testing.T) {
+	d := NewDriver(DriverConfig{BroadcastChunkSize: 16, Logger: testLogger})
+	if err := d.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen failed with error: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	workers := make(map[string]*Worker)
+	for _, id := range []string{"w1", "w2"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		workers[id] = NewWorker(WorkerConfig{ID: id, DriverAddr: d.Addr(), Executor: registry.Executor{}, Logger: testLogger})
+		go workers[id].Run(ctx)
+	}
+	waitForWorkers(t, d, 2)
+
+	table := map[string]interface{}{"1": "one", "2": "two", "3": "three", "4": "four"}
+	b, err := d.Broadcast(table)
+	if err != nil {
+		t.Fatalf("Broadcast failed with error: %v", err)
+	}
+	job := rdd.NewKeyedRDD(numbers(4), func(i interface{}) (interface{}, error) { return i, nil }).
+		Repartition(4).
+		MapNamed("cluster.test.lookup", map[string]interface{}{"Table": b})
+
+	for run := 0; run < 2; run++ {
+		result, err := d.Submit(context.Background(), job)
+		if err != nil {
+			t.Fatalf("Submit failed with error: %v", err)
+		}
+		if !reflect.DeepEqual(result, []interface{}{"one", "two", "three", "four"}) {
+			t.Errorf("Broadcast lookup failed: got %v", result)
+		}
+	}
+
+	pieces, _ := d.broadcasts.Pieces(b.ID())
+	if len(pieces) < 2 {
+		t.Fatalf("Expected the table to be split into pieces, got %d", len(pieces))
+	}
+	if sent := d.broadcastPieces.Load(); sent != int64(2*len(pieces)) {
+		t.Errorf("Expected %d pieces to be sent, once per worker, got %d", 2*len(pieces), sent)
+	}
+	enc, _ := codec.Lookup(codec.Default)
+	for id, w := range workers {
+		var held [][]byte
+		for _, blockID := range w.blocks.IDs(storage.BroadcastPrefix(b.ID())) {
+			data, _ := w.blocks.Get(blockID)
+			held = append(held, data)
+		}
+		if len(held) != len(pieces) {
+			t.Fatalf("Worker %s holds %d pieces, want %d", id, len(held), len(pieces))
+		}
+		if v, err := broadcast.Decode(enc, held); err != nil || !reflect.DeepEqual(v, table) {
+			t.Errorf("Worker %s pieces decode to %v, %v", id, v, err)
+		}
+	}
+
+	if err := b.Destroy(); err != nil {
+		t.Fatalf("Destroy failed with error: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for (workers["w1"].blocks.Size() > 0 || workers["w2"].blocks.Size() > 0) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for id, w := range workers {
+		if ids := w.blocks.IDs(""); len(ids) != 0 {
+			t.Errorf("Worker %s still holds blocks after Destroy: %v", id, ids)
+		}
+	}
+	if _, err := d.Submit(context.Background(), job); !errors.Is(err, broadcast.ErrDestroyed) {
+		t.Errorf("Expected a job reading a destroyed broadcast to fail, got %v", err)
+	}
+}
+
+func TestCluster_AccumulatorMergedOncePerTask(t *testing.T) {
+	d := startDriver(t)
+	startRegistryWorker(t, d, "w1", 0)
+	startRegistryWorker(t, d, "w2", 0)
+	waitForWorkers(t, d, 2)
+
+	seen := accumulator.Long("seen")
+	job := rdd.NewKeyedRDD(numbers(8), nil).
+		Repartition(4).
+		MapNamed("cluster.test.count", map[string]interface{}{"Seen": seen})
+	if _, err := d.Submit(context.Background(), job); err != nil {
+		t.Fatalf("Submit failed with error: %v", err)
+	}
+	if seen.Value() != 8 {
+		t.Fatalf("Accumulator after one job: got %d, want 8", seen.Value())
+	}
+
+	// A map stage whose output is lost and recomputed must not count twice
+	seen.Reset()
+	args, _ := json.Marshal(map[string]interface{}{"Seen": seen})
+	ops := []types.OperationSpec{{Name: "cluster.test.count", Args: args}}
+	key := types.OperationSpec{Name: "cluster.test.mod", Args: json.RawMessage(`{"N":2}`)}
+	enc, _ := codec.Lookup(codec.Binary)
+	dep := &shuffleDep{id: 2000, codec: enc, reduce: ShuffleReduce{Key: key, Reduce: types.OperationSpec{Name: "cluster.test.sum"}}}
+	for p, part := range [][]interface{}{{1.0, 2.0}, {3.0, 4.0}, {5.0, 6.0}, {7.0, 8.0}} {
+		dep.mapTasks = append(dep.mapTasks, &TaskRequest{Partition: p, Codec: codec.Binary, Data: part, Operations: ops, Write: &ShuffleWrite{ShuffleID: dep.id, MapID: p, NumBuckets: 2, Key: key}})
+	}
+	defer d.finishShuffle(dep)
+	if _, err := d.runStage(context.Background(), nil, dep.mapTasks); err != nil {
+		t.Fatalf("Map stage failed with error: %v", err)
+	}
+	d.MapOutputs().Unregister(dep.id, 1)
+	d.MapOutputs().Unregister(dep.id, 3)
+	if _, err := d.fetchBucket(context.Background(), dep, 0); err != nil {
+		t.Fatalf("Fetching bucket failed with error: %v", err)
+	}
+	if missing := d.MapOutputs().Missing(dep.id, len(dep.mapTasks)); len(missing) != 0 {
+		t.Fatalf("Map outputs were not recomputed: %v", missing)
+	}
+	if seen.Value() != 8 {
+		t.Errorf("Recomputed map tasks counted again: got %d, want 8", seen.Value())
+	}
+}
+
+func TestCollect_ReadsResultsQueuedBeforeLoss(t *testing.T) {
+	w := &workerConn{info: WorkerInfo{ID: "w1"}}
+	decode := func(payload []byte) ([]interface{}, error) { return []interface{}{string(payload)}, nil }
+	// the worker sent its final chunk and dropped; select would pick either case
+	for i := 0; i < 100; i++ {
+		p := &pendingTask{results: make(chan TaskResult, 16), lost: make(chan struct{})}
+		p.results <- TaskResult{Payload: []byte("a")}
+		p.results <- TaskResult{Payload: []byte("b"), Done: true}
+		close(p.lost)
+		out, err := collect(context.Background(), w, 1, p, decode)
+		if err != nil {
+			t.Fatalf("Collect of a completed task failed with error: %v", err)
+		}
+		if !reflect.DeepEqual(out.records, []interface{}{"a", "b"}) {
+			t.Fatalf("Collect of a completed task: got %v, want [a b]", out.records)
+		}
+	}
+
+	p := &pendingTask{results: make(chan TaskResult, 16), lost: make(chan struct{})}
+	p.results <- TaskResult{Payload: []byte("a")}
+	close(p.lost)
+	if _, err := collect(context.Background(), w, 1, p, decode); !errors.Is(err, ErrWorkerLost) {
+		t.Errorf("Collect of an unfinished task: got %v, want ErrWorkerLost", err)
+	}
+}
@@ -0,0 +1,604 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bajor/spark-go-core/accumulator"
+	"github.com/bajor/spark-go-core/broadcast"
+	"github.com/bajor/spark-go-core/codec"
+	"github.com/bajor/spark-go-core/operations"
+	"github.com/bajor/spark-go-core/storage"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// ErrNoWorkers is returned when a task is submitted while no worker is registered
+var ErrNoWorkers = errors.New("no workers registered")
+
+// ErrWorkerLost is returned for tasks whose worker disconnected or stopped sending heartbeats
+var ErrWorkerLost = errors.New("worker lost")
+
+// DefaultHeartbeatTimeout is how long the driver waits for a message from a worker before declaring it dead
+const DefaultHeartbeatTimeout = 10 * time.Second
+
+// DefaultMaxTaskAttempts is how many workers a task is tried on before the job fails
+const DefaultMaxTaskAttempts = 4
+
+// WorkerInfo describes a registered worker
+type WorkerInfo struct {
+	ID           string
+	Address      string
+	RegisteredAt time.Time
+	LastSeen     time.Time
+	RunningTasks int
+	// LostReason is set for workers returned by DeadWorkers
+	LostReason string
+}
+
+// DriverConfig configures a Driver
+type DriverConfig struct {
+	// HeartbeatTimeout is how long a worker may stay silent before it is marked dead
+	HeartbeatTimeout time.Duration
+	// MaxTaskAttempts bounds how often a task is rescheduled after losing its worker
+	MaxTaskAttempts int
+	// Codec names the record codec of jobs that do not pick their own, defaults to codec.Default
+	Codec string
+	// BroadcastChunkSize is the size of the pieces broadcast values are shipped in,
+	// defaults to broadcast.DefaultChunkSize
+	BroadcastChunkSize int
+	// Compression names the shuffle block compression of jobs that do not pick their own,
+	// defaults to compression.Default
+	Compression string
+	// Logger receives membership and task events, defaults to the standard logger
+	Logger *log.Logger
+}
+
+// Driver accepts worker registrations and distributes tasks to them
+type Driver struct {
+	config   DriverConfig
+	listener net.Listener
+	nextTask int64
+	outputs  *MapOutputTracker
+	stop     chan struct{}
+
+	broadcasts      *broadcast.Manager
+	broadcastPieces atomic.Int64
+
+	mu       sync.Mutex
+	workers  map[string]*workerConn
+	order    []string
+	next     int
+	dead     []WorkerInfo
+	shuffles []ShuffleMetrics
+	changed  chan struct{}
+	closed   bool
+	handlers sync.WaitGroup
+}
+
+// workerConn is the driver side of a registered worker connection
+type workerConn struct {
+	info     WorkerInfo
+	conn     *conn
+	lastSeen atomic.Int64
+
+	mu      sync.Mutex
+	pending map[int64]*pendingTask
+	gone    bool
+	reason  string
+
+	// shipMu serializes shipping broadcasts so a task never overtakes their pieces
+	shipMu  sync.Mutex
+	shipped map[int64]bool
+}
+
+// pendingTask receives the result chunks of a request until the caller stops waiting.
+// lost is closed when the worker goes away before the request finished.
+type pendingTask struct {
+	results chan TaskResult
+	done    chan struct{}
+	lost    chan struct{}
+}
+
+// NewDriver creates a Driver; call Listen to start accepting workers
+func NewDriver(config DriverConfig) *Driver {
+	if config.HeartbeatTimeout <= 0 {
+		config.HeartbeatTimeout = DefaultHeartbeatTimeout
+	}
+	if config.MaxTaskAttempts <= 0 {
+		config.MaxTaskAttempts = DefaultMaxTaskAttempts
+	}
+	if config.Logger == nil {
+		config.Logger = log.Default()
+	}
+	d := &Driver{
+		config:     config,
+		outputs:    NewMapOutputTracker(),
+		stop:       make(chan struct{}),
+		broadcasts: broadcast.NewManager(storage.NewBlockManager(), broadcast.Config{Codec: config.Codec, ChunkSize: config.BroadcastChunkSize}),
+		workers:    make(map[string]*workerConn),
+		changed:    make(chan struct{}),
+	}
+	d.broadcasts.OnDestroy(d.releaseBroadcast)
+	return d
+}
+
+// Listen starts accepting worker connections on addr, e.g. ":7077" or "127.0.0.1:0"
+func (d *Driver) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	d.listener = l
+	d.config.Logger.Printf("driver listening on %s", l.Addr())
+	go d.acceptLoop()
+	go d.monitor()
+	return nil
+}
+
+// Addr returns the address the driver is listening on
+func (d *Driver) Addr() string {
+	if d.listener == nil {
+		return ""
+	}
+	return d.listener.Addr().String()
+}
+
+// MapOutputs returns the tracker holding the locations of shuffle outputs
+func (d *Driver) MapOutputs() *MapOutputTracker {
+	return d.outputs
+}
+
+// Close stops accepting workers and disconnects the registered ones
+func (d *Driver) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	close(d.stop)
+	workers := make([]*workerConn, 0, len(d.workers))
+	for _, w := range d.workers {
+		workers = append(workers, w)
+	}
+	d.mu.Unlock()
+	d.broadcasts.Close()
+
+	var err error
+	if d.listener != nil {
+		err = d.listener.Close()
+	}
+	for _, w := range workers {
+		w.conn.close()
+	}
+	d.handlers.Wait()
+	return err
+}
+
+// Workers returns the live membership list ordered by registration time
+func (d *Driver) Workers() []WorkerInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]WorkerInfo, 0, len(d.order))
+	for _, id := range d.order {
+		out = append(out, d.workers[id].snapshot())
+	}
+	return out
+}
+
+// DeadWorkers returns the workers that disconnected or missed their heartbeats
+func (d *Driver) DeadWorkers() []WorkerInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]WorkerInfo, len(d.dead))
+	copy(out, d.dead)
+	return out
+}
+
+// WaitForWorkers blocks until at least n workers are registered or ctx is done
+func (d *Driver) WaitForWorkers(ctx context.Context, n int) error {
+	for {
+		d.mu.Lock()
+		count := len(d.workers)
+		changed := d.changed
+		d.mu.Unlock()
+		if count >= n {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %d workers, have %d: %w", n, count, ctx.Err())
+		}
+	}
+}
+
+func (d *Driver) acceptLoop() {
+	for {
+		raw, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		d.handlers.Add(1)
+		go func() {
+			defer d.handlers.Done()
+			d.handle(newConn(raw))
+		}()
+	}
+}
+
+// monitor marks workers dead when nothing was heard from them within the heartbeat timeout
+func (d *Driver) monitor() {
+	ticker := time.NewTicker(d.config.HeartbeatTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case now := <-ticker.C:
+			d.mu.Lock()
+			var expired []*workerConn
+			for _, w := range d.workers {
+				if now.Sub(time.Unix(0, w.lastSeen.Load())) > d.config.HeartbeatTimeout {
+					expired = append(expired, w)
+				}
+			}
+			d.mu.Unlock()
+			for _, w := range expired {
+				w.markLost(fmt.Sprintf("no heartbeat for %v", d.config.HeartbeatTimeout))
+				// closing the connection makes handle remove the worker
+				w.conn.close()
+			}
+		}
+	}
+}
+
+// handle performs the registration handshake and then reads worker messages until the worker goes away
+func (d *Driver) handle(c *conn) {
+	defer c.close()
+
+	msg, err := c.receive()
+	if err != nil {
+		if errors.Is(err, ErrVersionMismatch) {
+			c.send(Message{Type: MsgRejected, Reason: err.Error()})
+		}
+		d.config.Logger.Printf("rejecting connection from %s: %v", c.raw.RemoteAddr(), err)
+		return
+	}
+	if msg.Type != MsgRegister || msg.WorkerID == "" {
+		c.send(Message{Type: MsgRejected, Reason: "expected register message with a worker id"})
+		return
+	}
+
+	w := &workerConn{
+		info:    WorkerInfo{ID: msg.WorkerID, Address: msg.Address, RegisteredAt: time.Now()},
+		conn:    c,
+		pending: make(map[int64]*pendingTask),
+		shipped: make(map[int64]bool),
+	}
+	w.lastSeen.Store(time.Now().UnixNano())
+	if err := d.addWorker(w); err != nil {
+		c.send(Message{Type: MsgRejected, Reason: err.Error()})
+		return
+	}
+	defer d.removeWorker(w)
+
+	if err := c.send(Message{Type: MsgRegistered, WorkerID: w.info.ID}); err != nil {
+		return
+	}
+	d.config.Logger.Printf("worker %s registered from %s", w.info.ID, c.raw.RemoteAddr())
+
+	for {
+		msg, err := c.receive()
+		if err != nil {
+			w.markLost("disconnected")
+			return
+		}
+		w.lastSeen.Store(time.Now().UnixNano())
+		if msg.Type == MsgResult && msg.Result != nil {
+			w.deliver(*msg.Result)
+		}
+	}
+}
+
+func (d *Driver) addWorker(w *workerConn) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return errors.New("driver is shutting down")
+	}
+	if _, ok := d.workers[w.info.ID]; ok {
+		return fmt.Errorf("worker id %q is already registered", w.info.ID)
+	}
+	d.workers[w.info.ID] = w
+	d.order = append(d.order, w.info.ID)
+	d.notifyLocked()
+	return nil
+}
+
+// removeWorker drops a lost worker from the membership list, fails its running tasks
+// so they get rescheduled and forgets the shuffle outputs it held
+func (d *Driver) removeWorker(w *workerConn) {
+	info := w.snapshot()
+	d.mu.Lock()
+	if d.workers[w.info.ID] == w {
+		delete(d.workers, w.info.ID)
+		for i, id := range d.order {
+			if id == w.info.ID {
+				d.order = append(d.order[:i], d.order[i+1:]...)
+				break
+			}
+		}
+		if !d.closed {
+			d.dead = append(d.dead, info)
+		}
+		d.notifyLocked()
+	}
+	d.mu.Unlock()
+
+	w.failPending()
+	lost := d.outputs.RemoveWorker(w.info.ID)
+	d.config.Logger.Printf("worker %s removed (%s), %d shuffle outputs invalidated", w.info.ID, info.LostReason, lost)
+}
+
+// notifyLocked wakes up everyone waiting for a membership change
+func (d *Driver) notifyLocked() {
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// pickWorker chooses the next worker round-robin, avoiding the excluded ones when possible
+func (d *Driver) pickWorker(exclude map[string]bool) (*workerConn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.order) == 0 {
+		return nil, ErrNoWorkers
+	}
+	for i := 0; i < len(d.order); i++ {
+		d.next = (d.next + 1) % len(d.order)
+		if !exclude[d.order[d.next]] {
+			break
+		}
+	}
+	return d.workers[d.order[d.next]], nil
+}
+
+// worker returns a live worker by id
+func (d *Driver) worker(id string) (*workerConn, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.workers[id]
+	return w, ok
+}
+
+// RunTask sends one partition with its operations to a worker and collects the streamed result
+func (d *Driver) RunTask(ctx context.Context, partition int, data []interface{}, ops []types.OperationSpec) ([]interface{}, error) {
+	out, err := d.runTask(ctx, &TaskRequest{Partition: partition, Data: data, Operations: ops})
+	if err != nil {
+		return nil, err
+	}
+	if err := accumulator.Apply(out.updates); err != nil {
+		return nil, err
+	}
+	return out.records, nil
+}
+
+// taskOutput is what a finished task produced and where it ran
+type taskOutput struct {
+	records  []interface{}
+	status   MapStatus
+	updates  []accumulator.Update
+	workerID string
+}
+
+// runTask runs a task, moving it to another worker whenever the one running it is lost
+func (d *Driver) runTask(ctx context.Context, req *TaskRequest) (taskOutput, error) {
+	tried := make(map[string]bool)
+	var lastErr error
+	for attempt := 0; attempt < d.config.MaxTaskAttempts; attempt++ {
+		w, err := d.pickWorker(tried)
+		if err != nil {
+			if lastErr != nil {
+				return taskOutput{}, fmt.Errorf("%w (after %v)", err, lastErr)
+			}
+			return taskOutput{}, err
+		}
+		tried[w.info.ID] = true
+
+		attemptReq := *req
+		attemptReq.TaskID = atomic.AddInt64(&d.nextTask, 1)
+		out, err := d.runTaskOn(ctx, w, &attemptReq)
+		if err == nil || !errors.Is(err, ErrWorkerLost) {
+			return out, err
+		}
+		lastErr = err
+		d.config.Logger.Printf("rescheduling partition %d: %v", req.Partition, err)
+	}
+	return taskOutput{}, fmt.Errorf("partition %d failed after %d attempts: %w", req.Partition, d.config.MaxTaskAttempts, lastErr)
+}
+
+// runTaskOn sends a task to a specific worker and collects its streamed result
+func (d *Driver) runTaskOn(ctx context.Context, w *workerConn, req *TaskRequest) (taskOutput, error) {
+	enc, err := codec.Lookup(req.Codec)
+	if err != nil {
+		return taskOutput{}, err
+	}
+	wire := *req
+	if wire.Payload, err = codec.Marshal(enc, req.Data); err != nil {
+		return taskOutput{}, fmt.Errorf("partition %d: %w", req.Partition, err)
+	}
+
+	if err := d.shipBroadcasts(w, req.Broadcasts); err != nil {
+		return taskOutput{}, err
+	}
+
+	p, err := w.register(req.TaskID)
+	if err != nil {
+		return taskOutput{}, err
+	}
+	defer w.unregister(req.TaskID)
+
+	if err := w.conn.send(Message{Type: MsgTask, Task: &wire}); err != nil {
+		return taskOutput{}, fmt.Errorf("send task %d to worker %s: %v: %w", req.TaskID, w.info.ID, err, ErrWorkerLost)
+	}
+	return collect(ctx, w, req.TaskID, p, func(payload []byte) ([]interface{}, error) {
+		return codec.Unmarshal(enc, payload)
+	})
+}
+
+// collect gathers result chunks for a request until the final one arrives,
+// turning each payload into records with decode. Chunks a worker sent before it was
+// lost are still read, so a task whose final chunk arrived is not run again.
+func collect(ctx context.Context, w *workerConn, id int64, p *pendingTask, decode func([]byte) ([]interface{}, error)) (taskOutput, error) {
+	out := taskOutput{records: make([]interface{}, 0), workerID: w.info.ID}
+	for {
+		var res TaskResult
+		select {
+		case res = <-p.results:
+		case <-p.lost:
+			// select picks among ready cases at random, so chunks may still be queued
+			select {
+			case res = <-p.results:
+			default:
+				return taskOutput{}, fmt.Errorf("request %d on worker %s: %w", id, w.info.ID, ErrWorkerLost)
+			}
+		case <-ctx.Done():
+			return taskOutput{}, ctx.Err()
+		}
+		if res.Error != "" {
+			return taskOutput{}, fmt.Errorf("request %d on worker %s: %s", id, w.info.ID, res.Error)
+		}
+		if len(res.Payload) > 0 {
+			records, err := decode(res.Payload)
+			if err != nil {
+				return taskOutput{}, fmt.Errorf("request %d on worker %s: %w", id, w.info.ID, err)
+			}
+			out.records = append(out.records, records...)
+		}
+		if res.BucketSizes != nil {
+			out.status = MapStatus{WorkerID: w.info.ID, BucketSizes: res.BucketSizes, BucketBytes: res.BucketBytes, RawBucketBytes: res.RawBucketBytes}
+		}
+		if res.Done {
+			out.updates = res.Accumulators
+			return out, nil
+		}
+	}
+}
+
+// RunJob splits data into numPartitions, runs every partition on the workers and
+// returns the combined output in partition order
+func (d *Driver) RunJob(ctx context.Context, data []interface{}, numPartitions int, ops []types.OperationSpec) ([]interface{}, error) {
+	refs, err := d.broadcastRefs(ops)
+	if err != nil {
+		return nil, err
+	}
+	parts := operations.Split(data, numPartitions)
+	reqs := make([]*TaskRequest, len(parts))
+	for i, part := range parts {
+		reqs[i] = &TaskRequest{Partition: i, Codec: d.config.Codec, Data: part, Operations: ops, Broadcasts: refs}
+	}
+	outputs, err := d.runAll(ctx, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]interface{}, 0)
+	for _, out := range outputs {
+		if err := accumulator.Apply(out.updates); err != nil {
+			return nil, err
+		}
+		result = append(result, out.records...)
+	}
+	return result, nil
+}
+
+// runAll runs the requests concurrently and returns their outputs in order
+func (d *Driver) runAll(ctx context.Context, reqs []*TaskRequest) ([]taskOutput, error) {
+	outputs := make([]taskOutput, len(reqs))
+	errs := make([]error, len(reqs))
+
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func(i int, req *TaskRequest) {
+			defer wg.Done()
+			outputs[i], errs[i] = d.runTask(ctx, req)
+		}(i, req)
+	}
+	wg.Wait()
+
+	for i := range reqs {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("partition %d: %w", reqs[i].Partition, errs[i])
+		}
+	}
+	return outputs, nil
+}
+
+func (w *workerConn) snapshot() WorkerInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	info := w.info
+	info.RunningTasks = len(w.pending)
+	info.LastSeen = time.Unix(0, w.lastSeen.Load())
+	info.LostReason = w.reason
+	return info
+}
+
+// markLost records why the worker is considered dead; the first reason wins
+func (w *workerConn) markLost(reason string) {
+	w.mu.Lock()
+	if w.reason == "" {
+		w.reason = reason
+	}
+	w.mu.Unlock()
+}
+
+func (w *workerConn) register(id int64) (*pendingTask, error) {
+	p := &pendingTask{results: make(chan TaskResult, 16), done: make(chan struct{}), lost: make(chan struct{})}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.gone {
+		return nil, fmt.Errorf("worker %s: %w", w.info.ID, ErrWorkerLost)
+	}
+	w.pending[id] = p
+	return p, nil
+}
+
+func (w *workerConn) unregister(id int64) {
+	w.mu.Lock()
+	if p, ok := w.pending[id]; ok {
+		close(p.done)
+		delete(w.pending, id)
+	}
+	w.mu.Unlock()
+}
+
+func (w *workerConn) deliver(res TaskResult) {
+	w.mu.Lock()
+	p, ok := w.pending[res.TaskID]
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case p.results <- res:
+	case <-p.done:
+	}
+}
+
+// failPending tells every request still waiting on this worker that it was lost
+func (w *workerConn) failPending() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.gone = true
+	for _, p := range w.pending {
+		close(p.lost)
+	}
+}
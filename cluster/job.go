@@ -0,0 +1,366 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bajor/spark-go-core/accumulator"
+	"github.com/bajor/spark-go-core/codec"
+	"github.com/bajor/spark-go-core/compression"
+	"github.com/bajor/spark-go-core/operations"
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/registry"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// stagePlan is a run of narrow operations executed inside one task. When shuffle is
+// set the stage ends by writing its output as shuffle blocks grouped by that key.
+type stagePlan struct {
+	ops     []types.OperationSpec
+	shuffle *ShuffleReduce
+}
+
+// shuffleDep is a shuffle written by a map stage. The map task requests are kept as
+// lineage so outputs lost with a worker can be recomputed.
+type shuffleDep struct {
+	id          int
+	codec       codec.Codec
+	compression string
+	corrupt     atomic.Int64
+	reduce      ShuffleReduce
+	parent      *shuffleDep
+	mapTasks    []*TaskRequest
+
+	// mu serializes recomputation so concurrent reducers do not redo the same map tasks
+	mu sync.Mutex
+}
+
+var nextShuffleID int64
+
+// JobConfig holds the settings of a single submitted job
+type JobConfig struct {
+	// Codec names the codec records and shuffle blocks are encoded with, defaults to the driver's
+	Codec string
+	// Compression names the compression of shuffle blocks, defaults to the driver's
+	Compression string
+}
+
+// Submit ships the RDD's operation chain to the workers and returns the combined output.
+// Every operation must come from the registry; chains holding Go closures are refused
+// before anything is sent. Shuffle operations such as ReduceByKeyNamed split the job into
+// stages whose map outputs stay on the workers until the reduce side fetches them.
+func (d *Driver) Submit(ctx context.Context, r *rdd.KeyedRDD) ([]interface{}, error) {
+	return d.SubmitJob(ctx, r, JobConfig{})
+}
+
+// SubmitJob is Submit with per-job settings. Its shuffles keep their planned number
+// of partitions: unlike rdd's Collect, it never adapts them to measured block sizes.
+func (d *Driver) SubmitJob(ctx context.Context, r *rdd.KeyedRDD, config JobConfig) ([]interface{}, error) {
+	if config.Codec == "" {
+		config.Codec = d.config.Codec
+	}
+	if config.Compression == "" {
+		config.Compression = d.config.Compression
+	}
+	enc, err := codec.Lookup(config.Codec)
+	if err != nil {
+		return nil, err
+	}
+	compressor, err := compression.Lookup(config.Compression)
+	if err != nil {
+		return nil, err
+	}
+	plans, err := planStages(r.Chain.Operations)
+	if err != nil {
+		return nil, fmt.Errorf("cannot ship chain: %w", err)
+	}
+	numPartitions := r.Partitions
+	if r.Source != nil && numPartitions < 1 {
+		numPartitions = r.Source.NumPartitions()
+	}
+	if numPartitions < 1 {
+		numPartitions = 1
+	}
+	parts, err := inputPartitions(r, numPartitions)
+	if err != nil {
+		return nil, err
+	}
+
+	var dep *shuffleDep
+	defer func() {
+		for s := dep; s != nil; s = s.parent {
+			d.finishShuffle(s)
+		}
+	}()
+
+	for _, plan := range plans {
+		reqs := make([]*TaskRequest, numPartitions)
+		var next *shuffleDep
+		if plan.shuffle != nil {
+			next = &shuffleDep{id: int(atomic.AddInt64(&nextShuffleID, 1)), codec: enc, compression: compressor.Name(), reduce: *plan.shuffle, parent: dep, mapTasks: reqs}
+		}
+		refs, err := d.broadcastRefs(plan.specs(dep)...)
+		if err != nil {
+			return nil, err
+		}
+		for p := range reqs {
+			req := &TaskRequest{Partition: p, Codec: enc.Name(), Operations: plan.ops, Broadcasts: refs}
+			if dep == nil {
+				req.Data = parts[p]
+			} else {
+				req.Reduce = &dep.reduce
+			}
+			if next != nil {
+				req.Write = &ShuffleWrite{ShuffleID: next.id, MapID: p, NumBuckets: numPartitions, Key: plan.shuffle.Key, Compression: next.compression}
+			}
+			reqs[p] = req
+		}
+
+		outputs, err := d.runStage(ctx, dep, reqs)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			result := make([]interface{}, 0)
+			for _, out := range outputs {
+				result = append(result, out.records...)
+			}
+			return result, nil
+		}
+		dep = next
+	}
+	return nil, fmt.Errorf("job has no result stage")
+}
+
+// inputPartitions splits the RDD's data into partitions. Source partitions are read on
+// the driver, since workers cannot be assumed to see the driver's files, and
+// redistributed over numPartitions.
+func inputPartitions(r *rdd.KeyedRDD, numPartitions int) ([][]interface{}, error) {
+	if r.Source == nil {
+		return operations.Split(r.Data, numPartitions), nil
+	}
+	src := r.InputSource()
+	if src.NumPartitions() == numPartitions {
+		parts := make([][]interface{}, numPartitions)
+		for p := range parts {
+			part, err := rdd.ReadPartition(src, p)
+			if err != nil {
+				return nil, fmt.Errorf("read partition %d: %w", p, err)
+			}
+			parts[p] = part
+		}
+		return parts, nil
+	}
+	data := make([]interface{}, 0)
+	for p := 0; p < src.NumPartitions(); p++ {
+		part, err := rdd.ReadPartition(src, p)
+		if err != nil {
+			return nil, fmt.Errorf("read partition %d: %w", p, err)
+		}
+		data = append(data, part...)
+	}
+	return operations.Split(data, numPartitions), nil
+}
+
+// specs returns every operation a task of the stage runs: the reduce of the shuffle
+// it reads, its own operations and the key of the shuffle it writes
+func (p stagePlan) specs(input *shuffleDep) [][]types.OperationSpec {
+	all := [][]types.OperationSpec{p.ops}
+	if input != nil {
+		all = append(all, []types.OperationSpec{input.reduce.Key, input.reduce.Reduce})
+	}
+	if p.shuffle != nil {
+		all = append(all, []types.OperationSpec{p.shuffle.Key})
+	}
+	return all
+}
+
+// planStages cuts an operation chain into stages at every shuffle operation
+func planStages(ops []types.Operation) ([]stagePlan, error) {
+	var plans []stagePlan
+	current := stagePlan{}
+	for i, op := range ops {
+		if shuffle, ok := op.(types.ShuffleOperation); ok {
+			key, reduce := shuffle.ShuffleSpecs()
+			for _, spec := range []types.OperationSpec{key, reduce} {
+				if !registry.Registered(spec.Name) {
+					return nil, fmt.Errorf("operation %d refers to %q, which is not registered in this binary", i, spec.Name)
+				}
+			}
+			current.shuffle = &ShuffleReduce{Key: key, Reduce: reduce}
+			plans = append(plans, current)
+			current = stagePlan{}
+			continue
+		}
+		specs, err := registry.Specs([]types.Operation{op})
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		current.ops = append(current.ops, specs...)
+	}
+	return append(plans, current), nil
+}
+
+// runStage runs all tasks of a stage concurrently
+func (d *Driver) runStage(ctx context.Context, dep *shuffleDep, reqs []*TaskRequest) ([]taskOutput, error) {
+	outputs := make([]taskOutput, len(reqs))
+	errs := make([]error, len(reqs))
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func(i int, req *TaskRequest) {
+			defer wg.Done()
+			outputs[i], errs[i] = d.runStageTask(ctx, dep, req)
+		}(i, req)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("partition %d: %w", reqs[i].Partition, err)
+		}
+	}
+	return outputs, nil
+}
+
+// runStageTask fetches the task's shuffle input if it has one, runs it and registers
+// the shuffle output it wrote. Accumulator updates are merged once per task: a map task
+// recomputed after its output was lost does not count again.
+func (d *Driver) runStageTask(ctx context.Context, dep *shuffleDep, req *TaskRequest) (taskOutput, error) {
+	if dep != nil {
+		data, err := d.fetchBucket(ctx, dep, req.Partition)
+		if err != nil {
+			return taskOutput{}, err
+		}
+		withInput := *req
+		withInput.Data = data
+		req = &withInput
+	}
+	out, err := d.runTask(ctx, req)
+	if err != nil {
+		return taskOutput{}, err
+	}
+	first := true
+	if req.Write != nil {
+		first = d.outputs.Register(req.Write.ShuffleID, req.Write.MapID, out.status)
+	}
+	if first {
+		if err := accumulator.Apply(out.updates); err != nil {
+			return taskOutput{}, err
+		}
+	}
+	return out, nil
+}
+
+// fetchBucket collects one bucket from every map output of a shuffle. Missing outputs,
+// because their worker died or a fetch failed, are recomputed from lineage first. A
+// block failing its checksum counts as a failed fetch, so corruption leads to
+// recomputation rather than wrong results.
+func (d *Driver) fetchBucket(ctx context.Context, dep *shuffleDep, bucket int) ([]interface{}, error) {
+	var lastErr error
+	for attempt := 0; attempt < d.config.MaxTaskAttempts; attempt++ {
+		if err := d.recomputeMissing(ctx, dep); err != nil {
+			return nil, err
+		}
+		records := make([]interface{}, 0)
+		failed := false
+		for mapID := range dep.mapTasks {
+			block, err := d.fetchBlock(ctx, dep.codec, BlockID{ShuffleID: dep.id, MapID: mapID, Bucket: bucket})
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if errors.Is(err, compression.ErrCorrupt) {
+					dep.corrupt.Add(1)
+				}
+				d.config.Logger.Printf("shuffle %d: fetch of map output %d failed, recomputing: %v", dep.id, mapID, err)
+				d.outputs.Unregister(dep.id, mapID)
+				lastErr = err
+				failed = true
+				break
+			}
+			records = append(records, block...)
+		}
+		if !failed {
+			return records, nil
+		}
+	}
+	return nil, fmt.Errorf("shuffle %d bucket %d: %w", dep.id, bucket, lastErr)
+}
+
+// recomputeMissing reruns the map tasks whose outputs are not registered
+func (d *Driver) recomputeMissing(ctx context.Context, dep *shuffleDep) error {
+	dep.mu.Lock()
+	defer dep.mu.Unlock()
+	missing := d.outputs.Missing(dep.id, len(dep.mapTasks))
+	if len(missing) == 0 {
+		return nil
+	}
+	reqs := make([]*TaskRequest, len(missing))
+	for i, m := range missing {
+		reqs[i] = dep.mapTasks[m]
+	}
+	_, err := d.runStage(ctx, dep.parent, reqs)
+	return err
+}
+
+// fetchBlock streams a shuffle block from the worker that holds it
+func (d *Driver) fetchBlock(ctx context.Context, enc codec.Codec, block BlockID) ([]interface{}, error) {
+	status, ok := d.outputs.Status(block.ShuffleID, block.MapID)
+	if !ok {
+		return nil, fmt.Errorf("no output registered for map %d", block.MapID)
+	}
+	w, ok := d.worker(status.WorkerID)
+	if !ok {
+		return nil, fmt.Errorf("worker %s: %w", status.WorkerID, ErrWorkerLost)
+	}
+
+	id := atomic.AddInt64(&d.nextTask, 1)
+	p, err := w.register(id)
+	if err != nil {
+		return nil, err
+	}
+	defer w.unregister(id)
+	if err := w.conn.send(Message{Type: MsgFetch, Fetch: &FetchRequest{RequestID: id, Block: block}}); err != nil {
+		return nil, fmt.Errorf("fetch from worker %s: %v: %w", w.info.ID, err, ErrWorkerLost)
+	}
+	out, err := collect(ctx, w, id, p, func(sealed []byte) ([]interface{}, error) {
+		raw, err := compression.Open(sealed)
+		if err != nil {
+			return nil, fmt.Errorf("block %+v: %w", block, err)
+		}
+		return codec.Unmarshal(enc, raw)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.records, nil
+}
+
+// ShuffleMetrics returns the metrics of every finished shuffle, oldest first
+func (d *Driver) ShuffleMetrics() []ShuffleMetrics {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]ShuffleMetrics(nil), d.shuffles...)
+}
+
+// finishShuffle records the metrics of a shuffle, forgets it and tells the workers to
+// drop its blocks
+func (d *Driver) finishShuffle(dep *shuffleDep) {
+	metrics := d.outputs.Metrics(dep.id)
+	metrics.Compression = dep.compression
+	metrics.CorruptBlocks = int(dep.corrupt.Load())
+	d.outputs.RemoveShuffle(dep.id)
+	d.mu.Lock()
+	d.shuffles = append(d.shuffles, metrics)
+	workers := make([]*workerConn, 0, len(d.workers))
+	for _, w := range d.workers {
+		workers = append(workers, w)
+	}
+	d.mu.Unlock()
+	for _, w := range workers {
+		w.conn.send(Message{Type: MsgRemove, ShuffleID: dep.id})
+	}
+}
@@ -0,0 +1,176 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/bajor/spark-go-core/accumulator"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// ProtocolVersion is bumped whenever the wire format changes incompatibly.
+// Driver and workers refuse to talk to a peer with a different version.
+const ProtocolVersion = 6
+
+// Message types exchanged between driver and workers
+const (
+	MsgRegister   = "register"
+	MsgRegistered = "registered"
+	MsgRejected   = "rejected"
+	MsgTask       = "task"
+	MsgResult     = "result"
+	MsgHeartbeat  = "heartbeat"
+	MsgFetch      = "fetch"
+	MsgRemove     = "removeShuffle"
+	MsgBroadcast  = "broadcast"
+	MsgDestroy    = "destroyBroadcast"
+)
+
+// TaskRequest is sent by the driver to run a chain of operations on one partition.
+// Data travels as Payload, encoded with the job's codec. With Reduce set, Data holds
+// the fetched shuffle input and is grouped and reduced before the operations run.
+// With Write set, the output is kept on the worker as shuffle blocks and only their
+// sizes are returned.
+type TaskRequest struct {
+	TaskID     int64                 `json:"taskId"`
+	Partition  int                   `json:"partition"`
+	Codec      string                `json:"codec,omitempty"`
+	Data       []interface{}         `json:"-"`
+	Payload    []byte                `json:"payload,omitempty"`
+	Reduce     *ShuffleReduce        `json:"reduce,omitempty"`
+	Operations []types.OperationSpec `json:"operations"`
+	Write      *ShuffleWrite         `json:"write,omitempty"`
+	// Broadcasts lists the broadcasts the operations read; the driver ships their
+	// pieces to the worker before the first task that needs them
+	Broadcasts []int64 `json:"broadcasts,omitempty"`
+}
+
+// ShuffleReduce describes how a reduce task groups its input
+type ShuffleReduce struct {
+	Key    types.OperationSpec `json:"key"`
+	Reduce types.OperationSpec `json:"reduce"`
+}
+
+// ShuffleWrite tells a map task to hash-partition its output by key into buckets.
+// Every bucket is encoded with the task's codec and sealed as a checksummed block
+// with the named compression.
+type ShuffleWrite struct {
+	ShuffleID   int                 `json:"shuffleId"`
+	MapID       int                 `json:"mapId"`
+	NumBuckets  int                 `json:"numBuckets"`
+	Key         types.OperationSpec `json:"key"`
+	Compression string              `json:"compression,omitempty"`
+}
+
+// BroadcastPiece carries one chunk of a serialized broadcast value
+type BroadcastPiece struct {
+	ID    int64  `json:"id"`
+	Index int    `json:"index"`
+	Total int    `json:"total"`
+	Codec string `json:"codec"`
+	Data  []byte `json:"data"`
+}
+
+// BlockID identifies one bucket of one map task's shuffle output
+type BlockID struct {
+	ShuffleID int `json:"shuffleId"`
+	MapID     int `json:"mapId"`
+	Bucket    int `json:"bucket"`
+}
+
+// FetchRequest asks a worker to stream back a shuffle block it holds
+type FetchRequest struct {
+	RequestID int64   `json:"requestId"`
+	Block     BlockID `json:"block"`
+}
+
+// TaskResult carries one chunk of a task's output, encoded in Payload with the codec
+// of the request. Workers stream results in several chunks; the last one has Done
+// set, or Error if the task failed. Shuffle map tasks report the record count of
+// every bucket in BucketSizes, and its size before and after compression in
+// RawBucketBytes and BucketBytes. The final chunk carries the task's accumulator
+// updates, which the driver merges only if the task succeeded.
+type TaskResult struct {
+	TaskID         int64   `json:"taskId"`
+	Payload        []byte  `json:"payload,omitempty"`
+	BucketSizes    []int64 `json:"bucketSizes,omitempty"`
+	BucketBytes    []int64 `json:"bucketBytes,omitempty"`
+	RawBucketBytes []int64 `json:"rawBucketBytes,omitempty"`
+	Done           bool    `json:"done,omitempty"`
+	Error          string  `json:"error,omitempty"`
+
+	Accumulators []accumulator.Update `json:"accumulators,omitempty"`
+}
+
+// Message is the envelope for everything sent over the wire, one JSON object per line
+type Message struct {
+	Version     int             `json:"version"`
+	Type        string          `json:"type"`
+	WorkerID    string          `json:"workerId,omitempty"`
+	Address     string          `json:"address,omitempty"`
+	Reason      string          `json:"reason,omitempty"`
+	Task        *TaskRequest    `json:"task,omitempty"`
+	Result      *TaskResult     `json:"result,omitempty"`
+	Fetch       *FetchRequest   `json:"fetch,omitempty"`
+	ShuffleID   int             `json:"shuffleId,omitempty"`
+	Running     int             `json:"running,omitempty"`
+	Broadcast   *BroadcastPiece `json:"broadcast,omitempty"`
+	BroadcastID int64           `json:"broadcastId,omitempty"`
+}
+
+// ErrVersionMismatch is returned when a peer speaks a different protocol version
+var ErrVersionMismatch = errors.New("protocol version mismatch")
+
+// conn wraps a network connection with a line-delimited JSON encoder and decoder.
+// Writes are serialized so several goroutines can send on the same connection.
+type conn struct {
+	raw net.Conn
+	dec *json.Decoder
+
+	mu  sync.Mutex
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func newConn(raw net.Conn) *conn {
+	buf := bufio.NewWriter(raw)
+	return &conn{
+		raw: raw,
+		dec: json.NewDecoder(bufio.NewReader(raw)),
+		buf: buf,
+		enc: json.NewEncoder(buf),
+	}
+}
+
+func (c *conn) send(msg Message) error {
+	msg.Version = ProtocolVersion
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(msg); err != nil {
+		return err
+	}
+	return c.buf.Flush()
+}
+
+func (c *conn) receive() (Message, error) {
+	var msg Message
+	if err := c.dec.Decode(&msg); err != nil {
+		if err == io.EOF {
+			return msg, err
+		}
+		return msg, fmt.Errorf("decode message: %w", err)
+	}
+	if msg.Version != ProtocolVersion {
+		return msg, fmt.Errorf("%w: got %d, want %d", ErrVersionMismatch, msg.Version, ProtocolVersion)
+	}
+	return msg, nil
+}
+
+func (c *conn) close() error {
+	return c.raw.Close()
+}
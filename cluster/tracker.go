@@ -0,0 +1,171 @@
+package cluster
+
+import (
+	"sort"
+	"sync"
+)
+
+// MapStatus records where one map task's shuffle output lives and how big each bucket is:
+// BucketSizes counts records, BucketBytes and RawBucketBytes the stored block sizes
+// after and before compression
+type MapStatus struct {
+	WorkerID       string
+	BucketSizes    []int64
+	BucketBytes    []int64
+	RawBucketBytes []int64
+}
+
+// ShuffleMetrics summarizes the blocks written by one shuffle
+type ShuffleMetrics struct {
+	ShuffleID       int
+	Compression     string
+	Records         int64
+	RawBytes        int64
+	CompressedBytes int64
+	// CorruptBlocks counts fetched blocks that failed their checksum and were recomputed
+	CorruptBlocks int
+}
+
+// Ratio returns compressed bytes per raw byte, or 1 for an empty shuffle
+func (m ShuffleMetrics) Ratio() float64 {
+	if m.RawBytes == 0 {
+		return 1
+	}
+	return float64(m.CompressedBytes) / float64(m.RawBytes)
+}
+
+// MapOutputTracker keeps the location of every registered shuffle map output. Outputs
+// held by a lost worker are dropped so the map tasks producing them get recomputed.
+type MapOutputTracker struct {
+	mu       sync.Mutex
+	shuffles map[int]map[int]MapStatus
+	// completed remembers map tasks that finished at least once, even if their
+	// output was lost since
+	completed map[int]map[int]bool
+}
+
+// NewMapOutputTracker creates an empty tracker
+func NewMapOutputTracker() *MapOutputTracker {
+	return &MapOutputTracker{shuffles: make(map[int]map[int]MapStatus), completed: make(map[int]map[int]bool)}
+}
+
+// Register records the output of a finished map task. It reports whether the task
+// completed for the first time, as opposed to being recomputed after a lost output.
+func (t *MapOutputTracker) Register(shuffleID, mapID int, status MapStatus) (first bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	outputs, ok := t.shuffles[shuffleID]
+	if !ok {
+		outputs = make(map[int]MapStatus)
+		t.shuffles[shuffleID] = outputs
+	}
+	outputs[mapID] = status
+	completed, ok := t.completed[shuffleID]
+	if !ok {
+		completed = make(map[int]bool)
+		t.completed[shuffleID] = completed
+	}
+	first = !completed[mapID]
+	completed[mapID] = true
+	return first
+}
+
+// Unregister forgets one map output, e.g. after a fetch from it failed
+func (t *MapOutputTracker) Unregister(shuffleID, mapID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.shuffles[shuffleID], mapID)
+}
+
+// Status returns the output of a map task if it is still available
+func (t *MapOutputTracker) Status(shuffleID, mapID int) (MapStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status, ok := t.shuffles[shuffleID][mapID]
+	return status, ok
+}
+
+// Missing returns the map ids in [0, numMaps) that have no registered output
+func (t *MapOutputTracker) Missing(shuffleID, numMaps int) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var missing []int
+	for m := 0; m < numMaps; m++ {
+		if _, ok := t.shuffles[shuffleID][m]; !ok {
+			missing = append(missing, m)
+		}
+	}
+	return missing
+}
+
+// BucketSizes sums the sizes of every bucket across the registered map outputs
+func (t *MapOutputTracker) BucketSizes(shuffleID int) []int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var sizes []int64
+	for _, status := range t.shuffles[shuffleID] {
+		for b, size := range status.BucketSizes {
+			for len(sizes) <= b {
+				sizes = append(sizes, 0)
+			}
+			sizes[b] += size
+		}
+	}
+	return sizes
+}
+
+// Metrics totals the record and byte counts of the registered outputs of a shuffle
+func (t *MapOutputTracker) Metrics(shuffleID int) ShuffleMetrics {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := ShuffleMetrics{ShuffleID: shuffleID}
+	for _, status := range t.shuffles[shuffleID] {
+		m.Records += sum(status.BucketSizes)
+		m.CompressedBytes += sum(status.BucketBytes)
+		m.RawBytes += sum(status.RawBucketBytes)
+	}
+	return m
+}
+
+func sum(values []int64) int64 {
+	total := int64(0)
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// RemoveWorker drops every output held by a worker and returns how many were lost
+func (t *MapOutputTracker) RemoveWorker(workerID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lost := 0
+	for _, outputs := range t.shuffles {
+		for mapID, status := range outputs {
+			if status.WorkerID == workerID {
+				delete(outputs, mapID)
+				lost++
+			}
+		}
+	}
+	return lost
+}
+
+// RemoveShuffle forgets a shuffle once the job using it is done and returns the
+// workers that held its outputs
+func (t *MapOutputTracker) RemoveShuffle(shuffleID int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.completed, shuffleID)
+	seen := make(map[string]bool)
+	for _, status := range t.shuffles[shuffleID] {
+		seen[status.WorkerID] = true
+	}
+	delete(t.shuffles, shuffleID)
+	workers := make([]string, 0, len(seen))
+	for id := range seen {
+		workers = append(workers, id)
+	}
+	sort.Strings(workers)
+	return workers
+}
@@ -0,0 +1,26 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMapOutputTracker_RemoveWorker(t *testing.T) {
+	tracker := NewMapOutputTracker()
+	tracker.Register(1, 0, MapStatus{WorkerID: "w1", BucketSizes: []int64{1, 2}})
+	tracker.Register(1, 1, MapStatus{WorkerID: "w2", BucketSizes: []int64{3, 4}})
+	tracker.Register(2, 0, MapStatus{WorkerID: "w1", BucketSizes: []int64{5}})
+
+	if got := tracker.BucketSizes(1); !reflect.DeepEqual(got, []int64{4, 6}) {
+		t.Errorf("BucketSizes failed: got %v", got)
+	}
+	if removed := tracker.RemoveWorker("w1"); removed != 2 {
+		t.Errorf("RemoveWorker failed: removed %d outputs, want 2", removed)
+	}
+	if missing := tracker.Missing(1, 2); !reflect.DeepEqual(missing, []int{0}) {
+		t.Errorf("Missing failed: got %v, want [0]", missing)
+	}
+	if holders := tracker.RemoveShuffle(1); !reflect.DeepEqual(holders, []string{"w2"}) {
+		t.Errorf("RemoveShuffle failed: got %v, want [w2]", holders)
+	}
+}
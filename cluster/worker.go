@@ -0,0 +1,356 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bajor/spark-go-core/accumulator"
+	"github.com/bajor/spark-go-core/broadcast"
+	"github.com/bajor/spark-go-core/codec"
+	"github.com/bajor/spark-go-core/compression"
+	"github.com/bajor/spark-go-core/operations"
+	"github.com/bajor/spark-go-core/registry"
+	"github.com/bajor/spark-go-core/storage"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// DefaultChunkSize is the number of records a worker sends per result message
+const DefaultChunkSize = 1024
+
+// DefaultHeartbeatInterval is how often a worker tells the driver it is alive
+const DefaultHeartbeatInterval = time.Second
+
+// Executor runs a chain of operations on the data of one partition
+type Executor interface {
+	Execute(ctx context.Context, data []interface{}, ops []types.OperationSpec) ([]interface{}, error)
+}
+
+// OperationFunc applies a named operation with its decoded arguments to a partition
+type OperationFunc func(args json.RawMessage, data []interface{}) ([]interface{}, error)
+
+// Operations is an Executor backed by a fixed table of named operations
+type Operations map[string]OperationFunc
+
+// Execute applies every operation in order, failing on names missing from the table
+func (o Operations) Execute(ctx context.Context, data []interface{}, ops []types.OperationSpec) ([]interface{}, error) {
+	current := data
+	for _, spec := range ops {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fn, ok := o[spec.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown operation %q", spec.Name)
+		}
+		result, err := fn(spec.Args, current)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", spec.Name, err)
+		}
+		current = result
+	}
+	return current, nil
+}
+
+// WorkerConfig configures a Worker
+type WorkerConfig struct {
+	// ID identifies the worker in the driver's membership list, defaults to hostname-pid
+	ID string
+	// DriverAddr is the host:port of the driver
+	DriverAddr string
+	// Executor runs the operations of incoming tasks
+	Executor Executor
+	// ChunkSize is the number of records per streamed result message
+	ChunkSize int
+	// HeartbeatInterval is the time between heartbeats, keep it well below the driver's timeout
+	HeartbeatInterval time.Duration
+	// Logger receives connection and task events, defaults to the standard logger
+	Logger *log.Logger
+}
+
+// Worker connects to a driver, registers itself and runs the tasks it is sent
+type Worker struct {
+	config  WorkerConfig
+	running atomic.Int64
+
+	// blocks holds shuffle output until the driver removes the shuffle, and the
+	// pieces of broadcasts until they are destroyed
+	blocks *storage.BlockManager
+}
+
+// NewWorker creates a Worker; call Run to connect to the driver
+func NewWorker(config WorkerConfig) *Worker {
+	if config.ID == "" {
+		host, _ := os.Hostname()
+		config.ID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = DefaultChunkSize
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if config.Logger == nil {
+		config.Logger = log.Default()
+	}
+	return &Worker{config: config, blocks: storage.NewBlockManager()}
+}
+
+// ID returns the identifier the worker registers with
+func (w *Worker) ID() string {
+	return w.config.ID
+}
+
+// Run connects to the driver and serves tasks until ctx is cancelled or the driver goes away
+func (w *Worker) Run(ctx context.Context) error {
+	if w.config.Executor == nil {
+		return errors.New("worker has no executor")
+	}
+
+	var dialer net.Dialer
+	raw, err := dialer.DialContext(ctx, "tcp", w.config.DriverAddr)
+	if err != nil {
+		return fmt.Errorf("connect to driver: %w", err)
+	}
+	c := newConn(raw)
+	defer c.close()
+
+	if err := c.send(Message{Type: MsgRegister, WorkerID: w.config.ID, Address: raw.LocalAddr().String()}); err != nil {
+		return fmt.Errorf("register: %w", err)
+	}
+	reply, err := c.receive()
+	if err != nil {
+		return fmt.Errorf("register: %w", err)
+	}
+	if reply.Type != MsgRegistered {
+		return fmt.Errorf("driver rejected worker %s: %s", w.config.ID, reply.Reason)
+	}
+	w.config.Logger.Printf("worker %s registered with driver %s", w.config.ID, w.config.DriverAddr)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		c.close()
+	}()
+	go w.heartbeat(ctx, c)
+
+	var tasks sync.WaitGroup
+	defer tasks.Wait()
+	for {
+		msg, err := c.receive()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("connection to driver lost: %w", err)
+		}
+		switch {
+		case msg.Type == MsgTask && msg.Task != nil:
+			tasks.Add(1)
+			w.running.Add(1)
+			go func(task *TaskRequest) {
+				defer tasks.Done()
+				defer w.running.Add(-1)
+				w.runTask(ctx, c, task)
+			}(msg.Task)
+		case msg.Type == MsgFetch && msg.Fetch != nil:
+			tasks.Add(1)
+			go func(fetch *FetchRequest) {
+				defer tasks.Done()
+				w.serveFetch(c, fetch)
+			}(msg.Fetch)
+		case msg.Type == MsgRemove:
+			w.blocks.RemovePrefix(storage.ShufflePrefix(msg.ShuffleID))
+		case msg.Type == MsgBroadcast && msg.Broadcast != nil:
+			// handled inline so the value is installed before the task that follows
+			w.receiveBroadcast(msg.Broadcast)
+		case msg.Type == MsgDestroy:
+			w.blocks.RemovePrefix(storage.BroadcastPrefix(msg.BroadcastID))
+			broadcast.Uninstall(msg.BroadcastID)
+		}
+	}
+}
+
+// heartbeat reports liveness and the number of running tasks until ctx is cancelled
+func (w *Worker) heartbeat(ctx context.Context, c *conn) {
+	ticker := time.NewTicker(w.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.send(Message{Type: MsgHeartbeat, WorkerID: w.config.ID, Running: int(w.running.Load())}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// runTask executes a task and streams its output back in chunks. Reduce tasks group
+// their fetched input first; map tasks keep their output as shuffle blocks and only
+// report bucket sizes. Accumulator updates made by the operations travel with the
+// final chunk.
+func (w *Worker) runTask(ctx context.Context, c *conn, task *TaskRequest) {
+	updates := accumulator.NewUpdates()
+	enc, records, err := w.execute(accumulator.WithUpdates(ctx, updates), task)
+	var exported []accumulator.Update
+	if err == nil {
+		exported, err = updates.Export()
+	}
+	if err != nil {
+		c.send(Message{Type: MsgResult, Result: &TaskResult{TaskID: task.TaskID, Error: err.Error()}})
+		return
+	}
+	if task.Write != nil {
+		res, err := w.writeShuffle(enc, task.Write, records)
+		if err != nil {
+			res = &TaskResult{Error: err.Error()}
+		} else {
+			res.Accumulators = exported
+		}
+		res.TaskID = task.TaskID
+		c.send(Message{Type: MsgResult, Result: res})
+		return
+	}
+	w.stream(c, enc, task.TaskID, records, exported)
+}
+
+// execute decodes the task input and runs the reduce and the operations on it
+func (w *Worker) execute(ctx context.Context, task *TaskRequest) (codec.Codec, []interface{}, error) {
+	enc, err := codec.Lookup(task.Codec)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := make([]interface{}, 0)
+	if len(task.Payload) > 0 {
+		if data, err = codec.Unmarshal(enc, task.Payload); err != nil {
+			return nil, nil, err
+		}
+	}
+	if task.Reduce != nil {
+		if data, err = registry.ReduceByKey(data, task.Reduce.Key, task.Reduce.Reduce); err != nil {
+			return nil, nil, fmt.Errorf("reduce: %w", err)
+		}
+	}
+	records, err := w.config.Executor.Execute(ctx, data, task.Operations)
+	return enc, records, err
+}
+
+// writeShuffle hash-partitions records by key into encoded, compressed blocks held by
+// this worker and returns the sizes of every bucket
+func (w *Worker) writeShuffle(enc codec.Codec, write *ShuffleWrite, records []interface{}) (*TaskResult, error) {
+	compressor, err := compression.Lookup(write.Compression)
+	if err != nil {
+		return nil, err
+	}
+	keyOp, err := registry.Build(write.Key)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := registry.Keys(keyOp, records)
+	if err != nil {
+		return nil, err
+	}
+	buckets := make([][]interface{}, write.NumBuckets)
+	for i, record := range records {
+		b := operations.HashKey(keys[i]) % uint64(write.NumBuckets)
+		buckets[b] = append(buckets[b], record)
+	}
+
+	res := &TaskResult{
+		BucketSizes:    make([]int64, write.NumBuckets),
+		BucketBytes:    make([]int64, write.NumBuckets),
+		RawBucketBytes: make([]int64, write.NumBuckets),
+		Done:           true,
+	}
+	sealed := make([][]byte, write.NumBuckets)
+	for b, bucket := range buckets {
+		raw, err := codec.Marshal(enc, bucket)
+		if err != nil {
+			return nil, err
+		}
+		if sealed[b], err = compression.Seal(compressor, raw); err != nil {
+			return nil, err
+		}
+		res.BucketSizes[b] = int64(len(bucket))
+		res.RawBucketBytes[b] = int64(len(raw))
+		res.BucketBytes[b] = int64(len(sealed[b]))
+	}
+	for b, block := range sealed {
+		if err := w.blocks.Put(storage.ShuffleBlockID(write.ShuffleID, write.MapID, b), block); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// serveFetch sends a sealed shuffle block back to the driver as is; the driver
+// verifies its checksum
+func (w *Worker) serveFetch(c *conn, fetch *FetchRequest) {
+	block, ok := w.blocks.Get(storage.ShuffleBlockID(fetch.Block.ShuffleID, fetch.Block.MapID, fetch.Block.Bucket))
+	res := &TaskResult{TaskID: fetch.RequestID, Payload: block, Done: true}
+	if !ok {
+		res = &TaskResult{TaskID: fetch.RequestID, Error: fmt.Sprintf("block %+v not found", fetch.Block)}
+	}
+	c.send(Message{Type: MsgResult, Result: res})
+}
+
+// receiveBroadcast stores a broadcast piece and installs the value once the last one arrived
+func (w *Worker) receiveBroadcast(piece *BroadcastPiece) {
+	if err := w.blocks.Put(storage.BroadcastPieceID(piece.ID, piece.Index), piece.Data); err != nil {
+		w.config.Logger.Printf("broadcast %d: %v", piece.ID, err)
+		return
+	}
+	if piece.Index != piece.Total-1 {
+		return
+	}
+	pieces := make([][]byte, piece.Total)
+	for i := range pieces {
+		data, ok := w.blocks.Get(storage.BroadcastPieceID(piece.ID, i))
+		if !ok {
+			w.config.Logger.Printf("broadcast %d: piece %d is missing", piece.ID, i)
+			return
+		}
+		pieces[i] = data
+	}
+	enc, err := codec.Lookup(piece.Codec)
+	if err == nil {
+		var value interface{}
+		if value, err = broadcast.Decode(enc, pieces); err == nil {
+			broadcast.Install(piece.ID, value)
+			return
+		}
+	}
+	w.config.Logger.Printf("broadcast %d: %v", piece.ID, err)
+}
+
+// stream sends records in encoded chunks, the last one marked Done and carrying updates
+func (w *Worker) stream(c *conn, enc codec.Codec, id int64, records []interface{}, updates []accumulator.Update) {
+	for start := 0; ; start += w.config.ChunkSize {
+		end := start + w.config.ChunkSize
+		if end >= len(records) {
+			end = len(records)
+		}
+		payload, err := codec.Marshal(enc, records[start:end])
+		if err != nil {
+			c.send(Message{Type: MsgResult, Result: &TaskResult{TaskID: id, Error: err.Error()}})
+			return
+		}
+		res := &TaskResult{TaskID: id, Payload: payload, Done: end == len(records)}
+		if res.Done {
+			res.Accumulators = updates
+		}
+		if err := c.send(Message{Type: MsgResult, Result: res}); err != nil || res.Done {
+			return
+		}
+	}
+}
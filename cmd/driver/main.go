@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bajor/spark-go-core/cluster"
+	"github.com/bajor/spark-go-core/cmd/internal/demo"
+	"github.com/bajor/spark-go-core/codec"
+	"github.com/bajor/spark-go-core/compression"
+	"github.com/bajor/spark-go-core/rdd"
+)
+
+func main() {
+	port := flag.Int("port", 7077, "port to accept worker connections on")
+	workers := flag.Int("workers", 2, "number of workers to wait for before running the demo job")
+	partitions := flag.Int("partitions", 4, "number of partitions to split the demo data into")
+	codecName := flag.String("codec", codec.Default, "record codec for the demo job: "+strings.Join(codec.Names(), ", "))
+	compressionName := flag.String("compression", compression.Default, "shuffle block compression: "+strings.Join(compression.Names(), ", "))
+	wait := flag.Duration("wait", time.Minute, "how long to wait for workers to register")
+	flag.Parse()
+
+	driver := cluster.NewDriver(cluster.DriverConfig{})
+	if err := driver.Listen(fmt.Sprintf(":%d", *port)); err != nil {
+		log.Fatalf("driver: %v", err)
+	}
+	defer driver.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *wait)
+	defer cancel()
+	if err := driver.WaitForWorkers(ctx, *workers); err != nil {
+		log.Fatalf("driver: %v", err)
+	}
+	for _, w := range driver.Workers() {
+		log.Printf("member %s at %s", w.ID, w.Address)
+	}
+
+	data := make([]interface{}, 0, 12)
+	for i := 1; i <= 12; i++ {
+		data = append(data, i)
+	}
+	job := rdd.NewKeyedRDD(data, func(i interface{}) (interface{}, error) { return i, nil }).
+		Repartition(*partitions).
+		MapNamed("multiply", demo.MultiplyArgs{Factor: 2}).
+		FilterNamed("greaterThan", demo.GreaterThanArgs{Value: 4})
+
+	result, err := driver.SubmitJob(context.Background(), job, cluster.JobConfig{Codec: *codecName, Compression: *compressionName})
+	if err != nil {
+		log.Fatalf("driver: %v", err)
+	}
+	fmt.Println("Result:", result)
+}
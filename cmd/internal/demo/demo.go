@@ -0,0 +1,24 @@
+// Package demo registers the operations used by the example driver job. Both the
+// driver and the worker binaries import it so the names resolve on either side.
+package demo
+
+import "github.com/bajor/spark-go-core/registry"
+
+// MultiplyArgs are the arguments of the "multiply" operation
+type MultiplyArgs struct {
+	Factor float64 `json:"factor"`
+}
+
+// GreaterThanArgs are the arguments of the "greaterThan" operation
+type GreaterThanArgs struct {
+	Value float64 `json:"value"`
+}
+
+func init() {
+	registry.RegisterMapFunc("multiply", func(args MultiplyArgs, in float64) (float64, error) {
+		return in * args.Factor, nil
+	})
+	registry.RegisterFilterFunc("greaterThan", func(args GreaterThanArgs, in float64) bool {
+		return in > args.Value
+	})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/bajor/spark-go-core/cluster"
+	_ "github.com/bajor/spark-go-core/cmd/internal/demo"
+	"github.com/bajor/spark-go-core/registry"
+)
+
+func main() {
+	driverAddr := flag.String("driver", "localhost:7077", "address of the driver")
+	id := flag.String("id", "", "worker id, defaults to hostname-pid")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	worker := cluster.NewWorker(cluster.WorkerConfig{
+		ID:         *id,
+		DriverAddr: *driverAddr,
+		Executor:   registry.Executor{},
+	})
+	if err := worker.Run(ctx); err != nil {
+		log.Fatalf("worker %s: %v", worker.ID(), err)
+	}
+}
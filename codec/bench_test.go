@@ -0,0 +1,134 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+type benchEvent struct {
+	ID      int64
+	User    string
+	Country string
+	Amount  float64
+	Tags    []string
+}
+
+func init() {
+	Register(benchEvent{})
+}
+
+// benchShapes are typical record shapes: scalars, key/value pairs, rows as maps and user structs
+func benchShapes() map[string][]interface{} {
+	const n = 1000
+	shapes := map[string][]interface{}{}
+	add := func(name string, f func(i int) interface{}) {
+		records := make([]interface{}, n)
+		for i := range records {
+			records[i] = f(i)
+		}
+		shapes[name] = records
+	}
+	add("ints", func(i int) interface{} { return i })
+	add("floats", func(i int) interface{} { return float64(i) * 1.25 })
+	add("strings", func(i int) interface{} { return fmt.Sprintf("record-%06d", i) })
+	add("pairs", func(i int) interface{} { return []interface{}{fmt.Sprintf("key-%d", i%50), i} })
+	add("maps", func(i int) interface{} {
+		return map[string]interface{}{"id": i, "name": fmt.Sprintf("user-%d", i), "score": float64(i) / 3}
+	})
+	add("structs", func(i int) interface{} {
+		return benchEvent{ID: int64(i), User: fmt.Sprintf("user-%d", i), Country: "PL", Amount: float64(i) / 7, Tags: []string{"a", "b"}}
+	})
+	return shapes
+}
+
+var benchOrder = []string{"ints", "floats", "strings", "pairs", "maps", "structs"}
+
+func BenchmarkEncode(b *testing.B) {
+	shapes := benchShapes()
+	for _, name := range Names() {
+		c, _ := Lookup(name)
+		for _, shape := range benchOrder {
+			records := shapes[shape]
+			b.Run(name+"/"+shape, func(b *testing.B) {
+				data, _ := Marshal(c, records)
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := Marshal(c, records); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	shapes := benchShapes()
+	for _, name := range Names() {
+		c, _ := Lookup(name)
+		for _, shape := range benchOrder {
+			data, err := Marshal(c, shapes[shape])
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(name+"/"+shape, func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := Unmarshal(c, data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// encodeJSONUnescaped is the JSON encoder before top-level "$" keys were escaped,
+// to compare the cost of escaping against
+func encodeJSONUnescaped(records []interface{}) ([]byte, error) {
+	out := make([]interface{}, len(records))
+	for i, record := range records {
+		out[i] = record
+		if name, ok := TypeName(record); ok {
+			value, err := json.Marshal(record)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = typedJSON{Type: name, Value: value}
+		}
+	}
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(out)
+	return buf.Bytes(), err
+}
+
+func BenchmarkJSONEncode(b *testing.B) {
+	shapes := benchShapes()
+	c, _ := Lookup(JSON)
+	encoders := []struct {
+		name   string
+		encode func([]interface{}) ([]byte, error)
+	}{
+		{"escaped", func(records []interface{}) ([]byte, error) { return Marshal(c, records) }},
+		{"unescaped", encodeJSONUnescaped},
+	}
+	for _, shape := range benchOrder {
+		records := shapes[shape]
+		for _, e := range encoders {
+			b.Run(shape+"/"+e.name, func(b *testing.B) {
+				data, _ := e.encode(records)
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := e.encode(records); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
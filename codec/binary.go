@@ -0,0 +1,577 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+// binaryCodec is a compact tagged binary format. Common values get a one byte tag
+// followed by a varint or raw payload; registered types are written field by field
+// after their name, which is sent only once per batch.
+type binaryCodec struct{}
+
+const (
+	tagNil byte = iota
+	tagFalse
+	tagTrue
+	tagInt
+	tagInt8
+	tagInt16
+	tagInt32
+	tagInt64
+	tagUint
+	tagUint8
+	tagUint16
+	tagUint32
+	tagUint64
+	tagFloat32
+	tagFloat64
+	tagString
+	tagBytes
+	tagList
+	tagMap
+	tagTyped
+)
+
+var errTruncated = errors.New("truncated input")
+
+func (binaryCodec) Name() string { return Binary }
+
+func (binaryCodec) Encode(w io.Writer, records []interface{}) error {
+	e := &binaryEncoder{types: make(map[reflect.Type]uint64)}
+	e.uvarint(uint64(len(records)))
+	for i, record := range records {
+		if err := e.value(record); err != nil {
+			return fmt.Errorf("record %d: %w", i, err)
+		}
+	}
+	_, err := w.Write(e.buf)
+	return err
+}
+
+func (binaryCodec) Decode(r io.Reader) ([]interface{}, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	d := &binaryDecoder{data: data}
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]interface{}, n)
+	for i := range records {
+		if records[i], err = d.value(); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("%d trailing bytes", len(d.data)-d.pos)
+	}
+	return records, nil
+}
+
+type binaryEncoder struct {
+	buf   []byte
+	types map[reflect.Type]uint64
+}
+
+func (e *binaryEncoder) uvarint(v uint64) { e.buf = binary.AppendUvarint(e.buf, v) }
+func (e *binaryEncoder) varint(v int64)   { e.buf = binary.AppendVarint(e.buf, v) }
+
+func (e *binaryEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *binaryEncoder) tagged(tag byte, v int64) {
+	e.buf = append(e.buf, tag)
+	e.varint(v)
+}
+
+func (e *binaryEncoder) taggedUnsigned(tag byte, v uint64) {
+	e.buf = append(e.buf, tag)
+	e.uvarint(v)
+}
+
+// value writes a self-describing value
+func (e *binaryEncoder) value(v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		e.buf = append(e.buf, tagNil)
+	case bool:
+		if x {
+			e.buf = append(e.buf, tagTrue)
+		} else {
+			e.buf = append(e.buf, tagFalse)
+		}
+	case int:
+		e.tagged(tagInt, int64(x))
+	case int8:
+		e.tagged(tagInt8, int64(x))
+	case int16:
+		e.tagged(tagInt16, int64(x))
+	case int32:
+		e.tagged(tagInt32, int64(x))
+	case int64:
+		e.tagged(tagInt64, x)
+	case uint:
+		e.taggedUnsigned(tagUint, uint64(x))
+	case uint8:
+		e.taggedUnsigned(tagUint8, uint64(x))
+	case uint16:
+		e.taggedUnsigned(tagUint16, uint64(x))
+	case uint32:
+		e.taggedUnsigned(tagUint32, uint64(x))
+	case uint64:
+		e.taggedUnsigned(tagUint64, x)
+	case float32:
+		e.buf = binary.LittleEndian.AppendUint32(append(e.buf, tagFloat32), math.Float32bits(x))
+	case float64:
+		e.buf = binary.LittleEndian.AppendUint64(append(e.buf, tagFloat64), math.Float64bits(x))
+	case string:
+		e.buf = append(e.buf, tagString)
+		e.string(x)
+	case []byte:
+		e.buf = append(e.buf, tagBytes)
+		e.uvarint(uint64(len(x)))
+		e.buf = append(e.buf, x...)
+	case []interface{}:
+		e.buf = append(e.buf, tagList)
+		e.uvarint(uint64(len(x)))
+		for _, item := range x {
+			if err := e.value(item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		e.buf = append(e.buf, tagMap)
+		e.uvarint(uint64(len(x)))
+		for k, item := range x {
+			e.string(k)
+			if err := e.value(item); err != nil {
+				return err
+			}
+		}
+	default:
+		name, ok := TypeName(v)
+		if !ok {
+			return fmt.Errorf("type %T is not registered with codec.Register", v)
+		}
+		t := reflect.TypeOf(v)
+		e.buf = append(e.buf, tagTyped)
+		if id, seen := e.types[t]; seen {
+			e.uvarint(id)
+		} else {
+			id = uint64(len(e.types))
+			e.types[t] = id
+			e.uvarint(id)
+			e.string(name)
+		}
+		return e.reflect(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+// reflect writes a value of a statically known type without tags
+func (e *binaryEncoder) reflect(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.varint(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.uvarint(v.Uint())
+	case reflect.Float32:
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.string(v.String())
+	case reflect.Slice:
+		// length is stored plus one so nil and empty slices stay distinct
+		if v.IsNil() {
+			e.uvarint(0)
+			return nil
+		}
+		e.uvarint(uint64(v.Len()) + 1)
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.buf = append(e.buf, v.Bytes()...)
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := e.reflect(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := e.reflect(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.uvarint(0)
+			return nil
+		}
+		e.uvarint(uint64(v.Len()) + 1)
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.reflect(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.reflect(iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			if err := e.reflect(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0)
+			return nil
+		}
+		e.buf = append(e.buf, 1)
+		return e.reflect(v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			return e.value(nil)
+		}
+		return e.value(v.Elem().Interface())
+	default:
+		return fmt.Errorf("cannot encode %v values", v.Type())
+	}
+	return nil
+}
+
+type binaryDecoder struct {
+	data  []byte
+	pos   int
+	types []reflect.Type
+}
+
+func (d *binaryDecoder) byte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, errTruncated
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *binaryDecoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.pos += n
+	return v, nil
+}
+
+func (d *binaryDecoder) varint() (int64, error) {
+	v, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.pos += n
+	return v, nil
+}
+
+// length reads a count, rejecting counts that cannot fit in the remaining input
+func (d *binaryDecoder) length() (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.data)-d.pos) {
+		return 0, fmt.Errorf("length %d exceeds input: %w", n, errTruncated)
+	}
+	return int(n), nil
+}
+
+func (d *binaryDecoder) bytes(n int) ([]byte, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *binaryDecoder) string() (string, error) {
+	n, err := d.length()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.bytes(n)
+	return string(b), err
+}
+
+func (d *binaryDecoder) fixed(n int) (uint64, error) {
+	b, err := d.bytes(n)
+	if err != nil {
+		return 0, err
+	}
+	if n == 4 {
+		return uint64(binary.LittleEndian.Uint32(b)), nil
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// value reads a self-describing value
+func (d *binaryDecoder) value() (interface{}, error) {
+	tag, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case tagNil:
+		return nil, nil
+	case tagFalse:
+		return false, nil
+	case tagTrue:
+		return true, nil
+	case tagInt, tagInt8, tagInt16, tagInt32, tagInt64:
+		v, err := d.varint()
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case tagInt:
+			return int(v), nil
+		case tagInt8:
+			return int8(v), nil
+		case tagInt16:
+			return int16(v), nil
+		case tagInt32:
+			return int32(v), nil
+		}
+		return v, nil
+	case tagUint, tagUint8, tagUint16, tagUint32, tagUint64:
+		v, err := d.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case tagUint:
+			return uint(v), nil
+		case tagUint8:
+			return uint8(v), nil
+		case tagUint16:
+			return uint16(v), nil
+		case tagUint32:
+			return uint32(v), nil
+		}
+		return v, nil
+	case tagFloat32:
+		bits, err := d.fixed(4)
+		return math.Float32frombits(uint32(bits)), err
+	case tagFloat64:
+		bits, err := d.fixed(8)
+		return math.Float64frombits(bits), err
+	case tagString:
+		return d.string()
+	case tagBytes:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.bytes(n)
+		return append([]byte(nil), b...), err
+	case tagList:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			if list[i], err = d.value(); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case tagMap:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			if m[k], err = d.value(); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case tagTyped:
+		t, err := d.typ()
+		if err != nil {
+			return nil, err
+		}
+		v := reflect.New(t).Elem()
+		if err := d.reflect(v); err != nil {
+			return nil, fmt.Errorf("%v: %w", t, err)
+		}
+		return v.Interface(), nil
+	}
+	return nil, fmt.Errorf("unknown tag %d", tag)
+}
+
+// typ reads a type reference, either an index into the batch's table or a new name
+func (d *binaryDecoder) typ() (reflect.Type, error) {
+	id, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if id < uint64(len(d.types)) {
+		return d.types[id], nil
+	}
+	if id != uint64(len(d.types)) {
+		return nil, fmt.Errorf("unknown type reference %d", id)
+	}
+	name, err := d.string()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := typeByName(name)
+	if !ok {
+		return nil, fmt.Errorf("type %q is not registered", name)
+	}
+	d.types = append(d.types, t)
+	return t, nil
+}
+
+// reflect reads a value of a statically known type into v
+func (d *binaryDecoder) reflect(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := d.byte()
+		v.SetBool(b != 0)
+		return err
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := d.varint()
+		v.SetInt(n)
+		return err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := d.uvarint()
+		v.SetUint(n)
+		return err
+	case reflect.Float32:
+		bits, err := d.fixed(4)
+		v.SetFloat(float64(math.Float32frombits(uint32(bits))))
+		return err
+	case reflect.Float64:
+		bits, err := d.fixed(8)
+		v.SetFloat(math.Float64frombits(bits))
+		return err
+	case reflect.String:
+		s, err := d.string()
+		v.SetString(s)
+		return err
+	case reflect.Slice:
+		n, err := d.length()
+		if err != nil || n == 0 {
+			return err
+		}
+		n--
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.bytes(n)
+			if err != nil {
+				return err
+			}
+			s := reflect.MakeSlice(v.Type(), n, n)
+			reflect.Copy(s, reflect.ValueOf(b))
+			v.Set(s)
+			return nil
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := d.reflect(s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := d.reflect(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		n, err := d.length()
+		if err != nil || n == 0 {
+			return err
+		}
+		n--
+		t := v.Type()
+		m := reflect.MakeMapWithSize(t, n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(t.Key()).Elem()
+			if err := d.reflect(key); err != nil {
+				return err
+			}
+			val := reflect.New(t.Elem()).Elem()
+			if err := d.reflect(val); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, val)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			if err := d.reflect(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Pointer:
+		present, err := d.byte()
+		if err != nil || present == 0 {
+			return err
+		}
+		p := reflect.New(v.Type().Elem())
+		if err := d.reflect(p.Elem()); err != nil {
+			return err
+		}
+		v.Set(p)
+	case reflect.Interface:
+		x, err := d.value()
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			return nil
+		}
+		xv := reflect.ValueOf(x)
+		if !xv.Type().AssignableTo(v.Type()) {
+			return fmt.Errorf("cannot assign %T to %v", x, v.Type())
+		}
+		v.Set(xv)
+	default:
+		return fmt.Errorf("cannot decode %v values", v.Type())
+	}
+	return nil
+}
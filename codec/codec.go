@@ -0,0 +1,92 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Names of the built-in codecs
+const (
+	Gob    = "gob"
+	JSON   = "json"
+	Binary = "binary"
+)
+
+// Default is the codec used when a job does not pick one
+const Default = JSON
+
+// Codec turns a batch of records into bytes and back. Records are plain values,
+// []interface{}, map[string]interface{} or types registered with Register.
+type Codec interface {
+	Name() string
+	Encode(w io.Writer, records []interface{}) error
+	Decode(r io.Reader) ([]interface{}, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = make(map[string]Codec)
+)
+
+func init() {
+	RegisterCodec(gobCodec{})
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(binaryCodec{})
+}
+
+// RegisterCodec makes a codec selectable by name and panics on duplicates
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, ok := codecs[c.Name()]; ok {
+		panic(fmt.Sprintf("codec: %q registered twice", c.Name()))
+	}
+	codecs[c.Name()] = c
+}
+
+// Lookup returns the codec with the given name; an empty name selects Default
+func Lookup(name string) (Codec, error) {
+	if name == "" {
+		name = Default
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	return c, nil
+}
+
+// Names returns the names of all registered codecs in sorted order
+func Names() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Marshal encodes records into a byte slice
+func Marshal(c Codec, records []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.Encode(&buf, records); err != nil {
+		return nil, fmt.Errorf("%s encode: %w", c.Name(), err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes records produced by Marshal with the same codec
+func Unmarshal(c Codec, data []byte) ([]interface{}, error) {
+	records, err := c.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s decode: %w", c.Name(), err)
+	}
+	return records, nil
+}
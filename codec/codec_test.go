@@ -0,0 +1,168 @@
+package codec
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testPoint struct {
+	X, Y   int
+	Label  string
+	Tags   []string
+	Attrs  map[string]float64
+	Next   *testPoint
+	Extra  interface{}
+	hidden int
+}
+
+type unregistered struct{ A int }
+
+func init() {
+	Register(testPoint{})
+}
+
+func roundTrip(t *testing.T, c Codec, records []interface{}) []interface{} {
+	t.Helper()
+	data, err := Marshal(c, records)
+	if err != nil {
+		t.Fatalf("%s Marshal failed with error: %v", c.Name(), err)
+	}
+	out, err := Unmarshal(c, data)
+	if err != nil {
+		t.Fatalf("%s Unmarshal failed with error: %v", c.Name(), err)
+	}
+	return out
+}
+
+func TestCodec_LookupBuiltins(t *testing.T) {
+	if got := Names(); !reflect.DeepEqual(got, []string{Binary, Gob, JSON}) {
+		t.Errorf("Names failed: got %v", got)
+	}
+	c, err := Lookup("")
+	if err != nil || c.Name() != Default {
+		t.Errorf("Lookup of empty name should return the default codec, got %v, %v", c, err)
+	}
+	if _, err := Lookup("xml"); err == nil {
+		t.Errorf("Expected unknown codec to be rejected")
+	}
+}
+
+func TestCodec_PreservesTypes(t *testing.T) {
+	point := testPoint{X: 1, Y: -2, Label: "a", Tags: []string{"x"}, Attrs: map[string]float64{"w": 0.5}, Next: &testPoint{X: 3}, Extra: "e"}
+	records := []interface{}{
+		nil, true, 42, int64(-7), uint8(3), 1.5, float32(2.5), "text", []byte("raw"),
+		[]interface{}{1, "two"},
+		map[string]interface{}{"k": 3},
+		point,
+	}
+
+	for _, name := range []string{Binary, Gob} {
+		c, _ := Lookup(name)
+		if got := roundTrip(t, c, records); !reflect.DeepEqual(got, records) {
+			t.Errorf("%s round trip failed:\n got %#v\nwant %#v", name, got, records)
+		}
+	}
+}
+
+func TestCodec_JSONDecodesRegisteredTypes(t *testing.T) {
+	c, _ := Lookup(JSON)
+	point := testPoint{X: 1, Label: "a"}
+	got := roundTrip(t, c, []interface{}{point, 3, "s"})
+
+	expected := []interface{}{point, 3.0, "s"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("JSON round trip failed: got %#v, want %#v", got, expected)
+	}
+}
+
+func TestCodec_JSONKeepsDollarKeys(t *testing.T) {
+	c, _ := Lookup(JSON)
+	records := []interface{}{
+		map[string]interface{}{"$type": "testPoint", "value": "v"},
+		map[string]interface{}{"$type": "unknown"},
+		map[string]interface{}{"$$x": "y", "$": 1.0, "a$": true},
+		map[string]interface{}{"nested": map[string]interface{}{"$type": "n"}},
+	}
+	if got := roundTrip(t, c, records); !reflect.DeepEqual(got, records) {
+		t.Errorf("JSON round trip failed:\n got %#v\nwant %#v", got, records)
+	}
+	if _, err := Unmarshal(c, []byte(`[{"$type": "testPoint", "value": {}, "extra": 1}]`)); err == nil {
+		t.Errorf("Expected an envelope with extra keys to be refused")
+	}
+
+	// keys found through struct tags, embedded structs, pointers and marshalers
+	type tagged struct {
+		Type  string `json:"$type"`
+		Value string `json:"value"`
+	}
+	type embedding struct {
+		*tagged
+		Other int `json:"other"`
+	}
+	typed := []interface{}{
+		tagged{Type: "testPoint", Value: "v"},
+		&embedding{tagged: &tagged{Type: "testPoint"}, Other: 1},
+		map[string]string{"$type": "testPoint", "value": "v"},
+		json.RawMessage(`{"$type": "testPoint", "value": {}}`),
+	}
+	want := []interface{}{
+		map[string]interface{}{"$type": "testPoint", "value": "v"},
+		map[string]interface{}{"$type": "testPoint", "value": "", "other": 1.0},
+		map[string]interface{}{"$type": "testPoint", "value": "v"},
+		map[string]interface{}{"$type": "testPoint", "value": map[string]interface{}{}},
+	}
+	if got := roundTrip(t, c, typed); !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON round trip of typed records failed:\n got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestCodec_EmptyBatch(t *testing.T) {
+	for _, name := range Names() {
+		c, _ := Lookup(name)
+		if got := roundTrip(t, c, []interface{}{}); len(got) != 0 || got == nil {
+			t.Errorf("%s empty round trip failed: got %#v", name, got)
+		}
+	}
+}
+
+func TestCodec_UnregisteredTypeFails(t *testing.T) {
+	c, _ := Lookup(Binary)
+	_, err := Marshal(c, []interface{}{unregistered{A: 1}})
+	if err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Errorf("Expected unregistered type to be refused, got %v", err)
+	}
+}
+
+func TestCodec_BinaryRejectsCorruptInput(t *testing.T) {
+	c, _ := Lookup(Binary)
+	data, err := Marshal(c, []interface{}{"hello", testPoint{X: 1}})
+	if err != nil {
+		t.Fatalf("Marshal failed with error: %v", err)
+	}
+	for cut := 0; cut < len(data); cut++ {
+		if _, err := Unmarshal(c, data[:cut]); err == nil {
+			t.Errorf("Expected truncated input of %d bytes to fail", cut)
+		}
+	}
+}
+
+func TestCodec_BinaryWritesTypeNameOnce(t *testing.T) {
+	c, _ := Lookup(Binary)
+	one, _ := Marshal(c, []interface{}{testPoint{}})
+	two, _ := Marshal(c, []interface{}{testPoint{}, testPoint{}})
+	name, _ := TypeName(testPoint{})
+	if len(two)-len(one) >= len(name) {
+		t.Errorf("Type name repeated: %d bytes for one record, %d for two", len(one), len(two))
+	}
+}
+
+func TestCodec_RegisterConflicts(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected registering a name for a second type to panic")
+		}
+	}()
+	RegisterName("codec.testPoint.conflict", testPoint{})
+}
@@ -0,0 +1,26 @@
+package codec
+
+import (
+	"encoding/gob"
+	"io"
+)
+
+// gobCodec uses encoding/gob; records holding user types must be registered
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return Gob }
+
+func (gobCodec) Encode(w io.Writer, records []interface{}) error {
+	return gob.NewEncoder(w).Encode(records)
+}
+
+func (gobCodec) Decode(r io.Reader) ([]interface{}, error) {
+	var records []interface{}
+	if err := gob.NewDecoder(r).Decode(&records); err != nil {
+		return nil, err
+	}
+	if records == nil {
+		records = make([]interface{}, 0)
+	}
+	return records, nil
+}
@@ -0,0 +1,235 @@
+package codec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// jsonCodec writes records as a JSON array. Registered types are wrapped as
+// {"$type": name, "value": ...}; everything else decodes the way encoding/json
+// does, so numbers come back as float64. Top-level keys of other objects starting
+// with "$" are written with another "$" in front, so that no record is mistaken
+// for the envelope.
+type jsonCodec struct{}
+
+// typedJSON is the envelope of a registered record
+type typedJSON struct {
+	Type  string          `json:"$type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (jsonCodec) Name() string { return JSON }
+
+func (jsonCodec) Encode(w io.Writer, records []interface{}) error {
+	out := make([]interface{}, len(records))
+	for i, record := range records {
+		out[i] = record
+		if name, ok := TypeName(record); ok {
+			value, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			out[i] = typedJSON{Type: name, Value: value}
+			continue
+		}
+		escape, err := hasDollarKeys(record)
+		if err != nil {
+			return err
+		}
+		if escape {
+			if out[i], err = escapeJSONKeys(record); err != nil {
+				return err
+			}
+		}
+	}
+	return json.NewEncoder(w).Encode(out)
+}
+
+func (jsonCodec) Decode(r io.Reader) ([]interface{}, error) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+	records := make([]interface{}, len(raw))
+	for i, item := range raw {
+		record, err := decodeJSONRecord(item)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		records[i] = record
+	}
+	return records, nil
+}
+
+func decodeJSONRecord(item json.RawMessage) (interface{}, error) {
+	var fields map[string]json.RawMessage
+	if mayHoldDollarKeys(item) && json.Unmarshal(item, &fields) == nil {
+		if _, ok := fields["$type"]; ok {
+			return decodeTyped(fields)
+		}
+	}
+	var record interface{}
+	if err := json.Unmarshal(item, &record); err != nil {
+		return nil, err
+	}
+	if m, ok := record.(map[string]interface{}); ok && fields != nil {
+		unescaped := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			if strings.HasPrefix(k, "$") {
+				k = k[1:]
+			}
+			unescaped[k] = v
+		}
+		record = unescaped
+	}
+	return record, nil
+}
+
+// decodeTyped decodes the envelope of a registered record
+func decodeTyped(fields map[string]json.RawMessage) (interface{}, error) {
+	value, ok := fields["value"]
+	if !ok || len(fields) != 2 {
+		return nil, fmt.Errorf("typed record must hold exactly $type and value")
+	}
+	var name string
+	if err := json.Unmarshal(fields["$type"], &name); err != nil {
+		return nil, err
+	}
+	t, ok := typeByName(name)
+	if !ok {
+		return nil, fmt.Errorf("type %q is not registered", name)
+	}
+	v := reflect.New(t)
+	if err := json.Unmarshal(value, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+// escapeJSONKeys encodes a record to a JSON object with "$" prepended to every
+// top-level key starting with "$"
+func escapeJSONKeys(record interface{}) (json.RawMessage, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	escaped := make(map[string]json.RawMessage, len(fields))
+	for k, v := range fields {
+		if strings.HasPrefix(k, "$") {
+			k = "$" + k
+		}
+		escaped[k] = v
+	}
+	return json.Marshal(escaped)
+}
+
+var (
+	jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	// dollarFields caches whether a struct type encodes a top-level key starting
+	// with "$"
+	dollarFields sync.Map
+)
+
+// hasDollarKeys reports whether a record encodes to a JSON object with a top-level
+// key starting with "$". It reads map keys and struct tags instead of encoding the
+// record, which only types with their own marshalers need.
+func hasDollarKeys(record interface{}) (bool, error) {
+	encoded := func() (bool, error) {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return false, err
+		}
+		return mayHoldDollarKeys(data), nil
+	}
+	// rows are usually generic maps, read without reflection
+	if m, ok := record.(map[string]interface{}); ok {
+		for k := range m {
+			if strings.HasPrefix(k, "$") {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	v := reflect.ValueOf(record)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return false, nil
+		}
+		if v.Type().Implements(jsonMarshaler) {
+			return encoded()
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return false, nil
+	}
+	t := v.Type()
+	if t.Implements(jsonMarshaler) || reflect.PointerTo(t).Implements(jsonMarshaler) {
+		return encoded()
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		if t.Key().Implements(textMarshaler) {
+			return encoded()
+		}
+		if t.Key().Kind() != reflect.String {
+			return false, nil
+		}
+		key := reflect.New(t.Key()).Elem()
+		iter := v.MapRange()
+		for iter.Next() {
+			key.SetIterKey(iter)
+			if strings.HasPrefix(key.String(), "$") {
+				return true, nil
+			}
+		}
+	case reflect.Struct:
+		return structHasDollarKeys(t), nil
+	}
+	return false, nil
+}
+
+// structHasDollarKeys reports whether a struct type has a field encoded under a
+// name starting with "$", including the fields of embedded structs
+func structHasDollarKeys(t reflect.Type) bool {
+	if cached, ok := dollarFields.Load(t); ok {
+		return cached.(bool)
+	}
+	found := false
+	for i := 0; i < t.NumField() && !found; i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			found = structHasDollarKeys(ft)
+			continue
+		}
+		found = f.IsExported() && strings.HasPrefix(name, "$")
+	}
+	dollarFields.Store(t, found)
+	return found
+}
+
+// mayHoldDollarKeys reports whether encoded JSON is an object that may have a key
+// starting with "$"
+func mayHoldDollarKeys(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) && bytes.Contains(data, []byte(`"$`))
+}
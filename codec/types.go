@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	typesMu sync.RWMutex
+	byName  = make(map[string]reflect.Type)
+	byType  = make(map[reflect.Type]string)
+)
+
+func init() {
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+}
+
+// Register records the concrete type of value so every codec can decode it back into
+// that type instead of a generic map. Call it from init in the driver and the workers,
+// as with gob.Register. The type is named after its package path and type name.
+func Register(value interface{}) {
+	t := reflect.TypeOf(value)
+	name := t.String()
+	if t.Name() != "" {
+		name = t.PkgPath() + "." + t.Name()
+	}
+	RegisterName(name, value)
+}
+
+// RegisterName is like Register but uses the given name, which must be the same in
+// every binary that exchanges records of the type
+func RegisterName(name string, value interface{}) {
+	t := reflect.TypeOf(value)
+	if name == "" || t == nil {
+		panic("codec: RegisterName needs a name and a non-nil value")
+	}
+	typesMu.Lock()
+	defer typesMu.Unlock()
+	if existing, ok := byName[name]; ok {
+		if existing == t {
+			return
+		}
+		panic(fmt.Sprintf("codec: name %q registered for both %v and %v", name, existing, t))
+	}
+	if existing, ok := byType[t]; ok {
+		panic(fmt.Sprintf("codec: type %v registered as both %q and %q", t, existing, name))
+	}
+	byName[name] = t
+	byType[t] = name
+	gob.RegisterName(name, value)
+}
+
+// TypeName returns the registered name of a value's type
+func TypeName(value interface{}) (string, bool) {
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+	name, ok := byType[reflect.TypeOf(value)]
+	return name, ok
+}
+
+// typeByName returns the type registered under name
+func typeByName(name string) (reflect.Type, bool) {
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+	t, ok := byName[name]
+	return t, ok
+}
@@ -0,0 +1,154 @@
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"sync"
+)
+
+// Names of the built-in compression codecs
+const (
+	None  = "none"
+	Gzip  = "gzip"
+	Flate = "flate"
+	LZ    = "lz"
+	// Snappy is the block format of the Snappy library, for reading and writing
+	// files of other tools; LZ is the better choice for shuffle blocks
+	Snappy = "snappy"
+)
+
+// Default is the codec used for shuffle blocks when a job does not pick one
+const Default = LZ
+
+// ErrCorrupt is returned by Open when a block fails its checksum or cannot be decompressed
+var ErrCorrupt = errors.New("corrupt block")
+
+// Codec compresses whole blocks. Decompress is told the original size, which Seal
+// stores in the block header.
+type Codec interface {
+	Name() string
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte, rawSize int) ([]byte, error)
+}
+
+// blockMagic starts every sealed block so foreign data is rejected early
+const blockMagic = 0xB7
+
+var (
+	mu     sync.RWMutex
+	byName = make(map[string]Codec)
+	byID   = make(map[byte]Codec)
+	ids    = make(map[string]byte)
+)
+
+func init() {
+	Register(1, noneCodec{})
+	Register(2, gzipCodec{})
+	Register(3, flateCodec{})
+	Register(4, lzCodec{})
+	Register(5, snappyCodec{})
+}
+
+// Register makes a codec available by name. The id is written into block headers and
+// must be the same in every binary; it panics on duplicate names or ids.
+func Register(id byte, c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := byName[c.Name()]; ok {
+		panic(fmt.Sprintf("compression: %q registered twice", c.Name()))
+	}
+	if _, ok := byID[id]; ok || id == 0 {
+		panic(fmt.Sprintf("compression: id %d is taken", id))
+	}
+	byName[c.Name()] = c
+	byID[id] = c
+	ids[c.Name()] = id
+}
+
+// Lookup returns the codec with the given name; an empty name selects Default
+func Lookup(name string) (Codec, error) {
+	if name == "" {
+		name = Default
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := byName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %q", name)
+	}
+	return c, nil
+}
+
+// Names returns the names of all registered codecs in sorted order
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum covers the encoded raw size as well, so a damaged header is caught too
+func checksum(size, payload []byte) uint32 {
+	return crc32.Update(crc32.Checksum(size, castagnoli), castagnoli, payload)
+}
+
+// Seal compresses raw into a self-describing block: magic byte, codec id, raw size,
+// CRC-32C of the raw size and compressed payload, and the payload itself
+func Seal(c Codec, raw []byte) ([]byte, error) {
+	mu.RLock()
+	id, ok := ids[c.Name()]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("compression %q is not registered", c.Name())
+	}
+	payload, err := c.Compress(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s compress: %w", c.Name(), err)
+	}
+	size := binary.AppendUvarint(nil, uint64(len(raw)))
+	block := make([]byte, 0, len(payload)+16)
+	block = append(block, blockMagic, id)
+	block = append(block, size...)
+	block = binary.LittleEndian.AppendUint32(block, checksum(size, payload))
+	return append(block, payload...), nil
+}
+
+// Open verifies and decompresses a block produced by Seal. Any mismatch is
+// reported as ErrCorrupt so callers can recompute the block instead of using it.
+func Open(block []byte) ([]byte, error) {
+	if len(block) < 2 || block[0] != blockMagic {
+		return nil, fmt.Errorf("%w: bad header", ErrCorrupt)
+	}
+	mu.RLock()
+	c, ok := byID[block[1]]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown compression id %d", ErrCorrupt, block[1])
+	}
+	rawSize, n := binary.Uvarint(block[2:])
+	if n <= 0 || len(block) < 2+n+4 {
+		return nil, fmt.Errorf("%w: truncated header", ErrCorrupt)
+	}
+	sum := binary.LittleEndian.Uint32(block[2+n:])
+	payload := block[2+n+4:]
+	if checksum(block[2:2+n], payload) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	raw, err := c.Decompress(payload, int(rawSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorrupt, c.Name(), err)
+	}
+	if len(raw) != int(rawSize) {
+		return nil, fmt.Errorf("%w: got %d bytes, want %d", ErrCorrupt, len(raw), rawSize)
+	}
+	return raw, nil
+}
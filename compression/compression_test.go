@@ -0,0 +1,144 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func samples() map[string][]byte {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 10000)
+	r.Read(random)
+	var text bytes.Buffer
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&text, `{"id":%d,"user":"user-%d","country":"PL"}`+"\n", i, i%37)
+	}
+	return map[string][]byte{
+		"empty":  {},
+		"short":  []byte("abc"),
+		"zeros":  make([]byte, 100000),
+		"random": random,
+		"text":   text.Bytes(),
+		"repeat": bytes.Repeat([]byte("abcdefgh"), 3000),
+	}
+}
+
+func TestCompression_RoundTrip(t *testing.T) {
+	for _, name := range Names() {
+		c, err := Lookup(name)
+		if err != nil {
+			t.Fatalf("Lookup(%q) failed with error: %v", name, err)
+		}
+		for sample, raw := range samples() {
+			block, err := Seal(c, raw)
+			if err != nil {
+				t.Fatalf("%s/%s Seal failed with error: %v", name, sample, err)
+			}
+			got, err := Open(block)
+			if err != nil {
+				t.Fatalf("%s/%s Open failed with error: %v", name, sample, err)
+			}
+			if !bytes.Equal(got, raw) {
+				t.Errorf("%s/%s round trip changed the data", name, sample)
+			}
+		}
+	}
+}
+
+func TestCompression_ShrinksRepetitiveData(t *testing.T) {
+	raw := samples()["text"]
+	for _, name := range []string{Gzip, Flate, LZ} {
+		c, _ := Lookup(name)
+		block, _ := Seal(c, raw)
+		if len(block) >= len(raw)/2 {
+			t.Errorf("%s compressed %d bytes only to %d", name, len(raw), len(block))
+		}
+	}
+}
+
+func TestCompression_DetectsCorruption(t *testing.T) {
+	raw := samples()["text"]
+	for _, name := range Names() {
+		c, _ := Lookup(name)
+		block, _ := Seal(c, raw)
+		for _, pos := range []int{0, 1, 2, len(block) / 2, len(block) - 1} {
+			corrupt := append([]byte(nil), block...)
+			corrupt[pos] ^= 0x40
+			if _, err := Open(corrupt); !errors.Is(err, ErrCorrupt) {
+				t.Errorf("%s: flipping byte %d gave %v, want ErrCorrupt", name, pos, err)
+			}
+		}
+		if _, err := Open(block[:len(block)-1]); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: truncated block gave %v, want ErrCorrupt", name, err)
+		}
+	}
+}
+
+func TestCompression_LZRejectsMalformedStreams(t *testing.T) {
+	// a back reference before any output, and a literal run past the end
+	for _, stream := range [][]byte{{0, 4, 1}, {9, 'a'}} {
+		if _, err := (lzCodec{}).Decompress(stream, 16); err == nil {
+			t.Errorf("Expected malformed stream %v to fail", stream)
+		}
+	}
+}
+
+func TestCompression_SnappyDecodesEveryElementKind(t *testing.T) {
+	long := bytes.Repeat([]byte("z"), 100)
+	streams := map[string][]byte{
+		"abcdabcdabcd": {12, 0x0c, 'a', 'b', 'c', 'd', 0x11, 0x04},
+		"xyxyxy":       {6, 0x04, 'x', 'y', 0x0f, 2, 0, 0, 0},
+		string(long):   append([]byte{100, 0xf0, 99}, long...),
+	}
+	for expected, stream := range streams {
+		got, err := (snappyCodec{}).Decompress(stream, len(expected))
+		if err != nil || string(got) != expected {
+			t.Errorf("Decompress(%v) = %q, %v, want %q", stream[:3], got, err, expected)
+		}
+	}
+	// a copy before any output, and a length mismatch
+	for _, stream := range [][]byte{{4, 0x01, 1}, {5, 0x04, 'x', 'y'}} {
+		if _, err := (snappyCodec{}).Decompress(stream, -1); err == nil {
+			t.Errorf("Expected malformed stream %v to fail", stream)
+		}
+	}
+}
+
+func TestCompression_Lookup(t *testing.T) {
+	if c, err := Lookup(""); err != nil || c.Name() != Default {
+		t.Errorf("Lookup of empty name should return the default, got %v, %v", c, err)
+	}
+	if _, err := Lookup("zstd"); err == nil {
+		t.Errorf("Expected unknown compression to be rejected")
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	raw := samples()["text"]
+	for _, name := range Names() {
+		c, _ := Lookup(name)
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(raw)))
+			for i := 0; i < b.N; i++ {
+				Seal(c, raw)
+			}
+		})
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	raw := samples()["text"]
+	for _, name := range Names() {
+		c, _ := Lookup(name)
+		block, _ := Seal(c, raw)
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(raw)))
+			for i := 0; i < b.N; i++ {
+				Open(block)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// lzCodec is a small LZ77 compressor in the spirit of Snappy: no entropy coding, a
+// single hash probe per position and byte-aligned output, trading ratio for speed.
+// The stream is a sequence of tokens, each a literal run followed by an optional
+// back reference:
+//
+//	uvarint literal length, literal bytes, uvarint match length[, uvarint offset]
+//
+// The offset is present only when the match length is non-zero.
+type lzCodec struct{}
+
+const (
+	lzMinMatch  = 4
+	lzHashBits  = 14
+	lzMaxOffset = 1 << 16
+)
+
+var errLZCorrupt = errors.New("malformed lz stream")
+
+func (lzCodec) Name() string { return LZ }
+
+func (lzCodec) Compress(src []byte) ([]byte, error) {
+	dst := make([]byte, 0, len(src)/2+16)
+	var table [1 << lzHashBits]int32 // position+1 of the last occurrence of each hash
+
+	lit := 0
+	for i := 0; i+lzMinMatch <= len(src); {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := (v * 0x1e35a7bd) >> (32 - lzHashBits)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if candidate < 0 || i-candidate > lzMaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != v {
+			// skip faster through data that does not compress
+			i += 1 + (i-lit)>>5
+			continue
+		}
+		n := lzMinMatch
+		for i+n < len(src) && src[candidate+n] == src[i+n] {
+			n++
+		}
+		dst = binary.AppendUvarint(dst, uint64(i-lit))
+		dst = append(dst, src[lit:i]...)
+		dst = binary.AppendUvarint(dst, uint64(n))
+		dst = binary.AppendUvarint(dst, uint64(i-candidate))
+		i += n
+		lit = i
+	}
+
+	dst = binary.AppendUvarint(dst, uint64(len(src)-lit))
+	dst = append(dst, src[lit:]...)
+	return binary.AppendUvarint(dst, 0), nil
+}
+
+func (lzCodec) Decompress(src []byte, rawSize int) ([]byte, error) {
+	out := make([]byte, 0, rawSize)
+	for len(src) > 0 {
+		litLen, n := binary.Uvarint(src)
+		if n <= 0 || litLen > uint64(len(src)-n) || litLen > uint64(rawSize-len(out)) {
+			return nil, errLZCorrupt
+		}
+		src = src[n:]
+		out = append(out, src[:litLen]...)
+		src = src[litLen:]
+
+		matchLen, n := binary.Uvarint(src)
+		if n <= 0 || matchLen > uint64(rawSize-len(out)) {
+			return nil, errLZCorrupt
+		}
+		src = src[n:]
+		if matchLen == 0 {
+			continue
+		}
+		offset, n := binary.Uvarint(src)
+		if n <= 0 || offset == 0 || offset > uint64(len(out)) {
+			return nil, errLZCorrupt
+		}
+		src = src[n:]
+
+		start := len(out) - int(offset)
+		if int(offset) >= int(matchLen) {
+			out = append(out, out[start:start+int(matchLen)]...)
+			continue
+		}
+		// overlapping copy repeats the last offset bytes
+		for k := 0; k < int(matchLen); k++ {
+			out = append(out, out[start+k])
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,157 @@
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// snappyCodec implements the Snappy block format, as used by Parquet and other
+// columnar files: a uvarint of the raw length followed by tagged elements. The low two
+// bits of a tag select a literal or a copy with a 1, 2 or 4 byte offset.
+type snappyCodec struct{}
+
+const (
+	snappyLiteral = 0
+	snappyCopy1   = 1
+	snappyCopy2   = 2
+	snappyCopy4   = 3
+
+	snappyHashBits  = 14
+	snappyMaxOffset = 1 << 16
+)
+
+var errSnappyCorrupt = errors.New("malformed snappy stream")
+
+func (snappyCodec) Name() string { return Snappy }
+
+func (snappyCodec) Compress(src []byte) ([]byte, error) {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+	var table [1 << snappyHashBits]int32 // position+1 of the last occurrence of each hash
+
+	lit := 0
+	for i := 0; i+4 <= len(src); {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := (v * 0x1e35a7bd) >> (32 - snappyHashBits)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if candidate < 0 || i-candidate >= snappyMaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != v {
+			i += 1 + (i-lit)>>5
+			continue
+		}
+		n := 4
+		for i+n < len(src) && src[candidate+n] == src[i+n] {
+			n++
+		}
+		dst = appendSnappyLiteral(dst, src[lit:i])
+		dst = appendSnappyCopy(dst, i-candidate, n)
+		i += n
+		lit = i
+	}
+	return appendSnappyLiteral(dst, src[lit:]), nil
+}
+
+func appendSnappyLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	n := len(lit) - 1
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+// appendSnappyCopy emits copies of at most 64 bytes with 2 byte offsets, keeping at
+// least 4 bytes for the last one
+func appendSnappyCopy(dst []byte, offset, length int) []byte {
+	for length > 0 {
+		n := length
+		if n > 64 {
+			n = 60
+			if length-n < 4 {
+				n = length - 4
+			}
+		}
+		dst = append(dst, byte(n-1)<<2|snappyCopy2, byte(offset), byte(offset>>8))
+		length -= n
+	}
+	return dst
+}
+
+func (snappyCodec) Decompress(src []byte, rawSize int) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 || (rawSize >= 0 && size != uint64(rawSize)) || size > uint64(len(src))*255+64 {
+		return nil, errSnappyCorrupt
+	}
+	src = src[n:]
+	out := make([]byte, 0, size)
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 3 {
+		case snappyLiteral:
+			length = int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				extra := length - 59
+				if len(src) < extra {
+					return nil, errSnappyCorrupt
+				}
+				length = 0
+				for k := extra - 1; k >= 0; k-- {
+					length = length<<8 | int(src[k])
+				}
+				src = src[extra:]
+			}
+			length++
+			if length <= 0 || length > len(src) || uint64(len(out)+length) > size {
+				return nil, errSnappyCorrupt
+			}
+			out = append(out, src[:length]...)
+			src = src[length:]
+			continue
+		case snappyCopy1:
+			if len(src) < 2 {
+				return nil, errSnappyCorrupt
+			}
+			length = 4 + int(tag>>2)&7
+			offset = int(tag>>5)<<8 | int(src[1])
+			src = src[2:]
+		case snappyCopy2:
+			if len(src) < 3 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case snappyCopy4:
+			if len(src) < 5 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(out) || uint64(len(out)+length) > size {
+			return nil, errSnappyCorrupt
+		}
+		start := len(out) - offset
+		for k := 0; k < length; k++ {
+			out = append(out, out[start+k])
+		}
+	}
+	if uint64(len(out)) != size {
+		return nil, errSnappyCorrupt
+	}
+	return out, nil
+}
@@ -0,0 +1,85 @@
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+)
+
+// noneCodec stores blocks as they are, still framed and checksummed
+type noneCodec struct{}
+
+func (noneCodec) Name() string { return None }
+
+func (noneCodec) Compress(src []byte) ([]byte, error) {
+	return append([]byte(nil), src...), nil
+}
+
+func (noneCodec) Decompress(src []byte, rawSize int) ([]byte, error) {
+	return append([]byte(nil), src...), nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return Gzip }
+
+func (gzipCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(src []byte, rawSize int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readSized(r, rawSize)
+}
+
+// flateCodec is raw DEFLATE at the fastest level, without the gzip header
+type flateCodec struct{}
+
+func (flateCodec) Name() string { return Flate }
+
+func (flateCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCodec) Decompress(src []byte, rawSize int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return readSized(r, rawSize)
+}
+
+// readSized reads exactly rawSize bytes and fails if the stream holds more or less
+func readSized(r io.Reader, rawSize int) ([]byte, error) {
+	out := make([]byte, rawSize)
+	if _, err := io.ReadFull(r, out); err != nil {
+		return nil, err
+	}
+	var extra [1]byte
+	if n, _ := r.Read(extra[:]); n > 0 {
+		return nil, io.ErrShortBuffer
+	}
+	return out, nil
+}
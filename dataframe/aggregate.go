@@ -0,0 +1,305 @@
+package dataframe
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/bajor/spark-go-core/expr"
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/schema"
+)
+
+// Aggregation computes one value over the rows of a group
+type Aggregation struct {
+	// Func is one of sum, count, avg, min and max
+	Func string
+	// Expr is the aggregated expression; nil with count counts rows
+	Expr expr.Expr
+	// Name names the result column, e.g. "sum(amount)" when empty
+	Name string
+}
+
+// Sum adds up the non-null values of an expression; it is null without any
+func Sum(e expr.Expr) Aggregation { return Aggregation{Func: "sum", Expr: e} }
+
+// Count counts the rows for which an expression is not null
+func Count(e expr.Expr) Aggregation { return Aggregation{Func: "count", Expr: e} }
+
+// CountAll counts rows
+func CountAll() Aggregation { return Aggregation{Func: "count"} }
+
+// Avg averages the non-null values of an expression as a double
+func Avg(e expr.Expr) Aggregation { return Aggregation{Func: "avg", Expr: e} }
+
+// Min returns the smallest non-null value of an expression
+func Min(e expr.Expr) Aggregation { return Aggregation{Func: "min", Expr: e} }
+
+// Max returns the largest non-null value of an expression
+func Max(e expr.Expr) Aggregation { return Aggregation{Func: "max", Expr: e} }
+
+// As names the result column
+func (a Aggregation) As(name string) Aggregation {
+	a.Name = name
+	return a
+}
+
+func (a Aggregation) String() string {
+	arg := "*"
+	if a.Expr != nil {
+		arg = a.Expr.String()
+	}
+	s := a.Func + "(" + arg + ")"
+	if a.Name != "" {
+		s += " as " + a.Name
+	}
+	return s
+}
+
+// field resolves the aggregated expression and returns the result column
+func (a Aggregation) field(s schema.Schema) (schema.Field, error) {
+	name := a.Name
+	if name == "" {
+		arg := "*"
+		if a.Expr != nil {
+			arg = expr.Name(a.Expr)
+		}
+		name = a.Func + "(" + arg + ")"
+	}
+	if a.Expr == nil {
+		if a.Func != "count" {
+			return schema.Field{}, fmt.Errorf("%s needs an expression", a.Func)
+		}
+		return schema.Field{Name: name, Type: schema.Long}, nil
+	}
+	in, err := resolve(a.Expr, s)
+	if err != nil {
+		return schema.Field{}, err
+	}
+	switch a.Func {
+	case "count":
+		return schema.Field{Name: name, Type: schema.Long}, nil
+	case "sum", "avg":
+		if !numeric(in.Type) {
+			return schema.Field{}, fmt.Errorf("%s: %s is a %s, not a number", a.Func, a.Expr, in.Type)
+		}
+		t := in.Type
+		if a.Func == "avg" || t == schema.Null {
+			t = schema.Double
+		}
+		return schema.Field{Name: name, Type: t, Nullable: true}, nil
+	case "min", "max":
+		return schema.Field{Name: name, Type: in.Type, Nullable: true}, nil
+	}
+	return schema.Field{}, fmt.Errorf("unknown aggregate function %q", a.Func)
+}
+
+// accumulator folds the values of one aggregation over a group
+type accumulator struct {
+	agg   Aggregation
+	field schema.Field
+	count int64
+	value interface{}
+}
+
+func (acc *accumulator) add(row interface{}) error {
+	if acc.agg.Expr == nil {
+		acc.count++
+		return nil
+	}
+	v, err := acc.agg.Expr.Eval(row)
+	if err != nil || v == nil {
+		return err
+	}
+	acc.count++
+	switch acc.agg.Func {
+	case "sum", "avg":
+		t := acc.field.Type
+		if acc.agg.Func == "avg" {
+			t = schema.Double
+		}
+		if t == schema.Any {
+			v, err = anyNumber(v)
+		} else {
+			v, err = convert(v, schema.Field{Name: acc.field.Name, Type: t})
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", acc.agg, err)
+		}
+		// the sum of an Any column stays a long until a double is added
+		switch x := v.(type) {
+		case int64:
+			switch sum := acc.value.(type) {
+			case int64:
+				acc.value = sum + x
+			case float64:
+				acc.value = sum + float64(x)
+			default:
+				acc.value = x
+			}
+		case float64:
+			switch sum := acc.value.(type) {
+			case int64:
+				acc.value = float64(sum) + x
+			case float64:
+				acc.value = sum + x
+			default:
+				acc.value = x
+			}
+		}
+	case "min", "max":
+		if acc.value == nil {
+			acc.value = v
+			return nil
+		}
+		c, err := expr.Compare(v, acc.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", acc.agg, err)
+		}
+		if (c < 0) == (acc.agg.Func == "min") && c != 0 {
+			acc.value = v
+		}
+	}
+	return nil
+}
+
+// anyNumber converts a number held by an Any column to an int64, or to a float64 if
+// it is a floating-point number
+func anyNumber(v interface{}) (interface{}, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if !rv.IsValid() {
+		return nil, nil
+	}
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return convert(rv.Interface(), schema.Field{Type: schema.Long})
+	}
+	return nil, fmt.Errorf("%v (%T) is not a number", v, v)
+}
+
+func (acc *accumulator) result() (interface{}, error) {
+	switch acc.agg.Func {
+	case "count":
+		return acc.count, nil
+	case "avg":
+		if acc.count == 0 {
+			return nil, nil
+		}
+		return acc.value.(float64) / float64(acc.count), nil
+	}
+	return convert(acc.value, acc.field)
+}
+
+// GroupedData is a DataFrame grouped by columns, see GroupBy
+type GroupedData struct {
+	df      *DataFrame
+	columns []string
+}
+
+// Agg computes aggregations over every group, giving one row per group holding the
+// grouping columns followed by one column per aggregation. Without grouping columns
+// it gives a single row, even for no input rows.
+func (g *GroupedData) Agg(aggs ...Aggregation) *DataFrame {
+	if g.df.err != nil {
+		return g.df
+	}
+	in := g.df.plan.schema()
+	var fields []schema.Field
+	for _, c := range g.columns {
+		f, ok := in.Field(c)
+		if !ok {
+			return failed(fmt.Errorf("group by: unknown column %q in %s", c, in))
+		}
+		fields = append(fields, f)
+	}
+	if len(aggs) == 0 {
+		return failed(fmt.Errorf("agg needs at least one aggregation"))
+	}
+	for _, a := range aggs {
+		f, err := a.field(in)
+		if err != nil {
+			return failed(err)
+		}
+		fields = append(fields, f)
+	}
+	out := schema.New(fields...)
+	if err := checkNames(out); err != nil {
+		return failed(err)
+	}
+	return &DataFrame{plan: &aggregate{child: g.df.plan, groups: g.columns, aggs: aggs, out: out}}
+}
+
+// aggregate groups rows by columns and aggregates every group. Groups are formed by
+// ReduceByKey, so every row of a group is shuffled to one task.
+type aggregate struct {
+	child  node
+	groups []string
+	aggs   []Aggregation
+	out    schema.Schema
+	// partitions is the number of partitions of the shuffle chosen by the planner
+	// from the estimated input size; 0 keeps those of the input
+	partitions int
+}
+
+func (a *aggregate) schema() schema.Schema { return a.out }
+func (a *aggregate) children() []node      { return []node{a.child} }
+
+func (a *aggregate) withChildren(children []node) node {
+	c := *a
+	c.child = children[0]
+	return &c
+}
+
+func (a *aggregate) describe() string {
+	parts := make([]string, len(a.aggs))
+	for i, agg := range a.aggs {
+		parts[i] = agg.String()
+	}
+	d := "Aggregate [" + strings.Join(a.groups, ", ") + "] [" + strings.Join(parts, ", ") + "]"
+	if a.partitions > 0 {
+		d += " partitions " + strconv.Itoa(a.partitions)
+	}
+	return d
+}
+
+func (a *aggregate) compile(inputs []*rdd.KeyedRDD) (*rdd.KeyedRDD, error) {
+	groups, aggs, out := a.groups, a.aggs, a.out
+	reduce := func(rows []interface{}) ([]interface{}, error) {
+		accs := make([]*accumulator, len(aggs))
+		for i, agg := range aggs {
+			accs[i] = &accumulator{agg: agg, field: out.Fields[len(groups)+i]}
+		}
+		for _, row := range rows {
+			for _, acc := range accs {
+				if err := acc.add(row); err != nil {
+					return nil, err
+				}
+			}
+		}
+		result := make(map[string]interface{}, len(out.Fields))
+		if len(rows) > 0 {
+			for _, c := range groups {
+				result[c] = rows[0].(map[string]interface{})[c]
+			}
+		}
+		for _, acc := range accs {
+			v, err := acc.result()
+			if err != nil {
+				return nil, err
+			}
+			result[acc.field.Name] = v
+		}
+		return []interface{}{result}, nil
+	}
+	if len(groups) == 0 {
+		return inputs[0].Reduce(reduce), nil
+	}
+	return inputs[0].WithKey(func(row interface{}) (interface{}, error) {
+		key, _, err := groupKey(row.(map[string]interface{}), groups)
+		return key, err
+	}).ReduceByKeyAdvisory(reduce, a.partitions), nil
+}
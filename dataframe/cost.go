@@ -0,0 +1,231 @@
+package dataframe
+
+import (
+	"math"
+	"strings"
+
+	"github.com/bajor/spark-go-core/expr"
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/schema"
+)
+
+// The physical choices of the planner, variables so that tests can lower them
+var (
+	// broadcastBytes is the estimated size under which a side of a join is gathered
+	// and joined with every partition of the other instead of shuffling both
+	broadcastBytes = 10.0 * (1 << 20)
+	// partitionBytes is the estimated input size of one task after a shuffle
+	partitionBytes = 64.0 * (1 << 20)
+	// maxPartitions bounds the partitions of a shuffle
+	maxPartitions = 200
+)
+
+// reorderJoins reorders chains of three or more inner joins on conditions whose
+// inputs all have statistics, greedily joining first the pair of inputs with the
+// fewest estimated rows, then the input adding the fewest rows to the result, and
+// avoiding joins without a condition while connected inputs remain. A projection
+// restores the column order of the chain.
+func reorderJoins(n node) node {
+	if j, ok := n.(*join); ok && reorderable(j) {
+		var leaves []node
+		var conditions []expr.Expr
+		flattenJoins(j, &leaves, &conditions)
+		if len(leaves) >= 3 {
+			for i, l := range leaves {
+				leaves[i] = reorderJoins(l)
+			}
+			if ordered, ok := greedyJoin(leaves, conditions); ok {
+				return restoreOrder(ordered, j.schema())
+			}
+		}
+	}
+	children := n.children()
+	if len(children) == 0 {
+		return n
+	}
+	reordered := make([]node, len(children))
+	for i, c := range children {
+		reordered[i] = reorderJoins(c)
+	}
+	return n.withChildren(reordered)
+}
+
+// reorderable reports whether a join commutes with inner joins around it
+func reorderable(j *join) bool {
+	return j.how == Inner && !j.using
+}
+
+// flattenJoins collects the inputs and the condition conjuncts of a chain of joins
+func flattenJoins(n node, leaves *[]node, conditions *[]expr.Expr) {
+	j, ok := n.(*join)
+	if !ok || !reorderable(j) {
+		*leaves = append(*leaves, n)
+		return
+	}
+	if j.condition != nil {
+		*conditions = append(*conditions, conjuncts(j.condition)...)
+	}
+	flattenJoins(j.left, leaves, conditions)
+	flattenJoins(j.right, leaves, conditions)
+}
+
+// greedyJoin joins inputs in the order of fewest estimated rows. Every condition is
+// evaluated by the first join reading all its columns.
+func greedyJoin(leaves []node, conditions []expr.Expr) (node, bool) {
+	for _, l := range leaves {
+		if !estimateOf(l).known {
+			return nil, false
+		}
+	}
+	used := make([]bool, len(conditions))
+	// candidate joins left and right on the unused conditions reading both
+	candidate := func(left, right node) (*join, []int, bool) {
+		both := schema.New(append(append([]schema.Field(nil), left.schema().Fields...), right.schema().Fields...)...)
+		var on []expr.Expr
+		var indexes []int
+		for i, c := range conditions {
+			if !used[i] && reads(c, both) {
+				on = append(on, c)
+				indexes = append(indexes, i)
+			}
+		}
+		j, err := newConditionJoin(left, right, and(on), Inner)
+		return j, indexes, err == nil
+	}
+	// better reports whether a join is preferable to the best so far: joins with a
+	// condition come first, then those estimated to give fewer rows
+	better := func(j *join, on []int, best *join, bestOn []int) bool {
+		if best == nil {
+			return true
+		}
+		if (len(on) > 0) != (len(bestOn) > 0) {
+			return len(on) > 0
+		}
+		return estimateOf(j).rows < estimateOf(best).rows
+	}
+
+	var current *join
+	var currentOn []int
+	first, second := -1, -1
+	for a := range leaves {
+		for b := a + 1; b < len(leaves); b++ {
+			j, on, ok := candidate(leaves[a], leaves[b])
+			if !ok {
+				return nil, false
+			}
+			if better(j, on, current, currentOn) {
+				current, currentOn, first, second = j, on, a, b
+			}
+		}
+	}
+	for _, i := range currentOn {
+		used[i] = true
+	}
+	joined := map[int]bool{first: true, second: true}
+	for len(joined) < len(leaves) {
+		var best *join
+		var bestOn []int
+		next := -1
+		for i, l := range leaves {
+			if joined[i] {
+				continue
+			}
+			j, on, ok := candidate(current, l)
+			if !ok {
+				return nil, false
+			}
+			if better(j, on, best, bestOn) {
+				best, bestOn, next = j, on, i
+			}
+		}
+		for _, i := range bestOn {
+			used[i] = true
+		}
+		current, joined[next] = best, true
+	}
+	for _, u := range used {
+		if !u {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// restoreOrder projects the columns of a plan into the order of a schema holding
+// the same columns, unless they are in that order already
+func restoreOrder(n node, s schema.Schema) node {
+	if strings.Join(n.schema().Names(), ",") == strings.Join(s.Names(), ",") {
+		return n
+	}
+	exprs := make([]expr.Expr, len(s.Fields))
+	for i, name := range s.Names() {
+		exprs[i] = expr.Col(name)
+	}
+	return &project{child: n, exprs: exprs, out: s}
+}
+
+// choosePhysical picks how every join and aggregation of a plan is executed from
+// the estimated size of its inputs: a join broadcasts a side estimated smaller than
+// broadcastBytes if the join type allows, and shuffles sized to partitionBytes per
+// task otherwise. Operators over inputs without statistics keep the defaults.
+func choosePhysical(n node) node {
+	children := n.children()
+	if len(children) > 0 {
+		chosen := make([]node, len(children))
+		for i, c := range children {
+			chosen[i] = choosePhysical(c)
+		}
+		n = n.withChildren(chosen)
+	}
+	switch v := n.(type) {
+	case *join:
+		l, r := estimateOf(v.left), estimateOf(v.right)
+		c := *v
+		c.strategy, c.partitions = joinStrategy(l, r, v.how), 0
+		if c.strategy == rdd.ShuffleJoin && l.known && r.known {
+			c.partitions = shufflePartitions(l.bytes() + r.bytes())
+		}
+		return &c
+	case *aggregate:
+		if len(v.groups) == 0 {
+			return v
+		}
+		c := *v
+		c.partitions = 0
+		if in := estimateOf(v.child); in.known {
+			c.partitions = shufflePartitions(in.bytes())
+		}
+		return &c
+	}
+	return n
+}
+
+// joinStrategy broadcasts the smaller side of a join if it is small enough and the
+// join keeps no unmatched rows of it, which every partition of the other side would
+// emit
+func joinStrategy(l, r estimate, how JoinType) rdd.JoinStrategy {
+	right := r.known && r.bytes() <= broadcastBytes && canBroadcastRight(how)
+	left := l.known && l.bytes() <= broadcastBytes && canBroadcastLeft(how)
+	switch {
+	case right && left && l.bytes() < r.bytes():
+		return rdd.BroadcastLeft
+	case right:
+		return rdd.BroadcastRight
+	case left:
+		return rdd.BroadcastLeft
+	}
+	return rdd.ShuffleJoin
+}
+
+// shufflePartitions returns the number of partitions of partitionBytes holding an
+// estimated size
+func shufflePartitions(bytes float64) int {
+	n := int(math.Ceil(bytes / partitionBytes))
+	if n < 1 {
+		return 1
+	}
+	if n > maxPartitions {
+		return maxPartitions
+	}
+	return n
+}
@@ -0,0 +1,407 @@
+// Package dataframe provides DataFrames: RDDs of records with a schema of named,
+// typed columns. Transformations only build a logical plan, checked against the
+// schema as it grows; actions optimize the plan, compile it to Map, Filter and
+// ReduceByKey operations on KeyedRDDs and evaluate it.
+package dataframe
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	accumulators "github.com/bajor/spark-go-core/accumulator"
+	"github.com/bajor/spark-go-core/expr"
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/scheduler"
+	"github.com/bajor/spark-go-core/schema"
+)
+
+// Row is a record of a DataFrame, holding one value per column of its schema
+type Row struct {
+	Schema schema.Schema
+	Values []interface{}
+}
+
+// Get returns the value of a column, or nil if the row has no such column
+func (r Row) Get(name string) interface{} {
+	if i := r.Schema.Index(name); i >= 0 {
+		return r.Values[i]
+	}
+	return nil
+}
+
+// Map returns the row as a record map
+func (r Row) Map() map[string]interface{} {
+	m := make(map[string]interface{}, len(r.Values))
+	for i, f := range r.Schema.Fields {
+		m[f.Name] = r.Values[i]
+	}
+	return m
+}
+
+func (r Row) String() string {
+	parts := make([]string, len(r.Values))
+	for i, v := range r.Values {
+		if v == nil {
+			parts[i] = "null"
+		} else {
+			parts[i] = fmt.Sprint(v)
+		}
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// DataFrame is a lazily evaluated table of rows. A transformation failing analysis,
+// e.g. by naming an unknown column, gives a DataFrame holding the error, which every
+// later transformation and action returns.
+type DataFrame struct {
+	plan node
+	err  error
+
+	// mu guards executed, the plan the last Collect ran
+	mu       sync.Mutex
+	executed *execution
+}
+
+// New creates a DataFrame from an RDD of record maps or structs and their schema.
+// Records are converted to the column types when the DataFrame is evaluated; missing
+// columns are null.
+func New(r *rdd.KeyedRDD, s schema.Schema) *DataFrame {
+	if err := checkNames(s); err != nil {
+		return failed(err)
+	}
+	return &DataFrame{plan: &scan{rdd: r, out: s}}
+}
+
+// FromSource creates a DataFrame from an RDD read from a source knowing the schema
+// of its records, such as those of ReadCSV, ReadJSONLines and ReadParquet
+func FromSource(r *rdd.KeyedRDD) *DataFrame {
+	src, ok := r.Source.(interface{ Schema() schema.Schema })
+	if !ok {
+		return failed(fmt.Errorf("the source of the RDD has no schema"))
+	}
+	return New(r, src.Schema())
+}
+
+// FromRDD creates a DataFrame from an RDD of structs of type T, or pointers to them,
+// with the schema schema.Of derives from T
+func FromRDD[T any](r *rdd.KeyedRDD) *DataFrame {
+	s, err := schema.Of(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return failed(err)
+	}
+	return New(r, s)
+}
+
+// ToRDD returns an RDD of the rows of a DataFrame decoded into structs of type T, see
+// schema.Decode
+func ToRDD[T any](df *DataFrame) (*rdd.KeyedRDD, error) {
+	r, err := df.RDD()
+	if err != nil {
+		return nil, err
+	}
+	return r.Map(schema.DecodeFunc[T]()), nil
+}
+
+func failed(err error) *DataFrame {
+	return &DataFrame{err: err}
+}
+
+// checkNames rejects empty and duplicate column names
+func checkNames(s schema.Schema) error {
+	seen := make(map[string]bool, len(s.Fields))
+	for _, f := range s.Fields {
+		if f.Name == "" {
+			return fmt.Errorf("empty column name in %s", s)
+		}
+		if seen[f.Name] {
+			return fmt.Errorf("duplicate column %q in %s", f.Name, s)
+		}
+		seen[f.Name] = true
+	}
+	return nil
+}
+
+// Err returns the error of the first transformation that failed analysis
+func (df *DataFrame) Err() error {
+	return df.err
+}
+
+// Schema returns the columns of the DataFrame
+func (df *DataFrame) Schema() schema.Schema {
+	if df.err != nil {
+		return schema.Schema{}
+	}
+	return df.plan.schema()
+}
+
+// Columns returns the column names in order
+func (df *DataFrame) Columns() []string {
+	return df.Schema().Names()
+}
+
+// RDD compiles the optimized plan of the DataFrame to an RDD of record maps holding
+// one entry per column
+func (df *DataFrame) RDD() (*rdd.KeyedRDD, error) {
+	if df.err != nil {
+		return nil, df.err
+	}
+	return compile(optimize(df.plan))
+}
+
+// Optimized returns the DataFrame with the plan its actions run: filters moved below
+// projections, aggregations and joins and into the scans, unused columns dropped,
+// adjacent filters and projections merged, constants folded and no-op operators
+// removed. Its Explain shows the result.
+func (df *DataFrame) Optimized() *DataFrame {
+	if df.err != nil {
+		return df
+	}
+	return &DataFrame{plan: optimize(df.plan)}
+}
+
+// Cache returns a DataFrame of the same rows that keeps them in memory once first
+// evaluated. The statistics gathered while caching them let the planner estimate
+// the size of plans reading the result.
+func (df *DataFrame) Cache() *DataFrame {
+	r, err := df.RDD()
+	if err != nil {
+		return failed(err)
+	}
+	return &DataFrame{plan: &scan{rdd: r.Cache(), out: df.plan.schema()}}
+}
+
+// Explain describes the logical plan of the DataFrame, one operator per line with
+// its inputs indented below it. Operators reading sources with statistics show their
+// estimated rows. Once the DataFrame was collected, the optimized plan that ran
+// follows, with the estimated and the actual rows of every operator.
+func (df *DataFrame) Explain() string {
+	if df.err != nil {
+		return "Error " + df.err.Error() + "\n"
+	}
+	var b strings.Builder
+	explain(&b, df.plan, 0)
+	df.mu.Lock()
+	executed := df.executed
+	df.mu.Unlock()
+	if executed != nil {
+		b.WriteString("Executed plan:\n")
+		next := 0
+		explainExecuted(&b, executed.plan, 0, executed.rows, &next)
+	}
+	return b.String()
+}
+
+// with adds an operator to the plan, or fails with err
+func (df *DataFrame) with(n node, err error) *DataFrame {
+	if err != nil {
+		return failed(err)
+	}
+	return &DataFrame{plan: n}
+}
+
+// Select computes one column per expression, named by expr.Name or an alias.
+// Col("*") stands for every column of the DataFrame.
+func (df *DataFrame) Select(exprs ...expr.Expr) *DataFrame {
+	if df.err != nil {
+		return df
+	}
+	in := df.plan.schema()
+	var expanded []expr.Expr
+	for _, e := range exprs {
+		if c, ok := e.(expr.Column); ok && c.Name == "*" {
+			for _, name := range in.Names() {
+				expanded = append(expanded, expr.Col(name))
+			}
+			continue
+		}
+		expanded = append(expanded, e)
+	}
+	return df.with(newProject(df.plan, expanded))
+}
+
+func newProject(child node, exprs []expr.Expr) (node, error) {
+	if len(exprs) == 0 {
+		return nil, fmt.Errorf("select needs at least one column")
+	}
+	in := child.schema()
+	fields := make([]schema.Field, len(exprs))
+	for i, e := range exprs {
+		f, err := resolve(e, in)
+		if err != nil {
+			return nil, fmt.Errorf("select %s: %w", e, err)
+		}
+		fields[i] = f
+	}
+	out := schema.New(fields...)
+	if err := checkNames(out); err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	return &project{child: child, exprs: exprs, out: out}, nil
+}
+
+// Where keeps the rows for which a boolean predicate is true; null counts as false
+func (df *DataFrame) Where(predicate expr.Expr) *DataFrame {
+	if df.err != nil {
+		return df
+	}
+	f, err := resolve(predicate, df.plan.schema())
+	if err != nil {
+		return failed(fmt.Errorf("where %s: %w", predicate, err))
+	}
+	if f.Type != schema.Bool && f.Type != schema.Null && f.Type != schema.Any {
+		return failed(fmt.Errorf("where %s: the predicate is a %s, not a boolean", predicate, f.Type))
+	}
+	return &DataFrame{plan: &filter{child: df.plan, predicate: predicate}}
+}
+
+// WithColumn adds a column computed by an expression, or replaces the column of the
+// same name in place
+func (df *DataFrame) WithColumn(name string, e expr.Expr) *DataFrame {
+	if df.err != nil {
+		return df
+	}
+	var exprs []expr.Expr
+	replaced := false
+	for _, c := range df.plan.schema().Names() {
+		if c == name {
+			exprs = append(exprs, expr.As(e, name))
+			replaced = true
+			continue
+		}
+		exprs = append(exprs, expr.Col(c))
+	}
+	if !replaced {
+		exprs = append(exprs, expr.As(e, name))
+	}
+	return df.with(newProject(df.plan, exprs))
+}
+
+// OrderBy sorts the rows, gathering them into a single partition
+func (df *DataFrame) OrderBy(orders ...Order) *DataFrame {
+	if df.err != nil {
+		return df
+	}
+	if len(orders) == 0 {
+		return failed(fmt.Errorf("order by needs at least one column"))
+	}
+	for _, o := range orders {
+		if _, err := resolve(o.Expr, df.plan.schema()); err != nil {
+			return failed(fmt.Errorf("order by %s: %w", o.Expr, err))
+		}
+	}
+	return &DataFrame{plan: &sortRows{child: df.plan, orders: orders}}
+}
+
+// Limit keeps the first n rows, in order after OrderBy
+func (df *DataFrame) Limit(n int) *DataFrame {
+	if df.err != nil {
+		return df
+	}
+	if n < 0 {
+		return failed(fmt.Errorf("limit %d is negative", n))
+	}
+	return &DataFrame{plan: &limit{child: df.plan, n: n}}
+}
+
+// Join matches the rows of two DataFrames on equal values of the columns named by
+// on, which both must have. The result holds the key columns once, then the other
+// columns of the left side, then those of the right side; any other column name the
+// sides share is an error.
+func (df *DataFrame) Join(right *DataFrame, on []string, how JoinType) *DataFrame {
+	switch {
+	case df.err != nil:
+		return df
+	case right.err != nil:
+		return right
+	}
+	j, err := newUsingJoin(df.plan, right.plan, on, how)
+	return df.with(j, err)
+}
+
+// JoinOn matches the rows of two DataFrames for which a condition over the columns
+// of both is true; a nil condition matches every pair. Equalities between a left and
+// a right column joined by and group the rows to compare, so at least one is needed
+// to spread a join over several tasks. The result holds the columns of the left
+// side, then those of the right side, which must not share names.
+func (df *DataFrame) JoinOn(right *DataFrame, condition expr.Expr, how JoinType) *DataFrame {
+	switch {
+	case df.err != nil:
+		return df
+	case right.err != nil:
+		return right
+	}
+	j, err := newConditionJoin(df.plan, right.plan, condition, how)
+	return df.with(j, err)
+}
+
+// Distinct keeps one of every set of equal rows
+func (df *DataFrame) Distinct() *DataFrame {
+	if df.err != nil {
+		return df
+	}
+	s := df.plan.schema()
+	return &DataFrame{plan: &aggregate{child: df.plan, groups: s.Names(), out: s}}
+}
+
+// GroupBy groups the rows by the values of columns, to be aggregated with Agg
+func (df *DataFrame) GroupBy(columns ...string) *GroupedData {
+	return &GroupedData{df: df, columns: columns}
+}
+
+// Agg aggregates every row of the DataFrame into a single row
+func (df *DataFrame) Agg(aggs ...Aggregation) *DataFrame {
+	return df.GroupBy().Agg(aggs...)
+}
+
+// Collect evaluates the DataFrame in parallel and returns its rows. The rows every
+// operator produced are counted for Explain.
+func (df *DataFrame) Collect(ctx context.Context, s *scheduler.Scheduler) ([]Row, error) {
+	if df.err != nil {
+		return nil, df.err
+	}
+	plan := optimize(df.plan)
+	var counters []*accumulators.Accumulator[int64]
+	defer func() {
+		for _, c := range counters {
+			accumulators.Unregister(c.ID())
+		}
+	}()
+	r, err := compileCounted(plan, &counters)
+	if err != nil {
+		return nil, err
+	}
+	records, err := r.Collect(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	executed := &execution{plan: plan, rows: make([]int64, len(counters))}
+	for i, c := range counters {
+		executed.rows[i] = c.Value()
+	}
+	df.mu.Lock()
+	df.executed = executed
+	df.mu.Unlock()
+	out := df.plan.schema()
+	rows := make([]Row, len(records))
+	for i, record := range records {
+		m := record.(map[string]interface{})
+		values := make([]interface{}, len(out.Fields))
+		for j, f := range out.Fields {
+			values[j] = m[f.Name]
+		}
+		rows[i] = Row{Schema: out, Values: values}
+	}
+	return rows, nil
+}
+
+// Count evaluates the DataFrame in parallel and returns its number of rows
+func (df *DataFrame) Count(ctx context.Context, s *scheduler.Scheduler) (int64, error) {
+	rows, err := df.Agg(CountAll().As("count")).Collect(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	return rows[0].Values[0].(int64), nil
+}
@@ -0,0 +1,279 @@
+package dataframe
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bajor/spark-go-core/expr"
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/scheduler"
+	"github.com/bajor/spark-go-core/schema"
+)
+
+type sale struct {
+	Region string  `col:"region"`
+	Item   string  `col:"item"`
+	Amount int     `col:"amount"`
+	Price  float64 `col:"price"`
+	Note   *string `col:"note"`
+}
+
+func sales() *DataFrame {
+	note := "gift"
+	data := []interface{}{
+		sale{Region: "eu", Item: "apple", Amount: 3, Price: 0.5},
+		sale{Region: "eu", Item: "pear", Amount: 1, Price: 0.75, Note: &note},
+		sale{Region: "us", Item: "apple", Amount: 10, Price: 0.25},
+		sale{Region: "us", Item: "plum", Amount: 4, Price: 1},
+		sale{Region: "asia", Item: "pear", Amount: 2, Price: 0.5},
+	}
+	return FromRDD[sale](rdd.NewKeyedRDD(data, nil).Repartition(2))
+}
+
+func collect(t *testing.T, df *DataFrame) []string {
+	t.Helper()
+	rows, err := df.Collect(context.Background(), scheduler.New(scheduler.Config{Parallelism: 2}))
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	out := make([]string, len(rows))
+	for i, r := range rows {
+		out[i] = r.String()
+	}
+	return out
+}
+
+func sorted(rows []string) []string {
+	sort.Strings(rows)
+	return rows
+}
+
+func TestDataFrame_SelectWhereWithColumn(t *testing.T) {
+	df := sales().
+		Where(expr.Gt(expr.Col("amount"), expr.Lit(2))).
+		WithColumn("total", expr.Mul(expr.Col("amount"), expr.Col("price"))).
+		WithColumn("item", expr.Fn("upper", expr.Col("item"))).
+		Select(expr.Col("region"), expr.Col("item"), expr.Col("total"))
+
+	if got, want := df.Schema().String(), "(region string not null, item string, total double not null)"; got != want {
+		t.Errorf("Schema: got %s, want %s", got, want)
+	}
+	got := sorted(collect(t, df))
+	if want := []string{"[eu, APPLE, 1.5]", "[us, APPLE, 2.5]", "[us, PLUM, 4]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Rows: got %v, want %v", got, want)
+	}
+
+	rows, err := sales().Select(expr.Col("*")).Where(expr.Eq(expr.Col("note"), expr.Lit("gift"))).Collect(context.Background(), scheduler.New(scheduler.Config{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Get("item") != "pear" || rows[0].Get("amount") != int64(1) {
+		t.Errorf("Select(*) should keep every column, got %v", rows)
+	}
+}
+
+func TestDataFrame_GroupByAgg(t *testing.T) {
+	df := sales().GroupBy("region").Agg(
+		Sum(expr.Col("amount")), CountAll(), Avg(expr.Col("price")).As("avg_price"),
+		Min(expr.Col("item")), Max(expr.Col("amount")), Count(expr.Col("note")),
+	)
+	want := "(region string not null, sum(amount) long, count(*) long not null, avg_price double, min(item) string, max(amount) long, count(note) long not null)"
+	if got := df.Schema().String(); got != want {
+		t.Errorf("Schema: got %s, want %s", got, want)
+	}
+	got := sorted(collect(t, df))
+	if want := []string{"[asia, 2, 1, 0.5, pear, 2, 0]", "[eu, 4, 2, 0.625, apple, 3, 1]", "[us, 14, 2, 0.625, apple, 10, 0]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Rows: got %v, want %v", got, want)
+	}
+
+	empty := sales().Where(expr.Lit(false)).Agg(Sum(expr.Col("amount")), CountAll())
+	if got, want := collect(t, empty), []string{"[null, 0]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Aggregating no rows: got %v, want %v", got, want)
+	}
+	n, err := sales().Count(context.Background(), scheduler.New(scheduler.Config{}))
+	if err != nil || n != 5 {
+		t.Errorf("Count: got %d, %v, want 5", n, err)
+	}
+}
+
+func TestDataFrame_SumOfAnyColumn(t *testing.T) {
+	s := schema.New(
+		schema.Field{Name: "k", Type: schema.String},
+		schema.Field{Name: "v", Type: schema.Any, Nullable: true},
+	)
+	df := New(rdd.NewKeyedRDD([]interface{}{
+		map[string]interface{}{"k": "ints", "v": 1},
+		map[string]interface{}{"k": "ints", "v": int64(2)},
+		map[string]interface{}{"k": "ints", "v": uint8(3)},
+		map[string]interface{}{"k": "mixed", "v": int64(1)},
+		map[string]interface{}{"k": "mixed", "v": 2.5},
+		map[string]interface{}{"k": "mixed", "v": 2},
+		map[string]interface{}{"k": "mixed", "v": nil},
+	}, nil).Repartition(1), s)
+
+	got := sorted(collect(t, df.GroupBy("k").Agg(Sum(expr.Col("v")), Avg(expr.Col("v")))))
+	if want := []string{"[ints, 6, 2]", "[mixed, 5.5, 1.8333333333333333]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sum over an Any column: got %v, want %v", got, want)
+	}
+	rows, err := df.Where(expr.Eq(expr.Col("k"), expr.Lit("ints"))).Agg(Sum(expr.Col("v"))).Collect(context.Background(), scheduler.New(scheduler.Config{}))
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	if v := rows[0].Get("sum(v)"); v != int64(6) {
+		t.Errorf("Sum of integers in an Any column: got %v (%T), want int64 6", v, v)
+	}
+
+	bad := New(rdd.NewKeyedRDD([]interface{}{map[string]interface{}{"k": "a", "v": "x"}}, nil), s)
+	if _, err := bad.Agg(Sum(expr.Col("v"))).Collect(context.Background(), scheduler.New(scheduler.Config{})); err == nil {
+		t.Error("Expected the sum of a string in an Any column to fail")
+	}
+}
+
+func TestDataFrame_Join(t *testing.T) {
+	regions := New(rdd.NewKeyedRDD([]interface{}{
+		map[string]interface{}{"region": "eu", "name": "Europe"},
+		map[string]interface{}{"region": "us", "name": "United States"},
+		map[string]interface{}{"region": "africa", "name": "Africa"},
+		map[string]interface{}{"region": nil, "name": "Unknown"},
+	}, nil), schema.New(
+		schema.Field{Name: "region", Type: schema.String, Nullable: true},
+		schema.Field{Name: "name", Type: schema.String},
+	))
+	left := sales().Select(expr.Col("region"), expr.Col("item"))
+
+	tests := []struct {
+		how  JoinType
+		want []string
+	}{
+		{Inner, []string{"[eu, apple, Europe]", "[eu, pear, Europe]", "[us, apple, United States]", "[us, plum, United States]"}},
+		{LeftOuter, []string{"[asia, pear, null]", "[eu, apple, Europe]", "[eu, pear, Europe]", "[us, apple, United States]", "[us, plum, United States]"}},
+		{RightOuter, []string{"[africa, null, Africa]", "[eu, apple, Europe]", "[eu, pear, Europe]", "[null, null, Unknown]", "[us, apple, United States]", "[us, plum, United States]"}},
+		{FullOuter, []string{"[africa, null, Africa]", "[asia, pear, null]", "[eu, apple, Europe]", "[eu, pear, Europe]", "[null, null, Unknown]", "[us, apple, United States]", "[us, plum, United States]"}},
+		{LeftSemi, []string{"[eu, apple]", "[eu, pear]", "[us, apple]", "[us, plum]"}},
+		{LeftAnti, []string{"[asia, pear]"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.how), func(t *testing.T) {
+			if got := sorted(collect(t, left.Join(regions, []string{"region"}, tt.how))); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Rows: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := left.Join(regions, []string{"region"}, LeftOuter).Schema().String(), "(region string not null, item string not null, name string)"; got != want {
+		t.Errorf("Left join schema: got %s, want %s", got, want)
+	}
+	if err := sales().Join(sales(), []string{"region"}, Inner).Err(); err == nil || !strings.Contains(err.Error(), "duplicate column") {
+		t.Errorf("Joining sides sharing columns: got %v, want a duplicate column error", err)
+	}
+}
+
+func TestDataFrame_OrderByLimit(t *testing.T) {
+	df := sales().
+		OrderBy(Desc(expr.Col("region")), Asc(expr.Col("amount"))).
+		Select(expr.Col("region"), expr.Col("amount"))
+	want := []string{"[us, 4]", "[us, 10]", "[eu, 1]", "[eu, 3]", "[asia, 2]"}
+	if got := collect(t, df); !reflect.DeepEqual(got, want) {
+		t.Errorf("Rows: got %v, want %v", got, want)
+	}
+	if got := collect(t, df.Limit(2)); !reflect.DeepEqual(got, want[:2]) {
+		t.Errorf("Limited rows: got %v, want %v", got, want[:2])
+	}
+	explained := df.Limit(2).Explain()
+	wantPlan := "Limit 2\n  Project [region, amount]\n    Sort [region desc, amount]\n      Scan (region string not null, item string not null, amount long not null, price double not null, note string)\n"
+	if explained != wantPlan {
+		t.Errorf("Explain: got\n%s\nwant\n%s", explained, wantPlan)
+	}
+}
+
+func TestDataFrame_TypedRoundTrip(t *testing.T) {
+	type total struct {
+		Region string `col:"region"`
+		Amount int    `col:"total"`
+	}
+	df := sales().GroupBy("region").Agg(Sum(expr.Col("amount")).As("total"))
+	r, err := ToRDD[total](df)
+	if err != nil {
+		t.Fatalf("ToRDD failed with error: %v", err)
+	}
+	records, err := r.Collect(context.Background(), scheduler.New(scheduler.Config{Parallelism: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []total
+	for _, rec := range records {
+		got = append(got, rec.(total))
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].Region < got[j].Region })
+	if want := []total{{"asia", 2}, {"eu", 4}, {"us", 14}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Typed rows: got %v, want %v", got, want)
+	}
+}
+
+func TestDataFrame_AnalysisErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		df   *DataFrame
+		want string
+	}{
+		{"unknown column", sales().Select(expr.Col("missing")), `unknown column "missing"`},
+		{"non-boolean predicate", sales().Where(expr.Col("amount")), "not a boolean"},
+		{"string arithmetic", sales().WithColumn("x", expr.Mul(expr.Col("item"), expr.Lit(2))), "not numbers"},
+		{"sum of strings", sales().GroupBy("region").Agg(Sum(expr.Col("item"))), "not a number"},
+		{"unknown group column", sales().GroupBy("missing").Agg(CountAll()), `unknown column "missing"`},
+		{"propagated", sales().Select(expr.Col("missing")).Where(expr.Lit(true)).Limit(1), `unknown column "missing"`},
+		{"unknown join type", sales().Join(sales(), []string{"region"}, "cross"), "unknown join type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.df.Err()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Err: got %v, want an error containing %q", err, tt.want)
+			}
+			if _, err := tt.df.Collect(context.Background(), scheduler.New(scheduler.Config{})); err == nil {
+				t.Errorf("Collect should fail with the analysis error")
+			}
+		})
+	}
+
+	df := New(rdd.NewKeyedRDD([]interface{}{map[string]interface{}{"n": nil}}, nil), schema.New(schema.Field{Name: "n", Type: schema.Long}))
+	if _, err := df.Collect(context.Background(), scheduler.New(scheduler.Config{})); err == nil || !strings.Contains(err.Error(), "not nullable") {
+		t.Errorf("A null in a non-nullable column: got %v, want an error", err)
+	}
+}
+
+func TestDataFrame_JoinOnAndDistinct(t *testing.T) {
+	prices := New(rdd.NewKeyedRDD([]interface{}{
+		map[string]interface{}{"fruit": "apple", "min_amount": 1, "discount": 0.1},
+		map[string]interface{}{"fruit": "apple", "min_amount": 5, "discount": 0.2},
+		map[string]interface{}{"fruit": "pear", "min_amount": 2, "discount": 0.3},
+	}, nil), schema.New(
+		schema.Field{Name: "fruit", Type: schema.String},
+		schema.Field{Name: "min_amount", Type: schema.Long},
+		schema.Field{Name: "discount", Type: schema.Double},
+	))
+	left := sales().Select(expr.Col("region"), expr.Col("item"), expr.Col("amount"))
+	condition := expr.And(expr.Eq(expr.Col("item"), expr.Col("fruit")), expr.Ge(expr.Col("amount"), expr.Col("min_amount")))
+
+	joined := left.JoinOn(prices, condition, Inner).Select(expr.Col("region"), expr.Col("item"), expr.Col("discount"))
+	want := []string{"[asia, pear, 0.3]", "[eu, apple, 0.1]", "[us, apple, 0.1]", "[us, apple, 0.2]"}
+	if got := sorted(collect(t, joined)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Inner join on a condition: got %v, want %v", got, want)
+	}
+	// eu buys one pear, below the minimum amount, so only the residual rules it out
+	anti := left.JoinOn(prices, condition, LeftAnti)
+	if got, want := sorted(collect(t, anti)), []string{"[eu, pear, 1]", "[us, plum, 4]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Anti join on a condition: got %v, want %v", got, want)
+	}
+	if got, want := left.JoinOn(prices, condition, LeftOuter).Explain(), "Join left on ((item = fruit) and (amount >= min_amount))\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("Explain: got %q, want a prefix %q", got, want)
+	}
+
+	distinct := sales().Select(expr.Col("item")).Distinct()
+	if got, want := sorted(collect(t, distinct)), []string{"[apple]", "[pear]", "[plum]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Distinct: got %v, want %v", got, want)
+	}
+}
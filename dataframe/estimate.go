@@ -0,0 +1,397 @@
+package dataframe
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/bajor/spark-go-core/expr"
+	"github.com/bajor/spark-go-core/stats"
+)
+
+// Selectivities of predicates the statistics say nothing about
+const (
+	equalSelectivity   = 0.1
+	rangeSelectivity   = 1.0 / 3
+	defaultSelectivity = 0.5
+)
+
+// defaultWidth is the assumed size in bytes of a value of unknown size
+const defaultWidth = 8
+
+// estimate is the estimated output of an operator, derived from the statistics of
+// the sources below it
+type estimate struct {
+	// known is false when a source below has no statistics; nothing else is set then
+	known bool
+	rows  float64
+	// columns estimates every output column
+	columns map[string]columnEstimate
+}
+
+// columnEstimate estimates the values of one column
+type columnEstimate struct {
+	// distinct is the number of distinct non-null values; 0 is unknown
+	distinct float64
+	// nulls is the fraction of null values
+	nulls    float64
+	min, max interface{}
+	// width is the average size of a value in bytes
+	width float64
+}
+
+// bytes estimates the size of the output
+func (e estimate) bytes() float64 {
+	width := 0.0
+	for _, c := range e.columns {
+		width += c.width
+	}
+	return e.rows * width
+}
+
+// estimatedRows returns the estimated rows rounded to an integer, at least 1 unless
+// no row is expected at all
+func (e estimate) estimatedRows() int64 {
+	if e.rows > 0 && e.rows < 1 {
+		return 1
+	}
+	return int64(math.Round(e.rows))
+}
+
+// withRows returns the estimate for a subset of rows, capping the distinct values
+func (e estimate) withRows(rows float64) estimate {
+	out := estimate{known: e.known, rows: math.Max(rows, 0), columns: make(map[string]columnEstimate, len(e.columns))}
+	for name, c := range e.columns {
+		if c.distinct > out.rows {
+			c.distinct = out.rows
+		}
+		out.columns[name] = c
+	}
+	return out
+}
+
+// column returns the estimate of a column, defaulting its width
+func (e estimate) column(name string) columnEstimate {
+	c, ok := e.columns[name]
+	if !ok {
+		c.width = defaultWidth
+	}
+	return c
+}
+
+// estimateOf estimates the output of a plan
+func estimateOf(n node) estimate {
+	switch n := n.(type) {
+	case *scan:
+		s, ok := n.rdd.Statistics()
+		if !ok {
+			return estimate{}
+		}
+		est := scanEstimate(s, n)
+		for _, p := range n.filters {
+			est = filterEstimate(est, p)
+		}
+		return est
+	case *filter:
+		return filterEstimate(estimateOf(n.child), n.predicate)
+	case *project:
+		child := estimateOf(n.child)
+		if !child.known {
+			return child
+		}
+		out := estimate{known: true, rows: child.rows, columns: make(map[string]columnEstimate, len(n.exprs))}
+		for i, e := range n.exprs {
+			c := columnEstimate{width: defaultWidth}
+			if col, ok := unalias(e).(expr.Column); ok {
+				c = child.column(col.Name)
+			}
+			out.columns[n.out.Fields[i].Name] = c
+		}
+		return out
+	case *sortRows:
+		return estimateOf(n.child)
+	case *limit:
+		child := estimateOf(n.child)
+		if !child.known {
+			return child
+		}
+		return child.withRows(math.Min(child.rows, float64(n.n)))
+	case *aggregate:
+		return aggregateEstimate(estimateOf(n.child), n)
+	case *join:
+		return joinEstimate(estimateOf(n.left), estimateOf(n.right), n)
+	}
+	return estimate{}
+}
+
+// scanEstimate turns the statistics of a scan's source into an estimate of the
+// scan's columns
+func scanEstimate(s stats.Statistics, n *scan) estimate {
+	est := estimate{known: true, rows: float64(s.Rows), columns: make(map[string]columnEstimate, len(n.out.Fields))}
+	width := defaultWidth * 1.0
+	if s.Rows > 0 && len(s.Columns) > 0 {
+		width = float64(s.Bytes) / float64(s.Rows) / float64(len(s.Columns))
+	}
+	for _, f := range n.out.Fields {
+		c := columnEstimate{width: width}
+		if col, ok := s.Columns[f.Name]; ok {
+			c.distinct = float64(col.Distinct)
+			c.min, c.max = col.Min, col.Max
+			if s.Rows > 0 {
+				c.nulls = float64(col.Nulls) / float64(s.Rows)
+				c.width = float64(col.Bytes) / float64(s.Rows)
+			}
+		}
+		est.columns[f.Name] = c
+	}
+	return est
+}
+
+// filterEstimate estimates the rows of an input for which a predicate is true. A
+// column compared for equality with a literal keeps a single distinct value.
+func filterEstimate(in estimate, predicate expr.Expr) estimate {
+	if !in.known {
+		return in
+	}
+	out := in.withRows(in.rows * selectivity(predicate, in))
+	for _, c := range conjuncts(predicate) {
+		if name, _, ok := columnLiteral(c, "="); ok {
+			col := out.columns[name]
+			col.distinct, col.nulls = math.Min(col.distinct, 1), 0
+			out.columns[name] = col
+		}
+	}
+	return out
+}
+
+// selectivity estimates the fraction of rows for which a predicate is true
+func selectivity(e expr.Expr, in estimate) float64 {
+	switch e := e.(type) {
+	case expr.Literal:
+		if b, ok := e.Value.(bool); ok && b {
+			return 1
+		}
+		return 0
+	case expr.Unary:
+		if e.Op == "not" {
+			return 1 - selectivity(e.Operand, in)
+		}
+	case expr.Call:
+		if c, ok := singleColumn(e); ok && e.Func == "isnull" {
+			return in.column(c).nulls
+		}
+	case expr.Binary:
+		switch e.Op {
+		case "and":
+			return selectivity(e.Left, in) * selectivity(e.Right, in)
+		case "or":
+			l, r := selectivity(e.Left, in), selectivity(e.Right, in)
+			return l + r - l*r
+		case "=":
+			return equality(e, in)
+		case "!=":
+			return 1 - equality(e, in)
+		case "<", "<=", ">", ">=":
+			return inRange(e, in)
+		}
+	}
+	return defaultSelectivity
+}
+
+// equality estimates the selectivity of an equality: one distinct value of a column
+// compared to a literal, or the values the smaller of two compared columns shares
+// with the larger
+func equality(e expr.Binary, in estimate) float64 {
+	if name, _, ok := columnLiteral(e, "="); ok {
+		c := in.column(name)
+		if c.distinct > 0 {
+			return (1 - c.nulls) / c.distinct
+		}
+		return equalSelectivity
+	}
+	l, lok := e.Left.(expr.Column)
+	r, rok := e.Right.(expr.Column)
+	if lok && rok {
+		if d := math.Max(in.column(l.Name).distinct, in.column(r.Name).distinct); d > 0 {
+			return 1 / d
+		}
+	}
+	return equalSelectivity
+}
+
+// inRange estimates the fraction of a column's values below or above a literal by
+// interpolating between the column's minimum and maximum
+func inRange(e expr.Binary, in estimate) float64 {
+	name, v, ok := columnLiteral(e, e.Op)
+	if !ok {
+		return rangeSelectivity
+	}
+	c := in.column(name)
+	lo, lok := toFloat(c.min)
+	hi, hok := toFloat(c.max)
+	x, xok := toFloat(v)
+	if !lok || !hok || !xok {
+		return rangeSelectivity
+	}
+	below := 1.0
+	if hi > lo {
+		below = (x - lo) / (hi - lo)
+	} else if x < lo {
+		below = 0
+	}
+	below = math.Min(math.Max(below, 0), 1)
+	op := e.Op
+	if _, ok := e.Left.(expr.Literal); ok {
+		// lit < col is col > lit
+		op = map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<="}[op]
+	}
+	if op == ">" || op == ">=" {
+		below = 1 - below
+	}
+	return below * (1 - c.nulls)
+}
+
+// columnLiteral matches a comparison of a column with a literal by op, returning the
+// column and the literal
+func columnLiteral(e expr.Expr, op string) (string, interface{}, bool) {
+	b, ok := e.(expr.Binary)
+	if !ok || b.Op != op {
+		return "", nil, false
+	}
+	if c, ok := b.Left.(expr.Column); ok {
+		if l, ok := b.Right.(expr.Literal); ok {
+			return c.Name, l.Value, true
+		}
+	}
+	if c, ok := b.Right.(expr.Column); ok {
+		if l, ok := b.Left.(expr.Literal); ok {
+			return c.Name, l.Value, true
+		}
+	}
+	return "", nil, false
+}
+
+// singleColumn returns the column a function is called on
+func singleColumn(c expr.Call) (string, bool) {
+	if len(c.Args) != 1 {
+		return "", false
+	}
+	col, ok := c.Args[0].(expr.Column)
+	return col.Name, ok
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// aggregateEstimate estimates one row per combination of the group columns' distinct
+// values, at most one per input row
+func aggregateEstimate(in estimate, a *aggregate) estimate {
+	if !in.known {
+		return in
+	}
+	if len(a.groups) == 0 {
+		out := estimate{known: true, rows: 1, columns: make(map[string]columnEstimate, len(a.out.Fields))}
+		for _, f := range a.out.Fields {
+			out.columns[f.Name] = columnEstimate{distinct: 1, width: defaultWidth}
+		}
+		return out
+	}
+	groups := 1.0
+	for _, g := range a.groups {
+		c := in.column(g)
+		if c.distinct == 0 {
+			groups = in.rows
+			break
+		}
+		d := c.distinct
+		if c.nulls > 0 {
+			d++
+		}
+		groups *= d
+	}
+	out := estimate{known: true, rows: math.Min(groups, in.rows), columns: make(map[string]columnEstimate, len(a.out.Fields))}
+	for _, f := range a.out.Fields {
+		if c, ok := in.columns[f.Name]; ok && contains(a.groups, f.Name) {
+			out.columns[f.Name] = c
+		} else {
+			out.columns[f.Name] = columnEstimate{width: defaultWidth}
+		}
+	}
+	return out.withRows(out.rows)
+}
+
+// joinEstimate estimates the pairs sharing a key as the product of the sides' rows
+// divided, per key, by the larger number of distinct values, assuming the keys of
+// the side with fewer values all appear on the other
+func joinEstimate(l, r estimate, j *join) estimate {
+	if !l.known || !r.known {
+		return estimate{}
+	}
+	both := estimate{known: true, columns: make(map[string]columnEstimate, len(l.columns)+len(r.columns))}
+	for name, c := range r.columns {
+		both.columns[name] = c
+	}
+	for name, c := range l.columns {
+		both.columns[name] = c
+	}
+	pairs := l.rows * r.rows
+	for i := range j.leftKeys {
+		lc, rc := l.column(j.leftKeys[i]), r.column(j.rightKeys[i])
+		d := math.Max(lc.distinct, rc.distinct)
+		if d == 0 {
+			// without distinct counts assume a key of the larger side
+			d = math.Max(math.Max(l.rows, r.rows), 1)
+		}
+		pairs *= (1 - lc.nulls) * (1 - rc.nulls) / d
+	}
+	if j.residual != nil {
+		pairs *= selectivity(j.residual, both)
+	}
+	rows := pairs
+	switch j.how {
+	case LeftOuter:
+		rows = math.Max(pairs, l.rows)
+	case RightOuter:
+		rows = math.Max(pairs, r.rows)
+	case FullOuter:
+		rows = math.Max(pairs, math.Max(l.rows, r.rows))
+	case LeftSemi:
+		rows = math.Min(pairs, l.rows)
+	case LeftAnti:
+		rows = math.Max(l.rows-math.Min(pairs, l.rows), 0)
+	}
+	out := both.withRows(rows)
+	for name := range out.columns {
+		if j.out.Index(name) < 0 {
+			delete(out.columns, name)
+		}
+	}
+	return out
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// describeEstimate annotates an operator with its estimated rows, where known
+func describeEstimate(est estimate) string {
+	if !est.known {
+		return ""
+	}
+	return " (estimated rows " + strconv.FormatInt(est.estimatedRows(), 10) + ")"
+}
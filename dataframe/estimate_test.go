@@ -0,0 +1,319 @@
+package dataframe
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bajor/spark-go-core/expr"
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/scheduler"
+	"github.com/bajor/spark-go-core/schema"
+	"github.com/bajor/spark-go-core/stats"
+)
+
+// statsSource is a source of record maps knowing their statistics up front, like a
+// Parquet source reading them from file footers
+type statsSource struct {
+	records []interface{}
+	stats   stats.Statistics
+}
+
+func (s statsSource) NumPartitions() int { return 1 }
+
+func (s statsSource) Open(int) (lazy.SourceIterator, error) {
+	return sliceSource{SliceIterator: lazy.NewSliceIterator(s.records)}, nil
+}
+
+func (s statsSource) Statistics() (stats.Statistics, bool) { return s.stats, true }
+
+// table creates a DataFrame of n rows with long columns, whose statistics the
+// planner knows, with exact distinct counts. value returns the value of a column in
+// a row.
+func table(n int, columns []string, value func(column string, row int) interface{}) *DataFrame {
+	records := make([]interface{}, n)
+	c := stats.NewCollector()
+	distinct := make(map[string]map[interface{}]bool, len(columns))
+	for i := range records {
+		record := make(map[string]interface{}, len(columns))
+		for _, name := range columns {
+			v := value(name, i)
+			record[name] = v
+			if distinct[name] == nil {
+				distinct[name] = make(map[interface{}]bool)
+			}
+			if v != nil {
+				distinct[name][v] = true
+			}
+		}
+		records[i] = record
+		c.Add(record)
+	}
+	st := c.Statistics()
+	for name, col := range st.Columns {
+		col.Distinct = int64(len(distinct[name]))
+		st.Columns[name] = col
+	}
+	fields := make([]schema.Field, len(columns))
+	for i, name := range columns {
+		fields[i] = schema.Field{Name: name, Type: schema.Long, Nullable: true}
+	}
+	return New(rdd.FromSource(statsSource{records: records, stats: st}), schema.New(fields...))
+}
+
+// facts has 1000 rows whose key takes 100 values, each 10 times, and whose value is
+// unique from 0 to 999
+func facts() *DataFrame {
+	return table(1000, []string{"key", "value"}, func(column string, row int) interface{} {
+		if column == "key" {
+			return int64(row % 100)
+		}
+		return int64(row)
+	})
+}
+
+// dims has one row per key of facts, named by id
+func dims() *DataFrame {
+	return table(100, []string{"id", "weight"}, func(column string, row int) interface{} {
+		if column == "weight" && row%4 == 0 {
+			return nil
+		}
+		return int64(row)
+	})
+}
+
+// estimatedRows returns the estimated rows of the root of a plan
+func estimatedRows(t *testing.T, df *DataFrame) float64 {
+	t.Helper()
+	if df.err != nil {
+		t.Fatal(df.err)
+	}
+	est := estimateOf(df.plan)
+	if !est.known {
+		t.Fatalf("No estimate for\n%s", df.Explain())
+	}
+	return est.rows
+}
+
+func TestEstimate_Cardinalities(t *testing.T) {
+	key, value, id, weight := expr.Col("key"), expr.Col("value"), expr.Col("id"), expr.Col("weight")
+	tests := []struct {
+		name string
+		df   *DataFrame
+		want float64
+	}{
+		{"scan", facts(), 1000},
+		{"equality", facts().Where(expr.Eq(key, expr.Lit(7))), 10},
+		{"range", facts().Where(expr.Lt(value, expr.Lit(250))), 250},
+		{"reversed range", facts().Where(expr.Lt(expr.Lit(250), value)), 750},
+		{"conjunction", facts().Where(expr.And(expr.Eq(key, expr.Lit(7)), expr.Ge(value, expr.Lit(500)))), 5},
+		{"disjunction", facts().Where(expr.Or(expr.Eq(key, expr.Lit(7)), expr.Eq(key, expr.Lit(8)))), 19.9},
+		{"negation", facts().Where(expr.Not(expr.Eq(key, expr.Lit(7)))), 990},
+		{"is null", dims().Where(expr.Fn("isnull", weight)), 25},
+		{"null fraction", dims().Where(expr.Gt(weight, expr.Lit(-1))), 75},
+		{"projection", facts().Select(key).Where(expr.Eq(key, expr.Lit(1))), 10},
+		{"limit", facts().Limit(10), 10},
+		{"group", facts().GroupBy("key").Agg(CountAll()), 100},
+		{"distinct", facts().Select(key).Distinct(), 100},
+		{"global aggregate", facts().Agg(Sum(value)), 1},
+		{"inner join", facts().JoinOn(dims(), expr.Eq(key, id), Inner), 1000},
+		{"filtered join", facts().JoinOn(dims().Where(expr.Lt(id, expr.Lit(10))), expr.Eq(key, id), Inner), 101},
+		{"left join", dims().JoinOn(facts().Where(expr.Lt(value, expr.Lit(10))), expr.Eq(key, id), LeftOuter), 100},
+		{"semi join", dims().JoinOn(facts(), expr.Eq(key, id), LeftSemi), 100},
+		{"anti join", dims().JoinOn(facts().Where(expr.Eq(key, expr.Lit(7))), expr.Eq(key, id), LeftAnti), 90},
+		{"anti join matching every row", dims().JoinOn(facts().Where(expr.Lt(value, expr.Lit(500))), expr.Eq(key, id), LeftAnti), 0},
+		{"cross join", dims().JoinOn(dims().Select(expr.As(id, "other")), nil, Inner), 10000},
+	}
+	for _, tt := range tests {
+		got := estimatedRows(t, tt.df)
+		if math.Abs(got-tt.want) > 0.01*tt.want {
+			t.Errorf("%s: estimated %v rows, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if est := estimateOf(people().plan); est.known {
+		t.Errorf("Estimated %v rows of a DataFrame without statistics", est.rows)
+	}
+	if est := estimateOf(facts().JoinOn(people(), nil, Inner).plan); est.known {
+		t.Errorf("Estimated %v rows for a plan reading a source without statistics", est.rows)
+	}
+}
+
+// withPlannerSizes runs f with lower size thresholds, so that small tables are
+// planned like large ones
+func withPlannerSizes(t *testing.T, broadcast, partition float64, f func()) {
+	t.Helper()
+	oldBroadcast, oldPartition := broadcastBytes, partitionBytes
+	broadcastBytes, partitionBytes = broadcast, partition
+	defer func() { broadcastBytes, partitionBytes = oldBroadcast, oldPartition }()
+	f()
+}
+
+// stripEstimates removes the estimated rows from a plan
+func stripEstimates(plan string) string {
+	return regexp.MustCompile(` \(estimated rows \d+\)`).ReplaceAllString(plan, "")
+}
+
+func TestPlanner_JoinStrategyAndPartitions(t *testing.T) {
+	key, id := expr.Col("key"), expr.Col("id")
+	withPlannerSizes(t, 4096, 8192, func() {
+		tests := []struct {
+			df   *DataFrame
+			want string
+		}{
+			{
+				facts().JoinOn(dims(), expr.Eq(key, id), Inner),
+				"Join inner on (key = id) broadcast right",
+			},
+			{
+				dims().JoinOn(facts(), expr.Eq(key, id), Inner),
+				"Join inner on (key = id) broadcast left",
+			},
+			{
+				dims().JoinOn(facts(), expr.Eq(key, id), LeftOuter),
+				"Join left on (key = id) partitions 3",
+			},
+			{
+				facts().JoinOn(dims(), expr.Eq(key, id), FullOuter),
+				"Join full on (key = id) partitions 3",
+			},
+			{
+				facts().Join(facts().Select(key, expr.As(expr.Col("value"), "other")), []string{"key"}, Inner),
+				"Join inner [key] partitions 4",
+			},
+			{
+				facts().GroupBy("key").Agg(Sum(expr.Col("value"))),
+				"Aggregate [key] [sum(value)] partitions 2",
+			},
+		}
+		for _, tt := range tests {
+			plan := tt.df.Optimized().Explain()
+			if got := stripEstimates(strings.SplitN(plan, "\n", 2)[0]); got != tt.want {
+				t.Errorf("Planned\n%s\ngot  %s\nwant %s", plan, got, tt.want)
+			}
+			if got, want := sorted(collect(t, tt.df)), sorted(rowsAsWritten(t, tt.df)); !reflect.DeepEqual(got, want) {
+				t.Errorf("Rows of\n%s\ngot  %v\nwant %v", plan, got, want)
+			}
+		}
+	})
+	if got := facts().JoinOn(dims(), expr.Eq(key, id), Inner).Optimized().Explain(); !strings.HasPrefix(got, "Join inner on (key = id) broadcast right (estimated rows 1000)\n") {
+		t.Errorf("With the default sizes\n%s", got)
+	}
+	if got := people().JoinOn(orders(), expr.Eq(id, expr.Col("person")), Inner).Optimized().Explain(); !strings.HasPrefix(got, "Join inner on (id = person)\n") {
+		t.Errorf("Without statistics the default strategy is kept:\n%s", got)
+	}
+}
+
+// rowsAsWritten evaluates the plan of a DataFrame without optimizing it
+func rowsAsWritten(t *testing.T, df *DataFrame) []string {
+	t.Helper()
+	r, err := compile(df.plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := r.Collect(context.Background(), scheduler.New(scheduler.Config{Parallelism: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make([]string, len(records))
+	for i, record := range records {
+		rows[i] = Row{Schema: df.Schema(), Values: values(record, df.Schema())}.String()
+	}
+	return rows
+}
+
+func TestPlanner_ReordersJoins(t *testing.T) {
+	// customers joined with all their orders first gives 10000 rows, while orders
+	// joined with the 10 cities first gives 10
+	customers := table(100, []string{"customer", "city"}, func(column string, row int) interface{} {
+		return int64(row % 10)
+	})
+	orders := table(1000, []string{"order_city", "code"}, func(column string, row int) interface{} {
+		return int64(row)
+	})
+	cities := table(10, []string{"city_code", "size"}, func(column string, row int) interface{} {
+		return int64(row)
+	})
+	df := customers.
+		JoinOn(orders, expr.Eq(expr.Col("city"), expr.Col("order_city")), Inner).
+		JoinOn(cities, expr.Eq(expr.Col("code"), expr.Col("city_code")), Inner).
+		Select(expr.Col("customer"), expr.Col("size"))
+
+	want := "Project [customer, size] (estimated rows 100)\n" +
+		"  Join inner on (city = order_city) broadcast left (estimated rows 100)\n" +
+		"    Join inner on (code = city_code) broadcast right (estimated rows 10)\n" +
+		"      Scan (order_city long, code long) (estimated rows 1000)\n" +
+		"      Scan (city_code long, size long) (estimated rows 10)\n" +
+		"    Scan (customer long, city long) (estimated rows 100)\n"
+	if got := df.Optimized().Explain(); got != want {
+		t.Errorf("Reordered plan of\n%s\ngot\n%s\nwant\n%s", df.Explain(), got, want)
+	}
+	if got, want := sorted(collect(t, df)), sorted(rowsAsWritten(t, df)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Rows: got %v, want %v", got, want)
+	}
+
+	// without statistics the order is kept
+	unknown := people().
+		JoinOn(orders, expr.Eq(expr.Col("id"), expr.Col("order_city")), Inner).
+		JoinOn(cities, expr.Eq(expr.Col("code"), expr.Col("city_code")), Inner)
+	if got := unknown.Optimized().Explain(); !strings.HasPrefix(stripEstimates(got), "Join inner on (code = city_code) broadcast right\n  Join inner on (id = order_city) broadcast right\n") {
+		t.Errorf("Plan without statistics reordered:\n%s", got)
+	}
+}
+
+func TestExplain_EstimatedAndActualRows(t *testing.T) {
+	df := facts().Where(expr.Lt(expr.Col("value"), expr.Lit(100))).GroupBy("key").Agg(CountAll().As("n"))
+	if strings.Contains(df.Explain(), "Executed plan") {
+		t.Errorf("Executed plan explained before an action:\n%s", df.Explain())
+	}
+	if got := collect(t, df); len(got) != 100 {
+		t.Fatalf("Got %d groups, want 100", len(got))
+	}
+	want := "Aggregate [key] [count(*) as n] (estimated rows 100)\n" +
+		"  Filter (value < 100) (estimated rows 100)\n" +
+		"    Scan (key long, value long) (estimated rows 1000)\n" +
+		"Executed plan:\n" +
+		"Aggregate [key] [count(*) as n] partitions 1 (estimated rows 100, actual rows 100)\n" +
+		"  Scan (key long, value long) filters [(value < 100)] (estimated rows 100, actual rows 100)\n"
+	if got := df.Explain(); got != want {
+		t.Errorf("Explain after Collect: got\n%s\nwant\n%s", got, want)
+	}
+
+	plain := people().Where(expr.Gt(expr.Col("age"), expr.Lit(18)))
+	collect(t, plain)
+	if got, want := plain.Explain(), "Filter (age > 18)\n  "+peopleScan+"\nExecuted plan:\n"+peopleScan+" filters [(age > 18)] (actual rows 2)\n"; got != want {
+		t.Errorf("Explain without statistics: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDataFrame_CacheGathersStatistics(t *testing.T) {
+	cached := people().Cache()
+	if est := estimateOf(cached.plan); est.known {
+		t.Errorf("Estimated %v rows before the cache was filled", est.rows)
+	}
+	if got := collect(t, cached); len(got) != 4 {
+		t.Fatalf("Got %d rows, want 4", len(got))
+	}
+	named := cached.Where(expr.Eq(expr.Col("name"), expr.Lit("ann")))
+	if got := estimatedRows(t, named); got != 1 {
+		t.Errorf("Estimated %v rows named ann, want 1", got)
+	}
+	if got, want := collect(t, named), []string{"[1, ann, 34]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Rows: got %v, want %v", got, want)
+	}
+	if got := named.Explain(); !strings.Contains(got, "(estimated rows 1, actual rows 1)") {
+		t.Errorf("Explain of the cached DataFrame:\n%s", got)
+	}
+}
+
+func TestEstimate_RowsRoundUp(t *testing.T) {
+	for rows, want := range map[float64]int64{0: 0, 0.2: 1, 1.4: 1, 2.5: 3} {
+		if got := (estimate{known: true, rows: rows}).estimatedRows(); got != want {
+			t.Errorf("estimatedRows of %v: got %d, want %d", rows, got, want)
+		}
+	}
+}
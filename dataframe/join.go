@@ -0,0 +1,402 @@
+package dataframe
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bajor/spark-go-core/expr"
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/schema"
+)
+
+// JoinType selects the rows a Join keeps
+type JoinType string
+
+const (
+	// Inner keeps the pairs of matching rows
+	Inner JoinType = "inner"
+	// LeftOuter also keeps the left rows without a match, with nulls on the right
+	LeftOuter JoinType = "left"
+	// RightOuter also keeps the right rows without a match, with nulls on the left
+	RightOuter JoinType = "right"
+	// FullOuter keeps the rows of both sides, matched or not
+	FullOuter JoinType = "full"
+	// LeftSemi keeps the left rows with a match, and only the left columns
+	LeftSemi JoinType = "semi"
+	// LeftAnti keeps the left rows without a match, and only the left columns
+	LeftAnti JoinType = "anti"
+)
+
+// join matches the rows of two plans on equal values of key columns, and on a
+// residual condition evaluated on every pair sharing a key. Rows holding a null key
+// never match.
+type join struct {
+	left, right         node
+	leftKeys, rightKeys []string
+	// using joins on same-named columns, which the output holds once
+	using    bool
+	residual expr.Expr
+	// condition is the join condition as given to JoinOn, for describe
+	condition expr.Expr
+	how       JoinType
+	out       schema.Schema
+	// strategy and partitions are chosen by the planner from the estimated size of
+	// the sides, see choosePhysical
+	strategy   rdd.JoinStrategy
+	partitions int
+}
+
+func checkJoinType(how JoinType) error {
+	switch how {
+	case Inner, LeftOuter, RightOuter, FullOuter, LeftSemi, LeftAnti:
+		return nil
+	}
+	return fmt.Errorf("unknown join type %q", how)
+}
+
+// newUsingJoin joins on same-named columns of both sides
+func newUsingJoin(left, right node, on []string, how JoinType) (*join, error) {
+	if err := checkJoinType(how); err != nil {
+		return nil, err
+	}
+	if len(on) == 0 {
+		return nil, fmt.Errorf("join needs at least one key column")
+	}
+	ls, rs := left.schema(), right.schema()
+	keys := make(map[string]bool, len(on))
+	var fields []schema.Field
+	for _, c := range on {
+		lf, ok := ls.Field(c)
+		if !ok {
+			return nil, fmt.Errorf("join: unknown column %q in left side %s", c, ls)
+		}
+		rf, ok := rs.Field(c)
+		if !ok {
+			return nil, fmt.Errorf("join: unknown column %q in right side %s", c, rs)
+		}
+		t, err := keyType(lf, rf)
+		if err != nil {
+			return nil, err
+		}
+		f := lf
+		f.Type = t
+		switch how {
+		case RightOuter:
+			f.Nullable = rf.Nullable
+		case FullOuter:
+			f.Nullable = lf.Nullable || rf.Nullable
+		}
+		keys[c] = true
+		fields = append(fields, f)
+	}
+	for _, f := range ls.Fields {
+		if !keys[f.Name] {
+			fields = append(fields, outerField(f, how == RightOuter || how == FullOuter))
+		}
+	}
+	for _, f := range rs.Fields {
+		if !keys[f.Name] {
+			fields = append(fields, outerField(f, how == LeftOuter || how == FullOuter))
+		}
+	}
+	if how == LeftSemi || how == LeftAnti {
+		fields = ls.Fields
+	}
+	out := schema.New(fields...)
+	if err := checkNames(out); err != nil {
+		return nil, fmt.Errorf("join: %w", err)
+	}
+	return &join{left: left, right: right, leftKeys: on, rightKeys: on, using: true, how: how, out: out}, nil
+}
+
+// newConditionJoin joins on a condition. Its conjuncts equating a left column with a
+// right column become the keys; the others form the residual condition.
+func newConditionJoin(left, right node, condition expr.Expr, how JoinType) (*join, error) {
+	if err := checkJoinType(how); err != nil {
+		return nil, err
+	}
+	ls, rs := left.schema(), right.schema()
+	var fields []schema.Field
+	for _, f := range ls.Fields {
+		fields = append(fields, outerField(f, how == RightOuter || how == FullOuter))
+	}
+	for _, f := range rs.Fields {
+		fields = append(fields, outerField(f, how == LeftOuter || how == FullOuter))
+	}
+	both := schema.New(fields...)
+	if err := checkNames(both); err != nil {
+		return nil, fmt.Errorf("join: %w", err)
+	}
+	j := &join{left: left, right: right, condition: condition, how: how, out: both}
+	if how == LeftSemi || how == LeftAnti {
+		j.out = ls
+	}
+	if condition == nil {
+		return j, nil
+	}
+	if f, err := resolve(condition, both); err != nil {
+		return nil, fmt.Errorf("join on %s: %w", condition, err)
+	} else if f.Type != schema.Bool && f.Type != schema.Null && f.Type != schema.Any {
+		return nil, fmt.Errorf("join on %s: the condition is a %s, not a boolean", condition, f.Type)
+	}
+	for _, c := range conjuncts(condition) {
+		if l, r, ok := keyPair(c, ls, rs); ok {
+			lf, _ := ls.Field(l)
+			rf, _ := rs.Field(r)
+			if _, err := keyType(lf, rf); err == nil {
+				j.leftKeys = append(j.leftKeys, l)
+				j.rightKeys = append(j.rightKeys, r)
+				continue
+			}
+		}
+		if j.residual == nil {
+			j.residual = c
+		} else {
+			j.residual = expr.And(j.residual, c)
+		}
+	}
+	return j, nil
+}
+
+// keyPair reports whether e equates a column of the left side with one of the right
+func keyPair(e expr.Expr, ls, rs schema.Schema) (string, string, bool) {
+	b, ok := e.(expr.Binary)
+	if !ok || b.Op != "=" {
+		return "", "", false
+	}
+	l, lok := b.Left.(expr.Column)
+	r, rok := b.Right.(expr.Column)
+	if !lok || !rok {
+		return "", "", false
+	}
+	switch {
+	case ls.Index(l.Name) >= 0 && rs.Index(r.Name) >= 0:
+		return l.Name, r.Name, true
+	case ls.Index(r.Name) >= 0 && rs.Index(l.Name) >= 0:
+		return r.Name, l.Name, true
+	}
+	return "", "", false
+}
+
+// conjuncts splits a condition on its top-level ands
+func conjuncts(e expr.Expr) []expr.Expr {
+	if b, ok := e.(expr.Binary); ok && b.Op == "and" {
+		return append(conjuncts(b.Left), conjuncts(b.Right)...)
+	}
+	return []expr.Expr{e}
+}
+
+// keyType returns the type two key columns are compared as
+func keyType(lf, rf schema.Field) (schema.Type, error) {
+	switch {
+	case lf.Type == rf.Type:
+		return lf.Type, nil
+	case numeric(lf.Type) && numeric(rf.Type):
+		return schema.Merge(lf.Type, rf.Type), nil
+	}
+	return 0, fmt.Errorf("join: key %s is a %s on the left and %s a %s on the right", lf.Name, lf.Type, rf.Name, rf.Type)
+}
+
+// outerField makes a field nullable when its side of an outer join may be missing
+func outerField(f schema.Field, missing bool) schema.Field {
+	f.Nullable = f.Nullable || missing
+	return f
+}
+
+func (j *join) schema() schema.Schema { return j.out }
+func (j *join) children() []node      { return []node{j.left, j.right} }
+
+func (j *join) withChildren(children []node) node {
+	c := *j
+	c.left, c.right = children[0], children[1]
+	return &c
+}
+
+func (j *join) describe() string {
+	d := "Join " + string(j.how)
+	switch {
+	case j.using:
+		d += " [" + strings.Join(j.leftKeys, ", ") + "]"
+	case j.condition != nil:
+		d += " on " + j.condition.String()
+	}
+	if j.strategy != rdd.ShuffleJoin {
+		d += " " + j.strategy.String()
+	} else if j.partitions > 0 {
+		d += " partitions " + strconv.Itoa(j.partitions)
+	}
+	return d
+}
+
+// compile joins the rows of both sides by key with the chosen strategy. Every call
+// of the join function groups the rows it is given by key; rows holding a null key
+// are unmatched.
+func (j *join) compile(inputs []*rdd.KeyedRDD) (*rdd.KeyedRDD, error) {
+	key := func(keys []string) func(interface{}) (interface{}, error) {
+		return func(record interface{}) (interface{}, error) {
+			key, _, err := groupKey(record.(map[string]interface{}), keys)
+			return key, err
+		}
+	}
+	how, residual, out := j.how, j.residual, j.out
+	leftKeys, rightKeys := j.leftKeys, j.rightKeys
+	type group struct {
+		left, right []map[string]interface{}
+	}
+	joinFunc := func(left, right []interface{}) ([]interface{}, error) {
+		groups := make(map[string]*group)
+		var order []string
+		var unmatched group
+		add := func(records []interface{}, keys []string, right bool) error {
+			for _, record := range records {
+				row := record.(map[string]interface{})
+				key, null, err := groupKey(row, keys)
+				if err != nil {
+					return err
+				}
+				g := groups[key]
+				switch {
+				case null:
+					// null keys equal nothing, not even each other
+					g = &unmatched
+				case g == nil:
+					g = &group{}
+					groups[key] = g
+					order = append(order, key)
+				}
+				if right {
+					g.right = append(g.right, row)
+				} else {
+					g.left = append(g.left, row)
+				}
+			}
+			return nil
+		}
+		if err := add(left, leftKeys, false); err != nil {
+			return nil, err
+		}
+		if err := add(right, rightKeys, true); err != nil {
+			return nil, err
+		}
+		rows, err := joinGroup(unmatched.left, nil, how, residual, out)
+		if err != nil {
+			return nil, err
+		}
+		more, err := joinGroup(nil, unmatched.right, how, residual, out)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, more...)
+		for _, key := range order {
+			g := groups[key]
+			more, err := joinGroup(g.left, g.right, how, residual, out)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, more...)
+		}
+		return rows, nil
+	}
+	opts := rdd.JoinOptions{
+		Strategy:           j.strategy,
+		Partitions:         j.partitions,
+		CanBroadcastLeft:   canBroadcastLeft(how),
+		CanBroadcastRight:  canBroadcastRight(how),
+		AdvisoryPartitions: true,
+	}
+	return inputs[0].WithKey(key(leftKeys)).JoinWithOptions(inputs[1].WithKey(key(rightKeys)), opts, joinFunc), nil
+}
+
+// canBroadcastRight reports whether a join keeps no unmatched rows of its right
+// side, which every partition of the left side joined with all of it would emit
+func canBroadcastRight(how JoinType) bool {
+	return how == Inner || how == LeftOuter || how == LeftSemi || how == LeftAnti
+}
+
+// canBroadcastLeft reports whether a join keeps no unmatched rows of its left side
+func canBroadcastLeft(how JoinType) bool {
+	return how == Inner || how == RightOuter
+}
+
+// joinGroup joins the rows of both sides sharing a key
+func joinGroup(left, right []map[string]interface{}, how JoinType, residual expr.Expr, out schema.Schema) ([]interface{}, error) {
+	var rows []interface{}
+	emit := func(l, r map[string]interface{}) error {
+		row, err := joinRow(l, r, out)
+		rows = append(rows, row)
+		return err
+	}
+	matchedRight := make([]bool, len(right))
+	for _, l := range left {
+		matched := false
+		for i, r := range right {
+			if residual != nil {
+				v, err := residual.Eval(mergeRows(l, r))
+				if err != nil {
+					return nil, err
+				}
+				if !expr.Truthy(v) {
+					continue
+				}
+			}
+			matched, matchedRight[i] = true, true
+			if how == LeftSemi || how == LeftAnti {
+				break
+			}
+			if err := emit(l, r); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case how == LeftSemi && matched, how == LeftAnti && !matched:
+			rows = append(rows, l)
+		case !matched && (how == LeftOuter || how == FullOuter):
+			if err := emit(l, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if how == RightOuter || how == FullOuter {
+		for i, r := range right {
+			if !matchedRight[i] {
+				if err := emit(nil, r); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return rows, nil
+}
+
+// mergeRows returns the columns of a left and a right row together
+func mergeRows(left, right map[string]interface{}) map[string]interface{} {
+	row := make(map[string]interface{}, len(left)+len(right))
+	for k, v := range right {
+		row[k] = v
+	}
+	for k, v := range left {
+		row[k] = v
+	}
+	return row
+}
+
+// joinRow merges a left and a right row, either of which may be missing; the key
+// columns of a join on same-named columns take their value from the left row when
+// it has one
+func joinRow(left, right map[string]interface{}, out schema.Schema) (map[string]interface{}, error) {
+	row := make(map[string]interface{}, len(out.Fields))
+	for _, f := range out.Fields {
+		v, ok := left[f.Name]
+		if !ok || v == nil {
+			if rv, ok := right[f.Name]; ok {
+				v = rv
+			}
+		}
+		var err error
+		if row[f.Name], err = convert(v, f); err != nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
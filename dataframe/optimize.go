@@ -0,0 +1,669 @@
+package dataframe
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/bajor/spark-go-core/expr"
+	"github.com/bajor/spark-go-core/schema"
+)
+
+// rule rewrites an operator of a plan into one giving the same rows, reporting
+// whether it changed anything. Rules only move filters and merge or drop operators,
+// so the schema of the operator they rewrite stays the same.
+type rule struct {
+	name  string
+	apply func(n node) (node, bool)
+}
+
+// rules are applied to every operator of a plan, parents first, until none of them
+// changes it
+var rules = []rule{
+	{"FoldConstants", foldConstants},
+	{"RemoveNoOps", removeNoOps},
+	{"CombineFilters", combineFilters},
+	{"PushFilterThroughProject", pushFilterThroughProject},
+	{"PushFilterThroughSort", pushFilterThroughSort},
+	{"PushFilterThroughAggregate", pushFilterThroughAggregate},
+	{"PushFilterBelowJoin", pushFilterBelowJoin},
+	{"PushJoinConditionBelowJoin", pushJoinConditionBelowJoin},
+	{"PushFilterIntoScan", pushFilterIntoScan},
+	{"CombineProjects", combineProjects},
+}
+
+// maxPasses bounds the passes of the rules over a plan
+const maxPasses = 100
+
+// optimize rewrites a plan into a cheaper one giving the same rows: filters run as
+// early as possible, down to the sources, and operators only read the columns their
+// parents use. Where sources have statistics, inner joins are reordered and the
+// execution of joins and aggregations is chosen by the estimated sizes.
+func optimize(n node) node {
+	n = rewrite(n, rules)
+	n = reorderJoins(n)
+	n = pruneColumns(n, columnSet(n.schema().Names()))
+	n = rewrite(n, rules)
+	return choosePhysical(n)
+}
+
+// rewrite applies rules to a plan until they no longer change it
+func rewrite(n node, rules []rule) node {
+	for pass := 0; pass < maxPasses; pass++ {
+		var changed bool
+		if n, changed = transform(n, rules); !changed {
+			break
+		}
+	}
+	return n
+}
+
+// transform applies rules to an operator, then to the children of the result
+func transform(n node, rules []rule) (node, bool) {
+	changed := false
+	for _, r := range rules {
+		if m, ok := r.apply(n); ok {
+			n, changed = m, true
+		}
+	}
+	children := n.children()
+	if len(children) == 0 {
+		return n, changed
+	}
+	rewritten := make([]node, len(children))
+	childChanged := false
+	for i, c := range children {
+		var ok bool
+		rewritten[i], ok = transform(c, rules)
+		childChanged = childChanged || ok
+	}
+	if childChanged {
+		n = n.withChildren(rewritten)
+	}
+	return n, changed || childChanged
+}
+
+// foldConstants evaluates the sub-expressions reading no column, and drops the
+// constant sides of and and or
+func foldConstants(n node) (node, bool) {
+	switch v := n.(type) {
+	case *project:
+		exprs, changed := foldAll(v.exprs)
+		if !changed {
+			return n, false
+		}
+		c := *v
+		c.exprs = exprs
+		return &c, true
+	case *filter:
+		if p, ok := fold(v.predicate); ok {
+			return &filter{child: v.child, predicate: p}, true
+		}
+	case *sortRows:
+		orders := make([]Order, len(v.orders))
+		changed := false
+		for i, o := range v.orders {
+			var ok bool
+			o.Expr, ok = fold(o.Expr)
+			orders[i], changed = o, changed || ok
+		}
+		if changed {
+			return &sortRows{child: v.child, orders: orders}, true
+		}
+	case *aggregate:
+		aggs := make([]Aggregation, len(v.aggs))
+		changed := false
+		for i, a := range v.aggs {
+			if a.Expr != nil {
+				var ok bool
+				if a.Expr, ok = fold(a.Expr); ok {
+					// the result column keeps the name of the original expression
+					a.Name, changed = v.out.Fields[len(v.groups)+i].Name, true
+				}
+			}
+			aggs[i] = a
+		}
+		if changed {
+			c := *v
+			c.aggs = aggs
+			return &c, true
+		}
+	case *join:
+		if v.using || v.condition == nil {
+			return n, false
+		}
+		condition, ok := fold(v.condition)
+		if !ok {
+			return n, false
+		}
+		if l, isLit := condition.(expr.Literal); isLit && l.Value == true {
+			condition = nil
+		}
+		if j, err := newConditionJoin(v.left, v.right, condition, v.how); err == nil {
+			return j, true
+		}
+	}
+	return n, false
+}
+
+// foldAll folds the expressions of a projection, keeping their output names
+func foldAll(exprs []expr.Expr) ([]expr.Expr, bool) {
+	out := make([]expr.Expr, len(exprs))
+	changed := false
+	for i, e := range exprs {
+		f, ok := fold(e)
+		out[i], changed = keepName(e, f), changed || ok
+	}
+	return out, changed
+}
+
+// fold evaluates the sub-expressions of e reading no column and simplifies and and
+// or with a constant side, following three-valued logic. Sub-expressions failing to
+// evaluate are left for the rows to fail on.
+func fold(e expr.Expr) (expr.Expr, bool) {
+	changed := false
+	sub := func(x expr.Expr) expr.Expr {
+		y, ok := fold(x)
+		changed = changed || ok
+		return y
+	}
+	var args []expr.Expr
+	switch v := e.(type) {
+	case expr.Alias:
+		v.Expr = sub(v.Expr)
+		return v, changed
+	case expr.Unary:
+		v.Operand = sub(v.Operand)
+		e, args = v, []expr.Expr{v.Operand}
+	case expr.Call:
+		folded := make([]expr.Expr, len(v.Args))
+		for i, a := range v.Args {
+			folded[i] = sub(a)
+		}
+		v.Args = folded
+		e, args = v, folded
+	case expr.Binary:
+		v.Left, v.Right = sub(v.Left), sub(v.Right)
+		if s, ok := simplify(v); ok {
+			return s, true
+		}
+		e, args = v, []expr.Expr{v.Left, v.Right}
+	default:
+		return e, false
+	}
+	for _, a := range args {
+		if _, ok := a.(expr.Literal); !ok {
+			return e, changed
+		}
+	}
+	v, err := e.Eval(nil)
+	if err != nil {
+		return e, changed
+	}
+	return expr.Lit(v), true
+}
+
+// simplify drops the constant side of and and or: x and true is x, x and false is
+// false, x or true is true and x or false is x, nulls included
+func simplify(b expr.Binary) (expr.Expr, bool) {
+	if b.Op != "and" && b.Op != "or" {
+		return nil, false
+	}
+	for _, side := range [][2]expr.Expr{{b.Left, b.Right}, {b.Right, b.Left}} {
+		l, ok := side[0].(expr.Literal)
+		if !ok {
+			continue
+		}
+		if v, ok := l.Value.(bool); ok {
+			if v == (b.Op == "or") {
+				return expr.Lit(v), true
+			}
+			return side[1], true
+		}
+	}
+	return nil, false
+}
+
+// keepName names a rewritten projection expression like the original one
+func keepName(original, rewritten expr.Expr) expr.Expr {
+	name := expr.Name(original)
+	if expr.Name(rewritten) == name {
+		return rewritten
+	}
+	if a, ok := rewritten.(expr.Alias); ok {
+		rewritten = a.Expr
+	}
+	return expr.As(rewritten, name)
+}
+
+// removeNoOps drops filters keeping every row, projections returning their input,
+// limits above smaller limits and distincts of rows already distinct
+func removeNoOps(n node) (node, bool) {
+	switch v := n.(type) {
+	case *filter:
+		if l, ok := v.predicate.(expr.Literal); ok && l.Value == true {
+			return v.child, true
+		}
+	case *project:
+		in := v.child.schema()
+		if len(v.exprs) != len(in.Fields) || !reflect.DeepEqual(v.out, in) {
+			return n, false
+		}
+		for i, e := range v.exprs {
+			if c, ok := e.(expr.Column); !ok || c.Name != in.Fields[i].Name {
+				return n, false
+			}
+		}
+		return v.child, true
+	case *limit:
+		if l, ok := v.child.(*limit); ok {
+			if l.n < v.n {
+				return l, true
+			}
+			return &limit{child: l.child, n: v.n}, true
+		}
+	case *aggregate:
+		// the rows of an aggregation differ in their grouping columns
+		if a, ok := v.child.(*aggregate); ok && len(v.aggs) == 0 && len(v.groups) == len(a.out.Fields) {
+			return a, true
+		}
+	}
+	return n, false
+}
+
+// combineFilters merges adjacent filters into one, the lower predicate first so
+// that it still guards the upper one
+func combineFilters(n node) (node, bool) {
+	if f, ok := n.(*filter); ok {
+		if below, ok := f.child.(*filter); ok {
+			return &filter{child: below.child, predicate: expr.And(below.predicate, f.predicate)}, true
+		}
+	}
+	return n, false
+}
+
+// pushFilterThroughProject filters the input of a projection instead of its output,
+// reading the expressions computing the columns the predicate reads
+func pushFilterThroughProject(n node) (node, bool) {
+	f, ok := n.(*filter)
+	if !ok {
+		return n, false
+	}
+	p, ok := f.child.(*project)
+	if !ok {
+		return n, false
+	}
+	predicate, ok := substitute(f.predicate, p.exprs)
+	if !ok {
+		return n, false
+	}
+	return p.withChildren([]node{&filter{child: p.child, predicate: predicate}}), true
+}
+
+var errNotSubstituted = errors.New("not substituted")
+
+// substitute replaces the columns e reads by the expressions of a projection computing
+// them. It fails on a dotted column reaching into a value the projection computes.
+func substitute(e expr.Expr, exprs []expr.Expr) (expr.Expr, bool) {
+	defs := make(map[string]expr.Expr, len(exprs))
+	for _, x := range exprs {
+		defs[expr.Name(x)] = unalias(x)
+	}
+	out, err := expr.Rewrite(e, func(x expr.Expr) (expr.Expr, error) {
+		c, ok := x.(expr.Column)
+		if !ok {
+			return nil, nil
+		}
+		if def, ok := defs[c.Name]; ok {
+			return def, nil
+		}
+		if i := strings.IndexByte(c.Name, '.'); i > 0 {
+			if def, ok := defs[c.Name[:i]].(expr.Column); ok {
+				return expr.Col(def.Name + c.Name[i:]), nil
+			}
+		}
+		return nil, errNotSubstituted
+	})
+	return out, err == nil
+}
+
+func unalias(e expr.Expr) expr.Expr {
+	if a, ok := e.(expr.Alias); ok {
+		return a.Expr
+	}
+	return e
+}
+
+// pushFilterThroughSort filters the rows before sorting them
+func pushFilterThroughSort(n node) (node, bool) {
+	if f, ok := n.(*filter); ok {
+		if s, ok := f.child.(*sortRows); ok {
+			return &sortRows{child: &filter{child: s.child, predicate: f.predicate}, orders: s.orders}, true
+		}
+	}
+	return n, false
+}
+
+// pushFilterThroughAggregate filters the rows of an aggregation before grouping them
+// by the conjuncts of the predicate reading only grouping columns, which select
+// whole groups
+func pushFilterThroughAggregate(n node) (node, bool) {
+	f, ok := n.(*filter)
+	if !ok {
+		return n, false
+	}
+	a, ok := f.child.(*aggregate)
+	if !ok || len(a.groups) == 0 {
+		return n, false
+	}
+	groups := columnSet(a.groups)
+	var pushed, kept []expr.Expr
+	for _, c := range conjuncts(f.predicate) {
+		cols := expr.Columns(c)
+		ok := len(cols) > 0
+		for _, name := range cols {
+			ok = ok && groups[topName(name)]
+		}
+		if ok {
+			pushed = append(pushed, c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	if len(pushed) == 0 {
+		return n, false
+	}
+	return withFilter(a.withChildren([]node{withFilter(a.child, pushed)}), kept), true
+}
+
+// pushFilterBelowJoin filters the sides of a join by the conjuncts of the predicate
+// reading the columns of one side, where the join keeps every row of the other side
+// matching a filtered row. Conjuncts of an inner join reading both sides join its
+// condition, which may turn them into keys.
+func pushFilterBelowJoin(n node) (node, bool) {
+	f, ok := n.(*filter)
+	if !ok {
+		return n, false
+	}
+	j, ok := f.child.(*join)
+	if !ok {
+		return n, false
+	}
+	ls, rs := j.left.schema(), j.right.schema()
+	toLeftSide := j.how == Inner || j.how == LeftOuter || j.how == LeftSemi || j.how == LeftAnti
+	toRightSide := j.how == Inner || j.how == RightOuter
+	var left, right, condition, kept []expr.Expr
+	for _, c := range conjuncts(f.predicate) {
+		l, r := reads(c, ls), reads(c, rs)
+		switch {
+		case len(expr.Columns(c)) == 0:
+			kept = append(kept, c)
+		case (l && toLeftSide) || (r && toRightSide):
+			// a key of a join on same-named columns filters both sides of an inner join
+			if l && toLeftSide {
+				left = append(left, c)
+			}
+			if r && toRightSide {
+				right = append(right, c)
+			}
+		case j.how == Inner && !j.using:
+			condition = append(condition, c)
+		default:
+			kept = append(kept, c)
+		}
+	}
+	if len(left)+len(right)+len(condition) == 0 {
+		return n, false
+	}
+	var joined node = j.withChildren([]node{withFilter(j.left, left), withFilter(j.right, right)})
+	if len(condition) > 0 {
+		if j.condition != nil {
+			condition = append([]expr.Expr{j.condition}, condition...)
+		}
+		c, err := newConditionJoin(withFilter(j.left, left), withFilter(j.right, right), and(condition), j.how)
+		if err != nil {
+			return n, false
+		}
+		joined = c
+	}
+	return withFilter(joined, kept), true
+}
+
+// pushJoinConditionBelowJoin filters the sides of a join on a condition by the
+// conjuncts reading one side only, where a row failing them matches nothing and
+// the join drops it anyway
+func pushJoinConditionBelowJoin(n node) (node, bool) {
+	j, ok := n.(*join)
+	if !ok || j.using || j.condition == nil {
+		return n, false
+	}
+	ls, rs := j.left.schema(), j.right.schema()
+	toLeftSide := j.how == Inner || j.how == RightOuter || j.how == LeftSemi
+	toRightSide := j.how == Inner || j.how == LeftOuter || j.how == LeftSemi || j.how == LeftAnti
+	var left, right, kept []expr.Expr
+	for _, c := range conjuncts(j.condition) {
+		switch {
+		case len(expr.Columns(c)) == 0:
+			kept = append(kept, c)
+		case toLeftSide && reads(c, ls):
+			left = append(left, c)
+		case toRightSide && reads(c, rs):
+			right = append(right, c)
+		default:
+			kept = append(kept, c)
+		}
+	}
+	if len(left)+len(right) == 0 {
+		return n, false
+	}
+	c, err := newConditionJoin(withFilter(j.left, left), withFilter(j.right, right), and(kept), j.how)
+	if err != nil {
+		return n, false
+	}
+	return c, true
+}
+
+// pushFilterIntoScan runs the conjuncts of a predicate as filters of the scan below
+func pushFilterIntoScan(n node) (node, bool) {
+	if f, ok := n.(*filter); ok {
+		if s, ok := f.child.(*scan); ok {
+			c := *s
+			c.filters = append(append([]expr.Expr(nil), s.filters...), conjuncts(f.predicate)...)
+			return &c, true
+		}
+	}
+	return n, false
+}
+
+// combineProjects merges adjacent projections into one computing the upper columns
+// from the input of the lower one. Columns the lower one computes by more than a
+// column reference must be read once, so that no expression runs twice per row.
+func combineProjects(n node) (node, bool) {
+	p, ok := n.(*project)
+	if !ok {
+		return n, false
+	}
+	below, ok := p.child.(*project)
+	if !ok {
+		return n, false
+	}
+	computed := make(map[string]bool)
+	for _, e := range below.exprs {
+		switch unalias(e).(type) {
+		case expr.Column, expr.Literal:
+		default:
+			computed[expr.Name(e)] = true
+		}
+	}
+	reads := make(map[string]int)
+	for _, e := range p.exprs {
+		expr.Walk(e, func(x expr.Expr) {
+			if c, ok := x.(expr.Column); ok {
+				reads[topName(c.Name)]++
+			}
+		})
+	}
+	for name := range computed {
+		if reads[name] > 1 {
+			return n, false
+		}
+	}
+	exprs := make([]expr.Expr, len(p.exprs))
+	for i, e := range p.exprs {
+		s, ok := substitute(e, below.exprs)
+		if !ok {
+			return n, false
+		}
+		exprs[i] = keepName(e, s)
+	}
+	return &project{child: below.child, exprs: exprs, out: p.out}, true
+}
+
+// pruneColumns drops the columns no operator above reads: from the output of
+// projections and aggregations, and from the sides of joins and scans. need holds
+// the columns the parent reads; the schema of the root must not change.
+func pruneColumns(n node, need map[string]bool) node {
+	switch v := n.(type) {
+	case *scan:
+		for _, f := range v.filters {
+			need = with(need, expr.Columns(f)...)
+		}
+		var fields []schema.Field
+		for _, f := range v.out.Fields {
+			if need[f.Name] {
+				fields = append(fields, f)
+			}
+		}
+		if len(fields) == len(v.out.Fields) {
+			return v
+		}
+		c := *v
+		c.out = schema.New(fields...)
+		return &c
+	case *project:
+		var exprs []expr.Expr
+		var fields []schema.Field
+		childNeed := map[string]bool{}
+		for i, e := range v.exprs {
+			if need[v.out.Fields[i].Name] {
+				exprs = append(exprs, e)
+				fields = append(fields, v.out.Fields[i])
+				childNeed = with(childNeed, expr.Columns(e)...)
+			}
+		}
+		return &project{child: pruneColumns(v.child, childNeed), exprs: exprs, out: schema.New(fields...)}
+	case *filter:
+		return &filter{child: pruneColumns(v.child, with(need, expr.Columns(v.predicate)...)), predicate: v.predicate}
+	case *sortRows:
+		for _, o := range v.orders {
+			need = with(need, expr.Columns(o.Expr)...)
+		}
+		return &sortRows{child: pruneColumns(v.child, need), orders: v.orders}
+	case *limit:
+		return &limit{child: pruneColumns(v.child, need), n: v.n}
+	case *aggregate:
+		c := *v
+		c.aggs = nil
+		fields := append([]schema.Field(nil), v.out.Fields[:len(v.groups)]...)
+		childNeed := with(nil, v.groups...)
+		for i, a := range v.aggs {
+			f := v.out.Fields[len(v.groups)+i]
+			if need[f.Name] {
+				c.aggs = append(c.aggs, a)
+				fields = append(fields, f)
+				if a.Expr != nil {
+					childNeed = with(childNeed, expr.Columns(a.Expr)...)
+				}
+			}
+		}
+		c.out = schema.New(fields...)
+		c.child = pruneColumns(v.child, childNeed)
+		return &c
+	case *join:
+		ls, rs := v.left.schema(), v.right.schema()
+		leftNeed, rightNeed := with(nil, v.leftKeys...), with(nil, v.rightKeys...)
+		columns := make([]string, 0, len(need))
+		for name := range need {
+			columns = append(columns, name)
+		}
+		if v.residual != nil {
+			columns = append(columns, expr.Columns(v.residual)...)
+		}
+		for _, name := range columns {
+			if ls.Index(topName(name)) >= 0 {
+				leftNeed = with(leftNeed, name)
+			}
+			if rs.Index(topName(name)) >= 0 {
+				rightNeed = with(rightNeed, name)
+			}
+		}
+		c := *v
+		c.left, c.right = pruneColumns(v.left, leftNeed), pruneColumns(v.right, rightNeed)
+		left, right := c.left.schema(), c.right.schema()
+		semi := v.how == LeftSemi || v.how == LeftAnti
+		var fields []schema.Field
+		for _, f := range v.out.Fields {
+			if left.Index(f.Name) >= 0 || (!semi && right.Index(f.Name) >= 0) {
+				fields = append(fields, f)
+			}
+		}
+		c.out = schema.New(fields...)
+		return &c
+	}
+	return n
+}
+
+// reads reports whether an expression only reads columns of s
+func reads(e expr.Expr, s schema.Schema) bool {
+	for _, c := range expr.Columns(e) {
+		if _, _, err := columnType(c, s); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// withFilter filters the rows of a plan by conjuncts, if any
+func withFilter(n node, conjuncts []expr.Expr) node {
+	if len(conjuncts) == 0 {
+		return n
+	}
+	return &filter{child: n, predicate: and(conjuncts)}
+}
+
+// and joins conjuncts with and; it is nil without any
+func and(conjuncts []expr.Expr) expr.Expr {
+	var out expr.Expr
+	for _, c := range conjuncts {
+		if out == nil {
+			out = c
+		} else {
+			out = expr.And(out, c)
+		}
+	}
+	return out
+}
+
+// topName returns the column a possibly dotted column name reads
+func topName(name string) string {
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// with returns a set of column names with more names added, by their top-level column
+func with(set map[string]bool, names ...string) map[string]bool {
+	out := make(map[string]bool, len(set)+len(names))
+	for name := range set {
+		out[name] = true
+	}
+	for _, name := range names {
+		out[topName(name)] = true
+	}
+	return out
+}
+
+func columnSet(names []string) map[string]bool {
+	return with(nil, names...)
+}
@@ -0,0 +1,325 @@
+package dataframe
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bajor/spark-go-core/expr"
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/scheduler"
+	"github.com/bajor/spark-go-core/schema"
+	"github.com/bajor/spark-go-core/types"
+)
+
+func people() *DataFrame {
+	return New(rdd.NewKeyedRDD([]interface{}{
+		map[string]interface{}{"id": 1, "name": "ann", "age": 34},
+		map[string]interface{}{"id": 2, "name": "bob", "age": 17},
+		map[string]interface{}{"id": 3, "name": "cid", "age": nil},
+		map[string]interface{}{"id": 4, "name": nil, "age": 52},
+	}, nil).Repartition(2), schema.New(
+		schema.Field{Name: "id", Type: schema.Long},
+		schema.Field{Name: "name", Type: schema.String, Nullable: true},
+		schema.Field{Name: "age", Type: schema.Long, Nullable: true},
+	))
+}
+
+func orders() *DataFrame {
+	return New(rdd.NewKeyedRDD([]interface{}{
+		map[string]interface{}{"order_id": 10, "person": 1, "total": 9.5},
+		map[string]interface{}{"order_id": 11, "person": 1, "total": 3.0},
+		map[string]interface{}{"order_id": 12, "person": 2, "total": 20.0},
+		map[string]interface{}{"order_id": 13, "person": 5, "total": 7.25},
+		map[string]interface{}{"order_id": 14, "person": nil, "total": 1.0},
+	}, nil).Repartition(2), schema.New(
+		schema.Field{Name: "order_id", Type: schema.Long},
+		schema.Field{Name: "person", Type: schema.Long, Nullable: true},
+		schema.Field{Name: "total", Type: schema.Double},
+	))
+}
+
+// visits shares the id column of people
+func visits() *DataFrame {
+	return orders().Select(expr.As(expr.Col("person"), "id"), expr.Col("total"))
+}
+
+const (
+	peopleScan = "Scan (id long not null, name string, age long)"
+	ordersScan = "Scan (order_id long not null, person long, total double not null)"
+)
+
+// applyRule rewrites the plan of a DataFrame with a single rule and explains the result
+func applyRule(t *testing.T, name string, df *DataFrame) string {
+	t.Helper()
+	if df.err != nil {
+		t.Fatalf("%s: %v", name, df.err)
+	}
+	for _, r := range rules {
+		if r.name == name {
+			var b strings.Builder
+			explain(&b, rewrite(df.plan, []rule{r}), 0)
+			return b.String()
+		}
+	}
+	t.Fatalf("no rule %s", name)
+	return ""
+}
+
+func TestOptimizer_Rules(t *testing.T) {
+	age, name, id, total := expr.Col("age"), expr.Col("name"), expr.Col("id"), expr.Col("total")
+	tests := []struct {
+		rule string
+		df   *DataFrame
+		want []string
+	}{
+		{"FoldConstants", people().
+			Select(id, expr.Add(expr.Lit(1), expr.Lit(2)), expr.As(expr.Mul(age, expr.Add(expr.Lit(2), expr.Lit(3))), "x")).
+			Where(expr.And(expr.Gt(expr.Col("x"), expr.Lit(10)), expr.Eq(expr.Fn("upper", expr.Lit("a")), expr.Lit("A")))), []string{
+			"Filter (x > 10)",
+			"  Project [id, 3 as (1 + 2), (age * 5) as x]",
+			"    " + peopleScan,
+		}},
+		{"FoldConstants", people().Where(expr.Or(expr.Gt(age, expr.Lit(1)), expr.Not(expr.Lit(false)))).Where(expr.And(expr.Lit(nil), expr.Lit(true))), []string{
+			"Filter null",
+			"  Filter true",
+			"    " + peopleScan,
+		}},
+		{"FoldConstants", people().JoinOn(orders(), expr.And(expr.Eq(id, expr.Col("person")), expr.Ge(expr.Lit(2), expr.Lit(1))), Inner), []string{
+			"Join inner on (id = person)",
+			"  " + peopleScan,
+			"  " + ordersScan,
+		}},
+		{"RemoveNoOps", people().Select(expr.Col("*")).Where(expr.Lit(true)).Distinct().Distinct().Limit(5).Limit(3), []string{
+			"Limit 3",
+			"  Aggregate [id, name, age] []",
+			"    " + peopleScan,
+		}},
+		{"RemoveNoOps", people().Select(name, id, age).Limit(2).Limit(7), []string{
+			"Limit 2",
+			"  Project [name, id, age]",
+			"    " + peopleScan,
+		}},
+		{"CombineFilters", people().Where(expr.Gt(age, expr.Lit(18))).Where(expr.Ne(name, expr.Lit("bob"))).Where(expr.Lt(id, expr.Lit(4))), []string{
+			"Filter ((age > 18) and ((name != 'bob') and (id < 4)))",
+			"  " + peopleScan,
+		}},
+		{"PushFilterThroughProject", people().WithColumn("adult", expr.Ge(age, expr.Lit(18))).Where(expr.And(expr.Col("adult"), expr.Ne(name, expr.Lit("bob")))), []string{
+			"Project [id, name, age, (age >= 18) as adult]",
+			"  Filter ((age >= 18) and (name != 'bob'))",
+			"    " + peopleScan,
+		}},
+		{"PushFilterThroughSort", people().OrderBy(Desc(age)).Where(expr.Gt(age, expr.Lit(18))), []string{
+			"Sort [age desc]",
+			"  Filter (age > 18)",
+			"    " + peopleScan,
+		}},
+		{"PushFilterThroughAggregate", people().GroupBy("name").Agg(Sum(age).As("total")).Where(expr.And(expr.Ne(name, expr.Lit("bob")), expr.Gt(total, expr.Lit(10)))), []string{
+			"Filter (total > 10)",
+			"  Aggregate [name] [sum(age) as total]",
+			"    Filter (name != 'bob')",
+			"      " + peopleScan,
+		}},
+		{"PushFilterThroughAggregate", people().Agg(CountAll().As("n")).Where(expr.Gt(expr.Col("n"), expr.Lit(0))), []string{
+			"Filter (n > 0)",
+			"  Aggregate [] [count(*) as n]",
+			"    " + peopleScan,
+		}},
+		{"PushFilterBelowJoin", people().Join(visits(), []string{"id"}, Inner).Where(expr.And(expr.And(expr.Gt(id, expr.Lit(1)), expr.Ne(name, expr.Lit("bob"))), expr.Gt(total, expr.Lit(5)))), []string{
+			"Join inner [id]",
+			"  Filter ((id > 1) and (name != 'bob'))",
+			"    " + peopleScan,
+			"  Filter ((id > 1) and (total > 5))",
+			"    Project [person as id, total]",
+			"      " + ordersScan,
+		}},
+		{"PushFilterBelowJoin", people().Join(visits(), []string{"id"}, LeftOuter).Where(expr.And(expr.Gt(id, expr.Lit(1)), expr.Gt(total, expr.Lit(5)))), []string{
+			"Filter (total > 5)",
+			"  Join left [id]",
+			"    Filter (id > 1)",
+			"      " + peopleScan,
+			"    Project [person as id, total]",
+			"      " + ordersScan,
+		}},
+		{"PushFilterBelowJoin", people().JoinOn(orders(), nil, Inner).Where(expr.And(expr.Eq(id, expr.Col("person")), expr.Gt(total, age))), []string{
+			"Join inner on ((id = person) and (total > age))",
+			"  " + peopleScan,
+			"  " + ordersScan,
+		}},
+		{"PushFilterBelowJoin", people().JoinOn(orders(), expr.Eq(id, expr.Col("person")), FullOuter).Where(expr.Fn("isnull", name)), []string{
+			"Filter isnull(name)",
+			"  Join full on (id = person)",
+			"    " + peopleScan,
+			"    " + ordersScan,
+		}},
+		{"PushJoinConditionBelowJoin", people().JoinOn(orders(), expr.And(expr.Eq(id, expr.Col("person")), expr.And(expr.Gt(age, expr.Lit(18)), expr.Gt(total, expr.Lit(5)))), LeftOuter), []string{
+			"Join left on ((id = person) and (age > 18))",
+			"  " + peopleScan,
+			"  Filter (total > 5)",
+			"    " + ordersScan,
+		}},
+		{"PushJoinConditionBelowJoin", people().JoinOn(orders(), expr.And(expr.Eq(id, expr.Col("person")), expr.And(expr.Gt(age, expr.Lit(18)), expr.Gt(total, expr.Lit(5)))), Inner), []string{
+			"Join inner on (id = person)",
+			"  Filter (age > 18)",
+			"    " + peopleScan,
+			"  Filter (total > 5)",
+			"    " + ordersScan,
+		}},
+		{"PushFilterIntoScan", people().Where(expr.Gt(age, expr.Lit(18))).Where(expr.And(expr.Ne(name, expr.Lit("bob")), expr.Lt(id, expr.Lit(4)))), []string{
+			peopleScan + " filters [(age > 18), (name != 'bob'), (id < 4)]",
+		}},
+		{"CombineProjects", people().WithColumn("x", expr.Mul(age, expr.Lit(2))).Select(name, expr.As(expr.Add(expr.Col("x"), expr.Lit(1)), "y"), expr.Col("id")), []string{
+			"Project [name, ((age * 2) + 1) as y, id]",
+			"  " + peopleScan,
+		}},
+		{"CombineProjects", people().WithColumn("x", expr.Mul(age, expr.Lit(2))).Select(expr.As(expr.Mul(expr.Col("x"), expr.Col("x")), "y")), []string{
+			"Project [(x * x) as y]",
+			"  Project [id, name, age, (age * 2) as x]",
+			"    " + peopleScan,
+		}},
+	}
+	for _, tt := range tests {
+		want := strings.Join(tt.want, "\n") + "\n"
+		if got := applyRule(t, tt.rule, tt.df); got != want {
+			t.Errorf("%s of\n%s\ngot\n%s\nwant\n%s", tt.rule, tt.df.Explain(), got, want)
+		}
+	}
+}
+
+func TestOptimizer_PruneColumns(t *testing.T) {
+	// the maximum age is not read
+	df := people().Join(visits(), []string{"id"}, Inner).GroupBy("name").Agg(Sum(expr.Col("total")).As("spent"), Max(expr.Col("age")))
+	want := strings.Join([]string{
+		"Aggregate [name] [sum(total) as spent]",
+		"  Join inner [id]",
+		"    Scan (id long not null, name string)",
+		"    Project [person as id, total]",
+		"      Scan (person long, total double not null)",
+	}, "\n") + "\n"
+	var b strings.Builder
+	explain(&b, pruneColumns(df.plan, columnSet([]string{"name", "spent"})), 0)
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestOptimizer_Optimized(t *testing.T) {
+	df := people().
+		WithColumn("adult", expr.Ge(expr.Col("age"), expr.Lit(18))).
+		Join(visits(), []string{"id"}, Inner).
+		Where(expr.And(expr.Col("adult"), expr.Gt(expr.Col("total"), expr.Add(expr.Lit(2), expr.Lit(3))))).
+		Select(expr.Col("name"), expr.Col("total"))
+	want := strings.Join([]string{
+		"Project [name, total]",
+		"  Join inner [id]",
+		"    Project [id, name]",
+		"      Scan (id long not null, name string, age long) filters [(age >= 18)]",
+		"    Project [person as id, total]",
+		"      Scan (person long, total double not null) filters [(total > 5)]",
+	}, "\n") + "\n"
+	if got := df.Optimized().Explain(); got != want {
+		t.Errorf("Optimized plan of\n%s\ngot\n%s\nwant\n%s", df.Explain(), got, want)
+	}
+	if got, want := df.Optimized().Columns(), df.Columns(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Columns: got %v, want %v", got, want)
+	}
+}
+
+// TestOptimizer_SameRows compares the rows of optimized plans with those of the
+// plans as written
+func TestOptimizer_SameRows(t *testing.T) {
+	age, name, id, total := expr.Col("age"), expr.Col("name"), expr.Col("id"), expr.Col("total")
+	person := expr.Col("person")
+	tests := []*DataFrame{
+		people().WithColumn("next", expr.Add(age, expr.Lit(1))).Where(expr.Gt(expr.Col("next"), expr.Lit(18))).Select(name, expr.Col("next")),
+		people().Join(visits(), []string{"id"}, LeftOuter).Where(expr.Or(expr.Fn("isnull", total), expr.Gt(total, expr.Lit(5)))),
+		people().Join(visits(), []string{"id"}, RightOuter).Where(expr.Gt(id, expr.Lit(1))),
+		people().Join(visits(), []string{"id"}, FullOuter).Where(expr.Gt(id, expr.Lit(1))),
+		people().Join(visits(), []string{"id"}, LeftAnti).Where(expr.Lt(id, expr.Lit(4))),
+		people().JoinOn(orders(), expr.And(expr.Eq(id, person), expr.Gt(total, expr.Lit(5))), LeftOuter).Where(expr.Gt(age, expr.Lit(18))),
+		people().JoinOn(orders(), expr.And(expr.Eq(id, person), expr.Gt(age, expr.Lit(18))), LeftOuter),
+		people().JoinOn(orders(), expr.And(expr.Eq(id, person), expr.Lt(total, expr.Lit(5))), LeftAnti),
+		people().JoinOn(orders(), expr.And(expr.Eq(id, person), expr.Gt(age, expr.Lit(18))), LeftSemi),
+		people().JoinOn(orders(), nil, Inner).Where(expr.And(expr.Eq(id, person), expr.Ne(name, expr.Lit("bob")))).Select(name, expr.Col("order_id")),
+		people().GroupBy("name").Agg(CountAll().As("n"), Sum(age)).Where(expr.And(expr.Gt(expr.Col("n"), expr.Lit(0)), expr.Ne(name, expr.Lit("ann")))),
+		people().Select(name).Distinct().Where(expr.Lit(true)).Limit(10),
+		people().Where(expr.Or(expr.Lit(false), expr.Gt(age, expr.Lit(20)))).OrderBy(Asc(id)).Limit(1),
+	}
+	s := scheduler.New(scheduler.Config{Parallelism: 2})
+	for _, df := range tests {
+		want, err := compile(df.plan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := want.Collect(context.Background(), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := make([]string, len(rows))
+		for i, r := range rows {
+			expected[i] = Row{Schema: df.Schema(), Values: values(r, df.Schema())}.String()
+		}
+		if got := sorted(collect(t, df)); !reflect.DeepEqual(got, sorted(expected)) {
+			t.Errorf("Rows of\n%s\ngot  %v\nwant %v\noptimized to\n%s", df.Explain(), got, sorted(expected), df.Optimized().Explain())
+		}
+	}
+}
+
+func values(record interface{}, s schema.Schema) []interface{} {
+	m := record.(map[string]interface{})
+	out := make([]interface{}, len(s.Fields))
+	for i, f := range s.Fields {
+		out[i] = m[f.Name]
+	}
+	return out
+}
+
+// pushdownSource is a source of record maps logging the columns and predicates
+// pushed into it
+type pushdownSource struct {
+	records    []interface{}
+	columns    *[]string
+	predicates *[]expr.Expr
+}
+
+func (s pushdownSource) NumPartitions() int { return 1 }
+
+func (s pushdownSource) Open(int) (lazy.SourceIterator, error) {
+	return sliceSource{SliceIterator: lazy.NewSliceIterator(s.records)}, nil
+}
+
+type sliceSource struct {
+	*lazy.SliceIterator
+}
+
+func (sliceSource) Err() error   { return nil }
+func (sliceSource) Close() error { return nil }
+
+func (s pushdownSource) WithColumns(columns []string) (types.Source, error) {
+	*s.columns = columns
+	return s, nil
+}
+
+func (s pushdownSource) WithPredicates(predicates []expr.Expr) types.Source {
+	*s.predicates = predicates
+	return s
+}
+
+func TestOptimizer_PushdownIntoSource(t *testing.T) {
+	src := pushdownSource{records: []interface{}{
+		map[string]interface{}{"id": int64(1), "name": "ann", "age": int64(34)},
+		map[string]interface{}{"id": int64(2), "name": "bob", "age": int64(17)},
+	}, columns: new([]string), predicates: new([]expr.Expr)}
+	df := New(rdd.FromSource(src), people().Schema()).Where(expr.Gt(expr.Col("age"), expr.Lit(18))).Select(expr.Col("name"))
+	if got, want := collect(t, df), []string{"[ann]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Rows: got %v, want %v", got, want)
+	}
+	if got, want := *src.columns, []string{"name", "age"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Columns read: got %v, want %v", got, want)
+	}
+	if got, want := exprList(*src.predicates), "[(age > 18)]"; got != want {
+		t.Errorf("Predicates pushed: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,345 @@
+package dataframe
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	accumulators "github.com/bajor/spark-go-core/accumulator"
+	"github.com/bajor/spark-go-core/expr"
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/schema"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// node is an operator of a DataFrame's logical plan. Rows travel between operators
+// as maps holding exactly the columns of the operator's schema.
+type node interface {
+	schema() schema.Schema
+	children() []node
+	// withChildren returns a copy of the operator reading other inputs of the same
+	// schemas, for the optimizer
+	withChildren(children []node) node
+	// describe returns the operator and its arguments, for Explain
+	describe() string
+	// compile builds the RDD of the operator's rows from those of its children
+	compile(inputs []*rdd.KeyedRDD) (*rdd.KeyedRDD, error)
+}
+
+// compile builds the RDD computing a plan
+func compile(n node) (*rdd.KeyedRDD, error) {
+	return compileCounted(n, nil)
+}
+
+// compileCounted builds the RDD computing a plan which, unless counters is nil, adds
+// the rows every operator produces to a counter appended to counters, in the order
+// explain visits the operators
+func compileCounted(n node, counters *[]*accumulators.Accumulator[int64]) (*rdd.KeyedRDD, error) {
+	var counter *accumulators.Accumulator[int64]
+	if counters != nil {
+		counter = accumulators.Long("dataframe rows of " + n.describe())
+		*counters = append(*counters, counter)
+	}
+	children := n.children()
+	inputs := make([]*rdd.KeyedRDD, len(children))
+	for i, c := range children {
+		r, err := compileCounted(c, counters)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = r
+	}
+	r, err := n.compile(inputs)
+	if err != nil || counter == nil {
+		return r, err
+	}
+	return r.MapPartitions(func(ctx context.Context, rows []interface{}) ([]interface{}, error) {
+		counter.Add(ctx, int64(len(rows)))
+		return rows, nil
+	}), nil
+}
+
+// explain writes a plan as an indented tree, one operator per line with its
+// estimated rows where the sources have statistics
+func explain(b *strings.Builder, n node, depth int) {
+	b.WriteString(strings.Repeat("  ", depth))
+	b.WriteString(n.describe())
+	b.WriteString(describeEstimate(estimateOf(n)))
+	b.WriteByte('\n')
+	for _, c := range n.children() {
+		explain(b, c, depth+1)
+	}
+}
+
+// execution is a plan an action ran, with the rows every operator produced in the
+// order explain visits them
+type execution struct {
+	plan node
+	rows []int64
+}
+
+// explainExecuted writes an executed plan like explain, with the estimated and the
+// actual rows of every operator
+func explainExecuted(b *strings.Builder, n node, depth int, rows []int64, next *int) {
+	b.WriteString(strings.Repeat("  ", depth))
+	b.WriteString(n.describe())
+	if est := estimateOf(n); est.known {
+		fmt.Fprintf(b, " (estimated rows %d, actual rows %d)", est.estimatedRows(), rows[*next])
+	} else {
+		fmt.Fprintf(b, " (actual rows %d)", rows[*next])
+	}
+	b.WriteByte('\n')
+	*next++
+	for _, c := range n.children() {
+		explainExecuted(b, c, depth+1, rows, next)
+	}
+}
+
+// scan reads the records of an RDD, conformed to its schema, and keeps those for
+// which every filter is true
+type scan struct {
+	rdd     *rdd.KeyedRDD
+	out     schema.Schema
+	name    string
+	filters []expr.Expr
+}
+
+func (s *scan) schema() schema.Schema    { return s.out }
+func (s *scan) children() []node         { return nil }
+func (s *scan) withChildren([]node) node { return s }
+
+func (s *scan) describe() string {
+	d := "Scan " + s.out.String()
+	if s.name != "" {
+		d = "Scan " + s.name + " " + s.out.String()
+	}
+	if len(s.filters) > 0 {
+		d += " filters " + exprList(s.filters)
+	}
+	return d
+}
+
+// compile reads only the columns of the schema from a ColumnSource, and runs the
+// filters on the records of a PredicateSource before they are conformed, so that
+// the source can skip input by them
+func (s *scan) compile([]*rdd.KeyedRDD) (*rdd.KeyedRDD, error) {
+	r, pushed := s.rdd, false
+	if len(r.Chain.Operations) == 0 {
+		if src, ok := r.Source.(rdd.ColumnSource); ok && len(s.out.Fields) > 0 {
+			if narrowed, err := src.WithColumns(s.out.Names()); err == nil {
+				r = &rdd.KeyedRDD{KeyedRDD: &types.KeyedRDD{
+					Data:       r.Data,
+					Source:     narrowed,
+					Chain:      r.Chain,
+					Key:        r.Key,
+					Partitions: r.Partitions,
+				}}
+			}
+		}
+		if _, ok := r.Source.(rdd.PredicateSource); ok {
+			for _, p := range s.filters {
+				r = r.FilterExpr(p)
+			}
+			pushed = true
+		}
+	}
+	out := s.out
+	r = r.Map(func(record interface{}) (interface{}, error) {
+		return conform(record, out)
+	})
+	if !pushed {
+		for _, p := range s.filters {
+			r = r.FilterExpr(p)
+		}
+	}
+	return r, nil
+}
+
+// project computes one column per expression
+type project struct {
+	child node
+	exprs []expr.Expr
+	out   schema.Schema
+}
+
+func (p *project) schema() schema.Schema { return p.out }
+func (p *project) children() []node      { return []node{p.child} }
+func (p *project) describe() string      { return "Project " + exprList(p.exprs) }
+
+func (p *project) withChildren(children []node) node {
+	c := *p
+	c.child = children[0]
+	return &c
+}
+
+func (p *project) compile(inputs []*rdd.KeyedRDD) (*rdd.KeyedRDD, error) {
+	exprs, fields := p.exprs, p.out.Fields
+	return inputs[0].Map(func(record interface{}) (interface{}, error) {
+		row := make(map[string]interface{}, len(exprs))
+		for i, e := range exprs {
+			v, err := e.Eval(record)
+			if err != nil {
+				return nil, err
+			}
+			if row[fields[i].Name], err = convert(v, fields[i]); err != nil {
+				return nil, err
+			}
+		}
+		return row, nil
+	}), nil
+}
+
+// filter keeps the rows for which a predicate is true
+type filter struct {
+	child     node
+	predicate expr.Expr
+}
+
+func (f *filter) schema() schema.Schema { return f.child.schema() }
+func (f *filter) children() []node      { return []node{f.child} }
+func (f *filter) describe() string      { return "Filter " + f.predicate.String() }
+
+func (f *filter) withChildren(children []node) node {
+	return &filter{child: children[0], predicate: f.predicate}
+}
+
+func (f *filter) compile(inputs []*rdd.KeyedRDD) (*rdd.KeyedRDD, error) {
+	return inputs[0].FilterExpr(f.predicate), nil
+}
+
+// Order is a sort key of OrderBy
+type Order struct {
+	Expr expr.Expr
+	// Desc sorts in descending order
+	Desc bool
+}
+
+// Asc sorts by an expression in ascending order, nulls first
+func Asc(e expr.Expr) Order { return Order{Expr: e} }
+
+// Desc sorts by an expression in descending order, nulls last
+func Desc(e expr.Expr) Order { return Order{Expr: e, Desc: true} }
+
+func (o Order) String() string {
+	if o.Desc {
+		return o.Expr.String() + " desc"
+	}
+	return o.Expr.String()
+}
+
+// sortRows orders every row. The rows are gathered into one partition and sorted
+// there, like Reduce.
+type sortRows struct {
+	child  node
+	orders []Order
+}
+
+func (s *sortRows) schema() schema.Schema { return s.child.schema() }
+func (s *sortRows) children() []node      { return []node{s.child} }
+
+func (s *sortRows) withChildren(children []node) node {
+	return &sortRows{child: children[0], orders: s.orders}
+}
+
+func (s *sortRows) describe() string {
+	parts := make([]string, len(s.orders))
+	for i, o := range s.orders {
+		parts[i] = o.String()
+	}
+	return "Sort [" + strings.Join(parts, ", ") + "]"
+}
+
+func (s *sortRows) compile(inputs []*rdd.KeyedRDD) (*rdd.KeyedRDD, error) {
+	orders := s.orders
+	return inputs[0].Reduce(func(rows []interface{}) ([]interface{}, error) {
+		keys := make([][]interface{}, len(rows))
+		for i, row := range rows {
+			keys[i] = make([]interface{}, len(orders))
+			for j, o := range orders {
+				v, err := o.Expr.Eval(row)
+				if err != nil {
+					return nil, err
+				}
+				keys[i][j] = v
+			}
+		}
+		index := make([]int, len(rows))
+		for i := range index {
+			index[i] = i
+		}
+		var sortErr error
+		sort.SliceStable(index, func(a, b int) bool {
+			for j, o := range orders {
+				c, err := compareNullsFirst(keys[index[a]][j], keys[index[b]][j])
+				if err != nil && sortErr == nil {
+					sortErr = fmt.Errorf("order by %s: %w", o.Expr, err)
+				}
+				if c != 0 {
+					return (c < 0) != o.Desc
+				}
+			}
+			return false
+		})
+		if sortErr != nil {
+			return nil, sortErr
+		}
+		out := make([]interface{}, len(rows))
+		for i, j := range index {
+			out[i] = rows[j]
+		}
+		return out, nil
+	}), nil
+}
+
+// compareNullsFirst is expr.Compare with nulls before every other value
+func compareNullsFirst(a, b interface{}) (int, error) {
+	switch {
+	case a == nil && b == nil:
+		return 0, nil
+	case a == nil:
+		return -1, nil
+	case b == nil:
+		return 1, nil
+	}
+	return expr.Compare(a, b)
+}
+
+// limit keeps the first n rows
+type limit struct {
+	child node
+	n     int
+}
+
+func (l *limit) schema() schema.Schema { return l.child.schema() }
+func (l *limit) children() []node      { return []node{l.child} }
+func (l *limit) describe() string      { return "Limit " + strconv.Itoa(l.n) }
+
+func (l *limit) withChildren(children []node) node {
+	return &limit{child: children[0], n: l.n}
+}
+
+func (l *limit) compile(inputs []*rdd.KeyedRDD) (*rdd.KeyedRDD, error) {
+	n := l.n
+	first := func(rows []interface{}) []interface{} {
+		if len(rows) > n {
+			return rows[:n]
+		}
+		return rows
+	}
+	// every partition is cut to n rows before they are gathered
+	return inputs[0].MapPartitions(func(_ context.Context, rows []interface{}) ([]interface{}, error) {
+		return first(rows), nil
+	}).Reduce(func(rows []interface{}) ([]interface{}, error) {
+		return first(rows), nil
+	}), nil
+}
+
+func exprList(exprs []expr.Expr) string {
+	parts := make([]string, len(exprs))
+	for i, e := range exprs {
+		parts[i] = e.String()
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
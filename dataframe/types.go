@@ -0,0 +1,196 @@
+package dataframe
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/bajor/spark-go-core/expr"
+	"github.com/bajor/spark-go-core/schema"
+)
+
+// functionTypes are the result types of the built-in expression functions; the
+// others take the type of their arguments
+var functionTypes = map[string]schema.Type{
+	"upper":      schema.String,
+	"lower":      schema.String,
+	"trim":       schema.String,
+	"concat":     schema.String,
+	"substr":     schema.String,
+	"length":     schema.Long,
+	"contains":   schema.Bool,
+	"startswith": schema.Bool,
+	"endswith":   schema.Bool,
+	"isnull":     schema.Bool,
+	"like":       schema.Bool,
+}
+
+// resolve checks that an expression only reads columns of s and returns the field it
+// produces, named by expr.Name
+func resolve(e expr.Expr, s schema.Schema) (schema.Field, error) {
+	t, nullable, err := typeOf(e, s)
+	if err != nil {
+		return schema.Field{}, err
+	}
+	return schema.Field{Name: expr.Name(e), Type: t, Nullable: nullable}, nil
+}
+
+func typeOf(e expr.Expr, s schema.Schema) (schema.Type, bool, error) {
+	switch v := e.(type) {
+	case expr.Column:
+		return columnType(v.Name, s)
+	case expr.Literal:
+		t := schema.InferValue(v.Value)
+		return t, v.Value == nil, nil
+	case expr.Alias:
+		return typeOf(v.Expr, s)
+	case expr.Unary:
+		t, nullable, err := typeOf(v.Operand, s)
+		if err != nil {
+			return 0, false, err
+		}
+		if v.Op == "not" {
+			if t != schema.Bool && t != schema.Null && t != schema.Any {
+				return 0, false, fmt.Errorf("not: %s is a %s, not a boolean", v.Operand, t)
+			}
+			return schema.Bool, nullable, nil
+		}
+		if !numeric(t) {
+			return 0, false, fmt.Errorf("-: %s is a %s, not a number", v.Operand, t)
+		}
+		return t, nullable, nil
+	case expr.Binary:
+		lt, ln, err := typeOf(v.Left, s)
+		if err != nil {
+			return 0, false, err
+		}
+		rt, rn, err := typeOf(v.Right, s)
+		if err != nil {
+			return 0, false, err
+		}
+		nullable := ln || rn
+		switch v.Op {
+		case "and", "or", "=", "!=", "<", "<=", ">", ">=":
+			return schema.Bool, nullable, nil
+		case "+":
+			if lt == schema.String && rt == schema.String {
+				return schema.String, nullable, nil
+			}
+		}
+		if !numeric(lt) || !numeric(rt) {
+			return 0, false, fmt.Errorf("%s: operands %s and %s are not numbers", v.Op, lt, rt)
+		}
+		return schema.Merge(lt, rt), nullable, nil
+	case expr.Call:
+		var argType schema.Type
+		for _, a := range v.Args {
+			t, _, err := typeOf(a, s)
+			if err != nil {
+				return 0, false, err
+			}
+			argType = schema.Merge(argType, t)
+		}
+		if t, ok := functionTypes[v.Func]; ok {
+			return t, true, nil
+		}
+		return argType, true, nil
+	}
+	return schema.Any, true, nil
+}
+
+// columnType returns the type of a column; dotted names reach into Any columns
+func columnType(name string, s schema.Schema) (schema.Type, bool, error) {
+	if f, ok := s.Field(name); ok {
+		return f.Type, f.Nullable, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if f, ok := s.Field(name[:i]); ok {
+			if f.Type != schema.Any {
+				return 0, false, fmt.Errorf("column %q: %s is a %s without fields", name, f.Name, f.Type)
+			}
+			return schema.Any, true, nil
+		}
+	}
+	return 0, false, fmt.Errorf("unknown column %q in %s", name, s)
+}
+
+// numeric reports whether arithmetic accepts values of type t
+func numeric(t schema.Type) bool {
+	return t == schema.Long || t == schema.Double || t == schema.Null || t == schema.Any
+}
+
+// conform turns a map or struct record into a map holding exactly the columns of s,
+// converted to their types
+func conform(record interface{}, s schema.Schema) (map[string]interface{}, error) {
+	m, err := schema.Encode(record)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]interface{}, len(s.Fields))
+	for _, f := range s.Fields {
+		v, err := convert(m[f.Name], f)
+		if err != nil {
+			return nil, err
+		}
+		out[f.Name] = v
+	}
+	return out, nil
+}
+
+// convert casts a value to the type of a field, widening Go integer and float types
+func convert(v interface{}, f schema.Field) (interface{}, error) {
+	if v == nil {
+		if !f.Nullable {
+			return nil, fmt.Errorf("column %s is not nullable", f.Name)
+		}
+		return nil, nil
+	}
+	switch f.Type {
+	case schema.Long, schema.Double:
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Pointer:
+			if rv.IsNil() {
+				return convert(nil, f)
+			}
+			return convert(rv.Elem().Interface(), f)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v = rv.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			v = int64(rv.Uint())
+		case reflect.Float32, reflect.Float64:
+			v = rv.Float()
+		}
+	case schema.Bool, schema.String:
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				return convert(nil, f)
+			}
+			v = rv.Elem().Interface()
+		}
+	case schema.Null:
+		return nil, fmt.Errorf("column %s holds only nulls, got %v", f.Name, v)
+	}
+	out, err := f.Type.Convert(v)
+	if err != nil {
+		return nil, fmt.Errorf("column %s: %w", f.Name, err)
+	}
+	return out, nil
+}
+
+// groupKey encodes the values of columns as a comparable key; equal numbers of
+// different Go types share a key
+func groupKey(row map[string]interface{}, columns []string) (string, bool, error) {
+	values := make([]interface{}, len(columns))
+	hasNull := false
+	for i, c := range columns {
+		values[i] = row[c]
+		hasNull = hasNull || values[i] == nil
+	}
+	key, err := json.Marshal(values)
+	if err != nil {
+		return "", false, err
+	}
+	return string(key), hasNull, nil
+}
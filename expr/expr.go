@@ -0,0 +1,325 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Expr is a serializable expression evaluated against a single record. Records are
+// maps with string keys, structs (fields matched by name or json tag) or plain values.
+type Expr interface {
+	Eval(record interface{}) (interface{}, error)
+	String() string
+}
+
+// Column reads a field of the record. Dotted names reach into nested records and
+// the special name "_" refers to the record itself.
+type Column struct {
+	Name string
+}
+
+// Literal is a constant value
+type Literal struct {
+	Value interface{}
+}
+
+// Binary applies an arithmetic, comparison or boolean operator to two operands
+type Binary struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// Unary applies "not" or "-" to one operand
+type Unary struct {
+	Op      string
+	Operand Expr
+}
+
+// Call invokes a built-in scalar function
+type Call struct {
+	Func string
+	Args []Expr
+}
+
+// Alias names the result of an expression in a projection
+type Alias struct {
+	Expr Expr
+	Name string
+}
+
+// Col references a column by name
+func Col(name string) Expr { return Column{Name: name} }
+
+// Lit wraps a constant value
+func Lit(v interface{}) Expr { return Literal{Value: v} }
+
+// As names an expression for use in a projection
+func As(e Expr, name string) Expr { return Alias{Expr: e, Name: name} }
+
+// Fn calls a built-in function such as upper, lower, length or concat
+func Fn(name string, args ...Expr) Expr { return Call{Func: strings.ToLower(name), Args: args} }
+
+// Operator constructors build Binary and Unary nodes
+func Add(l, r Expr) Expr { return Binary{Op: "+", Left: l, Right: r} }
+func Sub(l, r Expr) Expr { return Binary{Op: "-", Left: l, Right: r} }
+func Mul(l, r Expr) Expr { return Binary{Op: "*", Left: l, Right: r} }
+func Div(l, r Expr) Expr { return Binary{Op: "/", Left: l, Right: r} }
+func Mod(l, r Expr) Expr { return Binary{Op: "%", Left: l, Right: r} }
+func Eq(l, r Expr) Expr  { return Binary{Op: "=", Left: l, Right: r} }
+func Ne(l, r Expr) Expr  { return Binary{Op: "!=", Left: l, Right: r} }
+func Lt(l, r Expr) Expr  { return Binary{Op: "<", Left: l, Right: r} }
+func Le(l, r Expr) Expr  { return Binary{Op: "<=", Left: l, Right: r} }
+func Gt(l, r Expr) Expr  { return Binary{Op: ">", Left: l, Right: r} }
+func Ge(l, r Expr) Expr  { return Binary{Op: ">=", Left: l, Right: r} }
+func And(l, r Expr) Expr { return Binary{Op: "and", Left: l, Right: r} }
+func Or(l, r Expr) Expr  { return Binary{Op: "or", Left: l, Right: r} }
+func Not(e Expr) Expr    { return Unary{Op: "not", Operand: e} }
+func Neg(e Expr) Expr    { return Unary{Op: "-", Operand: e} }
+
+func (c Column) Eval(record interface{}) (interface{}, error) {
+	if c.Name == "_" {
+		return record, nil
+	}
+	current := record
+	for _, part := range strings.Split(c.Name, ".") {
+		v, err := field(current, part)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", c.Name, err)
+		}
+		current = v
+	}
+	return current, nil
+}
+
+func (c Column) String() string { return c.Name }
+
+func (l Literal) Eval(record interface{}) (interface{}, error) { return l.Value, nil }
+
+func (l Literal) String() string {
+	switch v := l.Value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (b Binary) Eval(record interface{}) (interface{}, error) {
+	left, err := b.Left.Eval(record)
+	if err != nil {
+		return nil, err
+	}
+	// and/or short-circuit with SQL three-valued logic, nil meaning unknown
+	switch b.Op {
+	case "and":
+		if left == false {
+			return false, nil
+		}
+	case "or":
+		if left == true {
+			return true, nil
+		}
+	}
+	right, err := b.Right.Eval(record)
+	if err != nil {
+		return nil, err
+	}
+	return apply(b.Op, left, right)
+}
+
+func (b Binary) String() string {
+	return "(" + b.Left.String() + " " + b.Op + " " + b.Right.String() + ")"
+}
+
+func (u Unary) Eval(record interface{}) (interface{}, error) {
+	v, err := u.Operand.Eval(record)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	switch u.Op {
+	case "not":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("not: %T is not a boolean", v)
+		}
+		return !b, nil
+	case "-":
+		return arithmetic("-", 0, v)
+	}
+	return nil, fmt.Errorf("unknown unary operator %q", u.Op)
+}
+
+func (u Unary) String() string {
+	if u.Op == "not" {
+		return "(not " + u.Operand.String() + ")"
+	}
+	return "(-" + u.Operand.String() + ")"
+}
+
+func (c Call) Eval(record interface{}) (interface{}, error) {
+	fn, ok := functions[c.Func]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", c.Func)
+	}
+	args := make([]interface{}, len(c.Args))
+	for i, a := range c.Args {
+		v, err := a.Eval(record)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Func, err)
+	}
+	return v, nil
+}
+
+func (c Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		args[i] = a.String()
+	}
+	return c.Func + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (a Alias) Eval(record interface{}) (interface{}, error) { return a.Expr.Eval(record) }
+
+func (a Alias) String() string { return a.Expr.String() + " as " + a.Name }
+
+// Name returns the output name of an expression in a projection: the alias or
+// column name, or its string form otherwise
+func Name(e Expr) string {
+	switch v := e.(type) {
+	case Alias:
+		return v.Name
+	case Column:
+		return v.Name
+	}
+	return e.String()
+}
+
+// Columns returns the distinct column names an expression reads, in order of appearance
+func Columns(e Expr) []string {
+	seen := make(map[string]bool)
+	var out []string
+	Walk(e, func(n Expr) {
+		if c, ok := n.(Column); ok && !seen[c.Name] {
+			seen[c.Name] = true
+			out = append(out, c.Name)
+		}
+	})
+	return out
+}
+
+// Walk calls fn for e and every sub-expression, parents before children
+func Walk(e Expr, fn func(Expr)) {
+	fn(e)
+	switch v := e.(type) {
+	case Binary:
+		Walk(v.Left, fn)
+		Walk(v.Right, fn)
+	case Unary:
+		Walk(v.Operand, fn)
+	case Call:
+		for _, a := range v.Args {
+			Walk(a, fn)
+		}
+	case Alias:
+		Walk(v.Expr, fn)
+	}
+}
+
+// Rewrite returns e with sub-expressions replaced by fn. fn is called parents first
+// like Walk; when it returns a non-nil expression, that expression replaces the one
+// given and its sub-expressions are not visited.
+func Rewrite(e Expr, fn func(Expr) (Expr, error)) (Expr, error) {
+	replaced, err := fn(e)
+	if err != nil || replaced != nil {
+		return replaced, err
+	}
+	switch v := e.(type) {
+	case Binary:
+		if v.Left, err = Rewrite(v.Left, fn); err != nil {
+			return nil, err
+		}
+		if v.Right, err = Rewrite(v.Right, fn); err != nil {
+			return nil, err
+		}
+		return v, nil
+	case Unary:
+		if v.Operand, err = Rewrite(v.Operand, fn); err != nil {
+			return nil, err
+		}
+		return v, nil
+	case Call:
+		args := make([]Expr, len(v.Args))
+		for i, a := range v.Args {
+			if args[i], err = Rewrite(a, fn); err != nil {
+				return nil, err
+			}
+		}
+		v.Args = args
+		return v, nil
+	case Alias:
+		if v.Expr, err = Rewrite(v.Expr, fn); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return e, nil
+}
+
+// Truthy reports whether a predicate result keeps a record; nil (unknown) does not
+func Truthy(v interface{}) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+// field extracts a named field from a map or struct record
+func field(record interface{}, name string) (interface{}, error) {
+	switch r := record.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return r[name], nil
+	}
+
+	v := reflect.ValueOf(record)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		mv := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		if !mv.IsValid() {
+			return nil, nil
+		}
+		return mv.Interface(), nil
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			tag := strings.Split(f.Tag.Get("json"), ",")[0]
+			if f.Name == name || tag == name || strings.EqualFold(f.Name, name) {
+				return v.Field(i).Interface(), nil
+			}
+		}
+		return nil, fmt.Errorf("%v has no field %q", t, name)
+	}
+	return nil, fmt.Errorf("cannot read field %q of %T", name, record)
+}
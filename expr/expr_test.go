@@ -0,0 +1,238 @@
+package expr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bajor/spark-go-core/registry"
+	"github.com/bajor/spark-go-core/types"
+)
+
+type event struct {
+	Name   string `json:"name"`
+	Amount int    `json:"amount"`
+	User   struct {
+		Country string
+	}
+}
+
+func TestEval(t *testing.T) {
+	record := map[string]interface{}{"x": 5, "y": 2.5, "name": "bob", "missing": nil}
+
+	tests := []struct {
+		expr     Expr
+		expected interface{}
+	}{
+		{Add(Col("x"), Lit(1)), 6},
+		{Mul(Col("x"), Col("y")), 12.5},
+		{Div(Col("x"), Lit(2)), 2},
+		{Gt(Col("x"), Lit(4)), true},
+		{And(Gt(Col("x"), Lit(4)), Lt(Col("y"), Lit(2))), false},
+		{Or(Eq(Col("name"), Lit("alice")), Eq(Fn("upper", Col("name")), Lit("BOB"))), true},
+		{Not(Eq(Col("x"), Lit(5))), false},
+		{Gt(Col("missing"), Lit(1)), nil},
+		{And(Lit(false), Gt(Col("missing"), Lit(1))), false},
+		{Fn("length", Col("name")), 3},
+		{Fn("concat", Col("name"), Lit("-"), Col("x")), "bob-5"},
+		{Fn("substr", Lit("spark"), Lit(2), Lit(3)), "par"},
+		{Fn("isnull", Col("missing")), true},
+		{Fn("like", Col("name"), Lit("b_b%")), true},
+		{Fn("like", Col("name"), Lit("%o")), false},
+	}
+
+	for _, tt := range tests {
+		result, err := tt.expr.Eval(record)
+		if err != nil {
+			t.Errorf("%v failed with error: %v", tt.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("%v: got %v (%T), want %v (%T)", tt.expr, result, result, tt.expected, tt.expected)
+		}
+	}
+}
+
+func TestMatchLike(t *testing.T) {
+	tests := []struct {
+		s, pattern string
+		want       bool
+	}{
+		{"", "", true},
+		{"", "%", true},
+		{"", "_", false},
+		{"abc", "abc", true},
+		{"abc", "a_c", true},
+		{"abc", "a%", true},
+		{"abc", "%c", true},
+		{"abc", "%b%", true},
+		{"abc", "%d%", false},
+		{"abc", "ab", false},
+		{"abcbc", "a%bc", true},
+		{"abcbd", "a%bc", false},
+		{"aXbXc", "%X%X%", true},
+		{"mississippi", "m%iss%pi", true},
+		{"mississippi", "m%iss%pix", false},
+		{"żółw", "_ó%", true},
+	}
+	for _, tt := range tests {
+		if got := matchLike([]rune(tt.s), []rune(tt.pattern)); got != tt.want {
+			t.Errorf("%q LIKE %q: got %v, want %v", tt.s, tt.pattern, got, tt.want)
+		}
+	}
+
+	// with backtracking on every %, this would take exponential time
+	long := []rune(strings.Repeat("a", 10000))
+	start := time.Now()
+	if matchLike(long, []rune("%a%a%a%a%a%a%a%a%b")) {
+		t.Error("Expected no match without a b")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Matching a long string took %v", d)
+	}
+}
+
+func TestEval_StructFields(t *testing.T) {
+	e := event{Name: "click", Amount: 3}
+	e.User.Country = "PL"
+
+	result, err := And(Eq(Col("User.Country"), Lit("PL")), Ge(Col("amount"), Lit(3))).Eval(e)
+	if err != nil {
+		t.Fatalf("Eval failed with error: %v", err)
+	}
+	if result != true {
+		t.Errorf("Struct field access failed: got %v", result)
+	}
+
+	if _, err := Col("nope").Eval(e); err == nil {
+		t.Errorf("Expected unknown field to fail")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x > 4", "(x > 4)"},
+		{"x + 2 * 3", "(x + (2 * 3))"},
+		{"(x + 2) * 3", "((x + 2) * 3)"},
+		{"a > 1 and b < 2 or c = 3", "(((a > 1) and (b < 2)) or (c = 3))"},
+		{"not x = 1 && y != 'it''s'", "((not (x = 1)) and (y != 'it''s'))"},
+		{"upper(name) <> \"BOB\"", "(upper(name) != 'BOB')"},
+		{"-x + -2", "((-x) + -2)"},
+		{"_ % 2 == 0", "((_ % 2) = 0)"},
+	}
+
+	for _, tt := range tests {
+		e, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q) failed with error: %v", tt.input, err)
+			continue
+		}
+		if e.String() != tt.expected {
+			t.Errorf("Parse(%q): got %s, want %s", tt.input, e, tt.expected)
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, input := range []string{"", "x >", "(x", "x @ 2", "nosuchfn(x)", "'open", "f(x,"} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("Parse(%q) should fail", input)
+		}
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	original := As(And(Ge(Col("x"), Lit(4)), Fn("contains", Col("name"), Lit("o"))), "keep")
+
+	data, err := Marshal(original)
+	if err != nil {
+		t.Fatalf("Marshal failed with error: %v", err)
+	}
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed with error: %v", err)
+	}
+
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("Round trip failed: got %#v, want %#v", decoded, original)
+	}
+}
+
+func TestColumns(t *testing.T) {
+	e := MustParse("a + b > a and upper(c) = 'X'")
+
+	expected := []string{"a", "b", "c"}
+	if result := Columns(e); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Columns failed: got %v, want %v", result, expected)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	e := MustParse("a + b > a and upper(c) = 'X'")
+	rewritten, err := Rewrite(e, func(n Expr) (Expr, error) {
+		if c, ok := n.(Column); ok && c.Name == "a" {
+			return Mul(Col("x"), Lit(2)), nil
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rewritten.String(), "((((x * 2) + b) > (x * 2)) and (upper(c) = 'X'))"; got != want {
+		t.Errorf("Rewrite: got %s, want %s", got, want)
+	}
+	if got := e.String(); got != "(((a + b) > a) and (upper(c) = 'X'))" {
+		t.Errorf("Rewrite should not change its input, got %s", got)
+	}
+}
+
+func TestOperations(t *testing.T) {
+	data := []interface{}{
+		map[string]interface{}{"name": "a", "x": 1},
+		map[string]interface{}{"name": "b", "x": 5},
+		map[string]interface{}{"name": "c", "x": 7},
+	}
+
+	filtered, err := FilterOperation{Predicate: MustParse("x > 4")}.Execute(data)
+	if err != nil {
+		t.Fatalf("Filter failed with error: %v", err)
+	}
+	projected, err := ProjectOperation{Exprs: []Expr{Col("name"), As(MustParse("x * 10"), "big")}}.Execute(filtered)
+	if err != nil {
+		t.Fatalf("Project failed with error: %v", err)
+	}
+
+	expected := []interface{}{
+		map[string]interface{}{"name": "b", "big": 50},
+		map[string]interface{}{"name": "c", "big": 70},
+	}
+	if !reflect.DeepEqual(projected, expected) {
+		t.Errorf("Operations failed: got %v, want %v", projected, expected)
+	}
+}
+
+func TestOperations_ShipThroughRegistry(t *testing.T) {
+	op := FilterOperation{Predicate: MustParse("_ % 2 = 0")}
+
+	specs, err := registry.Specs([]types.Operation{op})
+	if err != nil {
+		t.Fatalf("Specs failed with error: %v", err)
+	}
+	rebuilt, err := registry.Build(specs[0])
+	if err != nil {
+		t.Fatalf("Build failed with error: %v", err)
+	}
+
+	result, err := rebuilt.Execute([]interface{}{1, 2, 3, 4})
+	if err != nil {
+		t.Fatalf("Execute failed with error: %v", err)
+	}
+	expected := []interface{}{2, 4}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Shipped filter failed: got %v, want %v", result, expected)
+	}
+}
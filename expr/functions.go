@@ -0,0 +1,203 @@
+package expr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// functions holds the built-in scalar functions callable from expressions
+var functions = map[string]func(args []interface{}) (interface{}, error){
+	"upper":      stringFunc(strings.ToUpper),
+	"lower":      stringFunc(strings.ToLower),
+	"trim":       stringFunc(strings.TrimSpace),
+	"length":     length,
+	"concat":     concat,
+	"contains":   stringPredicate(strings.Contains),
+	"startswith": stringPredicate(strings.HasPrefix),
+	"endswith":   stringPredicate(strings.HasSuffix),
+	"substr":     substr,
+	"coalesce":   coalesce,
+	"abs":        abs,
+	"isnull":     isNull,
+	"like":       like,
+}
+
+// Functions returns the names of the built-in functions
+func Functions() []string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func stringFunc(f func(string) string) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if err := arity(args, 1); err != nil || args[0] == nil {
+			return nil, err
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string, got %T", args[0])
+		}
+		return f(s), nil
+	}
+}
+
+func stringPredicate(f func(string, string) bool) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if err := arity(args, 2); err != nil || args[0] == nil || args[1] == nil {
+			return nil, err
+		}
+		s, ok1 := args[0].(string)
+		sub, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("expected strings, got %T and %T", args[0], args[1])
+		}
+		return f(s, sub), nil
+	}
+}
+
+func length(args []interface{}) (interface{}, error) {
+	if err := arity(args, 1); err != nil || args[0] == nil {
+		return nil, err
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("expected string, got %T", args[0])
+	}
+	return len([]rune(s)), nil
+}
+
+func concat(args []interface{}) (interface{}, error) {
+	var b strings.Builder
+	for _, a := range args {
+		if a == nil {
+			return nil, nil
+		}
+		fmt.Fprint(&b, a)
+	}
+	return b.String(), nil
+}
+
+// substr(s, start[, length]) uses 1-based positions like SQL
+func substr(args []interface{}) (interface{}, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("expected 2 or 3 arguments, got %d", len(args))
+	}
+	if args[0] == nil {
+		return nil, nil
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("expected string, got %T", args[0])
+	}
+	runes := []rune(s)
+	start, _, _, ok := number(args[1])
+	if !ok {
+		return nil, fmt.Errorf("start must be a number, got %T", args[1])
+	}
+	from := int(start) - 1
+	if from < 0 {
+		from = 0
+	}
+	if from > len(runes) {
+		from = len(runes)
+	}
+	to := len(runes)
+	if len(args) == 3 {
+		n, _, _, ok := number(args[2])
+		if !ok {
+			return nil, fmt.Errorf("length must be a number, got %T", args[2])
+		}
+		if from+int(n) < to {
+			to = from + int(n)
+		}
+	}
+	if to < from {
+		to = from
+	}
+	return string(runes[from:to]), nil
+}
+
+func coalesce(args []interface{}) (interface{}, error) {
+	for _, a := range args {
+		if a != nil {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+func abs(args []interface{}) (interface{}, error) {
+	if err := arity(args, 1); err != nil || args[0] == nil {
+		return nil, err
+	}
+	c, err := Compare(args[0], 0)
+	if err != nil {
+		return nil, err
+	}
+	if c < 0 {
+		return arithmetic("-", 0, args[0])
+	}
+	return args[0], nil
+}
+
+func isNull(args []interface{}) (interface{}, error) {
+	if err := arity(args, 1); err != nil {
+		return nil, err
+	}
+	return args[0] == nil, nil
+}
+
+// like(s, pattern) matches a SQL LIKE pattern, where % stands for any run of
+// characters and _ for a single one
+func like(args []interface{}) (interface{}, error) {
+	if err := arity(args, 2); err != nil || args[0] == nil || args[1] == nil {
+		return nil, err
+	}
+	s, ok1 := args[0].(string)
+	pattern, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("expected strings, got %T and %T", args[0], args[1])
+	}
+	return matchLike([]rune(s), []rune(pattern)), nil
+}
+
+// matchLike matches greedily, going back only to the character after the last %
+// seen, which takes time linear in len(s) times len(pattern) at worst
+func matchLike(s, pattern []rune) bool {
+	si, pi := 0, 0
+	// star is the index of the last % in pattern, -1 before the first; mark is the
+	// position in s it was last resumed from
+	star, mark := -1, 0
+	for si < len(s) {
+		switch {
+		case pi < len(pattern) && pattern[pi] == '%':
+			star, mark = pi, si
+			pi++
+		case pi < len(pattern) && (pattern[pi] == '_' || pattern[pi] == s[si]):
+			si++
+			pi++
+		case star >= 0:
+			// let the last % take one more character
+			mark++
+			si, pi = mark, star+1
+		default:
+			return false
+		}
+	}
+	for pi < len(pattern) && pattern[pi] == '%' {
+		pi++
+	}
+	return pi == len(pattern)
+}
+
+func arity(args []interface{}, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+	return nil
+}
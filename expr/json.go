@@ -0,0 +1,159 @@
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// node is the JSON form of an expression. Exactly one of Col, Lit, Op or Fn is set;
+// Type keeps integer literals from coming back as float64.
+type node struct {
+	Col   *string         `json:"col,omitempty"`
+	Lit   json.RawMessage `json:"lit,omitempty"`
+	Type  string          `json:"type,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	Fn    string          `json:"fn,omitempty"`
+	Alias string          `json:"as,omitempty"`
+	Args  []node          `json:"args,omitempty"`
+}
+
+// Marshal serializes an expression to JSON
+func Marshal(e Expr) ([]byte, error) {
+	n, err := toNode(e)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(n)
+}
+
+// Unmarshal rebuilds an expression from the JSON produced by Marshal
+func Unmarshal(data []byte) (Expr, error) {
+	var n node
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, fmt.Errorf("decode expression: %w", err)
+	}
+	return fromNode(n)
+}
+
+func toNode(e Expr) (node, error) {
+	switch v := e.(type) {
+	case Column:
+		name := v.Name
+		return node{Col: &name}, nil
+	case Literal:
+		raw, err := json.Marshal(v.Value)
+		if err != nil {
+			return node{}, fmt.Errorf("literal %v: %w", v.Value, err)
+		}
+		n := node{Lit: raw}
+		switch v.Value.(type) {
+		case int, int8, int16, int32:
+			n.Type = "int"
+		case int64:
+			n.Type = "int64"
+		}
+		return n, nil
+	case Binary:
+		l, err := toNode(v.Left)
+		if err != nil {
+			return node{}, err
+		}
+		r, err := toNode(v.Right)
+		if err != nil {
+			return node{}, err
+		}
+		return node{Op: v.Op, Args: []node{l, r}}, nil
+	case Unary:
+		operand, err := toNode(v.Operand)
+		if err != nil {
+			return node{}, err
+		}
+		return node{Op: v.Op, Args: []node{operand}}, nil
+	case Call:
+		n := node{Fn: v.Func, Args: make([]node, len(v.Args))}
+		for i, a := range v.Args {
+			arg, err := toNode(a)
+			if err != nil {
+				return node{}, err
+			}
+			n.Args[i] = arg
+		}
+		return n, nil
+	case Alias:
+		n, err := toNode(v.Expr)
+		if err != nil {
+			return node{}, err
+		}
+		if n.Alias != "" {
+			// aliasing an alias keeps the outer name
+			n = node{Op: "alias", Args: []node{n}}
+		}
+		n.Alias = v.Name
+		return n, nil
+	}
+	return node{}, fmt.Errorf("cannot serialize expression of type %T", e)
+}
+
+func fromNode(n node) (Expr, error) {
+	e, err := fromNodeBare(n)
+	if err != nil {
+		return nil, err
+	}
+	if n.Alias != "" {
+		return Alias{Expr: e, Name: n.Alias}, nil
+	}
+	return e, nil
+}
+
+func fromNodeBare(n node) (Expr, error) {
+	switch {
+	case n.Col != nil:
+		return Column{Name: *n.Col}, nil
+	case n.Lit != nil:
+		var v interface{}
+		if err := json.Unmarshal(n.Lit, &v); err != nil {
+			return nil, err
+		}
+		if f, ok := v.(float64); ok {
+			switch n.Type {
+			case "int":
+				v = int(f)
+			case "int64":
+				v = int64(f)
+			}
+		}
+		return Literal{Value: v}, nil
+	case n.Fn != "":
+		if _, ok := functions[n.Fn]; !ok {
+			return nil, fmt.Errorf("unknown function %q", n.Fn)
+		}
+		args := make([]Expr, len(n.Args))
+		for i, a := range n.Args {
+			arg, err := fromNode(a)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = arg
+		}
+		return Call{Func: n.Fn, Args: args}, nil
+	case n.Op == "alias" && len(n.Args) == 1:
+		return fromNode(n.Args[0])
+	case n.Op != "" && len(n.Args) == 1:
+		operand, err := fromNode(n.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return Unary{Op: n.Op, Operand: operand}, nil
+	case n.Op != "" && len(n.Args) == 2:
+		l, err := fromNode(n.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		r, err := fromNode(n.Args[1])
+		if err != nil {
+			return nil, err
+		}
+		return Binary{Op: n.Op, Left: l, Right: r}, nil
+	}
+	return nil, fmt.Errorf("invalid expression node %+v", n)
+}
@@ -0,0 +1,131 @@
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bajor/spark-go-core/registry"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// Registered names of the expression operations, so expression chains can be shipped to workers
+const (
+	MapOperationName     = "expr.map"
+	FilterOperationName  = "expr.filter"
+	ProjectOperationName = "expr.project"
+)
+
+func init() {
+	registry.RegisterPartition(MapOperationName, func(args json.RawMessage) (func([]interface{}) ([]interface{}, error), error) {
+		e, err := Unmarshal(args)
+		if err != nil {
+			return nil, err
+		}
+		return MapOperation{Expr: e}.Execute, nil
+	})
+	registry.RegisterPartition(FilterOperationName, func(args json.RawMessage) (func([]interface{}) ([]interface{}, error), error) {
+		e, err := Unmarshal(args)
+		if err != nil {
+			return nil, err
+		}
+		return FilterOperation{Predicate: e}.Execute, nil
+	})
+	registry.RegisterPartition(ProjectOperationName, func(args json.RawMessage) (func([]interface{}) ([]interface{}, error), error) {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(args, &raw); err != nil {
+			return nil, fmt.Errorf("decode projection: %w", err)
+		}
+		exprs := make([]Expr, len(raw))
+		for i, r := range raw {
+			e, err := Unmarshal(r)
+			if err != nil {
+				return nil, err
+			}
+			exprs[i] = e
+		}
+		return ProjectOperation{Exprs: exprs}.Execute, nil
+	})
+}
+
+// MapOperation replaces every record with the value of an expression
+type MapOperation struct {
+	Expr Expr
+}
+
+func (m MapOperation) Narrow() {}
+
+func (m MapOperation) Spec() types.OperationSpec {
+	args, _ := Marshal(m.Expr)
+	return types.OperationSpec{Name: MapOperationName, Args: args}
+}
+
+func (m MapOperation) Execute(data []interface{}) ([]interface{}, error) {
+	result := make([]interface{}, len(data))
+	for i, item := range data {
+		v, err := m.Expr.Eval(item)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+// FilterOperation keeps the records for which the predicate is true
+type FilterOperation struct {
+	Predicate Expr
+}
+
+func (f FilterOperation) Narrow() {}
+
+func (f FilterOperation) Spec() types.OperationSpec {
+	args, _ := Marshal(f.Predicate)
+	return types.OperationSpec{Name: FilterOperationName, Args: args}
+}
+
+func (f FilterOperation) Execute(data []interface{}) ([]interface{}, error) {
+	result := make([]interface{}, 0)
+	for _, item := range data {
+		v, err := f.Predicate.Eval(item)
+		if err != nil {
+			return nil, err
+		}
+		if Truthy(v) {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// ProjectOperation turns every record into a map with one entry per expression,
+// keyed by the expression's Name
+type ProjectOperation struct {
+	Exprs []Expr
+}
+
+func (p ProjectOperation) Narrow() {}
+
+func (p ProjectOperation) Spec() types.OperationSpec {
+	raw := make([]json.RawMessage, len(p.Exprs))
+	for i, e := range p.Exprs {
+		raw[i], _ = Marshal(e)
+	}
+	args, _ := json.Marshal(raw)
+	return types.OperationSpec{Name: ProjectOperationName, Args: args}
+}
+
+func (p ProjectOperation) Execute(data []interface{}) ([]interface{}, error) {
+	result := make([]interface{}, len(data))
+	for i, item := range data {
+		row := make(map[string]interface{}, len(p.Exprs))
+		for _, e := range p.Exprs {
+			v, err := e.Eval(item)
+			if err != nil {
+				return nil, err
+			}
+			row[Name(e)] = v
+		}
+		result[i] = row
+	}
+	return result, nil
+}
@@ -0,0 +1,292 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Parse builds an expression from its textual form, for example
+//
+//	x > 4 and upper(name) = 'BOB'
+//
+// Identifiers are column names (dotted for nested fields, "_" for the record itself),
+// strings use single or double quotes, and and/or/not may also be written &&, || and !.
+func Parse(input string) (Expr, error) {
+	p := &parser{lex: lexer{input: input}}
+	p.advance()
+	e, err := p.expression(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return e, nil
+}
+
+// MustParse is like Parse but panics on error; intended for expressions fixed at compile time
+func MustParse(input string) Expr {
+	e, err := Parse(input)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type lexer struct {
+	input string
+	pos   int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.input) && unicode.IsSpace(rune(l.input[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	c := l.input[l.pos]
+	switch {
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for l.pos < len(l.input) && (l.input[l.pos] == '_' || l.input[l.pos] == '.' ||
+			unicode.IsLetter(rune(l.input[l.pos])) || unicode.IsDigit(rune(l.input[l.pos]))) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.input[start:l.pos], pos: start}, nil
+	case unicode.IsDigit(rune(c)):
+		for l.pos < len(l.input) && (unicode.IsDigit(rune(l.input[l.pos])) || l.input[l.pos] == '.') {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: l.input[start:l.pos], pos: start}, nil
+	case c == '\'' || c == '"':
+		var b strings.Builder
+		l.pos++
+		for {
+			if l.pos >= len(l.input) {
+				return token{}, fmt.Errorf("unterminated string at position %d", start)
+			}
+			if l.input[l.pos] == c {
+				// a doubled quote is an escaped quote
+				if l.pos+1 < len(l.input) && l.input[l.pos+1] == c {
+					b.WriteByte(c)
+					l.pos += 2
+					continue
+				}
+				l.pos++
+				break
+			}
+			b.WriteByte(l.input[l.pos])
+			l.pos++
+		}
+		return token{kind: tokString, text: b.String(), pos: start}, nil
+	}
+
+	for _, op := range []string{"<=", ">=", "!=", "<>", "==", "&&", "||", "=", "<", ">", "+", "-", "*", "/", "%", "(", ")", ",", "!"} {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+	return token{}, fmt.Errorf("unexpected character %q at position %d", c, start)
+}
+
+type parser struct {
+	lex lexer
+	tok token
+	err error
+}
+
+func (p *parser) advance() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+	if p.err != nil {
+		p.tok = token{kind: tokEOF}
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	return fmt.Errorf("parse error at position %d: %s", p.tok.pos, fmt.Sprintf(format, args...))
+}
+
+// binaryOp returns the canonical operator and its precedence for the current token
+func (p *parser) binaryOp() (string, int, bool) {
+	text := p.tok.text
+	if p.tok.kind == tokIdent {
+		text = strings.ToLower(text)
+	} else if p.tok.kind != tokOp {
+		return "", 0, false
+	}
+	switch text {
+	case "or", "||":
+		return "or", 1, true
+	case "and", "&&":
+		return "and", 2, true
+	case "=", "==":
+		return "=", 4, true
+	case "!=", "<>":
+		return "!=", 4, true
+	case "<", "<=", ">", ">=":
+		return text, 4, true
+	case "+", "-":
+		return text, 5, true
+	case "*", "/", "%":
+		return text, 6, true
+	}
+	return "", 0, false
+}
+
+// expression parses operators binding tighter than minPrec using precedence climbing
+func (p *parser) expression(minPrec int) (Expr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, prec, ok := p.binaryOp()
+		if !ok || prec <= minPrec {
+			return left, nil
+		}
+		p.advance()
+		right, err := p.expression(prec)
+		if err != nil {
+			return nil, err
+		}
+		left = Binary{Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *parser) unary() (Expr, error) {
+	if (p.tok.kind == tokIdent && strings.EqualFold(p.tok.text, "not")) || (p.tok.kind == tokOp && p.tok.text == "!") {
+		p.advance()
+		// not binds looser than comparisons: "not x > 4" is "not (x > 4)"
+		operand, err := p.expression(3)
+		if err != nil {
+			return nil, err
+		}
+		return Not(operand), nil
+	}
+	if p.tok.kind == tokOp && p.tok.text == "-" {
+		p.advance()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if lit, ok := operand.(Literal); ok {
+			if v, err := arithmetic("-", 0, lit.Value); err == nil {
+				return Literal{Value: v}, nil
+			}
+		}
+		return Neg(operand), nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Expr, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		p.advance()
+		if strings.Contains(tok.text, ".") {
+			f, err := strconv.ParseFloat(tok.text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+			}
+			return Lit(f), nil
+		}
+		n, err := strconv.Atoi(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+		}
+		return Lit(n), nil
+	case tokString:
+		p.advance()
+		return Lit(tok.text), nil
+	case tokIdent:
+		p.advance()
+		switch strings.ToLower(tok.text) {
+		case "true":
+			return Lit(true), nil
+		case "false":
+			return Lit(false), nil
+		case "null":
+			return Lit(nil), nil
+		}
+		if p.tok.kind == tokOp && p.tok.text == "(" {
+			return p.call(tok)
+		}
+		return Col(tok.text), nil
+	case tokOp:
+		if tok.text == "(" {
+			p.advance()
+			e, err := p.expression(0)
+			if err != nil {
+				return nil, err
+			}
+			if p.tok.kind != tokOp || p.tok.text != ")" {
+				return nil, p.errorf("expected )")
+			}
+			p.advance()
+			return e, nil
+		}
+	case tokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+func (p *parser) call(name token) (Expr, error) {
+	fn := strings.ToLower(name.text)
+	if _, ok := functions[fn]; !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	p.advance() // (
+	var args []Expr
+	if p.tok.kind == tokOp && p.tok.text == ")" {
+		p.advance()
+		return Fn(fn), nil
+	}
+	for {
+		arg, err := p.expression(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.tok.kind == tokOp && p.tok.text == "," {
+			p.advance()
+			continue
+		}
+		if p.tok.kind == tokOp && p.tok.text == ")" {
+			p.advance()
+			return Fn(fn, args...), nil
+		}
+		return nil, p.errorf("expected , or ) in call to %s", fn)
+	}
+}
@@ -0,0 +1,181 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// apply evaluates a binary operator on two already evaluated operands
+func apply(op string, left, right interface{}) (interface{}, error) {
+	switch op {
+	case "and", "or":
+		return logic(op, left, right)
+	case "+", "-", "*", "/", "%":
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		if op == "+" {
+			if ls, ok := left.(string); ok {
+				if rs, ok := right.(string); ok {
+					return ls + rs, nil
+				}
+			}
+		}
+		return arithmetic(op, left, right)
+	case "=", "!=", "<", "<=", ">", ">=":
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		c, err := Compare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "=":
+			return c == 0, nil
+		case "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operator %q", op)
+}
+
+// logic implements SQL three-valued and/or, where nil means unknown
+func logic(op string, left, right interface{}) (interface{}, error) {
+	l, lok := left.(bool)
+	r, rok := right.(bool)
+	if (left != nil && !lok) || (right != nil && !rok) {
+		return nil, fmt.Errorf("%s: operands must be booleans, got %T and %T", op, left, right)
+	}
+	if op == "and" {
+		if (lok && !l) || (rok && !r) {
+			return false, nil
+		}
+		if lok && rok {
+			return true, nil
+		}
+		return nil, nil
+	}
+	if (lok && l) || (rok && r) {
+		return true, nil
+	}
+	if lok && rok {
+		return false, nil
+	}
+	return nil, nil
+}
+
+// arithmetic applies an arithmetic operator. Integers stay integers (int when both
+// operands are int, int64 otherwise) and anything involving a float becomes float64.
+func arithmetic(op string, left, right interface{}) (interface{}, error) {
+	li, lf, lIsFloat, lok := number(left)
+	ri, rf, rIsFloat, rok := number(right)
+	if !lok || !rok {
+		return nil, fmt.Errorf("%s: operands must be numbers, got %T and %T", op, left, right)
+	}
+
+	if lIsFloat || rIsFloat {
+		switch op {
+		case "+":
+			return lf + rf, nil
+		case "-":
+			return lf - rf, nil
+		case "*":
+			return lf * rf, nil
+		case "/":
+			return lf / rf, nil
+		default:
+			return math.Mod(lf, rf), nil
+		}
+	}
+
+	var res int64
+	switch op {
+	case "+":
+		res = li + ri
+	case "-":
+		res = li - ri
+	case "*":
+		res = li * ri
+	case "/", "%":
+		if ri == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if op == "/" {
+			res = li / ri
+		} else {
+			res = li % ri
+		}
+	}
+	_, lInt := left.(int)
+	_, rInt := right.(int)
+	if lInt && rInt {
+		return int(res), nil
+	}
+	return res, nil
+}
+
+// number converts any Go numeric value to both int64 and float64 forms
+func number(v interface{}) (int64, float64, bool, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), float64(rv.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), float64(rv.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return int64(rv.Float()), rv.Float(), true, true
+	}
+	return 0, 0, false, false
+}
+
+// Compare orders two values: numbers numerically, strings lexically and booleans false before true
+func Compare(left, right interface{}) (int, error) {
+	li, lf, lIsFloat, lok := number(left)
+	ri, rf, rIsFloat, rok := number(right)
+	if lok && rok {
+		if lIsFloat || rIsFloat {
+			switch {
+			case lf < rf:
+				return -1, nil
+			case lf > rf:
+				return 1, nil
+			}
+			return 0, nil
+		}
+		switch {
+		case li < ri:
+			return -1, nil
+		case li > ri:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	switch l := left.(type) {
+	case string:
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	case bool:
+		if r, ok := right.(bool); ok {
+			switch {
+			case l == r:
+				return 0, nil
+			case !l:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %T with %T", left, right)
+}
@@ -0,0 +1,417 @@
+package formats
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/schema"
+	"github.com/bajor/spark-go-core/source"
+	"github.com/bajor/spark-go-core/stats"
+)
+
+// DefaultSampleSize is the number of records schema inference looks at
+const DefaultSampleSize = 1000
+
+// CSVOptions configures a CSV reader
+type CSVOptions struct {
+	// Header treats the first record of every file as column names; without it
+	// columns are named _c0, _c1, ...
+	Header bool
+	// Delimiter separates fields, defaults to ','
+	Delimiter rune
+	// Quote encloses fields holding delimiters, quotes or line breaks, defaults to '"'.
+	// A quote inside a quoted field is written twice.
+	Quote rune
+	// NullValues are the field contents read as null, defaults to the empty string
+	NullValues []string
+	// Schema fixes the columns and their types; it takes precedence over InferSchema
+	Schema *schema.Schema
+	// InferSchema samples records to find column types; otherwise every column is a String
+	InferSchema bool
+	// SampleSize is the number of records sampled, defaults to DefaultSampleSize;
+	// a negative size samples everything
+	SampleSize int
+	// Mode selects what happens to records that cannot be parsed
+	Mode Mode
+	// Sink receives malformed records in SinkMalformed mode
+	Sink func(Malformed)
+}
+
+// CSVSource reads CSV files into records mapping column names to typed values. Each
+// file is one partition, since quoted fields may span lines.
+type CSVSource struct {
+	files   []string
+	options CSVOptions
+	schema  schema.Schema
+	policy  policy
+	// recorder gathers the statistics of the files read
+	recorder *stats.Recorder
+}
+
+// CSV creates a source over the files matched by patterns, see source.Expand. The
+// schema is given, inferred from a sample or made of String columns.
+func CSV(patterns string, options CSVOptions) (*CSVSource, error) {
+	if options.Delimiter == 0 {
+		options.Delimiter = ','
+	}
+	if options.Quote == 0 {
+		options.Quote = '"'
+	}
+	if options.Delimiter == options.Quote || options.Delimiter == '\n' || options.Quote == '\n' {
+		return nil, errors.New("csv: delimiter and quote must differ from each other and from a line break")
+	}
+	if options.NullValues == nil {
+		options.NullValues = []string{""}
+	}
+	if options.SampleSize == 0 {
+		options.SampleSize = DefaultSampleSize
+	}
+	p, err := newPolicy(options.Mode, options.Sink)
+	if err != nil {
+		return nil, fmt.Errorf("csv: %w", err)
+	}
+	files, err := source.Expand(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &CSVSource{files: files, options: options, policy: p, recorder: stats.NewRecorder(len(files))}
+	if options.Schema != nil {
+		s.schema = *options.Schema
+		return s, nil
+	}
+	if s.schema, err = s.infer(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Schema returns the schema records are read with
+func (s *CSVSource) Schema() schema.Schema {
+	return s.schema
+}
+
+// NumPartitions returns the number of files
+func (s *CSVSource) NumPartitions() int {
+	return len(s.files)
+}
+
+// Open returns an iterator over the records of one file
+func (s *CSVSource) Open(partition int) (lazy.SourceIterator, error) {
+	if partition < 0 || partition >= len(s.files) {
+		return nil, fmt.Errorf("csv source has no partition %d", partition)
+	}
+	it := &csvIterator{source: s, path: s.files[partition]}
+	if err := it.open(); err != nil {
+		return nil, err
+	}
+	return s.recorder.Observe(partition, it), nil
+}
+
+// Statistics returns the statistics of the records, known once every file was read
+func (s *CSVSource) Statistics() (stats.Statistics, bool) {
+	return s.recorder.Statistics()
+}
+
+// infer reads the column names and, with InferSchema, samples the column types.
+// Malformed records are left out of the sample.
+func (s *CSVSource) infer() (schema.Schema, error) {
+	var names []string
+	var inferrer *schema.Inferrer
+	sampled := 0
+	for _, path := range s.files {
+		if names != nil && (!s.options.InferSchema || (s.options.SampleSize >= 0 && sampled >= s.options.SampleSize)) {
+			break
+		}
+		rc, err := source.OpenFile(path)
+		if err != nil {
+			return schema.Schema{}, err
+		}
+		r := newCSVReader(rc, s.options.Delimiter, s.options.Quote)
+		first := true
+		for s.options.SampleSize < 0 || sampled < s.options.SampleSize || names == nil {
+			fields, _, _, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				continue
+			}
+			if first && s.options.Header {
+				first = false
+				if names == nil {
+					names = fields
+				}
+				continue
+			}
+			first = false
+			if names == nil {
+				names = make([]string, len(fields))
+				for i := range names {
+					names[i] = fmt.Sprintf("_c%d", i)
+				}
+			}
+			if !s.options.InferSchema {
+				break
+			}
+			if inferrer == nil {
+				inferrer = schema.NewInferrer(names...)
+			}
+			if len(fields) != len(names) {
+				continue
+			}
+			types := make(map[string]schema.Type, len(fields))
+			for i, f := range fields {
+				if s.isNull(f) {
+					types[names[i]] = schema.Null
+				} else {
+					types[names[i]] = schema.InferString(f)
+				}
+			}
+			inferrer.Add(types)
+			sampled++
+		}
+		rc.Close()
+	}
+
+	if inferrer != nil {
+		return inferrer.Schema(), nil
+	}
+	out := schema.Schema{Fields: make([]schema.Field, len(names))}
+	for i, name := range names {
+		out.Fields[i] = schema.Field{Name: name, Type: schema.String, Nullable: true}
+	}
+	return out, nil
+}
+
+func (s *CSVSource) isNull(field string) bool {
+	for _, n := range s.options.NullValues {
+		if field == n {
+			return true
+		}
+	}
+	return false
+}
+
+// record converts the fields of one CSV record according to the schema
+func (s *CSVSource) record(fields []string) (map[string]interface{}, error) {
+	if len(fields) != len(s.schema.Fields) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(s.schema.Fields), len(fields))
+	}
+	out := make(map[string]interface{}, len(fields))
+	for i, f := range s.schema.Fields {
+		if s.isNull(fields[i]) {
+			if !f.Nullable {
+				return nil, fmt.Errorf("column %s is not nullable", f.Name)
+			}
+			out[f.Name] = nil
+			continue
+		}
+		v, err := f.Type.Parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", f.Name, err)
+		}
+		out[f.Name] = v
+	}
+	return out, nil
+}
+
+// csvIterator streams the records of one file
+type csvIterator struct {
+	source *CSVSource
+	path   string
+	closer io.Closer
+	reader *csvReader
+	header bool
+	err    error
+}
+
+func (it *csvIterator) open() error {
+	rc, err := source.OpenFile(it.path)
+	if err != nil {
+		return err
+	}
+	it.closer = rc
+	it.reader = newCSVReader(rc, it.source.options.Delimiter, it.source.options.Quote)
+	it.header = it.source.options.Header
+	return nil
+}
+
+func (it *csvIterator) Next() (interface{}, bool) {
+	for it.err == nil && it.reader != nil {
+		fields, raw, line, err := it.reader.Read()
+		if err == io.EOF {
+			return nil, false
+		}
+		var record map[string]interface{}
+		if err == nil {
+			if it.header {
+				it.header = false
+				continue
+			}
+			record, err = it.source.record(fields)
+		}
+		if err != nil {
+			var read *readError
+			if errors.As(err, &read) {
+				it.err = fmt.Errorf("%s: %w", it.path, err)
+				return nil, false
+			}
+			it.header = false
+			if it.err = it.source.policy.handle(Malformed{Path: it.path, Line: line, Raw: raw, Err: err}); it.err != nil {
+				return nil, false
+			}
+			continue
+		}
+		return record, true
+	}
+	return nil, false
+}
+
+func (it *csvIterator) Err() error {
+	return it.err
+}
+
+func (it *csvIterator) Close() error {
+	if it.closer == nil {
+		return nil
+	}
+	err := it.closer.Close()
+	it.closer, it.reader = nil, nil
+	return err
+}
+
+func (it *csvIterator) Reset() {
+	it.Close()
+	it.err = it.open()
+}
+
+// readError is an I/O failure, which no malformed record policy can skip
+type readError struct {
+	err error
+}
+
+func (e *readError) Error() string { return e.err.Error() }
+func (e *readError) Unwrap() error { return e.err }
+
+// csvReader splits a stream into records. Unlike encoding/csv it supports any quote
+// character and keeps the raw text of every record for error reporting.
+type csvReader struct {
+	r     *bufio.Reader
+	delim rune
+	quote rune
+	line  int64
+}
+
+func newCSVReader(r io.Reader, delim, quote rune) *csvReader {
+	return &csvReader{r: bufio.NewReader(r), delim: delim, quote: quote}
+}
+
+// Read returns the fields of the next non-empty record, its raw text and the line it
+// starts on. A record that cannot be parsed is returned as an error; reading can go on
+// with the next record.
+func (c *csvReader) Read() ([]string, string, int64, error) {
+	for {
+		start := c.line + 1
+		var raw strings.Builder
+		fields, err := c.parse(&raw)
+		text := strings.TrimRight(raw.String(), "\r\n")
+		if err != nil {
+			return nil, text, start, err
+		}
+		if len(fields) == 1 && text == "" {
+			continue
+		}
+		return fields, text, start, nil
+	}
+}
+
+func (c *csvReader) parse(raw *strings.Builder) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inQuotes, quoted := false, false
+	for {
+		r, _, err := c.r.ReadRune()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, errors.New("unterminated quoted field")
+			}
+			if raw.Len() == 0 {
+				return nil, io.EOF
+			}
+			return append(fields, field.String()), nil
+		}
+		if err != nil {
+			return nil, &readError{err: err}
+		}
+		raw.WriteRune(r)
+
+		if inQuotes {
+			switch r {
+			case c.quote:
+				next, _, err := c.r.ReadRune()
+				if err == nil && next == c.quote {
+					raw.WriteRune(next)
+					field.WriteRune(c.quote)
+					continue
+				}
+				if err == nil {
+					c.r.UnreadRune()
+				}
+				inQuotes = false
+			case '\n':
+				c.line++
+				field.WriteRune(r)
+			default:
+				field.WriteRune(r)
+			}
+			continue
+		}
+
+		switch {
+		case r == c.delim:
+			fields = append(fields, field.String())
+			field.Reset()
+			quoted = false
+		case r == '\n':
+			c.line++
+			return append(fields, field.String()), nil
+		case r == '\r':
+			if next, _, err := c.r.ReadRune(); err == nil {
+				if next == '\n' {
+					raw.WriteRune(next)
+					c.line++
+					return append(fields, field.String()), nil
+				}
+				c.r.UnreadRune()
+			}
+			field.WriteRune(r)
+		case quoted:
+			c.skipLine(raw)
+			return nil, fmt.Errorf("unexpected %q after closing quote", r)
+		case r == c.quote && field.Len() == 0:
+			inQuotes, quoted = true, true
+		default:
+			field.WriteRune(r)
+		}
+	}
+}
+
+// skipLine consumes the rest of the current line after a parse error
+func (c *csvReader) skipLine(raw *strings.Builder) {
+	for {
+		r, _, err := c.r.ReadRune()
+		if err != nil {
+			return
+		}
+		raw.WriteRune(r)
+		if r == '\n' {
+			c.line++
+			return
+		}
+	}
+}
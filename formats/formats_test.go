@@ -0,0 +1,337 @@
+package formats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/schema"
+	"github.com/bajor/spark-go-core/stats"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAll(src interface {
+	NumPartitions() int
+	Open(int) (lazy.SourceIterator, error)
+}) ([]interface{}, error) {
+	all := make([]interface{}, 0)
+	for p := 0; p < src.NumPartitions(); p++ {
+		it, err := src.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		part, err := lazy.Drain(it)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, part...)
+	}
+	return all, nil
+}
+
+func TestCSV_HeaderQuotesAndInference(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "people.csv")
+	writeFile(t, path, "name,age,score\r\n\"Lovelace, Ada\",36,1.5\r\n\"say \"\"hi\"\"\nagain\",,2\r\n")
+
+	src, err := CSV(path, CSVOptions{Header: true, InferSchema: true})
+	if err != nil {
+		t.Fatalf("CSV failed with error: %v", err)
+	}
+	expectedSchema := schema.New(
+		schema.Field{Name: "name", Type: schema.String},
+		schema.Field{Name: "age", Type: schema.Long, Nullable: true},
+		schema.Field{Name: "score", Type: schema.Double},
+	)
+	if !reflect.DeepEqual(src.Schema(), expectedSchema) {
+		t.Errorf("Expected schema %s, got %s", expectedSchema, src.Schema())
+	}
+
+	got, err := readAll(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"name": "Lovelace, Ada", "age": int64(36), "score": 1.5},
+		map[string]interface{}{"name": "say \"hi\"\nagain", "age": nil, "score": 2.0},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestCSV_OptionsWithoutHeader(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.tsv")
+	writeFile(t, path, "a;'x;y';NA\n")
+
+	src, err := CSV(path, CSVOptions{Delimiter: ';', Quote: '\'', NullValues: []string{"NA"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readAll(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{map[string]interface{}{"_c0": "a", "_c1": "x;y", "_c2": nil}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestCSV_MalformedModes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bad.csv")
+	writeFile(t, path, "id,n\n1,2\n2\n3,x\n\"4\"z,5\n6,7\n")
+	fixed := schema.New(schema.Field{Name: "id", Type: schema.Long}, schema.Field{Name: "n", Type: schema.Long})
+
+	src, err := CSV(path, CSVOptions{Header: true, Schema: &fixed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = readAll(src)
+	var malformed Malformed
+	if !errors.As(err, &malformed) || malformed.Line != 3 || malformed.Raw != "2" {
+		t.Errorf("Expected a malformed record on line 3, got %v", err)
+	}
+
+	src, err = CSV(path, CSVOptions{Header: true, Schema: &fixed, Mode: DropMalformed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readAll(src)
+	if err != nil {
+		t.Fatalf("DropMalformed failed with error: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("Expected 2 records, got %v", got)
+	}
+
+	var mu sync.Mutex
+	var lines []int64
+	sink := func(m Malformed) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, m.Line)
+	}
+	src, err = CSV(path, CSVOptions{Header: true, Schema: &fixed, Mode: SinkMalformed, Sink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readAll(src); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []int64{3, 4, 5}) {
+		t.Errorf("Expected malformed lines [3 4 5], got %v", lines)
+	}
+
+	if _, err := CSV(path, CSVOptions{Mode: SinkMalformed}); err == nil {
+		t.Error("Expected SinkMalformed without a Sink to fail")
+	}
+}
+
+func TestJSONLines_SplitsAndInference(t *testing.T) {
+	dir := t.TempDir()
+	var content strings.Builder
+	for i := 0; i < 50; i++ {
+		content.WriteString(`{"id": ` + strings.Repeat("1", 1+i%5) + `, "tags": ["a"], "name": "n"}` + "\n")
+	}
+	content.WriteString(`{"id": 2.5, "extra": {"x": 1}}` + "\n\n")
+	writeFile(t, filepath.Join(dir, "a.jsonl"), content.String())
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(`{"id": 7}` + "\n"))
+	zw.Close()
+	writeFile(t, filepath.Join(dir, "b.jsonl.gz"), gz.String())
+
+	src, err := JSONLines(dir, JSONOptions{MinPartitions: 4})
+	if err != nil {
+		t.Fatalf("JSONLines failed with error: %v", err)
+	}
+	if src.NumPartitions() < 4 {
+		t.Errorf("Expected at least 4 partitions, got %d", src.NumPartitions())
+	}
+	expectedSchema := schema.New(
+		schema.Field{Name: "extra", Type: schema.Any, Nullable: true},
+		schema.Field{Name: "id", Type: schema.Double},
+		schema.Field{Name: "name", Type: schema.String, Nullable: true},
+		schema.Field{Name: "tags", Type: schema.Any, Nullable: true},
+	)
+	if !reflect.DeepEqual(src.Schema(), expectedSchema) {
+		t.Errorf("Expected schema %s, got %s", expectedSchema, src.Schema())
+	}
+
+	got, err := readAll(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 52 {
+		t.Fatalf("Expected 52 records, got %d", len(got))
+	}
+	last := map[string]interface{}{"extra": map[string]interface{}{"x": 1.0}, "id": 2.5, "name": nil, "tags": nil}
+	if !reflect.DeepEqual(got[50], last) {
+		t.Errorf("Expected %v, got %v", last, got[50])
+	}
+	if got[51].(map[string]interface{})["id"] != 7.0 {
+		t.Errorf("Expected the gzip record last, got %v", got[51])
+	}
+}
+
+func TestJSONLines_MalformedAndSchema(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.jsonl")
+	writeFile(t, path, `{"id": 1}`+"\n[1]\n{bad\n"+`{"id": "two"}`+"\n"+`{"id": 3, "dropped": true}`+"\n")
+	fixed := schema.New(schema.Field{Name: "id", Type: schema.Long})
+
+	var raws []string
+	src, err := JSONLines(path, JSONOptions{Schema: &fixed, Mode: SinkMalformed, Sink: func(m Malformed) { raws = append(raws, m.Raw) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readAll(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{map[string]interface{}{"id": int64(1)}, map[string]interface{}{"id": int64(3)}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	if !reflect.DeepEqual(raws, []string{"[1]", "{bad", `{"id": "two"}`}) {
+		t.Errorf("Unexpected malformed records %q", raws)
+	}
+
+	src, err = JSONLines(path, JSONOptions{Schema: &fixed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readAll(src); err == nil || !strings.Contains(err.Error(), "events.jsonl:2") {
+		t.Errorf("Expected a failure on line 2, got %v", err)
+	}
+}
+
+type row struct {
+	Name  string  `col:"name"`
+	Score float64 `col:"score"`
+}
+
+func TestSources_StatisticsOnceRead(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.csv"), "city,visits\nparis,3\nrome,\n")
+	writeFile(t, filepath.Join(dir, "b.csv"), "city,visits\nparis,7\n")
+	writeFile(t, filepath.Join(dir, "c.jsonl"), `{"city":"oslo","visits":1}`+"\n"+`{"city":"oslo"}`+"\n")
+
+	csvSource, err := CSV(filepath.Join(dir, "*.csv"), CSVOptions{Header: true, InferSchema: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonSource, err := JSONLines(filepath.Join(dir, "c.jsonl"), JSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		source interface {
+			NumPartitions() int
+			Open(int) (lazy.SourceIterator, error)
+			Statistics() (stats.Statistics, bool)
+		}
+		want string
+	}{
+		{"csv", csvSource, "rows 3, distinct cities 2, visits 3 to 7 with 1 null"},
+		{"jsonl", jsonSource, "rows 2, distinct cities 1, visits 1 to 1 with 1 null"},
+	}
+	for _, tt := range tests {
+		if _, ok := tt.source.Statistics(); ok {
+			t.Errorf("%s: statistics known before reading", tt.name)
+		}
+		if _, err := readAll(tt.source); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		st, ok := tt.source.Statistics()
+		if !ok {
+			t.Fatalf("%s: statistics unknown after reading", tt.name)
+		}
+		visits := st.Columns["visits"]
+		got := fmt.Sprintf("rows %d, distinct cities %d, visits %v to %v with %d null", st.Rows, st.Columns["city"].Distinct, visits.Min, visits.Max, visits.Nulls)
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteCSV_RoundTrip(t *testing.T) {
+	records := []interface{}{
+		row{Name: "plain", Score: 1},
+		row{Name: "with, comma", Score: 2.5},
+		row{Name: "quote \" and\nbreak", Score: -3},
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, records, CSVWriteOptions{Header: true}); err != nil {
+		t.Fatalf("WriteCSV failed with error: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "name,score\nplain,1\n\"with, comma\",2.5\n") {
+		t.Errorf("Unexpected output %q", buf.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "out.csv")
+	writeFile(t, path, buf.String())
+	src, err := CSV(path, CSVOptions{Header: true, InferSchema: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readAll(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, record := range got {
+		decoded, err := schema.Decode[row](record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != records[i] {
+			t.Errorf("Expected %+v, got %+v", records[i], decoded)
+		}
+	}
+}
+
+func TestWriteCSV_Maps(t *testing.T) {
+	records := []interface{}{
+		map[string]interface{}{"b": 1, "a": nil},
+		map[string]interface{}{"c": true},
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, records, CSVWriteOptions{Header: true, NullValue: "NA", Delimiter: '\t'}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "a\tb\tc\nNA\t1\tNA\nNA\tNA\ttrue\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestWriteJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	records := []interface{}{row{Name: "<a>", Score: 1}, map[string]interface{}{"k": []int{1}}}
+	if err := WriteJSONLines(&buf, records); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"name":"<a>","score":1}` + "\n" + `{"k":[1]}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
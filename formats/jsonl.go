@@ -0,0 +1,261 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/schema"
+	"github.com/bajor/spark-go-core/source"
+	"github.com/bajor/spark-go-core/stats"
+)
+
+// JSONOptions configures a JSON lines reader
+type JSONOptions struct {
+	// Schema fixes the columns and their types; fields missing from it are dropped
+	Schema *schema.Schema
+	// SampleSize is the number of records sampled to infer the schema, defaults to
+	// DefaultSampleSize; a negative size samples everything
+	SampleSize int
+	// MinPartitions and MaxSplitSize control how files are split, see source.TextOptions
+	MinPartitions int
+	MaxSplitSize  int64
+	// Mode selects what happens to records that cannot be parsed
+	Mode Mode
+	// Sink receives malformed records in SinkMalformed mode
+	Sink func(Malformed)
+}
+
+// JSONSource reads files holding one JSON object per line into records mapping
+// field names to values converted to the schema. Files are split like text files.
+type JSONSource struct {
+	text   *source.TextSource
+	schema schema.Schema
+	policy policy
+	// recorder gathers the statistics of the splits read
+	recorder *stats.Recorder
+}
+
+// JSONLines creates a source over the files matched by patterns, see source.Expand.
+// Without a schema one is inferred from a sample, with fields sorted by name.
+func JSONLines(patterns string, options JSONOptions) (*JSONSource, error) {
+	if options.SampleSize == 0 {
+		options.SampleSize = DefaultSampleSize
+	}
+	p, err := newPolicy(options.Mode, options.Sink)
+	if err != nil {
+		return nil, fmt.Errorf("jsonl: %w", err)
+	}
+	text, err := source.TextFile(patterns, source.TextOptions{MinPartitions: options.MinPartitions, MaxSplitSize: options.MaxSplitSize})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &JSONSource{text: text, policy: p, recorder: stats.NewRecorder(text.NumPartitions())}
+	if options.Schema != nil {
+		s.schema = *options.Schema
+		return s, nil
+	}
+	if s.schema, err = s.infer(options.SampleSize); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Schema returns the schema records are read with
+func (s *JSONSource) Schema() schema.Schema {
+	return s.schema
+}
+
+// NumPartitions returns the number of splits
+func (s *JSONSource) NumPartitions() int {
+	return s.text.NumPartitions()
+}
+
+// Open returns an iterator over the records of one split
+func (s *JSONSource) Open(partition int) (lazy.SourceIterator, error) {
+	lines, err := s.text.Open(partition)
+	if err != nil {
+		return nil, err
+	}
+	path, start, _ := s.text.Split(partition)
+	it := &jsonIterator{source: s, lines: lines, path: path}
+	if start == 0 {
+		it.line = 0
+	} else {
+		it.line = -1
+	}
+	return s.recorder.Observe(partition, it), nil
+}
+
+// Statistics returns the statistics of the records, known once every split was read
+func (s *JSONSource) Statistics() (stats.Statistics, bool) {
+	return s.recorder.Statistics()
+}
+
+// infer samples records from the first splits. Malformed lines are left out.
+func (s *JSONSource) infer(sampleSize int) (schema.Schema, error) {
+	inferrer := schema.NewInferrer()
+	for p := 0; p < s.text.NumPartitions(); p++ {
+		if sampleSize >= 0 && inferrer.Records() >= sampleSize {
+			break
+		}
+		lines, err := s.text.Open(p)
+		if err != nil {
+			return schema.Schema{}, err
+		}
+		for sampleSize < 0 || inferrer.Records() < sampleSize {
+			line, ok := lines.Next()
+			if !ok {
+				break
+			}
+			object, err := decodeObject(line.(string))
+			if err != nil || object == nil {
+				continue
+			}
+			types := make(map[string]schema.Type, len(object))
+			for k, v := range object {
+				types[k] = schema.InferValue(v)
+			}
+			inferrer.Add(types)
+		}
+		err = lines.Err()
+		lines.Close()
+		if err != nil {
+			return schema.Schema{}, err
+		}
+	}
+	out := inferrer.Schema()
+	sort.Slice(out.Fields, func(i, j int) bool { return out.Fields[i].Name < out.Fields[j].Name })
+	return out, nil
+}
+
+// record converts a decoded object according to the schema
+func (s *JSONSource) record(object map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(s.schema.Fields))
+	for _, f := range s.schema.Fields {
+		v, ok := object[f.Name]
+		if !ok || v == nil {
+			if !f.Nullable {
+				return nil, fmt.Errorf("field %s is not nullable", f.Name)
+			}
+			out[f.Name] = nil
+			continue
+		}
+		converted, err := f.Type.Convert(v)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", f.Name, err)
+		}
+		out[f.Name] = normalize(converted)
+	}
+	return out, nil
+}
+
+// decodeObject parses one line, returning nil for a blank one
+func decodeObject(line string) (map[string]interface{}, error) {
+	if strings.TrimSpace(line) == "" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the object")
+	}
+	object, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object, got %s", describe(v))
+	}
+	return object, nil
+}
+
+func describe(v interface{}) string {
+	raw, _ := json.Marshal(v)
+	if len(raw) > 20 {
+		raw = append(bytes.Clone(raw[:20]), "..."...)
+	}
+	return string(raw)
+}
+
+// normalize turns the numbers of nested values into float64, as encoding/json does
+// without UseNumber
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		f, _ := x.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range x {
+			x[k] = normalize(item)
+		}
+	case []interface{}:
+		for i, item := range x {
+			x[i] = normalize(item)
+		}
+	}
+	return v
+}
+
+// jsonIterator parses the lines of one split. line counts lines for error reports
+// and stays negative when the split does not start at the beginning of its file.
+type jsonIterator struct {
+	source *JSONSource
+	lines  lazy.SourceIterator
+	path   string
+	line   int64
+	err    error
+}
+
+func (it *jsonIterator) Next() (interface{}, bool) {
+	for it.err == nil {
+		raw, ok := it.lines.Next()
+		if !ok {
+			it.err = it.lines.Err()
+			return nil, false
+		}
+		if it.line >= 0 {
+			it.line++
+		}
+		object, err := decodeObject(raw.(string))
+		if err == nil && object == nil {
+			continue
+		}
+		var record map[string]interface{}
+		if err == nil {
+			record, err = it.source.record(object)
+		}
+		if err != nil {
+			line := it.line
+			if line < 0 {
+				line = 0
+			}
+			it.err = it.source.policy.handle(Malformed{Path: it.path, Line: line, Raw: raw.(string), Err: err})
+			continue
+		}
+		return record, true
+	}
+	return nil, false
+}
+
+func (it *jsonIterator) Err() error {
+	return it.err
+}
+
+func (it *jsonIterator) Close() error {
+	return it.lines.Close()
+}
+
+func (it *jsonIterator) Reset() {
+	it.lines.Reset()
+	it.err = it.lines.Err()
+	if it.line > 0 {
+		it.line = 0
+	}
+}
@@ -0,0 +1,70 @@
+package formats
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Mode selects what readers do with records they cannot parse
+type Mode int
+
+const (
+	// FailFast fails the task reading a malformed record
+	FailFast Mode = iota
+	// DropMalformed skips malformed records silently
+	DropMalformed
+	// SinkMalformed skips malformed records after passing them to the Sink
+	SinkMalformed
+)
+
+// Malformed describes a record a reader could not parse
+type Malformed struct {
+	Path string
+	// Line is the line the record starts on, 0 when unknown
+	Line int64
+	Raw  string
+	Err  error
+}
+
+func (m Malformed) Error() string {
+	if m.Line > 0 {
+		return fmt.Sprintf("%s:%d: malformed record: %v", m.Path, m.Line, m.Err)
+	}
+	return fmt.Sprintf("%s: malformed record: %v", m.Path, m.Err)
+}
+
+func (m Malformed) Unwrap() error {
+	return m.Err
+}
+
+// policy applies a Mode
+type policy struct {
+	mode Mode
+	sink func(Malformed)
+}
+
+func newPolicy(mode Mode, sink func(Malformed)) (policy, error) {
+	switch mode {
+	case FailFast, DropMalformed:
+	case SinkMalformed:
+		if sink == nil {
+			return policy{}, errors.New("SinkMalformed needs a Sink")
+		}
+	default:
+		return policy{}, fmt.Errorf("unknown malformed record mode %d", mode)
+	}
+	return policy{mode: mode, sink: sink}, nil
+}
+
+// handle returns the error to fail with, or nil if the record is to be skipped.
+// The sink may be called from several tasks at once.
+func (p policy) handle(m Malformed) error {
+	switch p.mode {
+	case DropMalformed:
+		return nil
+	case SinkMalformed:
+		p.sink(m)
+		return nil
+	}
+	return m
+}
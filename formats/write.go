@@ -0,0 +1,198 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bajor/spark-go-core/schema"
+)
+
+// CSVWriteOptions configures WriteCSV
+type CSVWriteOptions struct {
+	// Header writes the column names first
+	Header bool
+	// Delimiter separates fields, defaults to ','
+	Delimiter rune
+	// Quote encloses fields that need it, defaults to '"'
+	Quote rune
+	// NullValue is written for nil values, defaults to the empty string
+	NullValue string
+	// Columns selects the columns and their order. It defaults to the fields of struct
+	// records, or to the sorted keys of map records.
+	Columns []string
+}
+
+// WriteCSV writes records as CSV. Records may be maps, structs, pointers to structs or
+// slices written positionally; anything else is written as a single field.
+func WriteCSV(w io.Writer, records []interface{}, options CSVWriteOptions) error {
+	if options.Delimiter == 0 {
+		options.Delimiter = ','
+	}
+	if options.Quote == 0 {
+		options.Quote = '"'
+	}
+	if options.Delimiter == options.Quote {
+		return errors.New("csv: delimiter and quote must differ")
+	}
+	columns := options.Columns
+	if columns == nil {
+		columns = inferColumns(records)
+	}
+
+	buf := bufio.NewWriter(w)
+	cw := csvWriter{w: buf, options: options}
+	if options.Header && len(columns) > 0 {
+		cw.writeRow(columns)
+	}
+	for _, record := range records {
+		fields, err := rowFields(record, columns, options.NullValue)
+		if err != nil {
+			return err
+		}
+		cw.writeRow(fields)
+	}
+	return buf.Flush()
+}
+
+// inferColumns picks the columns of structured records
+func inferColumns(records []interface{}) []string {
+	if len(records) == 0 {
+		return nil
+	}
+	t := reflect.TypeOf(records[0])
+	if t != nil {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			return schema.Columns(t)
+		}
+	}
+	seen := make(map[string]bool)
+	var columns []string
+	for _, record := range records {
+		m, ok := record.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
+			}
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+func rowFields(record interface{}, columns []string, null string) ([]string, error) {
+	switch x := record.(type) {
+	case []interface{}:
+		fields := make([]string, len(x))
+		for i, v := range x {
+			fields[i] = formatField(v, null)
+		}
+		return fields, nil
+	case map[string]interface{}:
+		return columnFields(x, columns, null), nil
+	}
+	if columns != nil {
+		m, err := schema.Encode(record)
+		if err == nil {
+			return columnFields(m, columns, null), nil
+		}
+	}
+	return []string{formatField(record, null)}, nil
+}
+
+func columnFields(m map[string]interface{}, columns []string, null string) []string {
+	fields := make([]string, len(columns))
+	for i, c := range columns {
+		fields[i] = formatField(m[c], null)
+	}
+	return fields
+}
+
+// formatField renders a value; nested values are written as JSON
+func formatField(v interface{}, null string) string {
+	switch x := v.(type) {
+	case nil:
+		return null
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(x)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return null
+		}
+		return formatField(rv.Elem().Interface(), null)
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(raw)
+}
+
+type csvWriter struct {
+	w       *bufio.Writer
+	options CSVWriteOptions
+}
+
+func (c csvWriter) writeRow(fields []string) {
+	for i, f := range fields {
+		if i > 0 {
+			c.w.WriteRune(c.options.Delimiter)
+		}
+		if !c.needsQuotes(f) {
+			c.w.WriteString(f)
+			continue
+		}
+		quote := string(c.options.Quote)
+		c.w.WriteString(quote)
+		c.w.WriteString(strings.ReplaceAll(f, quote, quote+quote))
+		c.w.WriteString(quote)
+	}
+	c.w.WriteByte('\n')
+}
+
+// needsQuotes reports whether a field must be quoted to read back the same
+func (c csvWriter) needsQuotes(f string) bool {
+	if f == "" {
+		return false
+	}
+	return strings.ContainsRune(f, c.options.Delimiter) || strings.ContainsRune(f, c.options.Quote) ||
+		strings.ContainsAny(f, "\r\n") || f[0] == ' ' || f[len(f)-1] == ' '
+}
+
+// WriteJSONLines writes every record as one line of JSON. Structs are written as
+// objects keyed by the same column names WriteCSV and schema.Decode use.
+func WriteJSONLines(w io.Writer, records []interface{}) error {
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	for _, record := range records {
+		if m, err := schema.Encode(record); err == nil {
+			record = m
+		}
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
@@ -0,0 +1,30 @@
+// Package atomicfile replaces small files so that readers see the old or the new
+// content, never a partial write
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Write replaces dir/name with data through a synced temporary file and a rename
+func Write(dir, name string, data []byte) error {
+	f, err := os.CreateTemp(dir, "."+name+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
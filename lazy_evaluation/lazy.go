@@ -129,6 +129,15 @@ func NewLazyChain(data []interface{}) *LazyChain {
 	}
 }
 
+// NewIteratorChain creates a chain reading its elements from it, e.g. from a channel
+// or a stream. Elements are pulled one at a time as Collect or ForEach consume them;
+// a SourceIterator is closed afterwards and its error reported.
+func NewIteratorChain(it Iterator) *LazyChain {
+	lc := NewLazyChain(nil)
+	lc.iterator = it
+	return lc
+}
+
 func (lc *LazyChain) AddFilter(filter func(interface{}) bool) *LazyChain {
 	lc.filters = append(lc.filters, filter)
 	return lc
@@ -172,6 +181,9 @@ func (lc *LazyChain) Collect() ([]interface{}, error) {
 		}
 		results = append(results, item)
 	}
+	if err := lc.finish(); err != nil {
+		return nil, err
+	}
 
 	// Apply reducers if any
 	for _, reducer := range lc.reducers {
@@ -206,11 +218,25 @@ func (lc *LazyChain) ForEach(fn func(interface{}) error) error {
 			break
 		}
 		if err := fn(item); err != nil {
+			lc.finish()
 			return err
 		}
 	}
 
-	return nil
+	return lc.finish()
+}
+
+// finish closes a source iterator and returns the error it stopped on
+func (lc *LazyChain) finish() error {
+	si, ok := lc.iterator.(SourceIterator)
+	if !ok {
+		return nil
+	}
+	err := si.Err()
+	if cerr := si.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
 // Legacy compatibility methods
@@ -249,6 +275,8 @@ TODO:
 1 - write generic Evaluate method which:
 	a - calls OptimizeOperationsOrder() -> take a look at all operations in the stack and optimized it - return new ordered list of operations
 		- optimization for now - first filters, then all maps then all reduces
+		- DataFrames know which columns their filters read, so their plans are reordered
+		  by the rule-based optimizer in dataframe/optimize.go
 2 - operations method should have embedded information wheter it's map or reduce - write common interface for tmem
 
 */
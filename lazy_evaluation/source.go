@@ -0,0 +1,24 @@
+package lazy
+
+// SourceIterator is an Iterator over external data such as a file. Next returns false
+// at the end of the data or on the first read error, which Err then reports. Close
+// releases the underlying resources; Reset reopens them.
+type SourceIterator interface {
+	Iterator
+	Err() error
+	Close() error
+}
+
+// Drain reads every remaining element of it, closes it and returns the elements
+func Drain(it SourceIterator) ([]interface{}, error) {
+	defer it.Close()
+	items := make([]interface{}, 0)
+	for {
+		item, ok := it.Next()
+		if !ok {
+			break
+		}
+		items = append(items, item)
+	}
+	return items, it.Err()
+}
@@ -0,0 +1,502 @@
+// Package msglog is a file-backed, append-only, partitioned message log with topics,
+// offsets and consumer-group commits. It stands in for a message broker so that
+// pipelines reading partitioned, offset-tracked input can run and be tested on one
+// machine.
+package msglog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bajor/spark-go-core/codec"
+	"github.com/bajor/spark-go-core/internal/atomicfile"
+)
+
+func init() {
+	// messages are shuffled and checkpointed like any other record
+	codec.Register(Message{})
+}
+
+// Message is a record of a topic partition
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	// Timestamp is the append time in Unix nanoseconds
+	Timestamp int64
+	Key       []byte
+	Value     []byte
+}
+
+// Time returns the append time
+func (m Message) Time() time.Time {
+	return time.Unix(0, m.Timestamp)
+}
+
+var (
+	// ErrUnknownTopic is returned for topics that were not created
+	ErrUnknownTopic = errors.New("unknown topic")
+	// ErrTopicExists is returned by CreateTopic for a topic that already exists
+	ErrTopicExists = errors.New("topic already exists")
+	// ErrOffsetOutOfRange is returned by Read for offsets past the end of a partition
+	ErrOffsetOutOfRange = errors.New("offset out of range")
+	// ErrClosed is returned once the log is closed
+	ErrClosed = errors.New("log is closed")
+)
+
+// names are used as file names
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+const (
+	topicsDir = "topics"
+	groupsDir = "groups"
+	metaFile  = "meta.json"
+	// a frame is the length and CRC-32 of its body followed by the body: the timestamp,
+	// the key length, or noKey, the key and the value
+	frameHeader = 8
+	bodyHeader  = 12
+	noKey       = ^uint32(0)
+)
+
+// Log is a directory of topics, each split into partitions. A partition is a file of
+// messages numbered by offset from zero; appending never changes earlier messages.
+// A Log is safe for concurrent use, but only one Log may have a directory open.
+type Log struct {
+	dir string
+
+	mu     sync.RWMutex
+	topics map[string]*topic
+	closed bool
+	// groupMu serializes commits, which read, merge and rewrite a file
+	groupMu sync.Mutex
+}
+
+type topic struct {
+	partitions []*partition
+	// next is the partition of the next message without a key
+	mu   sync.Mutex
+	next int
+}
+
+// partition is an open partition file and the position of every message in it
+type partition struct {
+	mu        sync.RWMutex
+	file      *os.File
+	positions []int64
+	size      int64
+}
+
+type topicMeta struct {
+	Partitions int `json:"partitions"`
+}
+
+// Open opens the log in dir, creating the directory if needed. A message torn by a
+// crash while it was appended is dropped from the end of its partition.
+func Open(dir string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Join(dir, topicsDir), 0o755); err != nil {
+		return nil, err
+	}
+	l := &Log{dir: dir, topics: make(map[string]*topic)}
+	entries, err := os.ReadDir(filepath.Join(dir, topicsDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !validName.MatchString(e.Name()) {
+			continue
+		}
+		t, err := l.openTopic(e.Name())
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		if t != nil {
+			l.topics[e.Name()] = t
+		}
+	}
+	return l, nil
+}
+
+// openTopic opens the partitions of a topic; a directory without metadata is a topic
+// whose creation did not complete and is ignored
+func (l *Log) openTopic(name string) (*topic, error) {
+	data, err := os.ReadFile(filepath.Join(l.dir, topicsDir, name, metaFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var meta topicMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("topic %s: %w", name, err)
+	}
+	if meta.Partitions < 1 {
+		return nil, fmt.Errorf("topic %s has %d partitions", name, meta.Partitions)
+	}
+	t := &topic{}
+	for p := 0; p < meta.Partitions; p++ {
+		part, err := openPartition(filepath.Join(l.dir, topicsDir, name, strconv.Itoa(p)+".log"))
+		if err != nil {
+			t.close()
+			return nil, fmt.Errorf("topic %s partition %d: %w", name, p, err)
+		}
+		t.partitions = append(t.partitions, part)
+	}
+	return t, nil
+}
+
+// openPartition opens a partition file, indexes its messages and truncates a torn
+// message at its end
+func openPartition(path string) (*partition, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	p := &partition{file: file}
+	header := make([]byte, frameHeader)
+	var body []byte
+	for p.size < info.Size() {
+		if _, err := file.ReadAt(header, p.size); err != nil {
+			break
+		}
+		n := int64(binary.BigEndian.Uint32(header))
+		if n < bodyHeader || p.size+frameHeader+n > info.Size() {
+			break
+		}
+		if int64(cap(body)) < n {
+			body = make([]byte, n)
+		}
+		body = body[:n]
+		if _, err := file.ReadAt(body, p.size+frameHeader); err != nil || crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:]) {
+			break
+		}
+		p.positions = append(p.positions, p.size)
+		p.size += frameHeader + n
+	}
+	if p.size < info.Size() {
+		if err := file.Truncate(p.size); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// CreateTopic creates a topic with the given number of partitions
+func (l *Log) CreateTopic(name string, partitions int) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid topic name %q", name)
+	}
+	if partitions < 1 {
+		return fmt.Errorf("topic %s needs at least one partition, got %d", name, partitions)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if _, ok := l.topics[name]; ok {
+		return fmt.Errorf("%s: %w", name, ErrTopicExists)
+	}
+	dir := filepath.Join(l.dir, topicsDir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(topicMeta{Partitions: partitions})
+	if err != nil {
+		return err
+	}
+	// the metadata is written last, see openTopic
+	if err := atomicfile.Write(dir, metaFile, data); err != nil {
+		return err
+	}
+	t, err := l.openTopic(name)
+	if err != nil {
+		return err
+	}
+	l.topics[name] = t
+	return nil
+}
+
+// Topics returns the sorted names of every topic
+func (l *Log) Topics() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	names := make([]string, 0, len(l.topics))
+	for name := range l.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Partitions returns the number of partitions of a topic
+func (l *Log) Partitions(name string) (int, error) {
+	t, err := l.topic(name)
+	if err != nil {
+		return 0, err
+	}
+	return len(t.partitions), nil
+}
+
+func (l *Log) topic(name string) (*topic, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	t, ok := l.topics[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrUnknownTopic)
+	}
+	return t, nil
+}
+
+func (l *Log) partition(name string, p int) (*partition, error) {
+	t, err := l.topic(name)
+	if err != nil {
+		return nil, err
+	}
+	if p < 0 || p >= len(t.partitions) {
+		return nil, fmt.Errorf("topic %s has no partition %d", name, p)
+	}
+	return t.partitions[p], nil
+}
+
+// Append appends a message to a topic and returns its partition and offset. Messages
+// with the same key go to the same partition, chosen by a hash of the key; messages
+// without a key are spread over the partitions in turn.
+func (l *Log) Append(name string, key, value []byte) (int, int64, error) {
+	t, err := l.topic(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	var p int
+	if key != nil {
+		h := fnv.New32a()
+		h.Write(key)
+		p = int(h.Sum32() % uint32(len(t.partitions)))
+	} else {
+		t.mu.Lock()
+		p = t.next
+		t.next = (t.next + 1) % len(t.partitions)
+		t.mu.Unlock()
+	}
+	offset, err := l.AppendTo(name, p, key, value)
+	return p, offset, err
+}
+
+// AppendTo appends a message to a partition of a topic and returns its offset
+func (l *Log) AppendTo(name string, p int, key, value []byte) (int64, error) {
+	part, err := l.partition(name, p)
+	if err != nil {
+		return 0, err
+	}
+	frame := make([]byte, frameHeader+bodyHeader, frameHeader+bodyHeader+len(key)+len(value))
+	binary.BigEndian.PutUint64(frame[frameHeader:], uint64(time.Now().UnixNano()))
+	keyLen := noKey
+	if key != nil {
+		keyLen = uint32(len(key))
+	}
+	binary.BigEndian.PutUint32(frame[frameHeader+8:], keyLen)
+	frame = append(append(frame, key...), value...)
+	body := frame[frameHeader:]
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	binary.BigEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(body))
+
+	part.mu.Lock()
+	defer part.mu.Unlock()
+	if _, err := part.file.WriteAt(frame, part.size); err != nil {
+		// a partly written frame is overwritten by the next append
+		return 0, fmt.Errorf("topic %s partition %d: %w", name, p, err)
+	}
+	part.positions = append(part.positions, part.size)
+	part.size += int64(len(frame))
+	return int64(len(part.positions) - 1), nil
+}
+
+// EndOffset returns the offset the next message appended to a partition will get
+func (l *Log) EndOffset(name string, p int) (int64, error) {
+	part, err := l.partition(name, p)
+	if err != nil {
+		return 0, err
+	}
+	part.mu.RLock()
+	defer part.mu.RUnlock()
+	return int64(len(part.positions)), nil
+}
+
+// Read returns up to max messages of a partition starting at offset, fewer at the
+// end of the partition. A max of zero or less reads to the end.
+func (l *Log) Read(name string, p int, offset int64, max int) ([]Message, error) {
+	part, err := l.partition(name, p)
+	if err != nil {
+		return nil, err
+	}
+	part.mu.RLock()
+	end := int64(len(part.positions))
+	if offset < 0 || offset > end {
+		part.mu.RUnlock()
+		return nil, fmt.Errorf("topic %s partition %d offset %d of %d: %w", name, p, offset, end, ErrOffsetOutOfRange)
+	}
+	last := end
+	if max > 0 && offset+int64(max) < end {
+		last = offset + int64(max)
+	}
+	from, to := part.size, part.size
+	if offset < end {
+		from = part.positions[offset]
+	}
+	if last < end {
+		to = part.positions[last]
+	}
+	part.mu.RUnlock()
+
+	// appends only add bytes after to, so the range is read without the lock
+	data := make([]byte, to-from)
+	if _, err := part.file.ReadAt(data, from); err != nil && err != io.EOF {
+		return nil, err
+	}
+	messages := make([]Message, 0, last-offset)
+	for pos := 0; pos < len(data); offset++ {
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		body := data[pos+frameHeader : pos+frameHeader+n]
+		m := Message{Topic: name, Partition: p, Offset: offset, Timestamp: int64(binary.BigEndian.Uint64(body))}
+		rest := body[bodyHeader:]
+		if keyLen := binary.BigEndian.Uint32(body[8:]); keyLen != noKey {
+			m.Key, rest = rest[:keyLen:keyLen], rest[keyLen:]
+		}
+		m.Value = rest
+		messages = append(messages, m)
+		pos += frameHeader + n
+	}
+	return messages, nil
+}
+
+// Commit records the offsets a consumer group has processed up to in a topic, by
+// partition: the offset of the next message the group will read. Partitions not in
+// offsets keep their committed offsets.
+func (l *Log) Commit(group, name string, offsets map[int]int64) error {
+	if !validName.MatchString(group) {
+		return fmt.Errorf("invalid group name %q", group)
+	}
+	t, err := l.topic(name)
+	if err != nil {
+		return err
+	}
+	for p, offset := range offsets {
+		if p < 0 || p >= len(t.partitions) {
+			return fmt.Errorf("topic %s has no partition %d", name, p)
+		}
+		if offset < 0 {
+			return fmt.Errorf("topic %s partition %d: negative offset %d", name, p, offset)
+		}
+	}
+	l.groupMu.Lock()
+	defer l.groupMu.Unlock()
+	committed, err := l.Committed(group, name)
+	if err != nil {
+		return err
+	}
+	for p, offset := range offsets {
+		committed[p] = offset
+	}
+	data, err := json.Marshal(committed)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(l.dir, groupsDir, group)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return atomicfile.Write(dir, name+".json", data)
+}
+
+// Committed returns the offsets committed by a consumer group in a topic, by
+// partition. A group starts at offset zero in the partitions it has not committed.
+func (l *Log) Committed(group, name string) (map[int]int64, error) {
+	if !validName.MatchString(group) {
+		return nil, fmt.Errorf("invalid group name %q", group)
+	}
+	if _, err := l.topic(name); err != nil {
+		return nil, err
+	}
+	committed := make(map[int]int64)
+	data, err := os.ReadFile(filepath.Join(l.dir, groupsDir, group, name+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return committed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &committed); err != nil {
+		return nil, fmt.Errorf("group %s topic %s: %w", group, name, err)
+	}
+	return committed, nil
+}
+
+// Sync flushes every partition to stable storage
+func (l *Log) Sync() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return ErrClosed
+	}
+	return l.sync()
+}
+
+func (l *Log) sync() error {
+	for _, t := range l.topics {
+		for _, part := range t.partitions {
+			if err := part.file.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close syncs and closes every partition
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	err := l.sync()
+	for _, t := range l.topics {
+		if closeErr := t.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (t *topic) close() error {
+	var err error
+	for _, part := range t.partitions {
+		if closeErr := part.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,210 @@
+package msglog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+)
+
+func openLog(t *testing.T, dir string) *Log {
+	t.Helper()
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed with error: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// values returns the values of messages as strings
+func values(messages []Message) []string {
+	out := make([]string, len(messages))
+	for i, m := range messages {
+		out[i] = string(m.Value)
+	}
+	return out
+}
+
+func TestLog_AppendReadAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir)
+	if err := l.CreateTopic("events", 3); err != nil {
+		t.Fatalf("CreateTopic failed with error: %v", err)
+	}
+	if err := l.CreateTopic("events", 3); !errors.Is(err, ErrTopicExists) {
+		t.Errorf("Creating a topic twice: got %v, want ErrTopicExists", err)
+	}
+
+	keyed := -1
+	for i := 0; i < 5; i++ {
+		p, offset, err := l.Append("events", []byte("user-1"), []byte(fmt.Sprint("v", i)))
+		if err != nil {
+			t.Fatalf("Append failed with error: %v", err)
+		}
+		if keyed >= 0 && p != keyed {
+			t.Errorf("Messages with the same key went to partitions %d and %d", keyed, p)
+		}
+		if offset != int64(i) {
+			t.Errorf("Append %d returned offset %d", i, offset)
+		}
+		keyed = p
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := l.AppendTo("events", i, nil, []byte("x")); err != nil {
+			t.Fatalf("AppendTo failed with error: %v", err)
+		}
+	}
+
+	messages, err := l.Read("events", keyed, 1, 3)
+	if err != nil {
+		t.Fatalf("Read failed with error: %v", err)
+	}
+	if got, want := values(messages), []string{"v1", "v2", "v3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Read values: got %v, want %v", got, want)
+	}
+	if m := messages[0]; m.Topic != "events" || m.Partition != keyed || m.Offset != 1 || string(m.Key) != "user-1" || m.Time().IsZero() {
+		t.Errorf("Unexpected message %+v", m)
+	}
+	if _, err := l.Read("events", keyed, 7, 0); !errors.Is(err, ErrOffsetOutOfRange) {
+		t.Errorf("Reading past the end: got %v, want ErrOffsetOutOfRange", err)
+	}
+	l.Close()
+
+	l = openLog(t, dir)
+	if got := l.Topics(); !reflect.DeepEqual(got, []string{"events"}) {
+		t.Errorf("Topics after reopening: got %v", got)
+	}
+	end, err := l.EndOffset("events", keyed)
+	if err != nil || end != 6 {
+		t.Errorf("EndOffset after reopening: got %d, %v, want 6", end, err)
+	}
+	messages, err = l.Read("events", keyed, 4, 0)
+	if err != nil {
+		t.Fatalf("Read failed with error: %v", err)
+	}
+	if got, want := values(messages), []string{"v4", "x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Values after reopening: got %v, want %v", got, want)
+	}
+	if messages[1].Key != nil {
+		t.Errorf("A message appended without a key has key %q", messages[1].Key)
+	}
+	if _, _, err := l.Append("missing", nil, nil); !errors.Is(err, ErrUnknownTopic) {
+		t.Errorf("Appending to a missing topic: got %v, want ErrUnknownTopic", err)
+	}
+}
+
+func TestLog_DropsTornMessageAtEnd(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir)
+	if err := l.CreateTopic("t", 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"a", "b"} {
+		if _, err := l.AppendTo("t", 0, nil, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	// a crash in the middle of appending a third message
+	path := filepath.Join(dir, topicsDir, "t", "0.log")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 40, 1, 2})
+	f.Close()
+
+	l = openLog(t, dir)
+	if end, _ := l.EndOffset("t", 0); end != 2 {
+		t.Errorf("EndOffset after a torn append: got %d, want 2", end)
+	}
+	if _, err := l.AppendTo("t", 0, nil, []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := l.Read("t", 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := values(messages), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Values after a torn append: got %v, want %v", got, want)
+	}
+}
+
+func TestLog_GroupCommits(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir)
+	if err := l.CreateTopic("t", 2); err != nil {
+		t.Fatal(err)
+	}
+	committed, err := l.Committed("readers", "t")
+	if err != nil || len(committed) != 0 {
+		t.Errorf("Committed before any commit: got %v, %v", committed, err)
+	}
+	if err := l.Commit("readers", "t", map[int]int64{0: 3, 1: 1}); err != nil {
+		t.Fatalf("Commit failed with error: %v", err)
+	}
+	if err := l.Commit("readers", "t", map[int]int64{1: 4}); err != nil {
+		t.Fatalf("Commit failed with error: %v", err)
+	}
+	if err := l.Commit("readers", "t", map[int]int64{2: 1}); err == nil {
+		t.Error("Expected a commit to a missing partition to fail")
+	}
+	if err := l.Commit("../x", "t", map[int]int64{0: 1}); err == nil {
+		t.Error("Expected an invalid group name to be rejected")
+	}
+	l.Close()
+
+	l = openLog(t, dir)
+	committed, err = l.Committed("readers", "t")
+	if err != nil {
+		t.Fatalf("Committed failed with error: %v", err)
+	}
+	if want := map[int]int64{0: 3, 1: 4}; !reflect.DeepEqual(committed, want) {
+		t.Errorf("Committed offsets: got %v, want %v", committed, want)
+	}
+	if other, _ := l.Committed("others", "t"); len(other) != 0 {
+		t.Errorf("Another group shares the commits: %v", other)
+	}
+}
+
+func TestLog_SourceReadsRanges(t *testing.T) {
+	l := openLog(t, t.TempDir())
+	if err := l.CreateTopic("t", 2); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2500; i++ {
+		if _, err := l.AppendTo("t", i%2, nil, []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := l.Source("t", []Range{{Partition: 1, From: 10, To: 1200}, {Partition: 0, From: 0, To: 2}})
+	if src.NumPartitions() != 2 {
+		t.Fatalf("NumPartitions: got %d, want 2", src.NumPartitions())
+	}
+	it, err := src.Open(0)
+	if err != nil {
+		t.Fatalf("Open failed with error: %v", err)
+	}
+	records, err := lazy.Drain(it)
+	if err != nil {
+		t.Fatalf("Reading the range failed with error: %v", err)
+	}
+	if len(records) != 1190 {
+		t.Fatalf("Range of 1190 messages read %d", len(records))
+	}
+	for i, r := range records {
+		if m := r.(Message); m.Partition != 1 || m.Offset != int64(10+i) || string(m.Value) != fmt.Sprint(2*(10+i)+1) {
+			t.Fatalf("Message %d of the range: %+v", i, m)
+		}
+	}
+
+	if _, err := l.Source("t", []Range{{Partition: 0, From: 0, To: 2000}}).Open(0); !errors.Is(err, ErrOffsetOutOfRange) {
+		t.Errorf("Range past the end: got %v, want ErrOffsetOutOfRange", err)
+	}
+}
@@ -0,0 +1,95 @@
+package msglog
+
+import (
+	"fmt"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// readChunk is how many messages a source iterator reads at a time
+const readChunk = 1024
+
+// Range is the messages of a partition from offset From up to, not including, To
+type Range struct {
+	Partition int   `json:"partition"`
+	From      int64 `json:"from"`
+	To        int64 `json:"to"`
+}
+
+// Len returns the number of messages in the range
+func (r Range) Len() int64 {
+	return r.To - r.From
+}
+
+// Source returns a source of the Messages of a topic in ranges, one RDD partition per
+// range, for rdd.FromSource. Ranges must end at or before the end offset of their
+// partition when the source is read.
+func (l *Log) Source(name string, ranges []Range) types.Source {
+	return &rangeSource{log: l, topic: name, ranges: ranges}
+}
+
+type rangeSource struct {
+	log    *Log
+	topic  string
+	ranges []Range
+}
+
+func (s *rangeSource) NumPartitions() int {
+	return len(s.ranges)
+}
+
+func (s *rangeSource) Open(partition int) (lazy.SourceIterator, error) {
+	r := s.ranges[partition]
+	end, err := s.log.EndOffset(s.topic, r.Partition)
+	if err != nil {
+		return nil, err
+	}
+	if r.From < 0 || r.From > r.To || r.To > end {
+		return nil, fmt.Errorf("topic %s partition %d range %d-%d of %d: %w", s.topic, r.Partition, r.From, r.To, end, ErrOffsetOutOfRange)
+	}
+	return &rangeIterator{source: s, r: r, next: r.From}, nil
+}
+
+// rangeIterator reads the messages of a range a chunk at a time
+type rangeIterator struct {
+	source *rangeSource
+	r      Range
+	next   int64
+	buf    []Message
+	err    error
+}
+
+func (it *rangeIterator) Next() (interface{}, bool) {
+	if len(it.buf) == 0 {
+		if it.err != nil || it.next >= it.r.To {
+			return nil, false
+		}
+		n := it.r.To - it.next
+		if n > readChunk {
+			n = readChunk
+		}
+		it.buf, it.err = it.source.log.Read(it.source.topic, it.r.Partition, it.next, int(n))
+		if it.err != nil || len(it.buf) == 0 {
+			return nil, false
+		}
+		it.next += int64(len(it.buf))
+	}
+	m := it.buf[0]
+	it.buf = it.buf[1:]
+	return m, true
+}
+
+func (it *rangeIterator) Err() error {
+	return it.err
+}
+
+func (it *rangeIterator) Close() error {
+	it.buf = nil
+	it.next = it.r.To
+	return nil
+}
+
+func (it *rangeIterator) Reset() {
+	it.buf, it.err, it.next = nil, nil, it.r.From
+}
@@ -1,5 +1,11 @@
 package operations
 
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
 // Map applies a function to each element in a slice
 func Map(data []interface{}, f func(interface{}) (interface{}, error)) ([]interface{}, error) {
 	result := make([]interface{}, len(data))
@@ -52,4 +58,51 @@ func ReduceByKey(data []interface{}, keyFunc func(interface{}) (interface{}, err
 	}
 
 	return result, nil
-} 
\ No newline at end of file
+}
+
+// Split divides data into n contiguous slices of roughly equal size
+func Split(data []interface{}, n int) [][]interface{} {
+	if n < 1 {
+		n = 1
+	}
+	parts := make([][]interface{}, n)
+	for i := 0; i < n; i++ {
+		start := i * len(data) / n
+		end := (i + 1) * len(data) / n
+		parts[i] = data[start:end]
+	}
+	return parts
+}
+
+// HashPartition distributes elements into n buckets by the hash of their key
+func HashPartition(data []interface{}, keyFunc func(interface{}) (interface{}, error), n int) ([][]interface{}, error) {
+	if n < 1 {
+		n = 1
+	}
+	buckets := make([][]interface{}, n)
+	for _, item := range data {
+		key, err := keyFunc(item)
+		if err != nil {
+			return nil, err
+		}
+		b := int(HashKey(key) % uint64(n))
+		buckets[b] = append(buckets[b], item)
+	}
+	return buckets, nil
+}
+
+// HashKey returns a stable hash for a key so equal keys always land in the same bucket
+func HashKey(key interface{}) uint64 {
+	h := fnv.New64a()
+	switch k := key.(type) {
+	case string:
+		h.Write([]byte(k))
+	case int:
+		h.Write([]byte(strconv.FormatInt(int64(k), 10)))
+	case int64:
+		h.Write([]byte(strconv.FormatInt(k, 10)))
+	default:
+		fmt.Fprintf(h, "%#v", k)
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,209 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+var errCorruptPage = errors.New("malformed page")
+
+// bitWidth returns the bits needed to store values up to max
+func bitWidth(max int) int {
+	return bits.Len(uint(max))
+}
+
+// decodeLevels reads count values of the RLE/bit-packed hybrid encoding used for
+// levels, dictionary indices and v2 booleans. Each run starts with a uvarint header
+// whose low bit selects a bit-packed run of header>>1 groups of eight values, or an
+// RLE run of header>>1 copies of one value.
+func decodeLevels(buf []byte, width, count int) ([]int32, error) {
+	out := make([]int32, 0, count)
+	byteWidth := (width + 7) / 8
+	for len(out) < count {
+		header, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, errCorruptPage
+		}
+		buf = buf[n:]
+		if header&1 == 0 {
+			run := int(header >> 1)
+			if len(buf) < byteWidth || run <= 0 {
+				return nil, errCorruptPage
+			}
+			if rest := count - len(out); run > rest {
+				run = rest
+			}
+			var v int32
+			for i := byteWidth - 1; i >= 0; i-- {
+				v = v<<8 | int32(buf[i])
+			}
+			buf = buf[byteWidth:]
+			for i := 0; i < run; i++ {
+				out = append(out, v)
+			}
+			continue
+		}
+		groups := header >> 1
+		if groups == 0 || groups > uint64(count-len(out)+7)/8 {
+			return nil, errCorruptPage
+		}
+		size := int(groups) * width
+		if size > len(buf) {
+			return nil, errCorruptPage
+		}
+		values := unpack(buf[:size], width, int(groups)*8)
+		buf = buf[size:]
+		if rest := count - len(out); len(values) > rest {
+			// the last group is padded to eight values
+			values = values[:rest]
+		}
+		out = append(out, values...)
+	}
+	return out, nil
+}
+
+// unpack reads n values of width bits packed from the least significant bit up
+func unpack(buf []byte, width, n int) []int32 {
+	out := make([]int32, n)
+	if width == 0 {
+		return out
+	}
+	bit := 0
+	for i := range out {
+		var v uint32
+		for b := 0; b < width; b++ {
+			if buf[bit/8]&(1<<(bit%8)) != 0 {
+				v |= 1 << b
+			}
+			bit++
+		}
+		out[i] = int32(v)
+	}
+	return out
+}
+
+// encodeLevels writes levels as RLE runs, which suits the long runs of definition
+// levels in mostly null or mostly set columns
+func encodeLevels(levels []int32, width int) []byte {
+	byteWidth := (width + 7) / 8
+	var out []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		out = binary.AppendUvarint(out, uint64(j-i)<<1)
+		for b := 0; b < byteWidth; b++ {
+			out = append(out, byte(levels[i]>>(8*b)))
+		}
+		i = j
+	}
+	return out
+}
+
+// decodePlain reads n plain encoded values of a physical type
+func decodePlain(buf []byte, physical, typeLength int32, n int) ([]interface{}, error) {
+	out := make([]interface{}, n)
+	fixed := func(size int) error {
+		if len(buf) < size*n {
+			return errCorruptPage
+		}
+		return nil
+	}
+	switch physical {
+	case typeBoolean:
+		if len(buf) < (n+7)/8 {
+			return nil, errCorruptPage
+		}
+		for i := range out {
+			out[i] = buf[i/8]&(1<<(i%8)) != 0
+		}
+	case typeInt32:
+		if err := fixed(4); err != nil {
+			return nil, err
+		}
+		for i := range out {
+			out[i] = int64(int32(binary.LittleEndian.Uint32(buf[4*i:])))
+		}
+	case typeInt64:
+		if err := fixed(8); err != nil {
+			return nil, err
+		}
+		for i := range out {
+			out[i] = int64(binary.LittleEndian.Uint64(buf[8*i:]))
+		}
+	case typeInt96:
+		if err := fixed(12); err != nil {
+			return nil, err
+		}
+		for i := range out {
+			out[i] = append([]byte(nil), buf[12*i:12*i+12]...)
+		}
+	case typeFloat:
+		if err := fixed(4); err != nil {
+			return nil, err
+		}
+		for i := range out {
+			out[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:])))
+		}
+	case typeDouble:
+		if err := fixed(8); err != nil {
+			return nil, err
+		}
+		for i := range out {
+			out[i] = math.Float64frombits(binary.LittleEndian.Uint64(buf[8*i:]))
+		}
+	case typeByteArray:
+		for i := range out {
+			if len(buf) < 4 {
+				return nil, errCorruptPage
+			}
+			size := binary.LittleEndian.Uint32(buf)
+			if uint64(size) > uint64(len(buf)-4) {
+				return nil, errCorruptPage
+			}
+			out[i] = buf[4 : 4+size]
+			buf = buf[4+size:]
+		}
+	case typeFixedLenByteArray:
+		size := int(typeLength)
+		if size < 0 || fixed(size) != nil {
+			return nil, errCorruptPage
+		}
+		for i := range out {
+			out[i] = buf[size*i : size*i+size]
+		}
+	default:
+		return nil, fmt.Errorf("unknown physical type %d", physical)
+	}
+	return out, nil
+}
+
+// appendPlain appends one plain encoded value of the writer's physical types
+func appendPlain(buf []byte, physical int32, v interface{}) []byte {
+	switch physical {
+	case typeInt64:
+		return binary.LittleEndian.AppendUint64(buf, uint64(v.(int64)))
+	case typeDouble:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.(float64)))
+	case typeByteArray:
+		b := v.([]byte)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(b)))
+		return append(buf, b...)
+	}
+	panic(fmt.Sprintf("parquet: no plain encoding for type %d", physical))
+}
+
+// packBooleans plain encodes booleans, one bit each
+func packBooleans(values []bool) []byte {
+	out := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			out[i/8] |= 1 << (i % 8)
+		}
+	}
+	return out
+}
@@ -0,0 +1,418 @@
+package parquet
+
+import (
+	"fmt"
+)
+
+// physical types
+const (
+	typeBoolean           = 0
+	typeInt32             = 1
+	typeInt64             = 2
+	typeInt96             = 3
+	typeFloat             = 4
+	typeDouble            = 5
+	typeByteArray         = 6
+	typeFixedLenByteArray = 7
+)
+
+var physicalNames = []string{"BOOLEAN", "INT32", "INT64", "INT96", "FLOAT", "DOUBLE", "BYTE_ARRAY", "FIXED_LEN_BYTE_ARRAY"}
+
+// repetition types
+const (
+	repRequired = 0
+	repOptional = 1
+	repRepeated = 2
+)
+
+// converted types this package interprets
+const (
+	convertedUTF8 = 0
+	convertedEnum = 4
+	convertedJSON = 19
+	convertedNone = -1
+)
+
+// logical type union members this package interprets
+const (
+	logicalString = 1
+	logicalEnum   = 4
+	logicalJSON   = 12
+)
+
+// encodings
+const (
+	encodingPlain           = 0
+	encodingPlainDictionary = 2
+	encodingRLE             = 3
+	encodingBitPacked       = 4
+	encodingRLEDictionary   = 8
+)
+
+// compression codecs
+const (
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecGzip         = 2
+)
+
+var codecNames = []string{"UNCOMPRESSED", "SNAPPY", "GZIP", "LZO", "BROTLI", "LZ4", "ZSTD", "LZ4_RAW"}
+
+// page types
+const (
+	pageData       = 0
+	pageIndex      = 1
+	pageDictionary = 2
+	pageDataV2     = 3
+)
+
+func enumName(names []string, v int32) string {
+	if v >= 0 && int(v) < len(names) {
+		return names[v]
+	}
+	return fmt.Sprint(v)
+}
+
+// schemaElement is one node of the flattened schema tree. Type is -1 for groups.
+type schemaElement struct {
+	Type        int32
+	TypeLength  int32
+	Repetition  int32
+	Name        string
+	NumChildren int32
+	Converted   int32
+	Logical     int16
+}
+
+func decodeSchemaElement(s tstruct) schemaElement {
+	e := schemaElement{Type: -1, Converted: convertedNone, Name: s.string(4)}
+	if v, ok := s.int(1); ok {
+		e.Type = int32(v)
+	}
+	if v, ok := s.int(2); ok {
+		e.TypeLength = int32(v)
+	}
+	if v, ok := s.int(3); ok {
+		e.Repetition = int32(v)
+	}
+	if v, ok := s.int(5); ok {
+		e.NumChildren = int32(v)
+	}
+	if v, ok := s.int(6); ok {
+		e.Converted = int32(v)
+	}
+	for id := range s.strct(10) {
+		e.Logical = id
+	}
+	return e
+}
+
+func (e schemaElement) encode() []tfield {
+	fields := []tfield{}
+	if e.Type >= 0 {
+		fields = append(fields, tfield{1, e.Type})
+	}
+	if e.Type >= 0 || e.Repetition != repRequired {
+		// the root is a group without a repetition
+		fields = append(fields, tfield{3, e.Repetition})
+	}
+	fields = append(fields, tfield{4, e.Name})
+	if e.NumChildren > 0 {
+		fields = append(fields, tfield{5, e.NumChildren})
+	}
+	if e.Converted != convertedNone {
+		fields = append(fields, tfield{6, e.Converted})
+	}
+	if e.Logical != 0 {
+		fields = append(fields, tfield{10, []tfield{{e.Logical, []tfield{}}}})
+	}
+	return fields
+}
+
+// statistics of a column chunk; Min and Max are plain encoded without a length prefix
+type statistics struct {
+	Min, Max     []byte
+	NullCount    int64
+	HasNullCount bool
+}
+
+func decodeStatistics(s tstruct, physical int32) *statistics {
+	if s == nil {
+		return nil
+	}
+	st := &statistics{}
+	st.NullCount, st.HasNullCount = s.int(3)
+	if min, max := s.bytes(6), s.bytes(5); min != nil && max != nil {
+		st.Min, st.Max = min, max
+	} else if physical != typeByteArray && physical != typeFixedLenByteArray {
+		// the deprecated fields compare signed, which is only right for numbers
+		st.Min, st.Max = s.bytes(2), s.bytes(1)
+	}
+	return st
+}
+
+func (st *statistics) encode() []tfield {
+	fields := []tfield{{3, st.NullCount}}
+	if st.Min != nil && st.Max != nil {
+		fields = append(fields, tfield{5, st.Max}, tfield{6, st.Min})
+	}
+	return fields
+}
+
+// columnMeta describes a column chunk
+type columnMeta struct {
+	Type                  int32
+	Encodings             []int32
+	Path                  []string
+	Codec                 int32
+	NumValues             int64
+	TotalUncompressedSize int64
+	TotalCompressedSize   int64
+	DataPageOffset        int64
+	DictionaryPageOffset  int64
+	Statistics            *statistics
+}
+
+// start returns the offset of the chunk's first page
+func (m columnMeta) start() int64 {
+	if m.DictionaryPageOffset > 0 && m.DictionaryPageOffset < m.DataPageOffset {
+		return m.DictionaryPageOffset
+	}
+	return m.DataPageOffset
+}
+
+func decodeColumnMeta(s tstruct) columnMeta {
+	m := columnMeta{}
+	if v, ok := s.int(1); ok {
+		m.Type = int32(v)
+	}
+	for _, e := range s.list(2) {
+		if v, ok := e.(int64); ok {
+			m.Encodings = append(m.Encodings, int32(v))
+		}
+	}
+	for _, p := range s.list(3) {
+		if b, ok := p.([]byte); ok {
+			m.Path = append(m.Path, string(b))
+		}
+	}
+	if v, ok := s.int(4); ok {
+		m.Codec = int32(v)
+	}
+	m.NumValues, _ = s.int(5)
+	m.TotalUncompressedSize, _ = s.int(6)
+	m.TotalCompressedSize, _ = s.int(7)
+	m.DataPageOffset, _ = s.int(9)
+	m.DictionaryPageOffset, _ = s.int(11)
+	m.Statistics = decodeStatistics(s.strct(12), m.Type)
+	return m
+}
+
+func (m columnMeta) encode() []tfield {
+	encodings := make([]interface{}, len(m.Encodings))
+	for i, e := range m.Encodings {
+		encodings[i] = e
+	}
+	path := make([]interface{}, len(m.Path))
+	for i, p := range m.Path {
+		path[i] = p
+	}
+	fields := []tfield{
+		{1, m.Type},
+		{2, tlist{elem: tI32, items: encodings}},
+		{3, tlist{elem: tBinary, items: path}},
+		{4, m.Codec},
+		{5, m.NumValues},
+		{6, m.TotalUncompressedSize},
+		{7, m.TotalCompressedSize},
+		{9, m.DataPageOffset},
+	}
+	if m.DictionaryPageOffset > 0 {
+		fields = append(fields, tfield{11, m.DictionaryPageOffset})
+	}
+	if m.Statistics != nil {
+		fields = append(fields, tfield{12, m.Statistics.encode()})
+	}
+	return fields
+}
+
+type rowGroup struct {
+	Columns       []columnMeta
+	TotalByteSize int64
+	NumRows       int64
+}
+
+// fileMetaData is the footer of a Parquet file
+type fileMetaData struct {
+	Version   int32
+	Schema    []schemaElement
+	NumRows   int64
+	RowGroups []rowGroup
+	CreatedBy string
+}
+
+func decodeFileMetaData(buf []byte) (*fileMetaData, error) {
+	s, _, err := readStruct(buf)
+	if err != nil {
+		return nil, fmt.Errorf("file metadata: %w", err)
+	}
+	m := &fileMetaData{CreatedBy: s.string(6)}
+	if v, ok := s.int(1); ok {
+		m.Version = int32(v)
+	}
+	m.NumRows, _ = s.int(3)
+	for _, e := range s.list(2) {
+		es, ok := e.(tstruct)
+		if !ok {
+			return nil, fmt.Errorf("file metadata: %w", errThriftCorrupt)
+		}
+		m.Schema = append(m.Schema, decodeSchemaElement(es))
+	}
+	for _, g := range s.list(4) {
+		gs, ok := g.(tstruct)
+		if !ok {
+			return nil, fmt.Errorf("file metadata: %w", errThriftCorrupt)
+		}
+		rg := rowGroup{}
+		rg.TotalByteSize, _ = gs.int(2)
+		rg.NumRows, _ = gs.int(3)
+		for _, c := range gs.list(1) {
+			cs, ok := c.(tstruct)
+			if !ok {
+				return nil, fmt.Errorf("file metadata: %w", errThriftCorrupt)
+			}
+			if cs.string(1) != "" {
+				return nil, fmt.Errorf("column chunks in other files are not supported")
+			}
+			meta := cs.strct(3)
+			if meta == nil {
+				return nil, fmt.Errorf("column chunk without metadata")
+			}
+			rg.Columns = append(rg.Columns, decodeColumnMeta(meta))
+		}
+		m.RowGroups = append(m.RowGroups, rg)
+	}
+	return m, nil
+}
+
+func (m *fileMetaData) encode() []byte {
+	schema := make([]interface{}, len(m.Schema))
+	for i, e := range m.Schema {
+		schema[i] = e.encode()
+	}
+	groups := make([]interface{}, len(m.RowGroups))
+	for i, rg := range m.RowGroups {
+		columns := make([]interface{}, len(rg.Columns))
+		var compressed int64
+		for j, c := range rg.Columns {
+			columns[j] = []tfield{{2, c.DataPageOffset}, {3, c.encode()}}
+			compressed += c.TotalCompressedSize
+		}
+		fields := []tfield{
+			{1, tlist{elem: tStruct, items: columns}},
+			{2, rg.TotalByteSize},
+			{3, rg.NumRows},
+		}
+		if len(rg.Columns) > 0 {
+			fields = append(fields, tfield{5, rg.Columns[0].start()}, tfield{6, compressed})
+		}
+		groups[i] = append(fields, tfield{7, int16(i)})
+	}
+	// every column is ordered by its type, so readers may trust min_value and max_value
+	orders := make([]interface{}, len(m.Schema)-1)
+	for i := range orders {
+		orders[i] = []tfield{{1, []tfield{}}}
+	}
+
+	w := &thriftWriter{}
+	w.writeStruct([]tfield{
+		{1, m.Version},
+		{2, tlist{elem: tStruct, items: schema}},
+		{3, m.NumRows},
+		{4, tlist{elem: tStruct, items: groups}},
+		{6, m.CreatedBy},
+		{7, tlist{elem: tStruct, items: orders}},
+	})
+	return w.buf
+}
+
+// pageHeader describes the page that follows it
+type pageHeader struct {
+	Type             int32
+	UncompressedSize int32
+	CompressedSize   int32
+
+	// NumValues counts values including nulls
+	NumValues int32
+	Encoding  int32
+	// LevelEncoding is the encoding of v1 definition levels
+	LevelEncoding int32
+	// DefinitionLevelsLength and RepetitionLevelsLength are set for v2 data pages,
+	// whose levels are stored uncompressed ahead of the values
+	DefinitionLevelsLength int32
+	RepetitionLevelsLength int32
+	Compressed             bool
+}
+
+func decodePageHeader(buf []byte) (pageHeader, int, error) {
+	s, n, err := readStruct(buf)
+	if err != nil {
+		return pageHeader{}, 0, fmt.Errorf("page header: %w", err)
+	}
+	h := pageHeader{Compressed: true}
+	t, _ := s.int(1)
+	u, _ := s.int(2)
+	c, _ := s.int(3)
+	h.Type, h.UncompressedSize, h.CompressedSize = int32(t), int32(u), int32(c)
+	if h.UncompressedSize < 0 || h.CompressedSize < 0 {
+		return pageHeader{}, 0, fmt.Errorf("page header: %w", errThriftCorrupt)
+	}
+	var sub tstruct
+	switch h.Type {
+	case pageData:
+		sub = s.strct(5)
+	case pageDictionary:
+		sub = s.strct(7)
+	case pageDataV2:
+		sub = s.strct(8)
+	default:
+		return h, n, nil
+	}
+	if sub == nil {
+		return pageHeader{}, 0, fmt.Errorf("page header: missing header of page type %d", h.Type)
+	}
+	num, _ := sub.int(1)
+	h.NumValues = int32(num)
+	enc, _ := sub.int(2)
+	levels, _ := sub.int(3)
+	h.LevelEncoding = int32(levels)
+	if h.Type == pageDataV2 {
+		enc, _ = sub.int(4)
+		d, _ := sub.int(5)
+		r, _ := sub.int(6)
+		h.DefinitionLevelsLength, h.RepetitionLevelsLength = int32(d), int32(r)
+		if compressed, ok := sub.bool(7); ok {
+			h.Compressed = compressed
+		}
+	}
+	h.Encoding = int32(enc)
+	return h, n, nil
+}
+
+// encodeDataPageHeader encodes the header of a v1 data page with PLAIN values and
+// RLE definition levels
+func encodeDataPageHeader(uncompressed, compressed, numValues int) []byte {
+	w := &thriftWriter{}
+	w.writeStruct([]tfield{
+		{1, int32(pageData)},
+		{2, int32(uncompressed)},
+		{3, int32(compressed)},
+		{5, []tfield{
+			{1, int32(numValues)},
+			{2, int32(encodingPlain)},
+			{3, int32(encodingRLE)},
+			{4, int32(encodingRLE)},
+		}},
+	})
+	return w.buf
+}
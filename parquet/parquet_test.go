@@ -0,0 +1,527 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bajor/spark-go-core/compression"
+	"github.com/bajor/spark-go-core/expr"
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/schema"
+)
+
+func readAll(t *testing.T, s *Source) []interface{} {
+	t.Helper()
+	all := make([]interface{}, 0)
+	for p := 0; p < s.NumPartitions(); p++ {
+		it, err := s.Open(p)
+		if err != nil {
+			t.Fatalf("Open(%d) failed with error: %v", p, err)
+		}
+		part, err := lazy.Drain(it)
+		if err != nil {
+			t.Fatalf("Reading partition %d failed with error: %v", p, err)
+		}
+		all = append(all, part...)
+	}
+	return all
+}
+
+func writeParquet(t *testing.T, path string, records []interface{}, s schema.Schema, options WriteOptions) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := WriteRecords(f, records, s, options); err != nil {
+		t.Fatalf("WriteRecords failed with error: %v", err)
+	}
+}
+
+var peopleSchema = schema.New(
+	schema.Field{Name: "id", Type: schema.Long},
+	schema.Field{Name: "name", Type: schema.String, Nullable: true},
+	schema.Field{Name: "score", Type: schema.Double},
+	schema.Field{Name: "active", Type: schema.Bool, Nullable: true},
+	schema.Field{Name: "extra", Type: schema.Any, Nullable: true},
+)
+
+func people(n int) []interface{} {
+	records := make([]interface{}, n)
+	for i := range records {
+		r := map[string]interface{}{"id": int64(i), "name": nil, "score": float64(i) / 2, "active": nil, "extra": nil}
+		if i%3 != 0 {
+			r["name"] = strings.Repeat("x", i)
+			r["active"] = i%2 == 0
+		}
+		if i%4 == 0 {
+			r["extra"] = map[string]interface{}{"tags": []interface{}{"a", "b"}}
+		}
+		records[i] = r
+	}
+	return records
+}
+
+func TestWriter_RoundTripsEveryCodec(t *testing.T) {
+	records := people(50)
+	for _, codec := range []string{compression.None, compression.Snappy, compression.Gzip} {
+		path := filepath.Join(t.TempDir(), "people.parquet")
+		// small row groups and pages spread the records over several of each
+		writeParquet(t, path, records, peopleSchema, WriteOptions{Compression: codec, RowGroupSize: 16, PageSize: 64})
+
+		src, err := Read(path, ReadOptions{})
+		if err != nil {
+			t.Fatalf("%s: Read failed with error: %v", codec, err)
+		}
+		if !reflect.DeepEqual(src.Schema(), peopleSchema) {
+			t.Errorf("%s: expected schema %s, got %s", codec, peopleSchema, src.Schema())
+		}
+		if src.NumPartitions() != 4 {
+			t.Errorf("%s: expected 4 row groups, got %d", codec, src.NumPartitions())
+		}
+		if result := readAll(t, src); !reflect.DeepEqual(result, records) {
+			t.Errorf("%s: records differ after the round trip:\ngot  %v\nwant %v", codec, result, records)
+		}
+	}
+}
+
+func TestWriter_StructsAndErrors(t *testing.T) {
+	type point struct {
+		X     int64   `col:"x"`
+		Y     float64 `col:"y"`
+		Label *string `col:"label"`
+	}
+	s := schema.New(
+		schema.Field{Name: "x", Type: schema.Long},
+		schema.Field{Name: "y", Type: schema.Double},
+		schema.Field{Name: "label", Type: schema.String, Nullable: true},
+	)
+	label := "origin"
+	var buf bytes.Buffer
+	err := WriteRecords(&buf, []interface{}{point{0, 0, &label}, point{X: 3, Y: 4.5}}, s, WriteOptions{})
+	if err != nil {
+		t.Fatalf("WriteRecords failed with error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "points.parquet")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed with error: %v", err)
+	}
+	if f.NumRows() != 2 || f.CreatedBy() != createdBy {
+		t.Errorf("Unexpected metadata: %d rows, created by %q", f.NumRows(), f.CreatedBy())
+	}
+	result, err := f.ReadRowGroup(0, []string{"label", "x"})
+	if err != nil {
+		t.Fatalf("ReadRowGroup failed with error: %v", err)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"label": "origin", "x": int64(0)},
+		map[string]interface{}{"label": nil, "x": int64(3)},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Projection failed: got %v, want %v", result, expected)
+	}
+
+	if err := WriteRecords(&buf, []interface{}{map[string]interface{}{"x": nil}}, s, WriteOptions{}); err == nil {
+		t.Error("Expected an error for a null in a required column")
+	}
+	if err := WriteRecords(&buf, []interface{}{map[string]interface{}{"x": (*int64)(nil), "y": 1.0}}, s, WriteOptions{}); err == nil || !strings.Contains(err.Error(), "not nullable") {
+		t.Errorf("Expected a nil pointer in a required column to be refused, got %v", err)
+	}
+	if err := WriteRecords(&buf, []interface{}{map[string]interface{}{"x": "three", "y": 1.0}}, s, WriteOptions{}); err == nil {
+		t.Error("Expected an error for a string in a long column")
+	}
+	if _, err := NewWriter(&buf, s, WriteOptions{Compression: "zstd"}); err == nil {
+		t.Error("Expected an error for an unsupported codec")
+	}
+	notParquet := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(notParquet, []byte("a,b\n1,2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFile(notParquet); err == nil || !strings.Contains(err.Error(), ErrNotParquet.Error()) {
+		t.Errorf("Expected ErrNotParquet, got %v", err)
+	}
+}
+
+func TestSource_PredicatesSkipRowGroups(t *testing.T) {
+	records := make([]interface{}, 10)
+	for i := range records {
+		name := interface{}(nil)
+		if i >= 4 {
+			name = string(rune('a' + i))
+		}
+		score := float64(i)
+		if i == 9 {
+			score = math.NaN()
+		}
+		records[i] = map[string]interface{}{"id": int64(i), "name": name, "score": score}
+	}
+	s := schema.New(
+		schema.Field{Name: "id", Type: schema.Long},
+		schema.Field{Name: "name", Type: schema.String, Nullable: true},
+		schema.Field{Name: "score", Type: schema.Double},
+	)
+	path := filepath.Join(t.TempDir(), "ids.parquet")
+	// two rows per row group: ids 0-1, 2-3, 4-5, 6-7 and 8-9
+	writeParquet(t, path, records, s, WriteOptions{RowGroupSize: 2})
+	src, err := Read(path, ReadOptions{})
+	if err != nil {
+		t.Fatalf("Read failed with error: %v", err)
+	}
+
+	tests := []struct {
+		predicate string
+		groups    []bool
+	}{
+		{"id >= 7", []bool{false, false, false, true, true}},
+		{"7 < id", []bool{false, false, false, false, true}},
+		{"id = 4 or id = 1", []bool{true, false, true, false, false}},
+		{"id != 0", []bool{true, true, true, true, true}},
+		{"id > 2 and name <= \"g\"", []bool{false, false, true, true, false}},
+		{"name = \"a\"", []bool{false, false, false, false, false}},
+		{"name = null", []bool{false, false, false, false, false}},
+		{"score > 8.5", []bool{false, false, false, false, false}},
+		{"score >= 8", []bool{false, false, false, false, true}},
+		{"id + 1 > 100", []bool{true, true, true, true, true}},
+		{"missing > 1", []bool{true, true, true, true, true}},
+		{"id > \"x\"", []bool{true, true, true, true, true}},
+	}
+	for _, tt := range tests {
+		pruned := src.WithPredicates([]expr.Expr{expr.MustParse(tt.predicate)}).(*Source)
+		groups := make([]bool, pruned.NumPartitions())
+		for p := range groups {
+			groups[p] = pruned.MayMatch(p)
+		}
+		if !reflect.DeepEqual(groups, tt.groups) {
+			t.Errorf("%s: expected row groups %v to be read, got %v", tt.predicate, tt.groups, groups)
+		}
+	}
+
+	pruned, err := Read(path, ReadOptions{Columns: []string{"id"}, Predicates: []expr.Expr{expr.MustParse("id >= 7")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"id": int64(6)},
+		map[string]interface{}{"id": int64(7)},
+		map[string]interface{}{"id": int64(8)},
+		map[string]interface{}{"id": int64(9)},
+	}
+	if result := readAll(t, pruned); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected the rows of the last two row groups, got %v", result)
+	}
+}
+
+func TestSource_WithColumns(t *testing.T) {
+	records := []interface{}{
+		map[string]interface{}{"id": int64(1), "name": "a"},
+		map[string]interface{}{"id": int64(2), "name": nil},
+	}
+	s := schema.New(
+		schema.Field{Name: "id", Type: schema.Long},
+		schema.Field{Name: "name", Type: schema.String, Nullable: true},
+	)
+	path := filepath.Join(t.TempDir(), "names.parquet")
+	writeParquet(t, path, records, s, WriteOptions{})
+	src, err := Read(path, ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	narrowed, err := src.WithColumns([]string{"name"})
+	if err != nil {
+		t.Fatalf("WithColumns failed with error: %v", err)
+	}
+	if got, want := narrowed.(*Source).Schema().String(), "(name string)"; got != want {
+		t.Errorf("Schema: got %s, want %s", got, want)
+	}
+	expected := []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{"name": nil}}
+	if result := readAll(t, narrowed.(*Source)); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected only the name column, got %v", result)
+	}
+	if _, err := src.WithColumns([]string{"missing"}); err == nil {
+		t.Errorf("WithColumns should reject unknown columns")
+	}
+}
+
+func TestSource_Statistics(t *testing.T) {
+	records := make([]interface{}, 10)
+	for i := range records {
+		name := interface{}(nil)
+		if i >= 4 {
+			name = string(rune('a' + i%3))
+		}
+		records[i] = map[string]interface{}{"id": int64(i), "name": name}
+	}
+	s := schema.New(
+		schema.Field{Name: "id", Type: schema.Long},
+		schema.Field{Name: "name", Type: schema.String, Nullable: true},
+	)
+	path := filepath.Join(t.TempDir(), "names.parquet")
+	writeParquet(t, path, records, s, WriteOptions{RowGroupSize: 2})
+	src, err := Read(path, ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, ok := src.Statistics()
+	if !ok {
+		t.Fatalf("Statistics unknown before reading")
+	}
+	id, name := st.Columns["id"], st.Columns["name"]
+	if st.Rows != 10 || st.Bytes == 0 || id.Bytes+name.Bytes != st.Bytes {
+		t.Errorf("Statistics from the footer: got %s with %d and %d column bytes", st, id.Bytes, name.Bytes)
+	}
+	if id.Min != int64(0) || id.Max != int64(9) || id.Nulls != 0 || id.Distinct != 0 {
+		t.Errorf("Statistics of id from the footer: got %+v", id)
+	}
+	if name.Min != "a" || name.Max != "c" || name.Nulls != 4 {
+		t.Errorf("Statistics of name from the footer: got %+v", name)
+	}
+
+	narrowed, err := src.WithColumns([]string{"name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, narrowed.(*Source))
+	st, _ = src.Statistics()
+	if got := st.Columns["name"].Distinct; got != 3 {
+		t.Errorf("Distinct names once read: got %d, want 3", got)
+	}
+	if got := st.Columns["id"].Distinct; got != 0 {
+		t.Errorf("Distinct ids known without reading them: got %d", got)
+	}
+	readAll(t, src)
+	if st, _ = src.Statistics(); st.Columns["id"].Distinct != 10 {
+		t.Errorf("Distinct ids once read: got %d, want 10", st.Columns["id"].Distinct)
+	}
+
+	pruned, _ := src.WithPredicates([]expr.Expr{expr.MustParse("id >= 7")}).(*Source).Statistics()
+	if pruned.Rows != 4 || pruned.Columns["id"].Min != int64(6) {
+		t.Errorf("Statistics of the row groups matching id >= 7: got %s", pruned)
+	}
+}
+
+func TestRead_SchemasMustAgree(t *testing.T) {
+	dir := t.TempDir()
+	a := schema.New(schema.Field{Name: "id", Type: schema.Long}, schema.Field{Name: "name", Type: schema.String})
+	b := schema.New(schema.Field{Name: "id", Type: schema.Long, Nullable: true}, schema.Field{Name: "name", Type: schema.Long})
+	writeParquet(t, filepath.Join(dir, "a.parquet"), []interface{}{map[string]interface{}{"id": 1, "name": "one"}}, a, WriteOptions{})
+	writeParquet(t, filepath.Join(dir, "b.parquet"), []interface{}{map[string]interface{}{"id": nil, "name": 2}}, b, WriteOptions{})
+
+	if _, err := Read(dir, ReadOptions{}); err == nil {
+		t.Error("Expected an error for column types that differ between files")
+	}
+	src, err := Read(dir, ReadOptions{Columns: []string{"id"}})
+	if err != nil {
+		t.Fatalf("Read failed with error: %v", err)
+	}
+	expected := schema.New(schema.Field{Name: "id", Type: schema.Long, Nullable: true})
+	if !reflect.DeepEqual(src.Schema(), expected) {
+		t.Errorf("Expected schema %s, got %s", expected, src.Schema())
+	}
+	if result := readAll(t, src); len(result) != 2 {
+		t.Errorf("Expected 2 records, got %v", result)
+	}
+	if _, err := Read(dir, ReadOptions{Columns: []string{"missing"}}); err == nil {
+		t.Error("Expected an error for a missing column")
+	}
+}
+
+// chunk is a hand-built column chunk: its pages and the metadata describing them
+type chunk struct {
+	meta          columnMeta
+	dictionary    []byte
+	pages         []byte
+	hasDictionary bool
+}
+
+// buildFile lays out a single row group file the way other writers do, with the
+// dictionary page of a chunk ahead of its data pages
+func buildFile(t *testing.T, elements []schemaElement, numRows int64, chunks []chunk) string {
+	t.Helper()
+	buf := append([]byte(nil), magic...)
+	rg := rowGroup{NumRows: numRows}
+	for _, c := range chunks {
+		meta := c.meta
+		start := int64(len(buf))
+		meta.DataPageOffset = start
+		if c.hasDictionary {
+			meta.DictionaryPageOffset = start
+			buf = append(buf, c.dictionary...)
+			meta.DataPageOffset = int64(len(buf))
+		}
+		buf = append(buf, c.pages...)
+		meta.TotalCompressedSize = int64(len(buf)) - start
+		meta.TotalUncompressedSize = meta.TotalCompressedSize
+		rg.Columns = append(rg.Columns, meta)
+	}
+	footer := (&fileMetaData{Version: 2, Schema: elements, NumRows: numRows, RowGroups: []rowGroup{rg}, CreatedBy: "parquet-mr"}).encode()
+	buf = append(buf, footer...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(footer)))
+	buf = append(buf, magic...)
+	path := filepath.Join(t.TempDir(), "built.parquet")
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func pageHeaderBytes(fields []tfield) []byte {
+	w := &thriftWriter{}
+	w.writeStruct(fields)
+	return w.buf
+}
+
+func TestRead_DictionaryAndV2Pages(t *testing.T) {
+	elements := []schemaElement{
+		{Type: -1, Converted: convertedNone, Name: "schema", NumChildren: 3},
+		{Type: typeInt32, Converted: convertedNone, Name: "code"},
+		{Type: typeByteArray, Converted: convertedUTF8, Repetition: repOptional, Name: "city"},
+		{Type: -1, Converted: convertedNone, Repetition: repOptional, Name: "tags", NumChildren: 1},
+		{Type: typeByteArray, Converted: convertedUTF8, Repetition: repRepeated, Name: "tag"},
+	}
+
+	// code: dictionary [7 9 11] and a v1 data page of bit-packed indices 0 0 1 0 2 of
+	// width 2, i.e. one group of eight values with the last three padding
+	var dict []byte
+	for _, v := range []uint32{7, 9, 11} {
+		dict = binary.LittleEndian.AppendUint32(dict, v)
+	}
+	indices := []byte{2, 0x03, 0x10, 0x02}
+	code := chunk{
+		meta:          columnMeta{Type: typeInt32, Encodings: []int32{encodingPlainDictionary, encodingRLE}, Path: []string{"code"}, Codec: codecUncompressed, NumValues: 5},
+		hasDictionary: true,
+		dictionary: append(pageHeaderBytes([]tfield{
+			{1, int32(pageDictionary)}, {2, int32(len(dict))}, {3, int32(len(dict))},
+			{7, []tfield{{1, int32(3)}, {2, int32(encodingPlainDictionary)}}},
+		}), dict...),
+		pages: append(pageHeaderBytes([]tfield{
+			{1, int32(pageData)}, {2, int32(len(indices))}, {3, int32(len(indices))},
+			{5, []tfield{{1, int32(5)}, {2, int32(encodingRLEDictionary)}, {3, int32(encodingRLE)}, {4, int32(encodingRLE)}}},
+		}), indices...),
+	}
+
+	// city: a snappy compressed v2 data page, whose definition levels 1 0 1 0 1 are
+	// bit-packed and stored uncompressed ahead of the values
+	levels := []byte{0x03, 0x15}
+	var plain []byte
+	for _, s := range []string{"oslo", "rome", "lima"} {
+		plain = binary.LittleEndian.AppendUint32(plain, uint32(len(s)))
+		plain = append(plain, s...)
+	}
+	snappy, err := compression.Lookup(compression.Snappy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := snappy.Compress(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	city := chunk{
+		meta: columnMeta{Type: typeByteArray, Encodings: []int32{encodingPlain, encodingRLE}, Path: []string{"city"}, Codec: codecSnappy, NumValues: 5},
+		pages: append(append(pageHeaderBytes([]tfield{
+			{1, int32(pageDataV2)}, {2, int32(len(levels) + len(plain))}, {3, int32(len(levels) + len(compressed))},
+			{8, []tfield{{1, int32(5)}, {2, int32(2)}, {3, int32(5)}, {4, int32(encodingPlain)}, {5, int32(len(levels))}, {6, int32(0)}}},
+		}), levels...), compressed...),
+	}
+	tags := chunk{meta: columnMeta{Type: typeByteArray, Path: []string{"tags", "tag"}, Codec: codecUncompressed}}
+
+	path := buildFile(t, elements, 5, []chunk{code, city, tags})
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed with error: %v", err)
+	}
+	expectedSchema := schema.New(
+		schema.Field{Name: "code", Type: schema.Long},
+		schema.Field{Name: "city", Type: schema.String, Nullable: true},
+		schema.Field{Name: "tags", Type: schema.Any, Nullable: true},
+	)
+	if !reflect.DeepEqual(f.Schema(), expectedSchema) {
+		t.Errorf("Expected schema %s, got %s", expectedSchema, f.Schema())
+	}
+	if _, err := f.ReadRowGroup(0, nil); err == nil || !strings.Contains(err.Error(), "nested") {
+		t.Errorf("Expected an error for the nested column, got %v", err)
+	}
+
+	src, err := Read(path, ReadOptions{Columns: []string{"code", "city"}})
+	if err != nil {
+		t.Fatalf("Read failed with error: %v", err)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"code": int64(7), "city": "oslo"},
+		map[string]interface{}{"code": int64(7), "city": nil},
+		map[string]interface{}{"code": int64(9), "city": "rome"},
+		map[string]interface{}{"code": int64(7), "city": nil},
+		map[string]interface{}{"code": int64(11), "city": "lima"},
+	}
+	if result := readAll(t, src); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Read failed:\ngot  %v\nwant %v", result, expected)
+	}
+}
+
+func TestDecodeLevels_RejectsMalformedRuns(t *testing.T) {
+	for _, buf := range [][]byte{
+		{},
+		{0x01},             // bit-packed run of zero groups
+		{0x05, 0xff},       // two groups where one is left
+		{0x08},             // RLE run missing its value
+		{0x00, 0x01, 0x02}, // RLE run of zero values
+	} {
+		if _, err := decodeLevels(buf, 1, 4); err == nil {
+			t.Errorf("Expected an error decoding %v", buf)
+		}
+	}
+	levels, err := decodeLevels([]byte{0x10, 0x01}, 1, 4)
+	if err != nil || !reflect.DeepEqual(levels, []int32{1, 1, 1, 1}) {
+		t.Errorf("Expected a long RLE run to be cut to the values needed, got %v (%v)", levels, err)
+	}
+}
+
+// TestRead_ToolFixtures reads the files other tools wrote, see testdata/generate.py
+func TestRead_ToolFixtures(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Skip("no fixtures in testdata; run testdata/generate.py with pyarrow installed")
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			src, err := Read(path, ReadOptions{})
+			if err != nil {
+				t.Fatalf("Read failed with error: %v", err)
+			}
+			// compare through JSON, which holds every number as a float64
+			got, err := json.Marshal(readAll(t, src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(strings.TrimSuffix(path, ".parquet") + ".json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotRows, wantRows interface{}
+			if err := json.Unmarshal(got, &gotRows); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(want, &wantRows); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotRows, wantRows) {
+				t.Errorf("Rows of %s differ from %s.json", path, strings.TrimSuffix(filepath.Base(path), ".parquet"))
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package parquet
+
+import (
+	"github.com/bajor/spark-go-core/expr"
+)
+
+// columnStats summarizes one column chunk for predicate pushdown
+type columnStats struct {
+	min, max  interface{}
+	hasMinMax bool
+	nulls     int64
+	hasNulls  bool
+	rows      int64
+}
+
+// mayMatch reports whether any row summarized by stats can satisfy the predicate.
+// It only answers false when the statistics prove that no row matches; anything it
+// does not understand is assumed to match.
+func mayMatch(e expr.Expr, stats map[string]columnStats) bool {
+	b, ok := e.(expr.Binary)
+	if !ok {
+		return true
+	}
+	switch b.Op {
+	case "and":
+		return mayMatch(b.Left, stats) && mayMatch(b.Right, stats)
+	case "or":
+		return mayMatch(b.Left, stats) || mayMatch(b.Right, stats)
+	}
+
+	op := b.Op
+	col, isCol := b.Left.(expr.Column)
+	lit, isLit := b.Right.(expr.Literal)
+	if !isCol || !isLit {
+		// literal on the left: flip the comparison
+		col, isCol = b.Right.(expr.Column)
+		lit, isLit = b.Left.(expr.Literal)
+		op = flipped[op]
+	}
+	if !isCol || !isLit || op == "" {
+		return true
+	}
+	st, ok := stats[col.Name]
+	if !ok {
+		return true
+	}
+	if lit.Value == nil {
+		// comparisons with null are never true
+		return false
+	}
+	if st.hasNulls && st.nulls == st.rows {
+		return false
+	}
+	if !st.hasMinMax {
+		return true
+	}
+
+	lo, err := expr.Compare(st.min, lit.Value)
+	if err != nil {
+		return true
+	}
+	hi, err := expr.Compare(st.max, lit.Value)
+	if err != nil {
+		return true
+	}
+	switch op {
+	case "=":
+		return lo <= 0 && hi >= 0
+	case "!=":
+		return lo != 0 || hi != 0
+	case "<":
+		return lo < 0
+	case "<=":
+		return lo <= 0
+	case ">":
+		return hi > 0
+	case ">=":
+		return hi >= 0
+	}
+	return true
+}
+
+// flipped maps a comparison to the one with its operands swapped
+var flipped = map[string]string{
+	"=":  "=",
+	"!=": "!=",
+	"<":  ">",
+	"<=": ">=",
+	">":  "<",
+	">=": "<=",
+}
@@ -0,0 +1,523 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/bajor/spark-go-core/compression"
+	"github.com/bajor/spark-go-core/schema"
+)
+
+// magic starts and ends every Parquet file
+var magic = []byte("PAR1")
+
+// ErrNotParquet is returned for files without the Parquet magic bytes
+var ErrNotParquet = errors.New("not a parquet file")
+
+// column is a top-level field of a file. Nested fields, i.e. groups and repeated
+// fields, are listed in the schema but cannot be read.
+type column struct {
+	// leaf is the index of the column's first column chunk and width the number of
+	// chunks it spans, more than one only for nested fields
+	leaf    int
+	width   int
+	element schemaElement
+	field   schema.Field
+	nested  bool
+}
+
+// File is the metadata of a Parquet file, read from its footer
+type File struct {
+	path    string
+	meta    *fileMetaData
+	columns []column
+}
+
+// OpenFile reads the footer of a Parquet file
+func OpenFile(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	head := make([]byte, 4)
+	tail := make([]byte, 8)
+	if size < 12 {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotParquet)
+	}
+	if _, err := f.ReadAt(head, 0); err != nil {
+		return nil, err
+	}
+	if _, err := f.ReadAt(tail, size-8); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(head, magic) || !bytes.Equal(tail[4:], magic) {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotParquet)
+	}
+	footerSize := int64(binary.LittleEndian.Uint32(tail))
+	if footerSize > size-12 {
+		return nil, fmt.Errorf("%s: footer of %d bytes does not fit", path, footerSize)
+	}
+	footer := make([]byte, footerSize)
+	if _, err := f.ReadAt(footer, size-8-footerSize); err != nil {
+		return nil, err
+	}
+	meta, err := decodeFileMetaData(footer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	columns, err := topLevelColumns(meta.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, rg := range meta.RowGroups {
+		if len(rg.Columns) != countLeaves(columns) {
+			return nil, fmt.Errorf("%s: row group has %d columns, schema has %d", path, len(rg.Columns), countLeaves(columns))
+		}
+	}
+	return &File{path: path, meta: meta, columns: columns}, nil
+}
+
+// topLevelColumns walks the flattened schema tree, numbering leaves depth first as
+// column chunks are ordered
+func topLevelColumns(elements []schemaElement) ([]column, error) {
+	if len(elements) == 0 {
+		return nil, errors.New("empty schema")
+	}
+	pos, leaf := 1, 0
+	// skip consumes an element and its subtree, returning the leaves it holds
+	var skip func() (int, error)
+	skip = func() (int, error) {
+		if pos >= len(elements) {
+			return 0, errors.New("schema tree is truncated")
+		}
+		e := elements[pos]
+		pos++
+		if e.Type >= 0 {
+			return 1, nil
+		}
+		leaves := 0
+		for i := 0; i < int(e.NumChildren); i++ {
+			n, err := skip()
+			if err != nil {
+				return 0, err
+			}
+			leaves += n
+		}
+		return leaves, nil
+	}
+
+	var columns []column
+	for i := 0; i < int(elements[0].NumChildren); i++ {
+		if pos >= len(elements) {
+			return nil, errors.New("schema tree is truncated")
+		}
+		e := elements[pos]
+		n, err := skip()
+		if err != nil {
+			return nil, err
+		}
+		c := column{leaf: leaf, width: n, element: e}
+		leaf += n
+		c.nested = e.Type < 0 || e.Repetition == repRepeated
+		c.field = schema.Field{Name: e.Name, Type: fieldType(e), Nullable: e.Repetition != repRequired}
+		if c.nested {
+			c.field.Type = schema.Any
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+func countLeaves(columns []column) int {
+	n := 0
+	for _, c := range columns {
+		n += c.width
+	}
+	return n
+}
+
+// fieldType maps a leaf to the schema type of the values it is read as
+func fieldType(e schemaElement) schema.Type {
+	switch e.Type {
+	case typeBoolean:
+		return schema.Bool
+	case typeInt32, typeInt64:
+		return schema.Long
+	case typeFloat, typeDouble:
+		return schema.Double
+	case typeByteArray:
+		if isString(e) {
+			return schema.String
+		}
+	}
+	return schema.Any
+}
+
+func isString(e schemaElement) bool {
+	return e.Converted == convertedUTF8 || e.Converted == convertedEnum ||
+		e.Logical == logicalString || e.Logical == logicalEnum
+}
+
+func isJSON(e schemaElement) bool {
+	return e.Converted == convertedJSON || e.Logical == logicalJSON
+}
+
+// Path returns the file's path
+func (f *File) Path() string {
+	return f.path
+}
+
+// Schema returns the top-level fields; nested ones have type Any
+func (f *File) Schema() schema.Schema {
+	fields := make([]schema.Field, len(f.columns))
+	for i, c := range f.columns {
+		fields[i] = c.field
+	}
+	return schema.Schema{Fields: fields}
+}
+
+// NumRows returns the number of rows in the file
+func (f *File) NumRows() int64 {
+	return f.meta.NumRows
+}
+
+// NumRowGroups returns the number of row groups
+func (f *File) NumRowGroups() int {
+	return len(f.meta.RowGroups)
+}
+
+// CreatedBy returns the name of the application that wrote the file
+func (f *File) CreatedBy() string {
+	return f.meta.CreatedBy
+}
+
+// project returns the columns with the given names, or every column without names
+func (f *File) project(names []string) ([]column, error) {
+	var out []column
+	if names == nil {
+		out = f.columns
+	} else {
+		for _, name := range names {
+			found := false
+			for _, c := range f.columns {
+				if c.field.Name == name {
+					out = append(out, c)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("%s: no column %q", f.path, name)
+			}
+		}
+	}
+	for _, c := range out {
+		if c.nested {
+			return nil, fmt.Errorf("%s: column %q is nested, which is not supported; leave it out of the projection", f.path, c.field.Name)
+		}
+	}
+	return out, nil
+}
+
+// ReadRowGroup reads the projected columns of one row group into records mapping
+// column names to values. A nil projection reads every column.
+func (f *File) ReadRowGroup(group int, columns []string) ([]interface{}, error) {
+	if group < 0 || group >= len(f.meta.RowGroups) {
+		return nil, fmt.Errorf("%s: no row group %d", f.path, group)
+	}
+	projected, err := f.project(columns)
+	if err != nil {
+		return nil, err
+	}
+	return f.readRowGroup(group, projected)
+}
+
+func (f *File) readRowGroup(group int, projected []column) ([]interface{}, error) {
+	rg := f.meta.RowGroups[group]
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := make([]map[string]interface{}, rg.NumRows)
+	for i := range records {
+		records[i] = make(map[string]interface{}, len(projected))
+	}
+	for _, c := range projected {
+		values, err := readColumnChunk(file, c, rg.Columns[c.leaf], rg.NumRows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: row group %d column %s: %w", f.path, group, c.field.Name, err)
+		}
+		for i, v := range values {
+			records[i][c.field.Name] = v
+		}
+	}
+	out := make([]interface{}, len(records))
+	for i, r := range records {
+		out[i] = r
+	}
+	return out, nil
+}
+
+// readColumnChunk decodes every page of a column chunk into one value per row, nil
+// for nulls
+func readColumnChunk(r io.ReaderAt, c column, meta columnMeta, numRows int64) ([]interface{}, error) {
+	if meta.TotalCompressedSize < 0 || meta.TotalCompressedSize > 1<<31 {
+		return nil, errCorruptPage
+	}
+	chunk := make([]byte, meta.TotalCompressedSize)
+	if _, err := r.ReadAt(chunk, meta.start()); err != nil {
+		return nil, err
+	}
+	maxDef := 0
+	if c.element.Repetition == repOptional {
+		maxDef = 1
+	}
+
+	out := make([]interface{}, 0, numRows)
+	var dictionary []interface{}
+	for int64(len(out)) < meta.NumValues && len(chunk) > 0 {
+		h, n, err := decodePageHeader(chunk)
+		if err != nil {
+			return nil, err
+		}
+		chunk = chunk[n:]
+		if int(h.CompressedSize) > len(chunk) {
+			return nil, errCorruptPage
+		}
+		page := chunk[:h.CompressedSize]
+		chunk = chunk[h.CompressedSize:]
+
+		switch h.Type {
+		case pageDictionary:
+			raw, err := decompress(meta.Codec, page, int(h.UncompressedSize))
+			if err != nil {
+				return nil, err
+			}
+			if dictionary, err = decodePlain(raw, c.element.Type, c.element.TypeLength, int(h.NumValues)); err != nil {
+				return nil, err
+			}
+		case pageData, pageDataV2:
+			var defs []int32
+			var values []byte
+			if h.Type == pageData {
+				raw, err := decompress(meta.Codec, page, int(h.UncompressedSize))
+				if err != nil {
+					return nil, err
+				}
+				if maxDef > 0 {
+					if h.LevelEncoding != encodingRLE {
+						return nil, fmt.Errorf("unsupported definition level encoding %d", h.LevelEncoding)
+					}
+					if len(raw) < 4 || int(binary.LittleEndian.Uint32(raw)) > len(raw)-4 {
+						return nil, errCorruptPage
+					}
+					size := int(binary.LittleEndian.Uint32(raw))
+					if defs, err = decodeLevels(raw[4:4+size], bitWidth(maxDef), int(h.NumValues)); err != nil {
+						return nil, err
+					}
+					raw = raw[4+size:]
+				}
+				values = raw
+			} else {
+				levels := int(h.RepetitionLevelsLength) + int(h.DefinitionLevelsLength)
+				if h.RepetitionLevelsLength < 0 || h.DefinitionLevelsLength < 0 || levels > len(page) {
+					return nil, errCorruptPage
+				}
+				if maxDef > 0 {
+					if defs, err = decodeLevels(page[h.RepetitionLevelsLength:levels], bitWidth(maxDef), int(h.NumValues)); err != nil {
+						return nil, err
+					}
+				}
+				values = page[levels:]
+				if h.Compressed {
+					if values, err = decompress(meta.Codec, values, int(h.UncompressedSize)-levels); err != nil {
+						return nil, err
+					}
+				}
+			}
+
+			count := int(h.NumValues)
+			if defs != nil {
+				count = 0
+				for _, d := range defs {
+					if int(d) == maxDef {
+						count++
+					}
+				}
+			}
+			decoded, err := decodeValues(values, h.Encoding, c.element, count, dictionary)
+			if err != nil {
+				return nil, err
+			}
+			next := 0
+			for i := 0; i < int(h.NumValues); i++ {
+				if defs != nil && int(defs[i]) != maxDef {
+					out = append(out, nil)
+					continue
+				}
+				v, err := convertValue(c.element, decoded[next])
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, v)
+				next++
+			}
+		}
+	}
+	if int64(len(out)) != numRows {
+		return nil, fmt.Errorf("read %d values, expected %d", len(out), numRows)
+	}
+	return out, nil
+}
+
+func decodeValues(buf []byte, encoding int32, e schemaElement, count int, dictionary []interface{}) ([]interface{}, error) {
+	switch encoding {
+	case encodingPlain:
+		return decodePlain(buf, e.Type, e.TypeLength, count)
+	case encodingPlainDictionary, encodingRLEDictionary:
+		if dictionary == nil {
+			return nil, errors.New("dictionary encoded page without a dictionary")
+		}
+		if count == 0 {
+			return nil, nil
+		}
+		if len(buf) < 1 {
+			return nil, errCorruptPage
+		}
+		indices, err := decodeLevels(buf[1:], int(buf[0]), count)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, count)
+		for i, idx := range indices {
+			if idx < 0 || int(idx) >= len(dictionary) {
+				return nil, errCorruptPage
+			}
+			out[i] = dictionary[idx]
+		}
+		return out, nil
+	case encodingRLE:
+		if e.Type != typeBoolean {
+			break
+		}
+		if len(buf) < 4 || int(binary.LittleEndian.Uint32(buf)) > len(buf)-4 {
+			return nil, errCorruptPage
+		}
+		levels, err := decodeLevels(buf[4:4+binary.LittleEndian.Uint32(buf)], 1, count)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, count)
+		for i, l := range levels {
+			out[i] = l != 0
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %d for %s", encoding, enumName(physicalNames, e.Type))
+}
+
+// julianUnixEpoch is the Julian day number of 1970-01-01, used by INT96 timestamps
+const julianUnixEpoch = 2440588
+
+// convertValue turns a decoded physical value into what records hold: strings for
+// string columns, decoded JSON for JSON columns and times for INT96 timestamps
+func convertValue(e schemaElement, v interface{}) (interface{}, error) {
+	switch e.Type {
+	case typeByteArray, typeFixedLenByteArray:
+		b := v.([]byte)
+		switch {
+		case isString(e):
+			return string(b), nil
+		case isJSON(e):
+			var out interface{}
+			if err := json.Unmarshal(b, &out); err != nil {
+				return nil, fmt.Errorf("json column: %w", err)
+			}
+			return out, nil
+		}
+		return append([]byte(nil), b...), nil
+	case typeInt96:
+		b := v.([]byte)
+		nanos := int64(binary.LittleEndian.Uint64(b))
+		day := int64(binary.LittleEndian.Uint32(b[8:]))
+		return time.Unix((day-julianUnixEpoch)*86400, nanos).UTC(), nil
+	}
+	return v, nil
+}
+
+// statisticValue decodes a min or max statistic of a column readable as a comparable
+// value, returning nil when it is not usable
+func statisticValue(e schemaElement, b []byte) interface{} {
+	switch e.Type {
+	case typeBoolean:
+		if len(b) == 1 {
+			return b[0] != 0
+		}
+	case typeInt32:
+		if len(b) == 4 {
+			return int64(int32(binary.LittleEndian.Uint32(b)))
+		}
+	case typeInt64:
+		if len(b) == 8 {
+			return int64(binary.LittleEndian.Uint64(b))
+		}
+	case typeFloat:
+		if len(b) == 4 {
+			if f := float64(math.Float32frombits(binary.LittleEndian.Uint32(b))); !math.IsNaN(f) {
+				return f
+			}
+		}
+	case typeDouble:
+		if len(b) == 8 {
+			if f := math.Float64frombits(binary.LittleEndian.Uint64(b)); !math.IsNaN(f) {
+				return f
+			}
+		}
+	case typeByteArray:
+		if isString(e) {
+			return string(b)
+		}
+	}
+	return nil
+}
+
+func decompress(codec int32, src []byte, rawSize int) ([]byte, error) {
+	var name string
+	switch codec {
+	case codecUncompressed:
+		if len(src) != rawSize {
+			return nil, errCorruptPage
+		}
+		return src, nil
+	case codecSnappy:
+		name = compression.Snappy
+	case codecGzip:
+		name = compression.Gzip
+	default:
+		return nil, fmt.Errorf("unsupported compression codec %s", enumName(codecNames, codec))
+	}
+	c, err := compression.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	out, err := c.Decompress(src, rawSize)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,276 @@
+package parquet
+
+import (
+	"fmt"
+
+	"github.com/bajor/spark-go-core/expr"
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/schema"
+	"github.com/bajor/spark-go-core/source"
+	"github.com/bajor/spark-go-core/stats"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// ReadOptions configures a Parquet source
+type ReadOptions struct {
+	// Columns projects the records to these columns; only their column chunks are read.
+	// Empty reads every column.
+	Columns []string
+	// Predicates skip row groups whose statistics prove no row satisfies all of them.
+	// Rows of the remaining groups are not filtered, so predicates are usually pushed
+	// down from FilterExpr calls that still run afterwards.
+	Predicates []expr.Expr
+}
+
+// groupRef is one row group of one file
+type groupRef struct {
+	file  *File
+	group int
+}
+
+// Source reads Parquet files as records mapping column names to values, with one
+// partition per row group
+type Source struct {
+	groups     []groupRef
+	columns    []string
+	schema     schema.Schema
+	predicates []expr.Expr
+	// recorder gathers the distinct values of the row groups read, shared by the
+	// copies WithPredicates and WithColumns return
+	recorder *stats.Recorder
+}
+
+// Read creates a source over the Parquet files matched by patterns, a comma-separated
+// list of paths, globs and directories. Every file must have the projected columns
+// with the same types.
+func Read(patterns string, options ReadOptions) (*Source, error) {
+	paths, err := source.Expand(patterns)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("parquet: no files match %q", patterns)
+	}
+	s := &Source{predicates: options.Predicates}
+	for i, path := range paths {
+		f, err := OpenFile(path)
+		if err != nil {
+			return nil, err
+		}
+		projected, err := f.project(options.Columns)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			for _, c := range projected {
+				s.columns = append(s.columns, c.field.Name)
+				s.schema.Fields = append(s.schema.Fields, c.field)
+			}
+		} else if err := s.merge(f.path, projected); err != nil {
+			return nil, err
+		}
+		for g := range f.meta.RowGroups {
+			s.groups = append(s.groups, groupRef{file: f, group: g})
+		}
+	}
+	s.recorder = stats.NewRecorder(len(s.groups))
+	return s, nil
+}
+
+// merge checks that a file's projected columns match the source schema, widening
+// nullability
+func (s *Source) merge(path string, projected []column) error {
+	if len(projected) != len(s.schema.Fields) {
+		return fmt.Errorf("parquet: %s has %d columns, expected %d", path, len(projected), len(s.schema.Fields))
+	}
+	for i, c := range projected {
+		f := &s.schema.Fields[i]
+		if c.field.Name != f.Name || c.field.Type != f.Type {
+			return fmt.Errorf("parquet: %s has column %s %s where %s %s was expected", path, c.field.Name, c.field.Type, f.Name, f.Type)
+		}
+		f.Nullable = f.Nullable || c.field.Nullable
+	}
+	return nil
+}
+
+// Schema returns the projected columns
+func (s *Source) Schema() schema.Schema {
+	return s.schema
+}
+
+// NumPartitions returns the number of row groups
+func (s *Source) NumPartitions() int {
+	return len(s.groups)
+}
+
+// WithPredicates returns a copy of the source that also skips row groups not matching
+// predicates
+func (s *Source) WithPredicates(predicates []expr.Expr) types.Source {
+	c := *s
+	c.predicates = append(append([]expr.Expr(nil), s.predicates...), predicates...)
+	return &c
+}
+
+// WithColumns returns a copy of the source reading only some of its columns
+func (s *Source) WithColumns(columns []string) (types.Source, error) {
+	c := *s
+	c.columns, c.schema = nil, schema.Schema{}
+	for _, name := range columns {
+		f, ok := s.schema.Field(name)
+		if !ok {
+			return nil, fmt.Errorf("parquet: no column %s in %s", name, s.schema)
+		}
+		c.columns = append(c.columns, name)
+		c.schema.Fields = append(c.schema.Fields, f)
+	}
+	return &c, nil
+}
+
+// MayMatch reports whether the statistics of a partition's row group allow rows
+// satisfying every predicate
+func (s *Source) MayMatch(partition int) bool {
+	ref := s.groups[partition]
+	stats := ref.file.stats(ref.group)
+	for _, p := range s.predicates {
+		if !mayMatch(p, stats) {
+			return false
+		}
+	}
+	return true
+}
+
+// Open reads one row group, or nothing when its statistics rule out every predicate
+func (s *Source) Open(partition int) (lazy.SourceIterator, error) {
+	if partition < 0 || partition >= len(s.groups) {
+		return nil, fmt.Errorf("parquet source has no partition %d", partition)
+	}
+	if !s.MayMatch(partition) {
+		return &recordIterator{}, nil
+	}
+	ref := s.groups[partition]
+	projected, err := ref.file.project(s.columns)
+	if err != nil {
+		return nil, err
+	}
+	return s.recorder.Observe(partition, &recordIterator{read: func() ([]interface{}, error) {
+		return ref.file.readRowGroup(ref.group, projected)
+	}}), nil
+}
+
+// Statistics returns the statistics of the row groups that may match the predicates,
+// read from the file footers: their rows, the uncompressed size of the projected
+// column chunks and the nulls, minimum and maximum of each column. Distinct counts
+// are only known once every row group was read.
+func (s *Source) Statistics() (stats.Statistics, bool) {
+	out := stats.Statistics{Columns: make(map[string]stats.Column, len(s.columns))}
+	bounded := make(map[string]bool, len(s.columns))
+	for _, name := range s.columns {
+		bounded[name] = true
+	}
+	for p, ref := range s.groups {
+		if !s.MayMatch(p) {
+			continue
+		}
+		rg := ref.file.meta.RowGroups[ref.group]
+		out.Rows += rg.NumRows
+		projected, err := ref.file.project(s.columns)
+		if err != nil {
+			return stats.Statistics{}, false
+		}
+		groupStats := ref.file.stats(ref.group)
+		for _, c := range projected {
+			col := out.Columns[c.field.Name]
+			for leaf := c.leaf; leaf < c.leaf+c.width; leaf++ {
+				col.Bytes += rg.Columns[leaf].TotalUncompressedSize
+			}
+			st := groupStats[c.field.Name]
+			col.Nulls += st.nulls
+			switch {
+			case st.hasNulls && st.nulls == st.rows:
+				// an all-null group has no bounds
+			case !st.hasMinMax:
+				bounded[c.field.Name] = false
+			case col.Min == nil:
+				col.Min, col.Max = st.min, st.max
+			default:
+				if c, err := expr.Compare(st.min, col.Min); err == nil && c < 0 {
+					col.Min = st.min
+				}
+				if c, err := expr.Compare(st.max, col.Max); err == nil && c > 0 {
+					col.Max = st.max
+				}
+			}
+			out.Columns[c.field.Name] = col
+		}
+	}
+	read, complete := s.recorder.Statistics()
+	for name, col := range out.Columns {
+		out.Bytes += col.Bytes
+		if !bounded[name] {
+			col.Min, col.Max = nil, nil
+		}
+		if complete {
+			col.Distinct = read.Columns[name].Distinct
+		}
+		out.Columns[name] = col
+	}
+	return out, true
+}
+
+// stats returns the usable statistics of a row group's top-level columns
+func (f *File) stats(group int) map[string]columnStats {
+	rg := f.meta.RowGroups[group]
+	out := make(map[string]columnStats, len(f.columns))
+	for _, c := range f.columns {
+		if c.nested {
+			continue
+		}
+		meta := rg.Columns[c.leaf]
+		st := columnStats{rows: rg.NumRows}
+		if meta.Statistics != nil {
+			st.nulls, st.hasNulls = meta.Statistics.NullCount, meta.Statistics.HasNullCount
+			st.min = statisticValue(c.element, meta.Statistics.Min)
+			st.max = statisticValue(c.element, meta.Statistics.Max)
+			st.hasMinMax = st.min != nil && st.max != nil
+		}
+		out[c.field.Name] = st
+	}
+	return out
+}
+
+// recordIterator reads its row group on the first call to Next
+type recordIterator struct {
+	read    func() ([]interface{}, error)
+	records []interface{}
+	next    int
+	err     error
+}
+
+func (it *recordIterator) Next() (interface{}, bool) {
+	if it.read != nil && it.records == nil && it.err == nil {
+		it.records, it.err = it.read()
+		if it.records == nil && it.err == nil {
+			it.records = []interface{}{}
+		}
+	}
+	if it.err != nil || it.next >= len(it.records) {
+		return nil, false
+	}
+	r := it.records[it.next]
+	it.next++
+	return r, true
+}
+
+func (it *recordIterator) Err() error {
+	return it.err
+}
+
+func (it *recordIterator) Close() error {
+	it.records = nil
+	it.read = nil
+	return nil
+}
+
+func (it *recordIterator) Reset() {
+	it.next = 0
+}
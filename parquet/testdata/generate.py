@@ -0,0 +1,40 @@
+"""Writes the Parquet fixtures the reader tests check against files of other tools.
+
+Run with pyarrow installed, from this directory:
+
+    python3 generate.py
+
+Every <name>.parquet is written next to <name>.json, the rows it holds as a JSON
+array of objects, which TestRead_ToolFixtures compares the rows read back with.
+"""
+
+import json
+
+import pyarrow as pa
+import pyarrow.parquet as pq
+
+rows = [
+    {"id": i, "code": i % 7, "score": i * 0.5, "city": [None, "paris", "rome", "oslo"][i % 4], "active": i % 3 == 0}
+    for i in range(300)
+]
+table = pa.Table.from_pylist(
+    rows,
+    schema=pa.schema([
+        pa.field("id", pa.int64(), nullable=False),
+        pa.field("code", pa.int32()),
+        pa.field("score", pa.float64()),
+        pa.field("city", pa.string()),
+        pa.field("active", pa.bool_()),
+    ]),
+)
+
+fixtures = {
+    # the pyarrow defaults: dictionary encoding, v1 data pages, Snappy
+    "pyarrow_snappy": dict(row_group_size=128),
+    "pyarrow_gzip_v2": dict(row_group_size=128, compression="gzip", data_page_version="2.0"),
+    "pyarrow_plain": dict(compression="none", use_dictionary=False),
+}
+for name, options in fixtures.items():
+    pq.write_table(table, name + ".parquet", **options)
+    with open(name + ".json", "w") as f:
+        json.dump(rows, f)
@@ -0,0 +1,335 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Parquet metadata is serialized with the Thrift compact protocol. Rather than
+// generated code, structs are decoded into a map by field id and encoded from an
+// ordered field list; metadata.go converts between these and typed structs.
+
+// compact protocol type ids
+const (
+	tStop   = 0
+	tTrue   = 1
+	tFalse  = 2
+	tByte   = 3
+	tI16    = 4
+	tI32    = 5
+	tI64    = 6
+	tDouble = 7
+	tBinary = 8
+	tList   = 9
+	tSet    = 10
+	tMap    = 11
+	tStruct = 12
+)
+
+// maxThriftDepth bounds nesting so corrupt input cannot exhaust the stack
+const maxThriftDepth = 64
+
+var errThriftCorrupt = errors.New("malformed thrift data")
+
+// tstruct is a decoded struct. Integers are int64, doubles float64, binaries []byte,
+// lists and sets []interface{} and nested structs tstruct; maps are skipped.
+type tstruct map[int16]interface{}
+
+func (s tstruct) int(id int16) (int64, bool) {
+	v, ok := s[id].(int64)
+	return v, ok
+}
+
+func (s tstruct) bytes(id int16) []byte {
+	v, _ := s[id].([]byte)
+	return v
+}
+
+func (s tstruct) string(id int16) string {
+	return string(s.bytes(id))
+}
+
+func (s tstruct) bool(id int16) (bool, bool) {
+	v, ok := s[id].(bool)
+	return v, ok
+}
+
+func (s tstruct) list(id int16) []interface{} {
+	v, _ := s[id].([]interface{})
+	return v
+}
+
+func (s tstruct) strct(id int16) tstruct {
+	v, _ := s[id].(tstruct)
+	return v
+}
+
+// thriftReader decodes from a byte slice and tracks how much it consumed, which
+// page headers need to find the page data that follows them
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+// readStruct decodes one struct from the start of buf and returns it with its length
+func readStruct(buf []byte) (tstruct, int, error) {
+	r := &thriftReader{buf: buf}
+	s, err := r.readStruct(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s, r.pos, nil
+}
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, errThriftCorrupt
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, errThriftCorrupt
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) varint() (int64, error) {
+	v, err := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (r *thriftReader) readStruct(depth int) (tstruct, error) {
+	if depth > maxThriftDepth {
+		return nil, errThriftCorrupt
+	}
+	s := tstruct{}
+	var last int16
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if b == tStop {
+			return s, nil
+		}
+		typ := b & 0x0f
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		last = id
+		v, err := r.value(typ, depth)
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %w", id, err)
+		}
+		s[id] = v
+	}
+}
+
+func (r *thriftReader) value(typ byte, depth int) (interface{}, error) {
+	switch typ {
+	case tTrue:
+		return true, nil
+	case tFalse:
+		return false, nil
+	case tByte:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case tI16, tI32, tI64:
+		return r.varint()
+	case tDouble:
+		if len(r.buf)-r.pos < 8 {
+			return nil, errThriftCorrupt
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.buf[r.pos:]))
+		r.pos += 8
+		return v, nil
+	case tBinary:
+		n, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(r.buf)-r.pos) {
+			return nil, errThriftCorrupt
+		}
+		v := r.buf[r.pos : r.pos+int(n)]
+		r.pos += int(n)
+		return v, nil
+	case tList, tSet:
+		h, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, elem := uint64(h>>4), h&0x0f
+		if size == 15 {
+			if size, err = r.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		if size > uint64(len(r.buf)-r.pos) {
+			return nil, errThriftCorrupt
+		}
+		items := make([]interface{}, size)
+		for i := range items {
+			if elem == tTrue || elem == tFalse {
+				// booleans in containers take a byte each
+				b, err := r.byte()
+				if err != nil {
+					return nil, err
+				}
+				items[i] = b == tTrue
+				continue
+			}
+			if items[i], err = r.value(elem, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case tMap:
+		size, err := r.uvarint()
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		kv, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if size > uint64(len(r.buf)-r.pos) {
+			return nil, errThriftCorrupt
+		}
+		for i := uint64(0); i < size; i++ {
+			if _, err := r.value(kv>>4, depth+1); err != nil {
+				return nil, err
+			}
+			if _, err := r.value(kv&0x0f, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case tStruct:
+		return r.readStruct(depth + 1)
+	}
+	return nil, fmt.Errorf("%w: unknown type %d", errThriftCorrupt, typ)
+}
+
+// tfield is a struct field to encode. Values are bool, int8 (byte), int16, int32,
+// int64, float64, []byte, string, tlist or []tfield for a nested struct; nil values
+// are left out.
+type tfield struct {
+	id    int16
+	value interface{}
+}
+
+// tlist is a list of elements of one type
+type tlist struct {
+	elem  byte
+	items []interface{}
+}
+
+// thriftWriter encodes into a growing buffer
+type thriftWriter struct {
+	buf []byte
+}
+
+func (w *thriftWriter) varint(v int64) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(v<<1)^uint64(v>>63))
+}
+
+func (w *thriftWriter) writeStruct(fields []tfield) {
+	var last int16
+	for _, f := range fields {
+		if f.value == nil {
+			continue
+		}
+		typ := typeOf(f.value)
+		if b, ok := f.value.(bool); ok && !b {
+			typ = tFalse
+		}
+		if delta := f.id - last; delta > 0 && delta <= 15 {
+			w.buf = append(w.buf, byte(delta)<<4|typ)
+		} else {
+			w.buf = append(w.buf, typ)
+			w.varint(int64(f.id))
+		}
+		last = f.id
+		if typ != tTrue && typ != tFalse {
+			w.value(f.value)
+		}
+	}
+	w.buf = append(w.buf, tStop)
+}
+
+func typeOf(v interface{}) byte {
+	switch v.(type) {
+	case bool:
+		return tTrue
+	case int8:
+		return tByte
+	case int16:
+		return tI16
+	case int32:
+		return tI32
+	case int64:
+		return tI64
+	case float64:
+		return tDouble
+	case []byte, string:
+		return tBinary
+	case tlist:
+		return tList
+	case []tfield:
+		return tStruct
+	}
+	panic(fmt.Sprintf("parquet: cannot encode %T", v))
+}
+
+func (w *thriftWriter) value(v interface{}) {
+	switch x := v.(type) {
+	case bool:
+		if x {
+			w.buf = append(w.buf, tTrue)
+		} else {
+			w.buf = append(w.buf, tFalse)
+		}
+	case int8:
+		w.buf = append(w.buf, byte(x))
+	case int16:
+		w.varint(int64(x))
+	case int32:
+		w.varint(int64(x))
+	case int64:
+		w.varint(x)
+	case float64:
+		w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(x))
+	case []byte:
+		w.buf = binary.AppendUvarint(w.buf, uint64(len(x)))
+		w.buf = append(w.buf, x...)
+	case string:
+		w.buf = binary.AppendUvarint(w.buf, uint64(len(x)))
+		w.buf = append(w.buf, x...)
+	case tlist:
+		if len(x.items) < 15 {
+			w.buf = append(w.buf, byte(len(x.items))<<4|x.elem)
+		} else {
+			w.buf = append(w.buf, 0xf0|x.elem)
+			w.buf = binary.AppendUvarint(w.buf, uint64(len(x.items)))
+		}
+		for _, item := range x.items {
+			w.value(item)
+		}
+	case []tfield:
+		w.writeStruct(x)
+	}
+}
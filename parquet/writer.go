@@ -0,0 +1,384 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+
+	"github.com/bajor/spark-go-core/compression"
+	"github.com/bajor/spark-go-core/schema"
+)
+
+const (
+	// DefaultRowGroupSize is the number of rows buffered per row group
+	DefaultRowGroupSize = 128 << 10
+	// DefaultPageSize is the size of plain encoded values after which a page is cut
+	DefaultPageSize = 1 << 20
+)
+
+// createdBy is recorded in the footer of written files
+const createdBy = "spark-go-core"
+
+// WriteOptions configures a Writer
+type WriteOptions struct {
+	// Compression is compression.None, compression.Snappy or compression.Gzip, defaults
+	// to Snappy
+	Compression string
+	// RowGroupSize caps the rows of a row group, defaults to DefaultRowGroupSize
+	RowGroupSize int
+	// PageSize is the target size of a data page in bytes, defaults to DefaultPageSize
+	PageSize int
+}
+
+// Writer writes records with a flat schema as a Parquet file. Long columns are
+// INT64, Double columns DOUBLE, String columns UTF8 byte arrays and Any columns JSON
+// byte arrays; nullable fields are OPTIONAL. Values are plain encoded in v1 data pages
+// with min/max statistics per column chunk.
+type Writer struct {
+	out     *countingWriter
+	schema  schema.Schema
+	options WriteOptions
+	codec   compression.Codec
+	codecID int32
+
+	columns [][]interface{}
+	rows    int
+	meta    fileMetaData
+	err     error
+	closed  bool
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewWriter starts a Parquet file on w; Close must be called to write the footer
+func NewWriter(w io.Writer, s schema.Schema, options WriteOptions) (*Writer, error) {
+	if len(s.Fields) == 0 {
+		return nil, errors.New("parquet: the schema has no fields")
+	}
+	if options.RowGroupSize <= 0 {
+		options.RowGroupSize = DefaultRowGroupSize
+	}
+	if options.PageSize <= 0 {
+		options.PageSize = DefaultPageSize
+	}
+	pw := &Writer{out: &countingWriter{w: w}, schema: s, options: options}
+	switch options.Compression {
+	case compression.None:
+		pw.codecID = codecUncompressed
+	case "", compression.Snappy:
+		pw.codecID = codecSnappy
+		pw.codec, _ = compression.Lookup(compression.Snappy)
+	case compression.Gzip:
+		pw.codecID = codecGzip
+		pw.codec, _ = compression.Lookup(compression.Gzip)
+	default:
+		return nil, fmt.Errorf("parquet: unsupported compression %q", options.Compression)
+	}
+
+	pw.meta = fileMetaData{Version: 1, CreatedBy: createdBy}
+	pw.meta.Schema = []schemaElement{{Type: -1, Converted: convertedNone, Name: "schema", NumChildren: int32(len(s.Fields))}}
+	seen := make(map[string]bool)
+	for _, f := range s.Fields {
+		if seen[f.Name] {
+			return nil, fmt.Errorf("parquet: duplicate column %q", f.Name)
+		}
+		seen[f.Name] = true
+		pw.meta.Schema = append(pw.meta.Schema, element(f))
+	}
+	pw.columns = make([][]interface{}, len(s.Fields))
+	if _, err := pw.out.Write(magic); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// element returns the schema element a field is written as
+func element(f schema.Field) schemaElement {
+	e := schemaElement{Name: f.Name, Converted: convertedNone, Repetition: repRequired}
+	if f.Nullable {
+		e.Repetition = repOptional
+	}
+	switch f.Type {
+	case schema.Bool:
+		e.Type = typeBoolean
+	case schema.Long:
+		e.Type = typeInt64
+	case schema.Double:
+		e.Type = typeDouble
+	case schema.Any:
+		e.Type, e.Converted, e.Logical = typeByteArray, convertedJSON, logicalJSON
+	default:
+		e.Type, e.Converted, e.Logical = typeByteArray, convertedUTF8, logicalString
+	}
+	return e
+}
+
+// Write buffers one map or struct record, writing a row group once enough are buffered
+func (w *Writer) Write(record interface{}) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return errors.New("parquet: write to a closed writer")
+	}
+	m, err := schema.Encode(record)
+	if err != nil {
+		return err
+	}
+	row := make([]interface{}, len(w.schema.Fields))
+	for i, f := range w.schema.Fields {
+		if v := m[f.Name]; v != nil {
+			if row[i], err = physicalValue(f, v); err != nil {
+				return fmt.Errorf("parquet: column %s: %w", f.Name, err)
+			}
+		}
+		// checked after physicalValue dereferences pointers, which may be nil
+		if row[i] == nil && !f.Nullable {
+			return fmt.Errorf("parquet: column %s is not nullable", f.Name)
+		}
+	}
+	for i, v := range row {
+		w.columns[i] = append(w.columns[i], v)
+	}
+	w.rows++
+	if w.rows >= w.options.RowGroupSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// physicalValue converts a record value to the Go type of its column's physical
+// type, or nil for a nil pointer
+func physicalValue(f schema.Field, v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	switch f.Type {
+	case schema.Bool:
+		if rv.Kind() == reflect.Bool {
+			return rv.Bool(), nil
+		}
+	case schema.Long:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if rv.Uint() <= math.MaxInt64 {
+				return int64(rv.Uint()), nil
+			}
+		case reflect.Float32, reflect.Float64:
+			if x := rv.Float(); x == math.Trunc(x) && math.Abs(x) < 1<<63 {
+				return int64(x), nil
+			}
+		}
+	case schema.Double:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return float64(rv.Uint()), nil
+		case reflect.Float32, reflect.Float64:
+			return rv.Float(), nil
+		}
+	case schema.Any:
+		return json.Marshal(v)
+	default:
+		switch x := rv.Interface().(type) {
+		case string:
+			return []byte(x), nil
+		case []byte:
+			return x, nil
+		}
+		return []byte(fmt.Sprint(rv.Interface())), nil
+	}
+	return nil, fmt.Errorf("cannot use %v (%T) as %s", v, v, f.Type)
+}
+
+// flush writes the buffered rows as a row group
+func (w *Writer) flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+	rg := rowGroup{NumRows: int64(w.rows)}
+	for i, f := range w.schema.Fields {
+		meta, err := w.writeColumnChunk(f, w.meta.Schema[i+1], w.columns[i])
+		if err != nil {
+			w.err = err
+			return err
+		}
+		rg.Columns = append(rg.Columns, meta)
+		rg.TotalByteSize += meta.TotalUncompressedSize
+		w.columns[i] = w.columns[i][:0]
+	}
+	w.meta.RowGroups = append(w.meta.RowGroups, rg)
+	w.meta.NumRows += int64(w.rows)
+	w.rows = 0
+	return nil
+}
+
+func (w *Writer) writeColumnChunk(f schema.Field, e schemaElement, values []interface{}) (columnMeta, error) {
+	meta := columnMeta{
+		Type:           e.Type,
+		Encodings:      []int32{encodingPlain, encodingRLE},
+		Path:           []string{f.Name},
+		Codec:          w.codecID,
+		NumValues:      int64(len(values)),
+		DataPageOffset: w.out.n,
+		Statistics:     &statistics{HasNullCount: true},
+	}
+	var min, max interface{}
+	var defs []int32
+	var plain []byte
+	var bools []bool
+
+	writePage := func() error {
+		var payload []byte
+		if f.Nullable {
+			levels := encodeLevels(defs, 1)
+			payload = binary.LittleEndian.AppendUint32(payload, uint32(len(levels)))
+			payload = append(payload, levels...)
+		}
+		if e.Type == typeBoolean {
+			payload = append(payload, packBooleans(bools)...)
+		} else {
+			payload = append(payload, plain...)
+		}
+		compressed := payload
+		if w.codec != nil {
+			var err error
+			if compressed, err = w.codec.Compress(payload); err != nil {
+				return err
+			}
+		}
+		header := encodeDataPageHeader(len(payload), len(compressed), len(defs))
+		if _, err := w.out.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.out.Write(compressed); err != nil {
+			return err
+		}
+		meta.TotalUncompressedSize += int64(len(header) + len(payload))
+		meta.TotalCompressedSize += int64(len(header) + len(compressed))
+		defs, plain, bools = defs[:0], plain[:0], bools[:0]
+		return nil
+	}
+
+	for _, v := range values {
+		if v == nil {
+			defs = append(defs, 0)
+			meta.Statistics.NullCount++
+		} else {
+			defs = append(defs, 1)
+			if e.Type == typeBoolean {
+				bools = append(bools, v.(bool))
+			} else {
+				plain = appendPlain(plain, e.Type, v)
+			}
+			if f, ok := v.(float64); ok && math.IsNaN(f) {
+				// NaN is unordered, so it stays out of the statistics
+			} else if min == nil {
+				min, max = v, v
+			} else if less(v, min) {
+				min = v
+			} else if less(max, v) {
+				max = v
+			}
+		}
+		if len(plain) >= w.options.PageSize || len(bools) >= 8*w.options.PageSize {
+			if err := writePage(); err != nil {
+				return meta, err
+			}
+		}
+	}
+	if len(defs) > 0 || meta.TotalCompressedSize == 0 {
+		if err := writePage(); err != nil {
+			return meta, err
+		}
+	}
+	if min != nil {
+		meta.Statistics.Min, meta.Statistics.Max = statisticBytes(min), statisticBytes(max)
+	}
+	return meta, nil
+}
+
+// less orders two values of one physical type
+func less(a, b interface{}) bool {
+	switch x := a.(type) {
+	case bool:
+		return !x && b.(bool)
+	case int64:
+		return x < b.(int64)
+	case float64:
+		return x < b.(float64)
+	case []byte:
+		return bytes.Compare(x, b.([]byte)) < 0
+	}
+	return false
+}
+
+func statisticBytes(v interface{}) []byte {
+	switch x := v.(type) {
+	case bool:
+		if x {
+			return []byte{1}
+		}
+		return []byte{0}
+	case int64:
+		return binary.LittleEndian.AppendUint64(nil, uint64(x))
+	case float64:
+		return binary.LittleEndian.AppendUint64(nil, math.Float64bits(x))
+	case []byte:
+		return x
+	}
+	return nil
+}
+
+// Close writes the remaining rows and the footer. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if err := w.flush(); err != nil {
+		return err
+	}
+	footer := w.meta.encode()
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, magic...)
+	if _, err := w.out.Write(footer); err != nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// WriteRecords writes records as a complete Parquet file
+func WriteRecords(out io.Writer, records []interface{}, s schema.Schema, options WriteOptions) error {
+	w, err := NewWriter(out, s, options)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
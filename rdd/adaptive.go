@@ -0,0 +1,166 @@
+package rdd
+
+import (
+	"sort"
+
+	"github.com/bajor/spark-go-core/scheduler"
+	"github.com/bajor/spark-go-core/stats"
+)
+
+// shuffleBlock is the part of a map task's output hashed to one bucket
+type shuffleBlock struct {
+	data []interface{}
+	// bytes is the estimated size of data
+	bytes int64
+}
+
+// shuffleOutput holds the blocks written by a shuffle map stage, indexed by map task
+// and bucket
+type shuffleOutput [][]shuffleBlock
+
+// newShuffleBlocks wraps the buckets of one map task, measuring their size
+func newShuffleBlocks(buckets [][]interface{}) []shuffleBlock {
+	blocks := make([]shuffleBlock, len(buckets))
+	for b, bucket := range buckets {
+		blocks[b].data = bucket
+		for _, v := range bucket {
+			blocks[b].bytes += stats.Size(v)
+		}
+	}
+	return blocks
+}
+
+// bucketBytes returns the size of every bucket over all map tasks
+func (o shuffleOutput) bucketBytes(numBuckets int) []int64 {
+	sizes := make([]int64, numBuckets)
+	for _, blocks := range o {
+		for b, block := range blocks {
+			sizes[b] += block.bytes
+		}
+	}
+	return sizes
+}
+
+// bytes returns the size of the whole output
+func (o shuffleOutput) bytes() int64 {
+	var n int64
+	for _, blocks := range o {
+		for _, block := range blocks {
+			n += block.bytes
+		}
+	}
+	return n
+}
+
+// buckets concatenates buckets from to to, each in map task order, as the reduce
+// partitions would hold them one after another
+func (o shuffleOutput) buckets(from, to int) []interface{} {
+	var out []interface{}
+	for b := from; b < to; b++ {
+		for _, blocks := range o {
+			out = append(out, blocks[b].data...)
+		}
+	}
+	return out
+}
+
+// mapBlocks concatenates bucket b of map tasks from to to
+func (o shuffleOutput) mapBlocks(b, from, to int) []interface{} {
+	var out []interface{}
+	for _, blocks := range o[from:to] {
+		out = append(out, blocks[b].data...)
+	}
+	return out
+}
+
+// all returns every element of the output, one map task after another
+func (o shuffleOutput) all() []interface{} {
+	var out []interface{}
+	for m := range o {
+		out = append(out, o.local(m)...)
+	}
+	return out
+}
+
+// local returns the output of one map task, as a reader on the node that wrote it
+// would see it without fetching other blocks
+func (o shuffleOutput) local(m int) []interface{} {
+	var out []interface{}
+	for _, block := range o[m] {
+		out = append(out, block.data...)
+	}
+	return out
+}
+
+// partitions returns one reduce partition per bucket
+func (o shuffleOutput) partitions(numBuckets int) [][]interface{} {
+	out := make([][]interface{}, numBuckets)
+	for b := range out {
+		out[b] = o.buckets(b, b+1)
+	}
+	return out
+}
+
+// bucketRange is a reduce partition of adjacent buckets from to to
+type bucketRange struct {
+	from, to int
+}
+
+// coalesce merges adjacent buckets into partitions of at most target bytes, keeping
+// a bucket larger than target alone
+func coalesce(sizes []int64, target int64) []bucketRange {
+	var ranges []bucketRange
+	var current int64
+	for b, size := range sizes {
+		if len(ranges) > 0 && current+size <= target {
+			ranges[len(ranges)-1].to = b + 1
+			current += size
+			continue
+		}
+		ranges = append(ranges, bucketRange{from: b, to: b + 1})
+		current = size
+	}
+	return ranges
+}
+
+// coalescedPartitions returns the reduce partitions of a shuffle, merging small
+// buckets unless adaptive execution is disabled. Callers only use it for shuffles
+// whose number of buckets came from a default or a planner, never one the user set.
+func coalescedPartitions(s *scheduler.Scheduler, out shuffleOutput, numBuckets int) [][]interface{} {
+	config := s.Config()
+	if config.DisableAdaptive {
+		return out.partitions(numBuckets)
+	}
+	ranges := coalesce(out.bucketBytes(numBuckets), config.AdvisoryPartitionBytes)
+	partitions := make([][]interface{}, len(ranges))
+	for i, r := range ranges {
+		partitions[i] = out.buckets(r.from, r.to)
+	}
+	return partitions
+}
+
+// skewed reports which buckets are larger than factor times the median bucket and
+// than threshold
+func skewed(sizes []int64, factor float64, threshold int64) []bool {
+	sorted := append([]int64(nil), sizes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var median int64
+	if len(sorted) > 0 {
+		median = sorted[len(sorted)/2]
+	}
+	out := make([]bool, len(sizes))
+	for b, size := range sizes {
+		out[b] = float64(size) > factor*float64(median) && size > threshold
+	}
+	return out
+}
+
+// splitMaps splits bucket b of a shuffle into ranges of map tasks whose blocks hold
+// at most target bytes, keeping a larger block alone
+func splitMaps(o shuffleOutput, b int, target int64) []bucketRange {
+	sizes := make([]int64, len(o))
+	for m, blocks := range o {
+		sizes[m] = blocks[b].bytes
+	}
+	return coalesce(sizes, target)
+}
@@ -0,0 +1,119 @@
+package rdd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/operations"
+	"github.com/bajor/spark-go-core/scheduler"
+	"github.com/bajor/spark-go-core/stats"
+)
+
+// Cache returns an RDD of the elements of r that keeps them in memory once first
+// evaluated, so that later evaluations skip r's operations. Statistics of the
+// elements are gathered by the tasks caching them, see Statistics.
+func (r *KeyedRDD) Cache() *KeyedRDD {
+	return FromSource(&cacheSource{parent: r})
+}
+
+// cacheSource holds the partitions of an evaluated RDD and their statistics
+type cacheSource struct {
+	parent *KeyedRDD
+
+	mu         sync.Mutex
+	cached     bool
+	partitions [][]interface{}
+	collectors []*stats.Collector
+}
+
+// NumPartitions returns the number of cached partitions, or the number the parent
+// is evaluated with before
+func (c *cacheSource) NumPartitions() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached {
+		return len(c.partitions)
+	}
+	return c.parent.numPartitions()
+}
+
+func (c *cacheSource) numPartitions() int {
+	return c.NumPartitions()
+}
+
+// Open evaluates the parent on the calling goroutine if it is not cached yet
+func (c *cacheSource) Open(partition int) (lazy.SourceIterator, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.cached {
+		data, err := c.parent.evaluate()
+		if err != nil {
+			return nil, err
+		}
+		c.partitions = operations.Split(data, c.parent.numPartitions())
+		c.collectors = make([]*stats.Collector, len(c.partitions))
+		for i, part := range c.partitions {
+			c.collectors[i] = stats.NewCollector()
+			for _, v := range part {
+				c.collectors[i].Add(v)
+			}
+		}
+		c.cached = true
+	}
+	if partition < 0 || partition >= len(c.partitions) {
+		return nil, fmt.Errorf("cached RDD has no partition %d", partition)
+	}
+	return &sliceSourceIterator{SliceIterator: lazy.NewSliceIterator(c.partitions[partition])}, nil
+}
+
+// inputs runs the parent's stages if it is not cached yet, gathering the statistics
+// of every partition in the task computing it
+func (c *cacheSource) inputs(ctx context.Context, s *scheduler.Scheduler) ([]input, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.cached {
+		inputs, pipeline, err := c.parent.plan(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		var mu sync.Mutex
+		collectors := make([]*stats.Collector, len(inputs))
+		gather := MapPartitionsOperation{f: func(ctx context.Context, part []interface{}) ([]interface{}, error) {
+			collector := stats.NewCollector()
+			for _, v := range part {
+				collector.Add(v)
+			}
+			if tc, ok := scheduler.TaskContextFrom(ctx); ok {
+				mu.Lock()
+				collectors[tc.Partition] = collector
+				mu.Unlock()
+			}
+			return part, nil
+		}}
+		partitions, err := runStage(ctx, s, "cache", inputs, append(pipeline, gather))
+		if err != nil {
+			return nil, err
+		}
+		c.partitions, c.collectors, c.cached = partitions, collectors, true
+	}
+	return sliceInputs(c.partitions), nil
+}
+
+// Statistics returns the statistics of the cached elements, once cached
+func (c *cacheSource) Statistics() (stats.Statistics, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.cached {
+		return stats.Statistics{}, false
+	}
+	all := stats.NewCollector()
+	for _, collector := range c.collectors {
+		if collector == nil {
+			return stats.Statistics{}, false
+		}
+		all.Merge(collector)
+	}
+	return all.Statistics(), true
+}
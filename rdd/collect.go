@@ -0,0 +1,251 @@
+package rdd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bajor/spark-go-core/operations"
+	"github.com/bajor/spark-go-core/scheduler"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// Collect evaluates the operation chain in parallel on the given scheduler.
+// Narrow operations are pipelined inside one stage per partition; ReduceByKey
+// hash-partitions the stage output by key and reduces each bucket in a new stage,
+// and Reduce gathers all partitions before applying its function. Unless the
+// scheduler disables adaptive execution or the user set the number of partitions,
+// the measured sizes of the shuffled buckets decide how many reduce tasks run, see
+// Config.AdvisoryPartitionBytes.
+func (r *KeyedRDD) Collect(ctx context.Context, s *scheduler.Scheduler) ([]interface{}, error) {
+	inputs, pipeline, err := r.plan(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	out, err := runStage(ctx, s, "result", inputs, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return flatten(out), nil
+}
+
+// plan runs every stage but the last and returns the inputs and narrow pipeline of
+// the final stage, which the action runs itself
+func (r *KeyedRDD) plan(ctx context.Context, s *scheduler.Scheduler) ([]input, []types.Operation, error) {
+	numPartitions := r.numPartitions()
+	inputs, err := r.inputs(ctx, s, numPartitions)
+	if err != nil {
+		return nil, nil, err
+	}
+	var pipeline []types.Operation
+	for _, op := range r.Chain.Operations {
+		switch o := op.(type) {
+		case types.NarrowOperation:
+			pipeline = append(pipeline, o)
+		case ReduceByKeyOperation:
+			n := numPartitions
+			if o.partitions > 0 {
+				n = o.partitions
+			}
+			shuffled, err := runShuffleMapStage(ctx, s, inputs, pipeline, o.keyFunc, n)
+			if err != nil {
+				return nil, nil, err
+			}
+			buckets := shuffled.partitions(n)
+			if o.advisory || (o.partitions == 0 && !r.fixedPartitions()) {
+				buckets = coalescedPartitions(s, shuffled, n)
+			}
+			partitions, err := runStage(ctx, s, "reduceByKey", sliceInputs(buckets), []types.Operation{o})
+			if err != nil {
+				return nil, nil, err
+			}
+			inputs = sliceInputs(partitions)
+			pipeline = nil
+		default:
+			out, err := runStage(ctx, s, "collect", inputs, pipeline)
+			if err != nil {
+				return nil, nil, err
+			}
+			result, err := op.Execute(flatten(out))
+			if err != nil {
+				return nil, nil, err
+			}
+			inputs = sliceInputs([][]interface{}{result})
+			pipeline = nil
+		}
+	}
+	return inputs, pipeline, nil
+}
+
+// input produces the data of one partition at the start of a stage
+type input struct {
+	data []interface{}
+	// read computes the partition inside the task of the stage reading it
+	read func(ctx context.Context) ([]interface{}, error)
+}
+
+// stageSource is a source whose partitions are computed by stages of other RDDs,
+// such as a union of RDDs, rather than read in the first stage's tasks
+type stageSource interface {
+	numPartitions() int
+	inputs(ctx context.Context, s *scheduler.Scheduler) ([]input, error)
+}
+
+// numPartitions returns the number of partitions the RDD is evaluated with: the set
+// number, else one per source partition, or as many as the stages of a union, join or
+// cache produce
+func (r *KeyedRDD) numPartitions() int {
+	n := r.Partitions
+	if n < 1 {
+		if u, ok := r.Source.(stageSource); ok {
+			n = u.numPartitions()
+		} else if r.Source != nil {
+			n = r.Source.NumPartitions()
+		}
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// fixedPartitions reports whether the user set the number of partitions with
+// Repartition. Like Spark, adaptive execution keeps such a number instead of
+// coalescing the shuffles using it.
+func (r *KeyedRDD) fixedPartitions() bool {
+	return r.Partitions > 0
+}
+
+// inputs returns the partitions of the RDD's input. Source partitions are read inside
+// the first stage's tasks, so each task only holds its own split; the partitions of
+// a union, join or cache are computed by the stages of their parents, which run first.
+func (r *KeyedRDD) inputs(ctx context.Context, s *scheduler.Scheduler, numPartitions int) ([]input, error) {
+	if r.Source == nil {
+		return sliceInputs(operations.Split(r.Data, numPartitions)), nil
+	}
+	if u, ok := r.Source.(stageSource); ok {
+		return u.inputs(ctx, s)
+	}
+	src := r.InputSource()
+	inputs := make([]input, src.NumPartitions())
+	for p := range inputs {
+		p := p
+		inputs[p] = input{read: func(context.Context) ([]interface{}, error) {
+			return ReadPartition(src, p)
+		}}
+	}
+	return inputs, nil
+}
+
+func sliceInputs(partitions [][]interface{}) []input {
+	inputs := make([]input, len(partitions))
+	for i, part := range partitions {
+		inputs[i] = input{data: part}
+	}
+	return inputs
+}
+
+// load returns the input's data, reading it if it comes from a source
+func (in input) load(ctx context.Context) ([]interface{}, error) {
+	if in.read != nil {
+		return in.read(ctx)
+	}
+	return in.data, nil
+}
+
+// materialized reports whether every input is already in memory
+func materialized(inputs []input) ([][]interface{}, bool) {
+	partitions := make([][]interface{}, len(inputs))
+	for i, in := range inputs {
+		if in.read != nil {
+			return nil, false
+		}
+		partitions[i] = in.data
+	}
+	return partitions, true
+}
+
+// runStage applies the pipeline to every partition as one scheduler stage
+func runStage(ctx context.Context, s *scheduler.Scheduler, name string, inputs []input, pipeline []types.Operation) ([][]interface{}, error) {
+	if len(pipeline) == 0 {
+		if partitions, ok := materialized(inputs); ok {
+			return partitions, nil
+		}
+	}
+	tasks := make([]scheduler.Task, len(inputs))
+	for i, in := range inputs {
+		in := in
+		tasks[i] = func(ctx context.Context) ([]interface{}, error) {
+			part, err := in.load(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return executePipeline(ctx, part, pipeline)
+		}
+	}
+	return s.RunStage(ctx, name, tasks)
+}
+
+// runShuffleMapStage runs the pipeline and splits each output into numBuckets hash
+// buckets, returning the blocks of every map task with their measured sizes. The
+// blocks stay in memory as Go values, so unlike cluster shuffle blocks they are
+// neither serialized nor compressed.
+func runShuffleMapStage(ctx context.Context, s *scheduler.Scheduler, inputs []input, pipeline []types.Operation, keyFunc func(interface{}) (interface{}, error), numBuckets int) (shuffleOutput, error) {
+	tasks := make([]scheduler.Task, len(inputs))
+	for i, in := range inputs {
+		in := in
+		tasks[i] = func(ctx context.Context) ([]interface{}, error) {
+			part, err := in.load(ctx)
+			if err != nil {
+				return nil, err
+			}
+			data, err := executePipeline(ctx, part, pipeline)
+			if err != nil {
+				return nil, err
+			}
+			buckets, err := operations.HashPartition(data, keyFunc, numBuckets)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{newShuffleBlocks(buckets)}, nil
+		}
+	}
+	results, err := s.RunStage(ctx, "shuffleMap", tasks)
+	if err != nil {
+		return nil, err
+	}
+	out := make(shuffleOutput, len(results))
+	for m, res := range results {
+		out[m] = res[0].([]shuffleBlock)
+	}
+	return out, nil
+}
+
+// executePipeline applies operations to a partition, stopping early if the attempt was cancelled
+func executePipeline(ctx context.Context, data []interface{}, pipeline []types.Operation) ([]interface{}, error) {
+	current := data
+	for _, op := range pipeline {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var result []interface{}
+		var err error
+		if c, ok := op.(types.ContextOperation); ok {
+			result, err = c.ExecuteContext(ctx, current)
+		} else {
+			result, err = op.Execute(current)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%T: %w", op, err)
+		}
+		current = result
+	}
+	return current, nil
+}
+
+func flatten(partitions [][]interface{}) []interface{} {
+	result := make([]interface{}, 0)
+	for _, part := range partitions {
+		result = append(result, part...)
+	}
+	return result
+}
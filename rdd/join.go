@@ -0,0 +1,284 @@
+package rdd
+
+import (
+	"context"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/scheduler"
+)
+
+// JoinStrategy selects how Join brings together the elements of two RDDs sharing
+// a key
+type JoinStrategy int
+
+const (
+	// ShuffleJoin hash-partitions both sides by key
+	ShuffleJoin JoinStrategy = iota
+	// BroadcastRight gathers the right side and joins all of it with every partition
+	// of the left side, which is not shuffled
+	BroadcastRight
+	// BroadcastLeft gathers the left side and joins all of it with every partition
+	// of the right side
+	BroadcastLeft
+)
+
+func (s JoinStrategy) String() string {
+	switch s {
+	case BroadcastRight:
+		return "broadcast right"
+	case BroadcastLeft:
+		return "broadcast left"
+	}
+	return "shuffle"
+}
+
+// Join returns an RDD of the elements joinFunc produces from the elements of r and
+// other, keyed by their key functions. joinFunc is called once per partition of the
+// result with elements of both sides, among which are all those sharing a key with
+// any of them; it groups them by key itself.
+//
+// ShuffleJoin hash-partitions both sides into partitions partitions, or the number
+// of partitions of r when partitions is zero. The broadcast strategies call joinFunc
+// with the whole gathered side for every partition of the other, so joinFunc must not
+// emit elements of the gathered side that match nothing, as in an outer join keeping
+// them.
+func (r *KeyedRDD) Join(other *KeyedRDD, strategy JoinStrategy, partitions int, joinFunc func(left, right []interface{}) ([]interface{}, error)) *KeyedRDD {
+	return r.JoinWithOptions(other, JoinOptions{Strategy: strategy, Partitions: partitions}, joinFunc)
+}
+
+// JoinOptions selects how JoinWithOptions executes a join
+type JoinOptions struct {
+	Strategy   JoinStrategy
+	Partitions int
+	// CanBroadcastLeft and CanBroadcastRight tell adaptive execution that joinFunc
+	// emits no elements of that side matching nothing, so that the side may be joined
+	// with more than one partition of the other: broadcast when its shuffle output
+	// turns out small, or replicated to join the chunks of a skewed partition of the
+	// other side
+	CanBroadcastLeft, CanBroadcastRight bool
+	// AdvisoryPartitions lets adaptive execution coalesce the partitions of a shuffle
+	// join although Partitions is set, as when a planner chose it from estimates. A
+	// number set by the caller or inherited from a repartitioned left side is kept
+	// otherwise.
+	AdvisoryPartitions bool
+}
+
+// JoinWithOptions is Join with the options adaptive execution needs to change the
+// strategy of a shuffle join at run time. Once the map stage of a side has run, a
+// side smaller than the scheduler's Config.BroadcastJoinBytes is broadcast if
+// allowed, small partitions are coalesced unless their number was set, see
+// AdvisoryPartitions, and skewed ones split into chunks joined with the whole
+// partition of the other side.
+func (r *KeyedRDD) JoinWithOptions(other *KeyedRDD, opts JoinOptions, joinFunc func(left, right []interface{}) ([]interface{}, error)) *KeyedRDD {
+	return FromSource(&joinSource{left: r, right: other, opts: opts, join: joinFunc})
+}
+
+// joinSource joins everything in one partition when an RDD is evaluated on the
+// calling goroutine; Collect runs the sides' stages instead, see inputs
+type joinSource struct {
+	left, right *KeyedRDD
+	opts        JoinOptions
+	join        func(left, right []interface{}) ([]interface{}, error)
+}
+
+func (j *joinSource) NumPartitions() int {
+	return 1
+}
+
+func (j *joinSource) Open(int) (lazy.SourceIterator, error) {
+	left, err := j.left.evaluate()
+	if err != nil {
+		return nil, err
+	}
+	right, err := j.right.evaluate()
+	if err != nil {
+		return nil, err
+	}
+	data, err := j.join(left, right)
+	if err != nil {
+		return nil, err
+	}
+	return &sliceSourceIterator{SliceIterator: lazy.NewSliceIterator(data)}, nil
+}
+
+// numPartitions returns the number of partitions of the join's result as planned;
+// adaptive execution may change it once the sides are shuffled
+func (j *joinSource) numPartitions() int {
+	switch j.opts.Strategy {
+	case BroadcastRight:
+		return j.left.numPartitions()
+	case BroadcastLeft:
+		return j.right.numPartitions()
+	}
+	if j.opts.Partitions > 0 {
+		return j.opts.Partitions
+	}
+	return j.left.numPartitions()
+}
+
+// inputs runs the stages of both sides and returns one input per partition of the
+// result, joining its elements in the task reading it
+func (j *joinSource) inputs(ctx context.Context, s *scheduler.Scheduler) ([]input, error) {
+	if j.opts.Strategy == BroadcastRight || j.opts.Strategy == BroadcastLeft {
+		gathered := j.right
+		if j.opts.Strategy == BroadcastLeft {
+			gathered = j.left
+		}
+		gatheredInputs, pipeline, err := gathered.plan(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		out, err := runStage(ctx, s, "broadcast", gatheredInputs, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		if j.opts.Strategy == BroadcastLeft {
+			return j.streamRight(ctx, s, flatten(out))
+		}
+		return j.streamLeft(ctx, s, flatten(out))
+	}
+
+	n := j.numPartitions()
+	adaptive := !s.Config().DisableAdaptive
+	rightInputs, rightPipeline, err := j.right.plan(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	right, err := runShuffleMapStage(ctx, s, rightInputs, rightPipeline, j.right.Key, n)
+	if err != nil {
+		return nil, err
+	}
+	if adaptive && j.opts.CanBroadcastRight && right.bytes() <= s.Config().BroadcastJoinBytes {
+		return j.streamLeft(ctx, s, right.all())
+	}
+	leftInputs, leftPipeline, err := j.left.plan(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	left, err := runShuffleMapStage(ctx, s, leftInputs, leftPipeline, j.left.Key, n)
+	if err != nil {
+		return nil, err
+	}
+	if !adaptive {
+		inputs := make([]input, n)
+		for b := range inputs {
+			inputs[b] = j.joined(left.buckets(b, b+1), right.buckets(b, b+1))
+		}
+		return inputs, nil
+	}
+	if j.opts.CanBroadcastLeft && left.bytes() <= s.Config().BroadcastJoinBytes {
+		// the right side is already shuffled; every task reads one map task's output
+		all := left.all()
+		inputs := make([]input, len(right))
+		for m := range inputs {
+			inputs[m] = j.joined(all, right.local(m))
+		}
+		return inputs, nil
+	}
+	return j.adaptiveShuffle(s.Config(), left, right, n), nil
+}
+
+// streamLeft joins all of the right side with every partition of the left side,
+// which is not shuffled
+func (j *joinSource) streamLeft(ctx context.Context, s *scheduler.Scheduler, right []interface{}) ([]input, error) {
+	return j.stream(ctx, s, j.left, func(part []interface{}) ([]interface{}, error) {
+		return j.join(part, right)
+	})
+}
+
+// streamRight joins all of the left side with every partition of the right side
+func (j *joinSource) streamRight(ctx context.Context, s *scheduler.Scheduler, left []interface{}) ([]input, error) {
+	return j.stream(ctx, s, j.right, func(part []interface{}) ([]interface{}, error) {
+		return j.join(left, part)
+	})
+}
+
+// stream returns one input per partition of a side, joining it in the task reading it
+func (j *joinSource) stream(ctx context.Context, s *scheduler.Scheduler, side *KeyedRDD, join func([]interface{}) ([]interface{}, error)) ([]input, error) {
+	sideInputs, pipeline, err := side.plan(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	inputs := make([]input, len(sideInputs))
+	for i, in := range sideInputs {
+		in := in
+		inputs[i] = input{read: func(ctx context.Context) ([]interface{}, error) {
+			part, err := in.load(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if part, err = executePipeline(ctx, part, pipeline); err != nil {
+				return nil, err
+			}
+			return join(part)
+		}}
+	}
+	return inputs, nil
+}
+
+// adaptiveShuffle returns the partitions of a shuffle join from the measured bucket
+// sizes: a bucket skewed on a side that may be split is joined in chunks of that
+// side's map outputs, each with the whole bucket of the other side, and adjacent
+// buckets that are not are coalesced unless the number of buckets was set
+func (j *joinSource) adaptiveShuffle(config scheduler.Config, left, right shuffleOutput, n int) []input {
+	leftSizes, rightSizes := left.bucketBytes(n), right.bucketBytes(n)
+	leftSkewed := skewed(leftSizes, config.SkewFactor, config.SkewThresholdBytes)
+	rightSkewed := skewed(rightSizes, config.SkewFactor, config.SkewThresholdBytes)
+	split := func(b int) bool {
+		return (leftSkewed[b] && j.opts.CanBroadcastRight) || (rightSkewed[b] && j.opts.CanBroadcastLeft)
+	}
+
+	var inputs []input
+	from := 0
+	flush := func(to int) {
+		if !j.advisory() {
+			for b := from; b < to; b++ {
+				inputs = append(inputs, j.joined(left.buckets(b, b+1), right.buckets(b, b+1)))
+			}
+			return
+		}
+		sizes := make([]int64, to-from)
+		for b := range sizes {
+			sizes[b] = leftSizes[from+b] + rightSizes[from+b]
+		}
+		for _, r := range coalesce(sizes, config.AdvisoryPartitionBytes) {
+			inputs = append(inputs, j.joined(left.buckets(from+r.from, from+r.to), right.buckets(from+r.from, from+r.to)))
+		}
+	}
+	for b := 0; b < n; b++ {
+		if !split(b) {
+			continue
+		}
+		flush(b)
+		from = b + 1
+		leftChunks := []bucketRange{{0, len(left)}}
+		if leftSkewed[b] && j.opts.CanBroadcastRight {
+			leftChunks = splitMaps(left, b, config.AdvisoryPartitionBytes)
+		}
+		rightChunks := []bucketRange{{0, len(right)}}
+		if rightSkewed[b] && j.opts.CanBroadcastLeft {
+			rightChunks = splitMaps(right, b, config.AdvisoryPartitionBytes)
+		}
+		for _, l := range leftChunks {
+			for _, r := range rightChunks {
+				inputs = append(inputs, j.joined(left.mapBlocks(b, l.from, l.to), right.mapBlocks(b, r.from, r.to)))
+			}
+		}
+	}
+	flush(n)
+	return inputs
+}
+
+// advisory reports whether adaptive execution may coalesce the join's shuffle
+// partitions, which it may not when the caller set their number or the left side was
+// repartitioned
+func (j *joinSource) advisory() bool {
+	return j.opts.AdvisoryPartitions || (j.opts.Partitions == 0 && !j.left.fixedPartitions())
+}
+
+// joined returns an input joining the given elements in the task reading it
+func (j *joinSource) joined(left, right []interface{}) input {
+	return input{read: func(context.Context) ([]interface{}, error) {
+		return j.join(left, right)
+	}}
+}
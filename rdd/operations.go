@@ -1,7 +1,13 @@
 package rdd
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+
 	"github.com/bajor/spark-go-core/operations"
+	"github.com/bajor/spark-go-core/registry"
+	"github.com/bajor/spark-go-core/types"
 )
 
 // MapOperation represents a map transformation
@@ -9,6 +15,8 @@ type MapOperation struct {
 	f func(interface{}) (interface{}, error)
 }
 
+func (m MapOperation) Narrow() {}
+
 func (m MapOperation) Execute(data []interface{}) ([]interface{}, error) {
 	return operations.Map(data, m.f)
 }
@@ -18,10 +26,27 @@ type FilterOperation struct {
 	f func(interface{}) bool
 }
 
+func (f FilterOperation) Narrow() {}
+
 func (f FilterOperation) Execute(data []interface{}) ([]interface{}, error) {
 	return operations.Filter(data, f.f), nil
 }
 
+// MapPartitionsOperation transforms a whole partition at once
+type MapPartitionsOperation struct {
+	f func(context.Context, []interface{}) ([]interface{}, error)
+}
+
+func (m MapPartitionsOperation) Narrow() {}
+
+func (m MapPartitionsOperation) Execute(data []interface{}) ([]interface{}, error) {
+	return m.f(context.Background(), data)
+}
+
+func (m MapPartitionsOperation) ExecuteContext(ctx context.Context, data []interface{}) ([]interface{}, error) {
+	return m.f(ctx, data)
+}
+
 // ReduceOperation represents a reduce transformation
 type ReduceOperation struct {
 	f func([]interface{}) ([]interface{}, error)
@@ -35,8 +60,106 @@ func (r ReduceOperation) Execute(data []interface{}) ([]interface{}, error) {
 type ReduceByKeyOperation struct {
 	keyFunc    func(interface{}) (interface{}, error)
 	reduceFunc func([]interface{}) ([]interface{}, error)
+	// partitions is the number of reduce partitions; the RDD's number when zero
+	partitions int
+	// advisory lets adaptive execution coalesce partitions set explicitly
+	advisory bool
 }
 
 func (r ReduceByKeyOperation) Execute(data []interface{}) ([]interface{}, error) {
 	return operations.ReduceByKey(data, r.keyFunc, r.reduceFunc)
-} 
\ No newline at end of file
+}
+
+// NamedOperation references an operation from the registry by name and arguments
+type NamedOperation struct {
+	spec types.OperationSpec
+	op   types.Operation
+	err  error
+}
+
+// newNamedOperation builds a registered operation, which must be of one of the kinds
+// given; method names the RDD method in the error otherwise
+func newNamedOperation(method, name string, args interface{}, kinds ...registry.Kind) NamedOperation {
+	n := NamedOperation{spec: types.OperationSpec{Name: name}}
+	if args != nil {
+		n.spec.Args, n.err = json.Marshal(args)
+	}
+	if n.err == nil {
+		n.op, n.err = registry.Build(n.spec)
+	}
+	if n.err == nil {
+		kind, _ := registry.KindOf(name)
+		n.err = fmt.Errorf("%s: operation %q is a %s operation", method, name, kind)
+		for _, k := range kinds {
+			if k == kind {
+				n.err = nil
+			}
+		}
+	}
+	return n
+}
+
+func (n NamedOperation) Narrow() {}
+
+func (n NamedOperation) Spec() types.OperationSpec {
+	return n.spec
+}
+
+// Err returns the error building the operation, also returned when it executes
+func (n NamedOperation) Err() error {
+	return n.err
+}
+
+func (n NamedOperation) Execute(data []interface{}) ([]interface{}, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	return n.op.Execute(data)
+}
+
+func (n NamedOperation) ExecuteContext(ctx context.Context, data []interface{}) ([]interface{}, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	if op, ok := n.op.(types.ContextOperation); ok {
+		return op.ExecuteContext(ctx, data)
+	}
+	return n.op.Execute(data)
+}
+
+// NamedReduceByKeyOperation groups by a registered key operation and reduces each group
+// with a registered partition operation, so it can run as a shuffle on remote workers
+type NamedReduceByKeyOperation struct {
+	key    types.OperationSpec
+	reduce types.OperationSpec
+	err    error
+}
+
+func newNamedReduceByKeyOperation(keyName string, keyArgs interface{}, reduceName string, reduceArgs interface{}) NamedReduceByKeyOperation {
+	n := NamedReduceByKeyOperation{
+		key:    types.OperationSpec{Name: keyName},
+		reduce: types.OperationSpec{Name: reduceName},
+	}
+	if keyArgs != nil && n.err == nil {
+		n.key.Args, n.err = json.Marshal(keyArgs)
+	}
+	if reduceArgs != nil && n.err == nil {
+		n.reduce.Args, n.err = json.Marshal(reduceArgs)
+	}
+	return n
+}
+
+func (n NamedReduceByKeyOperation) Spec() types.OperationSpec {
+	return n.reduce
+}
+
+func (n NamedReduceByKeyOperation) ShuffleSpecs() (types.OperationSpec, types.OperationSpec) {
+	return n.key, n.reduce
+}
+
+func (n NamedReduceByKeyOperation) Execute(data []interface{}) ([]interface{}, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	return registry.ReduceByKey(data, n.key, n.reduce)
+}
@@ -1,6 +1,13 @@
 package rdd
 
 import (
+	"context"
+
+	"github.com/bajor/spark-go-core/expr"
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/operations"
+	"github.com/bajor/spark-go-core/registry"
+	"github.com/bajor/spark-go-core/stats"
 	"github.com/bajor/spark-go-core/types"
 )
 
@@ -20,76 +27,237 @@ func NewKeyedRDD(data []interface{}, key func(i interface{}) (interface{}, error
 	}
 }
 
-// Map applies a transformation function to each element
-func (r *KeyedRDD) Map(f func(i interface{}) (interface{}, error)) *KeyedRDD {
-	newChain := &types.OperationChain{Operations: make([]types.Operation, len(r.Chain.Operations))}
-	copy(newChain.Operations, r.Chain.Operations)
-	newChain.Operations = append(newChain.Operations, MapOperation{f: f})
-	
+// FromSource creates an RDD reading its partitions lazily from src, with every
+// element being its own key
+func FromSource(src types.Source) *KeyedRDD {
 	return &KeyedRDD{
 		KeyedRDD: &types.KeyedRDD{
-			Data:  r.Data,
-			Chain: newChain,
-			Key:   r.Key,
+			Source: src,
+			Chain:  &types.OperationChain{Operations: make([]types.Operation, 0)},
+			Key:    func(i interface{}) (interface{}, error) { return i, nil },
 		},
 	}
 }
 
-// Filter keeps only elements that match the predicate
-func (r *KeyedRDD) Filter(f func(i interface{}) bool) *KeyedRDD {
+// PredicateSource is implemented by sources that can skip input, e.g. whole row groups
+// of a Parquet file, which cannot satisfy filter expressions
+type PredicateSource interface {
+	types.Source
+	WithPredicates(predicates []expr.Expr) types.Source
+}
+
+// ColumnSource is implemented by sources of records that can skip reading columns,
+// e.g. the column chunks of a Parquet file
+type ColumnSource interface {
+	types.Source
+	WithColumns(columns []string) (types.Source, error)
+}
+
+// StatisticsSource is implemented by sources knowing statistics of their records,
+// e.g. from file metadata or gathered while their partitions were read
+type StatisticsSource interface {
+	types.Source
+	Statistics() (stats.Statistics, bool)
+}
+
+// Statistics returns the statistics of the RDD's elements. They are known for RDDs
+// reading a StatisticsSource, such as a cached RDD once evaluated, without further
+// operations.
+func (r *KeyedRDD) Statistics() (stats.Statistics, bool) {
+	src, ok := r.Source.(StatisticsSource)
+	if !ok || len(r.Chain.Operations) > 0 {
+		return stats.Statistics{}, false
+	}
+	return src.Statistics()
+}
+
+// InputSource returns the RDD's source. When the source is a PredicateSource, the
+// predicates of the FilterExpr calls at the start of the chain are pushed into it;
+// the filters still run, so the source may return rows that do not match.
+func (r *KeyedRDD) InputSource() types.Source {
+	ps, ok := r.Source.(PredicateSource)
+	if !ok {
+		return r.Source
+	}
+	var predicates []expr.Expr
+	for _, op := range r.Chain.Operations {
+		f, ok := op.(expr.FilterOperation)
+		if !ok {
+			break
+		}
+		predicates = append(predicates, f.Predicate)
+	}
+	if len(predicates) == 0 {
+		return r.Source
+	}
+	return ps.WithPredicates(predicates)
+}
+
+// withOperation returns a new RDD whose chain is this RDD's chain followed by op
+func (r *KeyedRDD) withOperation(op types.Operation) *KeyedRDD {
 	newChain := &types.OperationChain{Operations: make([]types.Operation, len(r.Chain.Operations))}
 	copy(newChain.Operations, r.Chain.Operations)
-	newChain.Operations = append(newChain.Operations, FilterOperation{f: f})
-	
+	newChain.Operations = append(newChain.Operations, op)
+
 	return &KeyedRDD{
 		KeyedRDD: &types.KeyedRDD{
-			Data:  r.Data,
-			Chain: newChain,
-			Key:   r.Key,
+			Data:       r.Data,
+			Source:     r.Source,
+			Chain:      newChain,
+			Key:        r.Key,
+			Partitions: r.Partitions,
 		},
 	}
 }
 
+// Map applies a transformation function to each element. f gets no task context;
+// use MapContext to add to accumulators from it.
+func (r *KeyedRDD) Map(f func(i interface{}) (interface{}, error)) *KeyedRDD {
+	return r.withOperation(MapOperation{f: f})
+}
+
+// Filter keeps only elements that match the predicate
+func (r *KeyedRDD) Filter(f func(i interface{}) bool) *KeyedRDD {
+	return r.withOperation(FilterOperation{f: f})
+}
+
+// MapContext is Map with the context of the task, through which f adds to
+// accumulators so that the additions are merged once per successful task
+func (r *KeyedRDD) MapContext(f func(ctx context.Context, i interface{}) (interface{}, error)) *KeyedRDD {
+	return r.withOperation(MapPartitionsOperation{f: func(ctx context.Context, part []interface{}) ([]interface{}, error) {
+		return operations.Map(part, func(i interface{}) (interface{}, error) {
+			return f(ctx, i)
+		})
+	}})
+}
+
+// FilterContext is Filter with the context of the task, see MapContext
+func (r *KeyedRDD) FilterContext(f func(ctx context.Context, i interface{}) bool) *KeyedRDD {
+	return r.withOperation(MapPartitionsOperation{f: func(ctx context.Context, part []interface{}) ([]interface{}, error) {
+		return operations.Filter(part, func(i interface{}) bool {
+			return f(ctx, i)
+		}), nil
+	}})
+}
+
+// MapPartitions applies f to every partition as a whole, e.g. to set up a costly
+// resource once per partition instead of once per element
+func (r *KeyedRDD) MapPartitions(f func(ctx context.Context, part []interface{}) ([]interface{}, error)) *KeyedRDD {
+	return r.withOperation(MapPartitionsOperation{f: f})
+}
+
 // ReduceByKey groups elements by key and applies a reduce function to each group
 func (r *KeyedRDD) ReduceByKey(f func(a []interface{}) ([]interface{}, error)) *KeyedRDD {
-	newChain := &types.OperationChain{Operations: make([]types.Operation, len(r.Chain.Operations))}
-	copy(newChain.Operations, r.Chain.Operations)
-	newChain.Operations = append(newChain.Operations, ReduceByKeyOperation{
+	return r.withOperation(ReduceByKeyOperation{
+		keyFunc:    r.Key,
+		reduceFunc: f,
+	})
+}
+
+// ReduceByKeyN is ReduceByKey shuffling the elements into n partitions instead of
+// the number of partitions of the RDD, unless n is 0. Adaptive execution keeps n.
+func (r *KeyedRDD) ReduceByKeyN(f func(a []interface{}) ([]interface{}, error), n int) *KeyedRDD {
+	return r.withOperation(ReduceByKeyOperation{
 		keyFunc:    r.Key,
 		reduceFunc: f,
+		partitions: n,
 	})
-	
+}
+
+// ReduceByKeyAdvisory is ReduceByKeyN for planners choosing n from estimates:
+// adaptive execution may coalesce the n partitions to the measured shuffle sizes,
+// which it never does to a number set by ReduceByKeyN or Repartition
+func (r *KeyedRDD) ReduceByKeyAdvisory(f func(a []interface{}) ([]interface{}, error), n int) *KeyedRDD {
+	return r.withOperation(ReduceByKeyOperation{
+		keyFunc:    r.Key,
+		reduceFunc: f,
+		partitions: n,
+		advisory:   true,
+	})
+}
+
+// Reduce applies a function to combine all elements into a single result
+func (r *KeyedRDD) Reduce(f func(a []interface{}) ([]interface{}, error)) *KeyedRDD {
+	return r.withOperation(ReduceOperation{f: f})
+}
+
+// MapNamed applies a map or partition operation registered in the registry package.
+// Unlike Map, the resulting chain can be shipped to remote workers. A filter
+// operation is refused: evaluating the RDD or submitting it fails with an error.
+func (r *KeyedRDD) MapNamed(name string, args interface{}) *KeyedRDD {
+	return r.withOperation(newNamedOperation("MapNamed", name, args, registry.MapKind, registry.PartitionKind))
+}
+
+// FilterNamed applies a filter operation registered in the registry package. Other
+// kinds of operations are refused like in MapNamed.
+func (r *KeyedRDD) FilterNamed(name string, args interface{}) *KeyedRDD {
+	return r.withOperation(newNamedOperation("FilterNamed", name, args, registry.FilterKind))
+}
+
+// ReduceByKeyNamed groups elements by a registered key operation and reduces each group
+// with a registered partition operation. Unlike ReduceByKey it can run as a distributed shuffle.
+func (r *KeyedRDD) ReduceByKeyNamed(keyName string, keyArgs interface{}, reduceName string, reduceArgs interface{}) *KeyedRDD {
+	return r.withOperation(newNamedReduceByKeyOperation(keyName, keyArgs, reduceName, reduceArgs))
+}
+
+// MapExpr replaces every element with the value of an expression
+func (r *KeyedRDD) MapExpr(e expr.Expr) *KeyedRDD {
+	return r.withOperation(expr.MapOperation{Expr: e})
+}
+
+// FilterExpr keeps elements for which the predicate expression is true
+func (r *KeyedRDD) FilterExpr(predicate expr.Expr) *KeyedRDD {
+	return r.withOperation(expr.FilterOperation{Predicate: predicate})
+}
+
+// Select projects every element into a map holding one entry per expression
+func (r *KeyedRDD) Select(exprs ...expr.Expr) *KeyedRDD {
+	return r.withOperation(expr.ProjectOperation{Exprs: exprs})
+}
+
+// WithKey returns the RDD with a new key function, used by the ReduceByKey calls that
+// follow
+func (r *KeyedRDD) WithKey(key func(i interface{}) (interface{}, error)) *KeyedRDD {
 	return &KeyedRDD{
 		KeyedRDD: &types.KeyedRDD{
-			Data:  r.Data,
-			Chain: newChain,
-			Key:   r.Key,
+			Data:       r.Data,
+			Source:     r.Source,
+			Chain:      r.Chain,
+			Key:        key,
+			Partitions: r.Partitions,
 		},
 	}
 }
 
-// Reduce applies a function to combine all elements into a single result
-func (r *KeyedRDD) Reduce(f func(a []interface{}) ([]interface{}, error)) *KeyedRDD {
-	newChain := &types.OperationChain{Operations: make([]types.Operation, len(r.Chain.Operations))}
-	copy(newChain.Operations, r.Chain.Operations)
-	newChain.Operations = append(newChain.Operations, ReduceOperation{f: f})
-	
+// Repartition sets the number of partitions used when the RDD is evaluated in parallel.
+// An RDD read from a source keeps one input partition per source partition and uses n
+// for its shuffles, which adaptive execution does not coalesce.
+func (r *KeyedRDD) Repartition(n int) *KeyedRDD {
 	return &KeyedRDD{
 		KeyedRDD: &types.KeyedRDD{
-			Data:  r.Data,
-			Chain: newChain,
-			Key:   r.Key,
+			Data:       r.Data,
+			Source:     r.Source,
+			Chain:      r.Chain,
+			Key:        r.Key,
+			Partitions: n,
 		},
 	}
 }
 
 // GetData evaluates the lazy operation chain and returns the result
 func (r *KeyedRDD) GetData() []interface{} {
+	currentData := r.Data
+	if r.Source != nil {
+		data, err := readSource(r.InputSource())
+		if err != nil {
+			panic(err)
+		}
+		currentData = data
+	}
 	if len(r.Chain.Operations) == 0 {
-		return r.Data
+		return currentData
 	}
 
-	currentData := r.Data
 	for _, op := range r.Chain.Operations {
 		result, err := op.Execute(currentData)
 		if err != nil {
@@ -98,4 +266,26 @@ func (r *KeyedRDD) GetData() []interface{} {
 		currentData = result
 	}
 	return currentData
-} 
\ No newline at end of file
+}
+
+// readSource reads every partition of a source in order
+func readSource(src types.Source) ([]interface{}, error) {
+	data := make([]interface{}, 0)
+	for p := 0; p < src.NumPartitions(); p++ {
+		part, err := ReadPartition(src, p)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, part...)
+	}
+	return data, nil
+}
+
+// ReadPartition reads one partition of a source into memory
+func ReadPartition(src types.Source, partition int) ([]interface{}, error) {
+	it, err := src.Open(partition)
+	if err != nil {
+		return nil, err
+	}
+	return lazy.Drain(it)
+}
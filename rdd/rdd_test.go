@@ -1,8 +1,26 @@
 package rdd
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/bajor/spark-go-core/accumulator"
+	"github.com/bajor/spark-go-core/broadcast"
+	"github.com/bajor/spark-go-core/expr"
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/registry"
+	"github.com/bajor/spark-go-core/scheduler"
+	"github.com/bajor/spark-go-core/sink"
+	"github.com/bajor/spark-go-core/storage"
+	"github.com/bajor/spark-go-core/types"
 )
 
 func TestRDD_LazyEvaluation(t *testing.T) {
@@ -240,4 +258,838 @@ func TestRDD_IntegrationWithOperations(t *testing.T) {
 	if !reflect.DeepEqual(result, expected) {
 		t.Errorf("RDD integration failed: got %v, want %v", result, expected)
 	}
-} 
\ No newline at end of file
+} 
+
+func TestRDD_CollectInParallel(t *testing.T) {
+	rdd := NewKeyedRDD([]interface{}{1, 2, 3, 4, 5, 6, 7, 8}, func(i interface{}) (interface{}, error) {
+		return i.(int) % 2, nil
+	}).Repartition(4)
+
+	rdd = rdd.Map(func(i interface{}) (interface{}, error) {
+		return i.(int) * 2, nil
+	}).Filter(func(i interface{}) bool {
+		return i.(int) > 4
+	})
+
+	result, err := rdd.Collect(context.Background(), scheduler.New(scheduler.Config{Parallelism: 2}))
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+
+	expected := rdd.GetData()
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Parallel collect failed: got %v, want %v", result, expected)
+	}
+}
+
+func TestRDD_CollectReduceByKeyInParallel(t *testing.T) {
+	rdd := NewKeyedRDD([]interface{}{1, 2, 3, 4, 5, 6, 7}, func(i interface{}) (interface{}, error) {
+		return i.(int) % 3, nil
+	}).Repartition(3)
+
+	rdd = rdd.ReduceByKey(func(a []interface{}) ([]interface{}, error) {
+		sum := 0
+		for _, v := range a {
+			sum += v.(int)
+		}
+		return []interface{}{sum}, nil
+	}).Reduce(func(a []interface{}) ([]interface{}, error) {
+		sorted := make([]int, 0, len(a))
+		for _, v := range a {
+			sorted = append(sorted, v.(int))
+		}
+		sort.Ints(sorted)
+		out := make([]interface{}, len(sorted))
+		for i, v := range sorted {
+			out[i] = v
+		}
+		return out, nil
+	})
+
+	s := scheduler.New(scheduler.Config{})
+	result, err := rdd.Collect(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+
+	expected := []interface{}{9, 12, 7} // keys 0: 3+6, 1: 1+4+7, 2: 2+5
+	sort.Slice(expected, func(i, j int) bool { return expected[i].(int) < expected[j].(int) })
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Parallel ReduceByKey failed: got %v, want %v", result, expected)
+	}
+
+	if stages := s.Stages(); len(stages) != 2 {
+		t.Errorf("Wrong number of stages: got %d, want 2", len(stages))
+	}
+}
+
+func TestRDD_MapNamed(t *testing.T) {
+	registry.RegisterMapFunc("rdd.test.increment", func(args struct{ By int }, in int) (int, error) {
+		return in + args.By, nil
+	})
+
+	rdd := NewKeyedRDD([]interface{}{1, 2, 3}, func(i interface{}) (interface{}, error) {
+		return i, nil
+	}).MapNamed("rdd.test.increment", struct{ By int }{By: 10})
+
+	result := rdd.GetData()
+	expected := []interface{}{11, 12, 13}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("MapNamed failed: got %v, want %v", result, expected)
+	}
+
+	if _, ok := rdd.Chain.Operations[0].(types.ShippableOperation); !ok {
+		t.Errorf("Named operation is not shippable")
+	}
+}
+
+func TestRDD_NamedOperationKinds(t *testing.T) {
+	registry.RegisterMapFunc("rdd.test.double", func(_ struct{}, in int) (int, error) {
+		return in * 2, nil
+	})
+	registry.RegisterFilterFunc("rdd.test.positive", func(_ struct{}, in int) bool {
+		return in > 0
+	})
+	data := []interface{}{-1, 2}
+
+	ok, err := NewKeyedRDD(data, nil).FilterNamed("rdd.test.positive", nil).MapNamed("rdd.test.double", nil).
+		Collect(context.Background(), scheduler.New(scheduler.Config{}))
+	if err != nil || !reflect.DeepEqual(ok, []interface{}{4}) {
+		t.Errorf("Named operations of the right kinds: got %v, %v, want [4]", ok, err)
+	}
+
+	tests := []struct {
+		name string
+		r    *KeyedRDD
+		want string
+	}{
+		{"FilterNamedMap", NewKeyedRDD(data, nil).FilterNamed("rdd.test.double", nil), `FilterNamed: operation "rdd.test.double" is a map operation`},
+		{"MapNamedFilter", NewKeyedRDD(data, nil).MapNamed("rdd.test.positive", nil), `MapNamed: operation "rdd.test.positive" is a filter operation`},
+	}
+	for _, tt := range tests {
+		if _, err := tt.r.Collect(context.Background(), scheduler.New(scheduler.Config{})); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+		// the error also keeps the chain from being shipped to workers
+		if _, err := registry.Specs(tt.r.Chain.Operations); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s specs: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestRDD_ExpressionOperations(t *testing.T) {
+	rdd := NewKeyedRDD([]interface{}{1, 2, 3, 4, 5, 6}, func(i interface{}) (interface{}, error) {
+		return i, nil
+	})
+
+	rdd = rdd.MapExpr(expr.MustParse("_ * 2")).FilterExpr(expr.MustParse("_ > 4"))
+
+	result := rdd.GetData()
+	expected := []interface{}{6, 8, 10, 12}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expression operations failed: got %v, want %v", result, expected)
+	}
+}
+
+func TestRDD_MapPartitionsWithBroadcast(t *testing.T) {
+	manager := broadcast.NewManager(storage.NewBlockManager(), broadcast.Config{})
+	names, err := manager.New(map[int]string{1: "one", 2: "two", 3: "three"})
+	if err != nil {
+		t.Fatalf("Broadcast failed with error: %v", err)
+	}
+	defer names.Destroy()
+
+	rdd := NewKeyedRDD([]interface{}{1, 2, 3}, func(i interface{}) (interface{}, error) { return i, nil }).
+		Repartition(2).
+		MapPartitions(func(ctx context.Context, part []interface{}) ([]interface{}, error) {
+			// one lookup of the broadcast per partition instead of per element
+			table, err := names.Value()
+			if err != nil {
+				return nil, err
+			}
+			out := make([]interface{}, len(part))
+			for i, v := range part {
+				out[i] = table.(map[int]string)[v.(int)]
+			}
+			return out, nil
+		}).
+		Filter(func(i interface{}) bool { return i != "two" })
+
+	result, err := rdd.Collect(context.Background(), scheduler.New(scheduler.Config{Parallelism: 2}))
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	if expected := []interface{}{"one", "three"}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("MapPartitions failed: got %v, want %v", result, expected)
+	}
+	if !reflect.DeepEqual(rdd.GetData(), result) {
+		t.Errorf("GetData and Collect disagree: %v vs %v", rdd.GetData(), result)
+	}
+}
+
+func TestRDD_CollectWithAccumulator(t *testing.T) {
+	odd := accumulator.Long("odd")
+	data := make([]interface{}, 100)
+	for i := range data {
+		data[i] = i
+	}
+	rdd := NewKeyedRDD(data, func(i interface{}) (interface{}, error) {
+		return i, nil
+	}).Repartition(8).MapPartitions(func(ctx context.Context, part []interface{}) ([]interface{}, error) {
+		for _, v := range part {
+			if v.(int)%2 == 1 {
+				odd.Add(ctx, 1)
+			}
+		}
+		return part, nil
+	})
+
+	if _, err := rdd.Collect(context.Background(), scheduler.New(scheduler.Config{Parallelism: 4})); err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	if odd.Value() != 50 {
+		t.Errorf("Accumulator after parallel collect: got %d, want 50", odd.Value())
+	}
+}
+
+func TestRDD_MapContextAccumulatorUnderSpeculation(t *testing.T) {
+	seen := accumulator.Long("seen")
+	kept := accumulator.Long("kept")
+	data := make([]interface{}, 40)
+	for i := range data {
+		data[i] = i
+	}
+	release := make(chan struct{})
+	rdd := NewKeyedRDD(data, func(i interface{}) (interface{}, error) {
+		return i, nil
+	}).Repartition(4).MapContext(func(ctx context.Context, i interface{}) (interface{}, error) {
+		seen.Add(ctx, 1)
+		// the first attempt of partition 0 counts its first element, then straggles
+		if tc, _ := scheduler.TaskContextFrom(ctx); tc.Partition == 0 && tc.Attempt == 0 {
+			<-release
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+		return i, nil
+	}).FilterContext(func(ctx context.Context, i interface{}) bool {
+		kept.Add(ctx, 1)
+		return i.(int)%2 == 0
+	})
+
+	s := scheduler.New(scheduler.Config{
+		Parallelism:           8,
+		Speculation:           true,
+		SpeculationMultiplier: 2,
+		SpeculationQuantile:   0.5,
+		SpeculationInterval:   5 * time.Millisecond,
+	})
+	got, err := rdd.Collect(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	if len(got) != 20 {
+		t.Errorf("FilterContext kept %d elements, want 20", len(got))
+	}
+	if stages := s.Stages(); stages[len(stages)-1].SpeculativeWins != 1 {
+		t.Errorf("Expected the speculative attempt to win, got %+v", stages[len(stages)-1])
+	}
+	if seen.Value() != 40 || kept.Value() != 40 {
+		t.Errorf("Accumulators under speculation: got %d mapped and %d filtered, want 40 each", seen.Value(), kept.Value())
+	}
+}
+
+// countingSource serves fixed partitions and counts how often each is opened
+type countingSource struct {
+	parts  [][]interface{}
+	opened []int32
+}
+
+func (s *countingSource) NumPartitions() int { return len(s.parts) }
+
+func (s *countingSource) Open(p int) (lazy.SourceIterator, error) {
+	atomic.AddInt32(&s.opened[p], 1)
+	return &sliceSource{SliceIterator: lazy.NewSliceIterator(s.parts[p])}, nil
+}
+
+type sliceSource struct {
+	*lazy.SliceIterator
+}
+
+func (sliceSource) Err() error   { return nil }
+func (sliceSource) Close() error { return nil }
+
+func TestRDD_CollectFromSource(t *testing.T) {
+	src := &countingSource{parts: [][]interface{}{{1, 2, 3}, {4, 5}, {6}}, opened: make([]int32, 3)}
+	rdd := FromSource(src).
+		Filter(func(i interface{}) bool { return i.(int) != 5 }).
+		Map(func(i interface{}) (interface{}, error) { return i.(int) * 10, nil })
+
+	result, err := rdd.Collect(context.Background(), scheduler.New(scheduler.Config{Parallelism: 2}))
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	if expected := []interface{}{10, 20, 30, 40, 60}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("Collect from source failed: got %v, want %v", result, expected)
+	}
+	for p, n := range src.opened {
+		if n != 1 {
+			t.Errorf("Partition %d was opened %d times, want once", p, n)
+		}
+	}
+	if !reflect.DeepEqual(rdd.GetData(), result) {
+		t.Errorf("GetData and Collect disagree: %v vs %v", rdd.GetData(), result)
+	}
+
+	counts, err := FromSource(src).Repartition(2).ReduceByKey(func(group []interface{}) ([]interface{}, error) {
+		return []interface{}{len(group)}, nil
+	}).Collect(context.Background(), scheduler.New(scheduler.Config{Parallelism: 2}))
+	if err != nil {
+		t.Fatalf("ReduceByKey over a source failed with error: %v", err)
+	}
+	if len(counts) != 6 {
+		t.Errorf("Expected one group per element, got %v", counts)
+	}
+}
+
+// predicateSource records the predicates pushed into it
+type predicateSource struct {
+	*countingSource
+	pushed []expr.Expr
+}
+
+func (s *predicateSource) WithPredicates(predicates []expr.Expr) types.Source {
+	return &predicateSource{countingSource: s.countingSource, pushed: predicates}
+}
+
+func TestRDD_InputSourcePushesLeadingFilters(t *testing.T) {
+	src := &predicateSource{countingSource: &countingSource{parts: [][]interface{}{{1, 2}}, opened: make([]int32, 1)}}
+	gt := expr.MustParse("_ > 1")
+	lt := expr.MustParse("_ < 5")
+	r := FromSource(src).FilterExpr(gt).FilterExpr(lt).MapExpr(expr.MustParse("_ * 2")).FilterExpr(expr.MustParse("_ > 3"))
+
+	pushed, ok := r.InputSource().(*predicateSource)
+	if !ok {
+		t.Fatalf("Expected a source with pushed predicates, got %T", r.InputSource())
+	}
+	if !reflect.DeepEqual(pushed.pushed, []expr.Expr{gt, lt}) {
+		t.Errorf("Expected the filters ahead of the map to be pushed, got %v", pushed.pushed)
+	}
+	if unfiltered := FromSource(src).MapExpr(expr.MustParse("_ * 2")).FilterExpr(gt); unfiltered.InputSource() != types.Source(src) {
+		t.Error("Expected no pushdown for a chain starting with a map")
+	}
+	plain := &countingSource{parts: [][]interface{}{{1}}, opened: make([]int32, 1)}
+	if FromSource(plain).FilterExpr(gt).InputSource() != types.Source(plain) {
+		t.Error("Expected sources without predicate support to be used as they are")
+	}
+}
+
+func TestRDD_SaveCommitsOneAttemptPerPartition(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	data := make([]interface{}, 40)
+	for i := range data {
+		data[i] = i
+	}
+	release := make(chan struct{})
+	rdd := NewKeyedRDD(data, func(i interface{}) (interface{}, error) {
+		return i, nil
+	}).Repartition(4).MapPartitions(func(ctx context.Context, part []interface{}) ([]interface{}, error) {
+		// the first attempt of partition 0 straggles past the end of the job
+		if tc, _ := scheduler.TaskContextFrom(ctx); tc.Partition == 0 && tc.Attempt == 0 {
+			<-release
+		} else {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return part, nil
+	})
+
+	s := scheduler.New(scheduler.Config{
+		Parallelism:           8,
+		Speculation:           true,
+		SpeculationMultiplier: 2,
+		SpeculationQuantile:   0.5,
+		SpeculationInterval:   5 * time.Millisecond,
+	})
+	if err := rdd.Save(context.Background(), s, dir, sink.Text, sink.Options{}); err != nil {
+		t.Fatalf("Save failed with error: %v", err)
+	}
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	if stages := s.Stages(); stages[len(stages)-1].SpeculativeWins != 1 {
+		t.Errorf("Expected the speculative attempt to win, got %+v", stages[len(stages)-1])
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	expected := []string{"_SUCCESS", "part-00000", "part-00001", "part-00002", "part-00003"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected files %v, got %v", expected, names)
+	}
+	var lines []string
+	for _, name := range expected[1:] {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.Fields(string(content))...)
+	}
+	if len(lines) != len(data) {
+		t.Errorf("Expected %d lines, got %d", len(data), len(lines))
+	}
+
+	if err := rdd.Save(context.Background(), s, dir, sink.Text, sink.Options{}); !errors.Is(err, sink.ErrExists) {
+		t.Errorf("Expected saving into an existing directory to fail, got %v", err)
+	}
+}
+
+func TestRDD_ToChannelAppliesBackpressure(t *testing.T) {
+	data := make([]interface{}, 20)
+	for i := range data {
+		data[i] = i
+	}
+	var computed int32
+	r := NewKeyedRDD(data, func(i interface{}) (interface{}, error) {
+		return i, nil
+	}).Repartition(4).Map(func(i interface{}) (interface{}, error) {
+		atomic.AddInt32(&computed, 1)
+		return i.(int) * 10, nil
+	})
+
+	ch := make(chan int)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- ToChannel(context.Background(), scheduler.New(scheduler.Config{Parallelism: 2}), r, ch)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// one task blocks sending, the other waits for its turn and two wait for a slot
+	if n := atomic.LoadInt32(&computed); n != 10 {
+		t.Errorf("Expected 2 of 4 partitions to be computed before anything is received, got %d elements", n)
+	}
+
+	var received []int
+	for len(received) < len(data) {
+		received = append(received, <-ch)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("ToChannel failed with error: %v", err)
+	}
+	for i := 0; i < len(received); i += 5 {
+		// every partition arrives contiguously and in order
+		for j := 1; j < 5; j++ {
+			if received[i+j] != received[i]+10*j {
+				t.Errorf("Partition starting at %d was interleaved: %v", received[i], received)
+			}
+		}
+	}
+	sort.Ints(received)
+	for i, v := range received {
+		if v != i*10 {
+			t.Fatalf("Expected every element once, got %v", received)
+		}
+	}
+
+	strs := make(chan string, 20)
+	if err := ToChannel(context.Background(), scheduler.New(scheduler.Config{}), r, strs); err == nil {
+		t.Error("Expected an error sending ints to a string channel")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ToChannel(ctx, scheduler.New(scheduler.Config{}), r, make(chan int)); err == nil {
+		t.Error("Expected an error once the context is cancelled")
+	}
+}
+
+func TestRDD_ForeachEmitsEachPartitionOnce(t *testing.T) {
+	data := make([]interface{}, 40)
+	for i := range data {
+		data[i] = i
+	}
+	release := make(chan struct{})
+	r := NewKeyedRDD(data, func(i interface{}) (interface{}, error) {
+		return i, nil
+	}).Repartition(4).MapPartitions(func(ctx context.Context, part []interface{}) ([]interface{}, error) {
+		// the first attempt of partition 0 straggles, so a speculative copy runs
+		if tc, _ := scheduler.TaskContextFrom(ctx); tc.Partition == 0 && tc.Attempt == 0 {
+			<-release
+		} else {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return part, nil
+	})
+	s := scheduler.New(scheduler.Config{
+		Parallelism:           8,
+		Speculation:           true,
+		SpeculationMultiplier: 2,
+		SpeculationQuantile:   0.5,
+		SpeculationInterval:   5 * time.Millisecond,
+	})
+	defer close(release)
+
+	var buf strings.Builder
+	if err := r.StreamTo(context.Background(), s, sink.Lines(&buf)); err != nil {
+		t.Fatalf("StreamTo failed with error: %v", err)
+	}
+	lines := strings.Fields(buf.String())
+	if len(lines) != len(data) {
+		t.Errorf("Expected %d lines, got %d: %v", len(data), len(lines), lines)
+	}
+	if stages := s.Stages(); stages[len(stages)-1].SpeculativeTasks == 0 {
+		t.Errorf("Expected a speculative attempt, got %+v", stages[len(stages)-1])
+	}
+
+	failing := errors.New("consumer gave up")
+	err := NewKeyedRDD(data, func(i interface{}) (interface{}, error) { return i, nil }).
+		Foreach(context.Background(), scheduler.New(scheduler.Config{}), func(_ context.Context, record interface{}) error {
+			if record.(int) == 7 {
+				return failing
+			}
+			return nil
+		})
+	if !errors.Is(err, failing) {
+		t.Errorf("Expected the consumer's error, got %v", err)
+	}
+}
+
+func TestRDD_WithKeyChangesGrouping(t *testing.T) {
+	original := NewKeyedRDD([]interface{}{"apple", "avocado", "banana", "cherry", "blueberry"}, func(i interface{}) (interface{}, error) { return i, nil })
+	byLetter := original.WithKey(func(i interface{}) (interface{}, error) { return i.(string)[:1], nil })
+	count := func(a []interface{}) ([]interface{}, error) { return []interface{}{len(a)}, nil }
+
+	got := byLetter.ReduceByKey(count).GetData()
+	sort.Slice(got, func(i, j int) bool { return got[i].(int) < got[j].(int) })
+	if want := []interface{}{1, 2, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReduceByKey after WithKey: got %v, want %v", got, want)
+	}
+	if got := original.ReduceByKey(count).GetData(); len(got) != 5 {
+		t.Errorf("WithKey changed the original RDD's key: got %v groups", len(got))
+	}
+}
+
+func TestRDD_UnionKeepsParentPartitions(t *testing.T) {
+	identity := func(i interface{}) (interface{}, error) { return i, nil }
+	evens := NewKeyedRDD([]interface{}{2, 4, 6}, identity).Repartition(3)
+	odds := NewKeyedRDD([]interface{}{1, 3, 5, 7}, func(i interface{}) (interface{}, error) { return i.(int) % 3, nil }).
+		Repartition(2).
+		ReduceByKey(func(a []interface{}) ([]interface{}, error) { return a[:1], nil })
+	union := evens.Map(func(i interface{}) (interface{}, error) { return i.(int) * 10, nil }).Union(odds)
+
+	s := scheduler.New(scheduler.Config{Parallelism: 2})
+	got, err := union.Collect(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].(int) < got[j].(int) })
+	want := union.GetData()
+	sort.Slice(want, func(i, j int) bool { return want[i].(int) < want[j].(int) })
+	if !reflect.DeepEqual(got, want) || len(got) != 6 {
+		t.Errorf("Union: got %v, GetData returned %v", got, want)
+	}
+
+	var partitions []int
+	counted := union.MapPartitions(func(_ context.Context, part []interface{}) ([]interface{}, error) {
+		return []interface{}{len(part)}, nil
+	})
+	out, err := counted.Collect(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	for _, n := range out {
+		partitions = append(partitions, n.(int))
+	}
+	if len(partitions) != 5 {
+		t.Errorf("Union of 3 and 2 partitions has %d partitions: %v", len(partitions), partitions)
+	}
+}
+
+func TestRDD_ReduceByKeyNSetsPartitions(t *testing.T) {
+	r := NewKeyedRDD([]interface{}{1, 2, 3, 4, 5, 6, 7, 8}, func(i interface{}) (interface{}, error) {
+		return i.(int) % 4, nil
+	}).Repartition(4).ReduceByKeyN(func(a []interface{}) ([]interface{}, error) {
+		return []interface{}{len(a)}, nil
+	}, 2)
+	counted := r.MapPartitions(func(_ context.Context, part []interface{}) ([]interface{}, error) {
+		return []interface{}{len(part)}, nil
+	})
+	out, err := counted.Collect(context.Background(), scheduler.New(scheduler.Config{Parallelism: 2}))
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	total := 0
+	for _, n := range out {
+		total += n.(int)
+	}
+	if len(out) != 2 || total != 4 {
+		t.Errorf("ReduceByKeyN into 2 partitions: got partition sizes %v, want 2 partitions of 4 groups", out)
+	}
+}
+
+func TestRDD_CacheGathersStatistics(t *testing.T) {
+	var evaluations int64
+	records := make([]interface{}, 100)
+	for i := range records {
+		var city interface{} = []string{"paris", "rome", "oslo"}[i%3]
+		if i%10 == 0 {
+			city = nil
+		}
+		records[i] = map[string]interface{}{"id": int64(i), "city": city}
+	}
+	identity := func(i interface{}) (interface{}, error) { return i, nil }
+	cached := NewKeyedRDD(records, identity).Repartition(4).Map(func(i interface{}) (interface{}, error) {
+		atomic.AddInt64(&evaluations, 1)
+		return i, nil
+	}).Cache()
+
+	if _, ok := cached.Statistics(); ok {
+		t.Errorf("Statistics known before the RDD was evaluated")
+	}
+	s := scheduler.New(scheduler.Config{Parallelism: 2})
+	for i := 0; i < 2; i++ {
+		got, err := cached.Collect(context.Background(), s)
+		if err != nil {
+			t.Fatalf("Collect failed with error: %v", err)
+		}
+		if len(got) != 100 {
+			t.Errorf("Collect of the cached RDD: got %d elements, want 100", len(got))
+		}
+	}
+	if evaluations != 100 {
+		t.Errorf("Cached RDD evaluated its parent %d times per element, want once", evaluations/100)
+	}
+
+	st, ok := cached.Statistics()
+	if !ok {
+		t.Fatalf("Statistics unknown after the RDD was evaluated")
+	}
+	if st.Rows != 100 || st.Bytes == 0 {
+		t.Errorf("Statistics: got %d rows of %d bytes, want 100 rows", st.Rows, st.Bytes)
+	}
+	city, id := st.Columns["city"], st.Columns["id"]
+	if city.Distinct != 3 || city.Nulls != 10 || city.Min != "oslo" || city.Max != "rome" {
+		t.Errorf("Statistics of city: got %+v", city)
+	}
+	if id.Distinct < 95 || id.Distinct > 105 || id.Min != int64(0) || id.Max != int64(99) {
+		t.Errorf("Statistics of id: got %+v", id)
+	}
+	if _, ok := cached.Map(identity).Statistics(); ok {
+		t.Errorf("Statistics known for an RDD mapping the cached one")
+	}
+}
+
+func TestRDD_JoinStrategies(t *testing.T) {
+	byFirst := func(i interface{}) (interface{}, error) { return i.([2]interface{})[0], nil }
+	left := NewKeyedRDD([]interface{}{
+		[2]interface{}{1, "a"}, [2]interface{}{2, "b"}, [2]interface{}{2, "c"}, [2]interface{}{3, "d"},
+	}, byFirst).Repartition(3)
+	right := NewKeyedRDD([]interface{}{
+		[2]interface{}{2, "x"}, [2]interface{}{3, "y"}, [2]interface{}{4, "z"},
+	}, byFirst).Repartition(2)
+	inner := func(l, r []interface{}) ([]interface{}, error) {
+		var out []interface{}
+		for _, a := range l {
+			for _, b := range r {
+				if a.([2]interface{})[0] == b.([2]interface{})[0] {
+					out = append(out, a.([2]interface{})[1].(string)+b.([2]interface{})[1].(string))
+				}
+			}
+		}
+		return out, nil
+	}
+	want := []interface{}{"bx", "cx", "dy"}
+
+	tests := []struct {
+		strategy   JoinStrategy
+		partitions int
+		tasks      int
+	}{
+		{ShuffleJoin, 0, 3},
+		{ShuffleJoin, 5, 5},
+		{BroadcastRight, 0, 3},
+		{BroadcastLeft, 0, 2},
+	}
+	for _, tt := range tests {
+		joined := left.Join(right, tt.strategy, tt.partitions, inner)
+		got, err := joined.Collect(context.Background(), scheduler.New(scheduler.Config{Parallelism: 2}))
+		if err != nil {
+			t.Fatalf("%s join failed with error: %v", tt.strategy, err)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i].(string) < got[j].(string) })
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s join: got %v, want %v", tt.strategy, got, want)
+		}
+		local := joined.GetData()
+		sort.Slice(local, func(i, j int) bool { return local[i].(string) < local[j].(string) })
+		if !reflect.DeepEqual(local, want) {
+			t.Errorf("%s join evaluated locally: got %v, want %v", tt.strategy, local, want)
+		}
+		counted, err := joined.MapPartitions(func(_ context.Context, part []interface{}) ([]interface{}, error) {
+			return []interface{}{len(part)}, nil
+		}).Collect(context.Background(), scheduler.New(scheduler.Config{}))
+		if err != nil {
+			t.Fatalf("%s join failed with error: %v", tt.strategy, err)
+		}
+		if len(counted) != tt.tasks {
+			t.Errorf("%s join with %d partitions: got %d partitions, want %d", tt.strategy, tt.partitions, len(counted), tt.tasks)
+		}
+	}
+}
+
+// stageTasks returns the number of tasks of every stage run by s, by stage name
+func stageTasks(s *scheduler.Scheduler) map[string][]int {
+	tasks := make(map[string][]int)
+	for _, stage := range s.Stages() {
+		tasks[stage.Name] = append(tasks[stage.Name], stage.NumTasks)
+	}
+	return tasks
+}
+
+func TestRDD_AdaptiveCoalescesReducePartitions(t *testing.T) {
+	data := make([]interface{}, 100)
+	for i := range data {
+		data[i] = i
+	}
+	keyed := NewKeyedRDD(data, func(i interface{}) (interface{}, error) {
+		return i.(int) % 10, nil
+	}).Repartition(4)
+	sum := func(a []interface{}) ([]interface{}, error) {
+		sum := 0
+		for _, v := range a {
+			sum += v.(int)
+		}
+		return []interface{}{sum}, nil
+	}
+	r := keyed.ReduceByKeyAdvisory(sum, 10)
+
+	planned := scheduler.New(scheduler.Config{Parallelism: 2, DisableAdaptive: true})
+	want, err := r.Collect(context.Background(), planned)
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i].(int) < want[j].(int) })
+	if got := stageTasks(planned)["reduceByKey"]; !reflect.DeepEqual(got, []int{10}) {
+		t.Errorf("Without adaptive execution the reduce stage ran %v tasks, want 10", got)
+	}
+
+	tests := []struct {
+		name     string
+		config   scheduler.Config
+		min, max int
+	}{
+		{"Default", scheduler.Config{Parallelism: 2}, 1, 1},
+		// every key holds 10 values of 8 bytes
+		{"SmallPartitions", scheduler.Config{Parallelism: 2, AdvisoryPartitionBytes: 100}, 2, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := scheduler.New(tt.config)
+			got, err := r.Collect(context.Background(), s)
+			if err != nil {
+				t.Fatalf("Collect failed with error: %v", err)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].(int) < got[j].(int) })
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Coalesced reduce: got %v, want %v", got, want)
+			}
+			tasks := stageTasks(s)["reduceByKey"]
+			if len(tasks) != 1 || tasks[0] < tt.min || tasks[0] > tt.max {
+				t.Errorf("Coalesced reduce stage ran %v tasks, want between %d and %d", tasks, tt.min, tt.max)
+			}
+		})
+	}
+
+	// numbers of partitions set by the user are kept, those by default coalesced
+	users := []struct {
+		name  string
+		r     *KeyedRDD
+		tasks int
+	}{
+		{"ReduceByKeyN", keyed.ReduceByKeyN(sum, 10), 10},
+		{"Repartition", keyed.Repartition(10).ReduceByKey(sum), 10},
+		{"Default", FromSource(&countingSource{parts: [][]interface{}{data[:50], data[50:]}, opened: make([]int32, 2)}).WithKey(keyed.Key).ReduceByKey(sum), 1},
+	}
+	for _, tt := range users {
+		s := scheduler.New(scheduler.Config{Parallelism: 2})
+		if _, err := tt.r.Collect(context.Background(), s); err != nil {
+			t.Fatalf("%s failed with error: %v", tt.name, err)
+		}
+		if got := stageTasks(s)["reduceByKey"]; !reflect.DeepEqual(got, []int{tt.tasks}) {
+			t.Errorf("%s reduce stage ran %v tasks, want %d", tt.name, got, tt.tasks)
+		}
+	}
+}
+
+func TestRDD_AdaptiveJoin(t *testing.T) {
+	byFirst := func(i interface{}) (interface{}, error) { return i.([2]interface{})[0], nil }
+	var leftData []interface{}
+	for i := 0; i < 40; i++ {
+		leftData = append(leftData, [2]interface{}{1, "hot"})
+	}
+	for k := 2; k < 10; k++ {
+		leftData = append(leftData, [2]interface{}{k, "cold"})
+	}
+	var rightData []interface{}
+	for k := 1; k < 10; k++ {
+		rightData = append(rightData, [2]interface{}{k, k})
+	}
+	left := NewKeyedRDD(leftData, byFirst).Repartition(8)
+	right := NewKeyedRDD(rightData, byFirst).Repartition(2)
+	inner := func(l, r []interface{}) ([]interface{}, error) {
+		var out []interface{}
+		for _, a := range l {
+			for _, b := range r {
+				if a.([2]interface{})[0] == b.([2]interface{})[0] {
+					out = append(out, a.([2]interface{})[0])
+				}
+			}
+		}
+		return out, nil
+	}
+	want := left.Join(right, ShuffleJoin, 0, inner).GetData()
+	sort.Slice(want, func(i, j int) bool { return want[i].(int) < want[j].(int) })
+
+	// skewed makes the bucket of the hot key skewed and keeps both sides from
+	// being broadcast
+	skewed := scheduler.Config{BroadcastJoinBytes: 1, AdvisoryPartitionBytes: 80, SkewFactor: 2, SkewThresholdBytes: 1}
+	tests := []struct {
+		name     string
+		opts     JoinOptions
+		config   scheduler.Config
+		maps     int
+		min, max int
+	}{
+		{"Disabled", JoinOptions{Partitions: 4, CanBroadcastLeft: true, CanBroadcastRight: true}, scheduler.Config{DisableAdaptive: true}, 2, 4, 4},
+		{"BroadcastRight", JoinOptions{Partitions: 4, CanBroadcastRight: true}, scheduler.Config{}, 1, 8, 8},
+		{"BroadcastLeft", JoinOptions{Partitions: 4, CanBroadcastLeft: true}, scheduler.Config{}, 2, 2, 2},
+		{"Coalesced", JoinOptions{Partitions: 4, AdvisoryPartitions: true}, scheduler.Config{}, 2, 1, 1},
+		{"UserPartitions", JoinOptions{Partitions: 4}, scheduler.Config{}, 2, 4, 4},
+		{"InheritedPartitions", JoinOptions{}, scheduler.Config{}, 2, 8, 8},
+		{"SkewNotSplittable", JoinOptions{Partitions: 4, CanBroadcastLeft: true, AdvisoryPartitions: true}, skewed, 2, 1, 4},
+		{"SkewSplit", JoinOptions{Partitions: 4, CanBroadcastRight: true, AdvisoryPartitions: true}, skewed, 2, 5, 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := scheduler.New(tt.config)
+			got, err := left.JoinWithOptions(right, tt.opts, inner).Collect(context.Background(), s)
+			if err != nil {
+				t.Fatalf("Join failed with error: %v", err)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].(int) < got[j].(int) })
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Join: got %v, want %v", got, want)
+			}
+			stages := stageTasks(s)
+			if maps := len(stages["shuffleMap"]); maps != tt.maps {
+				t.Errorf("Join ran %d shuffle map stages, want %d", maps, tt.maps)
+			}
+			if tasks := stages["result"]; len(tasks) != 1 || tasks[0] < tt.min || tasks[0] > tt.max {
+				t.Errorf("Join ran %v tasks, want between %d and %d", tasks, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package rdd
+
+import (
+	"context"
+
+	"github.com/bajor/spark-go-core/scheduler"
+	"github.com/bajor/spark-go-core/sink"
+)
+
+// Save writes every partition of the RDD as a part file in dir. Attempts write into
+// temporary directories and only the first attempt of each partition to finish is
+// committed, so speculative and retried tasks never leave duplicate or partial files;
+// the job writes a _SUCCESS marker once all files are in place.
+func (r *KeyedRDD) Save(ctx context.Context, s *scheduler.Scheduler, dir string, format sink.Format, options sink.Options) error {
+	committer, err := sink.NewCommitter(dir, options.Overwrite)
+	if err != nil {
+		return err
+	}
+	inputs, pipeline, err := r.plan(ctx, s)
+	if err != nil {
+		committer.AbortJob()
+		return err
+	}
+
+	tasks := make([]scheduler.Task, len(inputs))
+	for i, in := range inputs {
+		i, in := i, in
+		tasks[i] = func(ctx context.Context) ([]interface{}, error) {
+			part, err := in.load(ctx)
+			if err != nil {
+				return nil, err
+			}
+			data, err := executePipeline(ctx, part, pipeline)
+			if err != nil {
+				return nil, err
+			}
+			attempt := 0
+			if tc, ok := scheduler.TaskContextFrom(ctx); ok {
+				attempt = tc.Attempt
+			}
+			return nil, sink.WriteTask(ctx, committer, i, attempt, data, format, options)
+		}
+	}
+	if _, err := s.RunStage(ctx, "save", tasks); err != nil {
+		committer.AbortJob()
+		return err
+	}
+	return committer.CommitJob()
+}
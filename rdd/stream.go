@@ -0,0 +1,126 @@
+package rdd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/bajor/spark-go-core/scheduler"
+	"github.com/bajor/spark-go-core/sink"
+)
+
+// Foreach evaluates the RDD and passes every element to f as its partition is ready,
+// without collecting the results. Partitions are handed over one at a time in the
+// order they finish, each by exactly one attempt, and the elements of a partition in
+// order. A blocking f holds its task, so slow consumers throttle the job.
+func (r *KeyedRDD) Foreach(ctx context.Context, s *scheduler.Scheduler, f func(ctx context.Context, record interface{}) error) error {
+	return r.foreachPartition(ctx, s, "foreach", func(ctx context.Context, part []interface{}) error {
+		for _, record := range part {
+			if err := f(ctx, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ToChannel evaluates the RDD and sends every element to ch, see Foreach. Sends block
+// until ch has room or ctx is done. Elements that are not of type T fail the job
+// before any element of their partition is sent. ch is not closed.
+func ToChannel[T any](ctx context.Context, s *scheduler.Scheduler, r *KeyedRDD, ch chan<- T) error {
+	return r.foreachPartition(ctx, s, "toChannel", func(ctx context.Context, part []interface{}) error {
+		typed := make([]T, len(part))
+		for i, record := range part {
+			v, ok := record.(T)
+			if !ok {
+				return fmt.Errorf("element %v (%T) is not a %T", record, record, v)
+			}
+			typed[i] = v
+		}
+		for _, v := range typed {
+			select {
+			case ch <- v:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+}
+
+// StreamTo evaluates the RDD and writes every element with enc, see Foreach
+func (r *KeyedRDD) StreamTo(ctx context.Context, s *scheduler.Scheduler, enc sink.Encoder) error {
+	return r.Foreach(ctx, s, func(_ context.Context, record interface{}) error {
+		return enc.Encode(record)
+	})
+}
+
+// emission is the hand-over of one partition. done is closed once the attempt that
+// claimed it has finished, successfully if err is nil.
+type emission struct {
+	done chan struct{}
+	err  error
+}
+
+// foreachPartition runs the final stage of the RDD and passes each partition's output
+// to emit. The first attempt of a partition to finish computing claims it; other
+// attempts wait for it rather than emitting again, so that speculation never
+// duplicates output and never cancels an attempt half way through emitting.
+func (r *KeyedRDD) foreachPartition(ctx context.Context, s *scheduler.Scheduler, name string, emit func(ctx context.Context, part []interface{}) error) error {
+	inputs, pipeline, err := r.plan(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	claims := make(map[int]*emission)
+	// turn lets one partition emit at a time, keeping partitions contiguous
+	turn := make(chan struct{}, 1)
+
+	tasks := make([]scheduler.Task, len(inputs))
+	for i, in := range inputs {
+		i, in := i, in
+		tasks[i] = func(ctx context.Context) ([]interface{}, error) {
+			part, err := in.load(ctx)
+			if err != nil {
+				return nil, err
+			}
+			data, err := executePipeline(ctx, part, pipeline)
+			if err != nil {
+				return nil, err
+			}
+
+			mu.Lock()
+			e, claimed := claims[i]
+			if !claimed {
+				e = &emission{done: make(chan struct{})}
+				claims[i] = e
+			}
+			mu.Unlock()
+			if claimed {
+				select {
+				case <-e.done:
+					if e.err != nil {
+						return nil, fmt.Errorf("partition %d failed in another attempt: %w", i, e.err)
+					}
+					return nil, nil
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+
+			defer close(e.done)
+			select {
+			case turn <- struct{}{}:
+			case <-ctx.Done():
+				e.err = ctx.Err()
+				return nil, e.err
+			}
+			e.err = emit(ctx, data)
+			<-turn
+			return nil, e.err
+		}
+	}
+	_, err = s.RunStage(ctx, name, tasks)
+	return err
+}
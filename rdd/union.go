@@ -0,0 +1,85 @@
+package rdd
+
+import (
+	"context"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/scheduler"
+)
+
+// Union returns an RDD of the elements of r followed by those of others, each
+// element being its own key. Evaluated in parallel, the partitions of every input
+// RDD become partitions of the union.
+func (r *KeyedRDD) Union(others ...*KeyedRDD) *KeyedRDD {
+	return FromSource(&unionSource{parents: append([]*KeyedRDD{r}, others...)})
+}
+
+// unionSource reads one parent RDD per partition when an RDD is evaluated on the
+// calling goroutine; Collect instead runs the parents' stages and keeps their
+// partitions, see inputs
+type unionSource struct {
+	parents []*KeyedRDD
+}
+
+func (u *unionSource) NumPartitions() int {
+	return len(u.parents)
+}
+
+func (u *unionSource) Open(partition int) (lazy.SourceIterator, error) {
+	data, err := u.parents[partition].evaluate()
+	if err != nil {
+		return nil, err
+	}
+	return &sliceSourceIterator{SliceIterator: lazy.NewSliceIterator(data)}, nil
+}
+
+// numPartitions returns the number of partitions of the union's parents together
+func (u *unionSource) numPartitions() int {
+	n := 0
+	for _, p := range u.parents {
+		n += p.numPartitions()
+	}
+	return n
+}
+
+// inputs runs the parents up to their final stage and returns their partitions
+func (u *unionSource) inputs(ctx context.Context, s *scheduler.Scheduler) ([]input, error) {
+	var inputs []input
+	for _, p := range u.parents {
+		parentInputs, pipeline, err := p.plan(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		if len(pipeline) == 0 {
+			inputs = append(inputs, parentInputs...)
+			continue
+		}
+		out, err := runStage(ctx, s, "union", parentInputs, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, sliceInputs(out)...)
+	}
+	return inputs, nil
+}
+
+// evaluate is GetData returning errors instead of panicking
+func (r *KeyedRDD) evaluate() (data []interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			if e, ok := p.(error); ok {
+				err = e
+				return
+			}
+			panic(p)
+		}
+	}()
+	return r.GetData(), nil
+}
+
+type sliceSourceIterator struct {
+	*lazy.SliceIterator
+}
+
+func (sliceSourceIterator) Err() error   { return nil }
+func (sliceSourceIterator) Close() error { return nil }
@@ -0,0 +1,404 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/bajor/spark-go-core/operations"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// MapFactory builds a map function from its serialized arguments
+type MapFactory func(args json.RawMessage) (func(interface{}) (interface{}, error), error)
+
+// FilterFactory builds a filter predicate from its serialized arguments
+type FilterFactory func(args json.RawMessage) (func(interface{}) bool, error)
+
+// PartitionFactory builds a function that transforms a whole partition at once
+type PartitionFactory func(args json.RawMessage) (func([]interface{}) ([]interface{}, error), error)
+
+// PartitionContextFactory builds a partition function that receives the task context,
+// e.g. to add to accumulators
+type PartitionContextFactory func(args json.RawMessage) (func(context.Context, []interface{}) ([]interface{}, error), error)
+
+// Kind is the kind of function an operation was registered with
+type Kind int
+
+const (
+	// PartitionKind operations transform whole partitions
+	PartitionKind Kind = iota
+	// MapKind operations replace every element
+	MapKind
+	// FilterKind operations keep the elements a predicate accepts
+	FilterKind
+)
+
+func (k Kind) String() string {
+	switch k {
+	case MapKind:
+		return "map"
+	case FilterKind:
+		return "filter"
+	}
+	return "partition"
+}
+
+// entry is a registered operation that can be rebuilt from an OperationSpec
+type entry struct {
+	kind  Kind
+	build PartitionContextFactory
+}
+
+var (
+	mu      sync.RWMutex
+	entries = make(map[string]entry)
+)
+
+// register stores an entry and panics on duplicates, since registration happens in init
+func register(name string, e entry) {
+	mu.Lock()
+	defer mu.Unlock()
+	if name == "" {
+		panic("registry: empty operation name")
+	}
+	if _, ok := entries[name]; ok {
+		panic(fmt.Sprintf("registry: operation %q registered twice", name))
+	}
+	entries[name] = e
+}
+
+// RegisterMap registers a named map operation. It must be called from init in every
+// binary, driver and workers alike, that refers to the operation.
+func RegisterMap(name string, factory MapFactory) {
+	register(name, entry{kind: MapKind, build: func(args json.RawMessage) (func(context.Context, []interface{}) ([]interface{}, error), error) {
+		f, err := factory(args)
+		if err != nil {
+			return nil, err
+		}
+		return func(_ context.Context, data []interface{}) ([]interface{}, error) {
+			return operations.Map(data, f)
+		}, nil
+	}})
+}
+
+// RegisterFilter registers a named filter operation
+func RegisterFilter(name string, factory FilterFactory) {
+	register(name, entry{kind: FilterKind, build: func(args json.RawMessage) (func(context.Context, []interface{}) ([]interface{}, error), error) {
+		f, err := factory(args)
+		if err != nil {
+			return nil, err
+		}
+		return func(_ context.Context, data []interface{}) ([]interface{}, error) {
+			return operations.Filter(data, f), nil
+		}, nil
+	}})
+}
+
+// RegisterPartition registers a named operation that sees a whole partition
+func RegisterPartition(name string, factory PartitionFactory) {
+	register(name, entry{build: func(args json.RawMessage) (func(context.Context, []interface{}) ([]interface{}, error), error) {
+		f, err := factory(args)
+		if err != nil {
+			return nil, err
+		}
+		return func(_ context.Context, data []interface{}) ([]interface{}, error) {
+			return f(data)
+		}, nil
+	}})
+}
+
+// RegisterPartitionContext registers a partition operation that receives the task context
+func RegisterPartitionContext(name string, factory PartitionContextFactory) {
+	register(name, entry{build: factory})
+}
+
+// RegisterMapFunc registers a typed map function. Arguments are decoded into A and each
+// element is converted to T before the call, so JSON numbers can feed int parameters.
+func RegisterMapFunc[A, T, U any](name string, f func(args A, in T) (U, error)) {
+	RegisterMap(name, func(raw json.RawMessage) (func(interface{}) (interface{}, error), error) {
+		args, err := decodeArgs[A](raw)
+		if err != nil {
+			return nil, err
+		}
+		return func(i interface{}) (interface{}, error) {
+			in, err := convert[T](i)
+			if err != nil {
+				return nil, err
+			}
+			return f(args, in)
+		}, nil
+	})
+}
+
+// RegisterMapFuncContext is RegisterMapFunc for functions that need the task context,
+// e.g. to add to accumulators passed in their arguments
+func RegisterMapFuncContext[A, T, U any](name string, f func(ctx context.Context, args A, in T) (U, error)) {
+	register(name, entry{kind: MapKind, build: func(raw json.RawMessage) (func(context.Context, []interface{}) ([]interface{}, error), error) {
+		args, err := decodeArgs[A](raw)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, data []interface{}) ([]interface{}, error) {
+			return operations.Map(data, func(i interface{}) (interface{}, error) {
+				in, err := convert[T](i)
+				if err != nil {
+					return nil, err
+				}
+				return f(ctx, args, in)
+			})
+		}, nil
+	}})
+}
+
+// RegisterFilterFunc registers a typed filter predicate; elements that cannot be converted to T are dropped
+func RegisterFilterFunc[A, T any](name string, f func(args A, in T) bool) {
+	RegisterFilter(name, func(raw json.RawMessage) (func(interface{}) bool, error) {
+		args, err := decodeArgs[A](raw)
+		if err != nil {
+			return nil, err
+		}
+		return func(i interface{}) bool {
+			in, err := convert[T](i)
+			if err != nil {
+				return false
+			}
+			return f(args, in)
+		}, nil
+	})
+}
+
+// Registered reports whether an operation with the given name exists
+func Registered(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := entries[name]
+	return ok
+}
+
+// KindOf returns the kind of a registered operation
+func KindOf(name string) (Kind, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	e, ok := entries[name]
+	return e.kind, ok
+}
+
+// Names returns the names of all registered operations in sorted order
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Build turns a spec back into an executable operation
+func Build(spec types.OperationSpec) (types.Operation, error) {
+	mu.RLock()
+	e, ok := entries[spec.Name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("operation %q is not registered", spec.Name)
+	}
+	f, err := e.build(spec.Args)
+	if err != nil {
+		return nil, fmt.Errorf("operation %q: %w", spec.Name, err)
+	}
+	return Operation{spec: spec, f: f}, nil
+}
+
+// Specs converts an operation chain into specs that can be shipped to workers. It fails
+// with a descriptive error if the chain contains a Go closure or an unregistered name.
+func Specs(ops []types.Operation) ([]types.OperationSpec, error) {
+	specs := make([]types.OperationSpec, 0, len(ops))
+	for i, op := range ops {
+		shippable, ok := op.(types.ShippableOperation)
+		if !ok {
+			return nil, fmt.Errorf("operation %d (%T) is a Go closure and cannot be shipped to workers; register it with registry.RegisterMap or RegisterFilter and reference it by name", i, op)
+		}
+		if _, ok := op.(types.ShuffleOperation); ok {
+			return nil, fmt.Errorf("operation %d (%T) needs a shuffle and cannot run inside a single task", i, op)
+		}
+		// operations built with an invalid name or arguments fail before being shipped
+		if invalid, ok := op.(interface{ Err() error }); ok && invalid.Err() != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, invalid.Err())
+		}
+		spec := shippable.Spec()
+		if !Registered(spec.Name) {
+			return nil, fmt.Errorf("operation %d refers to %q, which is not registered in this binary", i, spec.Name)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// Operation is a registered operation bound to its arguments
+type Operation struct {
+	spec types.OperationSpec
+	f    func(context.Context, []interface{}) ([]interface{}, error)
+}
+
+// Narrow marks registered operations as partition-local
+func (o Operation) Narrow() {}
+
+// Spec returns the name and arguments the operation was built from
+func (o Operation) Spec() types.OperationSpec {
+	return o.spec
+}
+
+func (o Operation) Execute(data []interface{}) ([]interface{}, error) {
+	return o.f(context.Background(), data)
+}
+
+func (o Operation) ExecuteContext(ctx context.Context, data []interface{}) ([]interface{}, error) {
+	return o.f(ctx, data)
+}
+
+// Executor runs shipped operation specs by looking them up in the registry
+type Executor struct{}
+
+// Execute rebuilds every spec and applies the operations in order
+func (Executor) Execute(ctx context.Context, data []interface{}, specs []types.OperationSpec) ([]interface{}, error) {
+	current := data
+	for _, spec := range specs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		op, err := Build(spec)
+		if err != nil {
+			return nil, err
+		}
+		if current, err = op.(Operation).ExecuteContext(ctx, current); err != nil {
+			return nil, fmt.Errorf("%s: %w", spec.Name, err)
+		}
+	}
+	return current, nil
+}
+
+func decodeArgs[A any](raw json.RawMessage) (A, error) {
+	var args A
+	if len(raw) == 0 || string(raw) == "null" {
+		return args, nil
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return args, fmt.Errorf("decode arguments: %w", err)
+	}
+	return args, nil
+}
+
+// convert casts v to T, converting between numeric kinds when no value is lost: a
+// fraction, an overflow or a sign change is an error
+func convert[T any](v interface{}) (T, error) {
+	if t, ok := v.(T); ok {
+		return t, nil
+	}
+	var zero T
+	target := reflect.TypeOf((*T)(nil)).Elem()
+	val := reflect.ValueOf(v)
+	if val.IsValid() && isNumber(val.Kind()) && isNumber(target.Kind()) {
+		converted := val.Convert(target)
+		if !lossless(val, converted) {
+			return zero, fmt.Errorf("cannot convert %v (%T) to %v without loss", v, v, target)
+		}
+		return converted.Interface().(T), nil
+	}
+	return zero, fmt.Errorf("cannot use %T as %v", v, target)
+}
+
+// lossless reports whether a numeric conversion kept the value, converting it back
+// to compare. A float32 only holds 24 bits of mantissa, so integers above 2^24 and
+// most decimals such as 0.1 do not fit.
+func lossless(from, to reflect.Value) bool {
+	if isFloat(from.Kind()) && isFloat(to.Kind()) && math.IsNaN(from.Float()) {
+		return true
+	}
+	back := to.Convert(from.Type())
+	if !back.Equal(from) {
+		return false
+	}
+	// a conversion wrapping to the same bits in both directions still changes the sign
+	return negative(from) == negative(to)
+}
+
+func negative(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() < 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() < 0
+	}
+	return false
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// ReduceByKey groups records by the registered key operation and applies the registered
+// reduce operation to every group, the shippable counterpart of operations.ReduceByKey
+func ReduceByKey(data []interface{}, key, reduce types.OperationSpec) ([]interface{}, error) {
+	keyOp, err := Build(key)
+	if err != nil {
+		return nil, err
+	}
+	reduceOp, err := Build(reduce)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := Keys(keyOp, data)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[interface{}]int)
+	var groups [][]interface{}
+	for i, item := range data {
+		g, ok := index[keys[i]]
+		if !ok {
+			g = len(groups)
+			index[keys[i]] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], item)
+	}
+
+	result := make([]interface{}, 0)
+	for _, group := range groups {
+		reduced, err := reduceOp.Execute(group)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, reduced...)
+	}
+	return result, nil
+}
+
+// Keys applies a key operation to data, which must produce exactly one key per record
+func Keys(keyOp types.Operation, data []interface{}) ([]interface{}, error) {
+	keys, err := keyOp.Execute(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != len(data) {
+		return nil, fmt.Errorf("key operation returned %d keys for %d records", len(keys), len(data))
+	}
+	return keys, nil
+}
@@ -0,0 +1,141 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bajor/spark-go-core/types"
+)
+
+type scaleArgs struct {
+	Factor int `json:"factor"`
+}
+
+type minArgs struct {
+	Min int `json:"min"`
+}
+
+func init() {
+	RegisterMapFunc("test.scale", func(args scaleArgs, in int) (int, error) {
+		return in * args.Factor, nil
+	})
+	RegisterFilterFunc("test.atLeast", func(args minArgs, in int) bool {
+		return in >= args.Min
+	})
+	RegisterPartition("test.count", func(args json.RawMessage) (func([]interface{}) ([]interface{}, error), error) {
+		return func(data []interface{}) ([]interface{}, error) {
+			return []interface{}{len(data)}, nil
+		}, nil
+	})
+}
+
+type closureOperation struct{}
+
+func (closureOperation) Execute(data []interface{}) ([]interface{}, error) { return data, nil }
+
+func TestBuild(t *testing.T) {
+	op, err := Build(types.OperationSpec{Name: "test.scale", Args: json.RawMessage(`{"factor": 3}`)})
+	if err != nil {
+		t.Fatalf("Build failed with error: %v", err)
+	}
+
+	result, err := op.Execute([]interface{}{1, 2.0, 3})
+	if err != nil {
+		t.Fatalf("Execute failed with error: %v", err)
+	}
+
+	expected := []interface{}{3, 6, 9}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Build failed: got %v, want %v", result, expected)
+	}
+}
+
+func TestBuild_Unregistered(t *testing.T) {
+	if _, err := Build(types.OperationSpec{Name: "test.missing"}); err == nil {
+		t.Errorf("Expected unregistered operation to fail")
+	}
+}
+
+func TestRegister_DuplicatePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected duplicate registration to panic")
+		}
+	}()
+	RegisterFilterFunc("test.atLeast", func(args minArgs, in int) bool { return true })
+}
+
+func TestSpecs_RefusesClosures(t *testing.T) {
+	scale, _ := Build(types.OperationSpec{Name: "test.scale", Args: json.RawMessage(`{"factor": 2}`)})
+
+	_, err := Specs([]types.Operation{scale, closureOperation{}})
+	if err == nil || !strings.Contains(err.Error(), "closure") {
+		t.Errorf("Expected closure to be refused, got %v", err)
+	}
+}
+
+func TestExecutor(t *testing.T) {
+	specs := []types.OperationSpec{
+		{Name: "test.scale", Args: json.RawMessage(`{"factor": 2}`)},
+		{Name: "test.atLeast", Args: json.RawMessage(`{"min": 5}`)},
+		{Name: "test.count"},
+	}
+
+	result, err := Executor{}.Execute(context.Background(), []interface{}{1.0, 2.0, 3.0, 4.0}, specs)
+	if err != nil {
+		t.Fatalf("Execute failed with error: %v", err)
+	}
+
+	expected := []interface{}{2}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Executor failed: got %v, want %v", result, expected)
+	}
+}
+
+func TestConvert_RejectsLoss(t *testing.T) {
+	tests := []struct {
+		name string
+		conv func() (interface{}, error)
+		want interface{}
+	}{
+		{"WholeFloat", func() (interface{}, error) { return convert[int](2.0) }, 2},
+		{"Fraction", func() (interface{}, error) { return convert[int](2.7) }, nil},
+		{"Overflow", func() (interface{}, error) { return convert[int8](300) }, nil},
+		{"Negative", func() (interface{}, error) { return convert[uint](-1) }, nil},
+		{"FloatOverflow", func() (interface{}, error) { return convert[int64](1e30) }, nil},
+		{"Widening", func() (interface{}, error) { return convert[float64](int64(3)) }, 3.0},
+		{"Inexact", func() (interface{}, error) { return convert[float64](int64(1<<53 + 1)) }, nil},
+		{"Float32", func() (interface{}, error) { return convert[float32](0.5) }, float32(0.5)},
+		{"Float32Decimal", func() (interface{}, error) { return convert[float32](0.1) }, nil},
+		{"Float32Mantissa", func() (interface{}, error) { return convert[float32](int64(1<<24 + 1)) }, nil},
+		{"Float32Integer", func() (interface{}, error) { return convert[float32](int64(1 << 24)) }, float32(1 << 24)},
+		{"Float32Overflow", func() (interface{}, error) { return convert[float32](1e300) }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.conv()
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("Expected lossy conversion to fail, got %v", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Conversion: got %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuild_RejectsFraction(t *testing.T) {
+	op, err := Build(types.OperationSpec{Name: "test.scale", Args: json.RawMessage(`{"factor": 3}`)})
+	if err != nil {
+		t.Fatalf("Build failed with error: %v", err)
+	}
+	if _, err := op.Execute([]interface{}{1, 2.7}); err == nil {
+		t.Errorf("Expected 2.7 to be refused as an int")
+	}
+}
@@ -0,0 +1,337 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bajor/spark-go-core/accumulator"
+)
+
+// Task computes the output of a single partition. The context is cancelled when
+// another attempt of the same task has already produced a result.
+type Task func(ctx context.Context) ([]interface{}, error)
+
+// TaskContext describes the attempt a task function is running as
+type TaskContext struct {
+	StageID     int
+	Partition   int
+	Attempt     int
+	Speculative bool
+}
+
+type taskContextKey struct{}
+
+// TaskContextFrom returns the TaskContext stored in ctx by the scheduler
+func TaskContextFrom(ctx context.Context) (TaskContext, bool) {
+	tc, ok := ctx.Value(taskContextKey{}).(TaskContext)
+	return tc, ok
+}
+
+// Config controls parallelism, speculative execution and adaptive execution
+type Config struct {
+	// Parallelism is the maximum number of attempts running at once, defaults to runtime.NumCPU()
+	Parallelism int
+	// Speculation enables launching duplicate attempts for straggler tasks
+	Speculation bool
+	// SpeculationMultiplier is how many times slower than the median a task must be to be speculated
+	SpeculationMultiplier float64
+	// SpeculationQuantile is the fraction of tasks that must finish before speculation is considered
+	SpeculationQuantile float64
+	// SpeculationInterval is how often running tasks are checked for stragglers
+	SpeculationInterval time.Duration
+
+	// DisableAdaptive keeps the planned reduce partitions and join strategies instead
+	// of adapting them to the shuffle block sizes measured after each map stage. Only
+	// jobs run in process by rdd's Collect adapt; cluster jobs never do.
+	DisableAdaptive bool
+	// AdvisoryPartitionBytes is the size adaptive execution coalesces small reduce
+	// partitions up to and splits skewed join partitions down to
+	AdvisoryPartitionBytes int64
+	// SkewFactor and SkewThresholdBytes define a skewed join partition: larger than
+	// SkewFactor times the median partition of its side and than SkewThresholdBytes
+	SkewFactor         float64
+	SkewThresholdBytes int64
+	// BroadcastJoinBytes is the measured size under which a side of a shuffle join is
+	// broadcast instead
+	BroadcastJoinBytes int64
+}
+
+// DefaultConfig returns the configuration used when fields are left zero
+func DefaultConfig() Config {
+	return Config{
+		Parallelism:           runtime.NumCPU(),
+		SpeculationMultiplier: 1.5,
+		SpeculationQuantile:   0.75,
+		SpeculationInterval:   100 * time.Millisecond,
+
+		AdvisoryPartitionBytes: 64 << 20,
+		SkewFactor:             5,
+		SkewThresholdBytes:     256 << 20,
+		BroadcastJoinBytes:     10 << 20,
+	}
+}
+
+// StageInfo records what happened while a stage was running
+type StageInfo struct {
+	ID               int
+	Name             string
+	NumTasks         int
+	Durations        []time.Duration
+	SpeculativeTasks int
+	SpeculativeWins  int
+	Duration         time.Duration
+	Failed           bool
+}
+
+// Median returns the median duration of successful tasks in the stage
+func (si StageInfo) Median() time.Duration {
+	return median(si.Durations)
+}
+
+// Scheduler runs stages of tasks in parallel
+type Scheduler struct {
+	config Config
+
+	mu     sync.Mutex
+	nextID int
+	stages []StageInfo
+}
+
+// New creates a Scheduler, filling zero config fields with defaults
+func New(config Config) *Scheduler {
+	def := DefaultConfig()
+	if config.Parallelism <= 0 {
+		config.Parallelism = def.Parallelism
+	}
+	if config.SpeculationMultiplier <= 0 {
+		config.SpeculationMultiplier = def.SpeculationMultiplier
+	}
+	if config.SpeculationQuantile <= 0 || config.SpeculationQuantile > 1 {
+		config.SpeculationQuantile = def.SpeculationQuantile
+	}
+	if config.SpeculationInterval <= 0 {
+		config.SpeculationInterval = def.SpeculationInterval
+	}
+	if config.AdvisoryPartitionBytes <= 0 {
+		config.AdvisoryPartitionBytes = def.AdvisoryPartitionBytes
+	}
+	if config.SkewFactor <= 0 {
+		config.SkewFactor = def.SkewFactor
+	}
+	if config.SkewThresholdBytes <= 0 {
+		config.SkewThresholdBytes = def.SkewThresholdBytes
+	}
+	if config.BroadcastJoinBytes <= 0 {
+		config.BroadcastJoinBytes = def.BroadcastJoinBytes
+	}
+	return &Scheduler{config: config}
+}
+
+// Config returns the scheduler configuration
+func (s *Scheduler) Config() Config {
+	return s.config
+}
+
+// Stages returns information about all stages run so far
+func (s *Scheduler) Stages() []StageInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]StageInfo, len(s.stages))
+	copy(out, s.stages)
+	return out
+}
+
+// attemptResult is sent by every finished attempt
+type attemptResult struct {
+	partition   int
+	speculative bool
+	data        []interface{}
+	updates     *accumulator.Updates
+	err         error
+	duration    time.Duration
+}
+
+// taskState tracks the attempts of one partition
+type taskState struct {
+	started  atomic.Int64
+	running  int
+	attempts int
+	done     bool
+	cancels  []context.CancelFunc
+	failures []error
+}
+
+// RunStage runs one task per partition and returns their outputs in partition order.
+// With speculation enabled, tasks that run longer than SpeculationMultiplier times the
+// median of finished tasks get a duplicate attempt; the first result wins and the
+// other attempt is cancelled through its context. Accumulator updates are merged only
+// for the winning attempt.
+func (s *Scheduler) RunStage(ctx context.Context, name string, tasks []Task) ([][]interface{}, error) {
+	s.mu.Lock()
+	stageID := s.nextID
+	s.nextID++
+	s.mu.Unlock()
+
+	info := StageInfo{ID: stageID, Name: name, NumTasks: len(tasks)}
+	begin := time.Now()
+	results, err := s.runStage(ctx, stageID, tasks, &info)
+	info.Duration = time.Since(begin)
+	info.Failed = err != nil
+
+	s.mu.Lock()
+	s.stages = append(s.stages, info)
+	s.mu.Unlock()
+	return results, err
+}
+
+func (s *Scheduler) runStage(ctx context.Context, stageID int, tasks []Task, info *StageInfo) ([][]interface{}, error) {
+	results := make([][]interface{}, len(tasks))
+	if len(tasks) == 0 {
+		return results, nil
+	}
+
+	stageCtx, cancelStage := context.WithCancel(ctx)
+	defer cancelStage()
+
+	slots := make(chan struct{}, s.config.Parallelism)
+	// every partition has at most one speculative copy, so losers never block on send
+	finished := make(chan attemptResult, 2*len(tasks))
+	states := make([]*taskState, len(tasks))
+	for i := range states {
+		states[i] = &taskState{}
+	}
+
+	launch := func(partition int, speculative bool) {
+		st := states[partition]
+		attemptCtx, cancel := context.WithCancel(stageCtx)
+		st.cancels = append(st.cancels, cancel)
+		st.running++
+		attempt := st.attempts
+		st.attempts++
+		tc := TaskContext{StageID: stageID, Partition: partition, Attempt: attempt, Speculative: speculative}
+		go func() {
+			select {
+			case slots <- struct{}{}:
+			case <-attemptCtx.Done():
+				finished <- attemptResult{partition: partition, speculative: speculative, err: attemptCtx.Err()}
+				return
+			}
+			start := time.Now()
+			st.started.CompareAndSwap(0, start.UnixNano())
+			updates := accumulator.NewUpdates()
+			taskCtx := accumulator.WithUpdates(context.WithValue(attemptCtx, taskContextKey{}, tc), updates)
+			data, err := runTask(taskCtx, tasks[partition])
+			<-slots
+			finished <- attemptResult{partition: partition, speculative: speculative, data: data, updates: updates, err: err, duration: time.Since(start)}
+		}()
+	}
+
+	for i := range tasks {
+		launch(i, false)
+	}
+
+	var tick <-chan time.Time
+	if s.config.Speculation {
+		ticker := time.NewTicker(s.config.SpeculationInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	remaining := len(tasks)
+	for remaining > 0 {
+		select {
+		case res := <-finished:
+			st := states[res.partition]
+			st.running--
+			if st.done {
+				continue
+			}
+			if res.err != nil {
+				if !errors.Is(res.err, context.Canceled) || ctx.Err() != nil {
+					st.failures = append(st.failures, res.err)
+				}
+				if st.running == 0 {
+					return nil, taskError(stageID, res.partition, st.failures, ctx.Err())
+				}
+				continue
+			}
+			st.done = true
+			for _, cancel := range st.cancels {
+				cancel()
+			}
+			// only the winning attempt's accumulator updates reach the driver
+			res.updates.Merge()
+			results[res.partition] = res.data
+			info.Durations = append(info.Durations, res.duration)
+			if res.speculative {
+				info.SpeculativeWins++
+			}
+			remaining--
+		case <-tick:
+			for _, p := range s.stragglers(states, info.Durations, len(tasks)) {
+				launch(p, true)
+				info.SpeculativeTasks++
+			}
+		}
+	}
+	return results, nil
+}
+
+// stragglers returns the partitions that deserve a speculative attempt
+func (s *Scheduler) stragglers(states []*taskState, durations []time.Duration, total int) []int {
+	if len(durations) == 0 || float64(len(durations)) < s.config.SpeculationQuantile*float64(total) {
+		return nil
+	}
+	threshold := time.Duration(float64(median(durations)) * s.config.SpeculationMultiplier)
+	var out []int
+	for p, st := range states {
+		if st.done || st.running != 1 || st.attempts > 1 {
+			continue
+		}
+		started := st.started.Load()
+		if started != 0 && time.Since(time.Unix(0, started)) > threshold {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// runTask invokes the task, turning panics into errors so one bad partition fails the stage cleanly
+func runTask(ctx context.Context, task Task) (data []interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("task panicked: %v", r)
+		}
+	}()
+	return task(ctx)
+}
+
+func taskError(stageID, partition int, failures []error, ctxErr error) error {
+	if len(failures) == 0 {
+		if ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("stage %d: task %d was cancelled", stageID, partition)
+	}
+	return fmt.Errorf("stage %d: task %d failed: %w", stageID, partition, failures[len(failures)-1])
+}
+
+func median(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
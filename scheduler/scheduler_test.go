@@ -0,0 +1,167 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bajor/spark-go-core/accumulator"
+)
+
+func TestScheduler_RunStageKeepsPartitionOrder(t *testing.T) {
+	s := New(Config{Parallelism: 2})
+
+	tasks := make([]Task, 4)
+	for i := range tasks {
+		i := i
+		tasks[i] = func(ctx context.Context) ([]interface{}, error) {
+			time.Sleep(time.Duration(4-i) * time.Millisecond)
+			return []interface{}{i}, nil
+		}
+	}
+
+	result, err := s.RunStage(context.Background(), "order", tasks)
+	if err != nil {
+		t.Fatalf("RunStage failed with error: %v", err)
+	}
+
+	expected := [][]interface{}{{0}, {1}, {2}, {3}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("RunStage failed: got %v, want %v", result, expected)
+	}
+}
+
+func TestScheduler_TaskFailureFailsStage(t *testing.T) {
+	s := New(Config{})
+
+	tasks := []Task{
+		func(ctx context.Context) ([]interface{}, error) { return []interface{}{1}, nil },
+		func(ctx context.Context) ([]interface{}, error) { return nil, errors.New("boom") },
+	}
+
+	if _, err := s.RunStage(context.Background(), "failing", tasks); err == nil {
+		t.Errorf("Expected stage to fail")
+	}
+
+	stages := s.Stages()
+	if len(stages) != 1 || !stages[0].Failed {
+		t.Errorf("Stage not recorded as failed: %+v", stages)
+	}
+}
+
+func TestScheduler_SpeculatesStragglers(t *testing.T) {
+	s := New(Config{
+		Parallelism:           8,
+		Speculation:           true,
+		SpeculationMultiplier: 2,
+		SpeculationQuantile:   0.5,
+		SpeculationInterval:   5 * time.Millisecond,
+	})
+
+	var cancelled int32
+	tasks := make([]Task, 4)
+	for i := range tasks {
+		i := i
+		tasks[i] = func(ctx context.Context) ([]interface{}, error) {
+			tc, _ := TaskContextFrom(ctx)
+			if i == 3 && !tc.Speculative {
+				// The first attempt of the last partition is a straggler
+				select {
+				case <-time.After(5 * time.Second):
+					return []interface{}{"slow"}, nil
+				case <-ctx.Done():
+					atomic.AddInt32(&cancelled, 1)
+					return nil, ctx.Err()
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			return []interface{}{i}, nil
+		}
+	}
+
+	start := time.Now()
+	result, err := s.RunStage(context.Background(), "speculative", tasks)
+	if err != nil {
+		t.Fatalf("RunStage failed with error: %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Straggler was not speculated, stage took %v", time.Since(start))
+	}
+
+	expected := [][]interface{}{{0}, {1}, {2}, {3}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Speculative result not used: got %v, want %v", result, expected)
+	}
+
+	info := s.Stages()[0]
+	if info.SpeculativeTasks != 1 || info.SpeculativeWins != 1 {
+		t.Errorf("Wrong speculation counters: %+v", info)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&cancelled) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.LoadInt32(&cancelled) != 1 {
+		t.Errorf("Losing attempt was not cancelled")
+	}
+}
+
+func TestScheduler_NoSpeculationWhenDisabled(t *testing.T) {
+	s := New(Config{SpeculationInterval: time.Millisecond})
+
+	tasks := []Task{
+		func(ctx context.Context) ([]interface{}, error) { return []interface{}{1}, nil },
+		func(ctx context.Context) ([]interface{}, error) {
+			time.Sleep(30 * time.Millisecond)
+			return []interface{}{2}, nil
+		},
+	}
+
+	if _, err := s.RunStage(context.Background(), "plain", tasks); err != nil {
+		t.Fatalf("RunStage failed with error: %v", err)
+	}
+	if info := s.Stages()[0]; info.SpeculativeTasks != 0 {
+		t.Errorf("Speculation launched while disabled: %+v", info)
+	}
+}
+
+func TestScheduler_AccumulatorsCountWinningAttemptsOnly(t *testing.T) {
+	s := New(Config{
+		Parallelism:           8,
+		Speculation:           true,
+		SpeculationMultiplier: 2,
+		SpeculationQuantile:   0.5,
+		SpeculationInterval:   5 * time.Millisecond,
+	})
+
+	records := accumulator.Long("records")
+	tasks := make([]Task, 4)
+	for i := range tasks {
+		i := i
+		tasks[i] = func(ctx context.Context) ([]interface{}, error) {
+			tc, _ := TaskContextFrom(ctx)
+			records.Add(ctx, 10)
+			if i == 3 && !tc.Speculative {
+				// The straggler has already counted its records when it loses
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			time.Sleep(10 * time.Millisecond)
+			return []interface{}{i}, nil
+		}
+	}
+
+	if _, err := s.RunStage(context.Background(), "accumulate", tasks); err != nil {
+		t.Fatalf("RunStage failed with error: %v", err)
+	}
+	if info := s.Stages()[0]; info.SpeculativeWins != 1 {
+		t.Fatalf("Expected the speculative attempt to win: %+v", info)
+	}
+	if records.Value() != 40 {
+		t.Errorf("Accumulator counted losing attempts: got %d, want 40", records.Value())
+	}
+}
@@ -0,0 +1,59 @@
+package schema
+
+// Inferrer derives a schema from sampled records. Columns keep the order in which
+// they were first seen; a column missing from a record or holding null is nullable,
+// and a column that held nothing but nulls becomes String.
+type Inferrer struct {
+	names   []string
+	types   map[string]Type
+	counts  map[string]int
+	nulls   map[string]bool
+	records int
+}
+
+// NewInferrer creates an Inferrer, optionally with the columns known upfront
+func NewInferrer(columns ...string) *Inferrer {
+	i := &Inferrer{types: make(map[string]Type), counts: make(map[string]int), nulls: make(map[string]bool)}
+	for _, c := range columns {
+		i.column(c)
+	}
+	return i
+}
+
+func (i *Inferrer) column(name string) {
+	if _, ok := i.types[name]; !ok {
+		i.names = append(i.names, name)
+		i.types[name] = Null
+	}
+}
+
+// Add observes the types of one record's values
+func (i *Inferrer) Add(record map[string]Type) {
+	i.records++
+	for name, t := range record {
+		i.column(name)
+		i.counts[name]++
+		if t == Null {
+			i.nulls[name] = true
+		}
+		i.types[name] = Merge(i.types[name], t)
+	}
+}
+
+// Records returns the number of records observed
+func (i *Inferrer) Records() int {
+	return i.records
+}
+
+// Schema returns the inferred schema
+func (i *Inferrer) Schema() Schema {
+	s := Schema{Fields: make([]Field, len(i.names))}
+	for n, name := range i.names {
+		t := i.types[name]
+		if t == Null {
+			t = String
+		}
+		s.Fields[n] = Field{Name: name, Type: t, Nullable: i.nulls[name] || i.counts[name] < i.records}
+	}
+	return s
+}
@@ -0,0 +1,242 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Type is the type of a column
+type Type int
+
+const (
+	// Null is the type of a column that held only nulls so far
+	Null Type = iota
+	Bool
+	Long
+	Double
+	String
+	// Any holds nested or otherwise untyped values as decoded, e.g. JSON objects
+	Any
+)
+
+var typeNames = []string{"null", "boolean", "long", "double", "string", "any"}
+
+func (t Type) String() string {
+	if int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+	return fmt.Sprintf("Type(%d)", int(t))
+}
+
+// ParseType returns the type with the given name
+func ParseType(name string) (Type, error) {
+	for i, n := range typeNames {
+		if strings.EqualFold(n, name) {
+			return Type(i), nil
+		}
+	}
+	return Null, fmt.Errorf("unknown type %q", name)
+}
+
+func (t Type) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+func (t *Type) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	parsed, err := ParseType(name)
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// Field is a named, typed column
+type Field struct {
+	Name     string `json:"name"`
+	Type     Type   `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// Schema lists the columns of structured records in order
+type Schema struct {
+	Fields []Field `json:"fields"`
+}
+
+// New creates a schema from its fields
+func New(fields ...Field) Schema {
+	return Schema{Fields: fields}
+}
+
+// Names returns the column names in order
+func (s Schema) Names() []string {
+	names := make([]string, len(s.Fields))
+	for i, f := range s.Fields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// Index returns the position of a column, or -1 if there is none with that name
+func (s Schema) Index(name string) int {
+	for i, f := range s.Fields {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Field returns the column with the given name
+func (s Schema) Field(name string) (Field, bool) {
+	if i := s.Index(name); i >= 0 {
+		return s.Fields[i], true
+	}
+	return Field{}, false
+}
+
+func (s Schema) String() string {
+	parts := make([]string, len(s.Fields))
+	for i, f := range s.Fields {
+		parts[i] = f.Name + " " + f.Type.String()
+		if !f.Nullable {
+			parts[i] += " not null"
+		}
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+// Merge returns the narrowest type able to hold values of both types. Long widens to
+// Double; other conflicts fall back to String, or Any if either side is nested.
+func Merge(a, b Type) Type {
+	switch {
+	case a == b:
+		return a
+	case a == Null:
+		return b
+	case b == Null:
+		return a
+	case a == Any || b == Any:
+		return Any
+	case (a == Long && b == Double) || (a == Double && b == Long):
+		return Double
+	}
+	return String
+}
+
+// InferString returns the type of a text value such as a CSV field
+func InferString(s string) Type {
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Long
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return Double
+	}
+	if _, err := strconv.ParseBool(s); err == nil && (strings.EqualFold(s, "true") || strings.EqualFold(s, "false")) {
+		return Bool
+	}
+	return String
+}
+
+// InferValue returns the type of a decoded value, e.g. from JSON decoded with UseNumber
+func InferValue(v interface{}) Type {
+	switch x := v.(type) {
+	case nil:
+		return Null
+	case bool:
+		return Bool
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return Long
+	case float32, float64:
+		return Double
+	case json.Number:
+		if _, err := x.Int64(); err == nil {
+			return Long
+		}
+		return Double
+	case string:
+		return String
+	}
+	return Any
+}
+
+// Parse converts a text value to the type
+func (t Type) Parse(s string) (interface{}, error) {
+	switch t {
+	case Bool:
+		return strconv.ParseBool(s)
+	case Long:
+		return strconv.ParseInt(s, 10, 64)
+	case Double:
+		return strconv.ParseFloat(s, 64)
+	case Null:
+		if s != "" {
+			return nil, fmt.Errorf("cannot use %q as null", s)
+		}
+		return nil, nil
+	}
+	return s, nil
+}
+
+// Convert casts a decoded value to the type
+func (t Type) Convert(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if n, ok := v.(json.Number); ok {
+		switch t {
+		case Long:
+			return n.Int64()
+		case Double:
+			return n.Float64()
+		case String:
+			return n.String(), nil
+		case Any:
+			return n.Float64()
+		}
+		return nil, fmt.Errorf("cannot use %s as %s", n, t)
+	}
+	switch t {
+	case Any:
+		return v, nil
+	case String:
+		switch x := v.(type) {
+		case string:
+			return x, nil
+		case bool, int64, float64:
+			return fmt.Sprint(x), nil
+		}
+	case Bool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case Long:
+		switch x := v.(type) {
+		case int64:
+			return x, nil
+		case int:
+			return int64(x), nil
+		case float64:
+			if x == math.Trunc(x) {
+				return int64(x), nil
+			}
+		}
+	case Double:
+		switch x := v.(type) {
+		case float64:
+			return x, nil
+		case int64:
+			return float64(x), nil
+		case int:
+			return float64(x), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot use %v (%T) as %s", v, v, t)
+}
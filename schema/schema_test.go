@@ -0,0 +1,121 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMerge_WidensTypes(t *testing.T) {
+	tests := []struct {
+		a, b, expected Type
+	}{
+		{Long, Long, Long},
+		{Null, Long, Long},
+		{Long, Double, Double},
+		{Long, Bool, String},
+		{String, Any, Any},
+	}
+	for _, test := range tests {
+		if got := Merge(test.a, test.b); got != test.expected {
+			t.Errorf("Merge(%s, %s) = %s, expected %s", test.a, test.b, got, test.expected)
+		}
+	}
+}
+
+func TestInferrer_Schema(t *testing.T) {
+	i := NewInferrer("id")
+	i.Add(map[string]Type{"id": Long, "score": Long, "name": Null})
+	i.Add(map[string]Type{"id": Long, "score": Double})
+
+	expected := New(
+		Field{Name: "id", Type: Long},
+		Field{Name: "name", Type: String, Nullable: true},
+		Field{Name: "score", Type: Double},
+	)
+	got := i.Schema()
+	// map iteration makes the order of new columns arbitrary, so compare by name
+	if len(got.Fields) != len(expected.Fields) || got.Fields[0] != expected.Fields[0] {
+		t.Fatalf("Expected %s, got %s", expected, got)
+	}
+	for _, f := range expected.Fields {
+		if g, ok := got.Field(f.Name); !ok || g != f {
+			t.Errorf("Expected field %+v, got %+v", f, g)
+		}
+	}
+}
+
+func TestSchema_JSONRoundTrip(t *testing.T) {
+	s := New(Field{Name: "a", Type: Long}, Field{Name: "b", Type: Any, Nullable: true})
+	raw, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back Schema
+	if err := json.Unmarshal(raw, &back); err != nil {
+		t.Fatalf("Unmarshal failed with error: %v", err)
+	}
+	if !reflect.DeepEqual(s, back) {
+		t.Errorf("Expected %s, got %s", s, back)
+	}
+}
+
+func TestType_Convert(t *testing.T) {
+	if v, err := Long.Convert(json.Number("42")); err != nil || v != int64(42) {
+		t.Errorf("Expected 42, got %v (%v)", v, err)
+	}
+	if v, err := Double.Convert(int64(3)); err != nil || v != 3.0 {
+		t.Errorf("Expected 3.0, got %v (%v)", v, err)
+	}
+	if _, err := Long.Convert("x"); err == nil {
+		t.Error("Expected converting a string to long to fail")
+	}
+}
+
+type person struct {
+	Name  string `col:"name"`
+	Age   int    `json:"age"`
+	Email *string
+	skip  int
+}
+
+func TestDecode_MatchesTagsAndNames(t *testing.T) {
+	got, err := Decode[person](map[string]interface{}{"name": "ada", "age": int64(36), "email": "a@b", "other": 1})
+	if err != nil {
+		t.Fatalf("Decode failed with error: %v", err)
+	}
+	if got.Name != "ada" || got.Age != 36 || got.Email == nil || *got.Email != "a@b" {
+		t.Errorf("Unexpected %+v", got)
+	}
+
+	if _, err := Decode[person](map[string]interface{}{"age": "old"}); err == nil {
+		t.Error("Expected decoding a string into an int to fail")
+	}
+}
+
+func TestEncode_UsesColumnNames(t *testing.T) {
+	got, err := Encode(&person{Name: "ada", Age: 36})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"name": "ada", "age": 36, "Email": (*string)(nil)}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	if cols := Columns(reflect.TypeOf(person{})); !reflect.DeepEqual(cols, []string{"name", "age", "Email"}) {
+		t.Errorf("Unexpected columns %v", cols)
+	}
+}
+
+func TestOf_DerivesStructSchema(t *testing.T) {
+	got, err := Of(reflect.TypeOf(&person{}))
+	if err != nil {
+		t.Fatalf("Of failed with error: %v", err)
+	}
+	if want := "(name string not null, age long not null, Email string)"; got.String() != want {
+		t.Errorf("Schema of person: got %s, want %s", got, want)
+	}
+	if _, err := Of(reflect.TypeOf(1)); err == nil {
+		t.Error("Expected a schema of an int to be rejected")
+	}
+}
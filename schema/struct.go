@@ -0,0 +1,202 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Decode fills a struct of type T from a record map. Struct fields match columns by
+// their `col` tag, then their `json` tag, then their name ignoring case; unmatched
+// columns are ignored and null values leave the zero value.
+func Decode[T any](record interface{}) (T, error) {
+	var out T
+	m, ok := record.(map[string]interface{})
+	if !ok {
+		return out, fmt.Errorf("cannot decode %T into %T", record, out)
+	}
+	v := reflect.ValueOf(&out).Elem()
+	if v.Kind() != reflect.Struct {
+		return out, fmt.Errorf("cannot decode records into %T, want a struct", out)
+	}
+	for name, field := range structFields(v.Type()) {
+		value, ok := lookup(m, name)
+		if !ok {
+			continue
+		}
+		if err := assign(v.FieldByIndex(field.Index), value); err != nil {
+			return out, fmt.Errorf("column %s: %w", name, err)
+		}
+	}
+	return out, nil
+}
+
+// DecodeFunc returns a map function decoding records into T, for use with Map
+func DecodeFunc[T any]() func(interface{}) (interface{}, error) {
+	return func(record interface{}) (interface{}, error) {
+		return Decode[T](record)
+	}
+}
+
+// Encode turns a struct, or a pointer to one, into a record map using the same
+// column names as Decode. Maps are returned as they are.
+func Encode(v interface{}) (map[string]interface{}, error) {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m, nil
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot encode %T as a record", v)
+	}
+	out := make(map[string]interface{})
+	for name, field := range structFields(rv.Type()) {
+		out[name] = rv.FieldByIndex(field.Index).Interface()
+	}
+	return out, nil
+}
+
+// Columns returns the column names of a struct type in field order
+func Columns(t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := columnName(t.Field(i)); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Of returns the schema of a struct type, one column per field Columns names.
+// Integer fields are Long, floats Double, strings String and booleans Bool; other
+// fields are Any. Pointer fields are nullable.
+func Of(t reflect.Type) (Schema, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return Schema{}, fmt.Errorf("cannot derive a schema from %v, want a struct", t)
+	}
+	var fields []Field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, ok := columnName(f)
+		if !ok {
+			continue
+		}
+		ft, nullable := f.Type, false
+		if ft.Kind() == reflect.Pointer {
+			ft, nullable = ft.Elem(), true
+		}
+		fields = append(fields, Field{Name: name, Type: kindType(ft), Nullable: nullable})
+	}
+	return New(fields...), nil
+}
+
+func kindType(t reflect.Type) Type {
+	switch t.Kind() {
+	case reflect.Bool:
+		return Bool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Long
+	case reflect.Float32, reflect.Float64:
+		return Double
+	case reflect.String:
+		return String
+	}
+	return Any
+}
+
+func structFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if name, ok := columnName(f); ok {
+			fields[name] = f
+		}
+	}
+	return fields
+}
+
+// columnName returns the column a struct field maps to; unexported fields and fields
+// tagged "-" have none
+func columnName(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	for _, key := range []string{"col", "json"} {
+		if tag, ok := f.Tag.Lookup(key); ok {
+			name := strings.Split(tag, ",")[0]
+			if name == "-" {
+				return "", false
+			}
+			if name != "" {
+				return name, true
+			}
+		}
+	}
+	return f.Name, true
+}
+
+// lookup finds a column by exact name, then ignoring case
+func lookup(m map[string]interface{}, name string) (interface{}, bool) {
+	if v, ok := m[name]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func assign(dst reflect.Value, value interface{}) error {
+	if value == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if dst.Kind() == reflect.Pointer {
+		elem := reflect.New(dst.Type().Elem())
+		if err := assign(elem.Elem(), value); err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	}
+	src := reflect.ValueOf(value)
+	if src.Type().AssignableTo(dst.Type()) {
+		dst.Set(src)
+		return nil
+	}
+	if isNumber(src.Kind()) && isNumber(dst.Kind()) {
+		dst.Set(src.Convert(dst.Type()))
+		return nil
+	}
+	// nested values, e.g. JSON objects into structs, go through their JSON form
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, dst.Addr().Interface()); err != nil {
+		return fmt.Errorf("cannot use %v (%T) as %s", value, value, dst.Type())
+	}
+	return nil
+}
+
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
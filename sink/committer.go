@@ -0,0 +1,191 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	// SuccessMarker is written into the output directory once every file is in place
+	SuccessMarker = "_SUCCESS"
+	// tempDir prefixes the directory next to the output directory that holds task
+	// output until the job commits
+	tempDir = "_temporary"
+)
+
+// ErrExists is returned when the output directory already holds files and the job
+// is not allowed to overwrite them
+var ErrExists = errors.New("output directory already exists")
+
+// ErrJobClosed is returned to attempts that start writing after the job committed or
+// aborted, e.g. a speculative attempt that lost the race
+var ErrJobClosed = errors.New("output job is closed")
+
+// Committer implements the commit protocol of one write job. Each task attempt writes
+// into its own directory below _temporary_<name>, next to the output directory; the
+// first attempt of a partition to commit renames its directory into place and later
+// ones are discarded, so retried and speculative attempts never leave duplicate or
+// partial files. CommitJob assembles the committed files and the _SUCCESS marker in
+// the staging directory and renames it to the output directory at once.
+type Committer struct {
+	dir  string
+	temp string
+
+	mu        sync.Mutex
+	closed    bool
+	committed map[int]bool
+	running   sync.WaitGroup
+}
+
+// NewCommitter prepares dir for a write job. An existing non-empty directory is an
+// error unless overwrite is set, in which case it is removed. A staging directory
+// left by a job that crashed is removed too.
+func NewCommitter(dir string, overwrite bool) (*Committer, error) {
+	dir = filepath.Clean(dir)
+	entries, err := os.ReadDir(dir)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case len(entries) > 0 && !overwrite:
+		return nil, fmt.Errorf("%w: %s", ErrExists, dir)
+	case len(entries) > 0:
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	temp := filepath.Join(filepath.Dir(dir), tempDir+"_"+filepath.Base(dir))
+	if err := os.RemoveAll(temp); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(temp, 0o755); err != nil {
+		return nil, err
+	}
+	return &Committer{dir: dir, temp: temp, committed: make(map[int]bool)}, nil
+}
+
+// Dir returns the output directory
+func (c *Committer) Dir() string {
+	return c.dir
+}
+
+func (c *Committer) attemptDir(partition, attempt int) string {
+	return filepath.Join(c.temp, fmt.Sprintf("attempt_%05d_%d", partition, attempt))
+}
+
+func (c *Committer) taskDir(partition int) string {
+	return filepath.Join(c.temp, fmt.Sprintf("task_%05d", partition))
+}
+
+// SetupTask creates the directory an attempt writes its files to. Every successful
+// call must be followed by CommitTask or AbortTask.
+func (c *Committer) SetupTask(partition, attempt int) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return "", ErrJobClosed
+	}
+	dir := c.attemptDir(partition, attempt)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	c.running.Add(1)
+	return dir, nil
+}
+
+// CommitTask makes an attempt's files the output of its partition. It reports false,
+// and discards the files, if another attempt of the partition committed first.
+func (c *Committer) CommitTask(partition, attempt int) (bool, error) {
+	defer c.running.Done()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dir := c.attemptDir(partition, attempt)
+	if c.committed[partition] {
+		return false, os.RemoveAll(dir)
+	}
+	if err := os.Rename(dir, c.taskDir(partition)); err != nil {
+		os.RemoveAll(dir)
+		return false, err
+	}
+	c.committed[partition] = true
+	return true, nil
+}
+
+// AbortTask discards an attempt's files
+func (c *Committer) AbortTask(partition, attempt int) error {
+	defer c.running.Done()
+	return os.RemoveAll(c.attemptDir(partition, attempt))
+}
+
+// close stops new attempts and waits for the ones writing to finish
+func (c *Committer) close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.running.Wait()
+}
+
+// CommitJob moves the files of every committed task and the _SUCCESS marker into a
+// staged output directory, then replaces the empty output directory with it in one
+// rename. A crash before that rename leaves the output directory empty, never
+// partial.
+func (c *Committer) CommitJob() error {
+	c.close()
+	tasks, err := filepath.Glob(filepath.Join(c.temp, "task_*"))
+	if err != nil {
+		return err
+	}
+	staged := filepath.Join(c.temp, "output")
+	if err := os.MkdirAll(staged, 0o755); err != nil {
+		return err
+	}
+	sort.Strings(tasks)
+	for _, task := range tasks {
+		err := filepath.WalkDir(task, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(task, path)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(staged, rel)
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if _, err := os.Stat(target); err == nil {
+				return fmt.Errorf("%s is written by two tasks", rel)
+			}
+			return os.Rename(path, target)
+		})
+		if err != nil {
+			return fmt.Errorf("commit %s: %w", filepath.Base(task), err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(staged, SuccessMarker), nil, 0o644); err != nil {
+		return err
+	}
+	// the output directory is empty unless something else wrote to it meanwhile,
+	// which makes Remove fail rather than lose those files
+	if err := os.Remove(c.dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("replace %s: %w", c.dir, err)
+	}
+	if err := os.Rename(staged, c.dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(c.temp)
+}
+
+// AbortJob removes the staging directory, leaving the output directory empty
+func (c *Committer) AbortJob() error {
+	c.close()
+	return os.RemoveAll(c.temp)
+}
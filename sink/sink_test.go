@@ -0,0 +1,277 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bajor/spark-go-core/formats"
+)
+
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestCommitter_FirstAttemptWins(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	c, err := NewCommitter(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, attempt := range []int{0, 1} {
+		tmp, err := c.SetupTask(0, attempt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tmp, "part-00000"), []byte{byte('a' + attempt)}, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := c.CommitTask(0, 1); !ok || err != nil {
+		t.Fatalf("Expected attempt 1 to commit, got %v, %v", ok, err)
+	}
+	if ok, err := c.CommitTask(0, 0); ok || err != nil {
+		t.Fatalf("Expected attempt 0 to be discarded, got %v, %v", ok, err)
+	}
+	tmp, err := c.SetupTask(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(tmp, "part-00001"), []byte("partial"), 0o644)
+	c.AbortTask(1, 0)
+
+	if err := c.CommitJob(); err != nil {
+		t.Fatalf("CommitJob failed with error: %v", err)
+	}
+	if files := listFiles(t, dir); !reflect.DeepEqual(files, []string{"_SUCCESS", "part-00000"}) {
+		t.Errorf("Unexpected files %v", files)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "part-00000")); string(content) != "b" {
+		t.Errorf("Expected the first committed attempt's file, got %q", content)
+	}
+	if _, err := c.SetupTask(2, 0); !errors.Is(err, ErrJobClosed) {
+		t.Errorf("Expected ErrJobClosed after commit, got %v", err)
+	}
+}
+
+func TestCommitter_JobCommitIsOneRename(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "out")
+	// a crashed job left its staging directory behind
+	stale := filepath.Join(parent, "_temporary_out", "task_00000")
+	os.MkdirAll(stale, 0o755)
+	os.WriteFile(filepath.Join(stale, "part-00000"), []byte("stale"), 0o644)
+
+	c, err := NewCommitter(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := 0; p < 2; p++ {
+		tmp, err := c.SetupTask(p, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(tmp, fmt.Sprintf("part-%05d", p)), []byte("new"), 0o644)
+		if ok, err := c.CommitTask(p, 0); !ok || err != nil {
+			t.Fatalf("Expected task %d to commit, got %v, %v", p, ok, err)
+		}
+	}
+	if files := listFiles(t, dir); len(files) != 0 {
+		t.Errorf("Expected nothing in the output directory before the job commits, got %v", files)
+	}
+	if err := c.CommitJob(); err != nil {
+		t.Fatalf("CommitJob failed with error: %v", err)
+	}
+	if files := listFiles(t, dir); !reflect.DeepEqual(files, []string{"_SUCCESS", "part-00000", "part-00001"}) {
+		t.Errorf("Unexpected files %v", files)
+	}
+	if entries, _ := os.ReadDir(parent); len(entries) != 1 {
+		t.Errorf("Expected the staging directory to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestCommitter_ExistingDirectory(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "old"), nil, 0o644)
+
+	if _, err := NewCommitter(dir, false); !errors.Is(err, ErrExists) {
+		t.Fatalf("Expected ErrExists, got %v", err)
+	}
+	c, err := NewCommitter(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AbortJob(); err != nil {
+		t.Fatal(err)
+	}
+	if files := listFiles(t, dir); len(files) != 0 {
+		t.Errorf("Expected an empty directory after abort, got %v", files)
+	}
+}
+
+type sale struct {
+	Country string  `col:"country"`
+	Day     int     `col:"day"`
+	Amount  float64 `col:"amount"`
+}
+
+func TestWriteTask_PartitionBy(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sales")
+	c, err := NewCommitter(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []interface{}{
+		sale{Country: "PL", Day: 1, Amount: 1.5},
+		sale{Country: "US/CA", Day: 1, Amount: 2},
+		sale{Country: "PL", Day: 2, Amount: 3},
+	}
+	options := Options{PartitionBy: []string{"country"}}
+	if err := WriteTask(context.Background(), c, 3, 0, records, CSV(formats.CSVWriteOptions{Header: true}), options); err != nil {
+		t.Fatalf("WriteTask failed with error: %v", err)
+	}
+	if err := c.CommitJob(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"_SUCCESS", "country=PL/part-00003.csv", "country=US%2FCA/part-00003.csv"}
+	if files := listFiles(t, dir); !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Expected files %v, got %v", expected, files)
+	}
+	content, _ := os.ReadFile(filepath.Join(dir, "country=PL", "part-00003.csv"))
+	if string(content) != "day,amount\n1,1.5\n2,3\n" {
+		t.Errorf("Unexpected content %q", content)
+	}
+}
+
+func TestWriteTask_CSVColumnsPerFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	c, err := NewCommitter(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := CSV(formats.CSVWriteOptions{Header: true})
+	partitions := [][]interface{}{
+		{map[string]interface{}{"a": 1, "b": 2}},
+		{map[string]interface{}{"c": 3}},
+		{sale{Country: "PL", Day: 1, Amount: 1.5}},
+		{map[string]interface{}{"country": "PL", "note": "x"}},
+	}
+	options := []Options{{}, {}, {PartitionBy: []string{"country"}}, {PartitionBy: []string{"country"}}}
+	errs := make(chan error, 2)
+	for p := 0; p < 2; p++ {
+		go func(p int) {
+			errs <- WriteTask(context.Background(), c, p, 0, partitions[p], format, options[p])
+		}(p)
+	}
+	for p := 0; p < 2; p++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("WriteTask failed with error: %v", err)
+		}
+	}
+	// a struct file with columns picked by the sink must not fix those of later files
+	for p := 2; p < 4; p++ {
+		if err := WriteTask(context.Background(), c, p, 0, partitions[p], format, options[p]); err != nil {
+			t.Fatalf("WriteTask failed with error: %v", err)
+		}
+	}
+	if err := c.CommitJob(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"part-00000.csv":            "a,b\n1,2\n",
+		"part-00001.csv":            "c\n3\n",
+		"country=PL/part-00002.csv": "day,amount\n1,1.5\n",
+		"country=PL/part-00003.csv": "note\nx\n",
+	}
+	for name, want := range expected {
+		content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want {
+			t.Errorf("%s: got %q, want %q", name, content, want)
+		}
+	}
+}
+
+func TestWriteTask_CancelledAttemptLeavesNothing(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	c, err := NewCommitter(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := WriteTask(ctx, c, 0, 0, []interface{}{"a"}, Text, Options{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the cancelled attempt to fail, got %v", err)
+	}
+	if err := WriteTask(context.Background(), c, 0, 0, []interface{}{1}, Text, Options{PartitionBy: []string{"x"}}); err == nil {
+		t.Fatal("Expected partitioning scalar records to fail")
+	}
+	if err := c.CommitJob(); err != nil {
+		t.Fatal(err)
+	}
+	if files := listFiles(t, dir); !reflect.DeepEqual(files, []string{"_SUCCESS"}) {
+		t.Errorf("Unexpected files %v", files)
+	}
+}
+
+func TestPartitionValue(t *testing.T) {
+	tests := map[interface{}]string{
+		nil:        DefaultPartitionValue,
+		"":         DefaultPartitionValue,
+		"a b":      "a b",
+		"x=1/y":    "x%3D1%2Fy",
+		int64(-42): "-42",
+	}
+	for v, expected := range tests {
+		if got := PartitionValue(v); got != expected {
+			t.Errorf("PartitionValue(%v) = %q, expected %q", v, got, expected)
+		}
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	type point struct {
+		X int `col:"x"`
+		Y int `col:"y"`
+	}
+	var lines, jsonl bytes.Buffer
+	for _, record := range []interface{}{"a", 1, point{1, 2}} {
+		if err := Lines(&lines).Encode(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := "a\n1\n{1 2}\n"; lines.String() != expected {
+		t.Errorf("Lines: expected %q, got %q", expected, lines.String())
+	}
+	enc := JSON(&jsonl)
+	for _, record := range []interface{}{point{1, 2}, map[string]interface{}{"x": 3}} {
+		if err := enc.Encode(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := "{\"x\":1,\"y\":2}\n{\"x\":3}\n"; jsonl.String() != expected {
+		t.Errorf("JSON: expected %q, got %q", expected, jsonl.String())
+	}
+}
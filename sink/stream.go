@@ -0,0 +1,34 @@
+package sink
+
+import (
+	"io"
+
+	"github.com/bajor/spark-go-core/formats"
+)
+
+// Encoder writes records one at a time onto a stream
+type Encoder interface {
+	Encode(record interface{}) error
+}
+
+// EncoderFunc adapts a function to the Encoder interface
+type EncoderFunc func(record interface{}) error
+
+// Encode calls f
+func (f EncoderFunc) Encode(record interface{}) error {
+	return f(record)
+}
+
+// Lines encodes every record as one line of w, like the Text format
+func Lines(w io.Writer) Encoder {
+	return EncoderFunc(func(record interface{}) error {
+		return Text.Write(w, []interface{}{record}, nil)
+	})
+}
+
+// JSON encodes every record as one JSON object per line of w, like the JSONLines format
+func JSON(w io.Writer) Encoder {
+	return EncoderFunc(func(record interface{}) error {
+		return formats.WriteJSONLines(w, []interface{}{record})
+	})
+}
@@ -0,0 +1,225 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/bajor/spark-go-core/formats"
+	"github.com/bajor/spark-go-core/parquet"
+	"github.com/bajor/spark-go-core/schema"
+)
+
+// DefaultPartitionValue names the directory of records whose partition column is null
+const DefaultPartitionValue = "__HIVE_DEFAULT_PARTITION__"
+
+// Format encodes the records of one output file. Columns is the preferred column
+// order of structured records, or nil to let the format choose.
+type Format struct {
+	// Extension is appended to part file names, e.g. ".csv"
+	Extension string
+	Write     func(w io.Writer, records []interface{}, columns []string) error
+}
+
+// Text writes one record per line, strings as they are and other values formatted
+// with fmt
+var Text = Format{Write: func(w io.Writer, records []interface{}, _ []string) error {
+	buf := bufio.NewWriter(w)
+	for _, record := range records {
+		if s, ok := record.(string); ok {
+			buf.WriteString(s)
+		} else {
+			fmt.Fprint(buf, record)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Flush()
+}}
+
+// JSONLines writes one JSON object per line, see formats.WriteJSONLines
+var JSONLines = Format{Extension: ".json", Write: func(w io.Writer, records []interface{}, _ []string) error {
+	return formats.WriteJSONLines(w, records)
+}}
+
+// CSV writes records with formats.WriteCSV. Without explicit columns every file
+// picks its own, so set options.Columns when map records may lack some keys.
+func CSV(options formats.CSVWriteOptions) Format {
+	return Format{Extension: ".csv", Write: func(w io.Writer, records []interface{}, columns []string) error {
+		o := options
+		if o.Columns == nil {
+			o.Columns = columns
+		}
+		return formats.WriteCSV(w, records, o)
+	}}
+}
+
+// Parquet writes records with parquet.WriteRecords. The schema lists the written
+// columns, so it must leave out the columns of Options.PartitionBy.
+func Parquet(s schema.Schema, options parquet.WriteOptions) Format {
+	return Format{Extension: ".parquet", Write: func(w io.Writer, records []interface{}, _ []string) error {
+		return parquet.WriteRecords(w, records, s, options)
+	}}
+}
+
+// Options configures a write job
+type Options struct {
+	// Overwrite replaces an existing output directory instead of failing
+	Overwrite bool
+	// PartitionBy lays files out in Hive-style col=value directories, nested in the
+	// order given. The columns are taken from map or struct records and left out of
+	// the written records.
+	PartitionBy []string
+}
+
+// PartFile returns the name of the file holding a partition's records
+func PartFile(partition int, format Format) string {
+	return fmt.Sprintf("part-%05d%s", partition, format.Extension)
+}
+
+// WriteTask writes the records of one partition as a task attempt and commits them.
+// Output of an attempt that fails, is cancelled or loses to another attempt of the
+// same partition is discarded.
+func WriteTask(ctx context.Context, c *Committer, partition, attempt int, records []interface{}, format Format, options Options) (err error) {
+	dir, err := c.SetupTask(partition, attempt)
+	if err != nil {
+		return err
+	}
+	done := false
+	defer func() {
+		if !done {
+			c.AbortTask(partition, attempt)
+		}
+	}()
+
+	groups, err := group(records, options.PartitionBy)
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(dir, g.dir, PartFile(partition, format)), g.records, g.columns, format); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done = true
+	_, err = c.CommitTask(partition, attempt)
+	return err
+}
+
+func writeFile(path string, records []interface{}, columns []string, format Format) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := format.Write(f, records, columns); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// partitionGroup holds the records written to one partition directory
+type partitionGroup struct {
+	dir     string
+	records []interface{}
+	columns []string
+}
+
+// group splits records by the values of the partition columns. Without partition
+// columns all records form a single group in the root directory.
+func group(records []interface{}, partitionBy []string) ([]*partitionGroup, error) {
+	if len(partitionBy) == 0 {
+		return []*partitionGroup{{records: records}}, nil
+	}
+	var columns []string
+	if len(records) > 0 {
+		if t := reflect.TypeOf(records[0]); t != nil && (t.Kind() == reflect.Struct || (t.Kind() == reflect.Pointer && t.Elem().Kind() == reflect.Struct)) {
+			columns = without(schema.Columns(t), partitionBy)
+		}
+	}
+
+	groups := make(map[string]*partitionGroup)
+	for _, record := range records {
+		m, err := schema.Encode(record)
+		if err != nil {
+			return nil, fmt.Errorf("cannot partition %T by column: %w", record, err)
+		}
+		parts := make([]string, len(partitionBy))
+		rest := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			rest[k] = v
+		}
+		for i, col := range partitionBy {
+			v, ok := m[col]
+			if !ok {
+				return nil, fmt.Errorf("record has no partition column %s", col)
+			}
+			parts[i] = col + "=" + PartitionValue(v)
+			delete(rest, col)
+		}
+		dir := filepath.Join(parts...)
+		g, ok := groups[dir]
+		if !ok {
+			g = &partitionGroup{dir: dir, columns: columns}
+			groups[dir] = g
+		}
+		g.records = append(g.records, rest)
+	}
+
+	out := make([]*partitionGroup, 0, len(groups))
+	for _, g := range groups {
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].dir < out[j].dir })
+	return out, nil
+}
+
+func without(columns, drop []string) []string {
+	out := make([]string, 0, len(columns))
+	for _, c := range columns {
+		keep := true
+		for _, d := range drop {
+			keep = keep && c != d
+		}
+		if keep {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// PartitionValue renders a value as a directory name component, escaping characters
+// that are not safe in paths as %XX
+func PartitionValue(v interface{}) string {
+	if v == nil {
+		return DefaultPartitionValue
+	}
+	s := fmt.Sprint(v)
+	if s == "" {
+		return DefaultPartitionValue
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c == 0x7f || strings.IndexByte("\"#%'*/:=?\\{[]^", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
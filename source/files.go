@@ -0,0 +1,156 @@
+package source
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// file is an input file found by Expand
+type file struct {
+	path string
+	size int64
+	gzip bool
+}
+
+// Expand resolves a comma-separated list of paths, glob patterns and directories into
+// the files they name, in sorted order. Directories contribute the files directly
+// inside them, skipping hidden ones and markers starting with "." or "_".
+func Expand(patterns string) ([]string, error) {
+	files, err := expand(patterns)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths, nil
+}
+
+func expand(patterns string) ([]file, error) {
+	seen := make(map[string]bool)
+	var files []file
+	add := func(path string, info os.FileInfo) error {
+		if seen[path] {
+			return nil
+		}
+		seen[path] = true
+		compressed, err := isGzip(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, file{path: path, size: info.Size(), gzip: compressed})
+		return nil
+	}
+
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", pattern)
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				if err := add(match, info); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			entries, err := os.ReadDir(match)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				if entry.IsDir() || hidden(entry.Name()) {
+					continue
+				}
+				info, err := entry.Info()
+				if err != nil {
+					return nil, err
+				}
+				if err := add(filepath.Join(match, entry.Name()), info); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
+
+func hidden(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// isGzip sniffs the magic bytes, so compressed input is detected whatever its name
+func isGzip(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	head := make([]byte, len(gzipMagic))
+	n, err := io.ReadFull(f, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return n == len(gzipMagic) && head[0] == gzipMagic[0] && head[1] == gzipMagic[1], nil
+}
+
+// OpenFile opens a whole file, transparently decompressing gzip content
+func OpenFile(path string) (io.ReadCloser, error) {
+	compressed, err := isGzip(path)
+	if err != nil {
+		return nil, err
+	}
+	return openFile(file{path: path, gzip: compressed})
+}
+
+// openFile opens a whole file, decompressing it if needed
+func openFile(f file) (io.ReadCloser, error) {
+	raw, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if !f.gzip {
+		return raw, nil
+	}
+	gz, err := gzip.NewReader(bufio.NewReader(raw))
+	if err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("%s: %w", f.path, err)
+	}
+	return &gzipFile{Reader: gz, raw: raw}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	raw *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.raw.Close()
+}
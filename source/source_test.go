@@ -0,0 +1,280 @@
+package source
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeGzip(t *testing.T, path, content string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(content))
+	zw.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAll(t *testing.T, src interface {
+	NumPartitions() int
+	Open(int) (lazy.SourceIterator, error)
+}) []interface{} {
+	t.Helper()
+	all := make([]interface{}, 0)
+	for p := 0; p < src.NumPartitions(); p++ {
+		it, err := src.Open(p)
+		if err != nil {
+			t.Fatalf("Open(%d) failed with error: %v", p, err)
+		}
+		part, err := lazy.Drain(it)
+		if err != nil {
+			t.Fatalf("Reading partition %d failed with error: %v", p, err)
+		}
+		all = append(all, part...)
+	}
+	return all
+}
+
+func TestTextFile_SplitsReadEveryLineOnce(t *testing.T) {
+	var content strings.Builder
+	var expected []interface{}
+	for i := 0; i < 200; i++ {
+		line := strings.Repeat(fmt.Sprint(i%10), i%37)
+		content.WriteString(line + "\n")
+		expected = append(expected, line)
+	}
+	content.WriteString("no trailing newline")
+	expected = append(expected, "no trailing newline")
+	path := filepath.Join(t.TempDir(), "lines.txt")
+	writeFile(t, path, content.String())
+
+	for _, maxSplit := range []int64{1, 7, 64, 1000, 1 << 20} {
+		src, err := TextFile(path, TextOptions{MinPartitions: 3, MaxSplitSize: maxSplit})
+		if err != nil {
+			t.Fatalf("TextFile failed with error: %v", err)
+		}
+		if maxSplit < 1000 && src.NumPartitions() < 3 {
+			t.Errorf("Max split size %d: expected several splits, got %d", maxSplit, src.NumPartitions())
+		}
+		if got := readAll(t, src); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Max split size %d: lines were lost or duplicated, got %d lines, want %d", maxSplit, len(got), len(expected))
+		}
+	}
+}
+
+func TestTextFile_CarriageReturnsAndEmptyFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.txt"), "one\r\ntwo\r\n")
+	writeFile(t, filepath.Join(dir, "b.txt"), "")
+	src, err := TextFile(filepath.Join(dir, "*.txt"), TextOptions{})
+	if err != nil {
+		t.Fatalf("TextFile failed with error: %v", err)
+	}
+	if got := readAll(t, src); !reflect.DeepEqual(got, []interface{}{"one", "two"}) {
+		t.Errorf("Got %q", got)
+	}
+}
+
+func TestTextFile_GzipIsReadTransparently(t *testing.T) {
+	dir := t.TempDir()
+	writeGzip(t, filepath.Join(dir, "part-0.gz"), "a\nb\n")
+	// detection does not depend on the extension
+	writeGzip(t, filepath.Join(dir, "part-1"), "c\n")
+	writeFile(t, filepath.Join(dir, "part-2"), "d\ne\n")
+
+	src, err := TextFile(dir, TextOptions{MinPartitions: 10, MaxSplitSize: 1})
+	if err != nil {
+		t.Fatalf("TextFile failed with error: %v", err)
+	}
+	if got := readAll(t, src); !reflect.DeepEqual(got, []interface{}{"a", "b", "c", "d", "e"}) {
+		t.Errorf("Got %q", got)
+	}
+	compressed := 0
+	for _, s := range src.splits {
+		if s.file.gzip {
+			compressed++
+		}
+	}
+	if compressed != 2 {
+		t.Errorf("Compressed files must not be split: got %d splits of them", compressed)
+	}
+}
+
+func TestExpand_GlobsListsAndDirectories(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"x.log", "y.log", "z.txt", "_SUCCESS", ".hidden"} {
+		writeFile(t, filepath.Join(dir, name), name)
+	}
+	paths, err := Expand(filepath.Join(dir, "*.log") + "," + filepath.Join(dir, "z.txt"))
+	if err != nil {
+		t.Fatalf("Expand failed with error: %v", err)
+	}
+	if len(paths) != 3 {
+		t.Errorf("Expected 3 files, got %v", paths)
+	}
+
+	paths, err = Expand(dir)
+	if err != nil {
+		t.Fatalf("Expand failed with error: %v", err)
+	}
+	for _, p := range paths {
+		if base := filepath.Base(p); base == "_SUCCESS" || base == ".hidden" {
+			t.Errorf("Directory listing should skip %s", base)
+		}
+	}
+
+	if _, err := Expand(filepath.Join(dir, "*.csv")); err == nil {
+		t.Errorf("Expected an error when nothing matches")
+	}
+}
+
+func TestWholeTextFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.txt"), "alpha\nbeta\n")
+	writeGzip(t, filepath.Join(dir, "b.txt.gz"), "gamma")
+	writeFile(t, filepath.Join(dir, "c.txt"), "")
+
+	src, err := WholeTextFiles(filepath.Join(dir, "*"), 2)
+	if err != nil {
+		t.Fatalf("WholeTextFiles failed with error: %v", err)
+	}
+	if src.NumPartitions() != 2 {
+		t.Errorf("Expected 2 partitions, got %d", src.NumPartitions())
+	}
+	expected := []interface{}{
+		map[string]interface{}{"path": filepath.Join(dir, "a.txt"), "content": "alpha\nbeta\n"},
+		map[string]interface{}{"path": filepath.Join(dir, "b.txt.gz"), "content": "gamma"},
+		map[string]interface{}{"path": filepath.Join(dir, "c.txt"), "content": ""},
+	}
+	if got := readAll(t, src); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got %v", got)
+	}
+}
+
+func TestLineIterator_Reset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lines.txt")
+	writeFile(t, path, "a\nb\nc\n")
+	src, err := TextFile(path, TextOptions{})
+	if err != nil {
+		t.Fatalf("TextFile failed with error: %v", err)
+	}
+	it, err := src.Open(0)
+	if err != nil {
+		t.Fatalf("Open failed with error: %v", err)
+	}
+	defer it.Close()
+	first, _ := it.Next()
+	it.Reset()
+	again, _ := it.Next()
+	if first != "a" || again != "a" {
+		t.Errorf("Reset did not restart the split: %v, %v", first, again)
+	}
+}
+
+func TestChannelSource_SpreadsElementsAndReadsOnce(t *testing.T) {
+	ch := make(chan int)
+	go func() {
+		for i := 0; i < 100; i++ {
+			ch <- i
+		}
+		close(ch)
+	}()
+	src := Channel(ch, 3)
+	if src.NumPartitions() != 3 {
+		t.Fatalf("Expected 3 partitions, got %d", src.NumPartitions())
+	}
+	results := make(chan []interface{}, 3)
+	for p := 0; p < 3; p++ {
+		it, err := src.Open(p)
+		if err != nil {
+			t.Fatalf("Open(%d) failed with error: %v", p, err)
+		}
+		go func() {
+			part, _ := lazy.Drain(it)
+			results <- part
+		}()
+	}
+	seen := make(map[int]bool)
+	for p := 0; p < 3; p++ {
+		for _, v := range <-results {
+			seen[v.(int)] = true
+		}
+	}
+	if len(seen) != 100 {
+		t.Errorf("Expected every element once, got %d distinct", len(seen))
+	}
+	if _, err := src.Open(0); !errors.Is(err, ErrConsumed) {
+		t.Errorf("Expected ErrConsumed opening a partition again, got %v", err)
+	}
+}
+
+func TestReaderSource_DecodersAndErrors(t *testing.T) {
+	src := Reader(strings.NewReader("one\r\ntwo\n\nthree"), Lines)
+	it, err := src.Open(0)
+	if err != nil {
+		t.Fatalf("Open failed with error: %v", err)
+	}
+	lines, err := lazy.Drain(it)
+	if err != nil {
+		t.Fatalf("Reading lines failed with error: %v", err)
+	}
+	if expected := []interface{}{"one", "two", "", "three"}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %v, got %v", expected, lines)
+	}
+	if _, err := src.Open(0); !errors.Is(err, ErrConsumed) {
+		t.Errorf("Expected ErrConsumed opening the reader again, got %v", err)
+	}
+
+	type event struct {
+		ID   int    `json:"id"`
+		Kind string `json:"kind"`
+	}
+	it = ReaderIterator(strings.NewReader(`{"id":1,"kind":"a"}`+"\n"+`{"id":2,"kind":"b"} {"id":`), JSON[event])
+	events, err := lazy.Drain(it)
+	if err == nil {
+		t.Error("Expected an error for the truncated value")
+	}
+	if expected := []interface{}{event{1, "a"}, event{2, "b"}}; !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected %v before the error, got %v", expected, events)
+	}
+}
+
+func TestChannelIterator_FeedsLazyChain(t *testing.T) {
+	ch := make(chan int, 10)
+	for i := 1; i <= 10; i++ {
+		ch <- i
+	}
+	close(ch)
+	var sum int
+	err := lazy.NewIteratorChain(ChannelIterator(ch)).
+		AddFilter(func(i interface{}) bool { return i.(int)%2 == 0 }).
+		ForEach(func(i interface{}) error {
+			sum += i.(int)
+			return nil
+		})
+	if err != nil || sum != 30 {
+		t.Errorf("Expected the even elements to sum to 30, got %d (%v)", sum, err)
+	}
+
+	_, err = lazy.NewIteratorChain(ReaderIterator(strings.NewReader(`[1] [`), JSON[[]int])).Collect()
+	if err == nil {
+		t.Error("Expected Collect to report the decoding error")
+	}
+}
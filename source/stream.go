@@ -0,0 +1,195 @@
+package source
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+)
+
+// ErrConsumed is returned when a partition of a stream source is opened a second time;
+// channels and readers cannot be replayed
+var ErrConsumed = errors.New("stream partition was already read")
+
+// Decoder reads one record per call from a stream, returning io.EOF after the last
+type Decoder interface {
+	Decode() (interface{}, error)
+}
+
+// DecoderFunc adapts a function to the Decoder interface
+type DecoderFunc func() (interface{}, error)
+
+// Decode calls f
+func (f DecoderFunc) Decode() (interface{}, error) {
+	return f()
+}
+
+// Lines decodes one string per line, without the line ending. A last line without
+// a trailing newline is still returned.
+func Lines(r io.Reader) Decoder {
+	br := bufio.NewReader(r)
+	return DecoderFunc(func() (interface{}, error) {
+		line, err := br.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+	})
+}
+
+// JSON decodes a stream of JSON values, e.g. JSON lines, into values of type T. With
+// T being interface{} or a map, numbers are decoded as float64.
+func JSON[T any](r io.Reader) Decoder {
+	dec := json.NewDecoder(r)
+	return DecoderFunc(func() (interface{}, error) {
+		var v T
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+}
+
+// ChannelSource reads the elements received from a channel. Each of its partitions
+// drains the shared channel until it is closed, so the elements are spread over the
+// partitions in no particular order. The channel is consumed once: opening a
+// partition again fails with ErrConsumed.
+type ChannelSource struct {
+	receive func() (interface{}, bool)
+	opened  []bool
+	mu      sync.Mutex
+}
+
+// Channel creates a source over ch with the given number of partitions, at least one.
+// Tasks read ch while the producer sends, so a slow job holds the producer back.
+func Channel[T any](ch <-chan T, partitions int) *ChannelSource {
+	if partitions < 1 {
+		partitions = 1
+	}
+	return &ChannelSource{receive: receiver(ch), opened: make([]bool, partitions)}
+}
+
+func receiver[T any](ch <-chan T) func() (interface{}, bool) {
+	return func() (interface{}, bool) {
+		v, ok := <-ch
+		return v, ok
+	}
+}
+
+// NumPartitions returns the number of partitions reading the channel
+func (s *ChannelSource) NumPartitions() int {
+	return len(s.opened)
+}
+
+// Open returns an iterator receiving from the channel until it is closed
+func (s *ChannelSource) Open(partition int) (lazy.SourceIterator, error) {
+	if partition < 0 || partition >= len(s.opened) {
+		return nil, fmt.Errorf("channel source has no partition %d", partition)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opened[partition] {
+		return nil, fmt.Errorf("channel partition %d: %w", partition, ErrConsumed)
+	}
+	s.opened[partition] = true
+	return &streamIterator{next: func() (interface{}, bool, error) {
+		v, ok := s.receive()
+		return v, ok, nil
+	}}, nil
+}
+
+// ChannelIterator returns an iterator over the elements received from ch, e.g. to
+// build a lazy.LazyChain with lazy.NewIteratorChain. Reset does nothing.
+func ChannelIterator[T any](ch <-chan T) lazy.SourceIterator {
+	receive := receiver(ch)
+	return &streamIterator{next: func() (interface{}, bool, error) {
+		v, ok := receive()
+		return v, ok, nil
+	}}
+}
+
+// ReaderSource reads the records decoded from an io.Reader as a single partition,
+// which can be opened once
+type ReaderSource struct {
+	r          io.Reader
+	newDecoder func(io.Reader) Decoder
+	opened     bool
+	mu         sync.Mutex
+}
+
+// Reader creates a source decoding records from r with a decoder such as Lines or
+// JSON[T]. The reader is not closed.
+func Reader(r io.Reader, newDecoder func(io.Reader) Decoder) *ReaderSource {
+	return &ReaderSource{r: r, newDecoder: newDecoder}
+}
+
+// NumPartitions returns 1
+func (s *ReaderSource) NumPartitions() int {
+	return 1
+}
+
+// Open returns an iterator decoding records until the end of the stream
+func (s *ReaderSource) Open(partition int) (lazy.SourceIterator, error) {
+	if partition != 0 {
+		return nil, fmt.Errorf("reader source has no partition %d", partition)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opened {
+		return nil, fmt.Errorf("reader: %w", ErrConsumed)
+	}
+	s.opened = true
+	return ReaderIterator(s.r, s.newDecoder), nil
+}
+
+// ReaderIterator returns an iterator over the records decoded from r. Reset does
+// nothing.
+func ReaderIterator(r io.Reader, newDecoder func(io.Reader) Decoder) lazy.SourceIterator {
+	dec := newDecoder(r)
+	return &streamIterator{next: func() (interface{}, bool, error) {
+		v, err := dec.Decode()
+		if err == io.EOF {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		return v, true, nil
+	}}
+}
+
+// streamIterator reads from a stream that cannot be replayed
+type streamIterator struct {
+	next func() (interface{}, bool, error)
+	done bool
+	err  error
+}
+
+func (it *streamIterator) Next() (interface{}, bool) {
+	if it.done {
+		return nil, false
+	}
+	v, ok, err := it.next()
+	if err != nil || !ok {
+		it.done, it.err = true, err
+		return nil, false
+	}
+	return v, true
+}
+
+func (it *streamIterator) Err() error {
+	return it.err
+}
+
+// Close stops the iterator; the underlying channel or reader belongs to the caller
+func (it *streamIterator) Close() error {
+	it.done = true
+	return nil
+}
+
+func (it *streamIterator) Reset() {}
@@ -0,0 +1,190 @@
+package source
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+)
+
+// DefaultMaxSplitSize caps the bytes of an uncompressed file read by one partition
+const DefaultMaxSplitSize = 32 << 20
+
+// splitSlop lets the last split of a file grow by this factor instead of leaving a
+// tiny remainder as its own partition
+const splitSlop = 1.1
+
+// TextOptions configures a text file source
+type TextOptions struct {
+	// MinPartitions is the least number of splits wanted, defaults to 1
+	MinPartitions int
+	// MaxSplitSize caps the bytes of a split, defaults to DefaultMaxSplitSize
+	MaxSplitSize int64
+}
+
+// split is a byte range of a file; compressed files are always read whole
+type split struct {
+	file       file
+	start, end int64
+}
+
+// TextSource reads the lines of files without their terminators. Uncompressed files
+// are divided into byte ranges; a line belongs to the range holding its first byte,
+// so every line is read exactly once whatever the split boundaries.
+type TextSource struct {
+	splits []split
+}
+
+// TextFile creates a source over the files matched by patterns, see Expand
+func TextFile(patterns string, options TextOptions) (*TextSource, error) {
+	files, err := expand(patterns)
+	if err != nil {
+		return nil, err
+	}
+	if options.MinPartitions < 1 {
+		options.MinPartitions = 1
+	}
+	if options.MaxSplitSize <= 0 {
+		options.MaxSplitSize = DefaultMaxSplitSize
+	}
+
+	var total int64
+	for _, f := range files {
+		if !f.gzip {
+			total += f.size
+		}
+	}
+	splitSize := (total + int64(options.MinPartitions) - 1) / int64(options.MinPartitions)
+	if splitSize > options.MaxSplitSize {
+		splitSize = options.MaxSplitSize
+	}
+	if splitSize < 1 {
+		splitSize = 1
+	}
+
+	s := &TextSource{}
+	for _, f := range files {
+		if f.gzip || f.size == 0 {
+			s.splits = append(s.splits, split{file: f, start: 0, end: f.size})
+			continue
+		}
+		start := int64(0)
+		for float64(f.size-start) > float64(splitSize)*splitSlop {
+			s.splits = append(s.splits, split{file: f, start: start, end: start + splitSize})
+			start += splitSize
+		}
+		s.splits = append(s.splits, split{file: f, start: start, end: f.size})
+	}
+	return s, nil
+}
+
+// NumPartitions returns the number of splits
+func (s *TextSource) NumPartitions() int {
+	return len(s.splits)
+}
+
+// Split returns the file and byte range a partition reads; a compressed file is
+// always read whole
+func (s *TextSource) Split(partition int) (path string, start, end int64) {
+	sp := s.splits[partition]
+	return sp.file.path, sp.start, sp.end
+}
+
+// Open returns an iterator over the lines of one split
+func (s *TextSource) Open(partition int) (lazy.SourceIterator, error) {
+	if partition < 0 || partition >= len(s.splits) {
+		return nil, fmt.Errorf("text source has no partition %d", partition)
+	}
+	it := &lineIterator{split: s.splits[partition]}
+	if err := it.open(); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// lineIterator streams the lines of a split
+type lineIterator struct {
+	split  split
+	closer io.Closer
+	reader *bufio.Reader
+	pos    int64
+	err    error
+}
+
+func (it *lineIterator) open() error {
+	if it.split.file.gzip {
+		rc, err := openFile(it.split.file)
+		if err != nil {
+			return err
+		}
+		it.closer, it.reader, it.pos = rc, bufio.NewReader(rc), 0
+		return nil
+	}
+
+	f, err := os.Open(it.split.file.path)
+	if err != nil {
+		return err
+	}
+	it.closer = f
+	it.pos = it.split.start
+	if it.split.start > 0 {
+		// a line starts at start only if the byte before it ends the previous line,
+		// otherwise the partial line belongs to the previous split
+		if _, err := f.Seek(it.split.start-1, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+		it.reader = bufio.NewReader(f)
+		skipped, err := it.reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			f.Close()
+			return err
+		}
+		it.pos = it.split.start - 1 + int64(len(skipped))
+		return nil
+	}
+	it.reader = bufio.NewReader(f)
+	return nil
+}
+
+func (it *lineIterator) Next() (interface{}, bool) {
+	if it.err != nil || it.reader == nil {
+		return nil, false
+	}
+	if !it.split.file.gzip && it.pos >= it.split.end {
+		return nil, false
+	}
+	line, err := it.reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		it.err = fmt.Errorf("%s: %w", it.split.file.path, err)
+		return nil, false
+	}
+	if len(line) == 0 {
+		return nil, false
+	}
+	it.pos += int64(len(line))
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return line, true
+}
+
+func (it *lineIterator) Err() error {
+	return it.err
+}
+
+func (it *lineIterator) Close() error {
+	if it.closer == nil {
+		return nil
+	}
+	err := it.closer.Close()
+	it.closer, it.reader = nil, nil
+	return err
+}
+
+func (it *lineIterator) Reset() {
+	it.Close()
+	it.err = it.open()
+}
@@ -0,0 +1,86 @@
+package source
+
+import (
+	"fmt"
+	"io"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+)
+
+// WholeTextSource reads every file as a single record holding its path and content,
+// {"path": ..., "content": ...}, so it can be used with column expressions
+type WholeTextSource struct {
+	groups [][]file
+}
+
+// WholeTextFiles creates a source over the files matched by patterns, spreading them
+// over at most minPartitions partitions of consecutive files
+func WholeTextFiles(patterns string, minPartitions int) (*WholeTextSource, error) {
+	files, err := expand(patterns)
+	if err != nil {
+		return nil, err
+	}
+	if minPartitions < 1 {
+		minPartitions = 1
+	}
+	if minPartitions > len(files) {
+		minPartitions = len(files)
+	}
+	s := &WholeTextSource{groups: make([][]file, minPartitions)}
+	for i, f := range files {
+		g := i * minPartitions / len(files)
+		s.groups[g] = append(s.groups[g], f)
+	}
+	return s, nil
+}
+
+// NumPartitions returns the number of file groups
+func (s *WholeTextSource) NumPartitions() int {
+	return len(s.groups)
+}
+
+// Open returns an iterator reading the files of one group one at a time
+func (s *WholeTextSource) Open(partition int) (lazy.SourceIterator, error) {
+	if partition < 0 || partition >= len(s.groups) {
+		return nil, fmt.Errorf("whole text source has no partition %d", partition)
+	}
+	return &wholeIterator{files: s.groups[partition]}, nil
+}
+
+type wholeIterator struct {
+	files []file
+	next  int
+	err   error
+}
+
+func (it *wholeIterator) Next() (interface{}, bool) {
+	if it.err != nil || it.next >= len(it.files) {
+		return nil, false
+	}
+	f := it.files[it.next]
+	it.next++
+	rc, err := openFile(f)
+	if err != nil {
+		it.err = err
+		return nil, false
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		it.err = fmt.Errorf("%s: %w", f.path, err)
+		return nil, false
+	}
+	return map[string]interface{}{"path": f.path, "content": string(content)}, true
+}
+
+func (it *wholeIterator) Err() error {
+	return it.err
+}
+
+func (it *wholeIterator) Close() error {
+	return nil
+}
+
+func (it *wholeIterator) Reset() {
+	it.next, it.err = 0, nil
+}
@@ -0,0 +1,377 @@
+package spark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/bajor/spark-go-core/accumulator"
+	"github.com/bajor/spark-go-core/broadcast"
+	"github.com/bajor/spark-go-core/codec"
+	"github.com/bajor/spark-go-core/formats"
+	"github.com/bajor/spark-go-core/parquet"
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/scheduler"
+	"github.com/bajor/spark-go-core/schema"
+	"github.com/bajor/spark-go-core/sink"
+	"github.com/bajor/spark-go-core/source"
+	"github.com/bajor/spark-go-core/sql"
+	"github.com/bajor/spark-go-core/storage"
+)
+
+// ErrStopped is returned when a stopped Context is asked to run a job
+var ErrStopped = errors.New("context is stopped")
+
+// Config configures a Context. Zero fields take their defaults.
+type Config struct {
+	// AppName names the application in logs and temp directories
+	AppName string
+	// Parallelism is the number of tasks run at once and the default number of
+	// slices of new RDDs, defaults to runtime.NumCPU()
+	Parallelism int
+	// Speculation enables duplicate attempts for straggler tasks
+	Speculation bool
+	// DisableAdaptive keeps the planned shuffle partitions and join strategies instead
+	// of adapting them to the measured shuffle sizes
+	DisableAdaptive bool
+	// MemoryLimit is the number of bytes the block manager may hold, zero for no limit
+	MemoryLimit int64
+	// TempDirs are the directories scratch files go to, defaults to os.TempDir()
+	TempDirs []string
+	// Codec names the codec broadcast values are serialized with, defaults to codec.Default
+	Codec string
+	// BroadcastChunkSize is the maximum size of a broadcast piece
+	BroadcastChunkSize int
+}
+
+// Context is the entry point of an application. It owns the scheduler tasks run on,
+// the block manager, broadcasts, accumulators and scratch directories, and releases
+// them all on Stop.
+type Context struct {
+	config     Config
+	scheduler  *scheduler.Scheduler
+	blocks     *storage.BlockManager
+	broadcasts *broadcast.Manager
+	localDirs  []string
+	catalog    *sql.Catalog
+
+	mu           sync.Mutex
+	accumulators []int64
+	stopped      bool
+}
+
+// NewContext creates a Context, validating the codec and creating a scratch
+// directory inside every configured temp directory
+func NewContext(config Config) (*Context, error) {
+	if config.AppName == "" {
+		config.AppName = "spark-go"
+	}
+	if config.Parallelism <= 0 {
+		config.Parallelism = runtime.NumCPU()
+	}
+	if len(config.TempDirs) == 0 {
+		config.TempDirs = []string{os.TempDir()}
+	}
+	if config.Codec == "" {
+		config.Codec = codec.Default
+	}
+	if _, err := codec.Lookup(config.Codec); err != nil {
+		return nil, err
+	}
+
+	c := &Context{
+		config:    config,
+		scheduler: scheduler.New(scheduler.Config{Parallelism: config.Parallelism, Speculation: config.Speculation, DisableAdaptive: config.DisableAdaptive}),
+		blocks:    storage.NewBlockManagerWithLimit(config.MemoryLimit),
+		catalog:   sql.NewCatalog(),
+	}
+	c.broadcasts = broadcast.NewManager(c.blocks, broadcast.Config{Codec: config.Codec, ChunkSize: config.BroadcastChunkSize})
+	for _, dir := range config.TempDirs {
+		local, err := os.MkdirTemp(dir, config.AppName+"-")
+		if err != nil {
+			c.removeLocalDirs()
+			return nil, fmt.Errorf("create scratch directory: %w", err)
+		}
+		c.localDirs = append(c.localDirs, local)
+	}
+	return c, nil
+}
+
+// Config returns the configuration with defaults filled in
+func (c *Context) Config() Config {
+	return c.config
+}
+
+// Scheduler returns the scheduler jobs of this context run on
+func (c *Context) Scheduler() *scheduler.Scheduler {
+	return c.scheduler
+}
+
+// BlockManager returns the block manager holding broadcast pieces and cached blocks
+func (c *Context) BlockManager() *storage.BlockManager {
+	return c.blocks
+}
+
+// LocalDirs returns the scratch directories created for this context; they are
+// removed by Stop
+func (c *Context) LocalDirs() []string {
+	return append([]string(nil), c.localDirs...)
+}
+
+// Parallelize distributes data over numSlices partitions, or the configured
+// parallelism when numSlices is zero or less. Elements are their own keys.
+func (c *Context) Parallelize(data []interface{}, numSlices int) *rdd.KeyedRDD {
+	if numSlices <= 0 {
+		numSlices = c.config.Parallelism
+	}
+	return rdd.NewKeyedRDD(data, identity).Repartition(numSlices)
+}
+
+// Range creates an RDD of the int64 values from start up to, but excluding, end
+func (c *Context) Range(start, end, step int64, numSlices int) (*rdd.KeyedRDD, error) {
+	if step == 0 {
+		return nil, errors.New("range step must not be zero")
+	}
+	data := make([]interface{}, 0)
+	for v := start; (step > 0 && v < end) || (step < 0 && v > end); v += step {
+		data = append(data, v)
+	}
+	return c.Parallelize(data, numSlices), nil
+}
+
+// TextFile reads the lines of the files matched by a comma-separated list of paths,
+// glob patterns and directories. Large files are split into byte ranges read by
+// separate tasks and gzip input is decompressed transparently.
+func (c *Context) TextFile(path string, minPartitions int) (*rdd.KeyedRDD, error) {
+	if minPartitions <= 0 {
+		minPartitions = c.config.Parallelism
+	}
+	src, err := source.TextFile(path, source.TextOptions{MinPartitions: minPartitions})
+	if err != nil {
+		return nil, err
+	}
+	return rdd.FromSource(src), nil
+}
+
+// WholeTextFiles reads every matched file as one {"path", "content"} record
+func (c *Context) WholeTextFiles(path string, minPartitions int) (*rdd.KeyedRDD, error) {
+	if minPartitions <= 0 {
+		minPartitions = c.config.Parallelism
+	}
+	src, err := source.WholeTextFiles(path, minPartitions)
+	if err != nil {
+		return nil, err
+	}
+	return rdd.FromSource(src), nil
+}
+
+// ReadCSV reads CSV files into records mapping column names to values typed by the
+// given, inferred or all-String schema, one partition per file
+func (c *Context) ReadCSV(path string, options formats.CSVOptions) (*rdd.KeyedRDD, error) {
+	src, err := formats.CSV(path, options)
+	if err != nil {
+		return nil, err
+	}
+	return rdd.FromSource(src), nil
+}
+
+// ReadJSONLines reads files holding one JSON object per line; files are split like
+// TextFile and MinPartitions defaults to the configured parallelism
+func (c *Context) ReadJSONLines(path string, options formats.JSONOptions) (*rdd.KeyedRDD, error) {
+	if options.MinPartitions <= 0 {
+		options.MinPartitions = c.config.Parallelism
+	}
+	src, err := formats.JSONLines(path, options)
+	if err != nil {
+		return nil, err
+	}
+	return rdd.FromSource(src), nil
+}
+
+// ReadParquet reads Parquet files with one partition per row group. FilterExpr calls
+// at the start of the RDD's chain skip row groups whose statistics rule them out.
+func (c *Context) ReadParquet(path string, options parquet.ReadOptions) (*rdd.KeyedRDD, error) {
+	src, err := parquet.Read(path, options)
+	if err != nil {
+		return nil, err
+	}
+	return rdd.FromSource(src), nil
+}
+
+// FromChannel creates an RDD of the elements received from ch until it is closed,
+// read by numPartitions tasks, which defaults to the configured parallelism. The RDD
+// can be evaluated once, since the channel cannot be replayed.
+func FromChannel[T any](c *Context, ch <-chan T, numPartitions int) *rdd.KeyedRDD {
+	if numPartitions <= 0 {
+		numPartitions = c.config.Parallelism
+	}
+	return rdd.FromSource(source.Channel(ch, numPartitions))
+}
+
+// ReadStream creates a single partition RDD of the records decoded from r with a
+// decoder such as source.Lines or source.JSON. The RDD can be evaluated once.
+func (c *Context) ReadStream(r io.Reader, newDecoder func(io.Reader) source.Decoder) *rdd.KeyedRDD {
+	return rdd.FromSource(source.Reader(r, newDecoder))
+}
+
+// Broadcast ships a read-only value to every task; it is destroyed at the latest by Stop
+func (c *Context) Broadcast(value interface{}) (*broadcast.Broadcast, error) {
+	if c.isStopped() {
+		return nil, ErrStopped
+	}
+	return c.broadcasts.New(value)
+}
+
+// LongAccumulator creates a counter owned by this context
+func (c *Context) LongAccumulator(name string) *accumulator.Accumulator[int64] {
+	acc := accumulator.Long(name)
+	c.track(acc.ID())
+	return acc
+}
+
+// FloatAccumulator creates a floating point sum owned by this context
+func (c *Context) FloatAccumulator(name string) *accumulator.Accumulator[float64] {
+	acc := accumulator.Float(name)
+	c.track(acc.ID())
+	return acc
+}
+
+// CollectionAccumulator creates an accumulator gathering elements, owned by c
+func CollectionAccumulator[E any](c *Context, name string) *accumulator.Accumulator[[]E] {
+	acc := accumulator.Collection[E](name)
+	c.track(acc.ID())
+	return acc
+}
+
+// NewAccumulator creates an accumulator with a custom merge function, owned by c
+func NewAccumulator[T any](c *Context, name string, zero T, merge func(a, b T) T) *accumulator.Accumulator[T] {
+	acc := accumulator.New(name, zero, merge)
+	c.track(acc.ID())
+	return acc
+}
+
+// track records an accumulator so Stop can unregister it
+func (c *Context) track(id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.accumulators = append(c.accumulators, id)
+}
+
+// Collect evaluates an RDD on the context's scheduler
+func (c *Context) Collect(ctx context.Context, r *rdd.KeyedRDD) ([]interface{}, error) {
+	if c.isStopped() {
+		return nil, ErrStopped
+	}
+	return r.Collect(ctx, c.scheduler)
+}
+
+// Foreach evaluates an RDD and passes every element to f, see rdd.KeyedRDD.Foreach
+func (c *Context) Foreach(ctx context.Context, r *rdd.KeyedRDD, f func(ctx context.Context, record interface{}) error) error {
+	if c.isStopped() {
+		return ErrStopped
+	}
+	return r.Foreach(ctx, c.scheduler, f)
+}
+
+// ToChannel evaluates an RDD and sends every element to ch, blocking while ch is full
+func ToChannel[T any](ctx context.Context, c *Context, r *rdd.KeyedRDD, ch chan<- T) error {
+	if c.isStopped() {
+		return ErrStopped
+	}
+	return rdd.ToChannel(ctx, c.scheduler, r, ch)
+}
+
+// StreamTo evaluates an RDD and writes every element with an encoder such as
+// sink.Lines or sink.JSON
+func (c *Context) StreamTo(ctx context.Context, r *rdd.KeyedRDD, enc sink.Encoder) error {
+	if c.isStopped() {
+		return ErrStopped
+	}
+	return r.StreamTo(ctx, c.scheduler, enc)
+}
+
+// SaveAsTextFile writes the RDD into dir as one text file per partition, one line
+// per element. The directory gets a _SUCCESS marker once the job has committed.
+func (c *Context) SaveAsTextFile(ctx context.Context, r *rdd.KeyedRDD, dir string, options sink.Options) error {
+	return c.save(ctx, r, dir, sink.Text, options)
+}
+
+// SaveAsJSONLines writes map or struct elements into dir as one JSON lines file per
+// partition
+func (c *Context) SaveAsJSONLines(ctx context.Context, r *rdd.KeyedRDD, dir string, options sink.Options) error {
+	return c.save(ctx, r, dir, sink.JSONLines, options)
+}
+
+// SaveAsCSV writes map or struct elements into dir as one CSV file per partition
+func (c *Context) SaveAsCSV(ctx context.Context, r *rdd.KeyedRDD, dir string, csv formats.CSVWriteOptions, options sink.Options) error {
+	return c.save(ctx, r, dir, sink.CSV(csv), options)
+}
+
+// SaveAsParquet writes map or struct elements into dir as one Parquet file per
+// partition. Columns named in options.PartitionBy become directories and are left
+// out of the files' schema.
+func (c *Context) SaveAsParquet(ctx context.Context, r *rdd.KeyedRDD, dir string, s schema.Schema, pq parquet.WriteOptions, options sink.Options) error {
+	partitioned := make(map[string]bool, len(options.PartitionBy))
+	for _, name := range options.PartitionBy {
+		partitioned[name] = true
+	}
+	var fields []schema.Field
+	for _, f := range s.Fields {
+		if !partitioned[f.Name] {
+			fields = append(fields, f)
+		}
+	}
+	return c.save(ctx, r, dir, sink.Parquet(schema.New(fields...), pq), options)
+}
+
+func (c *Context) save(ctx context.Context, r *rdd.KeyedRDD, dir string, format sink.Format, options sink.Options) error {
+	if c.isStopped() {
+		return ErrStopped
+	}
+	return r.Save(ctx, c.scheduler, dir, format, options)
+}
+
+// Stop destroys the broadcasts, unregisters the accumulators, drops every block and
+// removes the scratch directories. Calling it again does nothing.
+func (c *Context) Stop() error {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return nil
+	}
+	c.stopped = true
+	accumulators := c.accumulators
+	c.accumulators = nil
+	c.mu.Unlock()
+
+	c.broadcasts.Close()
+	for _, id := range accumulators {
+		accumulator.Unregister(id)
+	}
+	c.blocks.Clear()
+	return c.removeLocalDirs()
+}
+
+func (c *Context) isStopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopped
+}
+
+func (c *Context) removeLocalDirs() error {
+	var errs []error
+	for _, dir := range c.localDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func identity(i interface{}) (interface{}, error) {
+	return i, nil
+}
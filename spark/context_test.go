@@ -0,0 +1,430 @@
+package spark
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bajor/spark-go-core/broadcast"
+	"github.com/bajor/spark-go-core/dataframe"
+	"github.com/bajor/spark-go-core/expr"
+	"github.com/bajor/spark-go-core/formats"
+	"github.com/bajor/spark-go-core/parquet"
+	"github.com/bajor/spark-go-core/schema"
+	"github.com/bajor/spark-go-core/sink"
+	"github.com/bajor/spark-go-core/source"
+	"github.com/bajor/spark-go-core/storage"
+)
+
+func newTestContext(t *testing.T, config Config) *Context {
+	t.Helper()
+	if config.TempDirs == nil {
+		config.TempDirs = []string{t.TempDir()}
+	}
+	c, err := NewContext(config)
+	if err != nil {
+		t.Fatalf("NewContext failed with error: %v", err)
+	}
+	t.Cleanup(func() { c.Stop() })
+	return c
+}
+
+func TestContext_Defaults(t *testing.T) {
+	c := newTestContext(t, Config{})
+	config := c.Config()
+	if config.Parallelism <= 0 || config.Codec == "" || config.AppName == "" {
+		t.Errorf("Defaults were not filled in: %+v", config)
+	}
+	if _, err := NewContext(Config{Codec: "nope", TempDirs: []string{t.TempDir()}}); err == nil {
+		t.Errorf("Expected an error for an unknown codec")
+	}
+}
+
+func TestContext_Parallelize(t *testing.T) {
+	c := newTestContext(t, Config{Parallelism: 3})
+	r := c.Parallelize([]interface{}{1, 2, 3, 4, 5}, 0)
+	if r.Partitions != 3 {
+		t.Errorf("Parallelize should default to the parallelism: got %d partitions", r.Partitions)
+	}
+	r = r.Map(func(i interface{}) (interface{}, error) { return i.(int) * 10, nil })
+	result, err := c.Collect(context.Background(), r)
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	if expected := []interface{}{10, 20, 30, 40, 50}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("Parallelize failed: got %v, want %v", result, expected)
+	}
+}
+
+func TestContext_Range(t *testing.T) {
+	c := newTestContext(t, Config{})
+	cases := []struct {
+		start, end, step int64
+		expected         []interface{}
+	}{
+		{0, 5, 2, []interface{}{int64(0), int64(2), int64(4)}},
+		{5, 0, -2, []interface{}{int64(5), int64(3), int64(1)}},
+		{3, 3, 1, []interface{}{}},
+	}
+	for _, tc := range cases {
+		r, err := c.Range(tc.start, tc.end, tc.step, 2)
+		if err != nil {
+			t.Fatalf("Range failed with error: %v", err)
+		}
+		result, err := c.Collect(context.Background(), r)
+		if err != nil {
+			t.Fatalf("Collect failed with error: %v", err)
+		}
+		if !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("Range(%d, %d, %d): got %v, want %v", tc.start, tc.end, tc.step, result, tc.expected)
+		}
+	}
+	if _, err := c.Range(0, 10, 0, 1); err == nil {
+		t.Errorf("Expected an error for a zero step")
+	}
+}
+
+func TestContext_TextFile(t *testing.T) {
+	c := newTestContext(t, Config{})
+	path := filepath.Join(t.TempDir(), "lines.txt")
+	if err := os.WriteFile(path, []byte("a\nbb\n\nccc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.TextFile(path, 2)
+	if err != nil {
+		t.Fatalf("TextFile failed with error: %v", err)
+	}
+	if expected := []interface{}{"a", "bb", "", "ccc"}; !reflect.DeepEqual(r.GetData(), expected) {
+		t.Errorf("TextFile failed: got %q, want %q", r.GetData(), expected)
+	}
+}
+
+func TestContext_StopReleasesEverything(t *testing.T) {
+	c := newTestContext(t, Config{MemoryLimit: 1 << 20})
+	dirs := c.LocalDirs()
+	if len(dirs) != 1 {
+		t.Fatalf("Expected one scratch directory, got %v", dirs)
+	}
+	if _, err := os.Stat(dirs[0]); err != nil {
+		t.Fatalf("Scratch directory was not created: %v", err)
+	}
+
+	table, err := c.Broadcast(map[string]interface{}{"a": 1.0})
+	if err != nil {
+		t.Fatalf("Broadcast failed with error: %v", err)
+	}
+	if c.BlockManager().Size() == 0 {
+		t.Fatalf("Broadcast pieces were not stored")
+	}
+	count := c.LongAccumulator("count")
+	r := c.Parallelize([]interface{}{1, 2, 3}, 2).MapPartitions(func(ctx context.Context, part []interface{}) ([]interface{}, error) {
+		count.Add(ctx, int64(len(part)))
+		return part, nil
+	})
+	if _, err := c.Collect(context.Background(), r); err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	if count.Value() != 3 {
+		t.Errorf("Accumulator: got %d, want 3", count.Value())
+	}
+
+	if err := c.Stop(); err != nil {
+		t.Fatalf("Stop failed with error: %v", err)
+	}
+	if _, err := table.Value(); !errors.Is(err, broadcast.ErrDestroyed) && !errors.Is(err, broadcast.ErrUnavailable) {
+		t.Errorf("Broadcast still readable after Stop: %v", err)
+	}
+	if c.BlockManager().Size() != 0 {
+		t.Errorf("Blocks left after Stop: %v", c.BlockManager().IDs(""))
+	}
+	if _, err := os.Stat(dirs[0]); !os.IsNotExist(err) {
+		t.Errorf("Scratch directory left after Stop: %v", err)
+	}
+	if _, err := c.Collect(context.Background(), r); !errors.Is(err, ErrStopped) {
+		t.Errorf("Expected ErrStopped after Stop, got %v", err)
+	}
+	if err := c.Stop(); err != nil {
+		t.Errorf("Second Stop failed with error: %v", err)
+	}
+}
+
+func TestContext_MemoryLimit(t *testing.T) {
+	c := newTestContext(t, Config{MemoryLimit: 16})
+	if _, err := c.Broadcast(make([]interface{}, 100)); !errors.Is(err, storage.ErrNoSpace) {
+		t.Errorf("Expected ErrNoSpace for a broadcast over the limit, got %v", err)
+	}
+	if c.BlockManager().Size() != 0 {
+		t.Errorf("Pieces of the failed broadcast were kept: %v", c.BlockManager().IDs(""))
+	}
+}
+
+func TestContext_TextFileGlobAndWholeTextFiles(t *testing.T) {
+	c := newTestContext(t, Config{Parallelism: 4})
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "1\n2\n", "b.txt": "3\n", "c.csv": "x\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines, err := c.TextFile(filepath.Join(dir, "*.txt"), 0)
+	if err != nil {
+		t.Fatalf("TextFile failed with error: %v", err)
+	}
+	result, err := c.Collect(context.Background(), lines)
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	if expected := []interface{}{"1", "2", "3"}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("TextFile glob failed: got %v, want %v", result, expected)
+	}
+
+	files, err := c.WholeTextFiles(dir, 0)
+	if err != nil {
+		t.Fatalf("WholeTextFiles failed with error: %v", err)
+	}
+	paths, err := c.Collect(context.Background(), files.MapExpr(expr.Col("path")))
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	if len(paths) != 3 {
+		t.Errorf("Expected one record per file, got %v", paths)
+	}
+}
+
+func TestContext_ReadCSVAndJSONLines(t *testing.T) {
+	c := newTestContext(t, Config{Parallelism: 2})
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "people.csv")
+	jsonPath := filepath.Join(dir, "people.jsonl")
+	if err := os.WriteFile(csvPath, []byte("name,age\nada,36\nalan,41\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(jsonPath, []byte(`{"name":"ada","age":36}`+"\n"+`{"name":"alan","age":41}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"name": "ada", "age": int64(36)},
+		map[string]interface{}{"name": "alan", "age": int64(41)},
+	}
+
+	people, err := c.ReadCSV(csvPath, formats.CSVOptions{Header: true, InferSchema: true})
+	if err != nil {
+		t.Fatalf("ReadCSV failed with error: %v", err)
+	}
+	result, err := c.Collect(context.Background(), people)
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("ReadCSV failed: got %v, want %v", result, expected)
+	}
+
+	people, err = c.ReadJSONLines(jsonPath, formats.JSONOptions{})
+	if err != nil {
+		t.Fatalf("ReadJSONLines failed with error: %v", err)
+	}
+	result, err = c.Collect(context.Background(), people)
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("ReadJSONLines failed: got %v, want %v", result, expected)
+	}
+}
+
+func TestContext_SaveAsCSVAndJSONLines(t *testing.T) {
+	c := newTestContext(t, Config{Parallelism: 2})
+	out := t.TempDir()
+	people := c.Parallelize([]interface{}{
+		map[string]interface{}{"name": "ada", "age": int64(36), "team": "a"},
+		map[string]interface{}{"name": "alan", "age": int64(41), "team": "b"},
+		map[string]interface{}{"name": "grace", "age": int64(45), "team": "a"},
+	}, 2)
+
+	csvDir := filepath.Join(out, "csv")
+	options := sink.Options{PartitionBy: []string{"team"}}
+	if err := c.SaveAsCSV(context.Background(), people, csvDir, formats.CSVWriteOptions{Header: true}, options); err != nil {
+		t.Fatalf("SaveAsCSV failed with error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(csvDir, sink.SuccessMarker)); err != nil {
+		t.Errorf("Expected a success marker: %v", err)
+	}
+	teamA, err := c.ReadCSV(filepath.Join(csvDir, "team=a"), formats.CSVOptions{Header: true, InferSchema: true})
+	if err != nil {
+		t.Fatalf("ReadCSV failed with error: %v", err)
+	}
+	result, err := c.Collect(context.Background(), teamA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"name": "ada", "age": int64(36)},
+		map[string]interface{}{"name": "grace", "age": int64(45)},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Partition team=a: got %v, want %v", result, expected)
+	}
+
+	jsonDir := filepath.Join(out, "json")
+	if err := c.SaveAsJSONLines(context.Background(), people, jsonDir, sink.Options{}); err != nil {
+		t.Fatalf("SaveAsJSONLines failed with error: %v", err)
+	}
+	if err := c.SaveAsJSONLines(context.Background(), people, jsonDir, sink.Options{Overwrite: true}); err != nil {
+		t.Fatalf("Overwriting failed with error: %v", err)
+	}
+	back, err := c.ReadJSONLines(jsonDir, formats.JSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := c.Collect(context.Background(), back); err != nil || len(result) != 3 {
+		t.Errorf("Expected 3 records read back, got %v (%v)", result, err)
+	}
+
+	c.Stop()
+	if err := c.SaveAsTextFile(context.Background(), people, filepath.Join(out, "text"), sink.Options{}); !errors.Is(err, ErrStopped) {
+		t.Errorf("Expected ErrStopped, got %v", err)
+	}
+}
+
+func TestContext_SaveAsParquetAndReadParquet(t *testing.T) {
+	c := newTestContext(t, Config{Parallelism: 2})
+	out := t.TempDir()
+	people := c.Parallelize([]interface{}{
+		map[string]interface{}{"name": "ada", "age": int64(36), "team": "a"},
+		map[string]interface{}{"name": "alan", "age": int64(41), "team": "b"},
+		map[string]interface{}{"name": "grace", "age": int64(45), "team": "a"},
+	}, 3)
+	s := schema.New(
+		schema.Field{Name: "name", Type: schema.String},
+		schema.Field{Name: "age", Type: schema.Long},
+		schema.Field{Name: "team", Type: schema.String},
+	)
+
+	dir := filepath.Join(out, "people")
+	if err := c.SaveAsParquet(context.Background(), people, dir, s, parquet.WriteOptions{}, sink.Options{}); err != nil {
+		t.Fatalf("SaveAsParquet failed with error: %v", err)
+	}
+	back, err := c.ReadParquet(dir, parquet.ReadOptions{Columns: []string{"name", "age"}})
+	if err != nil {
+		t.Fatalf("ReadParquet failed with error: %v", err)
+	}
+	result, err := c.Collect(context.Background(), back.FilterExpr(expr.MustParse("age > 40")))
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"name": "alan", "age": int64(41)},
+		map[string]interface{}{"name": "grace", "age": int64(45)},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("ReadParquet failed: got %v, want %v", result, expected)
+	}
+
+	partitioned := filepath.Join(out, "teams")
+	if err := c.SaveAsParquet(context.Background(), people, partitioned, s, parquet.WriteOptions{}, sink.Options{PartitionBy: []string{"team"}}); err != nil {
+		t.Fatalf("SaveAsParquet with PartitionBy failed with error: %v", err)
+	}
+	teamA, err := c.ReadParquet(filepath.Join(partitioned, "team=a"), parquet.ReadOptions{})
+	if err != nil {
+		t.Fatalf("ReadParquet failed with error: %v", err)
+	}
+	if result, err := c.Collect(context.Background(), teamA); err != nil || len(result) != 2 {
+		t.Errorf("Expected the 2 records of team a, got %v (%v)", result, err)
+	}
+}
+
+func TestContext_ChannelAndStreamPipelines(t *testing.T) {
+	c := newTestContext(t, Config{Parallelism: 2})
+	in := make(chan int)
+	go func() {
+		for i := 1; i <= 6; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	squares := FromChannel(c, in, 0).Map(func(i interface{}) (interface{}, error) {
+		return i.(int) * i.(int), nil
+	})
+	out := make(chan int)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- ToChannel(context.Background(), c, squares, out)
+		close(out)
+	}()
+	sum := 0
+	for v := range out {
+		sum += v
+	}
+	if err := <-errc; err != nil || sum != 91 {
+		t.Errorf("Expected the squares to sum to 91, got %d (%v)", sum, err)
+	}
+	if _, err := c.Collect(context.Background(), squares); err == nil {
+		t.Error("Expected evaluating a channel RDD a second time to fail")
+	}
+
+	events := c.ReadStream(strings.NewReader(`{"user":"ada","n":2}`+"\n"+`{"user":"alan","n":5}`+"\n"), source.JSON[map[string]interface{}])
+	var buf bytes.Buffer
+	if err := c.StreamTo(context.Background(), events.FilterExpr(expr.MustParse("n > 3")), sink.JSON(&buf)); err != nil {
+		t.Fatalf("StreamTo failed with error: %v", err)
+	}
+	if expected := `{"n":5,"user":"alan"}` + "\n"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+
+	c.Stop()
+	if err := c.Foreach(context.Background(), squares, func(context.Context, interface{}) error { return nil }); !errors.Is(err, ErrStopped) {
+		t.Errorf("Expected ErrStopped, got %v", err)
+	}
+}
+
+func TestContext_SQL(t *testing.T) {
+	c := newTestContext(t, Config{Parallelism: 2})
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "events.csv")
+	if err := os.WriteFile(csvPath, []byte("k,x\na,1\na,5\nb,7\nb,9\nc,2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateFileView("events", csvPath, "csv"); err != nil {
+		t.Fatalf("CreateFileView failed with error: %v", err)
+	}
+	labels := c.Parallelize([]interface{}{
+		map[string]interface{}{"k": "a", "label": "first"},
+		map[string]interface{}{"k": "b", "label": "second"},
+	}, 2)
+	s := schema.New(schema.Field{Name: "k", Type: schema.String}, schema.Field{Name: "label", Type: schema.String})
+	if err := c.CreateTempView("labels", dataframe.New(labels, s)); err != nil {
+		t.Fatalf("CreateTempView failed with error: %v", err)
+	}
+
+	df, err := c.SQL("SELECT l.label, count(*) AS n FROM events e JOIN labels l ON e.k = l.k WHERE x > 4 GROUP BY l.label ORDER BY label")
+	if err != nil {
+		t.Fatalf("SQL failed with error: %v", err)
+	}
+	rows, err := df.Collect(context.Background(), c.Scheduler())
+	if err != nil {
+		t.Fatalf("Collect failed with error: %v", err)
+	}
+	var got []string
+	for _, r := range rows {
+		got = append(got, r.String())
+	}
+	if want := []string{"[first, 1]", "[second, 2]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SQL rows: got %v, want %v", got, want)
+	}
+
+	if !c.DropTempView("labels") {
+		t.Errorf("DropTempView should report the dropped view")
+	}
+	if _, err := c.SQL("SELECT * FROM labels"); err == nil {
+		t.Errorf("Querying a dropped view should fail")
+	}
+	if err := c.CreateFileView("x", csvPath, "xml"); err == nil {
+		t.Errorf("An unknown file format should fail")
+	}
+}
@@ -0,0 +1,50 @@
+package spark
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bajor/spark-go-core/dataframe"
+	"github.com/bajor/spark-go-core/formats"
+	"github.com/bajor/spark-go-core/parquet"
+	"github.com/bajor/spark-go-core/rdd"
+)
+
+// CreateTempView registers a DataFrame as a view SQL queries can read, replacing
+// any view of the same name
+func (c *Context) CreateTempView(name string, df *dataframe.DataFrame) error {
+	return c.catalog.Register(name, df)
+}
+
+// CreateFileView registers files as a view with the schema of their reader. The
+// format is "csv", read with a header row and inferred column types, "json" for
+// JSON Lines or "parquet".
+func (c *Context) CreateFileView(name, path, format string) error {
+	var r *rdd.KeyedRDD
+	var err error
+	switch strings.ToLower(format) {
+	case "csv":
+		r, err = c.ReadCSV(path, formats.CSVOptions{Header: true, InferSchema: true})
+	case "json":
+		r, err = c.ReadJSONLines(path, formats.JSONOptions{})
+	case "parquet":
+		r, err = c.ReadParquet(path, parquet.ReadOptions{})
+	default:
+		return fmt.Errorf("unknown file format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	return c.catalog.Register(name, dataframe.FromSource(r))
+}
+
+// DropTempView removes a view and reports whether there was one
+func (c *Context) DropTempView(name string) bool {
+	return c.catalog.Drop(name)
+}
+
+// SQL plans a SELECT statement over the registered views. The query runs when an
+// action such as Collect is called on the returned DataFrame.
+func (c *Context) SQL(query string) (*dataframe.DataFrame, error) {
+	return c.catalog.Query(query)
+}
@@ -0,0 +1,82 @@
+// Package sql runs SQL queries over DataFrames registered as temporary views. A
+// hand-written parser reads SELECT statements with joins, WHERE, GROUP BY, HAVING,
+// ORDER BY and LIMIT; the planner turns them into DataFrame transformations, which
+// compile down to Map, Filter and ReduceByKey operations.
+package sql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bajor/spark-go-core/dataframe"
+)
+
+// Catalog holds the temporary views queries read from. View names are case-insensitive.
+// It is safe for concurrent use.
+type Catalog struct {
+	mu    sync.RWMutex
+	views map[string]*dataframe.DataFrame
+}
+
+// NewCatalog creates a catalog without views
+func NewCatalog() *Catalog {
+	return &Catalog{views: make(map[string]*dataframe.DataFrame)}
+}
+
+// Register adds a view, replacing any view of the same name. Names that are
+// keywords or hold other characters than letters, digits and underscores must be
+// quoted in queries, with double quotes or backticks.
+func (c *Catalog) Register(name string, df *dataframe.DataFrame) error {
+	if name == "" {
+		return fmt.Errorf("empty view name")
+	}
+	if err := df.Err(); err != nil {
+		return fmt.Errorf("view %s: %w", name, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.views[strings.ToLower(name)] = df
+	return nil
+}
+
+// Drop removes a view and reports whether there was one
+func (c *Catalog) Drop(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.views[strings.ToLower(name)]
+	delete(c.views, strings.ToLower(name))
+	return ok
+}
+
+// View returns the DataFrame registered under a name
+func (c *Catalog) View(name string) (*dataframe.DataFrame, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	df, ok := c.views[strings.ToLower(name)]
+	return df, ok
+}
+
+// Views returns the names of the views in order
+func (c *Catalog) Views() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make([]string, 0, len(c.views))
+	for name := range c.views {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Query parses a SELECT statement and plans it over the views, returning the
+// DataFrame of its result. Nothing is evaluated until an action is called on it.
+func (c *Catalog) Query(text string) (*dataframe.DataFrame, error) {
+	q, err := parse(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &planner{views: c.View}
+	return p.plan(q)
+}
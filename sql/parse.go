@@ -0,0 +1,774 @@
+package sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/bajor/spark-go-core/dataframe"
+	"github.com/bajor/spark-go-core/expr"
+)
+
+// query is a parsed SELECT statement
+type query struct {
+	distinct bool
+	items    []selectItem
+	from     tableRef
+	joins    []joinClause
+	where    expr.Expr
+	groupBy  []expr.Expr
+	having   expr.Expr
+	orderBy  []orderItem
+	// limit is the maximum number of rows, or -1 for no limit
+	limit int
+}
+
+// selectItem is an expression of the select list, or a star selecting every column
+// of the FROM clause or, when table is set, of one table
+type selectItem struct {
+	expr  expr.Expr
+	alias string
+	star  bool
+	table string
+}
+
+// tableRef names a view, or holds a subquery
+type tableRef struct {
+	name     string
+	subquery *query
+	alias    string
+}
+
+type joinClause struct {
+	how   dataframe.JoinType
+	table tableRef
+	// on is nil for cross joins
+	on expr.Expr
+}
+
+type orderItem struct {
+	expr expr.Expr
+	desc bool
+}
+
+// aggregates are the aggregate functions; count(*) is parsed as a call of count on
+// the column "*"
+var aggregates = map[string]bool{"count": true, "sum": true, "avg": true, "min": true, "max": true}
+
+// functionNames maps SQL spellings of scalar functions to the expr functions
+var functionNames = map[string]string{
+	"substring": "substr",
+	"len":       "length",
+}
+
+// keywords cannot be used as implicit aliases
+var keywords = map[string]bool{
+	"select": true, "distinct": true, "from": true, "where": true, "group": true, "by": true,
+	"having": true, "order": true, "limit": true, "asc": true, "desc": true, "join": true,
+	"inner": true, "left": true, "right": true, "full": true, "outer": true, "cross": true,
+	"semi": true, "anti": true, "on": true, "as": true, "and": true, "or": true, "not": true,
+	"is": true, "null": true, "in": true, "between": true, "like": true, "true": true, "false": true,
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	// tokQuoted is an identifier in double quotes or backticks, never a keyword
+	tokQuoted
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type lexer struct {
+	input string
+	pos   int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.input) {
+		if unicode.IsSpace(rune(l.input[l.pos])) {
+			l.pos++
+			continue
+		}
+		// -- comments run to the end of the line
+		if strings.HasPrefix(l.input[l.pos:], "--") {
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+			continue
+		}
+		break
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	c := l.input[l.pos]
+	switch {
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for l.pos < len(l.input) && (l.input[l.pos] == '_' || unicode.IsLetter(rune(l.input[l.pos])) || unicode.IsDigit(rune(l.input[l.pos]))) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.input[start:l.pos], pos: start}, nil
+	case unicode.IsDigit(rune(c)):
+		for l.pos < len(l.input) && (unicode.IsDigit(rune(l.input[l.pos])) || l.input[l.pos] == '.') {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: l.input[start:l.pos], pos: start}, nil
+	case c == '\'' || c == '"' || c == '`':
+		var b strings.Builder
+		l.pos++
+		for {
+			if l.pos >= len(l.input) {
+				return token{}, fmt.Errorf("unterminated quote at position %d", start)
+			}
+			if l.input[l.pos] == c {
+				// a doubled quote is an escaped quote
+				if l.pos+1 < len(l.input) && l.input[l.pos+1] == c {
+					b.WriteByte(c)
+					l.pos += 2
+					continue
+				}
+				l.pos++
+				break
+			}
+			b.WriteByte(l.input[l.pos])
+			l.pos++
+		}
+		if c == '\'' {
+			return token{kind: tokString, text: b.String(), pos: start}, nil
+		}
+		return token{kind: tokQuoted, text: b.String(), pos: start}, nil
+	}
+
+	for _, op := range []string{"<=", ">=", "!=", "<>", "==", "||", "=", "<", ">", "+", "-", "*", "/", "%", "(", ")", ",", ".", ";"} {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+	return token{}, fmt.Errorf("unexpected character %q at position %d", c, start)
+}
+
+type parser struct {
+	lex lexer
+	tok token
+	err error
+}
+
+// parse parses a single SELECT statement, optionally ending with a semicolon
+func parse(input string) (*query, error) {
+	p := &parser{lex: lexer{input: input}}
+	p.advance()
+	q, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	p.op(";")
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return q, nil
+}
+
+func (p *parser) advance() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+	if p.err != nil {
+		p.tok = token{kind: tokEOF}
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	return fmt.Errorf("syntax error at position %d: %s", p.tok.pos, fmt.Sprintf(format, args...))
+}
+
+// isKeyword reports whether the current token is one of the keywords
+func (p *parser) isKeyword(words ...string) bool {
+	if p.tok.kind != tokIdent {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(p.tok.text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyword consumes the current token if it is the keyword
+func (p *parser) keyword(word string) bool {
+	if p.isKeyword(word) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(words ...string) error {
+	for _, w := range words {
+		if !p.keyword(w) {
+			return p.errorf("expected %s", strings.ToUpper(w))
+		}
+	}
+	return nil
+}
+
+func (p *parser) isOp(op string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op
+}
+
+// op consumes the current token if it is the operator
+func (p *parser) op(op string) bool {
+	if p.isOp(op) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectOp(op string) error {
+	if !p.op(op) {
+		return p.errorf("expected %s", op)
+	}
+	return nil
+}
+
+// identifier consumes a plain identifier that is not a keyword, or a quoted one
+func (p *parser) identifier(what string) (string, error) {
+	switch {
+	case p.tok.kind == tokQuoted:
+	case p.tok.kind == tokIdent && !keywords[strings.ToLower(p.tok.text)]:
+	default:
+		return "", p.errorf("expected %s", what)
+	}
+	name := p.tok.text
+	p.advance()
+	return name, nil
+}
+
+// alias parses an optional alias, with or without AS
+func (p *parser) alias() (string, error) {
+	if p.keyword("as") {
+		return p.identifier("an alias")
+	}
+	if p.tok.kind == tokQuoted || (p.tok.kind == tokIdent && !keywords[strings.ToLower(p.tok.text)]) {
+		return p.identifier("an alias")
+	}
+	return "", nil
+}
+
+func (p *parser) query() (*query, error) {
+	if err := p.expectKeyword("select"); err != nil {
+		return nil, err
+	}
+	q := &query{limit: -1}
+	q.distinct = p.keyword("distinct")
+	for {
+		item, err := p.selectItem()
+		if err != nil {
+			return nil, err
+		}
+		q.items = append(q.items, item)
+		if !p.op(",") {
+			break
+		}
+	}
+
+	if err := p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+	var err error
+	if q.from, err = p.tableRef(); err != nil {
+		return nil, err
+	}
+	for {
+		j, ok, err := p.join()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		q.joins = append(q.joins, j)
+	}
+
+	if p.keyword("where") {
+		if q.where, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("group") {
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		if q.groupBy, err = p.expressionList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("having") {
+		if q.having, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("order") {
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			item := orderItem{expr: e}
+			if p.keyword("desc") {
+				item.desc = true
+			} else {
+				p.keyword("asc")
+			}
+			q.orderBy = append(q.orderBy, item)
+			if !p.op(",") {
+				break
+			}
+		}
+	}
+	if p.keyword("limit") {
+		if p.tok.kind != tokNumber {
+			return nil, p.errorf("expected a row count after LIMIT")
+		}
+		n, err := strconv.Atoi(p.tok.text)
+		if err != nil {
+			return nil, p.errorf("invalid row count %q", p.tok.text)
+		}
+		p.advance()
+		q.limit = n
+	}
+	return q, p.err
+}
+
+func (p *parser) selectItem() (selectItem, error) {
+	if p.op("*") {
+		return selectItem{star: true}, nil
+	}
+	// table.*
+	if p.tok.kind == tokIdent || p.tok.kind == tokQuoted {
+		save := *p
+		table := p.tok.text
+		p.advance()
+		if p.op(".") && p.op("*") {
+			return selectItem{star: true, table: table}, nil
+		}
+		*p = save
+	}
+	e, err := p.expression()
+	if err != nil {
+		return selectItem{}, err
+	}
+	alias, err := p.alias()
+	return selectItem{expr: e, alias: alias}, err
+}
+
+func (p *parser) tableRef() (tableRef, error) {
+	var t tableRef
+	if p.op("(") {
+		sub, err := p.query()
+		if err != nil {
+			return t, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return t, err
+		}
+		t.subquery = sub
+	} else {
+		name, err := p.identifier("a view name")
+		if err != nil {
+			return t, err
+		}
+		t.name = name
+	}
+	var err error
+	t.alias, err = p.alias()
+	return t, err
+}
+
+// join parses a join clause, if one follows
+func (p *parser) join() (joinClause, bool, error) {
+	var j joinClause
+	cross := false
+	switch {
+	case p.op(","):
+		cross = true
+		j.how = dataframe.Inner
+	case p.keyword("cross"):
+		if err := p.expectKeyword("join"); err != nil {
+			return j, false, err
+		}
+		cross = true
+		j.how = dataframe.Inner
+	case p.keyword("join"):
+		j.how = dataframe.Inner
+	case p.keyword("inner"):
+		j.how = dataframe.Inner
+		if err := p.expectKeyword("join"); err != nil {
+			return j, false, err
+		}
+	case p.isKeyword("left", "right", "full"):
+		j.how = map[string]dataframe.JoinType{"left": dataframe.LeftOuter, "right": dataframe.RightOuter, "full": dataframe.FullOuter}[strings.ToLower(p.tok.text)]
+		p.advance()
+		switch {
+		case j.how == dataframe.LeftOuter && p.keyword("semi"):
+			j.how = dataframe.LeftSemi
+		case j.how == dataframe.LeftOuter && p.keyword("anti"):
+			j.how = dataframe.LeftAnti
+		default:
+			p.keyword("outer")
+		}
+		if err := p.expectKeyword("join"); err != nil {
+			return j, false, err
+		}
+	default:
+		return j, false, nil
+	}
+	var err error
+	if j.table, err = p.tableRef(); err != nil {
+		return j, false, err
+	}
+	if cross {
+		return j, true, nil
+	}
+	if err := p.expectKeyword("on"); err != nil {
+		return j, false, err
+	}
+	j.on, err = p.expression()
+	return j, true, err
+}
+
+func (p *parser) expressionList() ([]expr.Expr, error) {
+	var list []expr.Expr
+	for {
+		e, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if !p.op(",") {
+			return list, nil
+		}
+	}
+}
+
+// expression parses, from the loosest binding level: or, and, not, predicates
+// (comparisons, IS NULL, IN, BETWEEN and LIKE), + - ||, * / % and unary minus
+func (p *parser) expression() (expr.Expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = expr.Or(left, right)
+	}
+	return left, nil
+}
+
+func (p *parser) and() (expr.Expr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = expr.And(left, right)
+	}
+	return left, nil
+}
+
+func (p *parser) not() (expr.Expr, error) {
+	if p.keyword("not") {
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return expr.Not(operand), nil
+	}
+	return p.predicate()
+}
+
+func (p *parser) predicate() (expr.Expr, error) {
+	left, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokOp {
+		op := p.tok.text
+		switch op {
+		case "==":
+			op = "="
+		case "<>":
+			op = "!="
+		}
+		switch op {
+		case "=", "!=", "<", "<=", ">", ">=":
+			p.advance()
+			right, err := p.additive()
+			if err != nil {
+				return nil, err
+			}
+			return expr.Binary{Op: op, Left: left, Right: right}, nil
+		}
+	}
+	if p.keyword("is") {
+		negate := p.keyword("not")
+		if err := p.expectKeyword("null"); err != nil {
+			return nil, err
+		}
+		return negated(expr.Fn("isnull", left), negate), nil
+	}
+	negate := p.keyword("not")
+	switch {
+	case p.keyword("in"):
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		values, err := p.expressionList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		var in expr.Expr
+		for _, v := range values {
+			if in == nil {
+				in = expr.Eq(left, v)
+			} else {
+				in = expr.Or(in, expr.Eq(left, v))
+			}
+		}
+		return negated(in, negate), nil
+	case p.keyword("between"):
+		low, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("and"); err != nil {
+			return nil, err
+		}
+		high, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		return negated(expr.And(expr.Ge(left, low), expr.Le(left, high)), negate), nil
+	case p.keyword("like"):
+		pattern, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		return negated(expr.Fn("like", left, pattern), negate), nil
+	case negate:
+		return nil, p.errorf("expected IN, BETWEEN or LIKE after NOT")
+	}
+	return left, nil
+}
+
+func negated(e expr.Expr, negate bool) expr.Expr {
+	if negate {
+		return expr.Not(e)
+	}
+	return e
+}
+
+func (p *parser) additive() (expr.Expr, error) {
+	left, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+") || p.isOp("-") || p.isOp("||") {
+		op := p.tok.text
+		p.advance()
+		right, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		if op == "||" {
+			left = expr.Fn("concat", left, right)
+		} else {
+			left = expr.Binary{Op: op, Left: left, Right: right}
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) multiplicative() (expr.Expr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*") || p.isOp("/") || p.isOp("%") {
+		op := p.tok.text
+		p.advance()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = expr.Binary{Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (expr.Expr, error) {
+	if p.op("-") {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if lit, ok := operand.(expr.Literal); ok {
+			switch v := lit.Value.(type) {
+			case int:
+				return expr.Lit(-v), nil
+			case float64:
+				return expr.Lit(-v), nil
+			}
+		}
+		return expr.Neg(operand), nil
+	}
+	p.op("+")
+	return p.primary()
+}
+
+func (p *parser) primary() (expr.Expr, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		p.advance()
+		if strings.Contains(tok.text, ".") {
+			f, err := strconv.ParseFloat(tok.text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+			}
+			return expr.Lit(f), nil
+		}
+		n, err := strconv.Atoi(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+		}
+		return expr.Lit(n), nil
+	case tokString:
+		p.advance()
+		return expr.Lit(tok.text), nil
+	case tokIdent, tokQuoted:
+		if tok.kind == tokIdent {
+			switch strings.ToLower(tok.text) {
+			case "true":
+				p.advance()
+				return expr.Lit(true), nil
+			case "false":
+				p.advance()
+				return expr.Lit(false), nil
+			case "null":
+				p.advance()
+				return expr.Lit(nil), nil
+			}
+			if keywords[strings.ToLower(tok.text)] {
+				return nil, p.errorf("unexpected %s", strings.ToUpper(tok.text))
+			}
+		}
+		p.advance()
+		if tok.kind == tokIdent && p.isOp("(") {
+			return p.call(tok)
+		}
+		// qualified and nested names are joined with dots
+		name := tok.text
+		for p.op(".") {
+			part, err := p.identifier("a column name")
+			if err != nil {
+				return nil, err
+			}
+			name += "." + part
+		}
+		return expr.Col(name), nil
+	case tokOp:
+		if p.op("(") {
+			e, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+	case tokEOF:
+		return nil, p.errorf("unexpected end of query")
+	}
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+func (p *parser) call(name token) (expr.Expr, error) {
+	fn := strings.ToLower(name.text)
+	if alias, ok := functionNames[fn]; ok {
+		fn = alias
+	}
+	p.advance() // (
+	if aggregates[fn] {
+		if p.keyword("distinct") {
+			return nil, p.errorf("%s(DISTINCT ...) is not supported", fn)
+		}
+		if fn == "count" && p.op("*") {
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return expr.Fn(fn, expr.Col("*")), nil
+		}
+	} else if !isFunction(fn) {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	var args []expr.Expr
+	if !p.op(")") {
+		var err error
+		if args, err = p.expressionList(); err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	}
+	if aggregates[fn] && len(args) != 1 {
+		return nil, fmt.Errorf("%s takes one argument, got %d at position %d", fn, len(args), name.pos)
+	}
+	return expr.Fn(fn, args...), nil
+}
+
+func isFunction(name string) bool {
+	for _, f := range expr.Functions() {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
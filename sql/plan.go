@@ -0,0 +1,576 @@
+package sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bajor/spark-go-core/dataframe"
+	"github.com/bajor/spark-go-core/expr"
+)
+
+// relation is a DataFrame being planned along with the SQL names of its columns
+type relation struct {
+	df      *dataframe.DataFrame
+	columns []column
+}
+
+// column maps a column as queries name it to the column of the DataFrame. Columns
+// of joined tables whose names clash are renamed in the DataFrame.
+type column struct {
+	// table is the alias or view name qualifying the column
+	table    string
+	name     string
+	internal string
+}
+
+// lookup finds a column by a possibly qualified and nested name, returning the
+// DataFrame column name followed by any nested path
+func (r *relation) lookup(name string) (string, error) {
+	parts := strings.Split(name, ".")
+	if len(parts) > 1 {
+		if c, ok, err := r.find(parts[0], parts[1]); err != nil || ok {
+			return joinPath(c.internal, parts[2:]), err
+		}
+	}
+	c, ok, err := r.find("", parts[0])
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("unknown column %s", name)
+	}
+	return joinPath(c.internal, parts[1:]), nil
+}
+
+// find returns the column of a table, or of any table when table is empty. Names
+// match exactly, or else ignoring case.
+func (r *relation) find(table, name string) (column, bool, error) {
+	for _, fold := range []bool{false, true} {
+		var found []column
+		for _, c := range r.columns {
+			if table != "" && !strings.EqualFold(c.table, table) {
+				continue
+			}
+			if c.name == name || (fold && strings.EqualFold(c.name, name)) {
+				found = append(found, c)
+			}
+		}
+		switch {
+		case len(found) == 1:
+			return found[0], true, nil
+		case len(found) > 1:
+			return column{}, false, fmt.Errorf("column %s is ambiguous", name)
+		}
+	}
+	return column{}, false, nil
+}
+
+func joinPath(name string, path []string) string {
+	return strings.Join(append([]string{name}, path...), ".")
+}
+
+// resolve rewrites the column names of an expression to those of the DataFrame
+func (r *relation) resolve(e expr.Expr, clause string) (expr.Expr, error) {
+	return expr.Rewrite(e, func(n expr.Expr) (expr.Expr, error) {
+		switch v := n.(type) {
+		case expr.Column:
+			name, err := r.lookup(v.Name)
+			if err != nil {
+				return nil, err
+			}
+			return expr.Col(name), nil
+		case expr.Call:
+			if aggregates[v.Func] {
+				return nil, fmt.Errorf("aggregate function %s is not allowed in %s", v, clause)
+			}
+		}
+		return nil, nil
+	})
+}
+
+// planner turns queries into DataFrames over the views of a catalog
+type planner struct {
+	views func(name string) (*dataframe.DataFrame, bool)
+}
+
+func (p *planner) plan(q *query) (*dataframe.DataFrame, error) {
+	rel, err := p.from(q)
+	if err != nil {
+		return nil, err
+	}
+	if q.where != nil {
+		where, err := rel.resolve(q.where, "WHERE")
+		if err != nil {
+			return nil, err
+		}
+		rel.df = rel.df.Where(where)
+	}
+
+	// scope resolves the expressions of the select list, HAVING and ORDER BY
+	scope := rel.resolve
+	if q.aggregated() {
+		agg, err := p.aggregate(q, rel)
+		if err != nil {
+			return nil, err
+		}
+		scope = agg.resolve
+		rel.df = agg.df
+		if q.having != nil {
+			having, err := scope(q.having, "HAVING")
+			if err != nil {
+				return nil, err
+			}
+			rel.df = rel.df.Where(having)
+		}
+	}
+
+	outputs, names, err := selectList(q, rel, scope)
+	if err != nil {
+		return nil, err
+	}
+	df := rel.df
+	if len(q.orderBy) == 0 {
+		df = df.Select(outputs...)
+		if q.distinct {
+			df = df.Distinct()
+		}
+	} else {
+		orders, hidden, err := orderBy(q, names, scope)
+		if err != nil {
+			return nil, err
+		}
+		if q.distinct && len(hidden) > 0 {
+			return nil, fmt.Errorf("ORDER BY of a SELECT DISTINCT must use selected columns")
+		}
+		df = df.Select(append(outputs, hidden...)...)
+		if q.distinct {
+			df = df.Distinct()
+		}
+		df = df.OrderBy(orders...)
+		if len(hidden) > 0 {
+			columns := make([]expr.Expr, len(names))
+			for i, name := range names {
+				columns[i] = expr.Col(name)
+			}
+			df = df.Select(columns...)
+		}
+	}
+	if q.limit >= 0 {
+		df = df.Limit(q.limit)
+	}
+	return df, df.Err()
+}
+
+// from plans the FROM clause and its joins
+func (p *planner) from(q *query) (*relation, error) {
+	rel, err := p.table(q.from)
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range q.joins {
+		right, err := p.table(j.table)
+		if err != nil {
+			return nil, err
+		}
+		if rel, err = join(rel, right, j); err != nil {
+			return nil, err
+		}
+	}
+	return rel, nil
+}
+
+// table plans a view or subquery of the FROM clause
+func (p *planner) table(t tableRef) (*relation, error) {
+	var df *dataframe.DataFrame
+	qualifier := t.alias
+	if t.subquery != nil {
+		var err error
+		if df, err = p.plan(t.subquery); err != nil {
+			return nil, err
+		}
+	} else {
+		view, ok := p.views(t.name)
+		if !ok {
+			return nil, fmt.Errorf("unknown view %s", t.name)
+		}
+		df = view
+		if qualifier == "" {
+			qualifier = t.name
+		}
+	}
+	rel := &relation{df: df}
+	for _, name := range df.Columns() {
+		rel.columns = append(rel.columns, column{table: qualifier, name: name, internal: name})
+	}
+	return rel, nil
+}
+
+// join joins a table to the relation planned so far, first renaming the columns of
+// the table whose names the relation already uses
+func join(left, right *relation, j joinClause) (*relation, error) {
+	table := ""
+	if len(right.columns) > 0 {
+		table = right.columns[0].table
+	}
+	taken := make(map[string]bool)
+	for _, c := range left.columns {
+		if table != "" && strings.EqualFold(c.table, table) {
+			return nil, fmt.Errorf("table name %s is used twice, give it an alias", c.table)
+		}
+		taken[c.internal] = true
+	}
+	renames := make([]expr.Expr, len(right.columns))
+	renamed := false
+	for i, c := range right.columns {
+		renames[i] = expr.Col(c.internal)
+		if taken[c.internal] {
+			internal := c.internal
+			for n := 1; taken[internal]; n++ {
+				internal = c.internal + "#" + strconv.Itoa(n)
+			}
+			renames[i] = expr.As(renames[i], internal)
+			right.columns[i].internal = internal
+			renamed = true
+		}
+		taken[right.columns[i].internal] = true
+	}
+	if renamed {
+		right.df = right.df.Select(renames...)
+	}
+
+	both := &relation{columns: append(append([]column(nil), left.columns...), right.columns...)}
+	var on expr.Expr
+	if j.on != nil {
+		var err error
+		if on, err = both.resolve(j.on, "ON"); err != nil {
+			return nil, err
+		}
+	}
+	both.df = left.df.JoinOn(right.df, on, j.how)
+	if j.how == dataframe.LeftSemi || j.how == dataframe.LeftAnti {
+		both.columns = left.columns
+	}
+	return both, both.df.Err()
+}
+
+// aggregated reports whether a query groups its rows
+func (q *query) aggregated() bool {
+	if len(q.groupBy) > 0 || q.having != nil {
+		return true
+	}
+	found := false
+	check := func(e expr.Expr) {
+		expr.Walk(e, func(n expr.Expr) {
+			if c, ok := n.(expr.Call); ok && aggregates[c.Func] {
+				found = true
+			}
+		})
+	}
+	for _, item := range q.items {
+		if !item.star {
+			check(item.expr)
+		}
+	}
+	for _, o := range q.orderBy {
+		check(o.expr)
+	}
+	return found
+}
+
+// aggregation is the result of GROUP BY: expressions of the select list, HAVING and
+// ORDER BY may only read grouping expressions and aggregates
+type aggregation struct {
+	df *dataframe.DataFrame
+	// groups maps grouping expressions, in SQL form, to their output columns
+	groups map[string]string
+	// columns maps the DataFrame columns grouped by to themselves
+	columns map[string]bool
+	// aggregates maps aggregate calls, in SQL form, to their output columns
+	aggregates map[string]string
+	input      *relation
+}
+
+func (p *planner) aggregate(q *query, rel *relation) (*aggregation, error) {
+	agg := &aggregation{groups: make(map[string]string), columns: make(map[string]bool), aggregates: make(map[string]string), input: rel}
+	df := rel.df
+	schema := df.Schema()
+	groups, err := groupBy(q)
+	if err != nil {
+		return nil, err
+	}
+	var groupColumns []string
+	for _, g := range groups {
+		resolved, err := rel.resolve(g, "GROUP BY")
+		if err != nil {
+			return nil, err
+		}
+		name := g.String()
+		if c, ok := resolved.(expr.Column); ok && schema.Index(c.Name) >= 0 {
+			name = c.Name
+			agg.columns[name] = true
+		} else {
+			df = df.WithColumn(name, resolved)
+		}
+		agg.groups[g.String()] = name
+		groupColumns = append(groupColumns, name)
+	}
+
+	var aggs []dataframe.Aggregation
+	collect := func(e expr.Expr) error {
+		var err error
+		expr.Walk(e, func(n expr.Expr) {
+			c, ok := n.(expr.Call)
+			if !ok || !aggregates[c.Func] || err != nil {
+				return
+			}
+			if _, ok := agg.aggregates[c.String()]; ok {
+				return
+			}
+			a := dataframe.Aggregation{Func: c.Func, Name: c.String()}
+			if arg := c.Args[0]; arg != (expr.Column{Name: "*"}) {
+				expr.Walk(arg, func(inner expr.Expr) {
+					if ic, ok := inner.(expr.Call); ok && aggregates[ic.Func] && err == nil {
+						err = fmt.Errorf("aggregate function %s is nested in %s", ic, c)
+					}
+				})
+				if err == nil {
+					a.Expr, err = rel.resolve(arg, "an aggregate")
+				}
+			}
+			agg.aggregates[c.String()] = a.Name
+			aggs = append(aggs, a)
+		})
+		return err
+	}
+	for _, item := range q.items {
+		if !item.star {
+			if err := collect(item.expr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if q.having != nil {
+		if err := collect(q.having); err != nil {
+			return nil, err
+		}
+	}
+	for _, o := range q.orderBy {
+		if err := collect(o.expr); err != nil {
+			return nil, err
+		}
+	}
+	if len(aggs) == 0 {
+		// GROUP BY without aggregates only lists the distinct groups
+		agg.df = df.Select(columnsOf(groupColumns)...).Distinct()
+	} else {
+		agg.df = df.GroupBy(groupColumns...).Agg(aggs...)
+	}
+	return agg, agg.df.Err()
+}
+
+// groupBy returns the GROUP BY expressions, replacing positions such as 1 with the
+// expression of that item of the select list, as ORDER BY does
+func groupBy(q *query) ([]expr.Expr, error) {
+	out := make([]expr.Expr, len(q.groupBy))
+	for i, g := range q.groupBy {
+		out[i] = g
+		l, ok := g.(expr.Literal)
+		if !ok {
+			continue
+		}
+		n, ok := l.Value.(int)
+		if !ok {
+			continue
+		}
+		if n < 1 || n > len(q.items) {
+			return nil, fmt.Errorf("GROUP BY position %d is not in the select list", n)
+		}
+		item := q.items[n-1]
+		if item.star {
+			return nil, fmt.Errorf("GROUP BY position %d refers to a star, list the columns instead", n)
+		}
+		var nested error
+		expr.Walk(item.expr, func(e expr.Expr) {
+			if c, ok := e.(expr.Call); ok && aggregates[c.Func] && nested == nil {
+				nested = fmt.Errorf("GROUP BY position %d refers to aggregate function %s", n, c)
+			}
+		})
+		if nested != nil {
+			return nil, nested
+		}
+		out[i] = item.expr
+	}
+	return out, nil
+}
+
+// resolve rewrites an expression over the groups into one over the aggregated rows
+func (a *aggregation) resolve(e expr.Expr, clause string) (expr.Expr, error) {
+	return expr.Rewrite(e, func(n expr.Expr) (expr.Expr, error) {
+		if name, ok := a.groups[n.String()]; ok {
+			return expr.Col(name), nil
+		}
+		switch v := n.(type) {
+		case expr.Call:
+			if name, ok := a.aggregates[v.String()]; ok {
+				return expr.Col(name), nil
+			}
+		case expr.Column:
+			name, err := a.input.lookup(v.Name)
+			if err != nil {
+				return nil, err
+			}
+			if a.columns[name] {
+				return expr.Col(name), nil
+			}
+			return nil, fmt.Errorf("column %s in %s must appear in GROUP BY or be used in an aggregate function", v.Name, clause)
+		}
+		return nil, nil
+	})
+}
+
+func columnsOf(names []string) []expr.Expr {
+	out := make([]expr.Expr, len(names))
+	for i, name := range names {
+		out[i] = expr.Col(name)
+	}
+	return out
+}
+
+// selectList resolves the select list, expanding stars, and returns the output
+// expressions and column names. Only aliases must be unique: a column named by
+// default after one already in the list, such as u.k after e.k, takes the name of
+// its renamed DataFrame column, k#1, as a star would.
+func selectList(q *query, rel *relation, scope func(expr.Expr, string) (expr.Expr, error)) ([]expr.Expr, []string, error) {
+	var outputs []expr.Expr
+	var names []string
+	used := make(map[string]bool)
+	aliases := make(map[string]bool)
+	for _, item := range q.items {
+		if item.alias != "" {
+			aliases[item.alias] = true
+		}
+	}
+	taken := func(name string) bool {
+		return used[name] || aliases[name]
+	}
+	// unique returns name, or else the DataFrame column read, or else name#n
+	unique := func(name string, e expr.Expr) string {
+		if !taken(name) {
+			return name
+		}
+		if c, ok := e.(expr.Column); ok && !taken(c.Name) {
+			return c.Name
+		}
+		candidate := name
+		for n := 1; taken(candidate); n++ {
+			candidate = name + "#" + strconv.Itoa(n)
+		}
+		return candidate
+	}
+	add := func(e expr.Expr, name string) {
+		if c, ok := e.(expr.Column); !ok || c.Name != name {
+			e = expr.As(e, name)
+		}
+		outputs = append(outputs, e)
+		names = append(names, name)
+		used[name] = true
+	}
+	for _, item := range q.items {
+		if !item.star {
+			e, err := scope(item.expr, "the select list")
+			if err != nil {
+				return nil, nil, err
+			}
+			name := outputName(item)
+			if item.alias == "" {
+				name = unique(name, e)
+			} else if used[name] {
+				return nil, nil, fmt.Errorf("duplicate column %s in the select list", name)
+			}
+			add(e, name)
+			continue
+		}
+		found := false
+		for _, c := range rel.columns {
+			if item.table != "" && !strings.EqualFold(c.table, item.table) {
+				continue
+			}
+			found = true
+			ref := c.name
+			if c.table != "" {
+				ref = c.table + "." + c.name
+			}
+			e, err := scope(expr.Col(ref), "the select list")
+			if err != nil {
+				return nil, nil, err
+			}
+			// a star keeps the renamed column when joined tables share a name
+			add(e, unique(c.name, e))
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("unknown table %s in %s.*", item.table, item.table)
+		}
+	}
+	return outputs, names, nil
+}
+
+// outputName names a column of the select list: by its alias, by its column name
+// without qualifier, or by its SQL form
+func outputName(item selectItem) string {
+	if item.alias != "" {
+		return item.alias
+	}
+	if c, ok := item.expr.(expr.Column); ok {
+		return c.Name[strings.LastIndexByte(c.Name, '.')+1:]
+	}
+	return item.expr.String()
+}
+
+// orderBy resolves the ORDER BY items. Items naming an output column, by name,
+// position or expression, sort by it; others are computed as hidden columns added
+// to the select list.
+func orderBy(q *query, names []string, scope func(expr.Expr, string) (expr.Expr, error)) ([]dataframe.Order, []expr.Expr, error) {
+	var orders []dataframe.Order
+	var hidden []expr.Expr
+	for _, o := range q.orderBy {
+		name, ok := orderColumn(o.expr, q, names)
+		if !ok {
+			e, err := scope(o.expr, "ORDER BY")
+			if err != nil {
+				return nil, nil, err
+			}
+			name = "__order" + strconv.Itoa(len(hidden))
+			hidden = append(hidden, expr.As(e, name))
+		}
+		orders = append(orders, dataframe.Order{Expr: expr.Col(name), Desc: o.desc})
+	}
+	return orders, hidden, nil
+}
+
+func orderColumn(e expr.Expr, q *query, names []string) (string, bool) {
+	switch v := e.(type) {
+	case expr.Literal:
+		if n, ok := v.Value.(int); ok && n >= 1 && n <= len(names) {
+			return names[n-1], true
+		}
+	case expr.Column:
+		for _, name := range names {
+			if name == v.Name {
+				return name, true
+			}
+		}
+	}
+	i := 0
+	for _, item := range q.items {
+		if item.star {
+			// stars expand to columns, matched by name above
+			return "", false
+		}
+		if item.expr.String() == e.String() {
+			return names[i], true
+		}
+		i++
+	}
+	return "", false
+}
@@ -0,0 +1,205 @@
+package sql
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bajor/spark-go-core/dataframe"
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/scheduler"
+	"github.com/bajor/spark-go-core/schema"
+)
+
+func table(s schema.Schema, rows ...[]interface{}) *dataframe.DataFrame {
+	data := make([]interface{}, len(rows))
+	for i, r := range rows {
+		m := make(map[string]interface{})
+		for j, f := range s.Fields {
+			m[f.Name] = r[j]
+		}
+		data[i] = m
+	}
+	return dataframe.New(rdd.NewKeyedRDD(data, nil).Repartition(2), s)
+}
+
+func testCatalog(t *testing.T) *Catalog {
+	t.Helper()
+	c := NewCatalog()
+	events := table(schema.New(
+		schema.Field{Name: "k", Type: schema.String},
+		schema.Field{Name: "x", Type: schema.Long},
+		schema.Field{Name: "user_id", Type: schema.Long, Nullable: true},
+	),
+		[]interface{}{"a", 1, 1},
+		[]interface{}{"a", 5, 1},
+		[]interface{}{"b", 7, 2},
+		[]interface{}{"b", 9, nil},
+		[]interface{}{"c", 6, 3},
+		[]interface{}{"a", 8, 2},
+	)
+	users := table(schema.New(
+		schema.Field{Name: "user_id", Type: schema.Long},
+		schema.Field{Name: "name", Type: schema.String},
+		schema.Field{Name: "k", Type: schema.String, Nullable: true},
+	),
+		[]interface{}{1, "ann", "a"},
+		[]interface{}{2, "bob", nil},
+		[]interface{}{4, "cid", "c"},
+	)
+	for name, df := range map[string]*dataframe.DataFrame{"events": events, "users": users} {
+		if err := c.Register(name, df); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func run(t *testing.T, c *Catalog, query string) []string {
+	t.Helper()
+	df, err := c.Query(query)
+	if err != nil {
+		t.Fatalf("Query(%q) failed with error: %v", query, err)
+	}
+	rows, err := df.Collect(context.Background(), scheduler.New(scheduler.Config{Parallelism: 2}))
+	if err != nil {
+		t.Fatalf("Collect of %q failed with error: %v", query, err)
+	}
+	out := make([]string, len(rows))
+	for i, r := range rows {
+		out[i] = r.String()
+	}
+	return out
+}
+
+func TestQuery(t *testing.T) {
+	c := testCatalog(t)
+	tests := []struct {
+		query string
+		// ordered compares the rows in order; other results are sorted first
+		ordered bool
+		want    []string
+	}{
+		{query: "SELECT k, count(*) FROM events WHERE x > 4 GROUP BY k", want: []string{"[a, 2]", "[b, 2]", "[c, 1]"}},
+		{query: "select k, sum(x) as total, avg(x), min(x), max(x) from events group by k having count(*) > 1 order by total desc", ordered: true,
+			want: []string{"[b, 16, 8, 7, 9]", "[a, 14, 4.666666666666667, 1, 8]"}},
+		{query: "SELECT * FROM events WHERE k IN ('b', 'c') AND user_id IS NOT NULL", want: []string{"[b, 7, 2]", "[c, 6, 3]"}},
+		{query: "SELECT upper(k) || '-' || x AS label FROM events WHERE x BETWEEN 5 AND 7 ORDER BY x", ordered: true, want: []string{"[A-5]", "[C-6]", "[B-7]"}},
+		{query: "SELECT x FROM events ORDER BY k DESC, x LIMIT 3", ordered: true, want: []string{"[6]", "[7]", "[9]"}},
+		{query: "SELECT DISTINCT k FROM events ORDER BY 1", ordered: true, want: []string{"[a]", "[b]", "[c]"}},
+		{query: "SELECT count(*), sum(x) FROM events WHERE x > 100", want: []string{"[0, null]"}},
+		{query: "SELECT length(k) + 1, substring(name, 1, 2) FROM users WHERE name LIKE '%n%'", want: []string{"[2, an]"}},
+		{query: "SELECT e.k, u.name FROM events e JOIN users u ON e.user_id = u.user_id WHERE e.x > 1", want: []string{"[a, ann]", "[a, bob]", "[b, bob]"}},
+		{query: "SELECT e.x, u.k FROM events AS e LEFT JOIN users AS u ON e.user_id = u.user_id AND u.k IS NOT NULL WHERE e.k = 'b'", want: []string{"[7, null]", "[9, null]"}},
+		{query: "SELECT name FROM users u LEFT ANTI JOIN events e ON u.user_id = e.user_id", want: []string{"[cid]"}},
+		{query: "SELECT name, count(*) AS n FROM events JOIN users ON events.user_id = users.user_id GROUP BY name ORDER BY n DESC, name", ordered: true, want: []string{"[ann, 2]", "[bob, 2]"}},
+		{query: "SELECT t.k, t.total FROM (SELECT k, sum(x) AS total FROM events GROUP BY k) t WHERE t.total > 10", want: []string{"[a, 14]", "[b, 16]"}},
+		{query: "SELECT upper(k), count(*) FROM events GROUP BY upper(k) ORDER BY count(*) DESC LIMIT 1", ordered: true, want: []string{"[A, 3]"}},
+		{query: "SELECT * FROM users u FULL JOIN events e ON u.k = e.k AND e.x > 7 WHERE u.name IS NULL OR e.k IS NULL",
+			want: []string{"[2, bob, null, null, null, null]", "[4, cid, c, null, null, null]", "[null, null, null, a, 1, 1]", "[null, null, null, a, 5, 1]", "[null, null, null, b, 7, 2]", "[null, null, null, b, 9, null]", "[null, null, null, c, 6, 3]"}},
+		{query: "SELECT k FROM events GROUP BY k HAVING sum(x) - 1 > 13 -- a comment\n;", want: []string{"[b]"}},
+		{query: "SELECT k, count(*) FROM events GROUP BY 1", want: []string{"[a, 3]", "[b, 2]", "[c, 1]"}},
+		{query: "SELECT x % 2 AS odd, sum(x) FROM events GROUP BY 1 ORDER BY 1", ordered: true, want: []string{"[0, 14]", "[1, 22]"}},
+		{query: "SELECT e.k, u.k, e.x FROM events e JOIN users u ON e.k = u.k WHERE e.x < 6", want: []string{"[a, a, 1]", "[a, a, 5]"}},
+	}
+	for _, tt := range tests {
+		got := run(t, c, tt.query)
+		if !tt.ordered {
+			sort.Strings(got)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %v\nwant %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQuery_ColumnNames(t *testing.T) {
+	c := testCatalog(t)
+	df, err := c.Query("SELECT e.k, count(*), sum(x) + 1, x % 2 = 0 AS even FROM events e GROUP BY e.k, x % 2 = 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := df.Columns(), []string{"k", "count(*)", "(sum(x) + 1)", "even"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Columns: got %v, want %v", got, want)
+	}
+	df, err = c.Query("SELECT * FROM events JOIN users ON events.user_id = users.user_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := df.Columns(), []string{"k", "x", "user_id", "user_id#1", "name", "k#1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Columns of joined tables sharing names: got %v, want %v", got, want)
+	}
+	df, err = c.Query("SELECT e.k, u.k FROM events e JOIN users u ON e.k = u.k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := df.Columns(), []string{"k", "k#1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Columns of a select list naming clashing columns: got %v, want %v", got, want)
+	}
+	// default names keep clear of aliases
+	df, err = c.Query("SELECT u.k, e.k, e.x AS `k#1` FROM events e JOIN users u ON e.k = u.k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := df.Columns(), []string{"k", "k#2", "k#1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Columns of a select list naming clashing columns: got %v, want %v", got, want)
+	}
+}
+
+func TestQuery_Errors(t *testing.T) {
+	c := testCatalog(t)
+	tests := []struct {
+		query, want string
+	}{
+		{"SELECT k FROM", "syntax error at position 13: expected a view name"},
+		{"SELECT k FROM events WHERE", "unexpected end of query"},
+		{"SELECT k, FROM events", "unexpected FROM"},
+		{"SELECT 'open FROM events", "unterminated quote"},
+		{"SELECT k FROM events LIMIT x", "expected a row count"},
+		{"SELECT nope(k) FROM events", `unknown function "nope"`},
+		{"SELECT count(DISTINCT k) FROM events", "not supported"},
+		{"SELECT k FROM missing", "unknown view missing"},
+		{"SELECT z FROM events", "unknown column z"},
+		{"SELECT user_id FROM events JOIN users ON events.k = users.k", "column user_id is ambiguous"},
+		{"SELECT k, x FROM events GROUP BY k", "column x in the select list must appear in GROUP BY"},
+		{"SELECT k FROM events WHERE count(*) > 1", "not allowed in WHERE"},
+		{"SELECT sum(count(*)) FROM events", "is nested in"},
+		{"SELECT k FROM events WHERE k + 1 > 2", "not numbers"},
+		{"SELECT DISTINCT k FROM events ORDER BY x", "must use selected columns"},
+		{"SELECT k FROM events e JOIN events e ON e.k = e.k", "used twice"},
+		{"SELECT k AS a, x AS a FROM events", "duplicate column a in the select list"},
+		{"SELECT k FROM events GROUP BY 2", "GROUP BY position 2 is not in the select list"},
+		{"SELECT count(*) FROM events GROUP BY 1", "refers to aggregate function count(*)"},
+		{"SELECT * FROM events GROUP BY 1", "refers to a star"},
+	}
+	for _, tt := range tests {
+		_, err := c.Query(tt.query)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.query, err, tt.want)
+		}
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	c := testCatalog(t)
+	if got, want := c.Views(), []string{"events", "users"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Views: got %v, want %v", got, want)
+	}
+	if _, ok := c.View("EVENTS"); !ok {
+		t.Errorf("View names should be case-insensitive")
+	}
+	if err := c.Register("select", c.views["users"]); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(t, c, "SELECT count(*) FROM `select`"); !reflect.DeepEqual(got, []string{"[3]"}) {
+		t.Errorf("Querying a quoted view name: got %v", got)
+	}
+	if !c.Drop("users") || c.Drop("users") {
+		t.Errorf("Drop should report whether the view existed")
+	}
+	if _, err := c.Query("SELECT * FROM users"); err == nil {
+		t.Errorf("A dropped view should be unknown")
+	}
+}
@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"sync"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+)
+
+// Recorder gathers the statistics of the partitions of a source while they are
+// read, so that a source knows them once every partition was read to the end
+type Recorder struct {
+	mu    sync.Mutex
+	parts []*Collector
+}
+
+// NewRecorder creates a Recorder for a source with the given number of partitions
+func NewRecorder(partitions int) *Recorder {
+	return &Recorder{parts: make([]*Collector, partitions)}
+}
+
+// Observe returns an iterator over the records of it which records their statistics
+// as those of a partition once it is exhausted without error
+func (r *Recorder) Observe(partition int, it lazy.SourceIterator) lazy.SourceIterator {
+	return &observer{SourceIterator: it, recorder: r, partition: partition, collector: NewCollector()}
+}
+
+// record keeps the statistics of a partition read to the end. A later read keeps
+// the statistics it replaces unless it saw more columns, e.g. of a source reading
+// every column instead of some.
+func (r *Recorder) record(partition int, c *Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if partition < 0 || partition >= len(r.parts) {
+		return
+	}
+	if old := r.parts[partition]; old == nil || len(c.columns) > len(old.columns) {
+		r.parts[partition] = c
+	}
+}
+
+// Statistics merges the statistics of every partition. It reports false until each
+// was read. Columns that some non-empty partition did not read are left out.
+func (r *Recorder) Statistics() (Statistics, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	all := NewCollector()
+	for _, p := range r.parts {
+		if p == nil {
+			return Statistics{}, false
+		}
+		all.Merge(p)
+	}
+	s := all.Statistics()
+	for _, p := range r.parts {
+		for name := range s.Columns {
+			if _, ok := p.columns[name]; !ok && p.rows > 0 {
+				delete(s.Columns, name)
+			}
+		}
+	}
+	return s, true
+}
+
+// observer adds the records an iterator returns to a Collector
+type observer struct {
+	lazy.SourceIterator
+	recorder  *Recorder
+	partition int
+	collector *Collector
+	done      bool
+}
+
+func (o *observer) Next() (interface{}, bool) {
+	v, ok := o.SourceIterator.Next()
+	if ok {
+		o.collector.Add(v)
+	} else if !o.done && o.Err() == nil {
+		o.done = true
+		o.recorder.record(o.partition, o.collector)
+	}
+	return v, ok
+}
+
+func (o *observer) Reset() {
+	o.SourceIterator.Reset()
+	o.collector, o.done = NewCollector(), false
+}
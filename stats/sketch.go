@@ -0,0 +1,112 @@
+package stats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"reflect"
+)
+
+// precision is the number of hash bits selecting a register of a sketch; 2^12
+// registers estimate distinct counts within about 2%
+const precision = 12
+
+// sketch is a HyperLogLog counter of distinct values. Registers are allocated on
+// the first value, so an empty sketch costs nothing.
+type sketch struct {
+	registers []uint8
+}
+
+func (s *sketch) add(v interface{}) {
+	h := hash(v)
+	if s.registers == nil {
+		s.registers = make([]uint8, 1<<precision)
+	}
+	i := h >> (64 - precision)
+	rank := uint8(bits.LeadingZeros64(h<<precision|1<<(precision-1))) + 1
+	if rank > s.registers[i] {
+		s.registers[i] = rank
+	}
+}
+
+func (s *sketch) merge(other *sketch) {
+	if other.registers == nil {
+		return
+	}
+	if s.registers == nil {
+		s.registers = make([]uint8, len(other.registers))
+	}
+	for i, r := range other.registers {
+		if r > s.registers[i] {
+			s.registers[i] = r
+		}
+	}
+}
+
+// estimate returns the estimated number of distinct values added
+func (s *sketch) estimate() int64 {
+	if s.registers == nil {
+		return 0
+	}
+	m := float64(len(s.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range s.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small cardinalities
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(e))
+}
+
+// hash hashes a value so that numbers equal by expr.Compare, such as 1 and 1.0,
+// hash alike
+func hash(v interface{}) uint64 {
+	h := fnv.New64a()
+	var buf [9]byte
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf[0] = 'i'
+		binary.LittleEndian.PutUint64(buf[1:], uint64(rv.Int()))
+		h.Write(buf[:])
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		buf[0] = 'i'
+		binary.LittleEndian.PutUint64(buf[1:], rv.Uint())
+		h.Write(buf[:])
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			buf[0] = 'i'
+			binary.LittleEndian.PutUint64(buf[1:], uint64(int64(f)))
+		} else {
+			buf[0] = 'f'
+			binary.LittleEndian.PutUint64(buf[1:], math.Float64bits(f))
+		}
+		h.Write(buf[:])
+	case reflect.String:
+		h.Write([]byte{'s'})
+		h.Write([]byte(rv.String()))
+	default:
+		fmt.Fprintf(h, "%T%v", v, v)
+	}
+	return mix(h.Sum64())
+}
+
+// mix spreads the bits of an FNV hash, whose high bits vary little for short inputs,
+// with the finalizer of SplitMix64
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,196 @@
+// Package stats summarizes sets of records for query planning: how many there are,
+// their size and, per column, the number of distinct values, the nulls and the
+// smallest and largest value. A Collector gathers them in a single pass over the
+// records as they are read, in memory bounded by the number of columns.
+package stats
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/bajor/spark-go-core/expr"
+)
+
+// Statistics describe a set of records
+type Statistics struct {
+	Rows int64
+	// Bytes is the size of the records, estimated in memory or read from file metadata
+	Bytes int64
+	// Columns holds the statistics of the columns of map records, where known
+	Columns map[string]Column
+}
+
+// Column describes the values of one column
+type Column struct {
+	// Distinct estimates the number of distinct non-null values; 0 is unknown
+	Distinct int64
+	// Nulls counts the null or missing values
+	Nulls int64
+	// Min and Max are the smallest and largest values; nil when unknown or when the
+	// values do not compare with each other
+	Min, Max interface{}
+	// Bytes is the estimated size of the column's values
+	Bytes int64
+}
+
+func (s Statistics) String() string {
+	names := make([]string, 0, len(s.Columns))
+	for name := range s.Columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "rows %d, bytes %d", s.Rows, s.Bytes)
+	for _, name := range names {
+		c := s.Columns[name]
+		fmt.Fprintf(&b, ", %s: distinct %d nulls %d", name, c.Distinct, c.Nulls)
+		if c.Min != nil {
+			fmt.Fprintf(&b, " min %v max %v", c.Min, c.Max)
+		}
+	}
+	return b.String()
+}
+
+// Collector gathers the statistics of the records added to it
+type Collector struct {
+	rows, bytes int64
+	columns     map[string]*columnCollector
+}
+
+// columnCollector gathers the statistics of one column
+type columnCollector struct {
+	values, bytes int64
+	sketch        sketch
+	min, max      interface{}
+	// incomparable is set once two values fail to compare
+	incomparable bool
+}
+
+// NewCollector creates an empty Collector
+func NewCollector() *Collector {
+	return &Collector{columns: make(map[string]*columnCollector)}
+}
+
+// Add adds a record. The columns of map records are summarized one by one; other
+// records only count towards the number of rows and their size.
+func (c *Collector) Add(record interface{}) {
+	c.rows++
+	m, ok := record.(map[string]interface{})
+	if !ok {
+		c.bytes += Size(record)
+		return
+	}
+	for name, v := range m {
+		col := c.columns[name]
+		if col == nil {
+			col = &columnCollector{}
+			c.columns[name] = col
+		}
+		n := Size(v)
+		c.bytes += int64(len(name)) + n
+		col.add(v, n)
+	}
+}
+
+func (col *columnCollector) add(v interface{}, n int64) {
+	if v == nil {
+		return
+	}
+	col.values++
+	col.bytes += n
+	col.sketch.add(v)
+	col.bound(v, v)
+}
+
+// bound widens the minimum and maximum to include lo and hi
+func (col *columnCollector) bound(lo, hi interface{}) {
+	if col.incomparable || lo == nil || hi == nil {
+		return
+	}
+	if col.min == nil {
+		col.min, col.max = lo, hi
+		return
+	}
+	cmin, err := expr.Compare(lo, col.min)
+	if err != nil {
+		col.incomparable, col.min, col.max = true, nil, nil
+		return
+	}
+	cmax, err := expr.Compare(hi, col.max)
+	if err != nil {
+		col.incomparable, col.min, col.max = true, nil, nil
+		return
+	}
+	if cmin < 0 {
+		col.min = lo
+	}
+	if cmax > 0 {
+		col.max = hi
+	}
+}
+
+// Merge adds the records summarized by another Collector, e.g. of another partition
+func (c *Collector) Merge(other *Collector) {
+	c.rows += other.rows
+	c.bytes += other.bytes
+	for name, o := range other.columns {
+		col := c.columns[name]
+		if col == nil {
+			col = &columnCollector{}
+			c.columns[name] = col
+		}
+		col.values += o.values
+		col.bytes += o.bytes
+		col.sketch.merge(&o.sketch)
+		if o.incomparable {
+			col.incomparable, col.min, col.max = true, nil, nil
+		}
+		col.bound(o.min, o.max)
+	}
+}
+
+// Statistics returns the statistics of the records added so far. Columns missing from
+// some map records count as null there.
+func (c *Collector) Statistics() Statistics {
+	s := Statistics{Rows: c.rows, Bytes: c.bytes, Columns: make(map[string]Column, len(c.columns))}
+	for name, col := range c.columns {
+		s.Columns[name] = Column{
+			Distinct: col.sketch.estimate(),
+			Nulls:    c.rows - col.values,
+			Min:      col.min,
+			Max:      col.max,
+			Bytes:    col.bytes,
+		}
+	}
+	return s
+}
+
+// Size estimates the bytes a value takes in memory, as counted by the statistics
+func Size(v interface{}) int64 {
+	switch x := v.(type) {
+	case nil, bool:
+		return 1
+	case string:
+		return int64(len(x))
+	case []byte:
+		return int64(len(x))
+	case map[string]interface{}:
+		var n int64
+		for k, e := range x {
+			n += int64(len(k)) + Size(e)
+		}
+		return n
+	case []interface{}:
+		var n int64
+		for _, e := range x {
+			n += Size(e)
+		}
+		return n
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Struct {
+		return int64(rv.Type().Size())
+	}
+	return 8
+}
@@ -0,0 +1,160 @@
+package stats
+
+import (
+	"fmt"
+	"testing"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+)
+
+func TestCollector_DistinctEstimates(t *testing.T) {
+	for _, n := range []int{1, 10, 1000, 10000, 100000} {
+		c := NewCollector()
+		for i := 0; i < 3*n; i++ {
+			c.Add(map[string]interface{}{"key": fmt.Sprintf("key-%d", i%n), "number": int64(i % n)})
+		}
+		s := c.Statistics()
+		for _, name := range []string{"key", "number"} {
+			got := float64(s.Columns[name].Distinct)
+			if got < 0.95*float64(n) || got > 1.05*float64(n) {
+				t.Errorf("Distinct values of %s among %d: got %v", name, n, got)
+			}
+		}
+	}
+}
+
+func TestCollector_Statistics(t *testing.T) {
+	c := NewCollector()
+	c.Add(map[string]interface{}{"age": int64(30), "name": "bob"})
+	c.Add(map[string]interface{}{"age": 41.5, "name": nil})
+	c.Add(map[string]interface{}{"age": int64(30)})
+	c.Add(map[string]interface{}{"age": int64(12), "name": "alice", "tags": []interface{}{"x"}})
+	s := c.Statistics()
+
+	if s.Rows != 4 || s.Bytes == 0 {
+		t.Errorf("Rows and bytes: got %d and %d", s.Rows, s.Bytes)
+	}
+	age := s.Columns["age"]
+	if age.Distinct != 3 || age.Nulls != 0 || age.Min != int64(12) || age.Max != 41.5 {
+		t.Errorf("age: got %+v", age)
+	}
+	name := s.Columns["name"]
+	if name.Distinct != 2 || name.Nulls != 2 || name.Min != "alice" || name.Max != "bob" {
+		t.Errorf("name: got %+v", name)
+	}
+	if tags := s.Columns["tags"]; tags.Nulls != 3 || tags.Distinct != 1 {
+		t.Errorf("tags: got %+v", tags)
+	}
+
+	mixed := NewCollector()
+	mixed.Add(map[string]interface{}{"v": int64(1)})
+	mixed.Add(map[string]interface{}{"v": "one"})
+	if v := mixed.Statistics().Columns["v"]; v.Min != nil || v.Max != nil || v.Distinct != 2 {
+		t.Errorf("Values that do not compare: got %+v", v)
+	}
+
+	plain := NewCollector()
+	plain.Add("text")
+	plain.Add(42)
+	if s := plain.Statistics(); s.Rows != 2 || s.Bytes != 12 || len(s.Columns) != 0 {
+		t.Errorf("Records other than maps: got %s", s)
+	}
+}
+
+func TestCollector_MergeMatchesSinglePass(t *testing.T) {
+	all, a, b := NewCollector(), NewCollector(), NewCollector()
+	for i := 0; i < 500; i++ {
+		record := map[string]interface{}{"n": int64(i % 200), "even": i%2 == 0}
+		all.Add(record)
+		if i < 150 {
+			a.Add(record)
+		} else {
+			b.Add(record)
+		}
+	}
+	a.Merge(b)
+	if got, want := a.Statistics().String(), all.Statistics().String(); got != want {
+		t.Errorf("Merged statistics: got %s, want %s", got, want)
+	}
+}
+
+func TestHash_EqualNumbersHashAlike(t *testing.T) {
+	if hash(int64(3)) != hash(3.0) || hash(int32(3)) != hash(uint8(3)) {
+		t.Errorf("Equal numbers of different types hash differently")
+	}
+	if hash(3.5) == hash(int64(3)) || hash("3") == hash(int64(3)) {
+		t.Errorf("Different values hash alike")
+	}
+}
+
+// sliceIterator is a SourceIterator over records, failing at the end if err is set
+type sliceIterator struct {
+	*lazy.SliceIterator
+	err  error
+	done bool
+}
+
+func (it *sliceIterator) Next() (interface{}, bool) {
+	v, ok := it.SliceIterator.Next()
+	it.done = !ok
+	return v, ok
+}
+
+func (it *sliceIterator) Err() error {
+	if it.done {
+		return it.err
+	}
+	return nil
+}
+
+func (it *sliceIterator) Close() error { return nil }
+
+func drain(it lazy.SourceIterator) {
+	for {
+		if _, ok := it.Next(); !ok {
+			return
+		}
+	}
+}
+
+func TestRecorder_KnowsStatisticsOnceEveryPartitionWasRead(t *testing.T) {
+	r := NewRecorder(2)
+	records := func(names ...string) []interface{} {
+		out := make([]interface{}, len(names))
+		for i, name := range names {
+			out[i] = map[string]interface{}{"name": name, "len": int64(len(name))}
+		}
+		return out
+	}
+
+	drain(r.Observe(0, &sliceIterator{SliceIterator: lazy.NewSliceIterator(records("ann", "bo"))}))
+	if _, ok := r.Statistics(); ok {
+		t.Errorf("Statistics known before the second partition was read")
+	}
+	drain(r.Observe(1, &sliceIterator{SliceIterator: lazy.NewSliceIterator(records("cy")), err: fmt.Errorf("broken")}))
+	if _, ok := r.Statistics(); ok {
+		t.Errorf("Statistics known after reading the second partition failed")
+	}
+	partial := r.Observe(1, &sliceIterator{SliceIterator: lazy.NewSliceIterator(records("cy", "dee"))})
+	partial.Next()
+	if _, ok := r.Statistics(); ok {
+		t.Errorf("Statistics known before the second partition was read to the end")
+	}
+	drain(partial)
+	s, ok := r.Statistics()
+	if !ok {
+		t.Fatalf("Statistics unknown after every partition was read")
+	}
+	if s.Rows != 4 || s.Columns["name"].Distinct != 4 || s.Columns["len"].Max != int64(3) {
+		t.Errorf("Statistics: got %s", s)
+	}
+
+	narrow := make([]interface{}, 2)
+	for i := range narrow {
+		narrow[i] = map[string]interface{}{"len": int64(i)}
+	}
+	drain(r.Observe(0, &sliceIterator{SliceIterator: lazy.NewSliceIterator(narrow)}))
+	if s, _ := r.Statistics(); s.Columns["name"].Distinct != 4 {
+		t.Errorf("A read of fewer columns replaced the statistics: got %s", s)
+	}
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BlockManager stores serialized blocks in memory under string ids. Shuffle outputs
+// and broadcast pieces live here until their owner removes them.
+type BlockManager struct {
+	mu     sync.RWMutex
+	blocks map[string][]byte
+	used   int64
+	limit  int64
+}
+
+// ErrNoSpace is returned by Put when a block does not fit in the memory limit
+var ErrNoSpace = errors.New("block manager memory limit exceeded")
+
+// NewBlockManager creates an empty block manager without a memory limit
+func NewBlockManager() *BlockManager {
+	return &BlockManager{blocks: make(map[string][]byte)}
+}
+
+// NewBlockManagerWithLimit creates an empty block manager holding at most limit bytes;
+// a limit of zero or less means no limit
+func NewBlockManagerWithLimit(limit int64) *BlockManager {
+	return &BlockManager{blocks: make(map[string][]byte), limit: limit}
+}
+
+// Put stores a block, replacing any block with the same id. It fails with ErrNoSpace
+// if the block would push the held bytes over the limit.
+func (m *BlockManager) Put(id string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	used := m.used + int64(len(data))
+	if old, ok := m.blocks[id]; ok {
+		used -= int64(len(old))
+	}
+	if m.limit > 0 && used > m.limit {
+		return fmt.Errorf("block %s of %d bytes: %w", id, len(data), ErrNoSpace)
+	}
+	m.blocks[id] = data
+	m.used = used
+	return nil
+}
+
+// Get returns a block; callers must not modify the returned slice
+func (m *BlockManager) Get(id string) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.blocks[id]
+	return data, ok
+}
+
+// Remove drops a block and reports whether it existed
+func (m *BlockManager) Remove(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.blocks[id]
+	if ok {
+		m.used -= int64(len(data))
+		delete(m.blocks, id)
+	}
+	return ok
+}
+
+// RemovePrefix drops every block whose id starts with prefix and returns how many were removed
+func (m *BlockManager) RemovePrefix(prefix string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed := 0
+	for id, data := range m.blocks {
+		if strings.HasPrefix(id, prefix) {
+			m.used -= int64(len(data))
+			delete(m.blocks, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// IDs returns the sorted ids of the blocks whose id starts with prefix
+func (m *BlockManager) IDs(prefix string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var ids []string
+	for id := range m.blocks {
+		if strings.HasPrefix(id, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Limit returns the memory limit in bytes, zero if there is none
+func (m *BlockManager) Limit() int64 {
+	return m.limit
+}
+
+// Size returns the number of bytes held
+func (m *BlockManager) Size() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.used
+}
+
+// Clear drops every block
+func (m *BlockManager) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blocks = make(map[string][]byte)
+	m.used = 0
+}
+
+// ShuffleBlockID names one bucket of one map task's shuffle output
+func ShuffleBlockID(shuffleID, mapID, bucket int) string {
+	return fmt.Sprintf("%s%d_%d", ShufflePrefix(shuffleID), mapID, bucket)
+}
+
+// ShufflePrefix is the id prefix shared by every block of a shuffle
+func ShufflePrefix(shuffleID int) string {
+	return fmt.Sprintf("shuffle_%d_", shuffleID)
+}
+
+// BroadcastPieceID names one chunk of a broadcast value
+func BroadcastPieceID(broadcastID int64, piece int) string {
+	return fmt.Sprintf("%s%d", BroadcastPrefix(broadcastID), piece)
+}
+
+// BroadcastPrefix is the id prefix shared by every piece of a broadcast
+func BroadcastPrefix(broadcastID int64) string {
+	return fmt.Sprintf("broadcast_%d_piece_", broadcastID)
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBlockManager_PutGetRemove(t *testing.T) {
+	m := NewBlockManager()
+	m.Put(ShuffleBlockID(1, 0, 0), []byte("abc"))
+	m.Put(ShuffleBlockID(1, 1, 0), []byte("de"))
+	m.Put(ShuffleBlockID(12, 0, 0), []byte("f"))
+	m.Put(BroadcastPieceID(1, 0), []byte("gh"))
+
+	if data, ok := m.Get("shuffle_1_0_0"); !ok || string(data) != "abc" {
+		t.Errorf("Get failed: got %q, %v", data, ok)
+	}
+	if m.Size() != 8 {
+		t.Errorf("Size failed: got %d, want 8", m.Size())
+	}
+
+	m.Put(ShuffleBlockID(1, 0, 0), []byte("x"))
+	if m.Size() != 6 {
+		t.Errorf("Size after replace failed: got %d, want 6", m.Size())
+	}
+
+	// shuffle 1 must not match shuffle 12
+	if removed := m.RemovePrefix(ShufflePrefix(1)); removed != 2 {
+		t.Errorf("RemovePrefix failed: removed %d, want 2", removed)
+	}
+	if ids := m.IDs(""); !reflect.DeepEqual(ids, []string{"broadcast_1_piece_0", "shuffle_12_0_0"}) {
+		t.Errorf("IDs failed: got %v", ids)
+	}
+	if !m.Remove("shuffle_12_0_0") || m.Remove("shuffle_12_0_0") {
+		t.Errorf("Remove should report whether the block existed")
+	}
+	if m.Size() != 2 {
+		t.Errorf("Size after removal failed: got %d, want 2", m.Size())
+	}
+}
+
+func TestBlockManager_MemoryLimit(t *testing.T) {
+	m := NewBlockManagerWithLimit(5)
+	if err := m.Put("a", []byte("abc")); err != nil {
+		t.Fatalf("Put within the limit failed with error: %v", err)
+	}
+	if err := m.Put("b", []byte("def")); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("Expected ErrNoSpace, got %v", err)
+	}
+	if _, ok := m.Get("b"); ok || m.Size() != 3 {
+		t.Errorf("Rejected block was stored: size %d", m.Size())
+	}
+	// replacing a block only counts the difference
+	if err := m.Put("a", []byte("abcde")); err != nil {
+		t.Errorf("Replacing within the limit failed with error: %v", err)
+	}
+}
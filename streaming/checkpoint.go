@@ -0,0 +1,113 @@
+package streaming
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bajor/spark-go-core/codec"
+	"github.com/bajor/spark-go-core/internal/atomicfile"
+)
+
+// checkpointFile is the name of the checkpoint inside the checkpoint directory
+const checkpointFile = "checkpoint"
+
+// stateful is implemented by the state of windowed and stateful streams
+type stateful interface {
+	// checkpoint returns the state as records the binary codec can encode
+	checkpoint() ([]interface{}, error)
+	// restore replaces the state with one returned by checkpoint
+	restore(records []interface{}) error
+}
+
+// Checkpoint makes the context save the state of its windowed and stateful streams
+// in dir after every batch. When dir already holds a checkpoint, Start restores it,
+// so a restarted application resumes its windows and per-key state; it must define
+// the same streams in the same order. State values must be encodable by the binary
+// codec: plain values, slices, maps and types registered with codec.Register.
+func (c *Context) Checkpoint(dir string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != created {
+		return ErrStarted
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	c.checkpointDir = dir
+	return nil
+}
+
+// checkpoint atomically replaces the checkpoint with the state after batch seq. The
+// checkpoint holds seq followed by the records of every stateful stream.
+func (c *Context) checkpoint(seq int64) error {
+	if c.checkpointDir == "" {
+		return nil
+	}
+	records := []interface{}{seq}
+	for _, d := range c.stateful {
+		state, err := d.state.checkpoint()
+		if err != nil {
+			return fmt.Errorf("checkpoint: %w", err)
+		}
+		records = append(records, state)
+	}
+	bin, err := codec.Lookup(codec.Binary)
+	if err != nil {
+		return err
+	}
+	data, err := codec.Marshal(bin, records)
+	if err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+
+	if err := atomicfile.Write(c.checkpointDir, checkpointFile, data); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	return nil
+}
+
+// restore loads the checkpoint, if there is one, into the stateful streams
+func (c *Context) restore() error {
+	if c.checkpointDir == "" {
+		return nil
+	}
+	path := filepath.Join(c.checkpointDir, checkpointFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	bin, err := codec.Lookup(codec.Binary)
+	if err != nil {
+		return err
+	}
+	records, err := codec.Unmarshal(bin, data)
+	if err != nil {
+		return fmt.Errorf("restore %s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("restore %s: empty checkpoint", path)
+	}
+	seq, ok := records[0].(int64)
+	if !ok {
+		return fmt.Errorf("restore %s: malformed batch number %v", path, records[0])
+	}
+	if len(records)-1 != len(c.stateful) {
+		return fmt.Errorf("restore %s: checkpoint holds %d stateful streams but the context defines %d", path, len(records)-1, len(c.stateful))
+	}
+	for i, d := range c.stateful {
+		state, ok := records[i+1].([]interface{})
+		if !ok {
+			return fmt.Errorf("restore %s: malformed state of stream %d", path, i)
+		}
+		if err := d.state.restore(state); err != nil {
+			return fmt.Errorf("restore %s: stream %d: %w", path, i, err)
+		}
+	}
+	c.seq = seq
+	return nil
+}
@@ -0,0 +1,362 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/spark"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// ErrStarted is returned when a context is started twice or changed after Start
+var ErrStarted = errors.New("streaming context was already started")
+
+// context states
+const (
+	created = iota
+	running
+	stopped
+)
+
+// BatchInfo records what happened in one batch
+type BatchInfo struct {
+	Time time.Time
+	// NumRecords counts the records received by all inputs
+	NumRecords int
+	// SchedulingDelay is how long the batch waited for the previous one
+	SchedulingDelay time.Duration
+	ProcessingTime  time.Duration
+	Err             error
+}
+
+// Context processes unbounded input as a series of small batches. Every batch
+// interval the records received by each input become a KeyedRDD, and every output
+// operation runs its DStream's chain on it. Batches run one at a time, in order.
+type Context struct {
+	sc       *spark.Context
+	interval time.Duration
+
+	mu            sync.Mutex
+	state         int
+	inputs        []Input
+	stateful      []*DStream
+	outputs       []func(ctx context.Context, b *batch) error
+	checkpointDir string
+	invalid       error
+	batches       []BatchInfo
+	err           error
+	next          time.Time
+	seq           int64
+
+	cancel   context.CancelFunc
+	quit     chan struct{}
+	loopDone chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// NewContext creates a streaming context cutting batches every interval and running
+// them on sc
+func NewContext(sc *spark.Context, interval time.Duration) (*Context, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("batch interval must be positive, got %v", interval)
+	}
+	return &Context{
+		sc:       sc,
+		interval: interval,
+		quit:     make(chan struct{}),
+		loopDone: make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}, nil
+}
+
+// SparkContext returns the context batches run on
+func (c *Context) SparkContext() *spark.Context {
+	return c.sc
+}
+
+// Interval returns the batch interval
+func (c *Context) Interval() time.Duration {
+	return c.interval
+}
+
+// InputStream creates a DStream of the records delivered by in
+func (c *Context) InputStream(in Input) *DStream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != created {
+		panic(ErrStarted)
+	}
+	c.inputs = append(c.inputs, in)
+	return &DStream{ctx: c, compute: func(b *batch) (*rdd.KeyedRDD, error) {
+		if src, ok := b.sources[in]; ok {
+			return rdd.FromSource(src), nil
+		}
+		return c.sc.Parallelize(b.inputs[in], 0), nil
+	}}
+}
+
+// ChannelStream creates a DStream of the elements received from ch. Receiving stops
+// when ch is closed or the context stops.
+func ChannelStream[T any](c *Context, ch <-chan T) *DStream {
+	return c.InputStream(channelInput(ch))
+}
+
+// SocketTextStream creates a DStream of the lines received from a TCP server
+func (c *Context) SocketTextStream(addr string) *DStream {
+	return c.InputStream(socketInput(addr))
+}
+
+// TextFileStream creates a DStream of the lines of files that appear in dir after the
+// stream starts. Files should be renamed into dir once complete; names starting with
+// "." or "_" are ignored.
+func (c *Context) TextFileStream(dir string) *DStream {
+	return c.InputStream(&fileInput{dir: dir})
+}
+
+func (c *Context) addOutput(out func(ctx context.Context, b *batch) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != created {
+		panic(ErrStarted)
+	}
+	c.outputs = append(c.outputs, out)
+}
+
+// statefulStream creates a DStream that carries state from one batch to the next. It
+// is computed every batch before the output operations, whether or not an output
+// uses it, and its state is checkpointed.
+func (c *Context) statefulStream(state stateful, compute func(b *batch) (*rdd.KeyedRDD, error)) *DStream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != created {
+		panic(ErrStarted)
+	}
+	d := &DStream{ctx: c, compute: compute, state: state}
+	c.stateful = append(c.stateful, d)
+	return d
+}
+
+// fail records an invalid stream definition, which Start returns
+func (c *Context) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.invalid == nil {
+		c.invalid = err
+	}
+}
+
+// batchesIn returns how many batches d spans; d must be a positive multiple of the
+// interval
+func (c *Context) batchesIn(name string, d time.Duration) (int, error) {
+	if d <= 0 || d%c.interval != 0 {
+		return 0, fmt.Errorf("%s %v is not a positive multiple of the batch interval %v", name, d, c.interval)
+	}
+	return int(d / c.interval), nil
+}
+
+// Start starts the inputs and the batch loop
+func (c *Context) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != created {
+		return ErrStarted
+	}
+	if c.invalid != nil {
+		return c.invalid
+	}
+	if len(c.outputs) == 0 {
+		return errors.New("streaming context has no output operations")
+	}
+	if err := c.restore(); err != nil {
+		return err
+	}
+	for i, in := range c.inputs {
+		if err := in.Start(); err != nil {
+			for _, started := range c.inputs[:i] {
+				started.Stop()
+			}
+			return err
+		}
+	}
+	c.state = running
+	c.next = time.Now().Add(c.interval)
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	go c.loop(ctx)
+	return nil
+}
+
+func (c *Context) loop(ctx context.Context) {
+	defer close(c.loopDone)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-ticker.C:
+		}
+		select {
+		case <-c.quit:
+			// a stop that raced with the tick wins
+			return
+		default:
+		}
+		if err := c.runBatch(ctx); err != nil {
+			if ctx.Err() == nil {
+				go c.Stop(false)
+			}
+			return
+		}
+	}
+}
+
+// batch holds the input records or sources of one batch and the RDDs computed for
+// it, so every DStream is derived once per batch however many outputs use it. seq
+// numbers batches from 1 and carries on from the checkpoint after a restart.
+type batch struct {
+	ctx     context.Context
+	time    time.Time
+	seq     int64
+	inputs  map[Input][]interface{}
+	sources map[Input]types.Source
+	rdds    map[*DStream]*rdd.KeyedRDD
+}
+
+// runBatch takes the records of every input and runs the output operations on them
+func (c *Context) runBatch(ctx context.Context) error {
+	c.mu.Lock()
+	t := c.next
+	c.next = t.Add(c.interval)
+	c.seq++
+	seq := c.seq
+	inputs := c.inputs
+	stateful := c.stateful
+	outputs := c.outputs
+	c.mu.Unlock()
+
+	begin := time.Now()
+	info := BatchInfo{Time: t, SchedulingDelay: begin.Sub(t)}
+	if info.SchedulingDelay < 0 {
+		info.SchedulingDelay = 0
+	}
+	b := &batch{ctx: ctx, time: t, seq: seq, inputs: make(map[Input][]interface{}, len(inputs)), sources: make(map[Input]types.Source), rdds: make(map[*DStream]*rdd.KeyedRDD)}
+	for _, in := range inputs {
+		if si, ok := in.(sourceInput); ok {
+			src, n, err := si.batchSource(seq, t)
+			if err != nil && info.Err == nil {
+				info.Err = err
+			}
+			if src != nil {
+				b.sources[in] = src
+			}
+			info.NumRecords += n
+			continue
+		}
+		records, err := in.Batch(seq, t)
+		if err != nil && info.Err == nil {
+			info.Err = err
+		}
+		b.inputs[in] = records
+		info.NumRecords += len(records)
+	}
+	if info.Err == nil {
+		// an immediate stop while the inputs were read drops the batch
+		info.Err = ctx.Err()
+	}
+	for _, d := range stateful {
+		if info.Err != nil {
+			break
+		}
+		_, info.Err = d.rdd(b)
+	}
+	for _, out := range outputs {
+		if info.Err != nil {
+			break
+		}
+		info.Err = out(ctx, b)
+	}
+	if info.Err == nil {
+		info.Err = c.checkpoint(seq)
+	}
+	for _, in := range inputs {
+		if cm, ok := in.(committer); ok && info.Err == nil {
+			info.Err = cm.commit(seq)
+		}
+	}
+	info.ProcessingTime = time.Since(begin)
+	if info.Err != nil {
+		info.Err = fmt.Errorf("batch %s: %w", t.Format(time.RFC3339Nano), info.Err)
+	}
+
+	c.mu.Lock()
+	c.batches = append(c.batches, info)
+	// a batch cancelled by an immediate stop did not fail
+	if info.Err != nil && c.err == nil && ctx.Err() == nil {
+		c.err = info.Err
+	}
+	c.mu.Unlock()
+	return info.Err
+}
+
+// Batches returns the batches run so far
+func (c *Context) Batches() []BatchInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]BatchInfo(nil), c.batches...)
+}
+
+// Stop stops the inputs and the batch loop. A graceful stop lets the running batch
+// finish and then processes everything the inputs received in one last batch; an
+// immediate stop cancels the running batch and drops unprocessed records. The spark
+// context is left running. Stop returns the error that failed a batch, if any; the
+// batch cancelled by an immediate stop is not a failure.
+func (c *Context) Stop(graceful bool) error {
+	c.stopOnce.Do(func() {
+		c.mu.Lock()
+		wasRunning := c.state == running
+		c.state = stopped
+		c.mu.Unlock()
+
+		if wasRunning {
+			for _, in := range c.inputs {
+				in.Stop()
+			}
+			if !graceful {
+				c.cancel()
+			}
+			close(c.quit)
+			<-c.loopDone
+			if graceful && c.Err() == nil {
+				c.runBatch(context.Background())
+			}
+			c.cancel()
+		}
+		close(c.stopped)
+	})
+	<-c.stopped
+	return c.Err()
+}
+
+// AwaitTermination blocks until the context stops, by Stop or because a batch failed,
+// or until ctx is done
+func (c *Context) AwaitTermination(ctx context.Context) error {
+	select {
+	case <-c.stopped:
+		return c.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Err returns the error that failed a batch, if any
+func (c *Context) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
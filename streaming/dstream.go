@@ -0,0 +1,182 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bajor/spark-go-core/expr"
+	"github.com/bajor/spark-go-core/rdd"
+	"github.com/bajor/spark-go-core/sink"
+)
+
+// DStream is a stream of KeyedRDDs, one per batch. Transformations build a new
+// DStream applying the same operation to every batch's RDD, and output operations
+// register work to run on it when the context is started. A windowed stream has no
+// RDD in the batches between two slides, and output operations skip those batches.
+type DStream struct {
+	ctx     *Context
+	compute func(b *batch) (*rdd.KeyedRDD, error)
+	// state is set for streams that carry data across batches
+	state stateful
+}
+
+// rdd returns the stream's RDD for a batch, computing it once per batch. It is nil
+// when the stream produces nothing in the batch.
+func (d *DStream) rdd(b *batch) (*rdd.KeyedRDD, error) {
+	if r, ok := b.rdds[d]; ok {
+		return r, nil
+	}
+	r, err := d.compute(b)
+	if err != nil {
+		return nil, err
+	}
+	b.rdds[d] = r
+	return r, nil
+}
+
+// Context returns the streaming context the stream belongs to
+func (d *DStream) Context() *Context {
+	return d.ctx
+}
+
+// Transform applies f to the RDD of every batch
+func (d *DStream) Transform(f func(r *rdd.KeyedRDD) *rdd.KeyedRDD) *DStream {
+	return &DStream{ctx: d.ctx, compute: func(b *batch) (*rdd.KeyedRDD, error) {
+		r, err := d.rdd(b)
+		if err != nil || r == nil {
+			return nil, err
+		}
+		return f(r), nil
+	}}
+}
+
+// Map applies a transformation function to each element
+func (d *DStream) Map(f func(i interface{}) (interface{}, error)) *DStream {
+	return d.Transform(func(r *rdd.KeyedRDD) *rdd.KeyedRDD { return r.Map(f) })
+}
+
+// Filter keeps only elements that match the predicate
+func (d *DStream) Filter(f func(i interface{}) bool) *DStream {
+	return d.Transform(func(r *rdd.KeyedRDD) *rdd.KeyedRDD { return r.Filter(f) })
+}
+
+// MapPartitions applies f to every partition of every batch
+func (d *DStream) MapPartitions(f func(ctx context.Context, part []interface{}) ([]interface{}, error)) *DStream {
+	return d.Transform(func(r *rdd.KeyedRDD) *rdd.KeyedRDD { return r.MapPartitions(f) })
+}
+
+// WithKey sets the key function used by the ReduceByKey calls that follow
+func (d *DStream) WithKey(key func(i interface{}) (interface{}, error)) *DStream {
+	return d.Transform(func(r *rdd.KeyedRDD) *rdd.KeyedRDD { return r.WithKey(key) })
+}
+
+// ReduceByKey groups the elements of every batch by key and reduces each group
+func (d *DStream) ReduceByKey(f func(a []interface{}) ([]interface{}, error)) *DStream {
+	return d.Transform(func(r *rdd.KeyedRDD) *rdd.KeyedRDD { return r.ReduceByKey(f) })
+}
+
+// Reduce combines all elements of every batch
+func (d *DStream) Reduce(f func(a []interface{}) ([]interface{}, error)) *DStream {
+	return d.Transform(func(r *rdd.KeyedRDD) *rdd.KeyedRDD { return r.Reduce(f) })
+}
+
+// MapExpr replaces every element with the value of an expression
+func (d *DStream) MapExpr(e expr.Expr) *DStream {
+	return d.Transform(func(r *rdd.KeyedRDD) *rdd.KeyedRDD { return r.MapExpr(e) })
+}
+
+// FilterExpr keeps elements for which the predicate expression is true
+func (d *DStream) FilterExpr(predicate expr.Expr) *DStream {
+	return d.Transform(func(r *rdd.KeyedRDD) *rdd.KeyedRDD { return r.FilterExpr(predicate) })
+}
+
+// Select projects every element into a map holding one entry per expression
+func (d *DStream) Select(exprs ...expr.Expr) *DStream {
+	return d.Transform(func(r *rdd.KeyedRDD) *rdd.KeyedRDD { return r.Select(exprs...) })
+}
+
+// Repartition sets the number of partitions of every batch's RDD
+func (d *DStream) Repartition(n int) *DStream {
+	return d.Transform(func(r *rdd.KeyedRDD) *rdd.KeyedRDD { return r.Repartition(n) })
+}
+
+// Union merges the elements of two streams of the same context batch by batch
+func (d *DStream) Union(other *DStream) *DStream {
+	return &DStream{ctx: d.ctx, compute: func(b *batch) (*rdd.KeyedRDD, error) {
+		left, err := d.collect(b)
+		if err != nil {
+			return nil, err
+		}
+		right, err := other.collect(b)
+		if err != nil {
+			return nil, err
+		}
+		return d.ctx.sc.Parallelize(append(left, right...), 0), nil
+	}}
+}
+
+// collect evaluates the stream's RDD for a batch; a batch without an RDD is empty
+func (d *DStream) collect(b *batch) ([]interface{}, error) {
+	r, err := d.rdd(b)
+	if err != nil || r == nil {
+		return nil, err
+	}
+	return d.ctx.sc.Collect(b.ctx, r)
+}
+
+// ForeachRDD registers f to run on the RDD of every batch, together with the batch time
+func (d *DStream) ForeachRDD(f func(ctx context.Context, r *rdd.KeyedRDD, time time.Time) error) {
+	d.ctx.addOutput(func(ctx context.Context, b *batch) error {
+		r, err := d.rdd(b)
+		if err != nil || r == nil {
+			return err
+		}
+		return f(ctx, r, b.time)
+	})
+}
+
+// Foreach registers f to run on every element of every batch, see rdd.KeyedRDD.Foreach
+func (d *DStream) Foreach(f func(ctx context.Context, record interface{}) error) {
+	d.ForeachRDD(func(ctx context.Context, r *rdd.KeyedRDD, _ time.Time) error {
+		return d.ctx.sc.Foreach(ctx, r, f)
+	})
+}
+
+// Collect registers f to receive the collected elements of every batch
+func (d *DStream) Collect(f func(records []interface{}, time time.Time) error) {
+	d.ForeachRDD(func(ctx context.Context, r *rdd.KeyedRDD, t time.Time) error {
+		records, err := d.ctx.sc.Collect(ctx, r)
+		if err != nil {
+			return err
+		}
+		return f(records, t)
+	})
+}
+
+// BatchDir returns the directory below dir that SaveAsFiles writes batch seq to
+func BatchDir(dir string, seq int64) string {
+	return filepath.Join(dir, fmt.Sprintf("batch-%010d", seq))
+}
+
+// SaveAsFiles registers an output writing every batch into its own directory below
+// dir, see BatchDir, with the commit protocol of rdd.KeyedRDD.Save. A batch whose
+// directory already has a _SUCCESS marker is skipped and a partial one is replaced,
+// so a batch that runs again after a restart, reading the same records from a
+// FileStream, is written exactly once.
+func (d *DStream) SaveAsFiles(dir string, format sink.Format, options sink.Options) {
+	options.Overwrite = true
+	d.ctx.addOutput(func(ctx context.Context, b *batch) error {
+		r, err := d.rdd(b)
+		if err != nil || r == nil {
+			return err
+		}
+		out := BatchDir(dir, b.seq)
+		if _, err := os.Stat(filepath.Join(out, sink.SuccessMarker)); err == nil {
+			return nil
+		}
+		return r.Save(ctx, d.ctx.sc.Scheduler(), out, format, options)
+	})
+}
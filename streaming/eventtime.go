@@ -0,0 +1,355 @@
+package streaming
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bajor/spark-go-core/rdd"
+)
+
+// LatePolicy chooses what happens to records that arrive after the watermark passed
+// the end of their window
+type LatePolicy int
+
+const (
+	// DropLate drops late records
+	DropLate LatePolicy = iota
+	// SideOutputLate sends records late for all their windows to the LateRecords
+	// stream; a record some sliding window still accepts is only added there
+	SideOutputLate
+	// UpdateLate adds late records to their window while the watermark is less than
+	// AllowedLateness past its end and emits the window's result again; later records
+	// are dropped
+	UpdateLate
+)
+
+// EventTimeSpec describes event-time windows over a stream of Pairs. A window holds
+// the values of one key whose event times fall in it, and is emitted once the
+// watermark passes its end.
+type EventTimeSpec struct {
+	// Timestamp returns the event time of a Pair's value, like the Key function of an RDD
+	Timestamp func(value interface{}) (time.Time, error)
+	// Length and Slide define fixed windows starting at multiples of Slide since the
+	// Unix epoch, as in Spark; a zero Slide makes tumbling windows of Length
+	Length, Slide time.Duration
+	// Gap defines session windows instead, which end once a key has no event for Gap
+	Gap time.Duration
+	// Map turns a value into the value reduced; nil reduces the values themselves
+	Map func(value interface{}) (interface{}, error)
+	// Reduce combines two values of a window
+	Reduce func(a, b interface{}) (interface{}, error)
+	// Delay is how far the watermark trails the latest event time seen, the amount
+	// of disorder tolerated before records count as late
+	Delay time.Duration
+	// Late is the policy for late records
+	Late LatePolicy
+	// AllowedLateness is how long past the watermark windows accept late records
+	// under UpdateLate
+	AllowedLateness time.Duration
+}
+
+func (s EventTimeSpec) validate() error {
+	switch {
+	case s.Timestamp == nil || s.Reduce == nil:
+		return errors.New("event-time windows need a Timestamp and a Reduce function")
+	case (s.Length > 0) == (s.Gap > 0):
+		return errors.New("event-time windows need either a Length or a Gap")
+	case s.Slide < 0 || s.Slide > s.Length:
+		return fmt.Errorf("window slide %v must be between zero and the length %v", s.Slide, s.Length)
+	case s.Delay < 0 || s.AllowedLateness < 0:
+		return errors.New("event-time delay and allowed lateness must not be negative")
+	case s.Late < DropLate || s.Late > UpdateLate:
+		return fmt.Errorf("unknown late policy %d", s.Late)
+	}
+	return nil
+}
+
+// WindowResult is the value of one key in one event-time window
+type WindowResult struct {
+	Start, End time.Time
+	Key, Value interface{}
+	// Update is set when late records changed a window that was already emitted
+	Update bool
+}
+
+// eventWindow is a window of one key. Emitted windows are kept while they can still
+// receive late records.
+type eventWindow struct {
+	start, end time.Time
+	value      interface{}
+	emitted    bool
+	changed    bool
+}
+
+// eventTimeState holds the open windows of every key and the latest event time
+type eventTimeState struct {
+	spec EventTimeSpec
+
+	mu        sync.Mutex
+	windows   map[interface{}][]*eventWindow
+	latest    time.Time
+	hasLatest bool
+	late      []interface{}
+}
+
+// watermark returns the event time before which records are late
+func (s *eventTimeState) watermark() time.Time {
+	if !s.hasLatest {
+		return time.Time{}
+	}
+	return s.latest.Add(-s.spec.Delay)
+}
+
+// assign returns the windows an event time belongs to, before session merging
+func (s *eventTimeState) assign(t time.Time) []*eventWindow {
+	if s.spec.Gap > 0 {
+		return []*eventWindow{{start: t, end: t.Add(s.spec.Gap)}}
+	}
+	slide := s.spec.Slide
+	if slide == 0 {
+		slide = s.spec.Length
+	}
+	var out []*eventWindow
+	for start := windowStart(t, slide); start.Add(s.spec.Length).After(t); start = start.Add(-slide) {
+		out = append(out, &eventWindow{start: start, end: start.Add(s.spec.Length)})
+	}
+	return out
+}
+
+// windowStart returns the latest multiple of slide since the Unix epoch at or before
+// t. Unlike t.Truncate, which counts from year 1, it puts windows of slides such as
+// 7m on the same wall-clock times as Spark and other epoch-aligned consumers.
+func windowStart(t time.Time, slide time.Duration) time.Time {
+	n := t.UnixNano()
+	mod := n % int64(slide)
+	if mod < 0 {
+		mod += int64(slide)
+	}
+	return time.Unix(0, n-mod).In(t.Location())
+}
+
+// accepts reports whether a window ending at end still takes records
+func (s *eventTimeState) accepts(end, watermark time.Time) bool {
+	if end.After(watermark) {
+		return true
+	}
+	return s.spec.Late == UpdateLate && end.Add(s.spec.AllowedLateness).After(watermark)
+}
+
+// add puts a value into a window of key, merging overlapping sessions
+func (s *eventTimeState) add(key interface{}, w *eventWindow) error {
+	windows := s.windows[key]
+	if s.spec.Gap == 0 {
+		for _, existing := range windows {
+			if existing.start.Equal(w.start) {
+				v, err := s.spec.Reduce(existing.value, w.value)
+				if err != nil {
+					return err
+				}
+				existing.value = v
+				existing.changed = true
+				return nil
+			}
+		}
+		w.changed = true
+		s.windows[key] = append(windows, w)
+		return nil
+	}
+
+	kept := windows[:0]
+	for _, existing := range windows {
+		if !existing.start.Before(w.end) || !w.start.Before(existing.end) {
+			kept = append(kept, existing)
+			continue
+		}
+		v, err := s.spec.Reduce(existing.value, w.value)
+		if err != nil {
+			return err
+		}
+		if existing.start.Before(w.start) {
+			w.start = existing.start
+		}
+		if existing.end.After(w.end) {
+			w.end = existing.end
+		}
+		w.value = v
+		w.emitted = w.emitted || existing.emitted
+	}
+	w.changed = true
+	s.windows[key] = append(kept, w)
+	return nil
+}
+
+// process adds the records of a batch, advances the watermark and returns the results
+// of the windows it closed and of emitted windows that late records changed
+func (s *eventTimeState) process(records []interface{}) ([]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	watermark := s.watermark()
+	s.late = nil
+	for _, record := range records {
+		p, err := pairOf(record)
+		if err != nil {
+			return nil, err
+		}
+		t, err := s.spec.Timestamp(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		v := p.Value
+		if s.spec.Map != nil {
+			if v, err = s.spec.Map(v); err != nil {
+				return nil, err
+			}
+		}
+		// a record of sliding windows may be late for some and added to others; only
+		// one no window accepted is side-output, so it is never counted twice
+		accepted := false
+		for _, w := range s.assign(t) {
+			if !s.accepts(w.end, watermark) {
+				continue
+			}
+			accepted = true
+			w.value = v
+			if err := s.add(p.Key, w); err != nil {
+				return nil, err
+			}
+		}
+		if !accepted && s.spec.Late == SideOutputLate {
+			s.late = append(s.late, record)
+		}
+		if !s.hasLatest || t.After(s.latest) {
+			s.latest, s.hasLatest = t, true
+		}
+	}
+
+	watermark = s.watermark()
+	var results []WindowResult
+	for key, windows := range s.windows {
+		kept := windows[:0]
+		for _, w := range windows {
+			if !w.end.After(watermark) && (w.changed || !w.emitted) {
+				results = append(results, WindowResult{Start: w.start, End: w.end, Key: key, Value: w.value, Update: w.emitted})
+				w.emitted = true
+			}
+			w.changed = false
+			if !w.emitted || s.accepts(w.end, watermark) {
+				kept = append(kept, w)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.windows, key)
+		} else {
+			s.windows[key] = kept
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if !results[i].End.Equal(results[j].End) {
+			return results[i].End.Before(results[j].End)
+		}
+		return results[i].Start.Before(results[j].Start)
+	})
+	out := make([]interface{}, len(results))
+	for i, r := range results {
+		out[i] = r
+	}
+	return out, nil
+}
+
+// checkpoint stores the latest event time followed by a key, start, end, value,
+// emitted quintuple per window, with times in Unix nanoseconds
+func (s *eventTimeState) checkpoint() ([]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []interface{}{s.hasLatest, s.latest.UnixNano()}
+	for key, windows := range s.windows {
+		for _, w := range windows {
+			out = append(out, key, w.start.UnixNano(), w.end.UnixNano(), w.value, w.emitted)
+		}
+	}
+	return out, nil
+}
+
+func (s *eventTimeState) restore(records []interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(records) < 2 || (len(records)-2)%5 != 0 {
+		return fmt.Errorf("malformed event-time state of %d records", len(records))
+	}
+	hasLatest, ok1 := records[0].(bool)
+	latest, ok2 := records[1].(int64)
+	if !ok1 || !ok2 {
+		return fmt.Errorf("malformed latest event time %v", records[1])
+	}
+	s.hasLatest, s.latest = hasLatest, time.Unix(0, latest)
+	s.windows = make(map[interface{}][]*eventWindow)
+	for i := 2; i < len(records); i += 5 {
+		start, ok1 := records[i+1].(int64)
+		end, ok2 := records[i+2].(int64)
+		emitted, ok3 := records[i+4].(bool)
+		if !ok1 || !ok2 || !ok3 {
+			return fmt.Errorf("malformed event-time window %v", records[i:i+5])
+		}
+		w := &eventWindow{start: time.Unix(0, start), end: time.Unix(0, end), value: records[i+3], emitted: emitted}
+		s.windows[records[i]] = append(s.windows[records[i]], w)
+	}
+	return nil
+}
+
+// EventTimeDStream is the stream of WindowResults returned by EventTimeWindow
+type EventTimeDStream struct {
+	*DStream
+	state *eventTimeState
+}
+
+// EventTimeWindow groups a stream of Pairs into windows by the event time of their
+// values rather than by the batch they arrive in, see EventTimeSpec. The watermark
+// is the latest event time seen minus Delay; it only moves forward. A window's
+// WindowResult is emitted in the batch in which the watermark passes its end, and
+// records that arrive once it has are late: they are handled by the Late policy,
+// judged against the watermark reached by the previous batch. Windows live on the
+// driver and are checkpointed, see Context.Checkpoint.
+func (d *DStream) EventTimeWindow(spec EventTimeSpec) *EventTimeDStream {
+	c := d.ctx
+	state := &eventTimeState{spec: spec, windows: make(map[interface{}][]*eventWindow)}
+	if err := spec.validate(); err != nil {
+		c.fail(err)
+		return &EventTimeDStream{DStream: &DStream{ctx: c, compute: func(*batch) (*rdd.KeyedRDD, error) { return nil, err }}, state: state}
+	}
+	stream := c.statefulStream(state, func(b *batch) (*rdd.KeyedRDD, error) {
+		records, err := d.collect(b)
+		if err != nil {
+			return nil, err
+		}
+		results, err := state.process(records)
+		if err != nil {
+			return nil, err
+		}
+		return c.sc.Parallelize(results, 0), nil
+	})
+	return &EventTimeDStream{DStream: stream, state: state}
+}
+
+// Watermark returns the current watermark, the zero time before the first event
+func (e *EventTimeDStream) Watermark() time.Time {
+	e.state.mu.Lock()
+	defer e.state.mu.Unlock()
+	return e.state.watermark()
+}
+
+// LateRecords returns a stream of the late records of every batch under
+// SideOutputLate; it is empty under the other policies
+func (e *EventTimeDStream) LateRecords() *DStream {
+	return &DStream{ctx: e.ctx, compute: func(b *batch) (*rdd.KeyedRDD, error) {
+		if _, err := e.rdd(b); err != nil {
+			return nil, err
+		}
+		e.state.mu.Lock()
+		late := e.state.late
+		e.state.mu.Unlock()
+		return e.ctx.sc.Parallelize(late, 0), nil
+	}}
+}
@@ -0,0 +1,205 @@
+package streaming
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// t0 is the origin of the event times in these tests
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// at returns the event time m minutes and s seconds after t0
+func at(m, s int) time.Time {
+	return t0.Add(time.Duration(m)*time.Minute + time.Duration(s)*time.Second)
+}
+
+func offset(t time.Time) string {
+	d := t.Sub(t0)
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// formatWindow prints a WindowResult as key[start-end)=value, with a + for updates
+func formatWindow(r WindowResult) string {
+	s := fmt.Sprintf("%v[%s-%s)=%v", r.Key, offset(r.Start), offset(r.End), r.Value)
+	if r.Update {
+		s += "+"
+	}
+	return s
+}
+
+// events builds Pairs of a key and an event time from key, minute, second triples
+func events(kms ...interface{}) []interface{} {
+	out := make([]interface{}, 0, len(kms)/3)
+	for i := 0; i < len(kms); i += 3 {
+		out = append(out, Pair{Key: kms[i], Value: at(kms[i+1].(int), kms[i+2].(int))})
+	}
+	return out
+}
+
+func countSpec(spec EventTimeSpec) EventTimeSpec {
+	spec.Timestamp = func(v interface{}) (time.Time, error) { return v.(time.Time), nil }
+	spec.Map = func(interface{}) (interface{}, error) { return 1, nil }
+	spec.Reduce = sum
+	return spec
+}
+
+// lateBatches has a record in the third batch that is late for its window
+var lateBatches = [][]interface{}{
+	events("a", 0, 10, "a", 0, 50, "b", 1, 10),
+	events("a", 0, 20, "a", 2, 0),
+	events("a", 0, 30, "b", 1, 40, "c", 3, 0),
+	events("a", 0, 5),
+}
+
+func TestDStream_EventTimeWindowLatePolicies(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		spec EventTimeSpec
+		want map[int][]string
+		late map[int][]string
+	}{
+		{
+			name: "drop",
+			spec: EventTimeSpec{Length: time.Minute, Delay: 30 * time.Second},
+			want: map[int][]string{1: {}, 2: {"a[0:00-1:00)=3"}, 3: {"b[1:00-2:00)=2"}, 4: {}},
+		},
+		{
+			name: "side output",
+			spec: EventTimeSpec{Length: time.Minute, Delay: 30 * time.Second, Late: SideOutputLate},
+			want: map[int][]string{1: {}, 2: {"a[0:00-1:00)=3"}, 3: {"b[1:00-2:00)=2"}, 4: {}},
+			late: map[int][]string{1: {}, 2: {}, 3: {"a=" + at(0, 30).String()}, 4: {"a=" + at(0, 5).String()}},
+		},
+		{
+			name: "update",
+			spec: EventTimeSpec{Length: time.Minute, Delay: 30 * time.Second, Late: UpdateLate, AllowedLateness: time.Minute},
+			want: map[int][]string{1: {}, 2: {"a[0:00-1:00)=3"}, 3: {"a[0:00-1:00)=4+", "b[1:00-2:00)=2"}, 4: {}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestContext(t)
+			q := newQueueInput()
+			windows := c.InputStream(q).EventTimeWindow(countSpec(tc.spec))
+			results := record(c, windows.DStream)
+			late := record(c, windows.LateRecords())
+			runQueued(t, c, q, lateBatches...)
+
+			if got := results.get(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Window results: got %v, want %v", got, tc.want)
+			}
+			if tc.late != nil {
+				if got := late.get(); !reflect.DeepEqual(got, tc.late) {
+					t.Errorf("Late records: got %v, want %v", got, tc.late)
+				}
+			}
+			if got, want := windows.Watermark(), at(2, 30); !got.Equal(want) {
+				t.Errorf("Watermark: got %v, want %v", offset(got), offset(want))
+			}
+		})
+	}
+}
+
+func TestDStream_EventTimeSlidingWindows(t *testing.T) {
+	c := newTestContext(t)
+	q := newQueueInput()
+	windows := c.InputStream(q).EventTimeWindow(countSpec(EventTimeSpec{Length: 2 * time.Minute, Slide: time.Minute}))
+	results := record(c, windows.DStream)
+	runQueued(t, c, q, events("a", 1, 30, "a", 2, 10), events("x", 5, 0))
+
+	want := map[int][]string{1: {"a[0:00-2:00)=1"}, 2: {"a[1:00-3:00)=2", "a[2:00-4:00)=1"}}
+	if got := results.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Sliding windows: got %v, want %v", got, want)
+	}
+}
+
+func TestDStream_EventTimeWindowsAlignToEpoch(t *testing.T) {
+	c := newTestContext(t)
+	q := newQueueInput()
+	windows := c.InputStream(q).EventTimeWindow(countSpec(EventTimeSpec{Length: 7 * time.Minute}))
+	results := record(c, windows.DStream)
+	runQueued(t, c, q, events("a", 0, 0), events("x", 20, 0))
+
+	// t0 is 2024-01-01, 28401120 minutes after the epoch, 6 past a multiple
+	// of 7; Truncate would count from year 1 and start the window elsewhere
+	start := time.Unix(0, 0).UTC().Add(28401114 * time.Minute)
+	want := map[int][]string{1: {}, 2: {"a[-6:00-1:00)=1"}}
+	if got := results.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Window results: got %v, want %v", got, want)
+	}
+	if got := t0.Truncate(7 * time.Minute); got.Equal(start) {
+		t.Fatalf("Test needs a time where epoch and Truncate alignment differ, both give %v", got)
+	}
+	if got := windowStart(t0, 7*time.Minute); !got.Equal(start) || got.Unix()%(7*60) != 0 {
+		t.Errorf("Window start: got %v, want %v", got, start)
+	}
+}
+
+func TestDStream_EventTimeSlidingWindowsPartlyLate(t *testing.T) {
+	c := newTestContext(t)
+	q := newQueueInput()
+	windows := c.InputStream(q).EventTimeWindow(countSpec(EventTimeSpec{Length: 2 * time.Minute, Slide: time.Minute, Late: SideOutputLate}))
+	results := record(c, windows.DStream)
+	late := record(c, windows.LateRecords())
+	// 2:30 is late for [1:00-3:00) but still counts in [2:00-4:00); 1:30 is late for
+	// both its windows
+	runQueued(t, c, q, events("a", 3, 10), events("a", 2, 30), events("a", 1, 30), events("x", 10, 0))
+
+	want := map[int][]string{1: {}, 2: {}, 3: {}, 4: {"a[2:00-4:00)=2", "a[3:00-5:00)=1"}}
+	if got := results.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Window results: got %v, want %v", got, want)
+	}
+	wantLate := map[int][]string{1: {}, 2: {}, 3: {"a=" + at(1, 30).String()}, 4: {}}
+	if got := late.get(); !reflect.DeepEqual(got, wantLate) {
+		t.Errorf("Late records: got %v, want %v", got, wantLate)
+	}
+}
+
+func TestDStream_EventTimeSessionsResumeFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	start := func() (*Context, *queueInput, *EventTimeDStream, *batchRecorder) {
+		c := newTestContext(t)
+		if err := c.Checkpoint(dir); err != nil {
+			t.Fatalf("Checkpoint failed with error: %v", err)
+		}
+		q := newQueueInput()
+		windows := c.InputStream(q).EventTimeWindow(countSpec(EventTimeSpec{Gap: time.Minute}))
+		return c, q, windows, record(c, windows.DStream)
+	}
+
+	c, q, _, results := start()
+	runQueued(t, c, q, events("a", 0, 0, "a", 0, 40, "b", 0, 10))
+	if got, want := results.get(), map[int][]string{1: {}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sessions before the restart: got %v, want %v", got, want)
+	}
+
+	c, q, windows, results := start()
+	runQueued(t, c, q, events("a", 1, 20, "z", 2, 0), events("z", 3, 0))
+	want := map[int][]string{
+		1: {"b[0:10-1:10)=1"},
+		2: {"a[0:00-2:20)=3", "z[2:00-3:00)=1"},
+	}
+	if got := results.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Sessions after the restart: got %v, want %v", got, want)
+	}
+	if got := windows.Watermark(); !got.Equal(at(3, 0)) {
+		t.Errorf("Watermark after the restart: got %v", offset(got))
+	}
+}
+
+func TestDStream_EventTimeWindowValidation(t *testing.T) {
+	for _, spec := range []EventTimeSpec{
+		{},
+		countSpec(EventTimeSpec{}),
+		countSpec(EventTimeSpec{Length: time.Minute, Gap: time.Minute}),
+		countSpec(EventTimeSpec{Length: time.Minute, Slide: 2 * time.Minute}),
+		countSpec(EventTimeSpec{Length: time.Minute, Delay: -time.Second}),
+		countSpec(EventTimeSpec{Gap: time.Minute, Late: LatePolicy(7)}),
+	} {
+		c := newTestContext(t)
+		c.InputStream(newQueueInput()).EventTimeWindow(spec).Collect(func([]interface{}, time.Time) error { return nil })
+		if err := c.Start(); err == nil {
+			t.Errorf("Expected %+v to be rejected", spec)
+		}
+	}
+}
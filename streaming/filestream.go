@@ -0,0 +1,288 @@
+package streaming
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bajor/spark-go-core/internal/atomicfile"
+	"github.com/bajor/spark-go-core/source"
+)
+
+// FileStreamOptions configures FileStream
+type FileStreamOptions struct {
+	// NewDecoder decodes the records of a file, source.Lines when nil. Records must be
+	// newline-delimited, as with source.Lines and source.JSON, so that files can be
+	// read in pieces.
+	NewDecoder func(r io.Reader) source.Decoder
+	// MaxBytesPerBatch limits how many bytes of new files a batch takes; larger files
+	// are read over several batches, each piece ending at a line end. Zero takes every
+	// new file whole.
+	MaxBytesPerBatch int64
+}
+
+// fileRange is a piece of a file taken by a batch. Size and ModTime are those of the
+// file when it was first seen, to detect files that change after being picked up.
+type fileRange struct {
+	File    string `json:"file"`
+	Start   int64  `json:"start"`
+	End     int64  `json:"end"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+}
+
+// seenFile is the size and modification time of a file when it was first seen and
+// the offset up to which batches have taken it
+type seenFile struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"modTime"`
+	Offset  int64 `json:"offset"`
+}
+
+// batchLog is the log entry of one batch: the ranges it takes and every file seen
+// once they are taken
+type batchLog struct {
+	Ranges []fileRange          `json:"ranges"`
+	Files  map[string]*seenFile `json:"files"`
+}
+
+// fileStream reads the files of a directory exactly once. Before a batch reads
+// anything, the byte ranges it takes are written to a log in the checkpoint
+// directory; a batch run again after a restart reads the ranges logged for it, and
+// later batches start after the offsets in the latest log.
+type fileStream struct {
+	ctx     *Context
+	index   int
+	dir     string
+	options FileStreamOptions
+
+	logDir  string
+	lastSeq int64
+	last    []fileRange
+	files   map[string]*seenFile
+}
+
+// FileStream creates a DStream of the records of the files in dir, including those
+// already there when the stream first starts. Writers must create files elsewhere,
+// or under a name starting with "." or "_", and rename them into dir once complete;
+// a file that changes after being picked up fails the stream. The context must have
+// a checkpoint directory, where the stream logs the files and byte offsets every
+// batch takes before reading them. After a crash the batch that did not complete
+// reads the same records again, so together with SaveAsFiles, which skips batches
+// already committed, every record is written exactly once.
+func (c *Context) FileStream(dir string, options FileStreamOptions) *DStream {
+	if options.NewDecoder == nil {
+		options.NewDecoder = source.Lines
+	}
+	c.mu.Lock()
+	index := len(c.inputs)
+	c.mu.Unlock()
+	return c.InputStream(&fileStream{ctx: c, index: index, dir: dir, options: options})
+}
+
+// Start reads the log; the context calls it with its checkpoint directory set
+func (f *fileStream) Start() error {
+	if f.ctx.checkpointDir == "" {
+		return fmt.Errorf("file stream of %s needs a checkpoint directory", f.dir)
+	}
+	f.logDir = filepath.Join(f.ctx.checkpointDir, "sources", strconv.Itoa(f.index))
+	if err := os.MkdirAll(f.logDir, 0o755); err != nil {
+		return err
+	}
+	f.files = make(map[string]*seenFile)
+	entries, err := os.ReadDir(f.logDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		// other names are temporary files of an interrupted write
+		if seq, err := strconv.ParseInt(e.Name(), 10, 64); err == nil && seq > f.lastSeq {
+			f.lastSeq = seq
+		}
+	}
+	if f.lastSeq == 0 {
+		return nil
+	}
+	name := strconv.FormatInt(f.lastSeq, 10)
+	data, err := os.ReadFile(filepath.Join(f.logDir, name))
+	if err != nil {
+		return err
+	}
+	var entry batchLog
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return fmt.Errorf("file stream log %s: %w", name, err)
+	}
+	f.last = entry.Ranges
+	if entry.Files != nil {
+		f.files = entry.Files
+	}
+	return nil
+}
+
+// Batch reads the ranges logged for seq, or logs and reads the next ranges
+func (f *fileStream) Batch(seq int64, _ time.Time) ([]interface{}, error) {
+	ranges := f.last
+	switch {
+	case seq == f.lastSeq:
+		// the batch did not complete before a restart
+	case seq == f.lastSeq+1:
+		var files map[string]*seenFile
+		var err error
+		if ranges, files, err = f.plan(); err != nil {
+			return nil, err
+		}
+		// the offsets only advance once logged, so a batch retried after a failed
+		// write plans the same ranges again
+		if err := f.log(seq, ranges, files); err != nil {
+			return nil, err
+		}
+		f.last, f.lastSeq, f.files = ranges, seq, files
+	default:
+		return nil, fmt.Errorf("file stream log of %s is at batch %d, not at batch %d or the one before", f.dir, f.lastSeq, seq)
+	}
+
+	var records []interface{}
+	for _, r := range ranges {
+		part, err := f.read(r)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, part...)
+	}
+	return records, nil
+}
+
+// plan picks the ranges of the next batch and returns them with the files seen once
+// they are taken, leaving the current offsets as they are
+func (f *fileStream) plan() ([]fileRange, map[string]*seenFile, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make(map[string]*seenFile, len(f.files))
+	for name, seen := range f.files {
+		copied := *seen
+		files[name] = &copied
+	}
+	budget := f.options.MaxBytesPerBatch
+	var ranges []fileRange
+	present := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, nil, err
+		}
+		present[name] = true
+		seen, ok := files[name]
+		if !ok {
+			seen = &seenFile{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+			files[name] = seen
+		} else if info.Size() != seen.Size || info.ModTime().UnixNano() != seen.ModTime {
+			return nil, nil, fmt.Errorf("%s changed after the file stream picked it up; write files elsewhere and rename them into %s", filepath.Join(f.dir, name), f.dir)
+		}
+		if seen.Offset >= seen.Size || (f.options.MaxBytesPerBatch > 0 && budget <= 0) {
+			continue
+		}
+		end := seen.Size
+		if f.options.MaxBytesPerBatch > 0 && seen.Offset+budget < seen.Size {
+			if end, err = lineEnd(filepath.Join(f.dir, name), seen.Offset+budget, seen.Size); err != nil {
+				return nil, nil, err
+			}
+		}
+		ranges = append(ranges, fileRange{File: name, Start: seen.Offset, End: end, Size: seen.Size, ModTime: seen.ModTime})
+		budget -= end - seen.Offset
+		seen.Offset = end
+	}
+	// forget files that were removed, a new file of the same name is read again
+	for name := range files {
+		if !present[name] {
+			delete(files, name)
+		}
+	}
+	return ranges, files, nil
+}
+
+// lineEnd returns the offset just past the first line end at or after from-1, or
+// size if the file has none
+func lineEnd(path string, from, size int64) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	buf := make([]byte, 64<<10)
+	for pos := from - 1; pos < size; {
+		n, err := file.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		pos += int64(n)
+	}
+	return size, nil
+}
+
+// log atomically writes the ranges of batch seq and the offsets of every file
+func (f *fileStream) log(seq int64, ranges []fileRange, files map[string]*seenFile) error {
+	data, err := json.Marshal(batchLog{Ranges: ranges, Files: files})
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.Write(f.logDir, strconv.FormatInt(seq, 10), data); err != nil {
+		return fmt.Errorf("file stream log: %w", err)
+	}
+	// only the latest log is read again
+	if err := os.Remove(filepath.Join(f.logDir, strconv.FormatInt(seq-1, 10))); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// read decodes the records of a range
+func (f *fileStream) read(r fileRange) ([]interface{}, error) {
+	path := filepath.Join(f.dir, r.File)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != r.Size || info.ModTime().UnixNano() != r.ModTime {
+		return nil, fmt.Errorf("%s changed after the file stream picked it up; write files elsewhere and rename them into %s", path, f.dir)
+	}
+	dec := f.options.NewDecoder(io.NewSectionReader(file, r.Start, r.End-r.Start))
+	var records []interface{}
+	for {
+		record, err := dec.Decode()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s at bytes %d-%d: %w", path, r.Start, r.End, err)
+		}
+		records = append(records, record)
+	}
+}
+
+func (f *fileStream) Stop() error {
+	return nil
+}
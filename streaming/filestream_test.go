@@ -0,0 +1,236 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bajor/spark-go-core/sink"
+	"github.com/bajor/spark-go-core/source"
+)
+
+// publish writes a file elsewhere and renames it into dir
+func publish(t *testing.T, dir, name string, lines ...string) {
+	t.Helper()
+	tmp := filepath.Join(dir, "_"+name)
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// committedLines returns the sorted lines of every committed batch below dir
+func committedLines(t *testing.T, dir string) []string {
+	t.Helper()
+	batches, err := filepath.Glob(filepath.Join(dir, "batch-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, b := range batches {
+		if _, err := os.Stat(filepath.Join(b, sink.SuccessMarker)); err != nil {
+			continue
+		}
+		parts, _ := filepath.Glob(filepath.Join(b, "part-*"))
+		for _, part := range parts {
+			data, err := os.ReadFile(part)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, strings.Fields(string(data))...)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func newFileStreamContext(t *testing.T, checkpoint string) *Context {
+	t.Helper()
+	c := newTestContext(t)
+	if err := c.Checkpoint(checkpoint); err != nil {
+		t.Fatalf("Checkpoint failed with error: %v", err)
+	}
+	return c
+}
+
+func TestFileStream_SplitsFilesAtLineEnds(t *testing.T) {
+	in := t.TempDir()
+	var lines []string
+	for i := 0; i < 10; i++ {
+		lines = append(lines, fmt.Sprintf("line-%02d", i))
+	}
+	publish(t, in, "a.txt", lines...)
+	if err := os.WriteFile(filepath.Join(in, ".hidden"), []byte("hidden\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newFileStreamContext(t, t.TempDir())
+	var got collector
+	var sizes []int
+	c.FileStream(in, FileStreamOptions{MaxBytesPerBatch: 20}).Collect(func(records []interface{}, tm time.Time) error {
+		if len(records) > 0 {
+			sizes = append(sizes, len(records))
+		}
+		return got.add(records, tm)
+	})
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+	waitFor(t, "every line", func() bool { return got.len() >= len(lines) })
+	if err := c.Stop(true); err != nil {
+		t.Fatalf("Stop failed with error: %v", err)
+	}
+	if !reflect.DeepEqual(got.strings(), lines) {
+		t.Errorf("File stream lines: got %v, want %v", got.strings(), lines)
+	}
+	// 20 bytes end in the third 8-byte line
+	if want := []int{3, 3, 3, 1}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Lines per batch: got %v, want %v", sizes, want)
+	}
+}
+
+func TestFileStream_RetriesFailedLogWrite(t *testing.T) {
+	in, checkpoint := t.TempDir(), t.TempDir()
+	var lines []string
+	for i := 0; i < 10; i++ {
+		lines = append(lines, fmt.Sprintf("line-%02d", i))
+	}
+	publish(t, in, "a.txt", lines...)
+
+	f := &fileStream{ctx: &Context{checkpointDir: checkpoint}, dir: in, options: FileStreamOptions{NewDecoder: source.Lines, MaxBytesPerBatch: 20}}
+	if err := f.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+	// a directory in place of the log of batch 1 makes its write fail once
+	blocker := filepath.Join(f.logDir, "1")
+	if err := os.Mkdir(blocker, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Batch(1, time.Time{}); err == nil {
+		t.Fatal("Expected the log write of batch 1 to fail")
+	}
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for seq := int64(1); len(got) < len(lines) && seq < 10; seq++ {
+		records, err := f.Batch(seq, time.Time{})
+		if err != nil {
+			t.Fatalf("Batch %d failed with error: %v", seq, err)
+		}
+		for _, r := range records {
+			got = append(got, r.(string))
+		}
+	}
+	if !reflect.DeepEqual(got, lines) {
+		t.Errorf("File stream lines after a failed log write: got %v, want %v", got, lines)
+	}
+}
+
+func TestFileStream_NeedsCheckpoint(t *testing.T) {
+	c := newTestContext(t)
+	c.FileStream(t.TempDir(), FileStreamOptions{}).Collect(func([]interface{}, time.Time) error { return nil })
+	if err := c.Start(); err == nil || !strings.Contains(err.Error(), "needs a checkpoint directory") {
+		t.Errorf("Expected a missing checkpoint error, got %v", err)
+	}
+}
+
+func TestFileStream_RejectsFilesChangedInPlace(t *testing.T) {
+	in := t.TempDir()
+	c := newFileStreamContext(t, t.TempDir())
+	var got collector
+	c.FileStream(in, FileStreamOptions{}).Collect(got.add)
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+	publish(t, in, "a.txt", "one")
+	waitFor(t, "the first line", func() bool { return got.len() == 1 })
+
+	f, err := os.OpenFile(filepath.Join(in, "a.txt"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("two\n")
+	f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.AwaitTermination(ctx); err == nil || !strings.Contains(err.Error(), "changed after the file stream picked it up") {
+		t.Errorf("Expected a changed file error, got %v", err)
+	}
+}
+
+func TestFileStream_ExactlyOnceAcrossRestarts(t *testing.T) {
+	in, out, checkpoint := t.TempDir(), t.TempDir(), t.TempDir()
+	crash := errors.New("crash")
+	// run processes the files published by publishAll; when failBefore or failAfter
+	// is set, the batch holding that line fails before or after its output commits
+	run := func(failBefore, failAfter string, publishAll func()) error {
+		c := newFileStreamContext(t, checkpoint)
+		lines := c.FileStream(in, FileStreamOptions{})
+		failOn := func(line string) func(context.Context, interface{}) error {
+			return func(_ context.Context, record interface{}) error {
+				if record == line {
+					return crash
+				}
+				return nil
+			}
+		}
+		if failBefore != "" {
+			lines.Foreach(failOn(failBefore))
+		}
+		lines.Map(func(i interface{}) (interface{}, error) { return strings.ToUpper(i.(string)), nil }).SaveAsFiles(out, sink.Text, sink.Options{})
+		if failAfter != "" {
+			lines.Foreach(failOn(failAfter))
+		}
+		if err := c.Start(); err != nil {
+			t.Fatalf("Start failed with error: %v", err)
+		}
+		publishAll()
+		if failBefore == "" && failAfter == "" {
+			waitFor(t, "the restarted batches", func() bool { return len(committedLines(t, out)) == 6 })
+			return c.Stop(true)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return c.AwaitTermination(ctx)
+	}
+
+	err := run("", "boom1", func() {
+		publish(t, in, "1.txt", "a", "b")
+		waitFor(t, "the first file", func() bool { return len(committedLines(t, out)) == 2 })
+		publish(t, in, "2.txt", "boom1", "c")
+	})
+	if !errors.Is(err, crash) {
+		t.Fatalf("The first run ended with %v, want the crash after the commit", err)
+	}
+	// the crashed batch was committed but not checkpointed
+	if got, want := committedLines(t, out), []string{"A", "B", "BOOM1", "C"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Output after the first run: got %v, want %v", got, want)
+	}
+
+	err = run("boom2", "", func() { publish(t, in, "3.txt", "boom2", "d") })
+	if !errors.Is(err, crash) {
+		t.Fatalf("The second run ended with %v, want the crash before the commit", err)
+	}
+	if got, want := committedLines(t, out), []string{"A", "B", "BOOM1", "C"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Output after the second run: got %v, want %v", got, want)
+	}
+
+	if err := run("", "", func() {}); err != nil {
+		t.Fatalf("The last run failed with error: %v", err)
+	}
+	if got, want := committedLines(t, out), []string{"A", "B", "BOOM1", "BOOM2", "C", "D"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Every line should be written exactly once: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,226 @@
+package streaming
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bajor/spark-go-core/source"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// Input delivers the records of an input stream batch by batch
+type Input interface {
+	// Start begins receiving; it is called once, before the first batch
+	Start() error
+	// Batch returns the records that arrived since the previous batch. seq numbers
+	// batches from 1 and carries on from the checkpoint after a restart, so an input
+	// that logs what each batch took can return the same records when a batch that
+	// did not complete runs again. An error fails the batch and stops the context.
+	Batch(seq int64, time time.Time) ([]interface{}, error)
+	// Stop stops receiving and waits until no more records arrive, so that a graceful
+	// stop can process everything received
+	Stop() error
+}
+
+// sourceInput is an Input whose batches are read by the tasks of the batch's jobs,
+// one RDD partition per source partition, instead of being held on the driver
+type sourceInput interface {
+	Input
+	// batchSource is called instead of Batch and returns the source of batch seq and
+	// the number of records it holds
+	batchSource(seq int64, time time.Time) (types.Source, int, error)
+}
+
+// committer is an Input told when batch seq has completed and been checkpointed
+type committer interface {
+	commit(seq int64) error
+}
+
+// receiver buffers the records a background goroutine receives until a batch takes them
+type receiver struct {
+	receive func(ctx context.Context, store func(record interface{})) error
+
+	mu     sync.Mutex
+	buf    []interface{}
+	err    error
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newReceiver(receive func(ctx context.Context, store func(record interface{})) error) *receiver {
+	return &receiver{receive: receive, done: make(chan struct{})}
+}
+
+func (r *receiver) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go func() {
+		defer close(r.done)
+		err := r.receive(ctx, r.store)
+		if err != nil && ctx.Err() == nil {
+			r.mu.Lock()
+			r.err = err
+			r.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (r *receiver) store(record interface{}) {
+	r.mu.Lock()
+	r.buf = append(r.buf, record)
+	r.mu.Unlock()
+}
+
+func (r *receiver) Batch(int64, time.Time) ([]interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	records, err := r.buf, r.err
+	r.buf, r.err = nil, nil
+	return records, err
+}
+
+func (r *receiver) Stop() error {
+	if r.cancel == nil {
+		return nil
+	}
+	r.cancel()
+	<-r.done
+	return nil
+}
+
+// channelInput receives from a channel until it is closed
+func channelInput[T any](ch <-chan T) Input {
+	return newReceiver(func(ctx context.Context, store func(interface{})) error {
+		for {
+			select {
+			case v, ok := <-ch:
+				if !ok {
+					return nil
+				}
+				store(v)
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+}
+
+// socketInput receives the lines sent over a TCP connection until the peer closes it
+func socketInput(addr string) Input {
+	return newReceiver(func(ctx context.Context, store func(interface{})) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" && (err == nil || err == io.EOF) {
+				store(strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"))
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+					return nil
+				}
+				return err
+			}
+		}
+	})
+}
+
+// fileInput reads the lines of files appearing in a directory. Files present when the
+// stream starts and hidden files are ignored; writers should create files elsewhere
+// and rename them into the directory, so that a batch never sees a partial file.
+type fileInput struct {
+	dir  string
+	seen map[string]bool
+}
+
+func (f *fileInput) Start() error {
+	names, err := f.list()
+	if err != nil {
+		return err
+	}
+	f.seen = make(map[string]bool, len(names))
+	for _, name := range names {
+		f.seen[name] = true
+	}
+	return nil
+}
+
+func (f *fileInput) list() ([]string, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") && !strings.HasPrefix(e.Name(), "_") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (f *fileInput) Batch(int64, time.Time) ([]interface{}, error) {
+	names, err := f.list()
+	if err != nil {
+		return nil, err
+	}
+	var records []interface{}
+	for _, name := range names {
+		if f.seen[name] {
+			continue
+		}
+		lines, err := readLines(filepath.Join(f.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		f.seen[name] = true
+		records = append(records, lines...)
+	}
+	return records, nil
+}
+
+func (f *fileInput) Stop() error {
+	return nil
+}
+
+// readLines reads a whole, possibly gzipped, text file
+func readLines(path string) ([]interface{}, error) {
+	r, err := source.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var lines []interface{}
+	dec := source.Lines(r)
+	for {
+		line, err := dec.Decode()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+}
@@ -0,0 +1,260 @@
+package streaming
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bajor/spark-go-core/internal/atomicfile"
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+	"github.com/bajor/spark-go-core/msglog"
+	"github.com/bajor/spark-go-core/types"
+)
+
+// LogStreamOptions configures LogStream
+type LogStreamOptions struct {
+	// Group is the consumer group whose committed offsets the stream starts from and
+	// commits to once each batch completes. One stream at a time should consume a
+	// topic for a group.
+	Group string
+	// MaxPerPartition limits how many messages a batch takes from each partition; zero
+	// takes every message appended since the previous batch
+	MaxPerPartition int64
+}
+
+// logBatchFile is the name of the log of the latest batch in a log stream's directory
+const logBatchFile = "batch"
+
+// logBatch is the log entry of the latest batch that took messages
+type logBatch struct {
+	Seq    int64          `json:"seq"`
+	Ranges []msglog.Range `json:"ranges"`
+}
+
+// logStream reads the partitions of a topic from the offsets committed by a consumer
+// group. With a checkpoint directory, the ranges of every batch are logged before
+// they are read, so the batch that did not complete before a restart reads the same
+// messages again.
+type logStream struct {
+	ctx     *Context
+	index   int
+	log     *msglog.Log
+	topic   string
+	options LogStreamOptions
+
+	logDir string
+	// next is the offset each partition's next batch starts at
+	next map[int]int64
+	// replay is the logged batch to run again after a restart
+	replay *logBatch
+	// running are the ranges of the running batch, committed once it completes
+	running []msglog.Range
+}
+
+// LogStream creates a DStream of the msglog.Messages appended to a topic of l, one
+// RDD partition per topic partition a batch reads from. Every batch takes the
+// messages between the offsets where the previous batch ended, starting from those
+// committed by options.Group, and the end of each partition; once the batch has
+// completed, and been checkpointed, the offsets past it are committed to the group.
+// Without a checkpoint directory a batch interrupted by a crash is read again from
+// the committed offsets, possibly with more messages, so every message is processed
+// at least once. With one, the ranges of each batch are logged before they are read
+// and the batch runs again after a restart with the same messages, so that together
+// with SaveAsFiles every message is written exactly once.
+func (c *Context) LogStream(l *msglog.Log, topic string, options LogStreamOptions) *DStream {
+	c.mu.Lock()
+	index := len(c.inputs)
+	c.mu.Unlock()
+	return c.InputStream(&logStream{ctx: c, index: index, log: l, topic: topic, options: options})
+}
+
+// Start reads the committed offsets and the batch log; the context calls it after
+// restoring its checkpoint, with the number of the latest completed batch in c.seq
+func (s *logStream) Start() error {
+	next, err := s.log.Committed(s.options.Group, s.topic)
+	if err != nil {
+		return fmt.Errorf("log stream of %s: %w", s.topic, err)
+	}
+	s.next = next
+	if s.ctx.checkpointDir == "" {
+		return nil
+	}
+	s.logDir = filepath.Join(s.ctx.checkpointDir, "sources", strconv.Itoa(s.index))
+	if err := os.MkdirAll(s.logDir, 0o755); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(s.logDir, logBatchFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entry logBatch
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return fmt.Errorf("log stream log of %s: %w", s.topic, err)
+	}
+	completed := s.ctx.seq
+	switch {
+	case entry.Seq == completed+1:
+		// the batch did not complete before a restart
+		s.replay = &entry
+	case entry.Seq <= completed:
+		// the batch completed, but its offsets may not have been committed
+		for _, r := range entry.Ranges {
+			if r.To > s.next[r.Partition] {
+				s.next[r.Partition] = r.To
+			}
+		}
+		s.running = entry.Ranges
+		return s.commit(entry.Seq)
+	default:
+		return fmt.Errorf("log stream log of %s is at batch %d but the checkpoint at batch %d", s.topic, entry.Seq, completed)
+	}
+	return nil
+}
+
+// batchSource returns the ranges logged for seq, or plans, logs and returns the next
+func (s *logStream) batchSource(seq int64, _ time.Time) (types.Source, int, error) {
+	var ranges []msglog.Range
+	if s.replay != nil {
+		if s.replay.Seq != seq {
+			return nil, 0, fmt.Errorf("log stream log of %s is at batch %d, not at batch %d", s.topic, s.replay.Seq, seq)
+		}
+		ranges, s.replay = s.replay.Ranges, nil
+	} else {
+		var err error
+		if ranges, err = s.plan(); err != nil {
+			return nil, 0, err
+		}
+		if s.logDir != "" && len(ranges) > 0 {
+			data, err := json.Marshal(logBatch{Seq: seq, Ranges: ranges})
+			if err != nil {
+				return nil, 0, err
+			}
+			if err := atomicfile.Write(s.logDir, logBatchFile, data); err != nil {
+				return nil, 0, fmt.Errorf("log stream log: %w", err)
+			}
+		}
+	}
+	n := 0
+	for _, r := range ranges {
+		s.next[r.Partition] = r.To
+		n += int(r.Len())
+	}
+	s.running = ranges
+	if len(ranges) == 0 {
+		return nil, 0, nil
+	}
+	return s.log.Source(s.topic, ranges), n, nil
+}
+
+// plan picks the ranges of the next batch
+func (s *logStream) plan() ([]msglog.Range, error) {
+	partitions, err := s.log.Partitions(s.topic)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []msglog.Range
+	for p := 0; p < partitions; p++ {
+		end, err := s.log.EndOffset(s.topic, p)
+		if err != nil {
+			return nil, err
+		}
+		from := s.next[p]
+		if from > end {
+			return nil, fmt.Errorf("topic %s partition %d: group %s is at offset %d past the end %d: %w", s.topic, p, s.options.Group, from, end, msglog.ErrOffsetOutOfRange)
+		}
+		if s.options.MaxPerPartition > 0 && end-from > s.options.MaxPerPartition {
+			end = from + s.options.MaxPerPartition
+		}
+		if end > from {
+			ranges = append(ranges, msglog.Range{Partition: p, From: from, To: end})
+		}
+	}
+	return ranges, nil
+}
+
+// Batch reads the messages of the next batch on the driver; the context reads them
+// in the batch's tasks instead
+func (s *logStream) Batch(seq int64, t time.Time) ([]interface{}, error) {
+	src, _, err := s.batchSource(seq, t)
+	if err != nil || src == nil {
+		return nil, err
+	}
+	var records []interface{}
+	for p := 0; p < src.NumPartitions(); p++ {
+		it, err := src.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		part, err := lazy.Drain(it)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, part...)
+	}
+	return records, nil
+}
+
+// commit commits the offsets past the ranges of the completed batch
+func (s *logStream) commit(int64) error {
+	if len(s.running) == 0 {
+		return nil
+	}
+	offsets := make(map[int]int64, len(s.running))
+	for _, r := range s.running {
+		offsets[r.Partition] = r.To
+	}
+	if err := s.log.Commit(s.options.Group, s.topic, offsets); err != nil {
+		return fmt.Errorf("log stream of %s: %w", s.topic, err)
+	}
+	s.running = nil
+	return nil
+}
+
+func (s *logStream) Stop() error {
+	return nil
+}
+
+// SaveToLog registers an output appending every element of every batch to a topic of
+// l, see msglog.Log.Append. encode returns the key and value of an element; when it
+// is nil, msglog.Messages keep their key and value, strings and byte slices become
+// values without a key and other elements are encoded as JSON values. A batch that
+// runs again after a restart appends its elements again, so every element is written
+// at least once.
+func (d *DStream) SaveToLog(l *msglog.Log, topic string, encode func(record interface{}) (key, value []byte, err error)) {
+	if _, err := l.Partitions(topic); err != nil {
+		d.ctx.fail(fmt.Errorf("SaveToLog: %w", err))
+		return
+	}
+	if encode == nil {
+		encode = encodeMessage
+	}
+	d.Foreach(func(_ context.Context, record interface{}) error {
+		key, value, err := encode(record)
+		if err != nil {
+			return err
+		}
+		_, _, err = l.Append(topic, key, value)
+		return err
+	})
+}
+
+func encodeMessage(record interface{}) ([]byte, []byte, error) {
+	switch v := record.(type) {
+	case msglog.Message:
+		return v.Key, v.Value, nil
+	case []byte:
+		return nil, v, nil
+	case string:
+		return nil, []byte(v), nil
+	}
+	value, err := json.Marshal(record)
+	return nil, value, err
+}
@@ -0,0 +1,211 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bajor/spark-go-core/msglog"
+	"github.com/bajor/spark-go-core/sink"
+)
+
+func openTopic(t *testing.T, name string, partitions int) *msglog.Log {
+	t.Helper()
+	l, err := msglog.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("msglog.Open failed with error: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if err := l.CreateTopic(name, partitions); err != nil {
+		t.Fatalf("CreateTopic failed with error: %v", err)
+	}
+	return l
+}
+
+// appendTo appends values to a partition without keys
+func appendTo(t *testing.T, l *msglog.Log, topic string, p int, values ...string) {
+	t.Helper()
+	for _, v := range values {
+		if _, err := l.AppendTo(topic, p, nil, []byte(v)); err != nil {
+			t.Fatalf("AppendTo failed with error: %v", err)
+		}
+	}
+}
+
+func messageValue(i interface{}) (interface{}, error) {
+	return string(i.(msglog.Message).Value), nil
+}
+
+func TestLogStream_ReadsFromCommittedOffsets(t *testing.T) {
+	l := openTopic(t, "in", 3)
+	for p, values := range [][]string{{"a0", "a1", "a2"}, {"b0", "b1", "b2"}, {"c0", "c1", "c2"}} {
+		appendTo(t, l, "in", p, values...)
+	}
+
+	run := func(want int) ([]int, []msglog.Message) {
+		c := newTestContext(t)
+		var mu sync.Mutex
+		var sizes []int
+		var messages []msglog.Message
+		c.LogStream(l, "in", LogStreamOptions{Group: "g", MaxPerPartition: 2}).Collect(func(records []interface{}, _ time.Time) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(records) > 0 {
+				sizes = append(sizes, len(records))
+			}
+			for _, r := range records {
+				messages = append(messages, r.(msglog.Message))
+			}
+			return nil
+		})
+		if err := c.Start(); err != nil {
+			t.Fatalf("Start failed with error: %v", err)
+		}
+		waitFor(t, "the messages", func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(messages) >= want
+		})
+		if err := c.Stop(true); err != nil {
+			t.Fatalf("Stop failed with error: %v", err)
+		}
+		return sizes, messages
+	}
+
+	sizes, messages := run(9)
+	if want := []int{6, 3}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Messages per batch: got %v, want %v", sizes, want)
+	}
+	seen := make(map[int][]int64)
+	for _, m := range messages {
+		seen[m.Partition] = append(seen[m.Partition], m.Offset)
+	}
+	for p := 0; p < 3; p++ {
+		sort.Slice(seen[p], func(i, j int) bool { return seen[p][i] < seen[p][j] })
+		if want := []int64{0, 1, 2}; !reflect.DeepEqual(seen[p], want) {
+			t.Errorf("Offsets of partition %d: got %v, want %v", p, seen[p], want)
+		}
+	}
+	committed, err := l.Committed("g", "in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]int64{0: 3, 1: 3, 2: 3}; !reflect.DeepEqual(committed, want) {
+		t.Errorf("Committed offsets: got %v, want %v", committed, want)
+	}
+
+	appendTo(t, l, "in", 1, "b3")
+	if _, messages = run(1); len(messages) != 1 || string(messages[0].Value) != "b3" {
+		t.Errorf("A restarted stream should read past the committed offsets, got %v", messages)
+	}
+}
+
+func TestLogStream_ExactlyOnceAcrossRestarts(t *testing.T) {
+	l := openTopic(t, "in", 2)
+	out, checkpoint := t.TempDir(), t.TempDir()
+	crash := errors.New("crash")
+	// run processes the messages appended by appendAll; when failBefore or failAfter
+	// is set, the batch holding that value fails before or after its output commits
+	run := func(failBefore, failAfter string, appendAll func()) error {
+		c := newFileStreamContext(t, checkpoint)
+		values := c.LogStream(l, "in", LogStreamOptions{Group: "g"}).Map(messageValue)
+		failOn := func(value string) func(context.Context, interface{}) error {
+			return func(_ context.Context, record interface{}) error {
+				if record == value {
+					return crash
+				}
+				return nil
+			}
+		}
+		if failBefore != "" {
+			values.Foreach(failOn(failBefore))
+		}
+		values.Map(func(i interface{}) (interface{}, error) { return strings.ToUpper(i.(string)), nil }).SaveAsFiles(out, sink.Text, sink.Options{})
+		if failAfter != "" {
+			values.Foreach(failOn(failAfter))
+		}
+		if err := c.Start(); err != nil {
+			t.Fatalf("Start failed with error: %v", err)
+		}
+		appendAll()
+		if failBefore == "" && failAfter == "" {
+			waitFor(t, "the restarted batches", func() bool { return len(committedLines(t, out)) == 6 })
+			return c.Stop(true)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return c.AwaitTermination(ctx)
+	}
+
+	err := run("", "boom1", func() {
+		appendTo(t, l, "in", 0, "a", "b")
+		waitFor(t, "the first messages", func() bool { return len(committedLines(t, out)) == 2 })
+		appendTo(t, l, "in", 1, "c", "boom1")
+	})
+	if !errors.Is(err, crash) {
+		t.Fatalf("The first run ended with %v, want the crash after the commit", err)
+	}
+	if got, want := committedLines(t, out), []string{"A", "B", "BOOM1", "C"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Output after the first run: got %v, want %v", got, want)
+	}
+	// the crashed batch was committed to the output but not to the group
+	if committed, _ := l.Committed("g", "in"); committed[0] != 2 || committed[1] > 1 {
+		t.Fatalf("Committed offsets after the first run: got %v", committed)
+	}
+
+	err = run("boom2", "", func() { appendTo(t, l, "in", 0, "boom2", "d") })
+	if !errors.Is(err, crash) {
+		t.Fatalf("The second run ended with %v, want the crash before the commit", err)
+	}
+	if got, want := committedLines(t, out), []string{"A", "B", "BOOM1", "C"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Output after the second run: got %v, want %v", got, want)
+	}
+
+	if err := run("", "", func() {}); err != nil {
+		t.Fatalf("The last run failed with error: %v", err)
+	}
+	if got, want := committedLines(t, out), []string{"A", "B", "BOOM1", "BOOM2", "C", "D"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Every message should be written exactly once: got %v, want %v", got, want)
+	}
+	if committed, _ := l.Committed("g", "in"); !reflect.DeepEqual(committed, map[int]int64{0: 4, 1: 2}) {
+		t.Errorf("Committed offsets after the last run: got %v", committed)
+	}
+}
+
+func TestDStream_SaveToLog(t *testing.T) {
+	l := openTopic(t, "out", 2)
+	c := newTestContext(t)
+	q := newQueueInput()
+	c.InputStream(q).SaveToLog(l, "out", nil)
+	runQueued(t, c, q, []interface{}{"a", []byte("b"), msglog.Message{Key: []byte("k"), Value: []byte("c")}, map[string]interface{}{"n": 1}})
+
+	var got []string
+	keys := 0
+	for p := 0; p < 2; p++ {
+		messages, err := l.Read("out", p, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range messages {
+			got = append(got, string(m.Value))
+			if m.Key != nil {
+				keys++
+			}
+		}
+	}
+	sort.Strings(got)
+	if want := []string{"a", "b", "c", `{"n":1}`}; !reflect.DeepEqual(got, want) || keys != 1 {
+		t.Errorf("Appended values: got %v with %d keys, want %v with 1", got, keys, want)
+	}
+
+	c = newTestContext(t)
+	c.InputStream(newQueueInput()).SaveToLog(l, "missing", nil)
+	if err := c.Start(); !errors.Is(err, msglog.ErrUnknownTopic) {
+		t.Errorf("Saving to a missing topic: got %v, want ErrUnknownTopic", err)
+	}
+}
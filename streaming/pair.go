@@ -0,0 +1,43 @@
+package streaming
+
+import (
+	"fmt"
+
+	"github.com/bajor/spark-go-core/codec"
+)
+
+// Pair is a key and a value. The keyed window and state operations take streams of
+// Pairs, and keys must be comparable.
+type Pair struct {
+	Key   interface{}
+	Value interface{}
+}
+
+func init() {
+	// states are checkpointed with the binary codec
+	codec.Register(Pair{})
+}
+
+// KeyBy turns every element into a Pair of key(element) and the element
+func (d *DStream) KeyBy(key func(i interface{}) (interface{}, error)) *DStream {
+	return d.Map(func(i interface{}) (interface{}, error) {
+		k, err := key(i)
+		if err != nil {
+			return nil, err
+		}
+		return Pair{Key: k, Value: i}, nil
+	})
+}
+
+func pairOf(record interface{}) (Pair, error) {
+	p, ok := record.(Pair)
+	if !ok {
+		return Pair{}, fmt.Errorf("element %v (%T) is not a streaming.Pair", record, record)
+	}
+	return p, nil
+}
+
+func pairKey(record interface{}) (interface{}, error) {
+	p, err := pairOf(record)
+	return p.Key, err
+}
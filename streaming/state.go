@@ -0,0 +1,274 @@
+package streaming
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bajor/spark-go-core/rdd"
+)
+
+// ErrTimingOut is returned when the state of a key that is timing out is updated
+var ErrTimingOut = errors.New("cannot update the state of a key that is timing out")
+
+// stateEntry is the state of one key and, for MapWithState, the batch time the key
+// last received a value
+type stateEntry struct {
+	value interface{}
+	seen  time.Time
+}
+
+// stateStore keeps the state of every key on the driver
+type stateStore struct {
+	entries map[interface{}]*stateEntry
+}
+
+func newStateStore() *stateStore {
+	return &stateStore{entries: make(map[interface{}]*stateEntry)}
+}
+
+func (s *stateStore) pairs() []interface{} {
+	out := make([]interface{}, 0, len(s.entries))
+	for k, e := range s.entries {
+		out = append(out, Pair{Key: k, Value: e.value})
+	}
+	return out
+}
+
+// checkpoint stores every key as a key, value, seen triple, with seen in Unix
+// nanoseconds or zero
+func (s *stateStore) checkpoint() ([]interface{}, error) {
+	out := make([]interface{}, 0, 3*len(s.entries))
+	for k, e := range s.entries {
+		var seen int64
+		if !e.seen.IsZero() {
+			seen = e.seen.UnixNano()
+		}
+		out = append(out, k, e.value, seen)
+	}
+	return out, nil
+}
+
+func (s *stateStore) restore(records []interface{}) error {
+	if len(records)%3 != 0 {
+		return fmt.Errorf("malformed state of %d records", len(records))
+	}
+	s.entries = make(map[interface{}]*stateEntry, len(records)/3)
+	for i := 0; i < len(records); i += 3 {
+		seen, ok := records[i+2].(int64)
+		if !ok {
+			return fmt.Errorf("malformed state time %v", records[i+2])
+		}
+		e := &stateEntry{value: records[i+1]}
+		if seen != 0 {
+			e.seen = time.Unix(0, seen)
+		}
+		s.entries[records[i]] = e
+	}
+	return nil
+}
+
+// groupValues groups the values of a batch of Pairs by key, keeping the order of the
+// keys' first values and of the values within each key
+func groupValues(records []interface{}) ([]interface{}, map[interface{}][]interface{}, error) {
+	var keys []interface{}
+	values := make(map[interface{}][]interface{})
+	for _, record := range records {
+		p, err := pairOf(record)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := values[p.Key]; !ok {
+			keys = append(keys, p.Key)
+		}
+		values[p.Key] = append(values[p.Key], p.Value)
+	}
+	return keys, values, nil
+}
+
+// UpdateStateByKey keeps a state for every key of a stream of Pairs. Every batch,
+// update is called for each key that has a state or new values, with the values in
+// the order they arrived, the current state and whether there is one. It returns the
+// new state, or false to remove the key. The stream holds a Pair of every key and its
+// state after each batch. State lives on the driver and is checkpointed, see
+// Context.Checkpoint.
+func (d *DStream) UpdateStateByKey(update func(key interface{}, values []interface{}, state interface{}, exists bool) (interface{}, bool, error)) *DStream {
+	c := d.ctx
+	store := newStateStore()
+	return c.statefulStream(store, func(b *batch) (*rdd.KeyedRDD, error) {
+		records, err := d.collect(b)
+		if err != nil {
+			return nil, err
+		}
+		keys, values, err := groupValues(records)
+		if err != nil {
+			return nil, err
+		}
+		for k := range store.entries {
+			if _, ok := values[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range keys {
+			e, exists := store.entries[k]
+			var state interface{}
+			if exists {
+				state = e.value
+			}
+			next, keep, err := update(k, values[k], state, exists)
+			if err != nil {
+				return nil, err
+			}
+			if !keep {
+				delete(store.entries, k)
+				continue
+			}
+			if !exists {
+				e = &stateEntry{}
+				store.entries[k] = e
+			}
+			e.value = next
+		}
+		return c.sc.Parallelize(store.pairs(), 0), nil
+	})
+}
+
+// State is the state of one key passed to a StateSpec function
+type State struct {
+	value     interface{}
+	exists    bool
+	timingOut bool
+}
+
+// Exists reports whether the key has a state
+func (s *State) Exists() bool {
+	return s.exists
+}
+
+// Get returns the state, nil if there is none
+func (s *State) Get() interface{} {
+	if !s.exists {
+		return nil
+	}
+	return s.value
+}
+
+// Update sets the state. It fails with ErrTimingOut when the key is timing out.
+func (s *State) Update(value interface{}) error {
+	if s.timingOut {
+		return ErrTimingOut
+	}
+	s.value, s.exists = value, true
+	return nil
+}
+
+// Remove removes the state
+func (s *State) Remove() {
+	s.value, s.exists = nil, false
+}
+
+// TimingOut reports whether the call is the last one for a key whose state timed out
+func (s *State) TimingOut() bool {
+	return s.timingOut
+}
+
+// StateSpec describes the per-key state kept by MapWithState
+type StateSpec struct {
+	// Func is called for every element of a stream of Pairs, in the order they arrive,
+	// with the key's state, and returns the element of the mapped stream; nil results
+	// are dropped
+	Func func(key, value interface{}, state *State) (interface{}, error)
+	// Timeout removes the state of keys that received no values for that long, measured
+	// in batch time. Before the state is removed Func is called once more with a nil
+	// value and a state that is TimingOut. Zero keeps state until Func removes it.
+	Timeout time.Duration
+	// Initial holds the Pairs of keys and states to start from when there is no
+	// checkpoint
+	Initial []Pair
+}
+
+// MapWithStateDStream is the mapped stream returned by MapWithState
+type MapWithStateDStream struct {
+	*DStream
+	store *stateStore
+}
+
+// MapWithState maps a stream of Pairs with a function that can read and change the
+// state of each element's key, see StateSpec. Unlike UpdateStateByKey only keys that
+// receive values or time out are visited. State lives on the driver and is
+// checkpointed, see Context.Checkpoint.
+func (d *DStream) MapWithState(spec StateSpec) *MapWithStateDStream {
+	c := d.ctx
+	store := newStateStore()
+	for _, p := range spec.Initial {
+		store.entries[p.Key] = &stateEntry{value: p.Value}
+	}
+	stream := c.statefulStream(store, func(b *batch) (*rdd.KeyedRDD, error) {
+		records, err := d.collect(b)
+		if err != nil {
+			return nil, err
+		}
+		var out []interface{}
+		call := func(k, v interface{}, state *State) error {
+			mapped, err := spec.Func(k, v, state)
+			if err != nil {
+				return err
+			}
+			if mapped != nil {
+				out = append(out, mapped)
+			}
+			return nil
+		}
+
+		seen := make(map[interface{}]bool)
+		for _, record := range records {
+			p, err := pairOf(record)
+			if err != nil {
+				return nil, err
+			}
+			e, exists := store.entries[p.Key]
+			state := &State{exists: exists}
+			if exists {
+				state.value = e.value
+			}
+			if err := call(p.Key, p.Value, state); err != nil {
+				return nil, err
+			}
+			seen[p.Key] = true
+			if !state.exists {
+				delete(store.entries, p.Key)
+				continue
+			}
+			store.entries[p.Key] = &stateEntry{value: state.value, seen: b.time}
+		}
+
+		if spec.Timeout > 0 {
+			for k, e := range store.entries {
+				if e.seen.IsZero() {
+					// the keys of Initial count as seen in the first batch
+					e.seen = b.time
+				}
+				if seen[k] || b.time.Sub(e.seen) < spec.Timeout {
+					continue
+				}
+				if err := call(k, nil, &State{value: e.value, exists: true, timingOut: true}); err != nil {
+					return nil, err
+				}
+				delete(store.entries, k)
+			}
+		}
+		return c.sc.Parallelize(out, 0), nil
+	})
+	return &MapWithStateDStream{DStream: stream, store: store}
+}
+
+// StateSnapshots returns a stream holding a Pair of every key and its state after
+// each batch
+func (m *MapWithStateDStream) StateSnapshots() *DStream {
+	return &DStream{ctx: m.ctx, compute: func(b *batch) (*rdd.KeyedRDD, error) {
+		if _, err := m.rdd(b); err != nil {
+			return nil, err
+		}
+		return m.ctx.sc.Parallelize(m.store.pairs(), 0), nil
+	}}
+}
@@ -0,0 +1,303 @@
+package streaming
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queueInput delivers one queued slice of records per batch. Batch blocks until the
+// test queues the next one, so batch contents do not depend on timing.
+type queueInput struct {
+	batches chan []interface{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newQueueInput() *queueInput {
+	return &queueInput{batches: make(chan []interface{}), done: make(chan struct{})}
+}
+
+func (q *queueInput) Start() error { return nil }
+
+func (q *queueInput) Batch(int64, time.Time) ([]interface{}, error) {
+	select {
+	case records := <-q.batches:
+		return records, nil
+	case <-q.done:
+		return nil, nil
+	}
+}
+
+func (q *queueInput) Stop() error {
+	q.once.Do(func() { close(q.done) })
+	return nil
+}
+
+// batchRecorder keeps the sorted output of every batch by batch number
+type batchRecorder struct {
+	c       *Context
+	mu      sync.Mutex
+	batches map[int][]string
+}
+
+func record(c *Context, d *DStream) *batchRecorder {
+	r := &batchRecorder{c: c, batches: make(map[int][]string)}
+	d.Collect(func(records []interface{}, _ time.Time) error {
+		out := make([]string, len(records))
+		for i, rec := range records {
+			switch rec := rec.(type) {
+			case Pair:
+				out[i] = fmt.Sprintf("%v=%v", rec.Key, rec.Value)
+			case WindowResult:
+				out[i] = formatWindow(rec)
+			default:
+				out[i] = fmt.Sprint(rec)
+			}
+		}
+		sort.Strings(out)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		// the batch being run is not in Batches yet
+		r.batches[len(c.Batches())+1] = out
+		return nil
+	})
+	return r
+}
+
+func (r *batchRecorder) get() map[int][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[int][]string, len(r.batches))
+	for k, v := range r.batches {
+		out[k] = v
+	}
+	return out
+}
+
+// runQueued starts c, runs one batch per slice of batches and stops c
+func runQueued(t *testing.T, c *Context, q *queueInput, batches ...[]interface{}) {
+	t.Helper()
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+	for _, records := range batches {
+		q.batches <- records
+	}
+	waitFor(t, "the queued batches", func() bool { return len(c.Batches()) == len(batches) })
+	if err := c.Stop(false); err != nil {
+		t.Fatalf("Stop failed with error: %v", err)
+	}
+	for i, b := range c.Batches()[:len(batches)] {
+		if b.Err != nil {
+			t.Errorf("Batch %d failed with error: %v", i+1, b.Err)
+		}
+	}
+}
+
+func pairs(kvs ...interface{}) []interface{} {
+	out := make([]interface{}, 0, len(kvs)/2)
+	for i := 0; i < len(kvs); i += 2 {
+		out = append(out, Pair{Key: kvs[i], Value: kvs[i+1]})
+	}
+	return out
+}
+
+func sum(a, b interface{}) (interface{}, error) { return a.(int) + b.(int), nil }
+
+func subtract(a, b interface{}) (interface{}, error) { return a.(int) - b.(int), nil }
+
+func TestDStream_Window(t *testing.T) {
+	c := newTestContext(t)
+	q := newQueueInput()
+	windows := record(c, c.InputStream(q).Window(3*testInterval, 2*testInterval))
+	runQueued(t, c, q, []interface{}{1}, []interface{}{2}, []interface{}{3, 4}, []interface{}{5}, []interface{}{6})
+
+	want := map[int][]string{2: {"1", "2"}, 4: {"2", "3", "4", "5"}}
+	if got := windows.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Window outputs: got %v, want %v", got, want)
+	}
+}
+
+func TestDStream_WindowRejectsDurations(t *testing.T) {
+	for _, tc := range []struct{ length, slide time.Duration }{
+		{15 * time.Millisecond, 0},
+		{2 * testInterval, 3 * time.Millisecond},
+		{0, testInterval},
+	} {
+		c := newTestContext(t)
+		stream := ChannelStream(c, make(chan int))
+		stream.Window(tc.length, tc.slide).Collect(func([]interface{}, time.Time) error { return nil })
+		if err := c.Start(); err == nil || !strings.Contains(err.Error(), "multiple of the batch interval") {
+			t.Errorf("Window(%v, %v): expected a duration error, got %v", tc.length, tc.slide, err)
+		}
+	}
+}
+
+func TestDStream_ReduceByKeyAndWindow(t *testing.T) {
+	batches := [][]interface{}{
+		pairs("a", 1, "b", 1, "a", 2),
+		pairs("a", 1),
+		pairs("c", 1),
+		pairs("a", 1),
+		{},
+		{},
+	}
+	want := map[int][]string{
+		1: {"a=3", "b=1"},
+		2: {"a=4", "b=1"},
+		3: {"a=1", "c=1"},
+		4: {"a=1", "c=1"},
+		5: {"a=1"},
+		6: {},
+	}
+	for _, inverse := range []func(a, b interface{}) (interface{}, error){nil, subtract} {
+		c := newTestContext(t)
+		q := newQueueInput()
+		counts := record(c, c.InputStream(q).ReduceByKeyAndWindow(sum, inverse, 2*testInterval, 0))
+		runQueued(t, c, q, batches...)
+		if got := counts.get(); !reflect.DeepEqual(got, want) {
+			t.Errorf("ReduceByKeyAndWindow with inverse %v: got %v, want %v", inverse != nil, got, want)
+		}
+	}
+}
+
+func TestDStream_ReduceByKeyAndWindowRejectsOtherElements(t *testing.T) {
+	c := newTestContext(t)
+	q := newQueueInput()
+	c.InputStream(q).ReduceByKeyAndWindow(sum, nil, testInterval, 0).Collect(func([]interface{}, time.Time) error { return nil })
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+	q.batches <- []interface{}{"not a pair"}
+	waitFor(t, "the failed batch", func() bool { return c.Err() != nil })
+	if err := c.Err(); !strings.Contains(err.Error(), "is not a streaming.Pair") {
+		t.Errorf("Expected a Pair error, got %v", err)
+	}
+}
+
+// runningSum keeps the sum of every key's values and drops keys whose sum is zero
+func runningSum(_ interface{}, values []interface{}, state interface{}, exists bool) (interface{}, bool, error) {
+	total := 0
+	if exists {
+		total = state.(int)
+	}
+	for _, v := range values {
+		total += v.(int)
+	}
+	return total, total != 0, nil
+}
+
+func TestDStream_UpdateStateByKeyResumesFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	start := func(extra bool) (*Context, *queueInput, *batchRecorder) {
+		c := newTestContext(t)
+		if err := c.Checkpoint(dir); err != nil {
+			t.Fatalf("Checkpoint failed with error: %v", err)
+		}
+		q := newQueueInput()
+		stream := c.InputStream(q)
+		totals := record(c, stream.UpdateStateByKey(runningSum))
+		if extra {
+			stream.Window(testInterval, 0).Collect(func([]interface{}, time.Time) error { return nil })
+		}
+		return c, q, totals
+	}
+
+	c, q, totals := start(false)
+	runQueued(t, c, q, pairs("a", 1, "b", 1), pairs("a", 1, "c", 5))
+	want := map[int][]string{1: {"a=1", "b=1"}, 2: {"a=2", "b=1", "c=5"}}
+	if got := totals.get(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("UpdateStateByKey before the restart: got %v, want %v", got, want)
+	}
+
+	c, q, totals = start(false)
+	runQueued(t, c, q, pairs("b", -1, "c", 1), []interface{}{})
+	want = map[int][]string{1: {"a=2", "c=6"}, 2: {"a=2", "c=6"}}
+	if got := totals.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("UpdateStateByKey after the restart: got %v, want %v", got, want)
+	}
+
+	c, _, _ = start(true)
+	if err := c.Start(); err == nil || !strings.Contains(err.Error(), "holds 1 stateful streams but the context defines 2") {
+		t.Errorf("Expected a checkpoint mismatch error, got %v", err)
+	}
+}
+
+func TestDStream_WindowResumesFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	start := func() (*Context, *queueInput, *batchRecorder) {
+		c := newTestContext(t)
+		if err := c.Checkpoint(dir); err != nil {
+			t.Fatalf("Checkpoint failed with error: %v", err)
+		}
+		q := newQueueInput()
+		return c, q, record(c, c.InputStream(q).ReduceByKeyAndWindow(sum, subtract, 3*testInterval, 0))
+	}
+
+	c, q, _ := start()
+	runQueued(t, c, q, pairs("a", 1), pairs("a", 2))
+	c, q, counts := start()
+	runQueued(t, c, q, pairs("a", 4), pairs("b", 1))
+	want := map[int][]string{1: {"a=7"}, 2: {"a=6", "b=1"}}
+	if got := counts.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Window after the restart: got %v, want %v", got, want)
+	}
+}
+
+func TestDStream_MapWithStateTimesOut(t *testing.T) {
+	c := newTestContext(t)
+	q := newQueueInput()
+	var updateErr error
+	mapped := c.InputStream(q).MapWithState(StateSpec{
+		Func: func(key, value interface{}, state *State) (interface{}, error) {
+			if state.TimingOut() {
+				updateErr = state.Update(0)
+				return fmt.Sprintf("%v timed out at %v", key, state.Get()), nil
+			}
+			total := value.(int)
+			if state.Exists() {
+				total += state.Get().(int)
+			}
+			if total < 0 {
+				state.Remove()
+				return nil, nil
+			}
+			if err := state.Update(total); err != nil {
+				return nil, err
+			}
+			return fmt.Sprintf("%v=%d", key, total), nil
+		},
+		Timeout: 2 * testInterval,
+		Initial: []Pair{{Key: "z", Value: 10}},
+	})
+	outputs := record(c, mapped.DStream)
+	snapshots := record(c, mapped.StateSnapshots())
+	runQueued(t, c, q, pairs("a", 1, "b", 1, "a", 1), pairs("a", 1, "c", 1), pairs("c", -5), []interface{}{})
+
+	wantOutputs := map[int][]string{
+		1: {"a=1", "a=2", "b=1"},
+		2: {"a=3", "c=1"},
+		3: {"b timed out at 1", "z timed out at 10"},
+		4: {"a timed out at 3"},
+	}
+	if got := outputs.get(); !reflect.DeepEqual(got, wantOutputs) {
+		t.Errorf("MapWithState outputs: got %v, want %v", got, wantOutputs)
+	}
+	wantSnapshots := map[int][]string{
+		1: {"a=2", "b=1", "z=10"},
+		2: {"a=3", "b=1", "c=1", "z=10"},
+		3: {"a=3"},
+		4: {},
+	}
+	if got := snapshots.get(); !reflect.DeepEqual(got, wantSnapshots) {
+		t.Errorf("MapWithState snapshots: got %v, want %v", got, wantSnapshots)
+	}
+	if updateErr != ErrTimingOut {
+		t.Errorf("Updating a timing out state returned %v, want ErrTimingOut", updateErr)
+	}
+}
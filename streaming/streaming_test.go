@@ -0,0 +1,341 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bajor/spark-go-core/spark"
+)
+
+const testInterval = 10 * time.Millisecond
+
+func newTestContext(t *testing.T) *Context {
+	t.Helper()
+	sc, err := spark.NewContext(spark.Config{Parallelism: 2, TempDirs: []string{t.TempDir()}})
+	if err != nil {
+		t.Fatalf("spark.NewContext failed with error: %v", err)
+	}
+	t.Cleanup(func() { sc.Stop() })
+	c, err := NewContext(sc, testInterval)
+	if err != nil {
+		t.Fatalf("NewContext failed with error: %v", err)
+	}
+	t.Cleanup(func() { c.Stop(false) })
+	return c
+}
+
+// collector gathers the records of every batch of a stream
+type collector struct {
+	mu      sync.Mutex
+	records []interface{}
+}
+
+func (c *collector) add(records []interface{}, _ time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.records = append(c.records, records...)
+	return nil
+}
+
+func (c *collector) strings() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]string, len(c.records))
+	for i, r := range c.records {
+		out[i] = fmt.Sprint(r)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (c *collector) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.records)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestContext_ChannelStreamGracefulStopProcessesEverything(t *testing.T) {
+	c := newTestContext(t)
+	ch := make(chan int)
+	var sum, count int
+	var mu sync.Mutex
+	evens := ChannelStream(c, ch).Filter(func(i interface{}) bool { return i.(int)%2 == 0 })
+	evens.Foreach(func(_ context.Context, record interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sum += record.(int)
+		count++
+		return nil
+	})
+	var squares collector
+	evens.Map(func(i interface{}) (interface{}, error) { return i.(int) * i.(int), nil }).Collect(squares.add)
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+	if err := c.Start(); err != ErrStarted {
+		t.Errorf("Second Start returned %v, want ErrStarted", err)
+	}
+
+	for i := 1; i <= 200; i++ {
+		ch <- i
+		if i%50 == 0 {
+			time.Sleep(2 * testInterval)
+		}
+	}
+	if err := c.Stop(true); err != nil {
+		t.Fatalf("Stop failed with error: %v", err)
+	}
+	if sum != 10100 || count != 100 || squares.len() != 100 {
+		t.Errorf("Graceful stop lost or repeated records: sum %d, count %d, squares %d", sum, count, squares.len())
+	}
+
+	batches := c.Batches()
+	if len(batches) < 2 {
+		t.Fatalf("Expected several batches, got %d", len(batches))
+	}
+	total := 0
+	for i, b := range batches {
+		total += b.NumRecords
+		if b.Err != nil {
+			t.Errorf("Batch %d failed with error: %v", i, b.Err)
+		}
+		if i > 0 && !b.Time.After(batches[i-1].Time) {
+			t.Errorf("Batch times are not increasing: %v then %v", batches[i-1].Time, b.Time)
+		}
+	}
+	if total != 200 {
+		t.Errorf("Batches received %d records, want 200", total)
+	}
+	if err := c.AwaitTermination(context.Background()); err != nil {
+		t.Errorf("AwaitTermination after Stop returned %v", err)
+	}
+}
+
+func TestContext_ReduceByKeyPerBatch(t *testing.T) {
+	c := newTestContext(t)
+	ch := make(chan string)
+	var counts collector
+	ChannelStream(c, ch).
+		Map(func(i interface{}) (interface{}, error) { return [2]interface{}{i, 1}, nil }).
+		WithKey(func(i interface{}) (interface{}, error) { return i.([2]interface{})[0], nil }).
+		ReduceByKey(func(group []interface{}) ([]interface{}, error) {
+			n := 0
+			for _, g := range group {
+				n += g.([2]interface{})[1].(int)
+			}
+			return []interface{}{fmt.Sprintf("%v=%d", group[0].([2]interface{})[0], n)}, nil
+		}).
+		Collect(counts.add)
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+	for _, w := range strings.Fields("a b a c a b") {
+		ch <- w
+	}
+	if err := c.Stop(true); err != nil {
+		t.Fatalf("Stop failed with error: %v", err)
+	}
+	// all words may land in one batch or be spread over several
+	totals := map[string]int{}
+	for _, s := range counts.strings() {
+		var n int
+		parts := strings.SplitN(s, "=", 2)
+		fmt.Sscan(parts[1], &n)
+		totals[parts[0]] += n
+	}
+	if want := map[string]int{"a": 3, "b": 2, "c": 1}; !reflect.DeepEqual(totals, want) {
+		t.Errorf("Word counts: got %v, want %v", totals, want)
+	}
+}
+
+func TestContext_TextFileStream(t *testing.T) {
+	c := newTestContext(t)
+	dir := t.TempDir()
+	staging := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "old.txt"), []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var lines collector
+	c.TextFileStream(dir).Collect(lines.add)
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+
+	publish := func(name, content string) {
+		tmp := filepath.Join(staging, name)
+		if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("a.txt", "one\ntwo\n")
+	if err := os.WriteFile(filepath.Join(dir, "_partial"), []byte("hidden\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the first file", func() bool { return lines.len() == 2 })
+	publish("b.txt", "three\n")
+	waitFor(t, "the second file", func() bool { return lines.len() == 3 })
+	if err := c.Stop(true); err != nil {
+		t.Fatalf("Stop failed with error: %v", err)
+	}
+	if got, want := lines.strings(), []string{"one", "three", "two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Text file stream: got %v, want %v", got, want)
+	}
+}
+
+func TestContext_SocketTextStream(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "hello\r\nsocket\nworld")
+	}()
+
+	c := newTestContext(t)
+	var lines collector
+	c.SocketTextStream(l.Addr().String()).
+		Map(func(i interface{}) (interface{}, error) { return strings.ToUpper(i.(string)), nil }).
+		Collect(lines.add)
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+	waitFor(t, "the socket lines", func() bool { return lines.len() == 3 })
+	if err := c.Stop(true); err != nil {
+		t.Fatalf("Stop failed with error: %v", err)
+	}
+	if got, want := lines.strings(), []string{"HELLO", "SOCKET", "WORLD"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Socket stream: got %v, want %v", got, want)
+	}
+}
+
+func TestContext_SocketConnectFailureStopsContext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	c := newTestContext(t)
+	c.SocketTextStream(addr).Collect(func([]interface{}, time.Time) error { return nil })
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.AwaitTermination(ctx); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the connection error, got %v", err)
+	}
+}
+
+func TestContext_BatchErrorStopsContext(t *testing.T) {
+	c := newTestContext(t)
+	ch := make(chan int, 10)
+	boom := errors.New("boom")
+	ChannelStream(c, ch).Foreach(func(_ context.Context, record interface{}) error {
+		if record.(int) == 3 {
+			return boom
+		}
+		return nil
+	})
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+	for i := 1; i <= 5; i++ {
+		ch <- i
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.AwaitTermination(ctx); !errors.Is(err, boom) {
+		t.Fatalf("AwaitTermination returned %v, want %v", err, boom)
+	}
+	if err := c.Stop(true); !errors.Is(err, boom) {
+		t.Errorf("Stop returned %v, want %v", err, boom)
+	}
+	batches := c.Batches()
+	if last := batches[len(batches)-1]; !errors.Is(last.Err, boom) {
+		t.Errorf("The failed batch was not recorded: %+v", last)
+	}
+}
+
+func TestContext_ImmediateStopCancelsRunningBatch(t *testing.T) {
+	c := newTestContext(t)
+	ch := make(chan int, 1)
+	started := make(chan struct{})
+	ChannelStream(c, ch).Foreach(func(ctx context.Context, _ interface{}) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+	ch <- 1
+	<-started
+	done := make(chan error, 1)
+	go func() { done <- c.Stop(false) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Immediate stop returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Immediate stop did not cancel the running batch")
+	}
+	batches := c.Batches()
+	if last := batches[len(batches)-1]; !errors.Is(last.Err, context.Canceled) {
+		t.Errorf("The cancelled batch was not recorded: %+v", last)
+	}
+}
+
+func TestContext_Validation(t *testing.T) {
+	c := newTestContext(t)
+	if _, err := NewContext(c.SparkContext(), 0); err == nil {
+		t.Error("Expected an error for a zero batch interval")
+	}
+	ch := make(chan int)
+	stream := ChannelStream(c, ch)
+	if err := c.Start(); err == nil {
+		t.Error("Expected an error starting without output operations")
+	}
+	stream.Collect(func([]interface{}, time.Time) error { return nil })
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+	defer func() {
+		if r := recover(); r != ErrStarted {
+			t.Errorf("Adding an output after Start panicked with %v, want ErrStarted", r)
+		}
+	}()
+	stream.Collect(func([]interface{}, time.Time) error { return nil })
+}
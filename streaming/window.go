@@ -0,0 +1,228 @@
+package streaming
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bajor/spark-go-core/rdd"
+)
+
+// window keeps the records of the last length batches, oldest first
+type window struct {
+	length  int
+	batches [][]interface{}
+}
+
+func (w *window) add(records []interface{}) (expired []interface{}) {
+	w.batches = append(w.batches, records)
+	if len(w.batches) > w.length {
+		expired = w.batches[0]
+		w.batches = w.batches[1:]
+	}
+	return expired
+}
+
+func (w *window) records() []interface{} {
+	var out []interface{}
+	for _, records := range w.batches {
+		out = append(out, records...)
+	}
+	return out
+}
+
+func (w *window) checkpoint() ([]interface{}, error) {
+	out := make([]interface{}, len(w.batches))
+	for i, records := range w.batches {
+		out[i] = records
+	}
+	return out, nil
+}
+
+func (w *window) restore(records []interface{}) error {
+	w.batches = nil
+	for _, r := range records {
+		batch, ok := r.([]interface{})
+		if !ok {
+			return fmt.Errorf("malformed window batch %v", r)
+		}
+		w.add(batch)
+	}
+	return nil
+}
+
+// windowed builds a stream over the last length of batches of d that produces an RDD
+// every slide; a zero slide slides every batch. onBatch sees the records of every new
+// batch and those of the batch that left the window, and result returns the RDD.
+func (d *DStream) windowed(length, slide time.Duration, state stateful, w *window, onBatch func(added, expired []interface{}) error, result func() ([]interface{}, error)) *DStream {
+	c := d.ctx
+	if slide == 0 {
+		slide = c.interval
+	}
+	n, err := c.batchesIn("window length", length)
+	var every int
+	if err == nil {
+		every, err = c.batchesIn("window slide", slide)
+	}
+	if err != nil {
+		c.fail(err)
+		return &DStream{ctx: c, compute: func(*batch) (*rdd.KeyedRDD, error) { return nil, err }}
+	}
+	w.length = n
+	return c.statefulStream(state, func(b *batch) (*rdd.KeyedRDD, error) {
+		records, err := d.collect(b)
+		if err != nil {
+			return nil, err
+		}
+		if err := onBatch(records, w.add(records)); err != nil {
+			return nil, err
+		}
+		if b.seq%int64(every) != 0 {
+			return nil, nil
+		}
+		out, err := result()
+		if err != nil {
+			return nil, err
+		}
+		return c.sc.Parallelize(out, 0), nil
+	})
+}
+
+// Window returns a stream whose RDD holds the elements of the last length of batches.
+// It produces an RDD every slide, and length and slide must be multiples of the batch
+// interval; a zero slide slides every batch.
+func (d *DStream) Window(length, slide time.Duration) *DStream {
+	w := &window{}
+	return d.windowed(length, slide, w, w, func(_, _ []interface{}) error { return nil }, func() ([]interface{}, error) {
+		return w.records(), nil
+	})
+}
+
+// windowReduce keeps the values of every key reduced per batch, and with an inverse
+// function the running value of the whole window
+type windowReduce struct {
+	*window
+	reduce, inverse func(a, b interface{}) (interface{}, error)
+	// values and counts hold, for every key in the window, its value and the number of
+	// batches in which it appears; they are only kept with an inverse function
+	values map[interface{}]interface{}
+	counts map[interface{}]int
+}
+
+func (w *windowReduce) update(added, expired []interface{}) error {
+	if w.inverse == nil {
+		return nil
+	}
+	for _, record := range added {
+		p := record.(Pair)
+		if old, ok := w.values[p.Key]; ok {
+			v, err := w.reduce(old, p.Value)
+			if err != nil {
+				return err
+			}
+			w.values[p.Key] = v
+		} else {
+			w.values[p.Key] = p.Value
+		}
+		w.counts[p.Key]++
+	}
+	for _, record := range expired {
+		p := record.(Pair)
+		w.counts[p.Key]--
+		if w.counts[p.Key] == 0 {
+			delete(w.values, p.Key)
+			delete(w.counts, p.Key)
+			continue
+		}
+		v, err := w.inverse(w.values[p.Key], p.Value)
+		if err != nil {
+			return err
+		}
+		w.values[p.Key] = v
+	}
+	return nil
+}
+
+func (w *windowReduce) result() ([]interface{}, error) {
+	if w.inverse != nil {
+		out := make([]interface{}, 0, len(w.values))
+		for k, v := range w.values {
+			out = append(out, Pair{Key: k, Value: v})
+		}
+		return out, nil
+	}
+	values := make(map[interface{}]interface{})
+	var keys []interface{}
+	for _, records := range w.batches {
+		for _, record := range records {
+			p := record.(Pair)
+			old, ok := values[p.Key]
+			if !ok {
+				values[p.Key] = p.Value
+				keys = append(keys, p.Key)
+				continue
+			}
+			v, err := w.reduce(old, p.Value)
+			if err != nil {
+				return nil, err
+			}
+			values[p.Key] = v
+		}
+	}
+	out := make([]interface{}, len(keys))
+	for i, k := range keys {
+		out[i] = Pair{Key: k, Value: values[k]}
+	}
+	return out, nil
+}
+
+func (w *windowReduce) restore(records []interface{}) error {
+	if err := w.window.restore(nil); err != nil {
+		return err
+	}
+	w.values = make(map[interface{}]interface{})
+	w.counts = make(map[interface{}]int)
+	for _, r := range records {
+		batch, ok := r.([]interface{})
+		if !ok {
+			return fmt.Errorf("malformed window batch %v", r)
+		}
+		for _, record := range batch {
+			if _, err := pairOf(record); err != nil {
+				return err
+			}
+		}
+		if err := w.update(batch, w.window.add(batch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReduceByKeyAndWindow reduces the values of every key of a stream of Pairs over the
+// last length of batches, producing a Pair of each key and its value every slide.
+// The values of every batch are first reduced per key on the cluster. Without an
+// inverse function the window is then reduced from scratch every slide; with one,
+// the value of each key is updated incrementally: batches entering the window are
+// added with reduce and batches leaving it are taken out with inverse, so that
+// inverse(reduce(a, b), b) == a. Keys that leave the window are dropped.
+func (d *DStream) ReduceByKeyAndWindow(reduce, inverse func(a, b interface{}) (interface{}, error), length, slide time.Duration) *DStream {
+	reduced := d.WithKey(pairKey).ReduceByKey(func(group []interface{}) ([]interface{}, error) {
+		acc := group[0].(Pair)
+		for _, record := range group[1:] {
+			v, err := reduce(acc.Value, record.(Pair).Value)
+			if err != nil {
+				return nil, err
+			}
+			acc.Value = v
+		}
+		return []interface{}{acc}, nil
+	})
+	w := &windowReduce{
+		window:  &window{},
+		reduce:  reduce,
+		inverse: inverse,
+		values:  make(map[interface{}]interface{}),
+		counts:  make(map[interface{}]int),
+	}
+	return reduced.windowed(length, slide, w, w.window, w.update, w.result)
+}
@@ -1,10 +1,27 @@
 package types
 
-// KeyedRDD represents a Resilient Distributed Dataset with key-based operations
+import (
+	"context"
+	"encoding/json"
+
+	lazy "github.com/bajor/spark-go-core/lazy_evaluation"
+)
+
+// KeyedRDD represents a Resilient Distributed Dataset with key-based operations.
+// Its input is either Data or, when set, Source.
 type KeyedRDD struct {
-	Data  []interface{}
-	Chain *OperationChain
-	Key   func(i interface{}) (interface{}, error)
+	Data       []interface{}
+	Source     Source
+	Chain      *OperationChain
+	Key        func(i interface{}) (interface{}, error)
+	Partitions int
+}
+
+// Source produces the input partitions of an RDD lazily, e.g. by reading byte ranges
+// of files, so no more than one partition per task is held in memory
+type Source interface {
+	NumPartitions() int
+	Open(partition int) (lazy.SourceIterator, error)
 }
 
 // OperationChain holds a sequence of operations to be executed lazily
@@ -15,4 +32,37 @@ type OperationChain struct {
 // Operation interface defines the contract for all RDD operations
 type Operation interface {
 	Execute(data []interface{}) ([]interface{}, error)
-} 
\ No newline at end of file
+}
+
+// NarrowOperation marks operations that can run on each partition independently
+type NarrowOperation interface {
+	Operation
+	Narrow()
+}
+
+// ContextOperation is implemented by operations that want the task's context, which
+// carries cancellation and the scheduler's TaskContext
+type ContextOperation interface {
+	Operation
+	ExecuteContext(ctx context.Context, data []interface{}) ([]interface{}, error)
+}
+
+// OperationSpec references a registered operation by name together with its serialized arguments
+type OperationSpec struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// ShippableOperation is implemented by operations that can be sent to remote workers
+type ShippableOperation interface {
+	Operation
+	Spec() OperationSpec
+}
+
+// ShuffleOperation is implemented by shippable operations that need every record of a
+// key in one place. Key is a registered map producing the key of each record and Reduce
+// a registered partition function applied to each group.
+type ShuffleOperation interface {
+	ShippableOperation
+	ShuffleSpecs() (key OperationSpec, reduce OperationSpec)
+}